@@ -0,0 +1,113 @@
+// Package main provides padlock-decode, a decode-only build of padlock for
+// recovery environments such as an initramfs or a rescue USB stick. It is
+// built on pkg/paddecode, which depends on nothing but the standard library,
+// and so builds to a small static binary:
+//
+//	CGO_ENABLED=0 go build -trimpath -ldflags="-s -w" -o padlock-decode ./cmd/padlock-decode
+//
+// It decodes collection directories and zips, restoring the files they hold
+// into an empty output directory, or writing the archive to standard output
+// if the output is "-". Collections that use features it lacks, such as
+// volumes, obfuscated names, encrypted zips or hybrid encodes, are left to
+// the full padlock command.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// Exit codes, the same as padlock's for the failures the two share
+const (
+	exitOK                      = 0 // Success
+	exitFailure                 = 1 // Any failure not covered below
+	exitUsage                   = 2 // Invalid command line
+	exitIO                      = 3 // A file or directory could not be read or written
+	exitInsufficientCollections = 4 // Fewer collections than required were found
+	exitChunkCorrupt            = 5 // Chunks are missing or damaged, or mislabeled
+	exitSessionMismatch         = 6 // Collections come from different encodes
+	exitNotArchive              = 7 // The decoded data is not a valid archive
+	exitOutputConflict          = 8 // The output directory is not empty
+	exitUnsafePath              = 9 // The archive tries to write outside of the output directory
+)
+
+// exitCodes maps errors to exit codes, in order of precedence
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{paddecode.ErrNoCollections, exitInsufficientCollections},
+	{paddecode.ErrInsufficientCollections, exitInsufficientCollections},
+	{paddecode.ErrSessionMismatch, exitSessionMismatch},
+	{paddecode.ErrChunkCorrupt, exitChunkCorrupt},
+	{paddecode.ErrBadLabel, exitChunkCorrupt},
+	{paddecode.ErrUnsafePath, exitUnsafePath},
+	{paddecode.ErrNotArchive, exitNotArchive},
+	{paddecode.ErrOutputNotEmpty, exitOutputConflict},
+}
+
+const usage = `Usage: padlock-decode [-verbose] <inputDir> <outputDir|->
+
+Decodes the padlock collections (directories like 3A5, or zips like 3A5.zip)
+found in inputDir, restoring the files they hold into outputDir, which must be
+empty or not yet exist. With "-", the tar archive they hold is written to
+standard output instead. Collections in .tar.gz files must first be extracted
+with tar.
+`
+
+func main() {
+	verbose := flag.Bool("verbose", false, "Log the progress of the decode in detail")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	inputDir, outputDir := flag.Arg(0), flag.Arg(1)
+
+	level := trace.LogLevelNormal
+	if *verbose {
+		level = trace.LogLevelVerbose
+	}
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("MAIN", level))
+
+	var err error
+	if outputDir == "-" {
+		err = paddecode.WriteArchive(ctx, inputDir, os.Stdout)
+	} else {
+		err = paddecode.Restore(ctx, inputDir, outputDir)
+	}
+	if err != nil {
+		log.Printf("Decode failed: %v", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode returns the exit code for the class of an error
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	for _, e := range exitCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	if errors.As(err, &pathErr) || errors.As(err, &linkErr) {
+		return exitIO
+	}
+	return exitFailure
+}
@@ -0,0 +1,119 @@
+//go:build js && wasm
+
+// Package main provides the WebAssembly build of the padlock decoder, which
+// backs a recovery page that reconstructs files from uploaded collection zips
+// entirely within the browser, so that nothing leaves the computer it runs on.
+//
+// It is built with:
+//
+//	GOOS=js GOARCH=wasm go build -o padlock.wasm ./cmd/padlock-wasm
+//	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" .
+//
+// and served, with index.html, from any static web server. Once loaded, it
+// sets the global object padlock, whose decode function takes an array of
+// uploaded zips, each {name, data} with data a Uint8Array, and options
+// {password, strict}. It returns a promise of {files, zip, log}: the restored
+// files, each {name, data, mode, modified}, a zip holding all of them, and
+// the log of the decode.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"syscall/js"
+
+	"github.com/rayozzie/padlock/pkg/padlock"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func main() {
+	js.Global().Set("padlock", js.ValueOf(map[string]any{
+		"decode": js.FuncOf(decode),
+	}))
+
+	// The functions must remain callable for as long as the page is open
+	select {}
+}
+
+// decode is padlock.decode, which runs the decode in the background as it
+// would otherwise block the browser's event loop
+func decode(this js.Value, args []js.Value) any {
+	var handler js.Func
+	handler = js.FuncOf(func(this js.Value, promise []js.Value) any {
+		resolve, reject := promise[0], promise[1]
+		go func() {
+			defer handler.Release()
+			result, err := runDecode(args)
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(result)
+		}()
+		return nil
+	})
+	return js.Global().Get("Promise").New(handler)
+}
+
+// runDecode decodes the zips given to padlock.decode, returning its result
+func runDecode(args []js.Value) (any, error) {
+	if len(args) < 1 || args[0].Type() != js.TypeObject {
+		return nil, fmt.Errorf("expected an array of zips")
+	}
+	cfg := padlock.MemoryDecodeConfig{Compression: padlock.CompressionGzip}
+	uploads := args[0]
+	for i := 0; i < uploads.Length(); i++ {
+		upload := uploads.Index(i)
+		data := upload.Get("data")
+		if data.Type() != js.TypeObject || !data.InstanceOf(js.Global().Get("Uint8Array")) {
+			return nil, fmt.Errorf("zip %d: data must be a Uint8Array", i)
+		}
+		z := padlock.MemoryFile{Name: upload.Get("name").String(), Data: make([]byte, data.Length())}
+		js.CopyBytesToGo(z.Data, data)
+		cfg.Zips = append(cfg.Zips, z)
+	}
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		if password := args[1].Get("password"); password.Type() == js.TypeString {
+			cfg.Password = password.String()
+		}
+		cfg.Strict = args[1].Get("strict").Truthy()
+	}
+
+	// The log is returned for the page to show, rather than written to the console
+	var logBuf bytes.Buffer
+	tracer := trace.NewTracer("PADLOCK", trace.LogLevelNormal)
+	tracer.SetSinks(trace.TextSink(&logBuf))
+	ctx := trace.WithContext(context.Background(), tracer)
+
+	files, err := padlock.DecodeMemory(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w\n\n%s", err, logBuf.String())
+	}
+	var zipBuf bytes.Buffer
+	if err := padlock.ZipFiles(&zipBuf, files); err != nil {
+		return nil, err
+	}
+
+	jsFiles := make([]any, len(files))
+	for i, f := range files {
+		jsFiles[i] = map[string]any{
+			"name":     f.Name,
+			"data":     toUint8Array(f.Data),
+			"mode":     int(f.Mode),
+			"modified": js.Global().Get("Date").New(f.ModTime.UnixMilli()),
+		}
+	}
+	return js.ValueOf(map[string]any{
+		"files": jsFiles,
+		"zip":   toUint8Array(zipBuf.Bytes()),
+		"log":   logBuf.String(),
+	}), nil
+}
+
+// toUint8Array copies data into a new Uint8Array
+func toUint8Array(data []byte) js.Value {
+	array := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(array, data)
+	return array
+}
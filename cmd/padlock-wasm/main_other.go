@@ -0,0 +1,13 @@
+//go:build !(js && wasm)
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Fprintln(os.Stderr, "padlock-wasm runs in a browser; build it with GOOS=js GOARCH=wasm go build -o padlock.wasm ./cmd/padlock-wasm")
+	os.Exit(1)
+}
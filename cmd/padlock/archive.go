@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+// archiveFlags holds the options choosing how collections are archived
+type archiveFlags struct {
+	zip     *bool
+	archive *string
+}
+
+// addArchiveFlags registers the archive options on a command's flag set
+func addArchiveFlags(fs *flag.FlagSet) *archiveFlags {
+	return &archiveFlags{
+		zip:     fs.Bool("zip", false, "create zip files for each collection instead of directories (same as -archive zip)"),
+		archive: fs.String("archive", "", "archive each collection as zip, tgz (a .tar.gz) or none"),
+	}
+}
+
+// kind returns whether collections are to be zipped or tarred
+func (af *archiveFlags) kind() (zipping bool, tarring bool) {
+	archive := strings.ToLower(*af.archive)
+	if archive == "" {
+		return *af.zip, false
+	}
+	if *af.zip && archive != "zip" {
+		fatalf(exitUsage, "Error: -zip cannot be combined with -archive %s", archive)
+	}
+	switch archive {
+	case "zip":
+		return true, false
+	case "tgz", "tar.gz":
+		return false, true
+	case "none":
+		return false, false
+	}
+	fatalf(exitUsage, "Error: -archive must be 'zip', 'tgz' or 'none', got '%s'", *af.archive)
+	return false, false
+}
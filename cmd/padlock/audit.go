@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/audit"
+	"github.com/rayozzie/padlock/pkg/padlock"
+)
+
+// auditFlags holds the audit log options of the commands that are audited
+type auditFlags struct {
+	enabled *bool
+	path    *string
+}
+
+// addAuditFlags registers the audit log options on a command's flag set
+func addAuditFlags(fs *flag.FlagSet) *auditFlags {
+	return &auditFlags{
+		enabled: fs.Bool("audit", false, "record the operation in "+padlock.AuditLogName+" alongside the output directory"),
+		path:    fs.String("audit-log", "", "record the operation in this audit log `file`"),
+	}
+}
+
+// open opens the audit log the flags ask for, next to outputDir unless a path
+// is given, or returns nil if the operation is not to be audited
+func (af *auditFlags) open(outputDir string) *audit.Log {
+	path := *af.path
+	if path == "" {
+		if !*af.enabled {
+			return nil
+		}
+		if outputDir == "" {
+			fatalf(exitUsage, "Error: -audit needs an output directory to keep the log alongside; use -audit-log PATH")
+		}
+		path = padlock.DefaultAuditLogPath(outputDir)
+	}
+	l, err := audit.Open(path)
+	if err != nil {
+		fatalf(exitCode(err), "Error: -audit-log: %v", err)
+	}
+	return l
+}
+
+// writeAuditSummary writes one line per record of an audit log, followed by
+// the hash of the last record when the whole chain was verified (err is nil)
+func writeAuditSummary(w io.Writer, records []audit.Record, err error) {
+	for _, r := range records {
+		line := fmt.Sprintf("%d  %s  %-6s  %s  %s", r.Seq, r.Time, r.Operation, r.Session, r.Result)
+		if r.Error != "" {
+			first, _, _ := strings.Cut(r.Error, "\n")
+			line += ": " + first
+		}
+		fmt.Fprintln(w, line)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "%d records verified before the chain breaks\n", len(records))
+		return
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(w, "0 records")
+		return
+	}
+	fmt.Fprintf(w, "%d records, chain intact; last hash %s\n", len(records), records[len(records)-1].Hash)
+}
+
+// writeCeremonySummary writes the custodians of a ceremony record whose
+// signature was verified, followed by the fingerprint of the key that signed it
+func writeCeremonySummary(w io.Writer, record *padlock.CeremonyRecord, fingerprint string) {
+	fmt.Fprintf(w, "Ceremony %s to %s: %d of %d collections required\n", record.Started, record.Finished, record.Required, record.Copies)
+	for _, c := range record.Custodians {
+		fmt.Fprintf(w, "%-16s %-12s %s  %s  confirmed %s\n", c.Name, strings.Join(c.Collections, ","), c.Code, c.SHA256, c.Confirmed)
+	}
+	fmt.Fprintf(w, "Signature valid; signed by key %s, which must match the fingerprint noted at the ceremony\n", fingerprint)
+}
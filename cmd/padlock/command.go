@@ -0,0 +1,224 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+)
+
+// command is one of padlock's commands. Its flags are registered by setup, so
+// that they can be listed for help, shell completion and the man page without
+// running the command.
+type command struct {
+	name    string
+	summary string     // One line describing the command
+	args    []argument // Positional arguments, which precede the flags
+
+	// setup registers the command's flags on fs and returns the function that
+	// runs the command with its positional arguments, once the flags are parsed
+	setup func(fs *flag.FlagSet) func(args []string)
+}
+
+// argument is a positional argument of a command
+type argument struct {
+	name     string
+	optional bool
+	repeated bool     // The argument may be given any number of times
+	values   []string // The values the argument may take, if not a path
+	file     bool     // The argument is a file rather than a directory
+}
+
+// commands are padlock's commands, in the order they are listed in
+var commands []*command
+
+func init() {
+	inputDir := argument{name: "inputDir"}
+	outputDir := argument{name: "outputDir"}
+	commands = []*command{
+		{name: "encode", summary: "Split input data into N collections with K-of-N threshold security",
+			args: []argument{{name: "inputDir", repeated: true, file: true}, {name: "outputDir", optional: true}}, setup: setupEncode("encode")},
+		{name: "ceremony", summary: "Generate shares in front of their custodians, each confirming receipt, with a signed record",
+			args: []argument{{name: "inputDir", repeated: true, file: true}, {name: "outputDir", optional: true}}, setup: setupEncode("ceremony")},
+		{name: "pads", summary: "Generate the random pads of an encode, for encode -pads to apply on another machine",
+			args: []argument{outputDir}, setup: setupPads},
+		{name: "decode", summary: "Reconstruct original data from K or more collections",
+			args: []argument{inputDir, {name: "outputDir", optional: true}}, setup: setupDecode},
+		{name: "ls", summary: "List the files held by K or more collections without restoring them",
+			args: []argument{inputDir}, setup: setupList},
+		{name: "verify-restore", summary: "Check restored files against the hashes recorded in K or more collections",
+			args: []argument{inputDir, outputDir}, setup: setupVerifyRestore},
+		{name: "mount", summary: "Mount the files held by K or more collections as a read-only filesystem (FUSE)",
+			args: []argument{inputDir, {name: "mountpoint"}}, setup: setupMount},
+		{name: "diagnose", summary: "Check supplied collections and explain what prevents them from decoding",
+			args: []argument{inputDir}, setup: setupDiagnose},
+		{name: "checkcode", summary: "Check a collection against the verification code issued with it, without the others",
+			args: []argument{{name: "collection", file: true}}, setup: setupCheckCode},
+		{name: "info", summary: "Search directories and attached drives for collections and list them by encode",
+			args: []argument{{name: "dir", optional: true, repeated: true}}, setup: setupInfo},
+		{name: "manifest", summary: "Export a JSON inventory of a collection set: its session, K/N, custodians and chunk hashes",
+			args: []argument{inputDir}, setup: setupManifest},
+		{name: "recover", summary: "Search attached drives for collections and guide the user through restoring them",
+			args: []argument{{name: "outputDir", optional: true}}, setup: setupRecover},
+		{name: "reshare", summary: "Re-encode K or more collections into a fresh set with new randomness",
+			args: []argument{inputDir, {name: "outputDir", optional: true}}, setup: setupReshare},
+		{name: "refresh", summary: "Issue new shares of the same data from all N collections, invalidating leaked ones",
+			args: []argument{inputDir, outputDir}, setup: setupRefresh},
+		{name: "convert", summary: "Rewrap the chunks of collections in another format or packaging, without decoding",
+			args: []argument{inputDir, outputDir}, setup: setupConvert},
+		{name: "repair", summary: "Regenerate the missing or damaged chunks of a collection from the others of its set",
+			args: []argument{inputDir, outputDir}, setup: setupRepair},
+		{name: "split-secret", summary: "Split a short secret, such as a master password, into N compact share files",
+			args: []argument{outputDir}, setup: setupSplitSecret},
+		{name: "join-secret", summary: "Join a secret from K or more of the share files split-secret wrote",
+			args: []argument{{name: "share", repeated: true, file: true}}, setup: setupJoinSecret},
+		{name: "serve", summary: "Run a local HTTP service exposing encode, decode and verify",
+			setup: setupServe},
+		{name: "watch", summary: "Encode a new dated collection set each time the input directory changes",
+			args: []argument{inputDir, outputDir}, setup: setupEncode("watch")},
+		{name: "audit", summary: "Verify that an audit log or ceremony record is intact and list what it records",
+			args: []argument{{name: "logFile", file: true}}, setup: setupAudit},
+		{name: "tui", summary: "Encode, restore or recover through menus, with size estimates and progress",
+			setup: setupTUI},
+		{name: "presets", summary: "List the presets that -preset chooses, built in and from the config file",
+			args: []argument{{name: "action", values: []string{"list"}}}, setup: setupPresets},
+		{name: "scheme", summary: "Print the XOR groups and chunk layout of a K-of-N set, for audits and reimplementations",
+			setup: setupScheme},
+		{name: "selftest", summary: "Check this binary against the embedded test vectors of the padlock format",
+			setup: setupSelftest},
+		{name: "completion", summary: "Print a shell completion script for bash, zsh, fish or powershell",
+			args: []argument{{name: "shell", values: completionShells}}, setup: setupCompletion},
+		{name: "docs", summary: "Print the manual page, generated from the commands and their flags",
+			args: []argument{{name: "format", values: []string{"man"}}}, setup: setupDocs},
+	}
+}
+
+// findCommand returns the command of the given name, or nil
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// flagSet returns the command's flag set and the function that runs it
+func (c *command) flagSet() (*flag.FlagSet, func(args []string)) {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	run := c.setup(fs)
+	return fs, run
+}
+
+// flags returns the command's flags, sorted by name
+func (c *command) flags() []*flag.Flag {
+	fs, _ := c.flagSet()
+	var flags []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, f)
+	})
+	return flags
+}
+
+// synopsis returns the command's positional arguments as shown in usage, e.g.
+// "<inputDir> [<outputDir>]"
+func (c *command) synopsis() string {
+	var parts []string
+	for _, a := range c.args {
+		part := "<" + a.name + ">"
+		if a.repeated {
+			part += "..."
+		}
+		if a.optional {
+			part = "[" + part + "]"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+// run parses a command line, the arguments following the command's name, and
+// runs the command, showing the usage if any required arguments are missing
+func (c *command) run(cmdline []string) {
+	args, flags, ok := c.splitArgs(cmdline)
+	if !ok {
+		usage()
+	}
+	fs, run := c.flagSet()
+	fs.Parse(flags)
+
+	// Options that weren't given take their defaults from the environment and
+	// the config file
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		cfg, err := loadConfig()
+		if err != nil {
+			fatalf(exitUsage, "Error: cannot read config: %v", err)
+		}
+		if err := applyDefaults(fs, c.name, cfg, os.LookupEnv); err != nil {
+			fatalf(exitUsage, "Error: %v", err)
+		}
+	}
+	run(args)
+}
+
+// splitArgs splits a command line into the positional arguments, which are
+// taken up to the first flag, and the flags, reporting whether every required
+// argument was given. A command with a repeated argument takes every
+// positional argument, leaving the command to tell them apart.
+func (c *command) splitArgs(cmdline []string) (args []string, flags []string, ok bool) {
+	for len(cmdline) > 0 && (!strings.HasPrefix(cmdline[0], "-") || cmdline[0] == "-") && (len(args) < len(c.args) || c.repeats()) {
+		args = append(args, cmdline[0])
+		cmdline = cmdline[1:]
+	}
+	for i, a := range c.args {
+		if i >= len(args) && !a.optional {
+			return args, cmdline, false
+		}
+	}
+	return args, cmdline, true
+}
+
+// repeats reports whether any of the command's arguments may be repeated
+func (c *command) repeats() bool {
+	for _, a := range c.args {
+		if a.repeated {
+			return true
+		}
+	}
+	return false
+}
+
+// arg returns a positional argument, or "" if it was not given
+func arg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// commonFlags returns the names of the flags that every command with flags
+// accepts, such as those for logging, which are documented once
+func commonFlags() map[string]bool {
+	var common map[string]bool
+	for _, c := range commands {
+		flags := c.flags()
+		if len(flags) == 0 {
+			continue
+		}
+		names := make(map[string]bool, len(flags))
+		for _, f := range flags {
+			if common == nil || common[f.Name] {
+				names[f.Name] = true
+			}
+		}
+		common = names
+	}
+	return common
+}
+
+// isBoolFlag reports whether a flag takes no value
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		command   string
+		cmdline   []string
+		wantArgs  []string
+		wantFlags []string
+		wantOK    bool
+	}{
+		{"Both directories", "encode", []string{"in", "out", "-copies", "3"}, []string{"in", "out"}, []string{"-copies", "3"}, true},
+		{"Several inputs", "encode", []string{"a", "b.txt", "out", "-copies", "3"}, []string{"a", "b.txt", "out"}, []string{"-copies", "3"}, true},
+		{"Optional omitted", "decode", []string{"in", "-stdout"}, []string{"in"}, []string{"-stdout"}, true},
+		{"Required missing", "mount", []string{"in", "-verbose"}, []string{"in"}, []string{"-verbose"}, false},
+		{"Nothing given", "ls", nil, nil, nil, false},
+		{"Extra argument left to flags", "ls", []string{"in", "extra"}, []string{"in"}, []string{"extra"}, true},
+		{"Repeated", "info", []string{"a", "b", "c", "-verbose"}, []string{"a", "b", "c"}, []string{"-verbose"}, true},
+		{"Standard input", "join-secret", []string{"a.txt", "-", "-out", "s"}, []string{"a.txt", "-"}, []string{"-out", "s"}, true},
+		{"Repeated none", "info", []string{"-scan", "/media"}, nil, []string{"-scan", "/media"}, true},
+		{"No arguments", "serve", []string{"-listen", ":0"}, nil, []string{"-listen", ":0"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, flags, ok := findCommand(tt.command).splitArgs(tt.cmdline)
+			if !reflect.DeepEqual(args, tt.wantArgs) || !reflect.DeepEqual(flags, tt.wantFlags) || ok != tt.wantOK {
+				t.Errorf("splitArgs(%q) = %q, %q, %v, want %q, %q, %v", tt.cmdline, args, flags, ok, tt.wantArgs, tt.wantFlags, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	t.Run("Unique names", func(t *testing.T) {
+		seen := make(map[string]bool)
+		for _, c := range commands {
+			if seen[c.name] {
+				t.Errorf("command %q is defined twice", c.name)
+			}
+			seen[c.name] = true
+			if findCommand(c.name) != c {
+				t.Errorf("findCommand(%q) did not find it", c.name)
+			}
+		}
+		if findCommand("nonexistent") != nil {
+			t.Errorf("findCommand found a command that doesn't exist")
+		}
+	})
+
+	t.Run("Synopsis", func(t *testing.T) {
+		for name, want := range map[string]string{
+			"encode": "<inputDir>... [<outputDir>]",
+			"mount":  "<inputDir> <mountpoint>",
+			"info":   "[<dir>...]",
+			"serve":  "",
+		} {
+			if got := findCommand(name).synopsis(); got != want {
+				t.Errorf("%s synopsis = %q, want %q", name, got, want)
+			}
+		}
+	})
+
+	t.Run("Entropy flags", func(t *testing.T) {
+		// Every command that draws pads mixes in the -entropy sources
+		for _, name := range []string{"encode", "ceremony", "pads", "reshare", "refresh", "split-secret", "serve", "tui"} {
+			flags := make(map[string]bool)
+			for _, f := range findCommand(name).flags() {
+				flags[f.Name] = true
+			}
+			for _, flag := range []string{"entropy", "entropy-policy", "entropy-pin"} {
+				if !flags[flag] {
+					t.Errorf("%s does not take -%s", name, flag)
+				}
+			}
+		}
+	})
+
+	t.Run("Common flags", func(t *testing.T) {
+		common := commonFlags()
+		for _, name := range []string{"log-file", "log-format", "log-level", "log-max-size", "log-stats", "log-spans", "no-swap", "low-memory", "offline"} {
+			if !common[name] {
+				t.Errorf("flag -%s is not common to every command", name)
+			}
+		}
+		if common["copies"] {
+			t.Errorf("flag -copies is reported as common to every command")
+		}
+	})
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// completionShells are the shells that padlock can print a completion script for
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// flagValues are the values of the flags that take one of a fixed set, or a
+// list drawn from one, offered when completing the flag's value
+var flagValues = map[string][]string{
+	"format":      {"bin", "png", "packed"},
+	"archive":     {"zip", "tgz", "none"},
+	"chunk-names": {"camera", "phone", "scan"},
+	"on-conflict": {"overwrite", "skip", "rename", "error"},
+	"log-format":  {"text", "json"},
+	"preserve":    {"symlinks", "owner", "hardlinks", "sparse", "xattrs", "special", "all", "none"},
+	"restore":     {"symlinks", "perms", "mtime", "owner", "xattrs", "special", "all", "none"},
+	"volume":      {"cd", "dvd", "dvd-dl", "bd"},
+	"preset":      {"personal", "enterprise", "archival"},
+}
+
+// completion is what the value of a flag or a positional argument completes to
+type completion int
+
+const (
+	completeNothing completion = iota // Free text, or a flag without a value
+	completeValues                    // One of a fixed set of values
+	completeFiles                     // A file
+	completeDirs                      // A directory, or a list of them
+)
+
+// flagCompletion returns what the value of a flag completes to, going by
+// flagValues or else by the name of its value in its usage (e.g. `file`)
+func flagCompletion(f *flag.Flag) (completion, []string) {
+	if isBoolFlag(f) {
+		return completeNothing, nil
+	}
+	if values, ok := flagValues[f.Name]; ok {
+		return completeValues, values
+	}
+	switch name, _ := flag.UnquoteUsage(f); name {
+	case "file", "files":
+		return completeFiles, nil
+	case "directory", "directories":
+		return completeDirs, nil
+	}
+	return completeNothing, nil
+}
+
+// completion returns what a positional argument completes to
+func (a argument) completion() (completion, []string) {
+	switch {
+	case len(a.values) > 0:
+		return completeValues, a.values
+	case a.file:
+		return completeFiles, nil
+	}
+	return completeDirs, nil
+}
+
+// setupCompletion implements "padlock completion <shell>", printing a script
+// that completes padlock's commands, flags and their values in that shell
+func setupCompletion(fs *flag.FlagSet) func(args []string) {
+	return func(args []string) {
+		switch args[0] {
+		case "bash":
+			writeBashCompletion(os.Stdout)
+		case "zsh":
+			writeZshCompletion(os.Stdout)
+		case "fish":
+			writeFishCompletion(os.Stdout)
+		case "powershell":
+			writePowerShellCompletion(os.Stdout)
+		default:
+			fatalf(exitUsage, "Error: unknown shell %q, expected one of %s", args[0], strings.Join(completionShells, ", "))
+		}
+	}
+}
+
+// flagNames returns the names of flags, each with its leading "-"
+func flagNames(flags []*flag.Flag) []string {
+	names := make([]string, len(flags))
+	for i, f := range flags {
+		names[i] = "-" + f.Name
+	}
+	return names
+}
+
+// commandNames returns the names of all commands
+func commandNames() []string {
+	names := make([]string, len(commands))
+	for i, c := range commands {
+		names[i] = c.name
+	}
+	return names
+}
+
+// bashCompgen returns the compgen command completing $cur to the given kind
+func bashCompgen(kind completion, values []string) string {
+	switch kind {
+	case completeValues:
+		return fmt.Sprintf(`COMPREPLY=($(compgen -W "%s" -- "$cur"))`, strings.Join(values, " "))
+	case completeFiles:
+		return `COMPREPLY=($(compgen -f -- "$cur"))`
+	case completeDirs:
+		return `COMPREPLY=($(compgen -d -- "$cur"))`
+	}
+	return "COMPREPLY=()"
+}
+
+// writeBashCompletion writes the completion script for bash
+func writeBashCompletion(w io.Writer) {
+	fmt.Fprintf(w, `# bash completion for padlock, generated by "padlock completion bash"
+# Load it with: source <(padlock completion bash)
+
+# _padlock_position prints the index of the positional argument being completed,
+# or "flags" once the flags, which follow the positional arguments, have begun
+_padlock_position() {
+    local i
+    if [[ $cur == -* ]]; then
+        echo flags
+        return
+    fi
+    for ((i = 2; i < COMP_CWORD; i++)); do
+        if [[ ${COMP_WORDS[i]} == -* ]]; then
+            echo flags
+            return
+        fi
+    done
+    echo $((COMP_CWORD - 2))
+}
+
+_padlock() {
+    local cur prev
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    if [[ $COMP_CWORD -eq 1 ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        return
+    fi
+
+    case "${COMP_WORDS[1]}" in
+`, strings.Join(commandNames(), " "))
+
+	for _, c := range commands {
+		flags := c.flags()
+		fmt.Fprintf(w, "    %s)\n", c.name)
+
+		// The value of a flag
+		fmt.Fprintf(w, "        case \"$prev\" in\n")
+		for _, f := range flags {
+			if isBoolFlag(f) {
+				continue
+			}
+			kind, values := flagCompletion(f)
+			fmt.Fprintf(w, "        -%s)\n            %s\n            return ;;\n", f.Name, bashCompgen(kind, values))
+		}
+		fmt.Fprintf(w, "        esac\n")
+
+		// A positional argument, or else a flag
+		fmt.Fprintf(w, "        case \"$(_padlock_position)\" in\n")
+		fmt.Fprintf(w, "        flags) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", strings.Join(flagNames(flags), " "))
+		for i, a := range c.args {
+			kind, values := a.completion()
+			pattern := fmt.Sprint(i)
+			if a.repeated {
+				pattern = "*"
+			}
+			fmt.Fprintf(w, "        %s) %s ;;\n", pattern, bashCompgen(kind, values))
+
+			// A repeated argument completes every later position too
+			if a.repeated {
+				break
+			}
+		}
+		if !c.repeats() {
+			fmt.Fprintf(w, "        *) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", strings.Join(flagNames(flags), " "))
+		}
+		fmt.Fprintf(w, "        esac\n        ;;\n")
+	}
+
+	fmt.Fprintf(w, `    esac
+}
+
+complete -o filenames -F _padlock padlock
+`)
+}
+
+// zshQuote quotes s for zsh within single quotes
+func zshQuote(s string) string {
+	return strings.ReplaceAll(s, "'", `'\''`)
+}
+
+// zshAction returns the _arguments action completing to the given kind
+func zshAction(kind completion, values []string) string {
+	switch kind {
+	case completeValues:
+		return "(" + strings.Join(values, " ") + ")"
+	case completeFiles:
+		return "_files"
+	case completeDirs:
+		return "_files -/"
+	}
+	return " "
+}
+
+// writeZshCompletion writes the completion script for zsh
+func writeZshCompletion(w io.Writer) {
+	fmt.Fprintf(w, `#compdef padlock
+# zsh completion for padlock, generated by "padlock completion zsh"
+# Install it as _padlock in a directory on $fpath, or load it with:
+#   source <(padlock completion zsh); compdef _padlock padlock
+
+_padlock() {
+    local -a commands
+    commands=(
+`)
+	for _, c := range commands {
+		fmt.Fprintf(w, "        '%s:%s'\n", c.name, zshQuote(c.summary))
+	}
+	fmt.Fprintf(w, `    )
+    if (( CURRENT == 2 )); then
+        _describe -t commands 'padlock command' commands
+        return
+    fi
+
+    local cmd=$words[2]
+    shift words
+    (( CURRENT-- ))
+    case $cmd in
+`)
+
+	// The descriptions of flags are within brackets, and their values and the
+	// positional arguments are named between colons
+	bracket := strings.NewReplacer("[", `\[`, "]", `\]`)
+	for _, c := range commands {
+		fmt.Fprintf(w, "    %s)\n        _arguments", c.name)
+		for _, f := range c.flags() {
+			_, usage := flag.UnquoteUsage(f)
+			spec := fmt.Sprintf("-%s[%s]", f.Name, bracket.Replace(usage))
+			if !isBoolFlag(f) {
+				name, _ := flag.UnquoteUsage(f)
+				kind, values := flagCompletion(f)
+				spec += fmt.Sprintf(":%s:%s", name, zshAction(kind, values))
+			}
+			fmt.Fprintf(w, " \\\n            '%s'", zshQuote(spec))
+		}
+		for i, a := range c.args {
+			position := fmt.Sprint(i + 1)
+			if a.repeated {
+				position = "*"
+			}
+			if a.optional && !a.repeated {
+				position += ":"
+			}
+			kind, values := a.completion()
+			fmt.Fprintf(w, " \\\n            '%s:%s:%s'", position, a.name, zshQuote(zshAction(kind, values)))
+		}
+		fmt.Fprintf(w, "\n        ;;\n")
+	}
+	fmt.Fprintf(w, `    esac
+}
+
+if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+    _padlock "$@"
+else
+    compdef _padlock padlock
+fi
+`)
+}
+
+// fishQuote quotes s as a single-quoted fish string
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// fishOptions returns the options of complete that offer the given kind
+func fishOptions(kind completion, values []string) string {
+	switch kind {
+	case completeValues:
+		return "-a " + fishQuote(strings.Join(values, " "))
+	case completeFiles:
+		return "-F"
+	case completeDirs:
+		return "-a '(__fish_complete_directories)'"
+	}
+	return ""
+}
+
+// writeFishCompletion writes the completion script for fish
+func writeFishCompletion(w io.Writer) {
+	fmt.Fprintf(w, `# fish completion for padlock, generated by "padlock completion fish"
+# Install it as ~/.config/fish/completions/padlock.fish, or load it with:
+#   padlock completion fish | source
+
+complete -c padlock -f
+`)
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c padlock -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.summary))
+	}
+	for _, c := range commands {
+		fmt.Fprintf(w, "\n")
+		condition := fishQuote("__fish_seen_subcommand_from " + c.name)
+
+		// Positional arguments of the same kind are offered once
+		seen := make(map[string]bool)
+		for _, a := range c.args {
+			options := fishOptions(a.completion())
+			if seen[options] {
+				continue
+			}
+			seen[options] = true
+			fmt.Fprintf(w, "complete -c padlock -n %s %s\n", condition, options)
+		}
+		for _, f := range c.flags() {
+			_, usage := flag.UnquoteUsage(f)
+			line := fmt.Sprintf("complete -c padlock -n %s -o %s -d %s", condition, f.Name, fishQuote(usage))
+			if !isBoolFlag(f) {
+				line = strings.TrimSpace(line + " -r " + fishOptions(flagCompletion(f)))
+			}
+			fmt.Fprintf(w, "%s\n", line)
+		}
+	}
+}
+
+// powerShellQuote quotes s as a single-quoted PowerShell string
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// powerShellList returns a PowerShell array of quoted strings
+func powerShellList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = powerShellQuote(v)
+	}
+	return "@(" + strings.Join(quoted, ", ") + ")"
+}
+
+// writePowerShellCompletion writes the completion script for PowerShell
+func writePowerShellCompletion(w io.Writer) {
+	fmt.Fprintf(w, `# PowerShell completion for padlock, generated by "padlock completion powershell"
+# Load it from your profile with: padlock completion powershell | Out-String | Invoke-Expression
+
+Register-ArgumentCompleter -Native -CommandName padlock -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+
+    $commands = [ordered]@{
+`)
+	for _, c := range commands {
+		fmt.Fprintf(w, "        %s = %s\n", powerShellQuote(c.name), powerShellQuote(c.summary))
+	}
+	fmt.Fprintf(w, "    }\n    $flags = @{\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "        %s = %s\n", powerShellQuote(c.name), powerShellList(flagNames(c.flags())))
+	}
+
+	// Values are keyed by command and flag, or by command alone for the
+	// positional arguments; paths are left to PowerShell's own completion
+	fmt.Fprintf(w, "    }\n    $values = @{\n")
+	for _, c := range commands {
+		for _, f := range c.flags() {
+			if kind, values := flagCompletion(f); kind == completeValues {
+				fmt.Fprintf(w, "        %s = %s\n", powerShellQuote(c.name+" -"+f.Name), powerShellList(values))
+			}
+		}
+		for _, a := range c.args {
+			if kind, values := a.completion(); kind == completeValues {
+				fmt.Fprintf(w, "        %s = %s\n", powerShellQuote(c.name), powerShellList(values))
+			}
+		}
+	}
+	fmt.Fprintf(w, `    }
+
+    $before = @($commandAst.CommandElements | Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })
+    if ($before.Count -le 1) {
+        $commands.Keys | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+            [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $commands[$_])
+        }
+        return
+    }
+
+    $command = $before[1]
+    $prev = $before[-1]
+    if ($values.ContainsKey("$command $prev")) {
+        $candidates = $values["$command $prev"]
+    } elseif ($wordToComplete -like '-*') {
+        $candidates = $flags[$command]
+    } elseif ($values.ContainsKey($command) -and $before.Count -eq 2) {
+        $candidates = $values[$command]
+    } else {
+        return
+    }
+    $candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`)
+}
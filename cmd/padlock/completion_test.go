@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompletion(t *testing.T) {
+	writers := map[string]func(io.Writer){
+		"bash":       writeBashCompletion,
+		"zsh":        writeZshCompletion,
+		"fish":       writeFishCompletion,
+		"powershell": writePowerShellCompletion,
+	}
+	if len(writers) != len(completionShells) {
+		t.Fatalf("%d shells are tested, but %d are supported", len(writers), len(completionShells))
+	}
+
+	for _, shell := range completionShells {
+		t.Run(shell, func(t *testing.T) {
+			var buf bytes.Buffer
+			writers[shell](&buf)
+			script := buf.String()
+
+			// Every command and every one of its flags must be completed
+			for _, c := range commands {
+				if !strings.Contains(script, c.name) {
+					t.Errorf("command %s is missing", c.name)
+				}
+				for _, f := range c.flags() {
+					if !strings.Contains(script, f.Name) {
+						t.Errorf("flag -%s of %s is missing", f.Name, c.name)
+					}
+				}
+			}
+			for _, values := range flagValues {
+				for _, v := range values {
+					if !strings.Contains(script, v) {
+						t.Errorf("value %s is missing", v)
+					}
+				}
+			}
+		})
+	}
+
+	// Check the syntax of the scripts for the shells that are installed
+	tempDir, err := os.MkdirTemp("", "padlock-completion-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		t.Run(shell+" syntax", func(t *testing.T) {
+			path, err := exec.LookPath(shell)
+			if err != nil {
+				t.Skipf("%s is not installed", shell)
+			}
+			var buf bytes.Buffer
+			writers[shell](&buf)
+			scriptPath := filepath.Join(tempDir, "padlock."+shell)
+			if err := os.WriteFile(scriptPath, buf.Bytes(), 0644); err != nil {
+				t.Fatalf("Failed to write script: %v", err)
+			}
+			if out, err := exec.Command(path, "-n", scriptPath).CombinedOutput(); err != nil {
+				t.Errorf("%s -n failed: %v\n%s", shell, err, out)
+			}
+		})
+	}
+}
+
+func TestFlagCompletion(t *testing.T) {
+	tests := []struct {
+		command string
+		flag    string
+		want    completion
+	}{
+		{"encode", "format", completeValues},
+		{"encode", "store", completeDirs},
+		{"encode", "target", completeDirs},
+		{"encode", "custodians-file", completeFiles},
+		{"decode", "zip-passwords", completeFiles},
+		{"decode", "on-conflict", completeValues},
+		{"info", "scan", completeDirs},
+		{"encode", "copies", completeNothing},
+		{"encode", "clear", completeNothing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command+" -"+tt.flag, func(t *testing.T) {
+			for _, f := range findCommand(tt.command).flags() {
+				if f.Name == tt.flag {
+					if got, _ := flagCompletion(f); got != tt.want {
+						t.Errorf("flagCompletion(-%s) = %d, want %d", tt.flag, got, tt.want)
+					}
+					return
+				}
+			}
+			t.Errorf("%s has no flag -%s", tt.command, tt.flag)
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Options that are not given on the command line take their values from, in
+// order of precedence:
+//
+//  1. The preset chosen with -preset
+//  2. An environment variable named after the option, e.g. PADLOCK_COPIES for
+//     -copies or PADLOCK_ON_CONFLICT for -on-conflict
+//  3. The option in the config file's table for the command, e.g. [encode]
+//  4. The option at the top of the config file, before any table, which
+//     applies to every command accepting it
+//  5. The option's built-in default
+//
+// The config file is config.toml in the padlock directory of the user's config
+// directory (~/.config/padlock/config.toml on Linux), or the file named by
+// PADLOCK_CONFIG. It is written in a subset of TOML: keys are option names
+// and values are strings, integers, booleans or arrays of strings. Tables
+// named preset.NAME define presets, chosen with -preset.
+
+// configEnv is the environment variable naming the config file to use instead
+// of the default
+const configEnv = "PADLOCK_CONFIG"
+
+// envPrefix begins the names of the environment variables giving options
+const envPrefix = "PADLOCK_"
+
+// config holds the option values read from a config file: those at the top,
+// which apply to every command accepting them, those in a table named after a
+// command, which apply to it alone, and those of each preset
+type config struct {
+	path     string
+	global   map[string]string
+	commands map[string]map[string]string
+	presets  map[string]map[string]string
+}
+
+// configPath returns the path of the config file, and whether it was named
+// explicitly, in which case it must exist
+func configPath() (string, bool) {
+	if path := os.Getenv(configEnv); path != "" {
+		return path, true
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(dir, "padlock", "config.toml"), false
+}
+
+// loadConfig reads the config file, if there is one, and checks that it only
+// sets options that the commands accept
+func loadConfig() (*config, error) {
+	path, explicit := configPath()
+	if path == "" {
+		return &config{}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return &config{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, err := parseConfig(path, f)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.check(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseConfig parses a config file in the subset of TOML that it is written in
+func parseConfig(path string, r io.Reader) (*config, error) {
+	cfg := &config{
+		path:     path,
+		global:   make(map[string]string),
+		commands: make(map[string]map[string]string),
+		presets:  make(map[string]map[string]string),
+	}
+	table := cfg.global
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// A table, named after a command or a preset
+		if strings.HasPrefix(line, "[") {
+			name, rest, ok := strings.Cut(line[1:], "]")
+			if !ok || !isComment(rest) {
+				return nil, fmt.Errorf("%s:%d: malformed table header", path, lineNum)
+			}
+			header := strings.TrimSpace(name)
+			tables := cfg.commands
+			if presetName, ok := strings.CutPrefix(header, "preset."); ok {
+				name, tables = unquoteKey(strings.TrimSpace(presetName)), cfg.presets
+			} else {
+				name = unquoteKey(header)
+			}
+			if _, ok := tables[name]; ok {
+				return nil, fmt.Errorf("%s:%d: table [%s] is defined twice", path, lineNum, header)
+			}
+			table = make(map[string]string)
+			tables[name] = table
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNum)
+		}
+		key = unquoteKey(strings.TrimSpace(key))
+		if key == "" {
+			return nil, fmt.Errorf("%s:%d: missing key", path, lineNum)
+		}
+		parsed, err := parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, lineNum, key, err)
+		}
+		if _, ok := table[key]; ok {
+			return nil, fmt.Errorf("%s:%d: %s is set twice", path, lineNum, key)
+		}
+		table[key] = parsed
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// unquoteKey returns a key or table name without the quotes it may be given in
+func unquoteKey(key string) string {
+	if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') && key[len(key)-1] == key[0] {
+		return key[1 : len(key)-1]
+	}
+	return key
+}
+
+// isComment reports whether what follows a value is nothing but a comment
+func isComment(rest string) bool {
+	rest = strings.TrimSpace(rest)
+	return rest == "" || strings.HasPrefix(rest, "#")
+}
+
+// parseConfigValue parses a value, returning it as it would be given on the
+// command line. Arrays become comma-separated lists.
+func parseConfigValue(value string) (string, error) {
+	parsed, rest, err := parseConfigScalar(value)
+	if err == nil && !isComment(rest) {
+		err = fmt.Errorf("unexpected %q after value", strings.TrimSpace(rest))
+	}
+	return parsed, err
+}
+
+// parseConfigScalar parses the value at the start of s, returning it and what
+// follows it
+func parseConfigScalar(s string) (string, string, error) {
+	switch {
+	case s == "":
+		return "", "", fmt.Errorf("missing value")
+
+	case s[0] == '"':
+		// A basic string, whose escapes are those of Go
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return value, s[i+1:], nil
+			}
+		}
+		return "", "", fmt.Errorf("unterminated string")
+
+	case s[0] == '\'':
+		// A literal string, without escapes
+		value, rest, ok := strings.Cut(s[1:], "'")
+		if !ok {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return value, rest, nil
+
+	case s[0] == '[':
+		var items []string
+		rest := strings.TrimSpace(s[1:])
+		for !strings.HasPrefix(rest, "]") {
+			item, after, err := parseConfigScalar(rest)
+			if err != nil {
+				return "", "", err
+			}
+			items = append(items, item)
+			rest = strings.TrimSpace(after)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "]") {
+				return "", "", fmt.Errorf("expected , or ] in array")
+			}
+		}
+		return strings.Join(items, ","), rest[1:], nil
+	}
+
+	// A boolean or an integer, up to the next delimiter
+	end := strings.IndexAny(s, " \t#,]")
+	if end < 0 {
+		end = len(s)
+	}
+	word, rest := s[:end], s[end:]
+	if word == "true" || word == "false" {
+		return word, rest, nil
+	}
+	if n, err := strconv.ParseInt(strings.ReplaceAll(word, "_", ""), 0, 64); err == nil {
+		return strconv.FormatInt(n, 10), rest, nil
+	}
+	return "", "", fmt.Errorf("invalid value %s (strings must be quoted)", word)
+}
+
+// check returns an error if the config sets an option that no command
+// accepts, or that the command of its table doesn't accept, as it is likely
+// misspelled
+func (cfg *config) check() error {
+	accepted := make(map[string]bool)
+	for _, c := range commands {
+		for _, f := range c.flags() {
+			accepted[f.Name] = true
+		}
+	}
+	for key := range cfg.global {
+		if !accepted[key] {
+			return fmt.Errorf("%s: no command accepts the option %s", cfg.path, key)
+		}
+	}
+	for name, table := range cfg.presets {
+		for key := range table {
+			if key != presetDescriptionKey && (!accepted[key] || key == "preset") {
+				return fmt.Errorf("%s: preset %s: no command accepts the option %s", cfg.path, name, key)
+			}
+		}
+	}
+	for name, table := range cfg.commands {
+		c := findCommand(name)
+		if c == nil {
+			return fmt.Errorf("%s: table [%s] does not name a command", cfg.path, name)
+		}
+		fs, _ := c.flagSet()
+		for key := range table {
+			if fs.Lookup(key) == nil {
+				return fmt.Errorf("%s: %s does not accept the option %s", cfg.path, name, key)
+			}
+		}
+	}
+	return nil
+}
+
+// envName returns the name of the environment variable giving an option
+func envName(option string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// applyDefaults sets the options of a command that were not given on its
+// command line from the preset chosen with -preset, the environment and the
+// config, in that order. Empty environment variables are ignored.
+func applyDefaults(fs *flag.FlagSet, command string, cfg *config, lookupEnv func(string) (string, bool)) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	// lookup returns the default of an option from the environment or config,
+	// and where it came from
+	lookup := func(name string) (source string, value string, ok bool) {
+		if value, ok = lookupEnv(envName(name)); ok && value != "" {
+			return envName(name), value, true
+		} else if value, ok = cfg.commands[command][name]; ok {
+			return fmt.Sprintf("%s [%s] %s", cfg.path, command, name), value, true
+		} else if value, ok = cfg.global[name]; ok {
+			return fmt.Sprintf("%s %s", cfg.path, name), value, true
+		}
+		return "", "", false
+	}
+
+	// The preset may itself be chosen by the environment or config
+	var presetName string
+	var presetOptions map[string]string
+	if f := fs.Lookup("preset"); f != nil {
+		presetName = f.Value.String()
+		if _, value, ok := lookup("preset"); ok && !given["preset"] {
+			presetName = value
+		}
+		if presetName != "" {
+			var err error
+			if presetOptions, err = resolvePreset(presetName, cfg); err != nil {
+				return err
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+		source, value, ok := "", "", false
+		if value, ok = presetOptions[f.Name]; ok {
+			source = "preset " + presetName
+		} else {
+			source, value, ok = lookup(f.Name)
+		}
+		if !ok {
+			return
+		}
+		// The value is set without marking the option as given, as commands
+		// that visit the options given treat them as overriding other defaults
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("%s: invalid value %q for -%s: %w", source, value, f.Name, setErr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		text := `# Defaults for every command
+copies = 5
+required = 3  # trailing comment
+clear = false
+format = "bin"
+exclude = [".git", 'node_modules', "*.tmp"]
+
+[encode]
+chunk = 1_048_576
+archive = 'zip'
+chunk-names = "DSC_%04d.PNG"
+
+["decode"]
+on-conflict = "skip"
+log-file = "C:\\logs\\padlock.log"
+`
+		cfg, err := parseConfig("config.toml", strings.NewReader(text))
+		if err != nil {
+			t.Fatalf("parseConfig failed: %v", err)
+		}
+		want := map[string]string{"copies": "5", "required": "3", "clear": "false", "format": "bin", "exclude": ".git,node_modules,*.tmp"}
+		for key, value := range want {
+			if cfg.global[key] != value {
+				t.Errorf("%s = %q, want %q", key, cfg.global[key], value)
+			}
+		}
+		if len(cfg.global) != len(want) {
+			t.Errorf("got %d options at the top, want %d", len(cfg.global), len(want))
+		}
+		if got := cfg.commands["encode"]["chunk"]; got != "1048576" {
+			t.Errorf("[encode] chunk = %q, want 1048576", got)
+		}
+		if got := cfg.commands["encode"]["chunk-names"]; got != "DSC_%04d.PNG" {
+			t.Errorf("[encode] chunk-names = %q", got)
+		}
+		if got := cfg.commands["decode"]["log-file"]; got != `C:\logs\padlock.log` {
+			t.Errorf("[decode] log-file = %q", got)
+		}
+		if err := cfg.check(); err != nil {
+			t.Errorf("check failed: %v", err)
+		}
+	})
+
+	invalid := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"No value", "copies\n", "expected key = value"},
+		{"Unquoted string", "format = bin\n", "strings must be quoted"},
+		{"Unterminated string", "format = \"bin\n", "unterminated string"},
+		{"Trailing text", "format = \"bin\" png\n", "after value"},
+		{"Duplicate key", "copies = 2\ncopies = 3\n", "set twice"},
+		{"Duplicate table", "[encode]\n[encode]\n", "defined twice"},
+		{"Malformed table", "[encode\n", "malformed table header"},
+		{"Unterminated array", "exclude = [\"a\" \"b\"]\n", "expected , or ]"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig("config.toml", strings.NewReader(tt.text))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseConfig error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	unknown := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"Unknown option", "copys = 3\n", "no command accepts the option copys"},
+		{"Unknown command", "[encrypt]\ncopies = 3\n", "does not name a command"},
+		{"Option of another command", "[decode]\ncopies = 3\n", "decode does not accept the option copies"},
+	}
+	for _, tt := range unknown {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseConfig("config.toml", strings.NewReader(tt.text))
+			if err != nil {
+				t.Fatalf("parseConfig failed: %v", err)
+			}
+			if err := cfg.check(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("check error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	cfg, err := parseConfig("config.toml", strings.NewReader(`copies = 3
+required = 3
+format = "bin"
+clear = true
+[encode]
+copies = 4
+`))
+	if err != nil {
+		t.Fatalf("parseConfig failed: %v", err)
+	}
+	env := map[string]string{"PADLOCK_REQUIRED": "2", "PADLOCK_FORMAT": ""}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	fs := flag.NewFlagSet("encode", flag.ContinueOnError)
+	copies := fs.Int("copies", 2, "")
+	required := fs.Int("required", 2, "")
+	format := fs.String("format", "png", "")
+	clear := fs.Bool("clear", false, "")
+	if err := fs.Parse([]string{"-clear=false"}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if err := applyDefaults(fs, "encode", cfg, lookupEnv); err != nil {
+		t.Fatalf("applyDefaults failed: %v", err)
+	}
+
+	if *copies != 4 {
+		t.Errorf("copies = %d, want 4 from the [encode] table over the top of the config", *copies)
+	}
+	if *required != 2 {
+		t.Errorf("required = %d, want 2 from the environment over the config", *required)
+	}
+	if *format != "bin" {
+		t.Errorf("format = %q, want bin from the config as the variable is empty", *format)
+	}
+	if *clear {
+		t.Errorf("clear = true, want false from the command line over the config")
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "clear" {
+			t.Errorf("-%s is reported as given on the command line", f.Name)
+		}
+	})
+
+	t.Run("Invalid value", func(t *testing.T) {
+		fs := flag.NewFlagSet("encode", flag.ContinueOnError)
+		fs.Int("copies", 2, "")
+		env := func(name string) (string, bool) {
+			return "many", name == "PADLOCK_COPIES"
+		}
+		err := applyDefaults(fs, "encode", &config{}, env)
+		if err == nil || !strings.Contains(err.Error(), "PADLOCK_COPIES") {
+			t.Errorf("applyDefaults error = %v, want one naming PADLOCK_COPIES", err)
+		}
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-config-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	t.Run("Named file", func(t *testing.T) {
+		path := filepath.Join(tempDir, "padlock.toml")
+		if err := os.WriteFile(path, []byte("[encode]\ncopies = 5\n"), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		t.Setenv(configEnv, path)
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("loadConfig failed: %v", err)
+		}
+		if cfg.commands["encode"]["copies"] != "5" {
+			t.Errorf("[encode] copies = %q, want 5", cfg.commands["encode"]["copies"])
+		}
+	})
+
+	t.Run("Named file missing", func(t *testing.T) {
+		t.Setenv(configEnv, filepath.Join(tempDir, "missing.toml"))
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("loadConfig succeeded without the file it was given")
+		}
+	})
+
+	t.Run("Default file missing", func(t *testing.T) {
+		t.Setenv(configEnv, "")
+		t.Setenv("XDG_CONFIG_HOME", tempDir)
+		t.Setenv("HOME", tempDir)
+		t.Setenv("AppData", tempDir)
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("loadConfig failed: %v", err)
+		}
+		if len(cfg.global) != 0 || len(cfg.commands) != 0 {
+			t.Errorf("loadConfig returned options without a config file")
+		}
+	})
+}
+
+func TestEnvName(t *testing.T) {
+	for option, want := range map[string]string{
+		"copies":       "PADLOCK_COPIES",
+		"on-conflict":  "PADLOCK_ON_CONFLICT",
+		"log-max-size": "PADLOCK_LOG_MAX_SIZE",
+	} {
+		if got := envName(option); got != want {
+			t.Errorf("envName(%q) = %q, want %q", option, got, want)
+		}
+	}
+}
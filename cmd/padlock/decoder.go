@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/rayozzie/padlock/pkg/file"
+)
+
+// selfDecoder names the running padlock executable in -decoders
+const selfDecoder = "self"
+
+// addDecodersFlag registers the -decoders option on a command's flag set
+func addDecodersFlag(fs *flag.FlagSet) *stringList {
+	var decoders stringList
+	fs.Var(&decoders, "decoders", "padlock executables to embed with instructions in each collection: self for this one, or comma-separated `files` built for other platforms")
+	return &decoders
+}
+
+// loadDecoders reads the executables given with -decoders
+func loadDecoders(ctx context.Context, paths []string) []file.Decoder {
+	var decoders []file.Decoder
+	for _, path := range paths {
+		if path == selfDecoder {
+			self, err := os.Executable()
+			if err != nil {
+				fatalf(exitIO, "Error: -decoders: cannot locate this executable: %v", err)
+			}
+			path = self
+		}
+		d, err := file.LoadDecoder(ctx, path)
+		if err != nil {
+			fatalf(exitUsage, "Error: -decoders: %v", err)
+		}
+		decoders = append(decoders, d)
+	}
+	return decoders
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// manDescription is the introduction of the manual page
+const manDescription = `Padlock splits a directory into N collections using a threshold one-time-pad
+scheme, such that any K of them reconstruct it and fewer than K reveal nothing
+about it. The directory is archived as a tar stream, which is divided into
+chunks that are XORed with random pads and distributed across the collections,
+each written as a directory, a zip or a tar.gz of binary or PNG files.`
+
+// setupDocs implements "padlock docs <format>", printing the documentation
+// generated from the commands and their flags
+func setupDocs(fs *flag.FlagSet) func(args []string) {
+	return func(args []string) {
+		switch args[0] {
+		case "man":
+			writeManPage(os.Stdout)
+		default:
+			fatalf(exitUsage, "Error: unknown documentation format %q, expected man", args[0])
+		}
+	}
+}
+
+// roffEscape escapes text for roff, in which backslashes begin escapes, "-" is
+// a hyphen rather than a minus, and lines beginning with "." or "'" are requests
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// manSynopsis returns a command's positional arguments in roff, in italics
+func manSynopsis(c *command) string {
+	var parts []string
+	for _, a := range c.args {
+		part := `\fI` + a.name + `\fR`
+		if len(a.values) > 0 {
+			part = `\fB` + strings.Join(a.values, `\fR|\fB`) + `\fR`
+		}
+		if a.repeated {
+			part += "..."
+		}
+		if a.optional {
+			part = "[" + part + "]"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeManFlag writes a flag as a tagged paragraph, giving its default unless
+// it is the zero value or the usage already mentions it
+func writeManFlag(w io.Writer, f *flag.Flag) {
+	name, usage := flag.UnquoteUsage(f)
+	fmt.Fprintf(w, ".TP\n\\fB\\-%s\\fR", roffEscape(f.Name))
+	if !isBoolFlag(f) {
+		fmt.Fprintf(w, " \\fI%s\\fR", roffEscape(name))
+	}
+	fmt.Fprintf(w, "\n%s", roffEscape(usage))
+	switch f.DefValue {
+	case "", "0", "false", "0s":
+	default:
+		if !strings.Contains(usage, "default") {
+			fmt.Fprintf(w, " (default: %s)", roffEscape(f.DefValue))
+		}
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// writeManPage writes the manual page in roff, for man(1)
+func writeManPage(w io.Writer) {
+	common := commonFlags()
+
+	fmt.Fprintf(w, `.\" Generated by "padlock docs man"
+.TH PADLOCK 1 "" "padlock" "User Commands"
+.SH NAME
+padlock \- split data into K\-of\-N one\-time\-pad collections, and reconstruct it
+.SH SYNOPSIS
+.B padlock
+\fIcommand\fR [\fIarguments\fR] [\fIoptions\fR]
+.SH DESCRIPTION
+%s
+.PP
+Positional arguments precede the options of each command.
+.SH COMMANDS
+`, roffEscape(manDescription))
+
+	for _, c := range commands {
+		fmt.Fprintf(w, ".SS \"padlock %s %s\"\n%s.\n", c.name, manSynopsis(c), roffEscape(c.summary))
+		for _, f := range c.flags() {
+			if !common[f.Name] {
+				writeManFlag(w, f)
+			}
+		}
+	}
+
+	// Common flags are described once, as registered by the first command
+	// accepting them
+	fmt.Fprintf(w, ".SH COMMON OPTIONS\nEvery command with options also accepts these.\n")
+	for _, c := range commands {
+		flags := c.flags()
+		if len(flags) == 0 {
+			continue
+		}
+		for _, f := range flags {
+			if common[f.Name] {
+				writeManFlag(w, f)
+			}
+		}
+		break
+	}
+
+	fmt.Fprintf(w, `.SH ENVIRONMENT
+.TP
+.B PADLOCK_\fIOPTION\fR
+The default of an option not given on the command line, named after the option
+in upper case with "_" for "\-", e.g. \fBPADLOCK_ON_CONFLICT\fR for
+\fB\-on\-conflict\fR. These take precedence over the config file, and are
+ignored when empty.
+.TP
+.B %s
+The config file to read instead of the default, which must exist.
+.SH FILES
+.TP
+.I ~/.config/padlock/config.toml
+The config file, in the user's config directory, giving defaults for options
+not given on the command line or in the environment. Options at the top of the
+file, before any table, apply to every command accepting them, and those in a
+table named after a command, e.g. \fB[encode]\fR, apply to that command and
+take precedence. Values are TOML strings, integers, booleans or arrays of
+strings, e.g. \fBcopies = 5\fR or \fBexclude = [".git", "*.tmp"]\fR.
+`, configEnv)
+
+	fmt.Fprintf(w, ".SH EXIT STATUS\n")
+	for _, e := range exitStatuses {
+		fmt.Fprintf(w, ".TP\n.B %d\n%s.\n", e.code, roffEscape(e.meaning))
+	}
+
+	fmt.Fprintf(w, ".SH EXAMPLES\n.nf\n")
+	for _, example := range examples {
+		fmt.Fprintf(w, "%s\n", roffEscape(example))
+	}
+	fmt.Fprintf(w, ".fi\n")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestManPage(t *testing.T) {
+	var buf bytes.Buffer
+	writeManPage(&buf)
+	page := buf.String()
+
+	t.Run("Commands and flags", func(t *testing.T) {
+		for _, c := range commands {
+			if !strings.Contains(page, fmt.Sprintf(".SS \"padlock %s ", c.name)) {
+				t.Errorf("command %s has no section", c.name)
+			}
+			for _, f := range c.flags() {
+				if !strings.Contains(page, `\fB\-`+roffEscape(f.Name)+`\fR`) {
+					t.Errorf("flag -%s of %s is missing", f.Name, c.name)
+				}
+			}
+		}
+	})
+
+	t.Run("Exit statuses", func(t *testing.T) {
+		for _, e := range exitStatuses {
+			if !strings.Contains(page, fmt.Sprintf(".B %d\n", e.code)) {
+				t.Errorf("exit status %d is missing", e.code)
+			}
+		}
+	})
+
+	t.Run("No stray requests", func(t *testing.T) {
+		for _, line := range strings.Split(page, "\n") {
+			if strings.HasPrefix(line, "'") {
+				t.Errorf("line is taken as a request: %q", line)
+			}
+		}
+	})
+}
+
+func TestRoffEscape(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"-copies", `\-copies`},
+		{`C:\out`, `C:\eout`},
+		{".hidden", `\&.hidden`},
+		{"first\n'quoted", "first\n\\&'quoted"},
+	}
+
+	for _, tt := range tests {
+		if got := roffEscape(tt.in); got != tt.want {
+			t.Errorf("roffEscape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// entropyFlags holds the options for mixing randomness fetched over the
+// network into the local random sources
+type entropyFlags struct {
+	sources stringList
+	policy  *string
+	pins    stringList
+}
+
+// addEntropyFlags registers the remote entropy options on a command's flag set
+func addEntropyFlags(fs *flag.FlagSet) *entropyFlags {
+	ef := &entropyFlags{}
+	fs.Var(&ef.sources, "entropy", "comma-separated remote random `sources` to mix in: anu, drand[:CHAIN], nist or https URLs returning JSON, with the field holding the bytes after #")
+	ef.policy = fs.String("entropy-policy", "all", "how many -entropy sources must answer: all, any or optional")
+	fs.Var(&ef.pins, "entropy-pin", "comma-separated `HOST=sha256/BASE64` pins of the public keys -entropy hosts must present")
+	return ef
+}
+
+// rng returns the default random number generator, mixing in the remote
+// sources of -entropy, which are fetched now. It exits if they are refused
+// in offline mode or fail to answer as -entropy-policy requires.
+func (ef *entropyFlags) rng(ctx context.Context, log *trace.Tracer) pad.RNG {
+	rng := pad.NewDefaultRand(ctx)
+	if len(ef.sources) == 0 {
+		return rng
+	}
+	cfg := pad.RemoteConfig{Pins: make(map[string][]string)}
+	for _, spec := range ef.sources {
+		backend, err := pad.ParseRemoteEntropy(spec)
+		if err != nil {
+			fatalf(exitUsage, "Error: -entropy: %v", err)
+		}
+		cfg.Backends = append(cfg.Backends, backend)
+	}
+	policy, err := pad.ParseRemotePolicy(*ef.policy)
+	if err != nil {
+		fatalf(exitUsage, "Error: -entropy-policy: %v", err)
+	}
+	cfg.Policy = policy
+	for _, pin := range ef.pins {
+		host, key, ok := strings.Cut(pin, "=")
+		if !ok || !strings.HasPrefix(key, "sha256/") {
+			fatalf(exitUsage, "Error: -entropy-pin must be HOST=sha256/BASE64, got %q", pin)
+		}
+		cfg.Pins[host] = append(cfg.Pins[host], key)
+	}
+
+	remote, err := pad.NewRemoteRNG(ctx, cfg)
+	if err != nil {
+		log.FatalCode(err, exitCode(err))
+	}
+	if remote != nil {
+		log.Infof("Mixing in remote randomness from %s", remote.Remote())
+		multi := rng.(*pad.MultiRNG)
+		multi.Sources = append(multi.Sources, remote)
+	}
+	return rng
+}
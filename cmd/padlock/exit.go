@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/rayozzie/padlock/pkg/padlock"
+)
+
+// Exit codes of the command-line tool, one per class of failure, so that
+// scripts can tell them apart. These values are stable and documented in the
+// usage text and README; new classes get new codes rather than reusing these.
+const (
+	exitOK                      = 0  // Success
+	exitFailure                 = 1  // Any failure not covered below
+	exitUsage                   = 2  // Invalid command line, arguments or options
+	exitIO                      = 3  // A file or directory could not be read or written
+	exitInsufficientCollections = 4  // Fewer collections than required were found
+	exitChunkCorrupt            = 5  // Chunks are missing or damaged beyond recovery, or mislabeled
+	exitSessionMismatch         = 6  // Collections come from different encodes
+	exitNotArchive              = 7  // The decoded data is not a valid archive
+	exitOutputConflict          = 8  // The output directory is not empty, or a restored file already exists
+	exitUnsafePath              = 9  // The archive tries to write outside of the output directory
+	exitNoMatch                 = 10 // No files in the archive matched the selection
+	exitAuditTampered           = 11 // An audit log or ceremony record has been altered
+	exitOutputFull              = 12 // The output has too little space or too few free files
+	exitHashMismatch            = 13 // Restored files do not match the hashes recorded when they were encoded
+	exitMetadataMismatch        = 14 // Collections do not record the metadata required of the decode
+	exitExpired                 = 15 // Collections are used after their expiry, and their policy is enforced
+	exitUnhealthyRNG            = 16 // The random number generator failed its health tests
+	exitCodeMismatch            = 17 // A collection does not have the verification code issued with it
+	exitNotRandom               = 18 // Pads or collection chunks failed the randomness checks, with -randomness fail
+	exitVerifyFailed            = 19 // The collections written by encode -verify do not reconstruct the input
+	exitInventoryMismatch       = 20 // Collections of a set manifest are missing or modified, for audit -manifest
+)
+
+// exitStatuses describe the exit codes, for the usage text and the man page
+var exitStatuses = []struct {
+	code    int
+	meaning string
+}{
+	{exitOK, "Success"},
+	{exitFailure, "Any other failure"},
+	{exitUsage, "Invalid command line, arguments or options"},
+	{exitIO, "A file or directory could not be read or written"},
+	{exitInsufficientCollections, "Fewer collections than required were found"},
+	{exitChunkCorrupt, "Chunks are missing or damaged beyond recovery, or mislabeled"},
+	{exitSessionMismatch, "Collections come from different encodes"},
+	{exitNotArchive, "The decoded data is not a valid archive"},
+	{exitOutputConflict, "The output directory is not empty, or a restored file already exists"},
+	{exitUnsafePath, "The archive tries to write outside of the output directory"},
+	{exitNoMatch, "No files in the archive matched -files"},
+	{exitAuditTampered, "An audit log or ceremony record has been altered"},
+	{exitOutputFull, "The output has too little space or too few free files (inodes)"},
+	{exitHashMismatch, "Restored files are missing or differ from the hashes recorded by -hashes"},
+	{exitMetadataMismatch, "Collections do not record the metadata given by -require-metadata"},
+	{exitExpired, "Collections are used after their -not-after expiry, with -enforce-policy"},
+	{exitUnhealthyRNG, "The random number generator failed its health tests"},
+	{exitCodeMismatch, "A collection does not have the verification code given by -code"},
+	{exitNotRandom, "Pads or collection chunks failed the randomness checks, with -randomness fail"},
+	{exitVerifyFailed, "The collections written do not decode to the input, with -verify"},
+	{exitInventoryMismatch, "Collections of the set manifest given by -manifest are missing or modified"},
+}
+
+// exitCodes maps errors to exit codes, in order of precedence: a decode that
+// fails for lack of collections often damages the stream it produces, so the
+// cause is checked before its consequences
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{padlock.ErrInvalidConfig, exitUsage},
+	{padlock.ErrInvalidParameters, exitUsage},
+	{padlock.ErrOffline, exitUsage},
+	{padlock.ErrNoCollections, exitInsufficientCollections},
+	{padlock.ErrInsufficientCollections, exitInsufficientCollections},
+	{padlock.ErrSessionMismatch, exitSessionMismatch},
+	{padlock.ErrChunkCorrupt, exitChunkCorrupt},
+	{padlock.ErrBadLabel, exitChunkCorrupt},
+	{padlock.ErrUnsafePath, exitUnsafePath},
+	{padlock.ErrNotArchive, exitNotArchive},
+	{padlock.ErrOutputNotEmpty, exitOutputConflict},
+	{padlock.ErrFileExists, exitOutputConflict},
+	{padlock.ErrNoMatch, exitNoMatch},
+	{padlock.ErrAuditTampered, exitAuditTampered},
+	{padlock.ErrOutputFull, exitOutputFull},
+	{padlock.ErrHashMismatch, exitHashMismatch},
+	{padlock.ErrMetadataMismatch, exitMetadataMismatch},
+	{padlock.ErrExpired, exitExpired},
+	{padlock.ErrUnhealthyRNG, exitUnhealthyRNG},
+	{padlock.ErrCodeMismatch, exitCodeMismatch},
+	{padlock.ErrNotRandom, exitNotRandom},
+	{padlock.ErrVerifyFailed, exitVerifyFailed},
+	{padlock.ErrInventoryMismatch, exitInventoryMismatch},
+}
+
+// exitCode returns the exit code for the class of an error
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	for _, e := range exitCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	var syscallErr *os.SyscallError
+	if errors.As(err, &pathErr) || errors.As(err, &linkErr) || errors.As(err, &syscallErr) {
+		return exitIO
+	}
+	return exitFailure
+}
+
+// fatalf logs a message and exits with the given exit code
+func fatalf(code int, format string, args ...interface{}) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/padlock"
+)
+
+func TestExitCode(t *testing.T) {
+	_, statErr := os.Stat("/nonexistent/padlock")
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"Success", nil, exitOK},
+		{"Unclassified", fmt.Errorf("something went wrong"), exitFailure},
+		{"Invalid config", fmt.Errorf("encode failed: %w", padlock.ErrInvalidConfig), exitUsage},
+		{"I/O error", fmt.Errorf("decode failed: %w", statErr), exitIO},
+		{"No collections", fmt.Errorf("decode failed: %w", padlock.ErrNoCollections), exitInsufficientCollections},
+		{"Not enough collections", fmt.Errorf("decode failed: %w", padlock.ErrInsufficientCollections), exitInsufficientCollections},
+		{"Corrupt chunk", fmt.Errorf("decode failed: %w", padlock.ErrChunkCorrupt), exitChunkCorrupt},
+		{"Different encodes", fmt.Errorf("decode failed: %w", padlock.ErrSessionMismatch), exitSessionMismatch},
+		{"Not an archive", fmt.Errorf("decode failed: %w", padlock.ErrNotArchive), exitNotArchive},
+		{"Output not empty", fmt.Errorf("decode failed: %w", padlock.ErrOutputNotEmpty), exitOutputConflict},
+		{"Unsafe path", fmt.Errorf("decode failed: %w", padlock.ErrUnsafePath), exitUnsafePath},
+		{"No match", fmt.Errorf("decode failed: %w", padlock.ErrNoMatch), exitNoMatch},
+		{"Audit log altered", fmt.Errorf("audit failed: %w", padlock.ErrAuditTampered), exitAuditTampered},
+		{"Output full", fmt.Errorf("encode failed: %w", padlock.ErrOutputFull), exitOutputFull},
+		{"Hash mismatch", fmt.Errorf("decode failed: %w", padlock.ErrHashMismatch), exitHashMismatch},
+		{"Metadata mismatch", fmt.Errorf("decode failed: %w", padlock.ErrMetadataMismatch), exitMetadataMismatch},
+		{"Expired", fmt.Errorf("decode failed: %w", padlock.ErrExpired), exitExpired},
+		{"Unhealthy RNG", fmt.Errorf("ceremony failed: %w", padlock.ErrUnhealthyRNG), exitUnhealthyRNG},
+		{"Code mismatch", fmt.Errorf("checkcode failed: %w", padlock.ErrCodeMismatch), exitCodeMismatch},
+		{"Not random", fmt.Errorf("encode failed: %w", padlock.ErrNotRandom), exitNotRandom},
+		{"Verify failed", fmt.Errorf("encode failed: %w", padlock.ErrVerifyFailed), exitVerifyFailed},
+		{"Inventory mismatch", fmt.Errorf("audit failed: %w", padlock.ErrInventoryMismatch), exitInventoryMismatch},
+		{"Cause before consequence", fmt.Errorf("%w (%w)", padlock.ErrNotArchive, padlock.ErrInsufficientCollections), exitInsufficientCollections},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
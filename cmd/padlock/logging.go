@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/padlock"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// logRotateKeep is the number of rotated log files kept alongside -log-file
+const logRotateKeep = 5
+
+// logFlags holds the logging options common to every command
+type logFlags struct {
+	file    *string
+	format  *string
+	levels  *string
+	maxSize *string
+	stats   *time.Duration
+	spans   *string
+}
+
+// addLogFlags registers the logging options on a command's flag set
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	return &logFlags{
+		file:    fs.String("log-file", "", "also write the log to this `file`, rotated as it grows"),
+		format:  fs.String("log-format", "text", "log format: text or json (applies to -log-file if given, otherwise to standard error)"),
+		levels:  fs.String("log-level", "", "log levels per prefix, e.g. DECODE=verbose,PADLOCK=error (levels: error, normal, verbose)"),
+		maxSize: fs.String("log-max-size", "10MiB", "size at which -log-file is rotated"),
+		stats:   fs.Duration("log-stats", 0, "log encode and decode progress and throughput at this interval, e.g. 30s"),
+		spans:   fs.String("log-spans", "", "append the timed steps of every operation to this `file` as OpenTelemetry OTLP/JSON"),
+	}
+}
+
+// tracer creates the command's tracer, logging where and how the flags ask,
+// starts logging statistics if -log-stats is given, and exports spans if
+// -log-spans is given
+func (lf *logFlags) tracer(verbose bool) *trace.Tracer {
+	log := lf.newTracer(verbose)
+	if *lf.spans != "" {
+		f, err := os.OpenFile(*lf.spans, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			fatalf(exitIO, "Error: -log-spans: %v", err)
+		}
+		log.SetSpanExporters(trace.OTLPJSONExporter(f, "padlock"))
+	}
+	if *lf.stats < 0 {
+		fatalf(exitUsage, "Error: -log-stats must not be negative, got %v", *lf.stats)
+	}
+	if *lf.stats > 0 {
+		padlock.LogMetrics(trace.WithContext(context.Background(), log), *lf.stats)
+	}
+	return log
+}
+
+// newTracer creates the command's tracer, logging where and how the flags ask
+func (lf *logFlags) newTracer(verbose bool) *trace.Tracer {
+	logLevel := trace.LogLevelNormal
+	if verbose {
+		logLevel = trace.LogLevelVerbose
+	}
+
+	// A bare level sets the default; PREFIX=LEVEL overrides it for one prefix
+	prefixLevels := map[string]trace.LogLevel{}
+	for _, item := range strings.Split(*lf.levels, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		prefix, name, found := strings.Cut(item, "=")
+		if !found {
+			name = item
+		}
+		level, err := trace.ParseLogLevel(strings.TrimSpace(name))
+		if err != nil {
+			fatalf(exitUsage, "Error: -log-level: %v", err)
+		}
+		if !found {
+			logLevel = level
+			continue
+		}
+		prefixLevels[strings.ToUpper(strings.TrimSpace(prefix))] = level
+	}
+	log := trace.NewTracer("MAIN", logLevel)
+	for prefix, level := range prefixLevels {
+		log.SetPrefixLevel(prefix, level)
+	}
+
+	var newSink func(w io.Writer) trace.Sink
+	switch *lf.format {
+	case "text":
+		newSink = trace.TextSink
+	case "json":
+		newSink = trace.JSONSink
+	default:
+		fatalf(exitUsage, "Error: -log-format must be 'text' or 'json', got '%s'", *lf.format)
+	}
+
+	if *lf.file == "" {
+		if *lf.format == "json" {
+			log.SetSinks(trace.JSONSink(os.Stderr))
+		}
+		return log
+	}
+	maxBytes, err := parseSize(*lf.maxSize)
+	if err != nil {
+		fatalf(exitUsage, "Error: -log-max-size: %v", err)
+	}
+	f, err := trace.OpenRotatingFile(*lf.file, maxBytes, logRotateKeep)
+	if err != nil {
+		fatalf(exitIO, "Error: -log-file: %v", err)
+	}
+	log.SetSinks(trace.StdLogSink(), newSink(f))
+	return log
+}
@@ -52,10 +52,18 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/rayozzie/padlock/pkg/audit"
+	"github.com/rayozzie/padlock/pkg/file"
 	"github.com/rayozzie/padlock/pkg/pad"
 	"github.com/rayozzie/padlock/pkg/padlock"
+	"github.com/rayozzie/padlock/pkg/server"
 	"github.com/rayozzie/padlock/pkg/trace"
 )
 
@@ -63,35 +71,318 @@ import (
 //
 // This function displays usage instructions for the padlock command-line tool,
 // explaining the available commands, their parameters, and options.
-// After displaying the help text, it exits with status code 1.
+// After displaying the help text, it exits with the usage exit code.
 func usage() {
 	fmt.Fprintf(os.Stderr, `Usage:
-  padlock encode <inputDir> <outputDir> [-copies N] [-required REQUIRED] [-format bin|png] [-clear] [-chunk SIZE] [-verbose] [-zip]
-  padlock decode <inputDir> <outputDir> [-clear] [-verbose]
+  padlock encode <inputDir>... <outputDir> [-copies N] [-required REQUIRED] [-format bin|png|packed] [-clear] [-chunk SIZE] [-verbose] [-zip] [-archive zip|tgz|none]
+                 [-preserve LIST] [-include PATTERNS] [-exclude PATTERNS] [-follow-symlinks] [-one-file-system]
+                 [-deterministic] [-hashes] [-volume SIZE] [-groups POLICY] [-custodians LIST]
+                 [-custodians-file PATH] [-parity PERCENT] [-audit] [-audit-log PATH]
+                 [-pad-length] [-pad-chunks N] [-decoy DIR -decoy-collections LETTERS] [-obfuscate]
+                 [-zip-encrypt] [-zip-passwords FILE] [-chunk-names TEMPLATE] [-store DIR] [-preset NAME]
+                 [-decoders self,FILES] [-hybrid shared|replicated] [-labels friendly|LIST] [-metadata LIST]
+                 [-not-after DATE] [-policy NOTES] [-entropy SOURCES] [-entropy-policy POLICY] [-entropy-pin LIST]
+  padlock encode <inputDir>... <outputDir> -snapshot NAME [-chunk SIZE] [-preserve LIST] [-include PATTERNS] [-exclude PATTERNS]
+                 [-pad-length] [-pad-chunks N] [-verbose]
+  padlock encode <inputDir> -target DIR1,DIR2,... [-required REQUIRED] [options]
+  padlock encode <inputDir>... <outputDir> -pads DIR [-format bin|png] [-zip] [options]
+  padlock ceremony <inputDir>... <outputDir> -custodians LIST|-custodians-file PATH [-required REQUIRED] [-record FILE]
+                 [-signing-key FILE] [encode options]
+  padlock pads <outputDir> -size SIZE [-copies N] [-required REQUIRED] [-format bin|png] [-chunk SIZE] [-clear] [-verbose]
+  padlock decode <inputDir> <outputDir> [-clear] [-verbose] [-restore LIST] [-on-conflict POLICY] [-files PATTERNS] [-strict]
+                 [-snapshot NAME] [-require-metadata LIST] [-enforce-policy] [-audit] [-audit-log PATH]
+  padlock decode <inputDir> -stdout [-files PATTERNS] [-strict] [-snapshot NAME] [-verbose] [-audit-log PATH]
+  padlock ls <inputDir> [-snapshot NAME | -snapshots] [-verbose]
+  padlock verify-restore <inputDir> <outputDir> [-files PATTERNS] [-snapshot NAME] [-verbose]
+  padlock mount <inputDir> <mountpoint> [-verbose]
+  padlock diagnose <inputDir> [-verbose]
+  padlock checkcode <collection> [-code CODE] [-verbose]
+  padlock info [<dir>...] [-scan DIRS] [-verbose]
+  padlock manifest <inputDir> [-out FILE] [-verbose]
+  padlock recover [<outputDir>] [-scan DIRS] [-clear] [-restore LIST] [-verbose]
+  padlock watch <inputDir> <outputDir> [-delay DURATION] [encode options]
+  padlock reshare <inputDir> <outputDir> [-copies N] [-required REQUIRED] [-format bin|png|packed] [-clear] [-chunk SIZE]
+                 [-zip] [-archive zip|tgz|none] [-zip-encrypt] [-volume SIZE] [-target DIRS] [-preset NAME]
+                 [-decoders self,FILES] [-entropy SOURCES] [-verbose]
+  padlock refresh <inputDir> <outputDir> [-clear] [-zip] [-archive zip|tgz|none] [-zip-encrypt] [-entropy SOURCES] [-verbose]
+  padlock convert <inputDir> <outputDir> [-format bin|png|packed] [-chunk-names TEMPLATE] [-clear] [-zip] [-archive zip|tgz|none]
+                 [-zip-encrypt] [-verbose]
+  padlock repair <inputDir> <outputDir> -collection NAME [-clear] [-zip] [-archive zip|tgz|none] [-zip-encrypt] [-verbose]
+  padlock split-secret <outputDir> [-in FILE] [-copies N] [-required REQUIRED] [-encoding base64|paper|mnemonic|bin]
+                 [-entropy SOURCES] [-clear] [-verbose]
+  padlock join-secret <share>|-... [-out FILE] [-verbose]
+  padlock serve [-listen ADDR] [-max-bytes SIZE] [-chunk SIZE] [-entropy SOURCES] [-audit-log PATH] [-verbose]
+  padlock audit <logFile>|<ceremonyRecord>
+  padlock audit -manifest <setManifest> [-partial] [-json] <path>
+  padlock presets list
+  padlock scheme [-copies N] [-required REQUIRED] [-json]
+  padlock selftest [-verbose]
+  padlock tui [-scan DIRS] [-preserve LIST] [-restore LIST] [-entropy SOURCES] [-verbose]
+  padlock completion bash|zsh|fish|powershell
+  padlock docs man
 
-Commands:
-  encode            Split input data into N collections with K-of-N threshold security
-  decode            Reconstruct original data from K or more collections
+Every command also accepts [-log-file PATH] [-log-format text|json] [-log-level LIST] [-log-max-size SIZE]
+[-log-stats DURATION] [-log-spans PATH] [-no-swap] [-low-memory] [-offline].
+Commands reading collections also accept [-zip-passwords FILE], and otherwise ask for the password of each encrypted zip,
+and [-tmpdir DIR] [-keep-temp] to extract archives that cannot be read in place into DIR and keep them for debugging.
+Options not given default to a PADLOCK_<OPTION> environment variable (e.g. PADLOCK_ON_CONFLICT), then to the
+command's table in ~/.config/padlock/config.toml (or $PADLOCK_CONFIG), then to the top of that file.
 
+Commands:
+%s
 Parameters:
-  <inputDir>        Source directory containing data to encode or collections to decode
+  <inputDir>        Source directory containing data to encode or collections to decode; encode also takes
+                    several directories and files, each archived under its own name (e.g. docs/, notes.txt)
   <outputDir>       Destination directory for encoded collections or decoded data
 
 Options:
+  -preset NAME      Take -copies, -required, -format and other options from a preset: personal (2 of 3, png,
+                    zip), enterprise (3 of 5, bin), archival (2 of 4, bin, zip, 10%% parity), one defined as
+                    [preset.NAME] in the config file, or a spec KofN[-bin|png][-zip|tgz|none] such as 2of3-png-zip;
+                    options given on the command line take precedence (see padlock presets list)
   -copies N         Number of collections to create (must be between 2 and 26, default: 2)
   -required REQUIRED  Minimum collections required for reconstruction (default: 2)
-  -format FORMAT    Output format: bin or png (default: png)
+  -format FORMAT    Output format: bin, png or packed (default: png); packed writes each collection as a
+                    single file (e.g. 3A5.padlock) of binary chunks with an index, far faster to write and copy
+                    than thousands of chunk files, and read in place when decoding
+  -chunk-names TEMPLATE  Name chunk files with TEMPLATE, in which %%04d is the chunk number (%%d for no
+                    padding) and %%s the collection name, e.g. DSC_%%04d.PNG, or a preset: camera
+                    (DSC_%%04d.PNG), phone (IMG_%%04d.PNG) or scan (scan%%03d.bin, with -format bin);
+                    decode detects the naming (default: IMG%%s_%%04d.PNG or %%s_%%04d.bin)
   -clear            Clear output directory if not empty
   -chunk SIZE       Maximum candidate block size in bytes (default: 2MB)
   -verbose          Enable detailed debug output
   -zip              Create zip files for each collection instead of directories
+  -archive KIND     Archive each collection as zip, tgz (a .tar.gz, which tar unpacks) or none (default: none,
+                    or zip with -zip); tgz collections are read in place when decoding
+  -zip-encrypt      With -zip, encrypt each zip with AES-256 using a password per collection (or custodian),
+                    asked for on the terminal unless -zip-passwords is given
+  -zip-passwords FILE  Read the passwords of encrypted zips from FILE, one NAME=PASSWORD per line,
+                    where NAME is a collection or custodian (e.g. 3A5), or * for any other
+  -include PATTERNS Only encode entries matching these comma-separated glob patterns (repeatable)
+  -exclude PATTERNS Skip entries matching these comma-separated glob patterns (repeatable)
+                    Patterns without '/' match base names at any depth (e.g. .git, *.tmp);
+                    patterns with '/' match paths relative to <inputDir> (e.g. docs/*.txt)
+  -follow-symlinks  Archive what symlinks point to instead of the links themselves (cycles are skipped)
+  -one-file-system  Don't descend into directories on other file systems
+  -deterministic    Normalize timestamps and ownership so identical input yields an identical archive stream
+  -hashes           Record the SHA-256 of each file in the archive, against which decode checks the files it
+                    restores and verify-restore checks a restored directory
+  -volume SIZE      Split each collection into volumes of at most SIZE bytes for fixed-size media
+                    (e.g. 4.7GB, 32GB, 700MiB, or cd, dvd, dvd-dl, bd)
+  -store DIR        Keep the files of the collections in the chunk store DIR, which any number of collections
+                    may share, each file under the SHA-256 hash of its contents, and write only a manifest
+                    per collection (e.g. 3A5.store.json) to <outputDir>; decode verifies every file it reads
+  -decoders LIST    Embed padlock executables in each collection, with instructions for running them, so that
+                    it can be decoded without finding padlock: self for this one, and builds for other platforms
+  -target DIRS      Write each collection directly to its own directory (e.g. a mounted USB drive),
+                    verifying every chunk by read-back and leaving a report on each device
+  -out A=DIR,...    Write the collections named by letter straight to their own directories, the rest
+                    to <outputDir>, e.g. A=/mnt/usb1,B=/mnt/usb2
+  -groups POLICY    Encode for groups of custodians, every one of which must reach its own threshold,
+                    e.g. board:2of3,engineers:3of5 (replaces -copies and -required)
+  -custodians LIST  Bundle collections per named custodian, one artifact each, with optional weights,
+                    e.g. ceo:2,cfo,cto,counsel gives the ceo two collections (replaces -copies)
+  -custodians-file PATH  Like -custodians, from a JSON file that also gives each custodian's contact
+                    details and recovery instructions, embedded as a README in every collection
+  -parity PERCENT   Add Reed-Solomon parity files to each collection, PERCENT of its chunk count,
+                    so that chunks lost to damaged media can be rebuilt when it is read
+  -pad-length       Pad every chunk to the full chunk size so collection sizes don't reveal the input length
+  -pad-chunks N     Also add a random number, up to N, of empty chunks to hide the chunk count
+                    (implies -pad-length)
+  -decoy DIR        Also encode DIR into the same collections as a decoy, revealed by any REQUIRED collections
+                    that include a decoy collection; the others reveal <inputDir>
+  -decoy-collections LETTERS  With -decoy, the letters of the decoy collections, e.g. C or DE
+  -labels LIST      Label each collection, for shares labeled by hand: friendly gives them letters and digits
+                    that can't be mistaken for each other (no I, O, 0 or 1), or give one label per collection,
+                    e.g. ALPHA,BRAVO,CHARLIE; the labels are recorded in every collection, and decode and info
+                    name the collections by them
+  -metadata LIST    Describe the set with comma-separated KEY=VALUE pairs, e.g. owner=alice,ticket=OPS-1234,
+                    recorded in the clear in the manifest of every collection and listed by decode and info;
+                    never put anything secret in it
+  -not-after DATE   Record that the collections expire after DATE (e.g. 2030-01-01, through the end of that
+                    day in UTC, or 2030-01-01T12:00:00Z) and are to be rotated; decode warns when they are used
+                    after it, and info shows it
+  -policy NOTES     Record notes on the use of the collections, such as their rotation schedule, shown by
+                    decode and info
+  -randomness POLICY  Check the pads and collection chunks of an encode look random: off, warn (default),
+                    warning about and summarizing those that don't, or fail, stopping the encode at the first
+  -verify           Once every chunk is written, decode REQUIRED of the collections and check that the
+                    archive they reconstruct has the SHA-256 of the archive encoded (exit status 19 if not)
+  -shred-input      Once the collections are decoded and every input file is verified against them, overwrite
+                    the input files with random data and remove them. SSDs, flash, copy-on-write filesystems,
+                    snapshots and backups may keep copies that overwriting cannot reach
+  -obfuscate        Name collections and their files with random-looking names (e.g. 9f2c41d0a7be/) that don't
+                    reveal REQUIRED, the number of collections or the scheme; decode reads the names back
+  -preserve LIST    Attributes to archive on encode: symlinks,owner,hardlinks,sparse,xattrs,special, all or none
+                    (default: symlinks,owner,hardlinks,sparse; hardlinks stores hard-linked files once, sparse
+                    stores only the data of files with holes, and special records FIFOs and device nodes;
+                    sockets and, by default, special files are skipped)
+  -restore LIST     Attributes to restore on decode: symlinks,perms,mtime,owner,xattrs,special, all or none
+                    (default: symlinks,perms,mtime)
+  -on-conflict POLICY  Restore into a non-empty output directory, resolving files that already
+                    exist with: overwrite, skip, rename or error
+  -files PATTERNS   Only restore entries matching these comma-separated glob patterns (repeatable); with
+                    verify-restore, only check those entries
+  -stdout           Write the contents of the selected files to standard output instead of a directory
+  -strict           Fail decode rather than fall back when collections are not all of one set, a chunk
+                    cannot be recovered, or the decoded data is not a complete archive
+  -require-metadata LIST  With decode, refuse collections that don't all record these KEY=VALUE pairs of
+                    -metadata, before restoring anything
+  -enforce-policy   With decode, refuse collections used after their -not-after expiry, rather than warning
+  -snapshot NAME    With encode, append the input as a snapshot named NAME to the set already in <outputDir>,
+                    in chunks after its own, so that each version is kept and decoded on its own; with decode,
+                    ls or verify-restore, read the snapshot NAME rather than the latest (the set's first
+                    encode is "initial")
+  -hybrid MODE      For inputs too large for every collection to hold their size: encrypt the input once with
+                    XChaCha20-Poly1305 under a random key and split only the key, keeping the encrypted data
+                    in padlock-data.enc, shared (once, next to the collections) or replicated (in each);
+                    the data is then only as secure as the cipher, not information-theoretically secure
+  -pads DIR         With encode, apply the pads that the pads command generated in DIR, on a machine that
+                    may have no trusted random source, rather than drawing random numbers; the set and chunk
+                    size are those of the pads, which are used once and must then be destroyed
+  -entropy SOURCES  With the commands that draw pads (encode, pads, reshare, refresh, split-secret, serve
+                    and tui), mix randomness fetched once over the network into the local random sources: anu
+                    (quantum), drand or nist (public beacons), or https URLs returning JSON, with the dotted path
+                    of the field holding the bytes after #, e.g. https://host/rand#data.value; drand rounds are
+                    BLS-verified, from quicknet or drand:mainnet
+  -entropy-policy POLICY  How many -entropy sources must answer: all (default), any or optional
+  -entropy-pin LIST  Comma-separated HOST=sha256/BASE64 hashes of the public keys -entropy hosts must present
+  -entropy-report FILE  With encode, write a JSON report of the random sources, the bytes each contributed and
+                    the SHA-256 of its output, and their health test results, for audits of the pads
+  -size SIZE        With pads, the size of the archived, compressed input the pads must be able to encode
+  -snapshots        With ls, list the snapshots of the set, when each was encoded and the chunks it occupies
+  -scan DIRS        With info or recover, the comma-separated directories to search (default: where drives
+                    are mounted, such as /Volumes, /media and /mnt)
+  -delay DURATION   With watch, how long the input must be quiet before re-encoding (default: 5s)
+  -record FILE      With ceremony, write the signed ceremony record to FILE (default:
+                    padlock-ceremony-DATE-TIME.json in the parent of <outputDir>)
+  -signing-key FILE  With ceremony, sign the record with this Ed25519 private key (PKCS #8 PEM, e.g. from
+                    openssl genpkey -algorithm ed25519) rather than a key generated for the ceremony
+  -code CODE        With checkcode, the verification code issued with the collection when it was encoded,
+                    e.g. K7QM-3XPA, to compare with its code (case, spaces and dashes are ignored)
+  -in FILE          With split-secret, read the secret from FILE rather than standard input; it is split
+                    byte for byte, trailing newline included, and may be up to 16KiB
+  -encoding KIND    With split-secret, write each share as base64 text (default), paper (numbered lines of hex,
+                    each with a checksum that join-secret checks, to print and type back in), mnemonic (words of
+                    the BIP-39 English wordlist ending in a checksum, to write down or memorize) or bin;
+                    join-secret reads a share given as - from standard input, such as a mnemonic typed in
+  -out FILE         With join-secret, write the secret to a new FILE rather than standard output
+  -listen ADDR      Address for serve to listen on (default: 127.0.0.1:8420)
+  -max-bytes SIZE   Largest request body serve accepts, e.g. 64MiB (default: 64MiB)
+  -audit            Record the operation, its parameters and the hashes of the files it read and wrote
+                    in a hash-chained log, padlock-audit.jsonl, in the parent of <outputDir>
+  -audit-log PATH   Like -audit, recording the operation in PATH instead
+  -log-file PATH    Also write the log to PATH, rotated when it reaches -log-max-size with 5 older
+                    files kept as PATH.1 to PATH.5, so long-running commands can be audited afterward
+  -log-format FORMAT  Log format: text or json, one object per line (default: text); applies to
+                    -log-file if given, otherwise to standard error
+  -log-level LIST   Log levels per component prefix: error, normal or verbose, e.g. FILE=verbose,PAD=error;
+                    a level without a prefix sets the default (default: normal, or verbose with -verbose)
+  -log-max-size SIZE  Size at which -log-file is rotated, e.g. 100MiB (default: 10MiB)
+  -log-stats DURATION  Log a line of the bytes and chunks encoded and decoded, throughput, collections
+                    being decoded, random bytes drawn and errors at this interval, e.g. 30s
+  -log-spans PATH   Append the timed steps of every operation (encode, chunk, collection) to PATH as
+                    OpenTelemetry OTLP/JSON, one span per line, for the Collector's otlpjsonfile receiver
+  -no-swap          Lock memory so that plaintext and pads are never written to swap, and disable core
+                    dumps; fails unless the locked-memory limit is unlimited (ulimit -l) or run as root
+  -low-memory       For devices with little memory, such as Raspberry Pi-class boards: run on one thread,
+                    collect garbage sooner and, when encoding, limit chunks to 256KB
+  -offline          Guarantee no network access, for air-gapped use: refuse random sources that would
+                    fetch randomness over the network before reading any, make every connection attempt
+                    fail, and let serve listen only on a loopback address
 
+Exit status:
+%s
 Examples:
-  padlock encode ~/Documents/secret ~/Collections -copies 5 -required 3 -format png -zip
-  padlock decode ~/Collections/subset ~/Restored -clear
-  padlock encode ~/Documents/top-secret ~/Collections -copies 5 -required 3 -verbose
-`)
-	os.Exit(1)
+%s`, usageCommands(), usageExitStatuses(), usageExamples())
+	os.Exit(exitUsage)
+}
+
+// examples are the example command lines shown in the usage and the man page
+var examples = []string{
+	"padlock encode ~/Documents/secret ~/Collections -copies 5 -required 3 -format png -zip",
+	"padlock encode ~/Documents/secret ~/Collections -copies 3 -required 2 -chunk-names camera",
+	"padlock decode ~/Collections/subset ~/Restored -clear",
+	"padlock decode ~/Collections/subset ~/Documents/secret -on-conflict skip",
+	"padlock decode ~/Collections/subset -stdout -files docs/plan.txt | less",
+	"padlock ls ~/Collections/subset",
+	"padlock mount ~/Collections/subset /mnt/secret",
+	"padlock diagnose ~/Collections/subset",
+	"padlock checkcode /media/usb/3B5.zip -code K7QM-3XPA",
+	"padlock info -scan /media",
+	"padlock manifest ~/Collections -out inventory.json",
+	"padlock recover ~/Restored",
+	"padlock encode ~/Documents/top-secret ~/Collections -copies 5 -required 3 -verbose",
+	"padlock encode ~/Projects/app ~/Collections -exclude .git,node_modules,*.tmp",
+	"padlock encode ~/Archive ~/Collections -copies 3 -required 2 -volume dvd -zip",
+	"padlock encode ~/Documents/secret ~/Collections -copies 3 -required 2 -zip -zip-encrypt",
+	"padlock encode ~/Archive ~/Collections -copies 3 -required 2 -parity 10 -zip",
+	"padlock encode ~/Photos ~/Collections -copies 3 -required 2 -format packed",
+	"padlock encode ~/Documents/secret ~/Collections -copies 3 -required 2 -obfuscate -zip",
+	"padlock encode ~/Documents/secret ~/Collections -copies 3 -required 2 -archive tgz",
+	"padlock encode ~/Documents/secret ~/Collections -groups board:2of3,engineers:3of5",
+	"padlock encode ~/Documents/secret ~/Collections -custodians ceo:2,cfo,cto,counsel -required 3 -zip",
+	"padlock encode ~/Documents/secret -target /media/usb1,/media/usb2,/media/usb3 -required 2",
+	"padlock encode ~/Documents/secret -copies 3 -required 2 -out A=/mnt/usb1,B=/mnt/usb2,C=/mnt/usb3 -zip",
+	"padlock encode ~/Documents/secret ~/Collections -copies 3 -required 2 -labels ALPHA,BRAVO,CHARLIE -zip",
+	"padlock encode ~/Documents/secret ~/Collections -copies 3 -required 2 -metadata owner=alice,purpose=estate",
+	"padlock decode ~/Collections/subset ~/Restored -require-metadata owner=alice",
+	"padlock encode ~/Documents/secret ~/Collections -copies 3 -required 2 -not-after 2027-12-31 -policy \"rotate yearly\"",
+	"padlock ceremony ~/Documents/secret ~/Ceremony -custodians ceo,cfo,cto,counsel -required 3 -zip",
+	"padlock audit ~/padlock-ceremony-20261017-093000.json",
+	"padlock reshare ~/Collections/subset ~/NewCollections -copies 5 -required 3",
+	"padlock encode ~/Documents/secret ~/Collections -copies 3 -required 2 -snapshot 2026-10-17",
+	"padlock encode ~/Videos ~/Collections -copies 3 -required 2 -hybrid replicated -zip",
+	"padlock decode ~/Collections/subset ~/Restored -snapshot 2026-10-17",
+	"padlock refresh ~/Collections/all ~/Refreshed -zip",
+	"padlock pads /media/usb/pads -copies 3 -required 2 -size 500MB",
+	"padlock encode ~/Documents/secret ~/Collections -copies 3 -required 2 -entropy anu,drand -entropy-policy any",
+	"padlock encode ~/Documents/secret ~/Collections -copies 3 -required 2 -entropy drand -entropy-report ~/entropy.json",
+	"padlock encode ~/Documents/secret ~/Collections -pads /media/usb/pads -zip",
+	"padlock convert ~/Collections/3B5 ~/Converted -format png -archive none",
+	"padlock repair ~/Collections/all ~/Repaired -collection 2B3 -zip",
+	"printf %s 'master password' | padlock split-secret ~/Shares -copies 3 -required 2 -encoding paper",
+	"padlock join-secret ~/Shares/padlock-secret-2A3.txt ~/Shares/padlock-secret-2C3.txt",
+	"padlock serve -listen 127.0.0.1:8420",
+	"padlock watch ~/Documents/secret ~/Collections -copies 3 -required 2 -delay 1m",
+	"padlock encode ~/Documents/secret ~/Collections -copies 3 -required 2 -audit-log ~/padlock-audit.jsonl",
+	"padlock audit ~/padlock-audit.jsonl",
+	"padlock audit -manifest inventory.json -partial /media/usb1",
+	"padlock encode ~/Documents/secret ~/Collections -preset personal",
+	"padlock encode ~/Documents/secret ~/Collections -preset 3of5-bin-zip -parity 5",
+	"padlock scheme -copies 5 -required 3",
+	"padlock selftest",
+	"padlock tui",
+	"padlock completion bash > /etc/bash_completion.d/padlock",
+	"padlock docs man > /usr/local/share/man/man1/padlock.1",
+}
+
+// usageCommands lists the commands with their summaries for the usage text
+func usageCommands() string {
+	var b strings.Builder
+	for _, c := range commands {
+		fmt.Fprintf(&b, "  %-18s%s\n", c.name, c.summary)
+	}
+	return b.String()
+}
+
+// usageExitStatuses lists the exit codes for the usage text
+func usageExitStatuses() string {
+	var b strings.Builder
+	for _, e := range exitStatuses {
+		fmt.Fprintf(&b, "  %-4d%s\n", e.code, e.meaning)
+	}
+	return b.String()
+}
+
+// usageExamples lists the examples for the usage text
+func usageExamples() string {
+	var b strings.Builder
+	for _, example := range examples {
+		fmt.Fprintf(&b, "  %s\n", example)
+	}
+	return b.String()
 }
 
 // main is the entry point for the padlock command-line tool.
@@ -117,150 +408,1470 @@ func main() {
 		usage()
 	}
 
-	cmd := os.Args[1]
+	c := findCommand(os.Args[1])
+	if c == nil {
+		usage()
+	}
+	c.run(os.Args[2:])
+}
+
+// setupEncode returns the setup of encode or watch, which share their flags: it
+// registers the flags and returns the function that runs the command
+func setupEncode(cmd string) func(fs *flag.FlagSet) func(args []string) {
+	return func(fs *flag.FlagSet) func(args []string) {
+		nVal := fs.Int("copies", 2, "number of collections (must be between 2 and 26)")
+		reqVal := fs.Int("required", 2, "minimum collections required for reconstruction")
+		formatVal := fs.String("format", "png", "bin, png or packed (default: png)")
+		chunkNamesVal := fs.String("chunk-names", "", "template for chunk file names, e.g. DSC_%04d.PNG, or camera, phone or scan")
+		clearVal := fs.Bool("clear", false, "clear output directory if not empty")
+		chunkVal := fs.Int("chunk", 2*1024*1024, "maximum candidate block size in bytes (default: 2MB)")
+		verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+		logVal := addLogFlags(fs)
+		memoryVal := addMemoryFlags(fs)
+		offlineVal := addOfflineFlag(fs)
+		zipPasswordsVal := addZipPasswordFlags(fs, true)
+		tempVal := addTempFlags(fs)
+		archiveVal := addArchiveFlags(fs)
+		preserveVal := fs.String("preserve", "symlinks,owner,hardlinks,sparse", "attributes to archive: symlinks,owner,hardlinks,sparse,xattrs,special, all or none")
+		followVal := fs.Bool("follow-symlinks", false, "archive what symlinks point to instead of the links themselves")
+		oneFSVal := fs.Bool("one-file-system", false, "don't descend into directories on other file systems")
+		deterministicVal := fs.Bool("deterministic", false, "normalize timestamps and ownership for a reproducible archive stream")
+		hashesVal := fs.Bool("hashes", false, "record the SHA-256 of each file, which decode and verify-restore check restored files against")
+		volumeVal := fs.String("volume", "", "split each collection into volumes of at most this size (e.g. 4.7GB or dvd)")
+		var targetVal stringList
+		fs.Var(&targetVal, "target", "comma-separated `directories`, one per collection, to write and verify collections on")
+		outVal := fs.String("out", "", "comma-separated `LETTER=DIR` destinations, writing each collection named to its own directory, e.g. A=/mnt/usb1,B=/mnt/usb2")
+		var includeVal, excludeVal stringList
+		fs.Var(&includeVal, "include", "only encode entries matching these comma-separated glob patterns")
+		fs.Var(&excludeVal, "exclude", "skip entries matching these comma-separated glob patterns")
+		groupsVal := fs.String("groups", "", "hierarchical policy NAME:KofN,... requiring every group to reach its own threshold")
+		custodiansVal := fs.String("custodians", "", "custodians NAME[:WEIGHT],... each receiving one bundle of WEIGHT collections")
+		custodiansFileVal := fs.String("custodians-file", "", "JSON `file` naming the custodians with their contacts and recovery instructions")
+		parityVal := fs.Int("parity", 0, "percentage of parity added to each collection to rebuild damaged chunks")
+		padLengthVal := fs.Bool("pad-length", false, "pad every chunk to full size so collection sizes don't reveal the input length")
+		padChunksVal := fs.Int("pad-chunks", 0, "add a random number, up to this many, of empty chunks (implies -pad-length)")
+		decoyVal := fs.String("decoy", "", "`directory` encoded as a decoy, revealed by collections including a decoy collection")
+		decoyLettersVal := fs.String("decoy-collections", "", "with -decoy, the letters of the decoy collections, e.g. C or DE")
+		obfuscateVal := fs.Bool("obfuscate", false, "give collections and their files innocuous names that don't reveal the threshold")
+		storeVal := fs.String("store", "", "chunk store `directory`, shared by any number of collections, keeping their files by content hash")
+		snapshotVal := fs.String("snapshot", "", "append the input as a snapshot of this `name` to the set already in the output directory")
+		hybridVal := fs.String("hybrid", "", "encrypt the input with a random key, split only the key, and keep the encrypted data `shared|replicated`")
+		padsVal := fs.String("pads", "", "encode with the pads generated in this `directory` by the pads command, drawing no random numbers")
+		entropyVal := addEntropyFlags(fs)
+		randomnessVal := fs.String("randomness", "warn", "what to do about pads or chunks that fail the randomness checks: `off|warn|fail`")
+		verifyVal := fs.Bool("verify", false, "after writing, decode K of the collections and check that they reconstruct the input exactly")
+		shredVal := fs.Bool("shred-input", false, "once the collections are verified to restore the input, overwrite and remove the input files (not reliable on SSDs)")
+		entropyReportVal := fs.String("entropy-report", "", "write a report of the random sources, their health and the bytes each contributed to this JSON `file`")
+		notAfterVal := fs.String("not-after", "", "`DATE` after which the collections expire and are to be rotated, e.g. 2030-01-01")
+		policyVal := fs.String("policy", "", "`notes` on the use of the collections, such as their rotation schedule")
+		metadataVal := fs.String("metadata", "", "comma-separated `KEY=VALUE` pairs describing the set, recorded in the clear in every collection")
+		labelsVal := fs.String("labels", "", "label each collection for hand-labeled shares: friendly, or a `LIST` of one label per collection, e.g. ALPHA,BRAVO,CHARLIE")
+		decodersVal := addDecodersFlag(fs)
+		auditVal := addAuditFlags(fs)
+		addPresetFlag(fs)
+		delayVal := fs.Duration("delay", padlock.DefaultWatchDelay, "with watch, how long the input must be quiet before re-encoding")
+		recordVal := fs.String("record", "", "with ceremony, the `file` to write the signed ceremony record to")
+		signingKeyVal := fs.String("signing-key", "", "with ceremony, the Ed25519 private key `file` (PKCS #8 PEM) signing the record")
+
+		return func(args []string) {
+			// The last of several arguments is the output directory, which may
+			// be omitted when writing to targets
+			inputs, outputDir := args, ""
+			if len(args) > 1 {
+				inputs, outputDir = args[:len(args)-1], args[len(args)-1]
+			}
+			if cmd == "watch" && len(inputs) > 1 {
+				fatalf(exitUsage, "Error: watch takes a single input directory")
+			}
+			if cmd == "watch" && *snapshotVal != "" {
+				fatalf(exitUsage, "Error: watch cannot append snapshots")
+			}
+			if cmd == "watch" && *padsVal != "" {
+				fatalf(exitUsage, "Error: watch cannot use pads, which encode only once")
+			}
+			if cmd == "watch" && *shredVal {
+				fatalf(exitUsage, "Error: watch cannot shred the directory it watches")
+			}
+			if cmd == "ceremony" && (*custodiansVal == "" && *custodiansFileVal == "") {
+				fatalf(exitUsage, "Error: ceremony needs the custodians, with -custodians or -custodians-file")
+			}
+			if cmd == "ceremony" && (*padsVal != "" || *snapshotVal != "" || len(targetVal) > 0) {
+				fatalf(exitUsage, "Error: ceremony cannot be combined with -pads, -snapshot or -target")
+			}
+			if cmd != "ceremony" && (*recordVal != "" || *signingKeyVal != "") {
+				fatalf(exitUsage, "Error: -record and -signing-key are only for ceremony")
+			}
+
+			// Validate the inputs. A single directory is encoded as it is;
+			// several inputs, or a file, are each encoded under their names.
+			var inputDir string
+			for _, input := range inputs {
+				inputStat, err := os.Stat(input)
+				if err != nil {
+					if os.IsNotExist(err) {
+						fatalf(exitUsage, "Error: Input does not exist: %s", input)
+					}
+					fatalf(exitIO, "Error: Cannot access input %s: %v", input, err)
+				}
+				if len(inputs) == 1 && inputStat.IsDir() {
+					inputDir, inputs = input, nil
+				} else if cmd == "watch" {
+					fatalf(exitUsage, "Error: Input path is not a directory: %s", input)
+				}
+			}
+			if _, err := file.InputNames(inputs); err != nil {
+				fatalf(exitUsage, "Error: %v", err)
+			}
+
+			if cmd == "watch" && len(targetVal) > 0 {
+				fatalf(exitUsage, "Error: -target cannot be used with watch")
+			}
+
+			// With targets, the number of collections defaults to the number of targets
+			if len(targetVal) > 0 {
+				copiesSet := false
+				fs.Visit(func(f *flag.Flag) {
+					copiesSet = copiesSet || f.Name == "copies"
+				})
+				if !copiesSet {
+					*nVal = len(targetVal)
+				}
+				if *nVal != len(targetVal) {
+					fatalf(exitUsage, "Error: -target lists %d directories but -copies is %d; one target is needed per collection", len(targetVal), *nVal)
+				}
+			} else if outputDir == "" && *outVal == "" {
+				fatalf(exitUsage, "Error: An output directory is required unless -target or -out is given")
+			}
+			var destinations map[string]string
+			if *outVal != "" {
+				if cmd == "watch" || len(targetVal) > 0 {
+					fatalf(exitUsage, "Error: -out cannot be used with watch or -target")
+				}
+				var err error
+				if destinations, err = padlock.ParseDestinations(*outVal); err != nil {
+					fatalf(exitUsage, "Error: -out: %v", err)
+				}
+			}
+
+			// Pads determine the set and chunk size, so the set defaults to theirs
+			if *padsVal != "" {
+				m, err := file.ReadPadsManifest(context.Background(), *padsVal)
+				if err != nil {
+					fatalf(exitUsage, "Error: -pads: %v", err)
+				}
+				setFlags := make(map[string]bool)
+				fs.Visit(func(f *flag.Flag) {
+					setFlags[f.Name] = true
+				})
+				if !setFlags["copies"] {
+					*nVal = m.Copies
+				}
+				if !setFlags["required"] {
+					*reqVal = m.Required
+				}
+				if setFlags["chunk"] && *chunkVal != m.ChunkSize {
+					log.Printf("Warning: -chunk is ignored with -pads; the pads were generated with %d byte chunks", m.ChunkSize)
+				}
+				*chunkVal = m.ChunkSize
+			}
+
+			// In low-memory mode, chunks default to the largest it allows
+			if *memoryVal.lowMemory && *padsVal == "" {
+				chunkSet := false
+				fs.Visit(func(f *flag.Flag) {
+					chunkSet = chunkSet || f.Name == "chunk"
+				})
+				if !chunkSet {
+					*chunkVal = padlock.LowMemoryChunkSize
+				}
+			}
+
+			// With custodians, the number of collections is the sum of their weights
+			var custodians []padlock.Custodian
+			var instructions string
+			if *custodiansVal != "" && *custodiansFileVal != "" {
+				fatalf(exitUsage, "Error: -custodians and -custodians-file cannot be combined")
+			}
+			if *custodiansVal != "" {
+				var err error
+				if custodians, err = padlock.ParseCustodians(*custodiansVal); err != nil {
+					fatalf(exitUsage, "Error: -custodians: %v", err)
+				}
+			} else if *custodiansFileVal != "" {
+				f, err := padlock.ReadCustodiansFile(*custodiansFileVal)
+				if err != nil {
+					fatalf(exitUsage, "Error: -custodians-file: %v", err)
+				}
+				custodians, instructions = f.Custodians, f.Instructions
+			}
+			if len(custodians) > 0 {
+				if len(targetVal) > 0 || *groupsVal != "" || *volumeVal != "" {
+					fatalf(exitUsage, "Error: custodians cannot be combined with -target, -groups or -volume")
+				}
+				*nVal = 0
+				for _, c := range custodians {
+					*nVal += c.Weight
+				}
+			}
+
+			// Validate flags
+			if *nVal < 2 || *nVal > 26 {
+				fatalf(exitUsage, "Error: Number of collections (-copies) must be between 2 and 26, got %d", *nVal)
+			}
+			if *reqVal < 2 {
+				log.Printf("Warning: -required value %d is too small, using minimum value of 2", *reqVal)
+				*reqVal = 2
+			}
+			if *reqVal > *nVal {
+				log.Printf("Warning: -required value %d cannot be greater than number of collections (-copies) %d; adjusting to %d", *reqVal, *nVal, *nVal)
+				*reqVal = *nVal
+			}
+
+			serializeOpts, err := parsePreserveList(*preserveVal)
+			if err != nil {
+				fatalf(exitUsage, "Error: %v", err)
+			}
+			if err := file.ValidatePatterns(includeVal); err != nil {
+				fatalf(exitUsage, "Error: invalid -include pattern: %v", err)
+			}
+			if err := file.ValidatePatterns(excludeVal); err != nil {
+				fatalf(exitUsage, "Error: invalid -exclude pattern: %v", err)
+			}
+			serializeOpts.Include = includeVal
+			serializeOpts.Exclude = excludeVal
+			serializeOpts.FollowSymlinks = *followVal
+			serializeOpts.OneFileSystem = *oneFSVal
+			serializeOpts.Deterministic = *deterministicVal
+			serializeOpts.RecordHashes = *hashesVal
+
+			var groups []padlock.GroupPolicy
+			if *groupsVal != "" {
+				if groups, err = padlock.ParseGroupPolicies(*groupsVal); err != nil {
+					fatalf(exitUsage, "Error: -groups: %v", err)
+				}
+				if len(targetVal) > 0 {
+					fatalf(exitUsage, "Error: -groups cannot be combined with -target")
+				}
+			}
+
+			var volumeSize int64
+			if *volumeVal != "" {
+				if volumeSize, err = parseSize(*volumeVal); err != nil {
+					fatalf(exitUsage, "Error: -volume: %v", err)
+				}
+				if volumeSize <= int64(*chunkVal) {
+					fatalf(exitUsage, "Error: -volume %s must be larger than the chunk size (%d bytes)", *volumeVal, *chunkVal)
+				}
+			}
+
+			if *parityVal < 0 || *parityVal > 100 {
+				fatalf(exitUsage, "Error: -parity must be between 0 and 100 percent, got %d", *parityVal)
+			}
+			if *parityVal > 0 && *volumeVal != "" {
+				fatalf(exitUsage, "Error: -parity cannot be combined with -volume")
+			}
+			if *padChunksVal < 0 {
+				fatalf(exitUsage, "Error: -pad-chunks must not be negative, got %d", *padChunksVal)
+			}
+			if (*decoyVal == "") != (*decoyLettersVal == "") {
+				fatalf(exitUsage, "Error: -decoy and -decoy-collections must be given together")
+			}
+			if *decoyVal != "" && (len(custodians) > 0 || *groupsVal != "") {
+				fatalf(exitUsage, "Error: -decoy cannot be combined with -groups or custodians")
+			}
+			if *obfuscateVal && (*volumeVal != "" || len(targetVal) > 0 || len(custodians) > 0 || *groupsVal != "" || len(*decodersVal) > 0) {
+				fatalf(exitUsage, "Error: -obfuscate cannot be combined with -volume, -target, -groups, custodians or -decoders")
+			}
+			if len(*decodersVal) > 0 && *volumeVal != "" {
+				fatalf(exitUsage, "Error: -decoders cannot be combined with -volume")
+			}
+			var labels []string
+			if *labelsVal != "" {
+				if *groupsVal != "" || *snapshotVal != "" {
+					fatalf(exitUsage, "Error: -labels cannot be combined with -groups or -snapshot")
+				}
+				if labels, err = padlock.ParseLabels(*labelsVal, *nVal); err != nil {
+					fatalf(exitUsage, "Error: -labels: %v", err)
+				}
+			}
+			var metadata map[string]string
+			if *metadataVal != "" {
+				if metadata, err = padlock.ParseMetadata(*metadataVal); err != nil {
+					fatalf(exitUsage, "Error: -metadata: %v", err)
+				}
+			}
+			var notAfter time.Time
+			if *notAfterVal != "" {
+				if notAfter, err = padlock.ParseNotAfter(*notAfterVal); err != nil {
+					fatalf(exitUsage, "Error: -not-after: %v", err)
+				}
+			}
+
+			*formatVal = strings.ToLower(*formatVal)
+			if *formatVal != "bin" && *formatVal != "png" && *formatVal != "packed" {
+				fatalf(exitUsage, "Error: -format must be 'bin', 'png' or 'packed', got '%s'", *formatVal)
+			}
+
+			// Create config
+			format := padlock.FormatPNG
+			if *formatVal == "bin" {
+				format = padlock.FormatBin
+			} else if *formatVal == "packed" {
+				format = padlock.FormatPacked
+			}
+
+			var chunkNaming file.ChunkNaming
+			if *chunkNamesVal != "" {
+				if chunkNaming, err = file.ParseChunkNaming(*chunkNamesVal); err != nil {
+					fatalf(exitUsage, "Error: -chunk-names: %v", err)
+				}
+				if err := chunkNaming.CheckFormat(format); err != nil {
+					fatalf(exitUsage, "Error: -chunk-names: %v", err)
+				}
+			}
+
+			// Create context with tracer
+			ctx := context.Background()
+			log := logVal.tracer(*verboseVal)
+			ctx = trace.WithContext(ctx, log)
+			memoryVal.apply(log)
+			ctx = offlineVal.apply(ctx)
+			zipping, tarring := archiveVal.kind()
+			if *storeVal != "" && (zipping || tarring || *volumeVal != "" || len(targetVal) > 0 || len(custodians) > 0 || *obfuscateVal) {
+				fatalf(exitUsage, "Error: -store cannot be combined with -zip, -archive, -volume, -target, custodians or -obfuscate")
+			}
+			ctx = zipPasswordsVal.apply(ctx, zipping)
+			ctx = tempVal.apply(ctx, cmd != "watch")
 
-	switch cmd {
-	case "encode":
-		if len(os.Args) < 4 {
-			usage()
+			// Create RNG with the configured context, unless the pads hold all
+			// the random data the encode needs
+			var rng pad.RNG
+			if *padsVal == "" {
+				rng = entropyVal.rng(ctx, log)
+			} else if len(entropyVal.sources) > 0 {
+				fatalf(exitUsage, "Error: -entropy cannot be combined with -pads, which draw no random numbers")
+			}
+
+			cfg := padlock.EncodeConfig{
+				InputDir:        inputDir,
+				Inputs:          inputs,
+				OutputDir:       outputDir,
+				N:               *nVal,
+				K:               *reqVal,
+				Format:          format,
+				ChunkNaming:     chunkNaming,
+				ChunkSize:       *chunkVal,
+				RNG:             rng,
+				ClearIfNotEmpty: *clearVal,
+				Verbose:         *verboseVal,
+				Compression:     padlock.CompressionGzip,
+				ZipCollections:  zipping,
+				TarCollections:  tarring,
+				Serialize:       serializeOpts,
+				VolumeSize:      volumeSize,
+				Targets:         targetVal,
+				Destinations:    destinations,
+				Groups:          groups,
+				Custodians:      custodians,
+				Instructions:    instructions,
+				ParityPercent:   *parityVal,
+				Audit:           auditVal.open(outputDir),
+				PadLength:       *padLengthVal || *padChunksVal > 0,
+				PadChunks:       *padChunksVal,
+				DecoyDir:        *decoyVal,
+				DecoyLetters:    *decoyLettersVal,
+				ObfuscateNames:  *obfuscateVal,
+				StoreDir:        *storeVal,
+				Decoders:        loadDecoders(ctx, *decodersVal),
+				Snapshot:        *snapshotVal,
+				Hybrid:          padlock.HybridMode(*hybridVal),
+				LowMemory:       *memoryVal.lowMemory,
+				PadsDir:         *padsVal,
+				Labels:          labels,
+				Metadata:        metadata,
+				NotAfter:        notAfter,
+				PolicyNotes:     *policyVal,
+				EntropyReport:   *entropyReportVal,
+				Randomness:      padlock.RandomnessPolicy(*randomnessVal),
+				Verify:          *verifyVal,
+				ShredInput:      *shredVal,
+			}
+
+			// Check the configuration as a whole before doing any work
+			if err := cfg.Validate(ctx); err != nil {
+				log.FatalCode(err, exitCode(err))
+			}
+
+			// Watch the directory, encoding a new set on each change until interrupted
+			if cmd == "watch" {
+				ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				if err := padlock.WatchDirectory(ctx, padlock.WatchConfig{Encode: cfg, Delay: *delayVal}); err != nil {
+					log.FatalCode(fmt.Errorf("watch failed: %w", err), exitCode(err))
+				}
+				return
+			}
+
+			// Generate the shares in front of their custodians, each confirming receipt
+			if cmd == "ceremony" {
+				ceremony := padlock.CeremonyConfig{
+					Encode:     cfg,
+					RecordPath: *recordVal,
+					Input:      os.Stdin,
+					Output:     os.Stdout,
+				}
+				if *signingKeyVal != "" {
+					if ceremony.SigningKey, err = padlock.ReadSigningKey(*signingKeyVal); err != nil {
+						fatalf(exitCode(err), "Error: -signing-key: %v", err)
+					}
+				}
+				if _, err := padlock.RunCeremony(ctx, ceremony); err != nil {
+					log.FatalCode(fmt.Errorf("ceremony failed: %w", err), exitCode(err))
+				}
+				return
+			}
+
+			// Encode the directory
+			if err := padlock.EncodeDirectory(ctx, cfg); err != nil {
+				log.FatalCode(fmt.Errorf("encode failed: %w", err), exitCode(err))
+			}
 		}
+	}
+}
 
-		inputDir := os.Args[2]
-		outputDir := os.Args[3]
+// setupDecode registers the flags of decode and returns the function that runs it
+func setupDecode(fs *flag.FlagSet) func(args []string) {
+	clearVal := fs.Bool("clear", false, "clear output directory if not empty")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, false)
+	tempVal := addTempFlags(fs)
+	restoreVal := fs.String("restore", "symlinks,perms,mtime", "attributes to restore: symlinks,perms,mtime,owner,xattrs,special, all or none")
+	conflictVal := fs.String("on-conflict", "", "how to handle existing files: overwrite, skip, rename or error")
+	stdoutVal := fs.Bool("stdout", false, "write the contents of the selected files to standard output")
+	strictVal := fs.Bool("strict", false, "fail rather than fall back when collections or the decoded data are not exactly as expected")
+	snapshotVal := fs.String("snapshot", "", "decode the snapshot of this `name` rather than the latest")
+	enforcePolicyVal := fs.Bool("enforce-policy", false, "refuse collections used after the expiry recorded in them, rather than warning")
+	requireMetadataVal := fs.String("require-metadata", "", "refuse collections that don't all record these comma-separated `KEY=VALUE` pairs")
+	var filesVal stringList
+	fs.Var(&filesVal, "files", "only restore entries matching these comma-separated glob patterns")
+	auditVal := addAuditFlags(fs)
+
+	return func(args []string) {
+		// The output directory may be omitted when extracting to standard output
+		inputDir, outputDir := args[0], arg(args, 1)
 
 		// Validate input directory
 		inputStat, err := os.Stat(inputDir)
 		if err != nil {
 			if os.IsNotExist(err) {
-				log.Fatalf("Error: Input directory does not exist: %s", inputDir)
+				fatalf(exitUsage, "Error: Input directory does not exist: %s", inputDir)
 			}
-			log.Fatalf("Error: Cannot access input directory %s: %v", inputDir, err)
+			fatalf(exitIO, "Error: Cannot access input directory %s: %v", inputDir, err)
 		}
+		// Input must be a directory for decoding
 		if !inputStat.IsDir() {
-			log.Fatalf("Error: Input path is not a directory: %s", inputDir)
+			fatalf(exitUsage, "Error: Input path is not a directory: %s. The input should be a directory containing collection subdirectories or ZIP files.", inputDir)
 		}
 
-		// Parse flags
-		fs := flag.NewFlagSet("encode", flag.ExitOnError)
-		nVal := fs.Int("copies", 2, "number of collections (must be between 2 and 26)")
-		reqVal := fs.Int("required", 2, "minimum collections required for reconstruction")
-		formatVal := fs.String("format", "png", "bin or png (default: png)")
-		clearVal := fs.Bool("clear", false, "clear output directory if not empty")
-		chunkVal := fs.Int("chunk", 2*1024*1024, "maximum candidate block size in bytes (default: 2MB)")
-		verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
-		zipVal := fs.Bool("zip", false, "create zip files for each collection instead of directories")
-		fs.Parse(os.Args[4:])
+		if outputDir == "" && !*stdoutVal {
+			fatalf(exitUsage, "Error: An output directory is required unless -stdout is given")
+		}
+		if err := file.ValidatePatterns(filesVal); err != nil {
+			fatalf(exitUsage, "Error: invalid -files pattern: %v", err)
+		}
+
+		deserializeOpts, err := parseRestoreList(*restoreVal)
+		if err != nil {
+			fatalf(exitUsage, "Error: %v", err)
+		}
+		deserializeOpts.OnConflict, err = file.ParseConflictPolicy(*conflictVal)
+		if err != nil {
+			fatalf(exitUsage, "Error: -on-conflict: %v", err)
+		}
+		deserializeOpts.Files = filesVal
+		var requireMetadata map[string]string
+		if *requireMetadataVal != "" {
+			if requireMetadata, err = padlock.ParseMetadata(*requireMetadataVal); err != nil {
+				fatalf(exitUsage, "Error: -require-metadata: %v", err)
+			}
+		}
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		ctx = zipPasswordsVal.apply(ctx, false)
+		ctx = tempVal.apply(ctx, true)
+
+		// Create RNG with the configured context
+		rng := pad.NewDefaultRand(ctx)
+
+		// Create config
+		cfg := padlock.DecodeConfig{
+			InputDir:        inputDir,
+			OutputDir:       outputDir,
+			RNG:             rng,
+			Verbose:         *verboseVal,
+			Compression:     padlock.CompressionGzip,
+			ClearIfNotEmpty: *clearVal,
+			Deserialize:     deserializeOpts,
+			Strict:          *strictVal,
+			Audit:           auditVal.open(outputDir),
+			Snapshot:        *snapshotVal,
+			TempDir:         *tempVal.dir,
+			KeepTemp:        *tempVal.keep,
+			RequireMetadata: requireMetadata,
+			EnforcePolicy:   *enforcePolicyVal,
+		}
+		if *stdoutVal {
+			cfg.OutputWriter = os.Stdout
+		}
+		if err := cfg.Validate(ctx); err != nil {
+			log.FatalCode(err, exitCode(err))
+		}
+
+		// Decode the directory
+		if err := padlock.DecodeDirectory(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("decode failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupList registers the flags of ls and returns the function that runs it
+func setupList(fs *flag.FlagSet) func(args []string) {
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, false)
+	tempVal := addTempFlags(fs)
+	snapshotVal := fs.String("snapshot", "", "list the snapshot of this `name` rather than the latest")
+	snapshotsVal := fs.Bool("snapshots", false, "list the snapshots of the set instead of its files")
+
+	return func(args []string) {
+		inputDir := args[0]
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		ctx = zipPasswordsVal.apply(ctx, false)
+		ctx = tempVal.apply(ctx, true)
+
+		// Create config
+		cfg := padlock.ListConfig{
+			InputDir:    inputDir,
+			Verbose:     *verboseVal,
+			Compression: padlock.CompressionGzip,
+			Output:      os.Stdout,
+			Snapshot:    *snapshotVal,
+		}
+
+		// List the snapshots recorded in the collections, oldest first
+		if *snapshotsVal {
+			snapshots, err := padlock.ListSnapshots(ctx, inputDir)
+			if err != nil {
+				log.FatalCode(fmt.Errorf("ls failed: %w", err), exitCode(err))
+			}
+			if len(snapshots) == 0 {
+				fmt.Println("No snapshots; the set holds a single encode")
+			}
+			for _, s := range snapshots {
+				fmt.Printf("%-20s %s  chunks %d-%d\n", s.Name, s.Created.Local().Format(time.DateTime), s.FirstChunk, s.LastChunk)
+			}
+			return
+		}
+
+		// List the collections
+		if err := padlock.ListCollections(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("ls failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupVerifyRestore registers the flags of verify-restore and returns the function that runs it
+func setupVerifyRestore(fs *flag.FlagSet) func(args []string) {
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, false)
+	tempVal := addTempFlags(fs)
+	var filesVal stringList
+	fs.Var(&filesVal, "files", "only verify entries matching these comma-separated glob patterns, as restored with decode -files")
+	snapshotVal := fs.String("snapshot", "", "verify against the snapshot of this `name` rather than the latest")
+
+	return func(args []string) {
+		inputDir, outputDir := args[0], args[1]
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		ctx = zipPasswordsVal.apply(ctx, false)
+		ctx = tempVal.apply(ctx, true)
+
+		cfg := padlock.VerifyRestoreConfig{
+			InputDir:    inputDir,
+			OutputDir:   outputDir,
+			Verbose:     *verboseVal,
+			Compression: padlock.CompressionGzip,
+			Files:       filesVal,
+			Snapshot:    *snapshotVal,
+		}
+		if err := padlock.VerifyRestore(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("verify-restore failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupMount registers the flags of mount and returns the function that runs it
+func setupMount(fs *flag.FlagSet) func(args []string) {
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, false)
+	tempVal := addTempFlags(fs)
+
+	return func(args []string) {
+		inputDir, mountpoint := args[0], args[1]
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		ctx = zipPasswordsVal.apply(ctx, false)
+		ctx = tempVal.apply(ctx, false)
+
+		// Create config
+		cfg := padlock.MountConfig{
+			InputDir:    inputDir,
+			Mountpoint:  mountpoint,
+			Verbose:     *verboseVal,
+			Compression: padlock.CompressionGzip,
+		}
+
+		// Serve the mount until interrupted
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := padlock.MountCollections(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("mount failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupDiagnose registers the flags of diagnose and returns the function that runs it
+func setupDiagnose(fs *flag.FlagSet) func(args []string) {
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, false)
+	tempVal := addTempFlags(fs)
+
+	return func(args []string) {
+		inputDir := args[0]
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		ctx = zipPasswordsVal.apply(ctx, false)
+		ctx = tempVal.apply(ctx, true)
+
+		// Create config
+		cfg := padlock.DiagnoseConfig{
+			InputDir: inputDir,
+			Verbose:  *verboseVal,
+			Output:   os.Stdout,
+		}
+
+		// Diagnose the collections
+		if err := padlock.DiagnoseCollections(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("diagnose failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupCheckCode registers the flags of checkcode and returns the function that runs it
+func setupCheckCode(fs *flag.FlagSet) func(args []string) {
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, false)
+	tempVal := addTempFlags(fs)
+	codeVal := fs.String("code", "", "the verification `code` issued with the collection, to compare with its code")
+
+	return func(args []string) {
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		ctx = zipPasswordsVal.apply(ctx, false)
+		ctx = tempVal.apply(ctx, true)
+
+		cfg := padlock.CheckCodeConfig{
+			Path:   args[0],
+			Code:   *codeVal,
+			Output: os.Stdout,
+		}
+		if err := padlock.CheckCode(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("checkcode failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupInfo registers the flags of info and returns the function that runs it
+func setupInfo(fs *flag.FlagSet) func(args []string) {
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, false)
+	tempVal := addTempFlags(fs)
+	var scanVal stringList
+	fs.Var(&scanVal, "scan", "comma-separated `directories` to search for collections")
+
+	return func(args []string) {
+		// Directories to search may be given before the flags
+		roots := args
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		ctx = zipPasswordsVal.apply(ctx, false)
+		ctx = tempVal.apply(ctx, true)
+
+		// Create config
+		cfg := padlock.InfoConfig{
+			Roots:   append(roots, scanVal...),
+			Verbose: *verboseVal,
+			Output:  os.Stdout,
+		}
+
+		// List the collections found
+		if err := padlock.CollectionInfo(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("info failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupRecover registers the flags of recover and returns the function that runs it
+func setupRecover(fs *flag.FlagSet) func(args []string) {
+	clearVal := fs.Bool("clear", false, "clear output directory if not empty")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, false)
+	tempVal := addTempFlags(fs)
+	restoreVal := fs.String("restore", "symlinks,perms,mtime", "attributes to restore: symlinks,perms,mtime,owner,xattrs,special, all or none")
+	var scanVal stringList
+	fs.Var(&scanVal, "scan", "comma-separated `directories` to search for collections")
+
+	return func(args []string) {
+		// The output directory is asked for if it isn't given
+		outputDir := arg(args, 0)
+
+		deserializeOpts, err := parseRestoreList(*restoreVal)
+		if err != nil {
+			fatalf(exitUsage, "Error: %v", err)
+		}
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		ctx = zipPasswordsVal.apply(ctx, false)
+		ctx = tempVal.apply(ctx, true)
+
+		// Create config
+		cfg := padlock.RecoverConfig{
+			Roots:           scanVal,
+			OutputDir:       outputDir,
+			Compression:     padlock.CompressionGzip,
+			ClearIfNotEmpty: *clearVal,
+			Deserialize:     deserializeOpts,
+			Verbose:         *verboseVal,
+			Input:           os.Stdin,
+			Output:          os.Stdout,
+		}
+
+		// Guide the user through the recovery
+		if err := padlock.RecoverInteractive(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("recover failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupTUI registers the flags of tui and returns the function that runs it
+func setupTUI(fs *flag.FlagSet) func(args []string) {
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, false)
+	tempVal := addTempFlags(fs)
+	preserveVal := fs.String("preserve", "symlinks,owner,hardlinks,sparse", "attributes to archive: symlinks,owner,hardlinks,sparse,xattrs,special, all or none")
+	restoreVal := fs.String("restore", "symlinks,perms,mtime", "attributes to restore: symlinks,perms,mtime,owner,xattrs,special, all or none")
+	entropyVal := addEntropyFlags(fs)
+	var scanVal stringList
+	fs.Var(&scanVal, "scan", "comma-separated `directories` to search for collections")
+
+	return func(args []string) {
+		serializeOpts, err := parsePreserveList(*preserveVal)
+		if err != nil {
+			fatalf(exitUsage, "Error: %v", err)
+		}
+		deserializeOpts, err := parseRestoreList(*restoreVal)
+		if err != nil {
+			fatalf(exitUsage, "Error: %v", err)
+		}
+
+		// Only errors are logged unless asked for, as the log would break up the screens
+		if *logVal.levels == "" && !*verboseVal {
+			*logVal.levels = "error"
+		}
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		ctx = zipPasswordsVal.apply(ctx, false)
+		ctx = tempVal.apply(ctx, false)
+
+		// Screens are cleared and progress redrawn in place only on a terminal
+		// that understands ANSI escapes, which the Windows console may not
+		ansi := false
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			ansi = os.Getenv("TERM") != "dumb" && runtime.GOOS != "windows"
+		}
+
+		err = padlock.RunTUI(ctx, padlock.TUIConfig{
+			Roots:       scanVal,
+			Compression: padlock.CompressionGzip,
+			RNG:         entropyVal.rng(ctx, log),
+			Serialize:   serializeOpts,
+			Deserialize: deserializeOpts,
+			Input:       os.Stdin,
+			Output:      os.Stdout,
+			ANSI:        ansi,
+		})
+		if err != nil {
+			log.FatalCode(fmt.Errorf("tui failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupReshare registers the flags of reshare and returns the function that runs it
+func setupReshare(fs *flag.FlagSet) func(args []string) {
+	nVal := fs.Int("copies", 2, "number of new collections (must be between 2 and 26)")
+	reqVal := fs.Int("required", 2, "minimum new collections required for reconstruction")
+	formatVal := fs.String("format", "png", "bin, png or packed (default: png)")
+	clearVal := fs.Bool("clear", false, "clear output directory if not empty")
+	chunkVal := fs.Int("chunk", 2*1024*1024, "maximum candidate block size in bytes (default: 2MB)")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, true)
+	tempVal := addTempFlags(fs)
+	archiveVal := addArchiveFlags(fs)
+	volumeVal := fs.String("volume", "", "split each collection into volumes of at most this size (e.g. 4.7GB or dvd)")
+	var targetVal stringList
+	fs.Var(&targetVal, "target", "comma-separated `directories`, one per collection, to write and verify collections on")
+	decodersVal := addDecodersFlag(fs)
+	entropyVal := addEntropyFlags(fs)
+	addPresetFlag(fs)
+
+	return func(args []string) {
+		// The output directory may be omitted when writing to targets
+		inputDir, outputDir := args[0], arg(args, 1)
+
+		// With targets, the number of collections defaults to the number of targets
+		if len(targetVal) > 0 {
+			copiesSet := false
+			fs.Visit(func(f *flag.Flag) {
+				copiesSet = copiesSet || f.Name == "copies"
+			})
+			if !copiesSet {
+				*nVal = len(targetVal)
+			}
+			if *nVal != len(targetVal) {
+				fatalf(exitUsage, "Error: -target lists %d directories but -copies is %d; one target is needed per collection", len(targetVal), *nVal)
+			}
+		} else if outputDir == "" {
+			fatalf(exitUsage, "Error: An output directory is required unless -target is given")
+		}
 
 		// Validate flags
 		if *nVal < 2 || *nVal > 26 {
-			log.Fatalf("Error: Number of collections (-copies) must be between 2 and 26, got %d", *nVal)
+			fatalf(exitUsage, "Error: Number of collections (-copies) must be between 2 and 26, got %d", *nVal)
 		}
-		if *reqVal < 2 {
-			log.Printf("Warning: -required value %d is too small, using minimum value of 2", *reqVal)
-			*reqVal = 2
+		if *reqVal < 2 || *reqVal > *nVal {
+			fatalf(exitUsage, "Error: -required must be between 2 and the number of collections (-copies) %d, got %d", *nVal, *reqVal)
 		}
-		if *reqVal > *nVal {
-			log.Printf("Warning: -required value %d cannot be greater than number of collections (-copies) %d; adjusting to %d", *reqVal, *nVal, *nVal)
-			*reqVal = *nVal
+
+		var volumeSize int64
+		if *volumeVal != "" {
+			var err error
+			if volumeSize, err = parseSize(*volumeVal); err != nil {
+				fatalf(exitUsage, "Error: -volume: %v", err)
+			}
+			if volumeSize <= int64(*chunkVal) {
+				fatalf(exitUsage, "Error: -volume %s must be larger than the chunk size (%d bytes)", *volumeVal, *chunkVal)
+			}
+		}
+		if len(*decodersVal) > 0 && *volumeVal != "" {
+			fatalf(exitUsage, "Error: -decoders cannot be combined with -volume")
 		}
 
 		*formatVal = strings.ToLower(*formatVal)
-		if *formatVal != "bin" && *formatVal != "png" {
-			log.Fatalf("Error: -format must be 'bin' or 'png', got '%s'", *formatVal)
+		if *formatVal != "bin" && *formatVal != "png" && *formatVal != "packed" {
+			fatalf(exitUsage, "Error: -format must be 'bin', 'png' or 'packed', got '%s'", *formatVal)
 		}
-
-		// Create config
 		format := padlock.FormatPNG
 		if *formatVal == "bin" {
 			format = padlock.FormatBin
+		} else if *formatVal == "packed" {
+			format = padlock.FormatPacked
 		}
 
 		// Create context with tracer
 		ctx := context.Background()
-		logLevel := trace.LogLevelNormal
-		if *verboseVal {
-			logLevel = trace.LogLevelVerbose
-		}
-		log := trace.NewTracer("MAIN", logLevel)
+		log := logVal.tracer(*verboseVal)
 		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		zipping, tarring := archiveVal.kind()
+		ctx = zipPasswordsVal.apply(ctx, zipping)
+		ctx = tempVal.apply(ctx, true)
 
-		// Create RNG with the configured context
-		rng := pad.NewDefaultRand(ctx)
-
-		cfg := padlock.EncodeConfig{
+		cfg := padlock.ReshareConfig{
 			InputDir:        inputDir,
 			OutputDir:       outputDir,
 			N:               *nVal,
 			K:               *reqVal,
 			Format:          format,
 			ChunkSize:       *chunkVal,
-			RNG:             rng,
+			RNG:             entropyVal.rng(ctx, log),
 			ClearIfNotEmpty: *clearVal,
 			Verbose:         *verboseVal,
-			Compression:     padlock.CompressionGzip,
-			ZipCollections:  *zipVal,
+			ZipCollections:  zipping,
+			TarCollections:  tarring,
+			VolumeSize:      volumeSize,
+			Targets:         targetVal,
+			Decoders:        loadDecoders(ctx, *decodersVal),
 		}
 
-		// Encode the directory
-		if err := padlock.EncodeDirectory(ctx, cfg); err != nil {
-			log.Fatal(fmt.Errorf("encode failed: %w", err))
+		// Reshare the collections
+		if err := padlock.ReshareCollections(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("reshare failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupRefresh registers the flags of refresh and returns the function that runs it
+func setupRefresh(fs *flag.FlagSet) func(args []string) {
+	clearVal := fs.Bool("clear", false, "clear output directory if not empty")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, true)
+	tempVal := addTempFlags(fs)
+	archiveVal := addArchiveFlags(fs)
+	entropyVal := addEntropyFlags(fs)
+
+	return func(args []string) {
+		inputDir, outputDir := args[0], args[1]
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		zipping, tarring := archiveVal.kind()
+		ctx = zipPasswordsVal.apply(ctx, zipping)
+		ctx = tempVal.apply(ctx, true)
+
+		cfg := padlock.RefreshConfig{
+			InputDir:        inputDir,
+			OutputDir:       outputDir,
+			RNG:             entropyVal.rng(ctx, log),
+			ClearIfNotEmpty: *clearVal,
+			Verbose:         *verboseVal,
+			ZipCollections:  zipping,
+			TarCollections:  tarring,
 		}
 
-	case "decode":
-		if len(os.Args) < 4 {
-			usage()
+		// Refresh the collections
+		if err := padlock.RefreshCollections(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("refresh failed: %w", err), exitCode(err))
 		}
+	}
+}
 
-		inputDir := os.Args[2]
-		outputDir := os.Args[3]
+// setupPads registers the flags of pads and returns the function that runs it
+func setupPads(fs *flag.FlagSet) func(args []string) {
+	nVal := fs.Int("copies", 2, "number of collections of the encode (must be between 2 and 26)")
+	reqVal := fs.Int("required", 2, "minimum collections required for reconstruction")
+	formatVal := fs.String("format", "bin", "bin or png (default: bin)")
+	chunkNamesVal := fs.String("chunk-names", "", "template for chunk file names, e.g. DSC_%04d.PNG, or camera, phone or scan")
+	chunkVal := fs.Int("chunk", 2*1024*1024, "chunk size in bytes, used by the encode the pads are for (default: 2MB)")
+	sizeVal := fs.String("size", "", "size of the input the pads must be able to encode once archived and compressed (e.g. 100MB)")
+	clearVal := fs.Bool("clear", false, "clear output directory if not empty")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	entropyVal := addEntropyFlags(fs)
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
 
-		// Validate input directory
-		inputStat, err := os.Stat(inputDir)
-		if err != nil {
-			if os.IsNotExist(err) {
-				log.Fatalf("Error: Input directory does not exist: %s", inputDir)
+	return func(args []string) {
+		outputDir := args[0]
+
+		if *sizeVal == "" {
+			fatalf(exitUsage, "Error: -size is required")
+		}
+		size, err := parseSize(*sizeVal)
+		if err != nil || size <= 0 {
+			fatalf(exitUsage, "Error: -size must be a positive size, got '%s'", *sizeVal)
+		}
+		if *nVal < 2 || *nVal > 26 {
+			fatalf(exitUsage, "Error: Number of collections (-copies) must be between 2 and 26, got %d", *nVal)
+		}
+		if *reqVal < 2 || *reqVal > *nVal {
+			fatalf(exitUsage, "Error: -required must be between 2 and -copies (%d), got %d", *nVal, *reqVal)
+		}
+		var format padlock.Format
+		switch strings.ToLower(*formatVal) {
+		case "bin":
+			format = padlock.FormatBin
+		case "png":
+			format = padlock.FormatPNG
+		default:
+			fatalf(exitUsage, "Error: -format must be 'bin' or 'png', got '%s'", *formatVal)
+		}
+		var chunkNaming file.ChunkNaming
+		if *chunkNamesVal != "" {
+			if chunkNaming, err = file.ParseChunkNaming(*chunkNamesVal); err != nil {
+				fatalf(exitUsage, "Error: -chunk-names: %v", err)
+			}
+			if err := chunkNaming.CheckFormat(format); err != nil {
+				fatalf(exitUsage, "Error: -chunk-names: %v", err)
 			}
-			log.Fatalf("Error: Cannot access input directory %s: %v", inputDir, err)
 		}
-		// Input must be a directory for decoding
-		if !inputStat.IsDir() {
-			log.Fatalf("Error: Input path is not a directory: %s. The input should be a directory containing collection subdirectories or ZIP files.", inputDir)
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+
+		cfg := padlock.PadsConfig{
+			OutputDir:       outputDir,
+			N:               *nVal,
+			K:               *reqVal,
+			Format:          format,
+			ChunkNaming:     chunkNaming,
+			ChunkSize:       *chunkVal,
+			Size:            size,
+			RNG:             entropyVal.rng(ctx, log),
+			ClearIfNotEmpty: *clearVal,
+			Verbose:         *verboseVal,
 		}
 
-		// Parse flags
-		fs := flag.NewFlagSet("decode", flag.ExitOnError)
-		clearVal := fs.Bool("clear", false, "clear output directory if not empty")
-		verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
-		fs.Parse(os.Args[4:])
+		// Generate the pads
+		if err := padlock.GeneratePads(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("pad generation failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupConvert registers the flags of convert and returns the function that runs it
+func setupConvert(fs *flag.FlagSet) func(args []string) {
+	formatVal := fs.String("format", "", "bin, png or packed (default: keep each collection's format)")
+	chunkNamesVal := fs.String("chunk-names", "", "template for chunk file names, e.g. DSC_%04d.PNG, or camera, phone or scan")
+	clearVal := fs.Bool("clear", false, "clear output directory if not empty")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, true)
+	tempVal := addTempFlags(fs)
+	archiveVal := addArchiveFlags(fs)
+
+	return func(args []string) {
+		inputDir, outputDir := args[0], args[1]
+
+		var format padlock.Format
+		switch strings.ToLower(*formatVal) {
+		case "":
+		case "bin":
+			format = padlock.FormatBin
+		case "png":
+			format = padlock.FormatPNG
+		case "packed":
+			format = padlock.FormatPacked
+		default:
+			fatalf(exitUsage, "Error: -format must be 'bin', 'png' or 'packed', got '%s'", *formatVal)
+		}
+		var chunkNaming file.ChunkNaming
+		if *chunkNamesVal != "" {
+			if format == "" {
+				fatalf(exitUsage, "Error: -chunk-names needs -format")
+			}
+			var err error
+			if chunkNaming, err = file.ParseChunkNaming(*chunkNamesVal); err != nil {
+				fatalf(exitUsage, "Error: -chunk-names: %v", err)
+			}
+			if err := chunkNaming.CheckFormat(format); err != nil {
+				fatalf(exitUsage, "Error: -chunk-names: %v", err)
+			}
+		}
 
 		// Create context with tracer
 		ctx := context.Background()
-		logLevel := trace.LogLevelNormal
-		if *verboseVal {
-			logLevel = trace.LogLevelVerbose
-		}
-		log := trace.NewTracer("MAIN", logLevel)
+		log := logVal.tracer(*verboseVal)
 		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		zipping, tarring := archiveVal.kind()
+		ctx = zipPasswordsVal.apply(ctx, zipping)
+		ctx = tempVal.apply(ctx, true)
 
-		// Create RNG with the configured context
-		rng := pad.NewDefaultRand(ctx)
-
-		// Create config
-		cfg := padlock.DecodeConfig{
+		cfg := padlock.ConvertConfig{
 			InputDir:        inputDir,
 			OutputDir:       outputDir,
-			RNG:             rng,
+			Format:          format,
+			ChunkNaming:     chunkNaming,
+			ClearIfNotEmpty: *clearVal,
 			Verbose:         *verboseVal,
-			Compression:     padlock.CompressionGzip,
+			ZipCollections:  zipping,
+			TarCollections:  tarring,
+		}
+
+		// Convert the collections
+		if err := padlock.ConvertCollections(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("convert failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupRepair registers the flags of repair and returns the function that runs it
+func setupRepair(fs *flag.FlagSet) func(args []string) {
+	collectionVal := fs.String("collection", "", "`name` of the damaged collection to repair, e.g. 2B3")
+	clearVal := fs.Bool("clear", false, "clear output directory if not empty")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, true)
+	tempVal := addTempFlags(fs)
+	archiveVal := addArchiveFlags(fs)
+
+	return func(args []string) {
+		inputDir, outputDir := args[0], args[1]
+		if *collectionVal == "" {
+			fatalf(exitUsage, "Error: -collection must name the damaged collection, e.g. -collection 2B3")
+		}
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		zipping, tarring := archiveVal.kind()
+		ctx = zipPasswordsVal.apply(ctx, zipping)
+		ctx = tempVal.apply(ctx, true)
+
+		cfg := padlock.RepairConfig{
+			InputDir:        inputDir,
+			OutputDir:       outputDir,
+			Collection:      strings.ToUpper(*collectionVal),
 			ClearIfNotEmpty: *clearVal,
+			Verbose:         *verboseVal,
+			ZipCollections:  zipping,
+			TarCollections:  tarring,
 		}
 
-		// Decode the directory
-		if err := padlock.DecodeDirectory(ctx, cfg); err != nil {
-			log.Fatal(fmt.Errorf("decode failed: %w", err))
+		// Repair the collection
+		if err := padlock.RepairCollection(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("repair failed: %w", err), exitCode(err))
 		}
+	}
+}
 
-	default:
-		usage()
+// setupServe registers the flags of serve and returns the function that runs it
+func setupServe(fs *flag.FlagSet) func(args []string) {
+	listenVal := fs.String("listen", server.DefaultAddr, "address to listen on")
+	maxBytesVal := fs.String("max-bytes", "64MiB", "largest request body to accept")
+	chunkVal := fs.Int("chunk", 2*1024*1024, "maximum chunk size in bytes of the collection streams encoded (default: 2MB)")
+	auditLogVal := fs.String("audit-log", "", "record every encode, decode and verify request in this audit log `file`")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	entropyVal := addEntropyFlags(fs)
+
+	return func(args []string) {
+		maxBytes, err := parseSize(*maxBytesVal)
+		if err != nil {
+			fatalf(exitUsage, "Error: -max-bytes: %v", err)
+		}
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		if *offlineVal.offline && !isLoopback(*listenVal) {
+			fatalf(exitUsage, "Error: -offline: serve can only listen on a loopback address, such as 127.0.0.1:8420, not %s", *listenVal)
+		}
+
+		// In low-memory mode, chunks default to the largest it allows
+		if *memoryVal.lowMemory {
+			chunkSet := false
+			fs.Visit(func(f *flag.Flag) {
+				chunkSet = chunkSet || f.Name == "chunk"
+			})
+			if !chunkSet {
+				*chunkVal = padlock.LowMemoryChunkSize
+			}
+		}
+
+		// Run the service
+		cfg := server.Config{
+			Addr:      *listenVal,
+			MaxBytes:  maxBytes,
+			ChunkSize: *chunkVal,
+			RNG:       entropyVal.rng(ctx, log),
+		}
+		if *auditLogVal != "" {
+			if cfg.Audit, err = audit.Open(*auditLogVal); err != nil {
+				log.FatalCode(fmt.Errorf("serve failed: %w", err), exitCode(err))
+			}
+		}
+		if err := server.ListenAndServe(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("serve failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupAudit returns the function that runs audit, which has no flags
+func setupAudit(fs *flag.FlagSet) func(args []string) {
+	manifestVal := fs.String("manifest", "", "check the collections at the path given against this set manifest `file`, as exported by padlock manifest")
+	partialVal := fs.Bool("partial", false, "with -manifest, the path holds only some of the collections, so those missing are not a failure")
+	jsonVal := fs.Bool("json", false, "with -manifest, write the report as JSON")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, false)
+	tempVal := addTempFlags(fs)
+
+	return func(args []string) {
+		logPath := args[0]
+
+		// Check the collections at the path against the manifest of their set
+		if *manifestVal != "" {
+			ctx := context.Background()
+			log := logVal.tracer(*verboseVal)
+			ctx = trace.WithContext(ctx, log)
+			memoryVal.apply(log)
+			ctx = offlineVal.apply(ctx)
+			ctx = zipPasswordsVal.apply(ctx, false)
+			ctx = tempVal.apply(ctx, true)
+			m, err := padlock.ReadSetManifest(*manifestVal)
+			if err != nil {
+				fatalf(exitCode(err), "Error: %v", err)
+			}
+			cfg := padlock.InventoryConfig{
+				Manifest: m,
+				Path:     logPath,
+				Partial:  *partialVal,
+				Verbose:  *verboseVal,
+				Output:   os.Stdout,
+				JSON:     *jsonVal,
+			}
+			if err := padlock.CheckInventory(ctx, cfg); err != nil {
+				log.FatalCode(fmt.Errorf("audit failed: %w", err), exitCode(err))
+			}
+			return
+		}
+
+		// Verify the signature of a ceremony record, listing the custodians
+		if data, err := os.ReadFile(logPath); err == nil && padlock.IsCeremonyRecord(data) {
+			record, fingerprint, err := padlock.VerifyCeremonyRecord(data)
+			if err != nil {
+				fatalf(exitCode(err), "Error: audit failed: %v", err)
+			}
+			writeCeremonySummary(os.Stdout, record, fingerprint)
+			return
+		}
+
+		// Verify the chain of records, listing those that are intact
+		records, err := audit.VerifyFile(logPath)
+		writeAuditSummary(os.Stdout, records, err)
+		if err != nil {
+			fatalf(exitCode(err), "Error: audit failed: %v", err)
+		}
+	}
+}
+
+// stringList is a flag.Value that collects comma-separated values such as glob
+// patterns or paths, accumulating across repeated uses of the same flag
+type stringList []string
+
+// String implements flag.Value
+func (p *stringList) String() string {
+	return strings.Join(*p, ",")
+}
+
+// Set implements flag.Value
+func (p *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*p = append(*p, item)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value into trimmed, lowercased, non-empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parsePreserveList converts the -preserve flag value into serialization options
+func parsePreserveList(value string) (padlock.SerializeOptions, error) {
+	var opts padlock.SerializeOptions
+	for _, item := range splitList(value) {
+		switch item {
+		case "none":
+		case "all":
+			opts.PreserveSymlinks, opts.PreserveOwnership, opts.PreserveXattrs = true, true, true
+			opts.PreserveHardlinks, opts.PreserveSparse, opts.PreserveSpecial = true, true, true
+		case "symlinks":
+			opts.PreserveSymlinks = true
+		case "owner":
+			opts.PreserveOwnership = true
+		case "hardlinks":
+			opts.PreserveHardlinks = true
+		case "sparse":
+			opts.PreserveSparse = true
+		case "xattrs":
+			opts.PreserveXattrs = true
+		case "special":
+			opts.PreserveSpecial = true
+		default:
+			return opts, fmt.Errorf("-preserve: unknown attribute '%s' (expected symlinks, owner, hardlinks, sparse, xattrs, special, all or none)", item)
+		}
+	}
+	return opts, nil
+}
+
+// parseRestoreList converts the -restore flag value into deserialization options
+func parseRestoreList(value string) (padlock.DeserializeOptions, error) {
+	var opts padlock.DeserializeOptions
+	for _, item := range splitList(value) {
+		switch item {
+		case "none":
+		case "all":
+			opts.RestoreSymlinks, opts.RestorePermissions, opts.RestoreModTimes = true, true, true
+			opts.RestoreOwnership, opts.RestoreXattrs = true, true
+		case "symlinks":
+			opts.RestoreSymlinks = true
+		case "perms":
+			opts.RestorePermissions = true
+		case "mtime":
+			opts.RestoreModTimes = true
+		case "owner":
+			opts.RestoreOwnership = true
+		case "xattrs":
+			opts.RestoreXattrs = true
+		case "special":
+			// Not part of "all", since a device node gives access to the device
+			opts.RestoreSpecial = true
+		default:
+			return opts, fmt.Errorf("-restore: unknown attribute '%s' (expected symlinks, perms, mtime, owner, xattrs, special, all or none)", item)
+		}
+	}
+	return opts, nil
+}
+
+// mediaSizes are the usable capacities of common fixed-size media, in bytes
+var mediaSizes = map[string]int64{
+	"cd":     700 * 1024 * 1024,
+	"dvd":    4_700_000_000,
+	"dvd-dl": 8_500_000_000,
+	"bd":     25_000_000_000,
+}
+
+// parseSize converts a size such as "4.7GB", "700MiB", "1048576" or a media
+// name such as "dvd" into bytes. Decimal suffixes (KB, MB, GB, TB) are powers
+// of 1000 as used by media manufacturers; binary suffixes (KiB, MiB, GiB, TiB)
+// are powers of 1024.
+func parseSize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if size, ok := mediaSizes[value]; ok {
+		return size, nil
+	}
+
+	multipliers := []struct {
+		suffix string
+		factor float64
+	}{
+		{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+		{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+		{"k", 1e3}, {"m", 1e6}, {"g", 1e9}, {"t", 1e12},
+		{"b", 1},
+	}
+	factor := 1.0
+	for _, m := range multipliers {
+		if strings.HasSuffix(value, m.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, m.suffix))
+			factor = m.factor
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid size '%s' (expected e.g. 4.7GB, 700MiB, or cd, dvd, dvd-dl, bd)", value)
 	}
+	return int64(number * factor), nil
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rayozzie/padlock/pkg/padlock"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// setupManifest registers the flags of manifest and returns the function that runs it
+func setupManifest(fs *flag.FlagSet) func(args []string) {
+	outVal := fs.String("out", "", "`file` to write the manifest to (default: standard output)")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	zipPasswordsVal := addZipPasswordFlags(fs, false)
+	tempVal := addTempFlags(fs)
+
+	return func(args []string) {
+		if len(args) != 1 {
+			fatalf(exitUsage, "Error: manifest takes the directory holding the collections of a set")
+		}
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+		ctx = zipPasswordsVal.apply(ctx, false)
+		ctx = tempVal.apply(ctx, true)
+
+		var out io.Writer = os.Stdout
+		if *outVal != "" {
+			f, err := os.Create(*outVal)
+			if err != nil {
+				fatalf(exitIO, "Error: Cannot create %s: %v", *outVal, err)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		cfg := padlock.ManifestConfig{
+			InputDir: args[0],
+			Verbose:  *verboseVal,
+			Output:   out,
+		}
+		if err := padlock.ExportManifest(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("manifest failed: %w", err), exitCode(err))
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/padlock"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// memoryFlags holds the options for how the process uses memory
+type memoryFlags struct {
+	noSwap    *bool
+	lowMemory *bool
+}
+
+// addMemoryFlags registers the -no-swap and -low-memory options on a command's flag set
+func addMemoryFlags(fs *flag.FlagSet) *memoryFlags {
+	return &memoryFlags{
+		noSwap:    fs.Bool("no-swap", false, "lock memory so that plaintext and pads are never swapped to disk, and disable core dumps"),
+		lowMemory: fs.Bool("low-memory", false, "run on one thread with small chunks and frequent garbage collection, for devices with little memory"),
+	}
+}
+
+// apply locks the process's memory if -no-swap was given, refusing to run
+// without it rather than silently running with less protection than asked
+// for, and configures the process for -low-memory
+func (mf *memoryFlags) apply(log *trace.Tracer) {
+	if *mf.lowMemory {
+		padlock.ConfigureLowMemory(trace.WithContext(context.Background(), log))
+	}
+	if !*mf.noSwap {
+		return
+	}
+	if err := pad.LockMemory(); err != nil {
+		fatalf(exitFailure, "Error: -no-swap: %v", err)
+	}
+	log.Debugf("Memory locked and core dumps disabled")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+)
+
+// offlineFlag holds the -offline option, which guarantees that the process
+// makes no network connections
+type offlineFlag struct {
+	offline *bool
+}
+
+// addOfflineFlag registers the -offline option on a command's flag set
+func addOfflineFlag(fs *flag.FlagSet) *offlineFlag {
+	return &offlineFlag{
+		offline: fs.Bool("offline", false, "guarantee no network access: refuse random sources that would reach out over the network, and make every connection attempt fail"),
+	}
+}
+
+// apply returns a context in offline mode if -offline was given, in which
+// encodes refuse random sources that would use the network before reading
+// any of them, and disables the process's resolver and HTTP transport, so
+// that anything that would still dial out fails instead
+func (of *offlineFlag) apply(ctx context.Context) context.Context {
+	if !*of.offline {
+		return ctx
+	}
+	refuse := func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, fmt.Errorf("%w: refused to connect to %s", pad.ErrOffline, address)
+	}
+	net.DefaultResolver = &net.Resolver{PreferGo: true, Dial: refuse}
+	http.DefaultTransport = &http.Transport{DialContext: refuse}
+	http.DefaultClient = &http.Client{Transport: http.DefaultTransport}
+	return pad.WithOffline(ctx, true)
+}
+
+// isLoopback reports whether a listen address, such as 127.0.0.1:8420, is
+// reachable only from this machine
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
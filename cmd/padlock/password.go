@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rayozzie/padlock/pkg/file"
+)
+
+// zipPasswordFlags holds the options for encrypted zips
+type zipPasswordFlags struct {
+	file    *string
+	encrypt *bool
+}
+
+// addZipPasswordFlags registers the zip password options on a command's flag
+// set, including -zip-encrypt for the commands that create zips
+func addZipPasswordFlags(fs *flag.FlagSet, creates bool) *zipPasswordFlags {
+	zf := &zipPasswordFlags{
+		file:    fs.String("zip-passwords", "", "`file` of NAME=PASSWORD lines giving the password of each collection's zip"),
+		encrypt: new(bool),
+	}
+	if creates {
+		zf.encrypt = fs.Bool("zip-encrypt", false, "with -zip, encrypt each zip with AES-256 using a password per collection")
+	}
+	return zf
+}
+
+// apply returns a context under which encrypted zips are opened with the
+// passwords from -zip-passwords, or else with passwords asked for on the
+// terminal, and under which the zips created are encrypted if -zip-encrypt
+// was given
+func (zf *zipPasswordFlags) apply(ctx context.Context, zipping bool) context.Context {
+	if *zf.encrypt && !zipping {
+		fatalf(exitUsage, "Error: -zip-encrypt requires -zip")
+	}
+	if *zf.file != "" {
+		passwords, err := file.LoadZipPasswords(*zf.file)
+		if err != nil {
+			fatalf(exitUsage, "Error: -zip-passwords: %v", err)
+		}
+		ctx = file.WithZipPasswords(ctx, passwords)
+		if *zf.encrypt {
+			ctx = file.WithZipEncryption(ctx, passwords)
+		}
+		return ctx
+	}
+	ctx = file.WithZipPasswords(ctx, newPasswordPrompt(false).password)
+	if *zf.encrypt {
+		ctx = file.WithZipEncryption(ctx, newPasswordPrompt(true).password)
+	}
+	return ctx
+}
+
+// passwordPrompt asks for zip passwords on the terminal, once per name
+type passwordPrompt struct {
+	confirm   bool
+	passwords map[string]string
+}
+
+func newPasswordPrompt(confirm bool) *passwordPrompt {
+	return &passwordPrompt{confirm: confirm, passwords: make(map[string]string)}
+}
+
+// password returns the password for a zip, asking for it, twice when it is
+// being set, the first time it is needed
+func (p *passwordPrompt) password(name string) (string, error) {
+	if password, ok := p.passwords[name]; ok {
+		return password, nil
+	}
+	password, err := readPassword(fmt.Sprintf("Password for %s: ", name))
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", fmt.Errorf("no password given for %s", name)
+	}
+	if p.confirm {
+		again, err := readPassword(fmt.Sprintf("Password for %s again: ", name))
+		if err != nil {
+			return "", err
+		}
+		if again != password {
+			fmt.Fprintln(os.Stderr, "Passwords do not match")
+			return p.password(name)
+		}
+	}
+	p.passwords[name] = password
+	return password, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A preset is a named set of options, chosen with -preset, so that choosing
+// the number of collections, the threshold, the format and the archive doesn't
+// require weighing their trade-offs. The options of a preset take precedence
+// over the environment and the config file, but not over the command line.
+
+// preset is a named set of option values
+type preset struct {
+	name        string
+	description string
+	options     map[string]string
+}
+
+// builtinPresets are the presets that need no config
+var builtinPresets = []preset{
+	{"personal", "Three copies, any two of which restore; PNG chunks in zips, which survive photo and cloud services",
+		map[string]string{"copies": "3", "required": "2", "format": "png", "archive": "zip"}},
+	{"enterprise", "Five custodians, any three of whom restore; compact binary chunks",
+		map[string]string{"copies": "5", "required": "3", "format": "bin"}},
+	{"archival", "Four copies, any two of which restore, with parity to rebuild chunks damaged in long-term storage",
+		map[string]string{"copies": "4", "required": "2", "format": "bin", "archive": "zip", "parity": "10"}},
+}
+
+// presetDescriptionKey is the key of a config preset that describes it, rather
+// than giving an option
+const presetDescriptionKey = "description"
+
+// presetOptionOrder is the order in which options are listed first when
+// showing a preset, the rest following by name
+var presetOptionOrder = []string{"copies", "required", "format", "archive", "chunk"}
+
+// addPresetFlag adds -preset, which applyDefaults resolves, to a command
+func addPresetFlag(fs *flag.FlagSet) {
+	fs.String("preset", "", "named set of options, e.g. personal or enterprise (see padlock presets list), or a spec such as 2of3-png-zip")
+}
+
+// presets returns the built-in presets and those of the config, which replace
+// built-in presets of the same name, sorted by name
+func presets(cfg *config) []preset {
+	byName := make(map[string]preset)
+	for _, p := range builtinPresets {
+		byName[p.name] = p
+	}
+	for name, table := range cfg.presets {
+		p := preset{name: name, options: make(map[string]string)}
+		for key, value := range table {
+			if key == presetDescriptionKey {
+				p.description = value
+			} else {
+				p.options[key] = value
+			}
+		}
+		byName[name] = p
+	}
+
+	var list []preset
+	for _, p := range byName {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+// resolvePreset returns the options of the preset of the given name, or of a
+// spec such as 2of3-png-zip
+func resolvePreset(name string, cfg *config) (map[string]string, error) {
+	all := presets(cfg)
+	for _, p := range all {
+		if p.name == name {
+			return p.options, nil
+		}
+	}
+	if options, err := parsePresetSpec(name); err == nil {
+		return options, nil
+	}
+
+	names := make([]string, len(all))
+	for i, p := range all {
+		names[i] = p.name
+	}
+	return nil, fmt.Errorf("unknown preset %q: expected one of %s, or a spec such as 2of3-png-zip", name, strings.Join(names, ", "))
+}
+
+// parsePresetSpec parses a spec of the form KofN[-FORMAT][-ARCHIVE], e.g.
+// 2of3-png-zip or 3of5-bin
+func parsePresetSpec(spec string) (map[string]string, error) {
+	parts := strings.Split(strings.ToLower(spec), "-")
+	k, n, ok := strings.Cut(parts[0], "of")
+	if !ok {
+		return nil, fmt.Errorf("%q does not begin with KofN", spec)
+	}
+	if _, err := strconv.Atoi(k); err != nil {
+		return nil, fmt.Errorf("%q does not begin with KofN", spec)
+	}
+	if _, err := strconv.Atoi(n); err != nil {
+		return nil, fmt.Errorf("%q does not begin with KofN", spec)
+	}
+
+	options := map[string]string{"copies": n, "required": k}
+	for _, part := range parts[1:] {
+		var option string
+		switch part {
+		case "bin", "png":
+			option = "format"
+		case "zip", "tgz", "none":
+			option = "archive"
+		default:
+			return nil, fmt.Errorf("%q: unknown part %q, expected bin, png, zip, tgz or none", spec, part)
+		}
+		if _, ok := options[option]; ok {
+			return nil, fmt.Errorf("%q gives the %s twice", spec, option)
+		}
+		options[option] = part
+	}
+	return options, nil
+}
+
+// presetArgs returns the options of a preset as they would be given on the
+// command line, e.g. "-copies 3 -required 2 -format png"
+func presetArgs(options map[string]string) string {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	rank := func(key string) int {
+		for i, k := range presetOptionOrder {
+			if k == key {
+				return i
+			}
+		}
+		return len(presetOptionOrder)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if rank(keys[i]) != rank(keys[j]) {
+			return rank(keys[i]) < rank(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	var args []string
+	for _, key := range keys {
+		args = append(args, "-"+key+" "+options[key])
+	}
+	return strings.Join(args, " ")
+}
+
+// setupPresets implements "padlock presets list"
+func setupPresets(fs *flag.FlagSet) func(args []string) {
+	return func(args []string) {
+		if args[0] != "list" {
+			fatalf(exitUsage, "Error: unknown presets action %q, expected list", args[0])
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			fatalf(exitUsage, "Error: cannot read config: %v", err)
+		}
+		writePresets(os.Stdout, cfg)
+	}
+}
+
+// writePresets lists the presets with their descriptions and options
+func writePresets(w io.Writer, cfg *config) {
+	for i, p := range presets(cfg) {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		source := "built-in"
+		if _, ok := cfg.presets[p.name]; ok {
+			source = cfg.path
+		}
+		fmt.Fprintf(w, "%s (%s)\n", p.name, source)
+		if p.description != "" {
+			fmt.Fprintf(w, "  %s\n", p.description)
+		}
+		fmt.Fprintf(w, "  %s\n", presetArgs(p.options))
+	}
+}
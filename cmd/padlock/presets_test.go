@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePresetSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"2of3", map[string]string{"copies": "3", "required": "2"}, false},
+		{"2of3-png-zip", map[string]string{"copies": "3", "required": "2", "format": "png", "archive": "zip"}, false},
+		{"3OF5-BIN", map[string]string{"copies": "5", "required": "3", "format": "bin"}, false},
+		{"2of4-tgz", map[string]string{"copies": "4", "required": "2", "archive": "tgz"}, false},
+		{"personal", nil, true},
+		{"xof3", nil, true},
+		{"2of3-gif", nil, true},
+		{"2of3-bin-png", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parsePresetSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePresetSpec(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePresetSpec(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresets(t *testing.T) {
+	cfg, err := parseConfig("config.toml", strings.NewReader(`[preset.personal]
+description = "Just two"
+copies = 2
+required = 2
+
+[preset."family"]
+copies = 4
+required = 2
+format = "png"
+`))
+	if err != nil {
+		t.Fatalf("parseConfig failed: %v", err)
+	}
+	if err := cfg.check(); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+
+	t.Run("Config replaces built-in", func(t *testing.T) {
+		options, err := resolvePreset("personal", cfg)
+		if err != nil {
+			t.Fatalf("resolvePreset failed: %v", err)
+		}
+		if want := map[string]string{"copies": "2", "required": "2"}; !reflect.DeepEqual(options, want) {
+			t.Errorf("personal = %v, want %v", options, want)
+		}
+	})
+
+	t.Run("Built-in", func(t *testing.T) {
+		options, err := resolvePreset("enterprise", cfg)
+		if err != nil {
+			t.Fatalf("resolvePreset failed: %v", err)
+		}
+		if options["copies"] != "5" || options["required"] != "3" {
+			t.Errorf("enterprise = %v", options)
+		}
+	})
+
+	t.Run("Spec", func(t *testing.T) {
+		options, err := resolvePreset("3of4-bin", cfg)
+		if err != nil {
+			t.Fatalf("resolvePreset failed: %v", err)
+		}
+		if options["copies"] != "4" || options["format"] != "bin" {
+			t.Errorf("3of4-bin = %v", options)
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := resolvePreset("corporate", cfg)
+		if err == nil || !strings.Contains(err.Error(), "family") {
+			t.Errorf("resolvePreset error = %v, want one listing the presets", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		var buf bytes.Buffer
+		writePresets(&buf, cfg)
+		out := buf.String()
+		for _, want := range []string{
+			"archival (built-in)",
+			"family (config.toml)\n  -copies 4 -required 2 -format png\n",
+			"personal (config.toml)\n  Just two\n  -copies 2 -required 2\n",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("list lacks %q:\n%s", want, out)
+			}
+		}
+	})
+
+	t.Run("Unknown option", func(t *testing.T) {
+		cfg, err := parseConfig("config.toml", strings.NewReader("[preset.loop]\npreset = \"personal\"\n"))
+		if err != nil {
+			t.Fatalf("parseConfig failed: %v", err)
+		}
+		if err := cfg.check(); err == nil {
+			t.Errorf("check accepted a preset choosing a preset")
+		}
+	})
+}
+
+func TestApplyPreset(t *testing.T) {
+	cfg, err := parseConfig("config.toml", strings.NewReader(`format = "png"
+[encode]
+preset = "enterprise"
+`))
+	if err != nil {
+		t.Fatalf("parseConfig failed: %v", err)
+	}
+	env := func(name string) (string, bool) {
+		if name == "PADLOCK_REQUIRED" {
+			return "4", true
+		}
+		return "", false
+	}
+
+	newFlags := func() (*flag.FlagSet, *int, *int, *string) {
+		fs := flag.NewFlagSet("encode", flag.ContinueOnError)
+		copies := fs.Int("copies", 2, "")
+		required := fs.Int("required", 2, "")
+		format := fs.String("format", "png", "")
+		addPresetFlag(fs)
+		return fs, copies, required, format
+	}
+
+	t.Run("From the config", func(t *testing.T) {
+		fs, copies, required, format := newFlags()
+		if err := fs.Parse([]string{"-copies", "6"}); err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if err := applyDefaults(fs, "encode", cfg, env); err != nil {
+			t.Fatalf("applyDefaults failed: %v", err)
+		}
+		// The command line beats the preset, which beats the environment and config
+		if *copies != 6 || *required != 3 || *format != "bin" {
+			t.Errorf("got -copies %d -required %d -format %s, want -copies 6 -required 3 -format bin", *copies, *required, *format)
+		}
+	})
+
+	t.Run("From the command line", func(t *testing.T) {
+		fs, copies, required, format := newFlags()
+		if err := fs.Parse([]string{"-preset", "2of3"}); err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if err := applyDefaults(fs, "encode", cfg, env); err != nil {
+			t.Fatalf("applyDefaults failed: %v", err)
+		}
+		if *copies != 3 || *required != 2 || *format != "png" {
+			t.Errorf("got -copies %d -required %d -format %s, want -copies 3 -required 2 -format png", *copies, *required, *format)
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		fs, _, _, _ := newFlags()
+		if err := fs.Parse([]string{"-preset", "nonexistent"}); err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if err := applyDefaults(fs, "encode", cfg, env); err == nil {
+			t.Errorf("applyDefaults accepted an unknown preset")
+		}
+	})
+}
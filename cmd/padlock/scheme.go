@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/padlock"
+)
+
+// setupScheme registers the flags of scheme and returns the function that runs it
+func setupScheme(fs *flag.FlagSet) func(args []string) {
+	copiesVal := fs.Int("copies", 2, "number of collections in the set (2-26)")
+	requiredVal := fs.Int("required", 2, "minimum collections required for reconstruction")
+	jsonVal := fs.Bool("json", false, "print the scheme as JSON")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+
+	return func(args []string) {
+		log := logVal.tracer(*verboseVal)
+		memoryVal.apply(log)
+		offlineVal.apply(context.Background())
+
+		s, err := pad.DescribeScheme(*copiesVal, *requiredVal)
+		if err != nil {
+			fatalf(exitUsage, "Error: %v", err)
+		}
+		if *jsonVal {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(s); err != nil {
+				log.FatalCode(fmt.Errorf("cannot write scheme: %w", err), exitFailure)
+			}
+			return
+		}
+		writeScheme(os.Stdout, s)
+	}
+}
+
+// writeScheme writes the groups of a scheme, the order of the pieces in the
+// chunks of each collection, and how chunks are laid out and decoded
+func writeScheme(w io.Writer, s pad.Scheme) {
+	fmt.Fprintf(w, "%d-of-%d: %d XOR groups; each chunk of a collection holds %d pieces\n\n", s.Required, s.Copies, len(s.Groups), s.Pieces)
+
+	fmt.Fprintln(w, "Groups, with the index of each collection's piece in its chunks:")
+	for _, g := range s.Groups {
+		members := make([]string, len(g.Letters))
+		for i := range members {
+			members[i] = fmt.Sprintf("%c:%d", g.Letters[i], g.Pieces[i])
+		}
+		fmt.Fprintf(w, "  %s  %s  (ciphertext in %s)\n", g.Letters, strings.Join(members, " "), g.Ciphertext)
+	}
+
+	fmt.Fprintln(w, "\nCollections, with the groups of their pieces in chunk order:")
+	for _, c := range s.Collections {
+		fmt.Fprintf(w, "  %s  %s\n", c.Name, strings.Join(c.Groups, " "))
+	}
+
+	fmt.Fprintln(w, "\nChunk layout:")
+	fmt.Fprintf(w, "  %-15s  header: 0, version 2, letter index (0 for A), K, N, then big-endian\n", fmt.Sprintf("%d bytes", s.HeaderBytes))
+	fmt.Fprintf(w, "  %-15s  chunk number (4 bytes), payload length (8) and session ID (8)\n", "")
+	fmt.Fprintf(w, "  %-15s  pieces; piece i starts at %d + i*dataBytes\n", fmt.Sprintf("%d x dataBytes", s.Pieces), s.HeaderBytes)
+	fmt.Fprintf(w, "Chunks are at least %d bytes, or %d padded; a smaller -chunk is raised to this.\n",
+		padlock.MinChunkSize(s.Copies, s.Required, false), padlock.MinChunkSize(s.Copies, s.Required, true))
+	fmt.Fprintln(w, "\nThe ciphertext piece of a group is the data XORed with the other pieces, which are random pads.")
+	fmt.Fprintf(w, "Any %d or more collections decode with the group of their first %d letters in alphabetical order.\n", s.Required, s.Required)
+	fmt.Fprintln(w, "An encode with a decoy writes the decoy in place of the data in the groups that include a decoy collection.")
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+)
+
+func TestWriteScheme(t *testing.T) {
+	s, err := pad.DescribeScheme(3, 2)
+	if err != nil {
+		t.Fatalf("DescribeScheme failed: %v", err)
+	}
+	var buf bytes.Buffer
+	writeScheme(&buf, s)
+	out := buf.String()
+	for _, want := range []string{
+		"2-of-3: 3 XOR groups; each chunk of a collection holds 2 pieces",
+		"  AC  A:1 C:0  (ciphertext in A)",
+		"  2C3  AC BC",
+		"piece i starts at 25 + i*dataBytes",
+		"Chunks are at least 128 bytes, or 176 padded",
+		"Any 2 or more collections decode",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected the scheme to contain %q, got:\n%s", want, out)
+		}
+	}
+}
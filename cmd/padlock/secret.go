@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/padlock"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// setupSplitSecret registers the flags of split-secret and returns the function that runs it
+func setupSplitSecret(fs *flag.FlagSet) func(args []string) {
+	inVal := fs.String("in", "", "`file` holding the secret (default: standard input)")
+	nVal := fs.Int("copies", 2, "number of shares (must be between 2 and 26)")
+	reqVal := fs.Int("required", 2, "minimum shares required to join the secret")
+	encodingVal := fs.String("encoding", "base64", "how shares are written: base64, paper, mnemonic or bin")
+	clearVal := fs.Bool("clear", false, "clear output directory if not empty")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+	entropyVal := addEntropyFlags(fs)
+
+	return func(args []string) {
+		outputDir := args[0]
+
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+
+		// Read at most one byte more than a secret may hold, to tell that it is too long
+		in := io.Reader(os.Stdin)
+		if *inVal != "" {
+			f, err := os.Open(*inVal)
+			if err != nil {
+				fatalf(exitIO, "Error: Cannot open secret: %v", err)
+			}
+			defer f.Close()
+			in = f
+		}
+		secret, err := io.ReadAll(io.LimitReader(in, padlock.MaxSecretBytes+1))
+		if err != nil {
+			fatalf(exitIO, "Error: Cannot read secret: %v", err)
+		}
+		defer pad.Zeroize(secret)
+
+		cfg := padlock.SplitSecretConfig{
+			Secret:          secret,
+			OutputDir:       outputDir,
+			N:               *nVal,
+			K:               *reqVal,
+			Encoding:        padlock.SecretEncoding(*encodingVal),
+			RNG:             entropyVal.rng(ctx, log),
+			ClearIfNotEmpty: *clearVal,
+		}
+		if err := padlock.SplitSecret(ctx, cfg); err != nil {
+			log.FatalCode(fmt.Errorf("split-secret failed: %w", err), exitCode(err))
+		}
+	}
+}
+
+// setupJoinSecret registers the flags of join-secret and returns the function that runs it
+func setupJoinSecret(fs *flag.FlagSet) func(args []string) {
+	outVal := fs.String("out", "", "`file` to write the secret to (default: standard output)")
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output (includes all trace information)")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+
+	return func(args []string) {
+		// Create context with tracer
+		ctx := context.Background()
+		log := logVal.tracer(*verboseVal)
+		ctx = trace.WithContext(ctx, log)
+		memoryVal.apply(log)
+		ctx = offlineVal.apply(ctx)
+
+		out := io.Writer(os.Stdout)
+		if *outVal != "" {
+			f, err := os.OpenFile(*outVal, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				fatalf(exitIO, "Error: Cannot create %s: %v", *outVal, err)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		// A share given as - is typed or pasted in, such as the words of a mnemonic
+		for _, share := range args {
+			if share == "-" {
+				fmt.Fprintln(os.Stderr, "Type or paste the share, then press Ctrl-D:")
+				break
+			}
+		}
+
+		if err := padlock.JoinSecret(ctx, padlock.JoinSecretConfig{Shares: args, Input: os.Stdin, Output: out}); err != nil {
+			log.FatalCode(fmt.Errorf("join-secret failed: %w", err), exitCode(err))
+		}
+	}
+}
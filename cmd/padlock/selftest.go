@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rayozzie/padlock/pkg/conformance"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// setupSelftest registers the flags of selftest and returns the function that runs it
+func setupSelftest(fs *flag.FlagSet) func(args []string) {
+	verboseVal := fs.Bool("verbose", false, "enable detailed debug output, including the decodes that fail as they should")
+	logVal := addLogFlags(fs)
+	memoryVal := addMemoryFlags(fs)
+	offlineVal := addOfflineFlag(fs)
+
+	return func(args []string) {
+		log := logVal.tracer(*verboseVal)
+		memoryVal.apply(log)
+
+		// Many cases must fail, so their errors are only logged with -verbose
+		runLog := log
+		if !*verboseVal {
+			runLog = trace.NewTracer("MAIN", trace.LogLevelError)
+			runLog.SetSinks()
+		}
+		ctx := offlineVal.apply(trace.WithContext(context.Background(), runLog))
+
+		vectors, err := conformance.Vectors()
+		if err != nil {
+			log.FatalCode(fmt.Errorf("cannot read the test vectors: %w", err), exitFailure)
+		}
+		report, err := conformance.Run(ctx, conformance.Reference, vectors)
+		if err != nil {
+			log.FatalCode(fmt.Errorf("selftest failed: %w", err), exitFailure)
+		}
+		writeSelftestReport(os.Stdout, report)
+		if err := report.Err(); err != nil {
+			log.FatalCode(fmt.Errorf("selftest failed: %w", err), exitFailure)
+		}
+	}
+}
+
+// writeSelftestReport writes the outcome of running the test vectors
+func writeSelftestReport(w io.Writer, report *conformance.Report) {
+	fmt.Fprintf(w, "%d vectors: %d decodes and %d encodes checked\n", report.Vectors, report.Cases, report.Encodes)
+	for _, failure := range report.Failures {
+		fmt.Fprintf(w, "  FAIL %s\n", failure)
+	}
+	if len(report.Failures) == 0 {
+		fmt.Fprintln(w, "All passed")
+	}
+}
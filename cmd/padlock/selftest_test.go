@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/conformance"
+)
+
+func TestWriteSelftestReport(t *testing.T) {
+	var buf bytes.Buffer
+	writeSelftestReport(&buf, &conformance.Report{Vectors: 2, Cases: 10, Encodes: 2})
+	if want := "2 vectors: 10 decodes and 2 encodes checked\nAll passed\n"; buf.String() != want {
+		t.Errorf("Report = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	writeSelftestReport(&buf, &conformance.Report{Vectors: 1, Cases: 3, Failures: []string{"2of3-chunks: decoding 2A3 succeeded, but should fail"}})
+	if want := "1 vectors: 3 decodes and 0 encodes checked\n  FAIL 2of3-chunks: decoding 2A3 succeeded, but should fail\n"; buf.String() != want {
+		t.Errorf("Report = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rayozzie/padlock/pkg/file"
+)
+
+// tempFlags holds the options for the temporary directories that archives
+// are extracted into
+type tempFlags struct {
+	dir  *string
+	keep *bool
+}
+
+// addTempFlags registers the temporary directory options on a command's flag set
+func addTempFlags(fs *flag.FlagSet) *tempFlags {
+	return &tempFlags{
+		dir:  fs.String("tmpdir", "", "`directory` to extract archives into (default: the system's temporary directory)"),
+		keep: fs.Bool("keep-temp", false, "keep the temporary directories archives are extracted into, for debugging"),
+	}
+}
+
+// apply returns a context under which temporary directories are created in
+// -tmpdir and kept with -keep-temp. Unless they are kept, or the command
+// handles interrupts itself, an interrupt removes those not yet removed
+// before the process exits.
+func (tf *tempFlags) apply(ctx context.Context, interrupts bool) context.Context {
+	if *tf.dir != "" {
+		if info, err := os.Stat(*tf.dir); err != nil || !info.IsDir() {
+			fatalf(exitUsage, "Error: -tmpdir %s is not an existing directory", *tf.dir)
+		}
+	}
+	if interrupts && !*tf.keep {
+		removeTempOnInterrupt()
+	}
+	if *tf.dir == "" && !*tf.keep {
+		return ctx
+	}
+	return file.WithTempDir(ctx, *tf.dir, *tf.keep)
+}
+
+// removeTempOnInterrupt removes the temporary directories still present when
+// the process is interrupted, which exits without running deferred cleanup
+func removeTempOnInterrupt() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		for _, dir := range file.RemoveTempDirs() {
+			log.Printf("Interrupted: removed temporary directory %s", dir)
+		}
+		os.Exit(exitFailure)
+	}()
+}
@@ -0,0 +1,43 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// readPassword asks for a password on the terminal without echoing it. The
+// terminal is used even when standard input is not, as when encoding from a pipe.
+func readPassword(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("a password is needed but there is no terminal to ask for it on; use -zip-passwords")
+	}
+	defer tty.Close()
+
+	fd := int(tty.Fd())
+	saved, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the terminal settings: %w", err)
+	}
+	noEcho := *saved
+	noEcho.Lflag &^= unix.ECHO
+	noEcho.Lflag |= unix.ICANON | unix.ISIG
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &noEcho); err != nil {
+		return "", fmt.Errorf("failed to turn off echo: %w", err)
+	}
+	defer unix.IoctlSetTermios(fd, ioctlSetTermios, saved)
+
+	fmt.Fprint(tty, prompt)
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	fmt.Fprintln(tty)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -3,8 +3,11 @@ module github.com/rayozzie/padlock
 go 1.24.2
 
 require (
-	github.com/seehuhn/mt19937 v1.0.0 // indirect
-	golang.org/x/crypto v0.37.0 // indirect
-	golang.org/x/exp v0.0.0-20250408133849-7e4ce0ab07d0 // indirect
-	golang.org/x/sys v0.32.0 // indirect
+	github.com/cloudflare/circl v1.6.1
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/hanwen/go-fuse/v2 v2.11.0
+	github.com/seehuhn/mt19937 v1.0.0
+	golang.org/x/crypto v0.37.0
+	golang.org/x/sync v0.13.0
+	golang.org/x/sys v0.32.0
 )
@@ -0,0 +1,256 @@
+// Package audit keeps a tamper-evident record of the cryptographic operations
+// padlock performs, for users who must be able to show afterward what was
+// encoded, decoded or verified, when, and with which parameters.
+//
+// The log is a file of JSON lines, one Record per operation. Each record holds
+// the SHA-256 hash of the record before it and a hash of its own contents, so
+// that editing, inserting or deleting a record breaks the chain from that point
+// on, which Verify reports. Removing records from the end of the log cannot be
+// detected from the log alone, so the hash of the last record should be noted
+// elsewhere when the log is archived.
+//
+// Records identify collections by the SHA-256 hash of each of their files, so
+// the collections read by a decode can be matched against those written by an
+// encode. The plaintext itself is never recorded.
+package audit
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrTampered means the records of an audit log do not form an intact chain
+var ErrTampered = errors.New("audit log has been altered")
+
+// Operations recorded in the audit log
+const (
+	OpEncode = "encode"
+	OpDecode = "decode"
+	OpVerify = "verify"
+)
+
+// FileHash identifies a file read or written by an operation
+type FileHash struct {
+	Path   string `json:"path"` // Path relative to the directory the operation read or wrote
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Record is one operation in the audit log
+type Record struct {
+	Seq       int64             `json:"seq"`               // Position in the log, from 1
+	Time      string            `json:"time"`              // When the operation finished, RFC 3339 UTC
+	Operation string            `json:"op"`                // OpEncode, OpDecode or OpVerify
+	Session   string            `json:"session"`           // Random identifier of this run of the operation
+	Params    map[string]string `json:"params,omitempty"`  // Parameters of the operation
+	Inputs    []FileHash        `json:"inputs,omitempty"`  // Collection files read
+	Outputs   []FileHash        `json:"outputs,omitempty"` // Files written
+	Result    string            `json:"result"`            // "ok" or "error"
+	Error     string            `json:"error,omitempty"`   // Why the operation failed
+	Prev      string            `json:"prev"`              // Hash of the previous record, empty for the first
+	Hash      string            `json:"hash"`              // Hash of this record with Hash empty
+}
+
+// hash computes the chained hash of a record, which covers every field but Hash
+func (r Record) hash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log appends records to an audit log file. It is safe for concurrent use.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	seq  int64  // Sequence number of the last record
+	last string // Hash of the last record
+}
+
+// Open opens the audit log at path for appending, creating it if it doesn't
+// exist. New records are chained to the last record already in the log.
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var last Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			return nil, fmt.Errorf("%w: %s: record %d is not valid JSON: %v", ErrTampered, path, last.Seq+1, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+	l.seq, l.last = last.Seq, last.Hash
+	return l, nil
+}
+
+// Path returns the path of the audit log file
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append completes a record with its position, time and hashes and writes it
+// to the log, syncing it to disk before returning
+func (l *Log) Append(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r.Seq = l.seq + 1
+	if r.Time == "" {
+		r.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	r.Prev = l.last
+	hash, err := r.hash()
+	if err != nil {
+		return fmt.Errorf("failed to hash audit record: %w", err)
+	}
+	r.Hash = hash
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", l.path, err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit log %s: %w", l.path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync audit log %s: %w", l.path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log %s: %w", l.path, err)
+	}
+	l.seq, l.last = r.Seq, r.Hash
+	return nil
+}
+
+// Verify checks that the records read from r form an intact chain, returning
+// them. An error wrapping ErrTampered identifies the first record that does not.
+func Verify(r io.Reader) ([]Record, error) {
+	var records []Record
+	prev := ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return records, fmt.Errorf("%w: line %d is not a valid record: %v", ErrTampered, line, err)
+		}
+		if rec.Seq != int64(len(records))+1 {
+			return records, fmt.Errorf("%w: line %d holds record %d, expected record %d", ErrTampered, line, rec.Seq, len(records)+1)
+		}
+		if rec.Prev != prev {
+			return records, fmt.Errorf("%w: record %d does not follow the record before it", ErrTampered, rec.Seq)
+		}
+		hash, err := rec.hash()
+		if err != nil {
+			return records, fmt.Errorf("failed to hash record %d: %w", rec.Seq, err)
+		}
+		if hash != rec.Hash {
+			return records, fmt.Errorf("%w: record %d does not match its hash", ErrTampered, rec.Seq)
+		}
+		records = append(records, rec)
+		prev = rec.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return records, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return records, nil
+}
+
+// VerifyFile checks the audit log at path, as for Verify
+func VerifyFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	defer f.Close()
+	return Verify(f)
+}
+
+// NewSession returns a random identifier for one run of an operation
+func NewSession() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// HashBytes identifies data held in memory, such as a collection stream
+func HashBytes(name string, data []byte) FileHash {
+	sum := sha256.Sum256(data)
+	return FileHash{Path: name, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+// HashTree hashes every regular file under root, returning them sorted by
+// their slash-separated paths relative to root
+func HashTree(root string) ([]FileHash, error) {
+	var hashes []FileHash
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		n, err := io.Copy(h, f)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, FileHash{Path: filepath.ToSlash(rel), Size: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash files in %s: %w", root, err)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i].Path < hashes[j].Path })
+	return hashes, nil
+}
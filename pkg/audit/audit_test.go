@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppendVerify(t *testing.T) {
+	dir, err := os.MkdirTemp("", "audit-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, op := range []string{OpEncode, OpDecode} {
+		rec := Record{Operation: op, Session: NewSession(), Params: map[string]string{"copies": "3"}, Result: "ok"}
+		if err := l.Append(rec); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// Reopening continues the chain
+	l, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := l.Append(Record{Operation: OpVerify, Result: "error", Error: "decode failed"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	records, err := VerifyFile(path)
+	if err != nil {
+		t.Fatalf("VerifyFile failed: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	for i, r := range records {
+		if r.Seq != int64(i+1) {
+			t.Errorf("Record %d has seq %d", i, r.Seq)
+		}
+		if i > 0 && r.Prev != records[i-1].Hash {
+			t.Errorf("Record %d is not chained to the record before it", r.Seq)
+		}
+	}
+	if records[0].Prev != "" || records[2].Operation != OpVerify || records[2].Error != "decode failed" {
+		t.Errorf("Unexpected records: %+v", records)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	dir, err := os.MkdirTemp("", "audit-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, op := range []string{OpEncode, OpDecode, OpDecode} {
+		if err := l.Append(Record{Operation: op, Params: map[string]string{"copies": "3"}, Result: "ok"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	tests := []struct {
+		name     string
+		log      string
+		verified int
+	}{
+		{"Edited record", lines[0] + strings.Replace(lines[1], `"copies":"3"`, `"copies":"4"`, 1) + lines[2], 1},
+		{"Deleted record", lines[0] + lines[2], 1},
+		{"Reordered records", lines[1] + lines[0] + lines[2], 0},
+		{"Not JSON", lines[0] + "garbage\n" + lines[1], 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := Verify(strings.NewReader(tt.log))
+			if !errors.Is(err, ErrTampered) {
+				t.Fatalf("Expected ErrTampered, got %v", err)
+			}
+			if len(records) != tt.verified {
+				t.Errorf("Expected %d records verified before the break, got %d", tt.verified, len(records))
+			}
+		})
+	}
+}
+
+func TestHashTree(t *testing.T) {
+	dir, err := os.MkdirTemp("", "audit-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0644)
+
+	hashes, err := HashTree(dir)
+	if err != nil {
+		t.Fatalf("HashTree failed: %v", err)
+	}
+	want := []FileHash{
+		{Path: "a.txt", Size: 0, SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{Path: "sub/b.txt", Size: 5, SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+	}
+	if len(hashes) != len(want) {
+		t.Fatalf("Expected %d hashes, got %+v", len(want), hashes)
+	}
+	for i := range want {
+		if hashes[i] != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], hashes[i])
+		}
+	}
+	if h := HashBytes("sub/b.txt", []byte("hello")); h != want[1] {
+		t.Errorf("HashBytes = %+v, want %+v", h, want[1])
+	}
+}
@@ -0,0 +1,320 @@
+// Package conformance holds test vectors for the padlock format, small sets of
+// collections encoded with a seeded RNG, and runs an implementation against
+// them, so that independent implementations of the encoder or decoder can show
+// that they read and write what padlock does.
+//
+// Each vector is a JSON file in testdata/ holding a Spec, the collections it
+// encodes to, and the cases to decode. A collection is the concatenation of its
+// chunks, exactly as a bin collection's files hold them and as pad.Decode reads
+// them; byte strings are base64. A case lists the collections to supply, by
+// their keys in the vector's collections, and either the output a decoder must
+// produce from them or that it must fail. Implementations in other languages
+// can read the vectors directly; those in Go can pass their decoder, and
+// optionally their encoder, to Run.
+//
+// The reference encoder draws the eight bytes of the session ID in every chunk
+// header from the RNG first, then the pads of each chunk in order of their
+// groups, alphabetically, and within a group in order of its letters after
+// the first (see pad.DescribeScheme). The RNG is pad.TestRNG, whose bytes
+// count up from the vector's seed, so an encoder drawing its pads the same way
+// reproduces the collections byte for byte; others should be checked by
+// decoding what they encode instead.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// ErrNonconformant is returned when an implementation fails a vector
+var ErrNonconformant = errors.New("implementation does not conform to the test vectors")
+
+// testdata holds the vectors, embedded so that an installed binary can check
+// itself against them
+//
+//go:embed testdata/*.json
+var testdata embed.FS
+
+// Spec describes how a vector is encoded
+type Spec struct {
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	Required       int    `json:"required"`                 // K
+	Copies         int    `json:"copies"`                   // N
+	ChunkSize      int    `json:"chunkSize"`                // Largest chunk written to a collection, as given to pad.Encode
+	Padded         bool   `json:"padded,omitempty"`         // Whether every chunk is padded to full size
+	Seed           byte   `json:"seed"`                     // First byte of the pad.TestRNG the pads are drawn from
+	Plaintext      []byte `json:"plaintext"`                // The data encoded
+	Decoy          string `json:"decoy,omitempty"`          // Letters of the decoy collections, if any, which requires Padded
+	DecoyPlaintext []byte `json:"decoyPlaintext,omitempty"` // The data the decoy collections reveal
+	Truncated      bool   `json:"truncated,omitempty"`      // Whether to add a copy of the first collection missing its last byte
+}
+
+// Vector is a set of collections encoded from a Spec, and the cases of
+// decoding them
+type Vector struct {
+	Spec
+	Collections map[string][]byte `json:"collections"` // Collections by key: their names, and e.g. "2A3/truncated" for damaged copies
+	Cases       []Case            `json:"cases"`
+}
+
+// Case is one decode of a vector
+type Case struct {
+	Collections []string `json:"collections"`      // Keys of the collections supplied, in the order supplied
+	Output      []byte   `json:"output,omitempty"` // The data the decode must produce, unless it must fail; absent if empty
+	Error       string   `json:"error,omitempty"`  // Why the decode must fail, if it must
+}
+
+// DecodeFunc decodes collections, each the concatenation of its chunks
+type DecodeFunc func(ctx context.Context, collections [][]byte) ([]byte, error)
+
+// EncodeFunc encodes the plaintext of a spec, returning the collections by name
+type EncodeFunc func(ctx context.Context, spec Spec) (map[string][]byte, error)
+
+// Implementation is what Run checks. Encode may be nil, for a decoder alone.
+type Implementation struct {
+	Decode DecodeFunc
+	Encode EncodeFunc
+}
+
+// Reference is padlock's own implementation, which the vectors were made with
+var Reference = Implementation{Decode: ReferenceDecode, Encode: ReferenceEncode}
+
+// ReferenceDecode decodes collections with pad.DecodeFromReaders
+func ReferenceDecode(ctx context.Context, collections [][]byte) ([]byte, error) {
+	readers := make([]io.Reader, len(collections))
+	for i, c := range collections {
+		readers[i] = bytes.NewReader(c)
+	}
+	var output bytes.Buffer
+	if err := pad.DecodeFromReaders(ctx, readers, &output); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// ReferenceEncode encodes the plaintext of a spec with pad.Encode, or
+// pad.EncodeDual for a decoy, drawing the pads from a pad.TestRNG
+func ReferenceEncode(ctx context.Context, spec Spec) (map[string][]byte, error) {
+	p, err := pad.NewPadForEncode(ctx, spec.Copies, spec.Required)
+	if err != nil {
+		return nil, err
+	}
+	p.PadToChunk = spec.Padded
+
+	collections := make(map[string]*bytes.Buffer)
+	for _, name := range p.Collections {
+		collections[name] = &bytes.Buffer{}
+	}
+	newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		buf, ok := collections[collectionName]
+		if !ok {
+			return nil, fmt.Errorf("no collection %s", collectionName)
+		}
+		return nopWriteCloser{buf}, nil
+	}
+
+	rng := pad.NewTestRNG(spec.Seed)
+	if spec.Decoy != "" {
+		if !spec.Padded {
+			return nil, fmt.Errorf("%w: a decoy encode is always padded", pad.ErrInvalidParameters)
+		}
+		err = p.EncodeDual(ctx, spec.ChunkSize, bytes.NewReader(spec.Plaintext), bytes.NewReader(spec.DecoyPlaintext), spec.Decoy, rng, newChunk, "bin")
+	} else {
+		err = p.Encode(ctx, spec.ChunkSize, bytes.NewReader(spec.Plaintext), rng, newChunk, "bin")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]byte, len(collections))
+	for name, buf := range collections {
+		result[name] = buf.Bytes()
+	}
+	return result, nil
+}
+
+// Generate encodes a spec with the reference implementation and lists the
+// cases of decoding it: every subset of its collections, a collection supplied
+// twice, and the truncated copy if the spec asks for one
+func Generate(ctx context.Context, spec Spec) (Vector, error) {
+	collections, err := ReferenceEncode(ctx, spec)
+	if err != nil {
+		return Vector{}, err
+	}
+	v := Vector{Spec: spec, Collections: collections}
+
+	names := make([]string, spec.Copies)
+	decoy := make(map[string]bool)
+	for i := range names {
+		names[i] = pad.CollectionName(spec.Required, spec.Copies, i)
+		decoy[names[i]] = strings.ContainsRune(strings.ToUpper(spec.Decoy), rune('A'+i))
+	}
+
+	// The output of a subset is that of the group of its first K letters, which
+	// is the decoy if the group includes a decoy collection
+	outputOf := func(subset []string) Case {
+		c := Case{Collections: subset}
+		if len(subset) < spec.Required {
+			c.Error = fmt.Sprintf("%d of the %d required collections supplied", len(subset), spec.Required)
+			return c
+		}
+		c.Output = spec.Plaintext
+		for _, name := range subset[:spec.Required] {
+			if decoy[name] {
+				c.Output = spec.DecoyPlaintext
+			}
+		}
+		return c
+	}
+	for mask := 1; mask < 1<<len(names); mask++ {
+		var subset []string
+		for i, name := range names {
+			if mask&(1<<i) != 0 {
+				subset = append(subset, name)
+			}
+		}
+		v.Cases = append(v.Cases, outputOf(subset))
+	}
+
+	// The output doesn't depend on the order collections are supplied in
+	reversed := make([]string, len(names))
+	for i, name := range names {
+		reversed[len(names)-1-i] = name
+	}
+	c := outputOf(names)
+	c.Collections = reversed
+	v.Cases = append(v.Cases, c)
+
+	// A collection supplied twice counts once
+	duplicated := append([]string{names[0]}, names[:spec.Required-1]...)
+	v.Cases = append(v.Cases, Case{Collections: duplicated,
+		Error: fmt.Sprintf("collection %s supplied twice, leaving %d of the %d required", names[0], spec.Required-1, spec.Required)})
+
+	if spec.Truncated {
+		key := names[0] + "/truncated"
+		full := collections[names[0]]
+		if len(full) == 0 {
+			return Vector{}, fmt.Errorf("vector %s has no data to truncate", spec.Name)
+		}
+		v.Collections[key] = full[:len(full)-1]
+		v.Cases = append(v.Cases, Case{Collections: append([]string{key}, names[1:spec.Required]...),
+			Error: fmt.Sprintf("collection %s is missing the last byte of its last chunk", names[0])})
+	}
+	return v, nil
+}
+
+// Vectors returns the embedded vectors, in order of their names
+func Vectors() ([]Vector, error) {
+	files, err := testdata.ReadDir("testdata")
+	if err != nil {
+		return nil, err
+	}
+	var vectors []Vector
+	for _, f := range files {
+		data, err := testdata.ReadFile(path.Join("testdata", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var v Vector
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("vector %s: %w", f.Name(), err)
+		}
+		vectors = append(vectors, v)
+	}
+	sort.Slice(vectors, func(i, j int) bool { return vectors[i].Name < vectors[j].Name })
+	return vectors, nil
+}
+
+// Report records the outcome of running an implementation against the vectors
+type Report struct {
+	Vectors  int      // Vectors run
+	Cases    int      // Cases decoded
+	Encodes  int      // Vectors encoded, if the implementation has an encoder
+	Failures []string // A description of each case or encode that failed
+}
+
+// Err returns an error listing the failures, if there are any
+func (r *Report) Err() error {
+	if len(r.Failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d failures:\n  %s", ErrNonconformant, len(r.Failures), strings.Join(r.Failures, "\n  "))
+}
+
+// Run decodes every case of the vectors with an implementation, and encodes
+// every vector if it has an encoder, checking that it produces the output
+// expected and fails where it should. Failures are recorded in the report;
+// the error returned is for vectors that cannot be read.
+func Run(ctx context.Context, impl Implementation, vectors []Vector) (*Report, error) {
+	log := trace.FromContext(ctx).WithPrefix("CONFORMANCE")
+	report := &Report{}
+
+	for _, v := range vectors {
+		report.Vectors++
+		for i, c := range v.Cases {
+			report.Cases++
+			collections := make([][]byte, len(c.Collections))
+			for j, key := range c.Collections {
+				data, ok := v.Collections[key]
+				if !ok {
+					return report, fmt.Errorf("vector %s case %d: no collection %s", v.Name, i+1, key)
+				}
+				collections[j] = data
+			}
+
+			output, err := impl.Decode(ctx, collections)
+			label := fmt.Sprintf("%s: decoding %s", v.Name, strings.Join(c.Collections, ", "))
+			switch {
+			case c.Error != "" && err == nil:
+				report.Failures = append(report.Failures, fmt.Sprintf("%s succeeded, but should fail: %s", label, c.Error))
+			case c.Error == "" && err != nil:
+				report.Failures = append(report.Failures, fmt.Sprintf("%s failed: %v", label, err))
+			case c.Error == "" && !bytes.Equal(output, c.Output):
+				report.Failures = append(report.Failures, fmt.Sprintf("%s produced %d bytes that differ from the %d expected", label, len(output), len(c.Output)))
+			default:
+				log.Debugf("%s: ok", label)
+			}
+		}
+
+		if impl.Encode == nil {
+			continue
+		}
+		report.Encodes++
+		collections, err := impl.Encode(ctx, v.Spec)
+		if err != nil {
+			report.Failures = append(report.Failures, fmt.Sprintf("%s: encoding failed: %v", v.Name, err))
+			continue
+		}
+		for i := 0; i < v.Copies; i++ {
+			name := pad.CollectionName(v.Required, v.Copies, i)
+			if !bytes.Equal(collections[name], v.Collections[name]) {
+				report.Failures = append(report.Failures, fmt.Sprintf("%s: encoding produced collection %s differing from the vector", v.Name, name))
+			}
+		}
+	}
+
+	log.Debugf("Ran %d vectors: %d cases, %d encodes, %d failures", report.Vectors, report.Cases, report.Encodes, len(report.Failures))
+	return report, nil
+}
+
+// nopWriteCloser adapts a buffer shared by every chunk of a collection
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,136 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// update rewrites the vectors in testdata from specs, with
+// go test ./pkg/conformance -run TestVectors -update
+var update = flag.Bool("update", false, "rewrite the test vectors in testdata")
+
+// specs are the specs of the vectors in testdata
+var specs = []Spec{
+	{Name: "2of2-empty", Description: "Empty input, padded, so that its one chunk carries the marker of a padded encode",
+		Required: 2, Copies: 2, ChunkSize: 64, Padded: true, Seed: 0x00, Plaintext: []byte{}},
+	{Name: "2of3-chunks", Description: "Input spanning several chunks, the last of them short",
+		Required: 2, Copies: 3, ChunkSize: 64, Seed: 0x11,
+		Plaintext: []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 3)), Truncated: true},
+	{Name: "3of5-single", Description: "Input within one chunk, with five collections any three of which decode",
+		Required: 3, Copies: 5, ChunkSize: 600, Seed: 0x22, Plaintext: []byte("padlock 3-of-5 conformance vector\n")},
+	{Name: "2of4-padded", Description: "Input padded to whole chunks, each chunk's plaintext led by its count of data bytes",
+		Required: 2, Copies: 4, ChunkSize: 120, Padded: true, Seed: 0x33, Plaintext: []byte("padded input, shorter than its chunks")},
+	{Name: "2of4-decoy", Description: "A decoy revealed by any two collections including D, the input by any others",
+		Required: 2, Copies: 4, ChunkSize: 120, Padded: true, Seed: 0x44,
+		Plaintext: []byte("the real secret"), Decoy: "D", DecoyPlaintext: []byte("nothing to see here")},
+}
+
+func TestVectors(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelNormal)
+	ctx = trace.WithContext(ctx, tracer)
+
+	if *update {
+		for _, spec := range specs {
+			v, err := Generate(ctx, spec)
+			if err != nil {
+				t.Fatalf("Failed to generate vector %s: %v", spec.Name, err)
+			}
+			data, err := json.MarshalIndent(v, "", "  ")
+			if err != nil {
+				t.Fatalf("Failed to marshal vector %s: %v", spec.Name, err)
+			}
+			if err := os.WriteFile(filepath.Join("testdata", spec.Name+".json"), append(data, '\n'), 0644); err != nil {
+				t.Fatalf("Failed to write vector %s: %v", spec.Name, err)
+			}
+		}
+	}
+
+	vectors, err := Vectors()
+	if err != nil {
+		t.Fatalf("Failed to read vectors: %v", err)
+	}
+	if len(vectors) != len(specs) {
+		t.Fatalf("Expected %d vectors, got %d; rerun with -update", len(specs), len(vectors))
+	}
+
+	t.Run("Reproducible", func(t *testing.T) {
+		for _, spec := range specs {
+			v, err := Generate(ctx, spec)
+			if err != nil {
+				t.Fatalf("Failed to generate vector %s: %v", spec.Name, err)
+			}
+			var embedded *Vector
+			for i := range vectors {
+				if vectors[i].Name == spec.Name {
+					embedded = &vectors[i]
+				}
+			}
+			want, _ := json.Marshal(v)
+			if got, _ := json.Marshal(embedded); !bytes.Equal(got, want) {
+				t.Errorf("Vector %s differs from its spec; rerun with -update if the format changed deliberately", spec.Name)
+			}
+		}
+	})
+
+	t.Run("Reference", func(t *testing.T) {
+		report, err := Run(ctx, Reference, vectors)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if err := report.Err(); err != nil {
+			t.Error(err)
+		}
+		if report.Vectors != len(specs) || report.Encodes != len(specs) || report.Cases < 50 {
+			t.Errorf("Unexpected report %+v", report)
+		}
+	})
+
+	t.Run("Nonconformant", func(t *testing.T) {
+		// A decoder that accepts anything fails the cases that must fail, and
+		// one that ignores the decoy fails the decoy's cases
+		lenient := Implementation{Decode: func(ctx context.Context, collections [][]byte) ([]byte, error) {
+			output, err := ReferenceDecode(ctx, collections)
+			if err != nil {
+				return nil, nil
+			}
+			return output, nil
+		}}
+		report, err := Run(ctx, lenient, vectors)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if !errors.Is(report.Err(), ErrNonconformant) || !strings.Contains(report.Err().Error(), "should fail") {
+			t.Errorf("Expected a lenient decoder to fail, got %v", report.Err())
+		}
+
+		var decoy []Vector
+		for _, v := range vectors {
+			if v.Decoy != "" {
+				decoy = append(decoy, v)
+			}
+		}
+		plain := Implementation{Decode: func(ctx context.Context, collections [][]byte) ([]byte, error) {
+			if _, err := ReferenceDecode(ctx, collections); err != nil {
+				return nil, err
+			}
+			return decoy[0].Plaintext, nil
+		}}
+		report, err = Run(ctx, plain, decoy)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if report.Encodes != 0 || !strings.Contains(report.Err().Error(), "differ from") {
+			t.Errorf("Expected a decoder ignoring the decoy to fail, got %v", report.Err())
+		}
+	})
+}
@@ -16,6 +16,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -28,23 +29,128 @@ import (
 // A collection is one of the N shares in the K-of-N threshold scheme. Each collection
 // contains chunks of encoded data that, when combined with chunks from K-1 other
 // collections, can reconstruct the original data. Collections can be stored as
-// directories on disk or packaged as ZIP files for distribution.
+// directories on disk, packed into single files, or packaged as ZIP or tar.gz
+// files for distribution.
 type Collection struct {
 	Name   string // The name of the collection (e.g., "3A5")
 	Path   string // The filesystem path to the collection
-	Format Format // The format of the data chunks (binary or PNG)
+	Format Format // The format of the data chunks (binary, PNG, or binary in a packed file)
+
+	// Naming is the naming of the collection's chunk files, if not the
+	// default naming of its format
+	Naming ChunkNaming
+
+	// Volumes lists the collection directory within each volume, in volume
+	// order, for collections split across multiple volumes. Path is the first.
+	Volumes []string
+
+	// Zip is set when Path is a zip archive holding the collection, either on
+	// its own or in a custodian bundle, whose files are read in place rather
+	// than extracted
+	Zip bool
+
+	// Tar is set when Path is a gzipped tar archive of the collection, whose
+	// files are read in place rather than extracted
+	Tar bool
+
+	// Store is set when Path is the manifest of a collection whose files are
+	// kept in a chunk store, from which they are read
+	Store bool
+
+	archive collectionArchive
+
+	// index lists the chunk files of a collection directory, if it has a
+	// valid index, so that they are found without scanning
+	index *ChunkIndex
+}
+
+// collectionArchive is an archive from which the files of a collection are
+// read in place
+type collectionArchive interface {
+	names() []string
+	has(name string) bool
+	open(name string) (io.ReadCloser, error)
+	readFile(name string) ([]byte, error)
+	close() error
+}
+
+// CloseCollections closes the archives that collections found by
+// FindCollections are read from in place
+func CloseCollections(collections []Collection) {
+	for _, coll := range collections {
+		if coll.archive != nil {
+			coll.archive.close()
+		}
+	}
+}
+
+// fileNames returns the names of the files in one directory of a collection,
+// or in its archive
+func (c Collection) fileNames(dir string) ([]string, error) {
+	if c.archive != nil {
+		return c.archive.names(), nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collection directory %s: %w", dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// chunkFileName returns the name of the file holding one of the collection's chunks
+func (c Collection) chunkFileName(chunkNumber int) string {
+	return c.Naming.orDefault(c.Format).FileName(c.Name, chunkNumber)
+}
+
+// formatter returns the formatter reading the collection's chunk files
+func (c Collection) formatter() Formatter {
+	return GetNamedFormatter(c.Format, c.Naming)
+}
+
+// openFile opens one of the files of a collection, in its directory or archive
+func (c Collection) openFile(name string) (io.ReadCloser, error) {
+	if c.archive != nil {
+		return c.archive.open(name)
+	}
+	return os.Open(filepath.Join(c.Path, name))
+}
+
+// OpenFile opens a file kept in a collection along with its chunks, such as
+// the encrypted data of a hybrid encode, in its directory or archive
+func (c Collection) OpenFile(name string) (io.ReadCloser, error) {
+	return c.openFile(name)
 }
 
 // CreateCollections creates collection directories for the padlock scheme
 func CreateCollections(ctx context.Context, outputDir string, collectionNames []string) ([]Collection, error) {
+	outputDirs := make([]string, len(collectionNames))
+	for i := range outputDirs {
+		outputDirs[i] = outputDir
+	}
+	return CreateCollectionsAt(ctx, outputDirs, collectionNames)
+}
+
+// CreateCollectionsAt creates the directory of each collection in the output
+// directory given for it, so that collections can be written to separate
+// devices
+func CreateCollectionsAt(ctx context.Context, outputDirs []string, collectionNames []string) ([]Collection, error) {
 	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
 
-	log.Debugf("Creating %d collections in %s", len(collectionNames), outputDir)
+	if len(outputDirs) != len(collectionNames) {
+		return nil, fmt.Errorf("%d output directories given for %d collections", len(outputDirs), len(collectionNames))
+	}
+	log.Debugf("Creating %d collections in %s", len(collectionNames), strings.Join(uniqueStrings(outputDirs), ", "))
 
 	// Create collections
 	collections := make([]Collection, len(collectionNames))
 	for i, collName := range collectionNames {
-		collPath, err := CreateCollectionDirectory(ctx, outputDir, collName)
+		collPath, err := CreateCollectionDirectory(ctx, outputDirs[i], collName)
 		if err != nil {
 			return nil, err
 		}
@@ -60,56 +166,135 @@ func CreateCollections(ctx context.Context, outputDir string, collectionNames []
 	return collections, nil
 }
 
-// FindCollections locates collection directories or ZIP files in the input directory
+// uniqueStrings returns the distinct strings of a list, in order
+func uniqueStrings(list []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+// FindCollections locates collection directories, ZIP files, tar.gz files or
+// the manifests of stored collections in the input directory. Directories and
+// archives not named as collections, such as a folder renamed "dad's share",
+// are identified by the headers of their chunks, and folders holding none are
+// searched for collections up to maxNestingDepth levels deep.
 func FindCollections(ctx context.Context, inputDir string) ([]Collection, string, error) {
+	return findCollectionsIn(ctx, inputDir, 0)
+}
+
+// findCollectionsIn finds the collections in the input directory as
+// FindCollections does, but as if it were depth levels below the directory
+// searched, so that folders in it are searched for nested collections only
+// until maxNestingDepth is reached
+func findCollectionsIn(ctx context.Context, inputDir string, depth int) ([]Collection, string, error) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
+
+	collections, tempDir, err := findCollections(ctx, inputDir, depth, "")
+	if err != nil {
+		RemoveTemp(ctx, tempDir)
+		return nil, "", err
+	}
+
+	if len(collections) == 0 {
+		log.Error(fmt.Errorf("%w in %s", ErrNoCollections, inputDir))
+		RemoveTemp(ctx, tempDir)
+		return nil, "", fmt.Errorf("%w in %s", ErrNoCollections, inputDir)
+	}
+
+	// Load the index of each collection directory, if it has one
+	for i := range collections {
+		if collections[i].archive == nil {
+			collections[i].index = loadIndex(ctx, collections[i])
+		}
+	}
+
+	// Sort collections by name
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+
+	log.Debugf("Found %d collections", len(collections))
+	return collections, tempDir, nil
+}
+
+// findCollections gathers the collections in a directory found depth levels
+// below the input directory, extracting archives into tempDir, or into a new
+// temporary directory if it is empty, which it returns
+func findCollections(ctx context.Context, inputDir string, depth int, tempDir string) ([]Collection, string, error) {
 	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
 
 	log.Debugf("Finding collections in %s", inputDir)
 
-	// Create a temporary directory for extracted zip files if needed
-	tempDir := ""
-	hasZipFiles := false
+	hasArchives := false
 
-	// Check if we have zip files in the input directory
+	// Check if we have zip or tar.gz files in the input directory
 	files, err := os.ReadDir(inputDir)
+	if err != nil && depth > 0 {
+		log.Debugf("Not searching %s: %v", inputDir, err)
+		return nil, tempDir, nil
+	}
 	if err != nil {
 		log.Error(fmt.Errorf("failed to read input directory: %w", err))
-		return nil, "", fmt.Errorf("failed to read input directory: %w", err)
+		return nil, tempDir, fmt.Errorf("failed to read input directory: %w", err)
 	}
 
 	for _, file := range files {
-		if !file.IsDir() && filepath.Ext(file.Name()) == ".zip" {
-			hasZipFiles = true
+		if _, isTar := tarArchiveBase(file.Name()); !file.IsDir() && (filepath.Ext(file.Name()) == ".zip" || isTar) {
+			hasArchives = true
 			break
 		}
 	}
 
-	if hasZipFiles {
-		log.Debugf("Found zip files, creating temporary directory for extraction")
-		var err error
-		tempDir, err = os.MkdirTemp("", "padlock-*")
-		if err != nil {
-			log.Error(fmt.Errorf("failed to create temporary directory: %w", err))
-			return nil, "", fmt.Errorf("failed to create temporary directory: %w", err)
-		}
-		log.Debugf("Created temporary directory: %s", tempDir)
-	}
-
-	// Gather collections from directories and zip files
+	// Gather collections from directories and archives, grouping the volumes
+	// of multi-volume collections so that they can be merged afterwards
 	var collections []Collection
+	var nested []string
+	volumeParts := make(map[string][]volumePart)
 
 	// First, gather all collection directories
 	log.Debugf("Checking for collection directories")
 	for _, entry := range files {
 		if entry.IsDir() {
 			collName := entry.Name()
+			// Check if this is one volume of a multi-volume collection (e.g. "3A5.vol01")
+			if volName, index, ok := parseVolumeDirName(collName); ok {
+				collPath := filepath.Join(inputDir, collName, volName)
+				log.Debugf("Found volume %d of collection %s: %s", index, volName, collPath)
+				volumeParts[volName] = append(volumeParts[volName], volumePart{index: index, collPath: collPath})
+				continue
+			}
+			// Check if this is a custodian bundle holding several collections
+			if bundleDir := filepath.Join(inputDir, collName); isCustodianBundle(bundleDir) {
+				bundled, err := bundleCollections(ctx, bundleDir)
+				if err != nil {
+					log.Error(fmt.Errorf("failed to read custodian bundle %s: %w", collName, err))
+					continue
+				}
+				log.Debugf("Found custodian bundle %s with %d collections", bundleDir, len(bundled))
+				collections = append(collections, bundled...)
+				continue
+			}
+			// Check if this is a collection whose names have been obfuscated
+			if collPath := filepath.Join(inputDir, collName); hasObfuscatedManifest(collPath) {
+				if coll, ok := openObfuscatedCollection(ctx, dirArchive{dir: collPath}, collPath); ok {
+					collections = append(collections, coll)
+					log.Debugf("Added obfuscated collection %s with format %s", coll.Name, coll.Format)
+				}
+				continue
+			}
 			// Check if this looks like a collection directory (e.g. "3A5")
 			if len(collName) >= 3 && isCollectionName(collName) {
 				collPath := filepath.Join(inputDir, collName)
 				log.Debugf("Found collection directory: %s", collPath)
 
 				// Determine the format by looking at the files
-				format, err := determineCollectionFormat(collPath)
+				format, naming, err := determineCollectionFormat(collPath)
 				if err != nil {
 					log.Error(fmt.Errorf("failed to determine format for collection %s: %w", collName, err))
 					continue
@@ -119,67 +304,267 @@ func FindCollections(ctx context.Context, inputDir string) ([]Collection, string
 					Name:   collName,
 					Path:   collPath,
 					Format: format,
+					Naming: naming,
 				})
 
 				log.Debugf("Added collection %s with format %s", collName, format)
+			} else if coll, ok := openRenamedCollection(ctx, filepath.Join(inputDir, collName)); ok {
+				collections = append(collections, coll)
+				log.Debugf("Added collection %s from directory %s with format %s", coll.Name, collName, coll.Format)
+			} else if depth < maxNestingDepth && isNestingDir(filepath.Join(inputDir, collName)) {
+				nested = append(nested, filepath.Join(inputDir, collName))
 			}
 		}
 	}
 
-	// Then extract zip files if needed
-	if hasZipFiles {
-		log.Debugf("Checking for collection zip files")
-		for _, entry := range files {
-			if !entry.IsDir() && filepath.Ext(entry.Name()) == ".zip" {
-				zipPath := filepath.Join(inputDir, entry.Name())
-				log.Debugf("Found collection zip file: %s", zipPath)
+	// Then read zip and tar.gz files in place, or extract those holding volumes,
+	// or tar.gz files holding bundles
+	// Gather collections kept in chunk stores, by their manifests
+	for _, entry := range files {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), StoreManifestSuffix) {
+			continue
+		}
+		if coll, ok := openStoreCollection(ctx, filepath.Join(inputDir, entry.Name())); ok {
+			collections = append(collections, coll)
+			log.Debugf("Added collection %s from its chunk store with format %s", coll.Name, coll.Format)
+		}
+	}
 
-				// Extract the zip file
-				extractedDir, err := ExtractZipCollection(ctx, zipPath, tempDir)
-				if err != nil {
-					log.Error(fmt.Errorf("failed to extract zip collection %s: %w", zipPath, err))
+	// Gather collections packed into single files, read in place
+	for _, entry := range files {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != PackedSuffix {
+			continue
+		}
+		if coll, ok := openPackedCollection(ctx, filepath.Join(inputDir, entry.Name())); ok {
+			collections = append(collections, coll)
+			log.Debugf("Added collection %s read in place from its packed file", coll.Name)
+		}
+	}
+
+	if hasArchives {
+		log.Debugf("Checking for collection zip and tar.gz files")
+		for _, entry := range files {
+			if entry.IsDir() {
+				continue
+			}
+			archivePath := filepath.Join(inputDir, entry.Name())
+			var extract func(context.Context, string, string) (string, error)
+			if filepath.Ext(entry.Name()) == ".zip" {
+				log.Debugf("Found collection zip file: %s", archivePath)
+
+				// A zip of a single collection or of a custodian bundle is read in place
+				open := func() (*zipArchive, error) { return openZipArchive(ctx, archivePath) }
+				if coll, ok := openZipCollection(ctx, archivePath, open); ok {
+					collections = append(collections, coll)
+					log.Debugf("Added collection %s read in place from zip with format %s", coll.Name, coll.Format)
 					continue
 				}
-
-				collName := filepath.Base(extractedDir)
-				if !isCollectionName(collName) {
-					log.Error(fmt.Errorf("invalid collection name in zip file: %s", collName))
+				if bundled, ok := openZipBundle(ctx, archivePath, open); ok {
+					collections = append(collections, bundled...)
+					log.Debugf("Found custodian bundle %s with %d collections, read in place", archivePath, len(bundled))
+					continue
+				}
+				if coll, ok := openObfuscatedArchive(ctx, archivePath, func() (collectionArchive, error) { return open() }); ok {
+					collections = append(collections, coll)
+					log.Debugf("Added obfuscated collection %s read in place from zip with format %s", coll.Name, coll.Format)
+					continue
+				}
+				extract = ExtractZipCollection
+			} else if _, ok := tarArchiveBase(entry.Name()); ok {
+				log.Debugf("Found collection tar.gz file: %s", archivePath)
+
+				// A tar.gz of a single collection is read in place
+				if coll, ok := openTarCollection(ctx, archivePath); ok {
+					collections = append(collections, coll)
+					log.Debugf("Added collection %s read in place from tar.gz with format %s", coll.Name, coll.Format)
 					continue
 				}
+				open := func() (collectionArchive, error) { return openTarArchive(archivePath) }
+				if coll, ok := openObfuscatedArchive(ctx, archivePath, open); ok {
+					collections = append(collections, coll)
+					log.Debugf("Added obfuscated collection %s read in place from tar.gz with format %s", coll.Name, coll.Format)
+					continue
+				}
+				extract = ExtractTarCollection
+			} else {
+				continue
+			}
 
-				// Determine the format by looking at the files
-				format, err := determineCollectionFormat(extractedDir)
+			// Extract the archive
+			if tempDir == "" {
+				tempDir, err = MkdirTemp(ctx)
+				if err != nil {
+					CloseCollections(collections)
+					return nil, "", err
+				}
+			}
+			extractedDir, err := extract(ctx, archivePath, tempDir)
+			if err != nil {
+				log.Error(fmt.Errorf("failed to extract collection archive %s: %w", archivePath, err))
+				continue
+			}
+
+			collName := filepath.Base(extractedDir)
+			if volName, index, ok := parseVolumeDirName(collName); ok {
+				collPath := filepath.Join(extractedDir, volName)
+				log.Debugf("Found volume %d of collection %s in archive: %s", index, volName, collPath)
+				volumeParts[volName] = append(volumeParts[volName], volumePart{index: index, collPath: collPath})
+				continue
+			}
+			if isCustodianBundle(extractedDir) {
+				bundled, err := bundleCollections(ctx, extractedDir)
 				if err != nil {
-					log.Error(fmt.Errorf("failed to determine format for extracted collection %s: %w", collName, err))
+					log.Error(fmt.Errorf("failed to read custodian bundle %s: %w", archivePath, err))
 					continue
 				}
+				log.Debugf("Found custodian bundle %s with %d collections", archivePath, len(bundled))
+				collections = append(collections, bundled...)
+				continue
+			}
+			if hasObfuscatedManifest(extractedDir) {
+				if coll, ok := openObfuscatedCollection(ctx, dirArchive{dir: extractedDir}, extractedDir); ok {
+					collections = append(collections, coll)
+					log.Debugf("Added obfuscated collection %s from archive with format %s", coll.Name, coll.Format)
+				}
+				continue
+			}
+			if !isCollectionName(collName) {
+				if coll, ok := openRenamedCollection(ctx, extractedDir); ok {
+					collections = append(collections, coll)
+					log.Debugf("Added collection %s from archive %s with format %s", coll.Name, entry.Name(), coll.Format)
+				} else if depth < maxNestingDepth {
+					nested = append(nested, extractedDir)
+				} else {
+					log.Error(fmt.Errorf("invalid collection name in archive: %s", collName))
+				}
+				continue
+			}
 
-				collections = append(collections, Collection{
-					Name:   collName,
-					Path:   extractedDir,
-					Format: format,
-				})
+			// Determine the format by looking at the files
+			format, naming, err := determineCollectionFormat(extractedDir)
+			if err != nil {
+				log.Error(fmt.Errorf("failed to determine format for extracted collection %s: %w", collName, err))
+				continue
+			}
+
+			collections = append(collections, Collection{
+				Name:   collName,
+				Path:   extractedDir,
+				Format: format,
+				Naming: naming,
+			})
+
+			log.Debugf("Added collection %s from archive with format %s", collName, format)
+		}
+	}
 
-				log.Debugf("Added collection %s from zip with format %s", collName, format)
+	// Merge the volumes of each multi-volume collection, skipping incomplete ones
+	for collName, parts := range volumeParts {
+		coll, err := mergeVolumes(ctx, collName, parts)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		collections = append(collections, coll)
+		log.Debugf("Added collection %s from %d volumes with format %s", collName, len(parts), coll.Format)
+	}
+
+	// Search the folders that are not collections for collections nested in
+	// them, preferring those found nearer the input directory
+	for _, dir := range nested {
+		var found []Collection
+		var err error
+		found, tempDir, err = findCollections(ctx, dir, depth+1, tempDir)
+		if err != nil {
+			CloseCollections(collections)
+			return nil, tempDir, err
+		}
+		for _, coll := range found {
+			if hasCollectionNamed(collections, coll.Name) {
+				log.Infof("Warning: ignoring collection %s in %s, as another collection %s was found", coll.Name, dir, coll.Name)
+				CloseCollections([]Collection{coll})
+				continue
 			}
+			log.Debugf("Added collection %s nested in %s", coll.Name, dir)
+			collections = append(collections, coll)
 		}
 	}
+	return collections, tempDir, nil
+}
 
-	if len(collections) == 0 {
-		log.Error(fmt.Errorf("no collections found in %s", inputDir))
-		if tempDir != "" {
-			os.RemoveAll(tempDir)
+// FindCollectionsAt locates the collections stored at a path: a collection
+// directory, zip, tar.gz or volume, the manifest of a stored collection, a
+// custodian bundle, or else a directory holding any of these. Like
+// FindCollections, it returns the temporary directory of any that had to be
+// extracted.
+func FindCollectionsAt(ctx context.Context, path string) ([]Collection, string, error) {
+	path = filepath.Clean(path)
+	dir := filepath.Dir(path)
+	if found, tempDir, err := findCollectionsIn(ctx, dir, maxNestingDepth); err == nil {
+		var collections, others []Collection
+		for _, coll := range found {
+			if collectionSource(dir, tempDir, coll) == path || strings.HasPrefix(coll.Path, path+string(filepath.Separator)) {
+				collections = append(collections, coll)
+			} else {
+				others = append(others, coll)
+			}
+		}
+		CloseCollections(others)
+		if len(collections) > 0 {
+			return collections, tempDir, nil
 		}
-		return nil, "", fmt.Errorf("no collections found in %s", inputDir)
+		RemoveTemp(ctx, tempDir)
 	}
+	return FindCollections(ctx, path)
+}
 
-	// Sort collections by name
-	sort.Slice(collections, func(i, j int) bool {
-		return collections[i].Name < collections[j].Name
-	})
+// OpenZipReader opens the collections held by a zip read from r, such as one
+// uploaded to a browser, without touching the filesystem. The zip of a single
+// collection is identified by its name (e.g. "3A5.zip") or, if it has been
+// renamed, by the names of its chunk files; custodian bundles and obfuscated
+// collections are recognized by their manifests, as on disk. r must remain
+// readable until the collections are closed with CloseCollections.
+func OpenZipReader(ctx context.Context, name string, r io.ReaderAt, size int64) ([]Collection, error) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
 
-	log.Debugf("Found %d collections", len(collections))
-	return collections, tempDir, nil
+	open := func() (*zipArchive, error) { return readZipArchive(ctx, name, r, size) }
+	archive, err := open()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	zipName := name
+	if collName := strings.TrimSuffix(name, ".zip"); !isCollectionName(collName) {
+		if inferred, ok := chunkCollectionName(archive.names()); ok {
+			zipName = inferred + ".zip"
+		}
+	}
+	archive.close()
+
+	if coll, ok := openZipCollection(ctx, zipName, open); ok {
+		coll.Path = name
+		return []Collection{coll}, nil
+	}
+	if bundled, ok := openZipBundle(ctx, name, open); ok {
+		return bundled, nil
+	}
+	if coll, ok := openObfuscatedArchive(ctx, name, func() (collectionArchive, error) { return open() }); ok {
+		return []Collection{coll}, nil
+	}
+	log.Error(fmt.Errorf("%w in %s", ErrNoCollections, name))
+	return nil, fmt.Errorf("%w in %s", ErrNoCollections, name)
+}
+
+// chunkCollectionName returns the name of the collection whose chunk files
+// have the default naming (e.g. "3A5_0001.bin" or "IMG3A5_0001.PNG")
+func chunkCollectionName(names []string) (string, bool) {
+	for _, name := range names {
+		base := strings.TrimPrefix(strings.TrimSuffix(name, path.Ext(name)), "IMG")
+		if collName, _, ok := strings.Cut(base, "_"); ok && isCollectionName(collName) {
+			return collName, true
+		}
+	}
+	return "", false
 }
 
 // ZipCollections creates zip archives for each collection
@@ -209,25 +594,65 @@ func ZipCollections(ctx context.Context, collections []Collection) ([]string, er
 	return zipPaths, nil
 }
 
-// determineCollectionFormat determines the format of a collection by looking at its files
-func determineCollectionFormat(collPath string) (Format, error) {
-	files, err := os.ReadDir(collPath)
+// determineCollectionFormat determines the format of a collection, and the
+// naming of its chunks, by looking at its files
+func determineCollectionFormat(collPath string) (Format, ChunkNaming, error) {
+	return detectFormat(dirArchive{dir: collPath}, filepath.Base(collPath))
+}
+
+// openZipCollection opens a zip holding a single collection (e.g. "3A5.zip"),
+// to be read in place. Zips of a volume are not, as the volumes of a collection
+// are merged by directory, and neither are damaged zips, which are extracted as
+// far as possible instead. The zip is opened by open.
+func openZipCollection(ctx context.Context, zipPath string, open func() (*zipArchive, error)) (Collection, bool) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
+
+	collName := strings.TrimSuffix(filepath.Base(zipPath), ".zip")
+	if _, _, ok := parseVolumeDirName(collName); ok || !isCollectionName(collName) {
+		return Collection{}, false
+	}
+	archive, err := open()
 	if err != nil {
-		return "", fmt.Errorf("failed to read collection directory: %w", err)
+		log.Debugf("Not reading %s in place: %v", zipPath, err)
+		return Collection{}, false
 	}
-
-	for _, f := range files {
-		name := f.Name()
-		if !f.IsDir() {
-			if strings.HasPrefix(name, "IMG") && strings.HasSuffix(strings.ToUpper(name), ".PNG") {
-				return FormatPNG, nil
-			} else if strings.HasSuffix(name, ".bin") {
-				return FormatBin, nil
-			}
-		}
+	if archive.has(CustodianManifestFileName) || archive.has(ObfuscatedManifestFileName) {
+		archive.close()
+		return Collection{}, false
+	}
+	format, naming, err := detectFormat(archive, collName)
+	if err != nil {
+		archive.close()
+		return Collection{}, false
 	}
+	return Collection{Name: collName, Path: zipPath, Format: format, Naming: naming, Zip: true, archive: archive}, true
+}
+
+// openTarCollection opens a gzipped tar archive holding a single collection
+// (e.g. "3A5.tar.gz") to be read in place. Those of a volume or a custodian
+// bundle are extracted instead, as are damaged ones.
+func openTarCollection(ctx context.Context, tarPath string) (Collection, bool) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
 
-	return "", fmt.Errorf("unable to determine format for collection")
+	collName, _ := tarArchiveBase(filepath.Base(tarPath))
+	if _, _, ok := parseVolumeDirName(collName); ok || !isCollectionName(collName) {
+		return Collection{}, false
+	}
+	archive, err := openTarArchive(tarPath)
+	if err != nil {
+		log.Debugf("Not reading %s in place: %v", tarPath, err)
+		return Collection{}, false
+	}
+	if archive.has(ObfuscatedManifestFileName) {
+		archive.close()
+		return Collection{}, false
+	}
+	format, naming, err := detectFormat(archive, collName)
+	if err != nil {
+		archive.close()
+		return Collection{}, false
+	}
+	return Collection{Name: collName, Path: tarPath, Format: format, Naming: naming, Tar: true, archive: archive}, true
 }
 
 // isCollectionName checks if a string looks like a collection name (e.g. "3A5")
@@ -261,6 +686,9 @@ type CollectionReader struct {
 	Collection Collection
 	ChunkIndex int
 	Formatter  Formatter
+
+	parity       *collectionParity
+	parityLoaded bool
 }
 
 // NewCollectionReader creates a new collection reader
@@ -268,7 +696,7 @@ func NewCollectionReader(collection Collection) *CollectionReader {
 	return &CollectionReader{
 		Collection: collection,
 		ChunkIndex: 1, // Start at chunk 1
-		Formatter:  GetFormatter(collection.Format),
+		Formatter:  collection.formatter(),
 	}
 }
 
@@ -278,12 +706,67 @@ func (cr *CollectionReader) ReadNextChunk(ctx context.Context) ([]byte, error) {
 
 	log.Debugf("Reading chunk %d from collection %s", cr.ChunkIndex, cr.Collection.Name)
 
-	// Check if we're looking for a chunk that exists before trying to read it
-	var filePath string
-	if cr.Collection.Format == FormatPNG {
-		filePath = filepath.Join(cr.Collection.Path, fmt.Sprintf("IMG%s_%04d.PNG", cr.Collection.Name, cr.ChunkIndex))
-	} else {
-		filePath = filepath.Join(cr.Collection.Path, fmt.Sprintf("%s_%04d.bin", cr.Collection.Name, cr.ChunkIndex))
+	// Chunks protected by parity are verified, and rebuilt if they are damaged
+	if !cr.parityLoaded {
+		cr.parityLoaded = true
+		parity, err := loadParity(ctx, cr.Collection)
+		if err != nil {
+			return nil, err
+		}
+		cr.parity = parity
+	}
+	if cr.parity != nil && cr.ChunkIndex <= cr.parity.lastChunk() {
+		data, err := cr.parity.readChunk(ctx, cr.ChunkIndex)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		cr.ChunkIndex++
+		return data, nil
+	}
+
+	// Chunks of an archive are read in place, ending at the first missing one
+	if cr.Collection.archive != nil {
+		if !cr.Collection.archive.has(findArchived(cr.Collection.archive, cr.Collection.chunkFileName(cr.ChunkIndex))) {
+			log.Debugf("No more chunks in collection %s after chunk %d", cr.Collection.Name, cr.ChunkIndex-1)
+			return nil, io.EOF
+		}
+		data, err := readArchivedChunk(ctx, cr.Collection, cr.ChunkIndex)
+		if err != nil {
+			log.Error(fmt.Errorf("failed to read chunk %d from collection %s: %w", cr.ChunkIndex, cr.Collection.Name, err))
+			return nil, err
+		}
+		cr.ChunkIndex++
+		return data, nil
+	}
+
+	// Chunks of an indexed collection are found through its index, ending at
+	// the first not listed; one listed but missing is reported as corrupt
+	if index := cr.Collection.index; index != nil {
+		entry, ok := index.byNumber[cr.ChunkIndex]
+		if !ok {
+			log.Debugf("No more indexed chunks in collection %s after chunk %d", cr.Collection.Name, cr.ChunkIndex-1)
+			return nil, io.EOF
+		}
+		data, err := cr.Formatter.ReadChunk(ctx, index.dir(cr.Collection, entry), 0, cr.ChunkIndex)
+		if err != nil {
+			log.Error(fmt.Errorf("failed to read chunk %d from collection %s: %w", cr.ChunkIndex, cr.Collection.Name, err))
+			return nil, err
+		}
+		cr.ChunkIndex++
+		return data, nil
+	}
+
+	// Check if we're looking for a chunk that exists before trying to read it,
+	// searching each volume of multi-volume collections
+	collPath := cr.Collection.Path
+	filePath := findFile(filepath.Join(collPath, cr.Collection.chunkFileName(cr.ChunkIndex)))
+	for _, volumePath := range cr.Collection.Volumes {
+		candidate := findFile(filepath.Join(volumePath, cr.Collection.chunkFileName(cr.ChunkIndex)))
+		if _, err := os.Stat(candidate); err == nil {
+			collPath, filePath = volumePath, candidate
+			break
+		}
 	}
 
 	// Extra debug tracing
@@ -297,7 +780,7 @@ func (cr *CollectionReader) ReadNextChunk(ctx context.Context) ([]byte, error) {
 
 	// Read the current chunk
 	currentChunkIndex := cr.ChunkIndex
-	data, err := cr.Formatter.ReadChunk(ctx, cr.Collection.Path, 0, currentChunkIndex)
+	data, err := cr.Formatter.ReadChunk(ctx, collPath, 0, currentChunkIndex)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			log.Debugf("No more chunks in collection %s", cr.Collection.Name)
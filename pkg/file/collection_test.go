@@ -1,13 +1,19 @@
 package file
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/rayozzie/padlock/pkg/paddecode"
 	"github.com/rayozzie/padlock/pkg/trace"
 )
 
@@ -255,6 +261,76 @@ func TestCollectionReader(t *testing.T) {
 	}
 }
 
+func TestOpenZipReader(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// zipOf builds a zip in memory holding the given files
+	zipOf := func(files ...string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, name := range files {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatalf("Failed to create zip entry: %v", err)
+			}
+			fmt.Fprintf(w, "contents of %s", name)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("Failed to close zip: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name    string
+		zipName string
+		data    []byte
+		expect  string
+	}{
+		{"Named after the collection", "3A5.zip", zipOf("3A5_0001.bin", "3A5_0002.bin"), "3A5"},
+		{"Renamed by a download", "3A5 (1).zip", zipOf("3A5_0001.bin", "3A5_0002.bin"), "3A5"},
+		{"Renamed PNG collection", "shares.zip", zipOf("IMG2B3_0001.PNG"), "2B3"},
+		{"Not a collection", "photos.zip", zipOf("holiday.jpg"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collections, err := OpenZipReader(ctx, tt.zipName, bytes.NewReader(tt.data), int64(len(tt.data)))
+			if tt.expect == "" {
+				if !errors.Is(err, ErrNoCollections) {
+					t.Fatalf("Expected ErrNoCollections, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenZipReader failed: %v", err)
+			}
+			defer CloseCollections(collections)
+			if len(collections) != 1 || collections[0].Name != tt.expect || !collections[0].Zip {
+				t.Fatalf("Expected collection %s read from the zip, got %+v", tt.expect, collections)
+			}
+			if collections[0].Path != tt.zipName {
+				t.Errorf("Expected path %s, got %s", tt.zipName, collections[0].Path)
+			}
+
+			f, err := collections[0].openFile(collections[0].chunkFileName(1))
+			if err != nil {
+				t.Fatalf("Failed to open chunk: %v", err)
+			}
+			defer f.Close()
+			data, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatalf("Failed to read chunk: %v", err)
+			}
+			if !bytes.HasPrefix(data, []byte("contents of ")) {
+				t.Errorf("Unexpected chunk contents %q", data)
+			}
+		})
+	}
+}
+
 func TestIsCollectionName(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -279,3 +355,143 @@ func TestIsCollectionName(t *testing.T) {
 		})
 	}
 }
+
+func TestFindRenamedCollections(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+
+	// chunk returns a chunk of a collection of a 2-of-3 set, with its header
+	chunk := func(letter byte, number int) []byte {
+		pieces := paddecode.PiecesPerCollection(2, 3)
+		data := make([]byte, paddecode.HeaderBytes+pieces*4)
+		data[1], data[2], data[3], data[4] = paddecode.HeaderVersion, letter, 2, 3
+		binary.BigEndian.PutUint32(data[5:9], uint32(number))
+		binary.BigEndian.PutUint64(data[9:17], uint64(pieces*4))
+		binary.BigEndian.PutUint64(data[17:25], 42)
+		return data
+	}
+	// writeChunks writes two chunks of a collection into dir, named as the
+	// collection would name them
+	writeChunks := func(t *testing.T, dir string, letter byte) {
+		name := fmt.Sprintf("2%c3", 'A'+letter)
+		for n := 1; n <= 2; n++ {
+			w := NewChunkWriter(ctx, GetFormatter(FormatBin), filepath.Join(filepath.Dir(dir), name), 0, n)
+			w.Write(chunk(letter, n))
+			if err := w.Close(); err != nil {
+				t.Fatalf("Failed to write chunk: %v", err)
+			}
+		}
+		if filepath.Base(dir) == name {
+			return
+		}
+		if err := os.Rename(filepath.Join(filepath.Dir(dir), name), dir); err != nil {
+			t.Fatalf("Failed to rename collection: %v", err)
+		}
+	}
+	names := func(collections []Collection) string {
+		var s []string
+		for _, coll := range collections {
+			s = append(s, coll.Name)
+		}
+		return strings.Join(s, ",")
+	}
+
+	inputDir := t.TempDir()
+	writeChunks(t, filepath.Join(inputDir, "dad's share"), 0)
+	writeChunks(t, filepath.Join(inputDir, "backup", "shares", "2B3"), 1)
+	writeChunks(t, filepath.Join(inputDir, "a", "b", "c", "2C3"), 2)
+	if err := os.MkdirAll(filepath.Join(inputDir, "photos"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for _, name := range []string{"IMG_0001.JPG", "IMG_0002.JPG"} {
+		if err := os.WriteFile(filepath.Join(inputDir, "photos", name), []byte("not a chunk"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	t.Run("Renamed and nested", func(t *testing.T) {
+		// Collections nested three levels deep are not searched for
+		collections, _, err := FindCollections(ctx, inputDir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer CloseCollections(collections)
+		if names(collections) != "2A3,2B3" {
+			t.Fatalf("Expected collections 2A3 and 2B3, got %s", names(collections))
+		}
+		for _, coll := range collections {
+			cr := NewCollectionReader(coll)
+			for n := 1; n <= 2; n++ {
+				data, err := cr.ReadNextChunk(ctx)
+				if err != nil || !bytes.Equal(data, chunk(coll.Name[1]-'A', n)) {
+					t.Fatalf("Failed to read chunk %d of %s: %v", n, coll.Name, err)
+				}
+			}
+			if _, err := cr.ReadNextChunk(ctx); err != io.EOF {
+				t.Errorf("Expected EOF after the chunks of %s, got %v", coll.Name, err)
+			}
+		}
+	})
+
+	t.Run("Zip", func(t *testing.T) {
+		// A zip of a folder holding a collection, rather than of the collection
+		dir := t.TempDir()
+		f, err := os.Create(filepath.Join(dir, "mom.zip"))
+		if err != nil {
+			t.Fatalf("Failed to create zip: %v", err)
+		}
+		zw := zip.NewWriter(f)
+		for n := 1; n <= 2; n++ {
+			w, _ := zw.Create(fmt.Sprintf("shares/2C3/2C3_%04d.bin", n))
+			w.Write(chunk(2, n))
+		}
+		zw.Close()
+		f.Close()
+
+		collections, tempDir, err := FindCollections(ctx, dir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer RemoveTemp(ctx, tempDir)
+		defer CloseCollections(collections)
+		if names(collections) != "2C3" {
+			t.Fatalf("Expected collection 2C3, got %s", names(collections))
+		}
+		if data, err := ReadChunk(ctx, collections[0], 2); err != nil || !bytes.Equal(data, chunk(2, 2)) {
+			t.Errorf("Failed to read chunk 2: %v", err)
+		}
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		// A copy of a collection nested deeper gives way to the one found first
+		dir := t.TempDir()
+		writeChunks(t, filepath.Join(dir, "2A3"), 0)
+		writeChunks(t, filepath.Join(dir, "old", "copy"), 0)
+		collections, _, err := FindCollections(ctx, dir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		if names(collections) != "2A3" || collections[0].Path != filepath.Join(dir, "2A3") {
+			t.Errorf("Expected only %s, got %+v", filepath.Join(dir, "2A3"), collections)
+		}
+	})
+
+	t.Run("First chunk damaged", func(t *testing.T) {
+		// Every chunk names its collection, so a renamed collection is still
+		// identified when its first chunk is lost
+		dir := t.TempDir()
+		writeChunks(t, filepath.Join(dir, "mom's share"), 1)
+		if err := os.WriteFile(filepath.Join(dir, "mom's share", ChunkFileName(FormatBin, "2B3", 1)), []byte("damaged"), 0644); err != nil {
+			t.Fatalf("Failed to damage chunk: %v", err)
+		}
+		collections, _, err := FindCollections(ctx, dir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		if names(collections) != "2B3" {
+			t.Fatalf("Expected collection 2B3, got %s", names(collections))
+		}
+		if data, err := ReadChunk(ctx, collections[0], 2); err != nil || !bytes.Equal(data, chunk(1, 2)) {
+			t.Errorf("Failed to read chunk 2: %v", err)
+		}
+	})
+}
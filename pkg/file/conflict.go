@@ -0,0 +1,176 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// ConflictPolicy determines what happens when a restored entry collides with
+// a file that already exists in the output directory.
+type ConflictPolicy string
+
+const (
+	// ConflictNone preserves the original behavior: the output directory must be
+	// empty (or cleared) before decoding, so no per-file conflicts can occur.
+	ConflictNone ConflictPolicy = ""
+
+	// ConflictError fails the restore at the first entry that already exists.
+	ConflictError ConflictPolicy = "error"
+
+	// ConflictOverwrite replaces existing files with the restored ones.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+
+	// ConflictSkip leaves existing files untouched and discards the restored ones.
+	ConflictSkip ConflictPolicy = "skip"
+
+	// ConflictRename restores the entry next to the existing file under a new name.
+	ConflictRename ConflictPolicy = "rename"
+)
+
+// maxConflictListing limits how many file names are listed per category in the conflict summary
+const maxConflictListing = 10
+
+// ParseConflictPolicy converts a command-line value into a ConflictPolicy
+func ParseConflictPolicy(value string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case ConflictNone, ConflictError, ConflictOverwrite, ConflictSkip, ConflictRename:
+		return policy, nil
+	default:
+		return ConflictNone, fmt.Errorf("unknown conflict policy '%s' (expected overwrite, skip, rename or error)", value)
+	}
+}
+
+// conflictResolver applies a ConflictPolicy to entries restored by
+// DeserializeDirectoryFromStream, recording what it did for the final summary.
+type conflictResolver struct {
+	ctx         context.Context
+	policy      ConflictPolicy
+	outputDir   string
+	overwritten []string
+	skipped     []string
+	renamed     []string
+}
+
+// newConflictResolver creates a conflictResolver for the given policy
+func newConflictResolver(ctx context.Context, policy ConflictPolicy, outputDir string) *conflictResolver {
+	return &conflictResolver{ctx: ctx, policy: policy, outputDir: outputDir}
+}
+
+// resolve decides where a non-directory entry should be written. It returns the
+// path to write to, or an empty path if the entry should be skipped. Any existing
+// file that is to be replaced is removed, so the caller never writes through it.
+func (cr *conflictResolver) resolve(outPath string) (string, error) {
+	existing, err := os.Lstat(outPath)
+	if os.IsNotExist(err) {
+		return outPath, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	rel := cr.rel(outPath)
+	switch cr.policy {
+	case ConflictSkip:
+		cr.skipped = append(cr.skipped, rel)
+		return "", nil
+
+	case ConflictRename:
+		renamed, err := freeName(outPath)
+		if err != nil {
+			return "", err
+		}
+		cr.renamed = append(cr.renamed, rel+" -> "+cr.rel(renamed))
+		return renamed, nil
+
+	case ConflictOverwrite:
+		// os.Remove only replaces empty directories; a populated one is never discarded wholesale
+		if err := os.Remove(outPath); err != nil {
+			if existing.IsDir() {
+				return "", fmt.Errorf("cannot overwrite directory %s with a file: %w", rel, err)
+			}
+			return "", fmt.Errorf("cannot overwrite %s: %w", rel, err)
+		}
+		cr.overwritten = append(cr.overwritten, rel)
+		return outPath, nil
+
+	default:
+		return "", fmt.Errorf("%w in the output directory: %s", ErrFileExists, rel)
+	}
+}
+
+// resolveDir checks a directory entry against the output directory. Existing
+// directories are merged into; an existing non-directory is replaced only when
+// overwriting. It reports whether the directory already existed.
+func (cr *conflictResolver) resolveDir(outPath string) (bool, error) {
+	existing, err := os.Lstat(outPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if existing.IsDir() {
+		return true, nil
+	}
+
+	rel := cr.rel(outPath)
+	if cr.policy != ConflictOverwrite {
+		return false, fmt.Errorf("%w in the output directory and is not a directory: %s", ErrFileExists, rel)
+	}
+	if err := os.Remove(outPath); err != nil {
+		return false, fmt.Errorf("cannot overwrite %s with a directory: %w", rel, err)
+	}
+	cr.overwritten = append(cr.overwritten, rel)
+	return false, nil
+}
+
+// summarize logs which existing files were overwritten, skipped or renamed
+func (cr *conflictResolver) summarize() {
+	log := trace.FromContext(cr.ctx).WithPrefix("DESERIALIZE")
+	report := func(format string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		log.Infof(format, len(names))
+		for i, name := range names {
+			if i == maxConflictListing {
+				log.Infof("  ... and %d more", len(names)-maxConflictListing)
+				break
+			}
+			log.Infof("  - %s", name)
+		}
+	}
+	report("Overwrote %d existing files:", cr.overwritten)
+	report("Skipped %d existing files:", cr.skipped)
+	report("Restored %d files under new names to avoid existing files:", cr.renamed)
+}
+
+// rel returns the path of an entry relative to the output directory, for messages
+func (cr *conflictResolver) rel(path string) string {
+	if rel, err := filepath.Rel(cr.outputDir, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// freeName finds an unused name alongside path of the form "name.restored-N.ext"
+func freeName(path string) (string, error) {
+	ext := filepath.Ext(path)
+	if ext == filepath.Base(path) {
+		// Dotfiles like ".profile" have no extension to preserve
+		ext = ""
+	}
+	stem := strings.TrimSuffix(path, ext)
+	for i := 1; i < 10000; i++ {
+		candidate := fmt.Sprintf("%s.restored-%d%s", stem, i, ext)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free name available to restore %s", path)
+}
@@ -0,0 +1,126 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ConflictPolicy
+		wantErr bool
+	}{
+		{"", ConflictNone, false},
+		{"overwrite", ConflictOverwrite, false},
+		{"SKIP", ConflictSkip, false},
+		{" rename ", ConflictRename, false},
+		{"error", ConflictError, false},
+		{"merge", ConflictNone, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseConflictPolicy(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseConflictPolicy(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseConflictPolicy(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeserializeConflictPolicy(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "conflict-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The archived tree: one file that will conflict and one that will not
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(filepath.Join(inputDir, "docs"), 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "docs", "plan.txt"), []byte("archived plan"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "notes.txt"), []byte("archived notes"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	tests := []struct {
+		policy      ConflictPolicy
+		wantErr     bool
+		wantPlan    string
+		wantRenamed bool
+	}{
+		{ConflictOverwrite, false, "archived plan", false},
+		{ConflictSkip, false, "local plan", false},
+		{ConflictRename, false, "local plan", true},
+		{ConflictError, true, "local plan", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			// Populate the output directory with a conflicting file
+			outputDir := filepath.Join(tempDir, "output-"+string(tt.policy))
+			if err := os.MkdirAll(filepath.Join(outputDir, "docs"), 0755); err != nil {
+				t.Fatalf("Failed to create output dir: %v", err)
+			}
+			planPath := filepath.Join(outputDir, "docs", "plan.txt")
+			if err := os.WriteFile(planPath, []byte("local plan"), 0644); err != nil {
+				t.Fatalf("Failed to create existing file: %v", err)
+			}
+
+			stream, err := SerializeDirectoryToStream(ctx, inputDir, SerializeOptions{})
+			if err != nil {
+				t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+			}
+			defer stream.Close()
+
+			err = DeserializeDirectoryFromStream(ctx, outputDir, stream, false, DeserializeOptions{OnConflict: tt.policy})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DeserializeDirectoryFromStream error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrFileExists) {
+				t.Errorf("Expected %v, got %v", ErrFileExists, err)
+			}
+
+			data, err := os.ReadFile(planPath)
+			if err != nil {
+				t.Fatalf("Failed to read plan: %v", err)
+			}
+			if string(data) != tt.wantPlan {
+				t.Errorf("Expected plan %q, got %q", tt.wantPlan, string(data))
+			}
+
+			renamed, err := os.ReadFile(filepath.Join(outputDir, "docs", "plan.restored-1.txt"))
+			if tt.wantRenamed {
+				if err != nil || string(renamed) != "archived plan" {
+					t.Errorf("Expected archived plan restored under a new name, got %q (%v)", string(renamed), err)
+				}
+			} else if err == nil {
+				t.Errorf("Unexpected renamed file for policy %s", tt.policy)
+			}
+
+			if !tt.wantErr {
+				if _, err := os.Stat(filepath.Join(outputDir, "notes.txt")); err != nil {
+					t.Errorf("Non-conflicting file was not restored: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// ConvertCollection writes a copy of a collection into the directory dir, named
+// after the collection, its chunks rewrapped in the given format and named with
+// the given naming, or the format's default if it is the zero value. The chunks
+// themselves are copied unchanged, never combined with those of other
+// collections, so nothing is decoded. Their parity, which protects the chunks
+// rather than the files holding them, stays valid and is copied along with the
+// collection's other files, such as its recovery instructions and decoders. A
+// collection split across volumes is converted into a single directory.
+//
+// Chunks that are damaged but can be rebuilt from parity are written rebuilt;
+// a chunk that is missing, or that cannot be rebuilt, fails the conversion.
+func ConvertCollection(ctx context.Context, coll Collection, dir string, format Format, naming ChunkNaming) error {
+	log := trace.FromContext(ctx).WithPrefix("CONVERT")
+
+	if err := naming.CheckFormat(format); err != nil {
+		log.Error(err)
+		return err
+	}
+	source, err := NewChunkSource(ctx, coll)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read collection %s: %w", coll.Name, err))
+		return fmt.Errorf("failed to read collection %s: %w", coll.Name, err)
+	}
+	if source.LastChunk() == 0 {
+		log.Error(fmt.Errorf("collection %s holds no chunks", coll.Name))
+		return fmt.Errorf("collection %s holds no chunks", coll.Name)
+	}
+
+	formatter := GetNamedFormatter(format, naming)
+	for n := 1; n <= source.LastChunk(); n++ {
+		data, err := source.ReadChunk(ctx, n)
+		if err != nil {
+			log.Error(fmt.Errorf("failed to read chunk %d of collection %s: %w", n, coll.Name, err))
+			return fmt.Errorf("failed to read chunk %d of collection %s: %w", n, coll.Name, err)
+		}
+		if err := formatter.WriteChunk(ctx, dir, 0, n, data); err != nil {
+			log.Error(fmt.Errorf("failed to write chunk %d of collection %s: %w", n, coll.Name, err))
+			return fmt.Errorf("failed to write chunk %d of collection %s: %w", n, coll.Name, err)
+		}
+	}
+	if rebuilt := source.Rebuilt(); len(rebuilt) > 0 {
+		log.Infof("Warning: collection %s had %d damaged chunks, written as rebuilt from parity: %v", coll.Name, len(rebuilt), rebuilt)
+	}
+
+	copied, err := CopyCollectionFiles(ctx, coll, dir)
+	if err != nil {
+		return err
+	}
+	if err := WriteIndex(ctx, Collection{Name: coll.Name, Path: dir, Format: format, Naming: naming}); err != nil {
+		return err
+	}
+
+	log.Debugf("Converted collection %s: %d chunks in %s format, %d other files", coll.Name, source.LastChunk(), format, copied)
+	return nil
+}
+
+// CopyCollectionFiles copies the files of a collection other than its chunks,
+// such as its parity, recovery instructions and decoders, into the directory
+// dir, returning how many were copied. Files that every volume of a collection
+// holds, such as its recovery instructions, are copied once. Manifests are not
+// copied, but the metadata and usage policy of the set recorded in them are.
+// Nor is the index of the collection's chunks, which would not describe dir.
+func CopyCollectionFiles(ctx context.Context, coll Collection, dir string) (int, error) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
+
+	dirs := coll.Volumes
+	if len(dirs) == 0 {
+		dirs = []string{coll.Path}
+	}
+	naming := coll.Naming.orDefault(coll.Format)
+	copied := make(map[string]bool)
+	for _, d := range dirs {
+		names, err := coll.fileNames(d)
+		if err != nil {
+			log.Error(err)
+			return 0, err
+		}
+		for _, name := range names {
+			if _, ok := naming.chunkNumber(coll.Name, name); ok || copied[name] || name == ManifestFileName || name == IndexFileName || strings.ContainsAny(name, `/\`) {
+				continue
+			}
+			copied[name] = true
+			if err := copyCollectionFile(coll, d, name, dir); err != nil {
+				log.Error(fmt.Errorf("failed to copy %s of collection %s: %w", name, coll.Name, err))
+				return 0, fmt.Errorf("failed to copy %s of collection %s: %w", name, coll.Name, err)
+			}
+		}
+	}
+
+	// Manifests describe the layout of the directory they are in, so only the
+	// metadata and usage policy of the set are carried over
+	m, err := ReadCollectionManifest(ctx, coll)
+	if err != nil {
+		return 0, err
+	}
+	if m != nil && (m.Metadata != nil || m.Policy != nil) {
+		if err := WriteMetadata(ctx, dir, coll.Name, m.Metadata, m.Policy); err != nil {
+			return 0, err
+		}
+	}
+	return len(copied), nil
+}
+
+// copyCollectionFile copies one of the files of a collection, from the given
+// directory of it or its archive, into dir
+func copyCollectionFile(coll Collection, from string, name string, dir string) error {
+	var r io.ReadCloser
+	var err error
+	if coll.archive != nil {
+		r, err = coll.archive.open(name)
+	} else {
+		r, err = os.Open(filepath.Join(from, name))
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var mode fs.FileMode = 0644
+	if isDecoderFile(name) && name != DecoderReadmeFileName {
+		mode = 0755
+	}
+	w, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,107 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestConvertCollection(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "convert-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A PNG collection with parity, recovery instructions and a decoder
+	collPath := filepath.Join(tempDir, "input", "2A3")
+	coll := Collection{Name: "2A3", Path: collPath, Format: FormatPNG}
+	chunks := make(map[int][]byte)
+	for n := 1; n <= 5; n++ {
+		chunks[n] = make([]byte, 300)
+		if _, err := rand.Read(chunks[n]); err != nil {
+			t.Fatalf("Failed to generate chunk: %v", err)
+		}
+		if err := GetFormatter(FormatPNG).WriteChunk(ctx, collPath, 0, n, chunks[n]); err != nil {
+			t.Fatalf("Failed to write chunk: %v", err)
+		}
+	}
+	if err := WriteParity(ctx, coll, 20); err != nil {
+		t.Fatalf("WriteParity failed: %v", err)
+	}
+	if err := WriteRecoveryInfo(ctx, collPath, RecoveryInfo{Collection: "2A3", Required: 2, Copies: 3}); err != nil {
+		t.Fatalf("WriteRecoveryInfo failed: %v", err)
+	}
+	decoder := Decoder{OS: "linux", Arch: "amd64", Data: []byte("decoder")}
+	if err := WriteDecoders(ctx, collPath, []Decoder{decoder}, 2); err != nil {
+		t.Fatalf("WriteDecoders failed: %v", err)
+	}
+
+	// A damaged chunk is rebuilt from parity on the way
+	os.Remove(filepath.Join(collPath, ChunkFileName(FormatPNG, "2A3", 3)))
+
+	outPath := filepath.Join(tempDir, "output", "2A3")
+	if err := os.MkdirAll(outPath, 0755); err != nil {
+		t.Fatalf("Failed to create output dir: %v", err)
+	}
+	if err := ConvertCollection(ctx, coll, outPath, FormatBin, ChunkNaming{}); err != nil {
+		t.Fatalf("ConvertCollection failed: %v", err)
+	}
+
+	converted := Collection{Name: "2A3", Path: outPath, Format: FormatBin}
+	for n := 1; n <= 5; n++ {
+		data, err := os.ReadFile(filepath.Join(outPath, ChunkFileName(FormatBin, "2A3", n)))
+		if err != nil {
+			t.Fatalf("Failed to read converted chunk %d: %v", n, err)
+		}
+		if !bytes.Equal(data, chunks[n]) {
+			t.Errorf("Converted chunk %d differs from the original", n)
+		}
+	}
+	for _, name := range []string{RecoveryInfoFileName, RecoveryReadmeFileName, DecoderReadmeFileName, decoder.FileName(), ParityFileName("2A3", 1, 0)} {
+		if _, err := os.Stat(filepath.Join(outPath, name)); err != nil {
+			t.Errorf("Expected %s to be copied: %v", name, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(outPath, decoder.FileName())); err == nil && info.Mode().Perm()&0100 == 0 {
+		t.Errorf("Expected the decoder to stay executable, got mode %v", info.Mode())
+	}
+	if matches, _ := filepath.Glob(filepath.Join(outPath, "*.PNG")); len(matches) != 0 {
+		t.Errorf("Expected no PNG chunks in the converted collection, got %v", matches)
+	}
+
+	// The copied parity protects the converted chunks
+	os.Remove(filepath.Join(outPath, ChunkFileName(FormatBin, "2A3", 2)))
+	source, err := NewChunkSource(ctx, converted)
+	if err != nil {
+		t.Fatalf("NewChunkSource failed: %v", err)
+	}
+	if data, err := source.ReadChunk(ctx, 2); err != nil || !bytes.Equal(data, chunks[2]) {
+		t.Errorf("Expected chunk 2 to be rebuilt from the copied parity (%v)", err)
+	}
+
+	// A chunk that cannot be rebuilt fails the conversion
+	emptyPath := filepath.Join(tempDir, "empty", "2A3")
+	os.MkdirAll(emptyPath, 0755)
+	for _, n := range []int{1, 2} {
+		os.Remove(filepath.Join(collPath, ChunkFileName(FormatPNG, "2A3", n)))
+	}
+	if err := ConvertCollection(ctx, coll, emptyPath, FormatBin, ChunkNaming{}); err == nil {
+		t.Errorf("Expected an error converting a collection missing more chunks than parity can rebuild")
+	}
+
+	// A naming that doesn't suit the format is refused
+	if err := ConvertCollection(ctx, coll, emptyPath, FormatBin, DefaultChunkNaming(FormatPNG)); err == nil {
+		t.Errorf("Expected an error naming bin chunks .PNG")
+	}
+}
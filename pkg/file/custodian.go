@@ -0,0 +1,188 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// CustodianManifestFileName is the name of the manifest file stored in each
+// custodian bundle
+const CustodianManifestFileName = "padlock-custodian.json"
+
+// CustodianManifest describes a custodian bundle: the collections held by one
+// custodian, together with the assignment of every collection of the set, so
+// that any bundle tells its holder who else must be involved in a recovery
+type CustodianManifest struct {
+	Version     int                 `json:"version"`     // Manifest format version
+	Custodian   string              `json:"custodian"`   // Name of the custodian holding this bundle
+	Collections []string            `json:"collections"` // Collections in this bundle (e.g., ["3A5", "3B5"])
+	Required    int                 `json:"required"`    // Collections required for reconstruction (K)
+	Copies      int                 `json:"copies"`      // Collections in the set (N)
+	Custodians  map[string][]string `json:"custodians"`  // Collections held by each custodian of the set
+}
+
+// CreateCustodianBundle moves the custodian's collections from outputDir into
+// a bundle directory named after the custodian, with a manifest describing it,
+// and returns the bundle path
+func CreateCustodianBundle(ctx context.Context, outputDir string, m CustodianManifest) (string, error) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
+
+	bundleDir := filepath.Join(outputDir, m.Custodian)
+	if err := os.MkdirAll(bundleDir, 0755); err != nil {
+		log.Error(fmt.Errorf("failed to create bundle for %s: %w", m.Custodian, err))
+		return "", fmt.Errorf("failed to create bundle for %s: %w", m.Custodian, err)
+	}
+	for _, collName := range m.Collections {
+		if err := os.Rename(filepath.Join(outputDir, collName), filepath.Join(bundleDir, collName)); err != nil {
+			log.Error(fmt.Errorf("failed to move collection %s into bundle for %s: %w", collName, m.Custodian, err))
+			return "", fmt.Errorf("failed to move collection %s into bundle for %s: %w", collName, m.Custodian, err)
+		}
+	}
+
+	m.Version = ManifestVersion
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Error(fmt.Errorf("failed to encode custodian manifest: %w", err))
+		return "", fmt.Errorf("failed to encode custodian manifest: %w", err)
+	}
+	path := filepath.Join(bundleDir, CustodianManifestFileName)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		log.Error(fmt.Errorf("failed to write custodian manifest %s: %w", path, err))
+		return "", fmt.Errorf("failed to write custodian manifest %s: %w", path, err)
+	}
+
+	log.Debugf("Bundled collections %v for custodian %s", m.Collections, m.Custodian)
+	return bundleDir, nil
+}
+
+// ReadCustodianManifest reads the manifest of a custodian bundle. It returns nil
+// without error for directories that are not custodian bundles.
+func ReadCustodianManifest(ctx context.Context, bundleDir string) (*CustodianManifest, error) {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	path := filepath.Join(bundleDir, CustodianManifestFileName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read custodian manifest %s: %w", path, err))
+		return nil, fmt.Errorf("failed to read custodian manifest %s: %w", path, err)
+	}
+
+	m, err := parseCustodianManifest(path, data)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseCustodianManifest decodes the contents of a custodian manifest read from path
+func parseCustodianManifest(path string, data []byte) (*CustodianManifest, error) {
+	var m CustodianManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid custodian manifest %s: %w", path, err)
+	}
+	if m.Version > ManifestVersion {
+		return nil, fmt.Errorf("custodian manifest %s has unsupported version %d", path, m.Version)
+	}
+	return &m, nil
+}
+
+// isCustodianBundle reports whether a directory is a custodian bundle
+func isCustodianBundle(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, CustodianManifestFileName))
+	return err == nil
+}
+
+// bundleCollections returns the collections held in a custodian bundle
+func bundleCollections(ctx context.Context, bundleDir string) ([]Collection, error) {
+	m, err := ReadCustodianManifest(ctx, bundleDir)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("%s is not a custodian bundle", bundleDir)
+	}
+
+	var collections []Collection
+	for _, collName := range m.Collections {
+		collPath := filepath.Join(bundleDir, collName)
+		format, naming, err := determineCollectionFormat(collPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine format for collection %s of custodian %s: %w", collName, m.Custodian, err)
+		}
+		collections = append(collections, Collection{Name: collName, Path: collPath, Format: format, Naming: naming})
+	}
+	return collections, nil
+}
+
+// openZipBundle opens a zipped custodian bundle (e.g. "ceo.zip") with open so
+// that each of its collections is read in place
+func openZipBundle(ctx context.Context, zipPath string, open func() (*zipArchive, error)) ([]Collection, bool) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
+
+	archive, err := open()
+	if err != nil {
+		log.Debugf("Not reading %s in place: %v", zipPath, err)
+		return nil, false
+	}
+	defer archive.close()
+	if !archive.has(CustodianManifestFileName) {
+		return nil, false
+	}
+	data, err := archive.readFile(CustodianManifestFileName)
+	if err != nil {
+		log.Debugf("Not reading %s in place: %v", zipPath, err)
+		return nil, false
+	}
+	m, err := parseCustodianManifest(filepath.Join(zipPath, CustodianManifestFileName), data)
+	if err != nil {
+		log.Debugf("Not reading %s in place: %v", zipPath, err)
+		return nil, false
+	}
+
+	var collections []Collection
+	for _, collName := range m.Collections {
+		view := archive.sub(collName)
+		format, naming, err := detectFormat(view, collName)
+		if err != nil {
+			log.Debugf("Not reading %s in place: collection %s of custodian %s: %v", zipPath, collName, m.Custodian, err)
+			view.close()
+			CloseCollections(collections)
+			return nil, false
+		}
+		collections = append(collections, Collection{Name: collName, Path: zipPath, Format: format, Naming: naming, Zip: true, archive: view})
+	}
+	return collections, true
+}
+
+// CollectionCustodians returns the manifest of the custodian bundle a
+// collection was found in, directory or zip, or nil if it was not found in one
+func CollectionCustodians(ctx context.Context, coll Collection) (*CustodianManifest, error) {
+	if !coll.Zip {
+		if dir := filepath.Dir(coll.Path); isCustodianBundle(dir) {
+			return ReadCustodianManifest(ctx, dir)
+		}
+		return nil, nil
+	}
+	archive, err := openZipArchive(ctx, coll.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.close()
+	if !archive.has(CustodianManifestFileName) {
+		return nil, nil
+	}
+	data, err := archive.readFile(CustodianManifestFileName)
+	if err != nil {
+		return nil, err
+	}
+	return parseCustodianManifest(filepath.Join(coll.Path, CustodianManifestFileName), data)
+}
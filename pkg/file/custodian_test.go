@@ -0,0 +1,91 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestCustodianBundle(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "custodian-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Three collections, the first two of which belong to one custodian
+	for _, collName := range []string{"2A3", "2B3", "2C3"} {
+		collPath := filepath.Join(tempDir, collName)
+		if err := os.MkdirAll(collPath, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", collName, err)
+		}
+		if err := os.WriteFile(filepath.Join(collPath, collName+"_0001.bin"), []byte("chunk"), 0644); err != nil {
+			t.Fatalf("Failed to create chunk: %v", err)
+		}
+	}
+	assignment := map[string][]string{"ceo": {"2A3", "2B3"}, "cfo": {"2C3"}}
+	bundleDir, err := CreateCustodianBundle(ctx, tempDir, CustodianManifest{
+		Custodian:   "ceo",
+		Collections: assignment["ceo"],
+		Required:    2,
+		Copies:      3,
+		Custodians:  assignment,
+	})
+	if err != nil {
+		t.Fatalf("CreateCustodianBundle failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "2A3")); !os.IsNotExist(err) {
+		t.Errorf("Expected 2A3 to be moved into the bundle")
+	}
+
+	m, err := ReadCustodianManifest(ctx, bundleDir)
+	if err != nil || m == nil {
+		t.Fatalf("ReadCustodianManifest failed: %v", err)
+	}
+	if m.Version != ManifestVersion || m.Custodian != "ceo" || len(m.Collections) != 2 || len(m.Custodians["cfo"]) != 1 {
+		t.Errorf("Unexpected custodian manifest: %+v", m)
+	}
+
+	// The bundle is found as its collections, whether as a directory or a zip,
+	// and is not mistaken for a group
+	check := func(name string, zipped bool) {
+		collections, tempPath, err := FindCollections(ctx, tempDir)
+		if err != nil {
+			t.Fatalf("%s: FindCollections failed: %v", name, err)
+		}
+		defer CloseCollections(collections)
+		if tempPath != "" {
+			defer os.RemoveAll(tempPath)
+			t.Errorf("%s: expected nothing to be extracted, got %s", name, tempPath)
+		}
+		if len(collections) != 3 || collections[0].Name != "2A3" || collections[1].Name != "2B3" || collections[2].Name != "2C3" {
+			t.Fatalf("%s: expected collections 2A3, 2B3 and 2C3, got %+v", name, collections)
+		}
+		// The collections of a zipped bundle are read in place
+		for _, coll := range collections[:2] {
+			if coll.Zip != zipped {
+				t.Errorf("%s: expected collection %s to have Zip %v, got %+v", name, coll.Name, zipped, coll)
+			}
+			if numbers, err := ChunkNumbers(coll); err != nil || len(numbers) == 0 {
+				t.Errorf("%s: expected chunks in collection %s, got %v (%v)", name, coll.Name, numbers, err)
+			}
+		}
+		if groups, err := FindGroups(ctx, tempDir); err != nil || len(groups) != 0 {
+			t.Errorf("%s: expected no groups, got %v (%v)", name, groups, err)
+		}
+	}
+	check("directory", false)
+
+	if _, err := ZipCollections(ctx, []Collection{{Name: "ceo", Path: bundleDir}}); err != nil {
+		t.Fatalf("ZipCollections failed: %v", err)
+	}
+	check("zip", true)
+}
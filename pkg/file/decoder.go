@@ -0,0 +1,236 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// DecoderFilePrefix begins the names of the padlock executables embedded in a
+// collection, and of the instructions for running them, so that whoever holds
+// the collection decades later needn't find compatible software to decode it
+const DecoderFilePrefix = "padlock-decoder"
+
+// DecoderReadmeFileName is the name of the instructions for running the
+// embedded decoders
+const DecoderReadmeFileName = DecoderFilePrefix + ".txt"
+
+// Decoder is a padlock executable to embed in each collection
+type Decoder struct {
+	OS   string // Operating system it runs on, as GOOS (e.g. "linux")
+	Arch string // Architecture it runs on, as GOARCH (e.g. "amd64"), or "universal" for a macOS universal binary
+	Data []byte // The executable
+}
+
+// decoderPlatforms describes the platforms of decoders for the instructions
+var decoderPlatforms = map[string]string{
+	"linux/amd64":      "Linux on Intel or AMD processors",
+	"linux/arm64":      "Linux on ARM processors, e.g. a Raspberry Pi",
+	"linux/386":        "Linux on 32-bit Intel processors",
+	"linux/arm":        "Linux on 32-bit ARM processors",
+	"darwin/amd64":     "macOS on Intel processors",
+	"darwin/arm64":     "macOS on Apple silicon",
+	"darwin/universal": "macOS",
+	"windows/amd64":    "Windows on Intel or AMD processors",
+	"windows/arm64":    "Windows on ARM processors",
+	"windows/386":      "Windows on 32-bit Intel processors",
+}
+
+// decoderOSNames names the operating systems of decoders other than Windows
+// for the instructions
+var decoderOSNames = map[string]string{
+	"linux":   "Linux",
+	"darwin":  "macOS",
+	"freebsd": "FreeBSD",
+	"netbsd":  "NetBSD",
+	"openbsd": "OpenBSD",
+}
+
+// LoadDecoder reads a padlock executable to embed in collections, determining
+// the platform it runs on from its headers
+func LoadDecoder(ctx context.Context, path string) (Decoder, error) {
+	log := trace.FromContext(ctx).WithPrefix("DECODER")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read decoder %s: %w", path, err))
+		return Decoder{}, fmt.Errorf("failed to read decoder %s: %w", path, err)
+	}
+	d := Decoder{Data: data}
+	dynamic := false
+	r := bytes.NewReader(data)
+	if f, err := elf.NewFile(r); err == nil {
+		switch f.OSABI {
+		case elf.ELFOSABI_NONE, elf.ELFOSABI_LINUX:
+			d.OS = "linux"
+		case elf.ELFOSABI_FREEBSD:
+			d.OS = "freebsd"
+		case elf.ELFOSABI_NETBSD:
+			d.OS = "netbsd"
+		case elf.ELFOSABI_OPENBSD:
+			d.OS = "openbsd"
+		}
+		switch f.Machine {
+		case elf.EM_X86_64:
+			d.Arch = "amd64"
+		case elf.EM_AARCH64:
+			d.Arch = "arm64"
+		case elf.EM_386:
+			d.Arch = "386"
+		case elf.EM_ARM:
+			d.Arch = "arm"
+		case elf.EM_RISCV:
+			d.Arch = "riscv64"
+		}
+		for _, prog := range f.Progs {
+			dynamic = dynamic || prog.Type == elf.PT_INTERP
+		}
+	} else if f, err := macho.NewFile(r); err == nil {
+		d.OS = "darwin"
+		switch f.Cpu {
+		case macho.CpuAmd64:
+			d.Arch = "amd64"
+		case macho.CpuArm64:
+			d.Arch = "arm64"
+		}
+	} else if _, err := macho.NewFatFile(r); err == nil {
+		d.OS, d.Arch = "darwin", "universal"
+	} else if f, err := pe.NewFile(r); err == nil {
+		d.OS = "windows"
+		switch f.Machine {
+		case pe.IMAGE_FILE_MACHINE_AMD64:
+			d.Arch = "amd64"
+		case pe.IMAGE_FILE_MACHINE_ARM64:
+			d.Arch = "arm64"
+		case pe.IMAGE_FILE_MACHINE_I386:
+			d.Arch = "386"
+		}
+	} else {
+		log.Error(fmt.Errorf("decoder %s is not an executable for Linux, macOS or Windows", path))
+		return Decoder{}, fmt.Errorf("decoder %s is not an executable for Linux, macOS or Windows", path)
+	}
+	if d.OS == "" || d.Arch == "" {
+		log.Error(fmt.Errorf("decoder %s is for an unsupported platform", path))
+		return Decoder{}, fmt.Errorf("decoder %s is for an unsupported platform", path)
+	}
+
+	// Statically linked executables keep running as the libraries of their
+	// platform change
+	if dynamic {
+		log.Infof("Warning: decoder %s is dynamically linked and may not run where its libraries differ; build it with CGO_ENABLED=0", path)
+	}
+	log.Debugf("Decoder %s runs on %s (%d bytes)", path, d.Platform(), len(data))
+	return d, nil
+}
+
+// Platform returns the platform the decoder runs on, e.g. "linux/amd64"
+func (d Decoder) Platform() string {
+	return d.OS + "/" + d.Arch
+}
+
+// FileName returns the name of the decoder within a collection, e.g.
+// "padlock-decoder-linux-amd64" or "padlock-decoder-windows-amd64.exe"
+func (d Decoder) FileName() string {
+	name := DecoderFilePrefix + "-" + d.OS + "-" + d.Arch
+	if d.OS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// isDecoderFile reports whether a file of a collection is an embedded decoder
+// or its instructions
+func isDecoderFile(name string) bool {
+	return strings.HasPrefix(name, DecoderFilePrefix)
+}
+
+// DecoderReadme renders the instructions for running the decoders embedded in
+// a collection of a set requiring the given number of collections
+func DecoderReadme(decoders []Decoder, required int) string {
+	var b strings.Builder
+	b.WriteString("PADLOCK DECODER\n\n")
+	b.WriteString("This collection includes padlock, the program that decodes it, so that the\n")
+	b.WriteString("data can be recovered without finding padlock elsewhere.\n\n")
+	fmt.Fprintf(&b, "Gather any %d collections of the set, as directories or zip files, into a\n", required)
+	b.WriteString("single directory. Then, in a terminal, run the decoder for your computer:\n\n")
+	width := 0
+	for _, d := range decoders {
+		width = max(width, len(d.FileName()))
+	}
+	for _, d := range decoders {
+		description, ok := decoderPlatforms[d.Platform()]
+		if !ok {
+			description = d.Platform()
+		}
+		fmt.Fprintf(&b, "  %-*s  %s\n", width, d.FileName(), description)
+	}
+
+	for _, d := range decoders {
+		if d.OS != "windows" {
+			fmt.Fprintf(&b, "\nFor example, on %s:\n\n", decoderOSNames[d.OS])
+			fmt.Fprintf(&b, "    chmod +x %s\n", d.FileName())
+			fmt.Fprintf(&b, "    ./%s decode <directory with the collections> <output directory>\n", d.FileName())
+			break
+		}
+	}
+	for _, d := range decoders {
+		if d.OS == "windows" {
+			b.WriteString("\nOn Windows:\n\n")
+			fmt.Fprintf(&b, "    %s decode <directory with the collections> <output directory>\n", d.FileName())
+			break
+		}
+	}
+	for _, d := range decoders {
+		if d.OS == "darwin" {
+			b.WriteString("\nmacOS may refuse to run a decoder that was downloaded or copied from an\n")
+			b.WriteString("archive. Allow it with:\n\n")
+			fmt.Fprintf(&b, "    xattr -d com.apple.quarantine %s\n", d.FileName())
+			break
+		}
+	}
+
+	b.WriteString("\nThe SHA-256 of each decoder, to check that it has not been altered:\n\n")
+	for _, d := range decoders {
+		sum := sha256.Sum256(d.Data)
+		fmt.Fprintf(&b, "  %s  %s\n", hex.EncodeToString(sum[:]), d.FileName())
+	}
+	b.WriteString("\npadlock is available at https://github.com/rayozzie/padlock\n")
+	return b.String()
+}
+
+// AddDecoders adds the decoders and their instructions to a collection through
+// add, which writes a file of the collection with the given mode
+func AddDecoders(decoders []Decoder, required int, add func(name string, data []byte, mode fs.FileMode) error) error {
+	for _, d := range decoders {
+		if err := add(d.FileName(), d.Data, 0755); err != nil {
+			return err
+		}
+	}
+	return add(DecoderReadmeFileName, []byte(DecoderReadme(decoders, required)), 0644)
+}
+
+// WriteDecoders writes the decoders and their instructions into a collection directory
+func WriteDecoders(ctx context.Context, collPath string, decoders []Decoder, required int) error {
+	log := trace.FromContext(ctx).WithPrefix("DECODER")
+
+	return AddDecoders(decoders, required, func(name string, data []byte, mode fs.FileMode) error {
+		path := filepath.Join(collPath, name)
+		log.Debugf("Writing %s", path)
+		if err := os.WriteFile(path, data, mode); err != nil {
+			log.Error(fmt.Errorf("failed to write decoder %s: %w", path, err))
+			return fmt.Errorf("failed to write decoder %s: %w", path, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,110 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestLoadDecoder(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "decoder-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	t.Run("Running executable", func(t *testing.T) {
+		self, err := os.Executable()
+		if err != nil {
+			t.Skipf("Cannot locate the test executable: %v", err)
+		}
+		d, err := LoadDecoder(ctx, self)
+		if err != nil {
+			t.Fatalf("LoadDecoder failed: %v", err)
+		}
+		if d.OS != runtime.GOOS || d.Arch != runtime.GOARCH {
+			t.Errorf("Detected %s, expected %s/%s", d.Platform(), runtime.GOOS, runtime.GOARCH)
+		}
+		if len(d.Data) == 0 {
+			t.Errorf("Decoder holds no executable")
+		}
+	})
+
+	t.Run("Not an executable", func(t *testing.T) {
+		path := filepath.Join(tempDir, "notes.txt")
+		if err := os.WriteFile(path, []byte("not a program"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		if _, err := LoadDecoder(ctx, path); err == nil {
+			t.Errorf("Expected an error for a text file")
+		}
+		if _, err := LoadDecoder(ctx, filepath.Join(tempDir, "missing")); err == nil {
+			t.Errorf("Expected an error for a missing file")
+		}
+	})
+}
+
+func TestWriteDecoders(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "decoder-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	decoders := []Decoder{
+		{OS: "linux", Arch: "amd64", Data: []byte("linux executable")},
+		{OS: "darwin", Arch: "arm64", Data: []byte("macOS executable")},
+		{OS: "windows", Arch: "amd64", Data: []byte("windows executable")},
+	}
+	if err := WriteDecoders(ctx, tempDir, decoders, 3); err != nil {
+		t.Fatalf("WriteDecoders failed: %v", err)
+	}
+
+	for _, name := range []string{"padlock-decoder-linux-amd64", "padlock-decoder-darwin-arm64", "padlock-decoder-windows-amd64.exe"} {
+		info, err := os.Stat(filepath.Join(tempDir, name))
+		if err != nil {
+			t.Errorf("Decoder %s was not written: %v", name, err)
+			continue
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm()&0100 == 0 {
+			t.Errorf("Decoder %s is not executable: %v", name, info.Mode())
+		}
+		if !isDecoderFile(name) {
+			t.Errorf("%s is not recognized as a decoder", name)
+		}
+	}
+
+	readme, err := os.ReadFile(filepath.Join(tempDir, DecoderReadmeFileName))
+	if err != nil {
+		t.Fatalf("Failed to read decoder instructions: %v", err)
+	}
+	for _, want := range []string{
+		"Gather any 3 collections",
+		"padlock-decoder-darwin-arm64       macOS on Apple silicon",
+		"For example, on Linux:",
+		"./padlock-decoder-linux-amd64 decode",
+		"On Windows:",
+		"xattr -d com.apple.quarantine padlock-decoder-darwin-arm64",
+		// The SHA-256 of "linux executable"
+		"  7a3af414e45be6f318acae4ffd03b8ee38f05d7fe211735942e34a6502916c4a  padlock-decoder-linux-amd64",
+	} {
+		if !strings.Contains(string(readme), want) {
+			t.Errorf("Instructions lack %q:\n%s", want, readme)
+		}
+	}
+}
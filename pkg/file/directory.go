@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/rayozzie/padlock/pkg/trace"
 )
@@ -34,6 +35,31 @@ func ValidateInputDirectory(ctx context.Context, inputDir string) error {
 	return nil
 }
 
+// ValidateInputs checks that each of several inputs, directories or files,
+// exists and that they can be archived together under their names (see
+// InputNames)
+func ValidateInputs(ctx context.Context, inputs []string) error {
+	log := trace.FromContext(ctx).WithPrefix("FILE")
+
+	log.Debugf("Validating inputs: %s", strings.Join(inputs, ", "))
+
+	for _, input := range inputs {
+		if _, err := os.Stat(input); err != nil {
+			if os.IsNotExist(err) {
+				log.Error(fmt.Errorf("input does not exist: %s", input))
+				return fmt.Errorf("input does not exist: %s", input)
+			}
+			log.Error(fmt.Errorf("cannot access input %s: %v", input, err))
+			return fmt.Errorf("cannot access input %s: %v", input, err)
+		}
+	}
+	if _, err := InputNames(inputs); err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
 // PrepareOutputDirectory ensures the output directory exists and is empty if clear is true
 func PrepareOutputDirectory(ctx context.Context, outputDir string, clear bool) error {
 	log := trace.FromContext(ctx).WithPrefix("FILE")
@@ -98,13 +124,13 @@ func PrepareOutputDirectory(ctx context.Context, outputDir string, clear bool) e
 				}
 			}
 
-			errMsg := fmt.Sprintf("Output directory is not empty. Use -clear to clear the output directory.%s", fileList)
+			errMsg := fmt.Sprintf("Use -clear to clear the output directory.%s", fileList)
 			if remainingCount > 0 {
 				errMsg += fmt.Sprintf("\n  ... and %d more files/directories", remainingCount)
 			}
 
-			log.Error(fmt.Errorf("%s", errMsg))
-			return fmt.Errorf("%s", errMsg)
+			log.Error(fmt.Errorf("%w. %s", ErrOutputNotEmpty, errMsg))
+			return fmt.Errorf("%w. %s", ErrOutputNotEmpty, errMsg)
 		}
 
 		log.Debugf("Output directory is empty: %s", outputDir)
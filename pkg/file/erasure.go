@@ -0,0 +1,199 @@
+package file
+
+import (
+	"fmt"
+)
+
+// gfExp and gfLog are the exponent and logarithm tables of GF(2^8) over the
+// polynomial x^8+x^4+x^3+x^2+1 (0x11d), and gfMulTable holds every product,
+// so that the inner loops of the erasure code are table lookups
+var (
+	gfExp      [510]byte
+	gfLog      [256]int
+	gfMulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMulTable[a][b] = gfExp[gfLog[a]+gfLog[b]]
+		}
+	}
+}
+
+// gfInv returns the multiplicative inverse of a nonzero element
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+// gfPow returns a raised to the power n
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(gfLog[a]*n)%255]
+}
+
+// invertMatrix inverts a square matrix over GF(2^8) by Gauss-Jordan elimination
+func invertMatrix(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	work := make([][]byte, n)
+	for i := range m {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, fmt.Errorf("matrix is singular")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		scale := gfInv(work[col][col])
+		for j := range work[col] {
+			work[col][j] = gfMulTable[scale][work[col][j]]
+		}
+		for r := 0; r < n; r++ {
+			if f := work[r][col]; r != col && f != 0 {
+				for j := range work[r] {
+					work[r][j] ^= gfMulTable[f][work[col][j]]
+				}
+			}
+		}
+	}
+	inverse := make([][]byte, n)
+	for i := range work {
+		inverse[i] = work[i][n:]
+	}
+	return inverse, nil
+}
+
+// reedSolomon is a systematic Reed-Solomon erasure code: the data shards are
+// stored as they are, and any dataShards of the dataShards+parityShards shards
+// are enough to rebuild the rest
+type reedSolomon struct {
+	dataShards   int
+	parityShards int
+	matrix       [][]byte // One row per shard; the first dataShards rows are the identity
+}
+
+// newReedSolomon creates an erasure code for the given numbers of data and parity shards
+func newReedSolomon(dataShards, parityShards int) (*reedSolomon, error) {
+	if dataShards < 1 || parityShards < 1 || dataShards+parityShards > 256 {
+		return nil, fmt.Errorf("invalid erasure code: %d data and %d parity shards", dataShards, parityShards)
+	}
+	total := dataShards + parityShards
+
+	// Any dataShards rows of a Vandermonde matrix are independent. Multiplying
+	// by the inverse of its top square keeps that property while making the
+	// top rows the identity, so that data shards encode to themselves.
+	vandermonde := make([][]byte, total)
+	for r := range vandermonde {
+		vandermonde[r] = make([]byte, dataShards)
+		for c := range vandermonde[r] {
+			vandermonde[r][c] = gfPow(byte(r), c)
+		}
+	}
+	top, err := invertMatrix(vandermonde[:dataShards])
+	if err != nil {
+		return nil, err
+	}
+	matrix := make([][]byte, total)
+	for r := range matrix {
+		matrix[r] = make([]byte, dataShards)
+		for c := 0; c < dataShards; c++ {
+			var sum byte
+			for i := 0; i < dataShards; i++ {
+				sum ^= gfMulTable[vandermonde[r][i]][top[i][c]]
+			}
+			matrix[r][c] = sum
+		}
+	}
+	return &reedSolomon{dataShards: dataShards, parityShards: parityShards, matrix: matrix}, nil
+}
+
+// encode computes the parity shards from the data shards, which must all be the same size
+func (rs *reedSolomon) encode(data [][]byte) ([][]byte, error) {
+	if len(data) != rs.dataShards {
+		return nil, fmt.Errorf("expected %d data shards, got %d", rs.dataShards, len(data))
+	}
+	size := len(data[0])
+	for _, shard := range data {
+		if len(shard) != size {
+			return nil, fmt.Errorf("data shards differ in size")
+		}
+	}
+	parity := make([][]byte, rs.parityShards)
+	for i := range parity {
+		parity[i] = rs.combine(rs.matrix[rs.dataShards+i], data, size)
+	}
+	return parity, nil
+}
+
+// reconstruct fills in the missing (nil) data shards from any dataShards of
+// the others. shards holds the data shards followed by the parity shards.
+func (rs *reedSolomon) reconstruct(shards [][]byte) error {
+	if len(shards) != rs.dataShards+rs.parityShards {
+		return fmt.Errorf("expected %d shards, got %d", rs.dataShards+rs.parityShards, len(shards))
+	}
+
+	// Solve for the data from the first dataShards shards present
+	var rows [][]byte
+	var present [][]byte
+	for i, shard := range shards {
+		if shard != nil && len(present) < rs.dataShards {
+			rows = append(rows, rs.matrix[i])
+			present = append(present, shard)
+		}
+	}
+	if len(present) < rs.dataShards {
+		return fmt.Errorf("too few shards to reconstruct: %d of the %d required", len(present), rs.dataShards)
+	}
+	size := len(present[0])
+	for _, shard := range present {
+		if len(shard) != size {
+			return fmt.Errorf("shards differ in size")
+		}
+	}
+	decode, err := invertMatrix(rows)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < rs.dataShards; i++ {
+		if shards[i] == nil {
+			shards[i] = rs.combine(decode[i], present, size)
+		}
+	}
+	return nil
+}
+
+// combine returns the sum of the shards, each multiplied by its coefficient
+func (rs *reedSolomon) combine(coefficients []byte, shards [][]byte, size int) []byte {
+	out := make([]byte, size)
+	for i, shard := range shards {
+		row := &gfMulTable[coefficients[i]]
+		for j, b := range shard {
+			out[j] ^= row[b]
+		}
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package file
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   int
+		parity int
+		lost   []int // Shards to drop before reconstructing
+		ok     bool
+	}{
+		{"no loss", 4, 2, nil, true},
+		{"lost data", 4, 2, []int{0, 3}, true},
+		{"lost data and parity", 4, 2, []int{1, 4}, true},
+		{"single parity", 10, 1, []int{7}, true},
+		{"large stripe", 64, 7, []int{0, 9, 18, 27, 36, 45, 63}, true},
+		{"too much loss", 4, 2, []int{0, 1, 2}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := newReedSolomon(tt.data, tt.parity)
+			if err != nil {
+				t.Fatalf("newReedSolomon failed: %v", err)
+			}
+			data := make([][]byte, tt.data)
+			for i := range data {
+				data[i] = make([]byte, 100)
+				if _, err := rand.Read(data[i]); err != nil {
+					t.Fatalf("Failed to generate data: %v", err)
+				}
+			}
+			parity, err := rs.encode(data)
+			if err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+
+			shards := make([][]byte, 0, tt.data+tt.parity)
+			for _, shard := range append(data, parity...) {
+				shards = append(shards, bytes.Clone(shard))
+			}
+			for _, i := range tt.lost {
+				shards[i] = nil
+			}
+			err = rs.reconstruct(shards)
+			if !tt.ok {
+				if err == nil {
+					t.Errorf("Expected an error reconstructing with %d shards lost", len(tt.lost))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reconstruct failed: %v", err)
+			}
+			for i := range data {
+				if !bytes.Equal(shards[i], data[i]) {
+					t.Errorf("Data shard %d was not reconstructed", i)
+				}
+			}
+		})
+	}
+
+	if _, err := newReedSolomon(250, 10); err == nil {
+		t.Errorf("Expected an error for more than 256 shards")
+	}
+}
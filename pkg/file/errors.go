@@ -0,0 +1,45 @@
+package file
+
+import (
+	"errors"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+)
+
+// Errors returned when reading collections and restoring their contents,
+// wrapped with the details of each failure. Damaged chunks are reported with
+// pad.ErrChunkCorrupt, so that they match however they are detected, and
+// those the standalone decoder also detects are the same values as paddecode's.
+var (
+	// ErrNoCollections means no collections were found in the input directory
+	ErrNoCollections = paddecode.ErrNoCollections
+
+	// ErrOutputNotEmpty means the output directory holds files and neither
+	// clearing it nor a conflict policy was requested
+	ErrOutputNotEmpty = paddecode.ErrOutputNotEmpty
+
+	// ErrFileExists means a restored file already exists and the conflict
+	// policy is to fail
+	ErrFileExists = errors.New("file already exists")
+
+	// ErrNotArchive means the decoded stream is not a valid tar archive
+	ErrNotArchive = paddecode.ErrNotArchive
+
+	// ErrUnsafePath means an archive entry would be restored outside of the
+	// output directory
+	ErrUnsafePath = paddecode.ErrUnsafePath
+
+	// ErrNoMatch means no archive entries matched the files selected for restore
+	ErrNoMatch = errors.New("no files matched")
+
+	// ErrOutputFull means the filesystem written to has too little space, or
+	// too few free files, for the collections
+	ErrOutputFull = errors.New("not enough room for the output")
+
+	// ErrSnapshotNotFound means the snapshot to read is not recorded in the collection
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+
+	// ErrHashMismatch means restored files are missing or differ from the
+	// hashes recorded when they were encoded
+	ErrHashMismatch = errors.New("restored files do not match their recorded hashes")
+)
@@ -0,0 +1,178 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestExtractFilesToWriter(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "extract-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"docs/plan.txt":  "the plan\n",
+		"docs/other.txt": "other\n",
+		"keys/a.pem":     "key a\n",
+		"keys/b.pem":     "key b\n",
+	}
+	for rel, content := range files {
+		path := filepath.Join(tempDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		expect   string
+		wantErr  bool
+	}{
+		{"Single file", []string{"docs/plan.txt"}, "the plan\n", false},
+		{"Glob in archive order", []string{"docs/plan.txt", "keys/*"}, "the plan\nkey a\nkey b\n", false},
+		{"Directory", []string{"keys"}, "key a\nkey b\n", false},
+		{"No match", []string{"missing.txt"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := SerializeDirectoryToStream(ctx, tempDir, SerializeOptions{})
+			if err != nil {
+				t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+			}
+			defer stream.Close()
+
+			var out bytes.Buffer
+			err = ExtractFilesToWriter(ctx, stream, &out, tt.patterns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractFilesToWriter error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if out.String() != tt.expect {
+				t.Errorf("Expected output %q, got %q", tt.expect, out.String())
+			}
+		})
+	}
+}
+
+func TestReadArchiveFiles(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Build an archive with a directory, a symlink and a name escaping it
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	entries := []struct {
+		header  tar.Header
+		content string
+	}{
+		{tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "docs/plan.txt", Typeflag: tar.TypeReg, Mode: 0600}, "the plan\n"},
+		{tar.Header{Name: "docs/link", Typeflag: tar.TypeSymlink, Linkname: "plan.txt"}, ""},
+		{tar.Header{Name: "../escape.txt", Typeflag: tar.TypeReg, Mode: 0644}, "escaped\n"},
+	}
+	for _, e := range entries {
+		e.header.Size = int64(len(e.content))
+		if err := tw.WriteHeader(&e.header); err != nil {
+			t.Fatalf("Failed to write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatalf("Failed to write content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+
+	t.Run("Regular files", func(t *testing.T) {
+		var visited []string
+		err := ReadArchiveFiles(ctx, bytes.NewReader(archive.Bytes()), func(header *tar.Header, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			visited = append(visited, fmt.Sprintf("%s %o %q", header.Name, header.Mode, data))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ReadArchiveFiles failed: %v", err)
+		}
+		expect := []string{`docs/plan.txt 600 "the plan\n"`, `escape.txt 644 "escaped\n"`}
+		if strings.Join(visited, "|") != strings.Join(expect, "|") {
+			t.Errorf("Expected %q, got %q", expect, visited)
+		}
+	})
+
+	t.Run("Visit error", func(t *testing.T) {
+		errStop := errors.New("stop")
+		r := bytes.NewReader(archive.Bytes())
+		err := ReadArchiveFiles(ctx, r, func(header *tar.Header, r io.Reader) error {
+			return errStop
+		})
+		if !errors.Is(err, errStop) {
+			t.Fatalf("Expected the visit error, got %v", err)
+		}
+		if r.Len() != 0 {
+			t.Errorf("Expected the stream to be drained, %d bytes remain", r.Len())
+		}
+	})
+}
+
+func TestListArchive(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "list-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := os.MkdirAll(filepath.Join(tempDir, "docs"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "docs", "plan.txt"), []byte("the plan"), 0600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	stream, err := SerializeDirectoryToStream(ctx, tempDir, SerializeOptions{})
+	if err != nil {
+		t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	var out bytes.Buffer
+	if err := ListArchive(ctx, stream, &out); err != nil {
+		t.Fatalf("ListArchive failed: %v", err)
+	}
+
+	listing := out.String()
+	for _, want := range []string{"docs/\n", "-rw-------", " 8 ", "docs/plan.txt\n", "2 entries, 8 bytes\n"} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("Expected listing to contain %q, got:\n%s", want, listing)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package file
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ValidatePatterns checks that each include/exclude pattern is well formed.
+//
+// Patterns use the path.Match syntax ('*', '?', '[...]'). A pattern that
+// contains a '/' is matched against the full slash-separated path of an entry
+// relative to the input directory (e.g. "docs/*.txt"); any other pattern is
+// matched against the entry's base name at any depth (e.g. ".git" or "*.tmp").
+// A trailing '/' is ignored, so "node_modules/" behaves like "node_modules",
+// and a leading '/' anchors a pattern to the input directory ("/build").
+func ValidatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if strings.TrimSuffix(pattern, "/") == "" {
+			return fmt.Errorf("empty pattern")
+		}
+		if _, err := path.Match(normalizePattern(pattern), ""); err != nil {
+			return fmt.Errorf("%q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchAnyPattern reports whether a slash-separated relative path matches any of the patterns
+func matchAnyPattern(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		pattern = normalizePattern(pattern)
+		target := rel
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(rel)
+		}
+		pattern = strings.TrimPrefix(pattern, "/")
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePattern strips the decorations that don't affect matching
+func normalizePattern(pattern string) string {
+	pattern = strings.TrimPrefix(pattern, "./")
+	return strings.TrimSuffix(pattern, "/")
+}
+
+// matchPathOrParent reports whether a slash-separated relative path, or any of
+// its parent directories, matches any of the patterns
+func matchPathOrParent(patterns []string, rel string) bool {
+	for p := strings.TrimSuffix(rel, "/"); p != "." && p != "" && p != "/"; p = path.Dir(p) {
+		if matchAnyPattern(patterns, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package file
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestMatchAnyPattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		rel      string
+		expect   bool
+	}{
+		{"Base name at root", []string{".git"}, ".git", true},
+		{"Base name nested", []string{".git"}, "src/.git", true},
+		{"Extension glob", []string{"*.tmp"}, "a/b/c.tmp", true},
+		{"Extension mismatch", []string{"*.tmp"}, "a/b/c.txt", false},
+		{"Path pattern", []string{"keys/*"}, "keys/id.pem", true},
+		{"Path pattern wrong dir", []string{"keys/*"}, "other/keys/id.pem", false},
+		{"Anchored pattern", []string{"/build"}, "build", true},
+		{"Anchored pattern nested", []string{"/build"}, "src/build", false},
+		{"Trailing slash", []string{"node_modules/"}, "web/node_modules", true},
+		{"No patterns", nil, "anything", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := matchAnyPattern(tt.patterns, tt.rel); result != tt.expect {
+				t.Errorf("matchAnyPattern(%v, %s) = %v, want %v", tt.patterns, tt.rel, result, tt.expect)
+			}
+		})
+	}
+}
+
+func TestMatchPathOrParent(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		rel      string
+		expect   bool
+	}{
+		{"Exact file", []string{"docs/plan.txt"}, "docs/plan.txt", true},
+		{"Under matching directory", []string{"keys"}, "keys/sub/id.pem", true},
+		{"Directory entry", []string{"keys/*"}, "keys/sub/", true},
+		{"Sibling", []string{"docs/plan.txt"}, "docs/other.txt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := matchPathOrParent(tt.patterns, tt.rel); result != tt.expect {
+				t.Errorf("matchPathOrParent(%v, %s) = %v, want %v", tt.patterns, tt.rel, result, tt.expect)
+			}
+		})
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	if err := ValidatePatterns([]string{"*.go", "docs/*", ".git"}); err != nil {
+		t.Errorf("Expected valid patterns, got error: %v", err)
+	}
+	if err := ValidatePatterns([]string{"[abc"}); err == nil {
+		t.Errorf("Expected error for malformed pattern")
+	}
+	if err := ValidatePatterns([]string{"/"}); err == nil {
+		t.Errorf("Expected error for empty pattern")
+	}
+}
+
+func TestSerializeWithFilters(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "filter-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := []string{
+		"README.md",
+		".git/config",
+		"docs/plan.txt",
+		"docs/cache/tmp.bin",
+		"src/main.go",
+		"src/main.tmp",
+		"empty/.keep",
+	}
+	for _, name := range files {
+		path := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		opts    SerializeOptions
+		entries []string
+	}{
+		{"Exclude", SerializeOptions{Exclude: []string{".git", "*.tmp", "docs/cache"}},
+			[]string{"README.md", "docs/", "docs/plan.txt", "empty/", "empty/.keep", "src/", "src/main.go"}},
+		{"Include file types", SerializeOptions{Include: []string{"*.go", "*.txt"}},
+			[]string{"docs/", "docs/plan.txt", "src/", "src/main.go"}},
+		{"Include directory", SerializeOptions{Include: []string{"docs"}, Exclude: []string{"cache"}},
+			[]string{"docs/", "docs/plan.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := SerializeDirectoryToStream(ctx, tempDir, tt.opts)
+			if err != nil {
+				t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+			}
+			defer stream.Close()
+
+			var entries []string
+			tr := tar.NewReader(stream)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Failed to read tar stream: %v", err)
+				}
+				entries = append(entries, header.Name)
+			}
+			sort.Strings(entries)
+
+			if strings.Join(entries, ",") != strings.Join(tt.entries, ",") {
+				t.Errorf("Expected entries %v, got %v", tt.entries, entries)
+			}
+		})
+	}
+}
@@ -35,6 +35,8 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/paddecode"
 	"github.com/rayozzie/padlock/pkg/trace"
 )
 
@@ -54,6 +56,13 @@ const (
 	// stealth at the cost of some storage efficiency.
 	// The encoded chunks are stored in a custom PNG chunk type 'rAWd'.
 	FormatPNG Format = "png"
+
+	// FormatPacked represents binary chunks packed into a single file per
+	// collection (e.g. "3A5.padlock"), for throughput. Each chunk is a framed
+	// record appended to the file, which ends with an index of them so that
+	// any chunk can be read directly. Thousands of small files are slow to
+	// write, copy and list on many filesystems; one large file is not.
+	FormatPacked Format = "packed"
 )
 
 // Formatter defines the interface for different chunk storage formats.
@@ -107,15 +116,17 @@ type Formatter interface {
 // - Faster processing compared to more complex formats
 //
 // File naming convention: "<collectionName>_<chunkNumber>.bin"
-// Example: "3A5_0001.bin"
-type BinFormatter struct{}
+// Example: "3A5_0001.bin", unless another Naming is given
+type BinFormatter struct {
+	Naming ChunkNaming // Naming of the chunk files, or the zero value for the default
+}
 
 // WriteChunk writes a chunk to a binary file
 func (bf *BinFormatter) WriteChunk(ctx context.Context, collectionPath string, collectionIndex int, chunkNumber int, data []byte) error {
 	log := trace.FromContext(ctx).WithPrefix("BIN-FORMATTER")
 
 	base := filepath.Base(collectionPath)
-	fname := fmt.Sprintf("%s_%04d.bin", base, chunkNumber)
+	fname := bf.Naming.orDefault(FormatBin).FileName(base, chunkNumber)
 	fp := filepath.Join(collectionPath, fname)
 
 	log.Debugf("Writing chunk %d to binary file: %s", chunkNumber, fp)
@@ -151,8 +162,8 @@ func (bf *BinFormatter) ReadChunk(ctx context.Context, collectionPath string, co
 	log := trace.FromContext(ctx).WithPrefix("BIN-FORMATTER")
 
 	base := filepath.Base(collectionPath)
-	fname := fmt.Sprintf("%s_%04d.bin", base, chunkNumber)
-	fp := filepath.Join(collectionPath, fname)
+	fname := bf.Naming.orDefault(FormatBin).FileName(base, chunkNumber)
+	fp := findFile(filepath.Join(collectionPath, fname))
 
 	log.Debugf("Reading chunk %d from binary file: %s", chunkNumber, fp)
 
@@ -160,7 +171,7 @@ func (bf *BinFormatter) ReadChunk(ctx context.Context, collectionPath string, co
 	if _, err := os.Stat(fp); os.IsNotExist(err) {
 		log.Debugf("Chunk file does not exist: %s", fp)
 		// Return a more informative error message
-		return nil, fmt.Errorf("chunk file does not exist: %s", fp)
+		return nil, fmt.Errorf("%w: chunk file does not exist: %s", pad.ErrChunkCorrupt, fp)
 	}
 
 	data, err := os.ReadFile(fp)
@@ -189,15 +200,17 @@ func (bf *BinFormatter) ReadChunk(ctx context.Context, collectionPath string, co
 // - Additional storage overhead compared to raw binary format
 //
 // File naming convention: "IMG<collectionName>_<chunkNumber>.PNG"
-// Example: "IMG3A5_0001.PNG"
-type PngFormatter struct{}
+// Example: "IMG3A5_0001.PNG", unless another Naming is given
+type PngFormatter struct {
+	Naming ChunkNaming // Naming of the chunk files, or the zero value for the default
+}
 
 // WriteChunk writes a chunk to a PNG file
 func (pf *PngFormatter) WriteChunk(ctx context.Context, collectionPath string, collectionIndex int, chunkNumber int, data []byte) error {
 	log := trace.FromContext(ctx).WithPrefix("PNG-FORMATTER")
 
 	base := filepath.Base(collectionPath)
-	fname := fmt.Sprintf("IMG%s_%04d.PNG", base, chunkNumber)
+	fname := pf.Naming.orDefault(FormatPNG).FileName(base, chunkNumber)
 	fp := filepath.Join(collectionPath, fname)
 
 	log.Debugf("Writing chunk %d to PNG file: %s", chunkNumber, fp)
@@ -237,8 +250,8 @@ func (pf *PngFormatter) ReadChunk(ctx context.Context, collectionPath string, co
 	log := trace.FromContext(ctx).WithPrefix("PNG-FORMATTER")
 
 	base := filepath.Base(collectionPath)
-	fname := fmt.Sprintf("IMG%s_%04d.PNG", base, chunkNumber)
-	fp := filepath.Join(collectionPath, fname)
+	fname := pf.Naming.orDefault(FormatPNG).FileName(base, chunkNumber)
+	fp := findFile(filepath.Join(collectionPath, fname))
 
 	log.Debugf("Reading chunk %d from PNG file: %s", chunkNumber, fp)
 
@@ -246,7 +259,7 @@ func (pf *PngFormatter) ReadChunk(ctx context.Context, collectionPath string, co
 	if _, err := os.Stat(fp); os.IsNotExist(err) {
 		log.Debugf("Chunk file does not exist: %s", fp)
 		// Return a more informative error message
-		return nil, fmt.Errorf("chunk file does not exist: %s", fp)
+		return nil, fmt.Errorf("%w: chunk file does not exist: %s", pad.ErrChunkCorrupt, fp)
 	}
 
 	f, err := os.Open(fp)
@@ -258,8 +271,8 @@ func (pf *PngFormatter) ReadChunk(ctx context.Context, collectionPath string, co
 
 	data, err := ExtractDataFromPNG(f)
 	if err != nil {
-		log.Error(fmt.Errorf("failed to extract data from PNG: %w", err))
-		return nil, fmt.Errorf("failed to extract data from PNG: %w", err)
+		log.Error(fmt.Errorf("%w: failed to extract data from PNG: %w", pad.ErrChunkCorrupt, err))
+		return nil, fmt.Errorf("%w: failed to extract data from PNG: %w", pad.ErrChunkCorrupt, err)
 	}
 
 	log.Debugf("Successfully read %d bytes from PNG file", len(data))
@@ -268,23 +281,67 @@ func (pf *PngFormatter) ReadChunk(ctx context.Context, collectionPath string, co
 
 // GetFormatter returns a Formatter for the specified format
 func GetFormatter(format Format) Formatter {
+	return GetNamedFormatter(format, ChunkNaming{})
+}
+
+// GetNamedFormatter returns a Formatter for the specified format that names
+// chunk files with the given naming, or the format's default if it is the zero value
+func GetNamedFormatter(format Format, naming ChunkNaming) Formatter {
 	switch format {
 	case FormatPNG:
-		return &PngFormatter{}
+		return &PngFormatter{Naming: naming}
 	case FormatBin:
-		return &BinFormatter{}
+		return &BinFormatter{Naming: naming}
 	default:
-		return &BinFormatter{} // Default to binary format
+		return &BinFormatter{Naming: naming} // Default to binary format
+	}
+}
+
+// ChunkFileName returns the name of the file holding a chunk of a collection in
+// the given format, under the format's default naming
+func ChunkFileName(format Format, collectionName string, chunkNumber int) string {
+	return DefaultChunkNaming(format).FileName(collectionName, chunkNumber)
+}
+
+// encodeChunkFile returns the contents of the file holding a chunk in the given
+// format, as the formatter would write it
+func encodeChunkFile(format Format, data []byte) ([]byte, error) {
+	if format != FormatPNG {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, color.Transparent)
+	if err := encodePNGWithData(&buf, img, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeChunkFile returns the chunk held by the contents of a chunk file in the
+// given format, as the formatter would read it
+func decodeChunkFile(format Format, contents []byte) ([]byte, error) {
+	if format != FormatPNG {
+		return contents, nil
+	}
+	data, err := ExtractDataFromPNG(bytes.NewReader(contents))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to extract data from PNG: %w", pad.ErrChunkCorrupt, err)
 	}
+	return data, nil
 }
 
+// maxPNGChunkData is the most data the PNG specification allows in one chunk.
+// Data beyond it is split across consecutive 'rAWd' chunks.
+const maxPNGChunkData = 1<<31 - 1
+
 // encodePNGWithData injects data into a custom 'rAWd' chunk in a PNG image.
 //
 // This function implements PNG steganography by creating a custom chunk type
 // that isn't recognized by standard PNG viewers but is preserved during normal
 // image operations. It works by:
 // 1. Creating a minimal PNG image (typically 1x1 pixel transparent)
-// 2. Inserting a custom 'rAWd' chunk with the payload data
+// 2. Inserting custom 'rAWd' chunks with the payload data, consecutively
 // 3. Ensuring proper CRC calculation and chunk structure
 // 4. Maintaining valid PNG format for compatibility with standard tools
 //
@@ -298,6 +355,12 @@ func GetFormatter(format Format) Formatter {
 //   - The data is NOT encrypted by this function (encryption happens earlier)
 //   - Specialized PNG analysis tools could detect the presence of custom chunks
 func encodePNGWithData(w io.Writer, img image.Image, data []byte) error {
+	return encodePNGWithDataChunks(w, img, data, maxPNGChunkData)
+}
+
+// encodePNGWithDataChunks is encodePNGWithData, splitting the data into
+// 'rAWd' chunks of at most maxChunk bytes
+func encodePNGWithDataChunks(w io.Writer, img image.Image, data []byte, maxChunk int) error {
 	var buf bytes.Buffer
 	if err := (&png.Encoder{CompressionLevel: png.DefaultCompression}).Encode(&buf, img); err != nil {
 		return fmt.Errorf("PNG encode error: %w", err)
@@ -318,25 +381,28 @@ func encodePNGWithData(w io.Writer, img image.Image, data []byte) error {
 	}
 
 	chunkType := []byte("rAWd")
-	length := uint32(len(data))
-	var lengthBytes [4]byte
-	binary.BigEndian.PutUint32(lengthBytes[:], length)
-	if _, err := w.Write(lengthBytes[:]); err != nil {
-		return fmt.Errorf("writing chunk length: %w", err)
-	}
-	if _, err := w.Write(chunkType); err != nil {
-		return fmt.Errorf("writing chunk type: %w", err)
-	}
-	if _, err := w.Write(data); err != nil {
-		return fmt.Errorf("writing chunk data: %w", err)
-	}
-	crc := crc32.NewIEEE()
-	crc.Write(chunkType)
-	crc.Write(data)
-	var crcBytes [4]byte
-	binary.BigEndian.PutUint32(crcBytes[:], crc.Sum32())
-	if _, err := w.Write(crcBytes[:]); err != nil {
-		return fmt.Errorf("writing chunk CRC: %w", err)
+	for first := true; first || len(data) > 0; first = false {
+		part := data[:min(len(data), maxChunk)]
+		data = data[len(part):]
+		var lengthBytes [4]byte
+		binary.BigEndian.PutUint32(lengthBytes[:], uint32(len(part)))
+		if _, err := w.Write(lengthBytes[:]); err != nil {
+			return fmt.Errorf("writing chunk length: %w", err)
+		}
+		if _, err := w.Write(chunkType); err != nil {
+			return fmt.Errorf("writing chunk type: %w", err)
+		}
+		if _, err := w.Write(part); err != nil {
+			return fmt.Errorf("writing chunk data: %w", err)
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(chunkType)
+		crc.Write(part)
+		var crcBytes [4]byte
+		binary.BigEndian.PutUint32(crcBytes[:], crc.Sum32())
+		if _, err := w.Write(crcBytes[:]); err != nil {
+			return fmt.Errorf("writing chunk CRC: %w", err)
+		}
 	}
 
 	if _, err := w.Write(pngBytes[iendPos:]); err != nil {
@@ -345,57 +411,8 @@ func encodePNGWithData(w io.Writer, img image.Image, data []byte) error {
 	return nil
 }
 
-// ExtractDataFromPNG extracts embedded data from a PNG's custom 'rAWd' chunk.
-//
-// This function reverses the steganographic encoding performed by encodePNGWithData,
-// recovering the original data embedded in the custom chunk. The process is:
-// 1. Read the entire PNG file into memory
-// 2. Locate the 'rAWd' custom chunk
-// 3. Extract the data payload from the chunk
-// 4. Verify the CRC to ensure data integrity
-//
-// Parameters:
-//   - r: Reader providing the PNG data to extract from
-//
-// Returns:
-//   - The extracted data as a byte slice
-//   - An error if the operation fails (invalid PNG, missing chunk, CRC error)
-//
-// Security notes:
-//   - CRC verification ensures data hasn't been corrupted or tampered with
-//   - No decryption is performed (that happens later in the pad decoding process)
-//   - Fails gracefully if the PNG doesn't contain the expected chunk
+// ExtractDataFromPNG extracts embedded data from a PNG's custom 'rAWd' chunks,
+// reversing encodePNGWithData (see paddecode.ExtractDataFromPNG)
 func ExtractDataFromPNG(r io.Reader) ([]byte, error) {
-	all, err := io.ReadAll(r)
-	if err != nil {
-		return nil, fmt.Errorf("read PNG data: %w", err)
-	}
-	chunkType := []byte("rAWd")
-	chunkPos := bytes.Index(all, chunkType)
-	if chunkPos == -1 {
-		return nil, fmt.Errorf("'rAWd' chunk not found")
-	}
-	if chunkPos < 4 {
-		return nil, fmt.Errorf("invalid structure, chunk at offset <4")
-	}
-	lengthBuf := all[chunkPos-4 : chunkPos]
-	length := binary.BigEndian.Uint32(lengthBuf)
-	dataStart := chunkPos + len(chunkType)
-	dataEnd := dataStart + int(length)
-	if dataEnd > len(all) {
-		return nil, fmt.Errorf("invalid PNG chunk length, out of range")
-	}
-	extracted := all[dataStart:dataEnd]
-	crcPos := dataEnd
-	if crcPos+4 > len(all) {
-		return nil, fmt.Errorf("invalid chunk: no CRC found")
-	}
-	expectedCRC := binary.BigEndian.Uint32(all[crcPos : crcPos+4])
-	crcCalc := crc32.NewIEEE()
-	crcCalc.Write(chunkType)
-	crcCalc.Write(extracted)
-	if crcCalc.Sum32() != expectedCRC {
-		return nil, fmt.Errorf("CRC mismatch in 'rAWd' chunk")
-	}
-	return extracted, nil
+	return paddecode.ExtractDataFromPNG(r)
 }
@@ -167,6 +167,35 @@ func TestExtractDataFromPNG(t *testing.T) {
 	}
 }
 
+// TestPNGDataChunks tests that data too large for one PNG chunk is split
+// across consecutive 'rAWd' chunks and extracted whole
+func TestPNGDataChunks(t *testing.T) {
+	testData := bytes.Repeat([]byte("0123456789"), 10)
+	for _, maxChunk := range []int{7, 10, 99, 100, 1000} {
+		var buf bytes.Buffer
+		if err := encodePNGWithDataChunks(&buf, createSmallPNG(), testData, maxChunk); err != nil {
+			t.Fatalf("Failed to encode PNG with %d-byte chunks: %v", maxChunk, err)
+		}
+		if _, err := png.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Errorf("PNG with %d-byte chunks is not a valid image: %v", maxChunk, err)
+		}
+		if got, want := bytes.Count(buf.Bytes(), []byte("rAWd")), (len(testData)+maxChunk-1)/maxChunk; got != want {
+			t.Errorf("Expected %d 'rAWd' chunks of at most %d bytes, got %d", want, maxChunk, got)
+		}
+		extracted, err := ExtractDataFromPNG(bytes.NewReader(buf.Bytes()))
+		if err != nil || !bytes.Equal(extracted, testData) {
+			t.Errorf("Data in %d-byte chunks extracted as %q, %v", maxChunk, extracted, err)
+		}
+
+		// Damage to any of the chunks is detected
+		damaged := bytes.Clone(buf.Bytes())
+		damaged[bytes.LastIndex(damaged, []byte("rAWd"))+4] ^= 0xff
+		if _, err := ExtractDataFromPNG(bytes.NewReader(damaged)); err == nil {
+			t.Errorf("Expected a CRC mismatch in the last of the %d-byte chunks", maxChunk)
+		}
+	}
+}
+
 func TestEncodePNGWithDataErrors(t *testing.T) {
 	// Test with invalid PNG
 	invalidPNG := []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A} // Just PNG signature
@@ -198,3 +227,28 @@ func writeMinimalPNG(w io.Writer, img image.Image) error {
 func createSmallPNG() image.Image {
 	return image.NewRGBA(image.Rect(0, 0, 1, 1))
 }
+
+// FuzzExtractDataFromPNG checks that the PNG chunks of untrusted collections
+// are read without panicking, and that what is extracted lies within them
+func FuzzExtractDataFromPNG(f *testing.F) {
+	var valid, plain bytes.Buffer
+	if err := encodePNGWithData(&valid, createSmallPNG(), []byte("seed data")); err != nil {
+		f.Fatalf("Failed to encode PNG with data: %v", err)
+	}
+	if err := writeMinimalPNG(&plain, createSmallPNG()); err != nil {
+		f.Fatalf("Failed to write minimal PNG: %v", err)
+	}
+	f.Add(valid.Bytes())
+	f.Add(plain.Bytes())
+	f.Add(valid.Bytes()[:valid.Len()-20])
+	f.Add([]byte("rAWd"))
+	f.Add([]byte("\xff\xff\xff\xffrAWd"))
+	f.Add([]byte("\x00\x00\x00\x00rAWd\x00\x00\x00\x00"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		extracted, err := ExtractDataFromPNG(bytes.NewReader(data))
+		if err == nil && !bytes.Contains(data, extracted) {
+			t.Errorf("Extracted %d bytes that are not in the %d byte PNG", len(extracted), len(data))
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// GroupManifestFileName is the name of the manifest file stored in each group
+// directory of a hierarchical collection set
+const GroupManifestFileName = "padlock-group.json"
+
+// GroupManifest describes one group of custodians in a hierarchical set, in
+// which every group must reach its own threshold to reconstruct the data
+type GroupManifest struct {
+	Version  int      `json:"version"`  // Manifest format version
+	Group    string   `json:"group"`    // Name of this group (e.g., "board")
+	Required int      `json:"required"` // Collections of this group required (K)
+	Copies   int      `json:"copies"`   // Collections in this group (N)
+	Groups   []string `json:"groups"`   // Names of all groups, every one of which is required
+}
+
+// WriteGroupManifest writes a group manifest into a group directory
+func WriteGroupManifest(ctx context.Context, groupDir string, m GroupManifest) error {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	m.Version = ManifestVersion
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Error(fmt.Errorf("failed to encode group manifest: %w", err))
+		return fmt.Errorf("failed to encode group manifest: %w", err)
+	}
+
+	path := filepath.Join(groupDir, GroupManifestFileName)
+	log.Debugf("Writing group manifest: %s", path)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		log.Error(fmt.Errorf("failed to write group manifest %s: %w", path, err))
+		return fmt.Errorf("failed to write group manifest %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadGroupManifest reads the manifest of a group directory. It returns nil
+// without error for groups that have no manifest.
+func ReadGroupManifest(ctx context.Context, groupDir string) (*GroupManifest, error) {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	path := filepath.Join(groupDir, GroupManifestFileName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read group manifest %s: %w", path, err))
+		return nil, fmt.Errorf("failed to read group manifest %s: %w", path, err)
+	}
+
+	var m GroupManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Error(fmt.Errorf("invalid group manifest %s: %w", path, err))
+		return nil, fmt.Errorf("invalid group manifest %s: %w", path, err)
+	}
+	if m.Version > ManifestVersion {
+		log.Error(fmt.Errorf("group manifest %s has unsupported version %d", path, m.Version))
+		return nil, fmt.Errorf("group manifest %s has unsupported version %d", path, m.Version)
+	}
+	return &m, nil
+}
+
+// FindGroups returns the group directories of a hierarchical collection set in
+// inputDir, sorted by name. A group directory is a subdirectory that is not
+// itself a collection and holds either a group manifest or collections, so a
+// group can be reassembled from its custodians' collections alone.
+func FindGroups(ctx context.Context, inputDir string) ([]string, error) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
+
+	entries, err := os.ReadDir(inputDir)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read input directory: %w", err))
+		return nil, fmt.Errorf("failed to read input directory: %w", err)
+	}
+
+	var groups []string
+	for _, entry := range entries {
+		if !entry.IsDir() || isCollectionName(entry.Name()) {
+			continue
+		}
+		if _, _, ok := parseVolumeDirName(entry.Name()); ok {
+			continue
+		}
+		groupDir := filepath.Join(inputDir, entry.Name())
+		if isGroupDir(groupDir) && !isCustodianBundle(groupDir) {
+			log.Debugf("Found group directory: %s", groupDir)
+			groups = append(groups, groupDir)
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// isGroupDir reports whether a directory holds a group manifest or collections
+func isGroupDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, GroupManifestFileName)); err == nil {
+		return true
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			if _, _, ok := parseVolumeDirName(name); ok || isCollectionName(name) {
+				return true
+			}
+		} else if strings.HasSuffix(name, ".zip") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestFindGroups(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "group-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A group with a manifest, a group reassembled from collections alone,
+	// a plain collection and an unrelated directory
+	for _, dir := range []string{"board", "engineers/3A5", "2A3", "notes"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	m := GroupManifest{Group: "board", Required: 2, Copies: 3, Groups: []string{"board", "engineers"}}
+	if err := WriteGroupManifest(ctx, filepath.Join(tempDir, "board"), m); err != nil {
+		t.Fatalf("WriteGroupManifest failed: %v", err)
+	}
+
+	groups, err := FindGroups(ctx, tempDir)
+	if err != nil {
+		t.Fatalf("FindGroups failed: %v", err)
+	}
+	if len(groups) != 2 || filepath.Base(groups[0]) != "board" || filepath.Base(groups[1]) != "engineers" {
+		t.Errorf("Expected groups board and engineers, got %v", groups)
+	}
+
+	got, err := ReadGroupManifest(ctx, groups[0])
+	if err != nil || got == nil {
+		t.Fatalf("ReadGroupManifest failed: %v", err)
+	}
+	if got.Version != ManifestVersion || got.Required != 2 || got.Copies != 3 || len(got.Groups) != 2 {
+		t.Errorf("Unexpected group manifest: %+v", got)
+	}
+	if got, err := ReadGroupManifest(ctx, groups[1]); err != nil || got != nil {
+		t.Errorf("Expected no manifest for engineers, got %+v (%v)", got, err)
+	}
+}
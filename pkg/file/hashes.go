@@ -0,0 +1,205 @@
+package file
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/audit"
+	"github.com/rayozzie/padlock/pkg/paddecode"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// hashManifestName is the name of the entry, at the end of the tar stream,
+// recording the SHA-256 of each file. Restores that do not know it, such as
+// GNU tar's, leave it as a file of this name.
+const hashManifestName = ".padlock-sha256.json"
+
+// hashManifestRecord is the PAX record marking the hash manifest, so that a
+// file of the input with the same name is never mistaken for it
+const hashManifestRecord = paddecode.HashManifestRecord
+
+// maxHashManifestSize bounds the manifest read from a stream
+const maxHashManifestSize = 1 << 30
+
+// HashManifest records the SHA-256 of each file of an archive, as it was when
+// it was encoded, so that a restore can be checked file by file. Hard links
+// are recorded under each of their names.
+type HashManifest struct {
+	Files []audit.FileHash `json:"files"`
+}
+
+// IsHashManifest reports whether a tar entry is the hash manifest
+func IsHashManifest(header *tar.Header) bool {
+	return header.PAXRecords[hashManifestRecord] != ""
+}
+
+// hashRecorder hashes the files of a walk, for SerializeOptions.RecordHashes
+type hashRecorder struct {
+	files []audit.FileHash
+	index map[string]int
+}
+
+// add records the hash of an entry
+func (hr *hashRecorder) add(rel string, size int64, h hash.Hash) {
+	if hr.index == nil {
+		hr.index = make(map[string]int)
+	}
+	hr.index[rel] = len(hr.files)
+	hr.files = append(hr.files, audit.FileHash{Path: rel, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+}
+
+// addLink records a hard link with the hash of the file it links to
+func (hr *hashRecorder) addLink(rel string, target string) {
+	if i, ok := hr.index[target]; ok {
+		fh := hr.files[i]
+		fh.Path = rel
+		hr.files = append(hr.files, fh)
+	}
+}
+
+// write writes the manifest as the last entry of the stream
+func (hr *hashRecorder) write(tw *tar.Writer, deterministic bool) error {
+	data, err := json.MarshalIndent(HashManifest{Files: hr.files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	modTime := time.Now()
+	if deterministic {
+		modTime = deterministicModTime
+	}
+	header := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       hashManifestName,
+		Mode:       0644,
+		Size:       int64(len(data)),
+		ModTime:    modTime,
+		PAXRecords: map[string]string{hashManifestRecord: "1"},
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// readHashManifest reads the hash manifest from the current entry of a tar stream
+func readHashManifest(r io.Reader) (*HashManifest, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxHashManifestSize))
+	if err != nil {
+		return nil, err
+	}
+	var manifest HashManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid hash manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// ReadHashManifest reads a tar stream to its end and returns the hash manifest
+// recorded in it, or nil if it was encoded without one
+func ReadHashManifest(ctx context.Context, r io.Reader) (*HashManifest, error) {
+	log := trace.FromContext(ctx).WithPrefix("EXTRACT")
+	defer io.Copy(io.Discard, r)
+
+	var manifest *HashManifest
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return manifest, nil
+		}
+		if err != nil {
+			log.Error(fmt.Errorf("tar header read error: %w", err))
+			return nil, fmt.Errorf("%w: tar header read error: %w", ErrNotArchive, err)
+		}
+		if IsHashManifest(header) {
+			if manifest, err = readHashManifest(tr); err != nil {
+				log.Error(err)
+				return nil, err
+			}
+		}
+	}
+}
+
+// VerifyRestored checks the files restored into outputDir against a hash
+// manifest. Files are looked up by their path in the archive, and only those
+// matching the patterns (see ValidatePatterns) are checked, or all of them if
+// there are none. Files that are missing or differ are listed, and make the
+// result ErrHashMismatch.
+func VerifyRestored(ctx context.Context, manifest *HashManifest, outputDir string, patterns []string) error {
+	restored := make(map[string]string)
+	for _, fh := range manifest.Files {
+		if len(patterns) == 0 || matchPathOrParent(patterns, fh.Path) {
+			restored[fh.Path] = filepath.Join(outputDir, filepath.FromSlash(fh.Path))
+		}
+	}
+	return verifyHashes(ctx, manifest, restored)
+}
+
+// verifyHashes hashes the files restored from each path of the manifest, given
+// where each was restored, and reports those that are missing or differ.
+// Paths that were not restored are not checked.
+func verifyHashes(ctx context.Context, manifest *HashManifest, restored map[string]string) error {
+	log := trace.FromContext(ctx).WithPrefix("VERIFY")
+
+	var failed []string
+	checked := 0
+	for _, fh := range manifest.Files {
+		path, ok := restored[fh.Path]
+		if !ok {
+			continue
+		}
+		got, err := hashFile(path)
+		switch {
+		case os.IsNotExist(err):
+			failed = append(failed, fh.Path+" (missing)")
+		case err != nil:
+			failed = append(failed, fmt.Sprintf("%s (%v)", fh.Path, err))
+		case got.Size != fh.Size || got.SHA256 != fh.SHA256:
+			failed = append(failed, fh.Path)
+		default:
+			log.Debugf("Verified: %s", fh.Path)
+		}
+		checked++
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		log.Infof("%d of %d files do not match the hashes recorded when they were encoded:", len(failed), checked)
+		for i, name := range failed {
+			if i == maxConflictListing {
+				log.Infof("  ... and %d more", len(failed)-maxConflictListing)
+				break
+			}
+			log.Infof("  - %s", name)
+		}
+		log.Error(fmt.Errorf("%w: %d of %d files", ErrHashMismatch, len(failed), checked))
+		return fmt.Errorf("%w: %d of %d files", ErrHashMismatch, len(failed), checked)
+	}
+	log.Infof("Verified %d files against the hashes recorded when they were encoded", checked)
+	return nil
+}
+
+// hashFile returns the size and SHA-256 of a file
+func hashFile(path string) (audit.FileHash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return audit.FileHash{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return audit.FileHash{}, err
+	}
+	return audit.FileHash{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
@@ -0,0 +1,128 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestRecordHashes(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	files := map[string]string{
+		"a.txt":     "first file",
+		"sub/b.txt": "second file",
+		// A file of the input with the manifest's name is an ordinary file
+		hashManifestName: "not a manifest",
+	}
+	for name, content := range files {
+		path := filepath.Join(inputDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	// serialize returns the stream of the input, read into memory
+	serialize := func(t *testing.T, opts SerializeOptions) []byte {
+		stream, err := SerializeDirectoryToStream(ctx, inputDir, opts)
+		if err != nil {
+			t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+		}
+		defer stream.Close()
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		return data
+	}
+
+	// restore deserializes a stream into a new directory
+	restore := func(t *testing.T, data []byte, opts DeserializeOptions) (string, error) {
+		outputDir := filepath.Join(t.TempDir(), "output")
+		return outputDir, DeserializeDirectoryFromStream(ctx, outputDir, bytes.NewReader(data), false, opts)
+	}
+
+	t.Run("Recorded", func(t *testing.T) {
+		manifest, err := ReadHashManifest(ctx, bytes.NewReader(serialize(t, SerializeOptions{RecordHashes: true})))
+		if err != nil {
+			t.Fatalf("ReadHashManifest failed: %v", err)
+		}
+		if manifest == nil || len(manifest.Files) != len(files) {
+			t.Fatalf("Expected %d hashes, got %+v", len(files), manifest)
+		}
+		for _, fh := range manifest.Files {
+			if fh.Size != int64(len(files[fh.Path])) {
+				t.Errorf("Expected size %d for %s, got %d", len(files[fh.Path]), fh.Path, fh.Size)
+			}
+		}
+	})
+
+	t.Run("Not recorded", func(t *testing.T) {
+		manifest, err := ReadHashManifest(ctx, bytes.NewReader(serialize(t, SerializeOptions{})))
+		if err != nil || manifest != nil {
+			t.Errorf("Expected no manifest, got %+v, %v", manifest, err)
+		}
+	})
+
+	t.Run("Restored and verified", func(t *testing.T) {
+		outputDir, err := restore(t, serialize(t, SerializeOptions{RecordHashes: true}), DeserializeOptions{})
+		if err != nil {
+			t.Fatalf("DeserializeDirectoryFromStream failed: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(outputDir, hashManifestName))
+		if err != nil || string(data) != files[hashManifestName] {
+			t.Errorf("Expected the input's own %s to be restored, got %q, %v", hashManifestName, data, err)
+		}
+	})
+
+	t.Run("Selected files", func(t *testing.T) {
+		if _, err := restore(t, serialize(t, SerializeOptions{RecordHashes: true}), DeserializeOptions{Files: []string{"sub"}}); err != nil {
+			t.Errorf("Expected files not selected to be left unchecked, got %v", err)
+		}
+	})
+
+	t.Run("Verify restored", func(t *testing.T) {
+		data := serialize(t, SerializeOptions{RecordHashes: true})
+		manifest, err := ReadHashManifest(ctx, bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("ReadHashManifest failed: %v", err)
+		}
+		outputDir, err := restore(t, data, DeserializeOptions{})
+		if err != nil {
+			t.Fatalf("DeserializeDirectoryFromStream failed: %v", err)
+		}
+		if err := VerifyRestored(ctx, manifest, outputDir, nil); err != nil {
+			t.Fatalf("Expected the restore to verify, got %v", err)
+		}
+
+		// Alter one file and remove another
+		if err := os.WriteFile(filepath.Join(outputDir, "a.txt"), []byte("first fil3"), 0644); err != nil {
+			t.Fatalf("Failed to alter file: %v", err)
+		}
+		if err := VerifyRestored(ctx, manifest, outputDir, nil); !errors.Is(err, ErrHashMismatch) {
+			t.Errorf("Expected ErrHashMismatch for an altered file, got %v", err)
+		}
+		if err := VerifyRestored(ctx, manifest, outputDir, []string{"sub"}); err != nil {
+			t.Errorf("Expected the selected files to verify, got %v", err)
+		}
+		if err := os.Remove(filepath.Join(outputDir, "sub", "b.txt")); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+		if err := VerifyRestored(ctx, manifest, outputDir, []string{"sub"}); !errors.Is(err, ErrHashMismatch) {
+			t.Errorf("Expected ErrHashMismatch for a missing file, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// maxNestingDepth is how many levels of folders below the input directory are
+// searched for collections, such as "3A5" kept in a "shares" folder of a zip
+const maxNestingDepth = 2
+
+// identifyVotes is how many chunks of a collection must agree on the collection
+// they belong to for it to be identified before all of its chunks are read
+const identifyVotes = 2
+
+// openRenamedCollection opens a collection directory whose name is not that of
+// a collection, such as one renamed "dad's share", identifying the collection
+// by the headers of its chunks
+func openRenamedCollection(ctx context.Context, dir string) (Collection, bool) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
+
+	if isCustodianBundle(dir) || hasObfuscatedManifest(dir) {
+		return Collection{}, false
+	}
+	collName, format, naming, err := identifyCollection(dirArchive{dir: dir})
+	if err != nil {
+		log.Debugf("Not a collection: %s: %v", dir, err)
+		return Collection{}, false
+	}
+	log.Debugf("Identified %s as collection %s by its chunks", dir, collName)
+	return Collection{Name: collName, Path: dir, Format: format, Naming: naming}, true
+}
+
+// identifyCollection identifies the collection held by an archive or directory
+// from the headers of its chunks, returning the collection's name and the
+// format and naming of its chunks. Every chunk names its collection, so any
+// intact chunk identifies it: chunks are read in order, passing over those
+// that are damaged or hold another chunk than their name says, until
+// identifyVotes of them agree, and otherwise the collection named by most of
+// them is taken. The naming is that of the chunk files as they are, never one
+// standing for the collection name, since the name of the directory holding
+// them no longer gives it.
+func identifyCollection(a collectionArchive) (string, Format, ChunkNaming, error) {
+	format, naming, err := detectFormat(a, "")
+	if err != nil {
+		return "", "", ChunkNaming{}, err
+	}
+	type chunkFile struct {
+		number int
+		name   string
+	}
+	var chunks []chunkFile
+	for _, name := range a.names() {
+		if n, ok := naming.chunkNumber("", name); ok {
+			chunks = append(chunks, chunkFile{number: n, name: name})
+		}
+	}
+	if len(chunks) == 0 {
+		return "", "", ChunkNaming{}, fmt.Errorf("no chunk files found")
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].number < chunks[j].number })
+
+	votes := make(map[string]int)
+	best := ""
+	var lastErr error
+	for _, chunk := range chunks {
+		collName, err := identifyChunk(a, format, chunk.name, chunk.number)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		votes[collName]++
+		if votes[collName] > votes[best] {
+			best = collName
+		}
+		if votes[best] >= identifyVotes {
+			break
+		}
+	}
+	if best == "" {
+		return "", "", ChunkNaming{}, lastErr
+	}
+	return best, format, naming, nil
+}
+
+// identifyChunk returns the collection named by the header of one chunk file,
+// which must hold the chunk its name gives
+func identifyChunk(a collectionArchive, format Format, name string, number int) (string, error) {
+	contents, err := a.readFile(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := decodeChunkFile(format, contents)
+	if err != nil {
+		return "", err
+	}
+	h, err := paddecode.ReadChunkHeader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%s is not a chunk: %w", name, err)
+	}
+	if h.Number != number {
+		return "", fmt.Errorf("%s holds chunk %d of collection %s", name, h.Number, h.Collection)
+	}
+	return h.Collection, nil
+}
+
+// isNestingDir reports whether a directory that is not a collection may hold
+// collections nested in it. Hidden directories and the groups of a
+// hierarchical set, whose collections are found group by group, are not.
+func isNestingDir(dir string) bool {
+	if strings.HasPrefix(filepath.Base(dir), ".") {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, GroupManifestFileName))
+	return os.IsNotExist(err)
+}
+
+// hasCollectionNamed reports whether a list of collections holds one of a name
+func hasCollectionNamed(collections []Collection, name string) bool {
+	for _, coll := range collections {
+		if coll.Name == name {
+			return true
+		}
+	}
+	return false
+}
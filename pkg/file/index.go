@@ -0,0 +1,163 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// IndexFileName is the name of the index of a collection's chunks, written
+// into each collection directory at the end of an encode
+const IndexFileName = "padlock-index.json"
+
+// ChunkIndex lists the chunk files of a collection directory, so that they can
+// be counted and found without listing the directory or probing for each
+// file. A collection split across volumes holds the same index in each volume.
+// Packed collections and streamed zips need none, as their own tables of
+// contents serve the same purpose.
+type ChunkIndex struct {
+	Version    int          `json:"version"`           // Manifest format version
+	Collection string       `json:"collection"`        // Collection name (e.g., "3A5")
+	Volumes    int          `json:"volumes,omitempty"` // Number of volumes the collection is split across, if more than one
+	Chunks     int          `json:"chunks"`            // Number of chunk files
+	Files      []IndexEntry `json:"files"`             // Chunk files, in chunk order
+
+	byNumber map[int]IndexEntry
+}
+
+// IndexEntry describes one chunk file of a collection
+type IndexEntry struct {
+	Number int    `json:"number"`           // Chunk number
+	Name   string `json:"name"`             // File name of the chunk
+	Bytes  int64  `json:"bytes"`            // Size of the file
+	Volume int    `json:"volume,omitempty"` // Volume holding the file, numbered from 1, for collections split across volumes
+}
+
+// WriteIndex lists the chunk files of a collection directory, or of each of
+// its volumes, and writes the index of them into each
+func WriteIndex(ctx context.Context, coll Collection) error {
+	log := trace.FromContext(ctx).WithPrefix("INDEX")
+
+	dirs := coll.Volumes
+	if len(dirs) == 0 {
+		dirs = []string{coll.Path}
+	}
+	index := ChunkIndex{Version: ManifestVersion, Collection: coll.Name, Files: []IndexEntry{}}
+	if len(coll.Volumes) > 0 {
+		index.Volumes = len(coll.Volumes)
+	}
+	naming := coll.Naming.orDefault(coll.Format)
+	seen := make(map[int]bool)
+	for i, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			log.Error(fmt.Errorf("failed to read collection directory %s: %w", dir, err))
+			return fmt.Errorf("failed to read collection directory %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			n, ok := naming.chunkNumber(coll.Name, entry.Name())
+			if !ok || entry.IsDir() || seen[n] {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				log.Error(fmt.Errorf("failed to index %s: %w", filepath.Join(dir, entry.Name()), err))
+				return fmt.Errorf("failed to index %s: %w", filepath.Join(dir, entry.Name()), err)
+			}
+			seen[n] = true
+			e := IndexEntry{Number: n, Name: entry.Name(), Bytes: info.Size()}
+			if len(coll.Volumes) > 0 {
+				e.Volume = i + 1
+			}
+			index.Files = append(index.Files, e)
+		}
+	}
+	sort.Slice(index.Files, func(i, j int) bool { return index.Files[i].Number < index.Files[j].Number })
+	index.Chunks = len(index.Files)
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		log.Error(fmt.Errorf("failed to encode index: %w", err))
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, IndexFileName)
+		log.Debugf("Writing index of %d chunks: %s", index.Chunks, path)
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			log.Error(fmt.Errorf("failed to write index %s: %w", path, err))
+			return fmt.Errorf("failed to write index %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// loadIndex reads the index of a collection directory, returning nil if it
+// has none or if the index does not describe it, such as one left behind by
+// an older encode or naming, in which case its chunks are found by scanning
+func loadIndex(ctx context.Context, coll Collection) *ChunkIndex {
+	log := trace.FromContext(ctx).WithPrefix("INDEX")
+
+	data, err := os.ReadFile(filepath.Join(coll.Path, IndexFileName))
+	if err != nil {
+		return nil
+	}
+	var index ChunkIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		log.Debugf("Ignoring invalid index of collection %s: %v", coll.Name, err)
+		return nil
+	}
+	if err := index.check(coll); err != nil {
+		log.Debugf("Ignoring index of collection %s: %v", coll.Name, err)
+		return nil
+	}
+	log.Debugf("Using index of %d chunks of collection %s", index.Chunks, coll.Name)
+	return &index
+}
+
+// check verifies that an index describes a collection. Beyond its own
+// consistency, only the last chunk it lists is looked for, which catches an
+// index left stale by chunks having been removed or the index copied elsewhere.
+func (x *ChunkIndex) check(coll Collection) error {
+	switch {
+	case x.Version > ManifestVersion:
+		return fmt.Errorf("unsupported version %d", x.Version)
+	case x.Collection != coll.Name:
+		return fmt.Errorf("index is of collection %s", x.Collection)
+	case x.Volumes != len(coll.Volumes):
+		return fmt.Errorf("index is of %d volumes, not %d", x.Volumes, len(coll.Volumes))
+	case x.Chunks != len(x.Files):
+		return fmt.Errorf("index counts %d chunks but lists %d", x.Chunks, len(x.Files))
+	case x.Chunks == 0:
+		return fmt.Errorf("index lists no chunks")
+	}
+	x.byNumber = make(map[int]IndexEntry, len(x.Files))
+	for _, e := range x.Files {
+		if _, dup := x.byNumber[e.Number]; dup || !strings.EqualFold(e.Name, coll.chunkFileName(e.Number)) {
+			return fmt.Errorf("unexpected entry %s for chunk %d", e.Name, e.Number)
+		}
+		if (len(coll.Volumes) == 0 && e.Volume != 0) || (len(coll.Volumes) > 0 && (e.Volume < 1 || e.Volume > len(coll.Volumes))) {
+			return fmt.Errorf("unexpected volume %d for chunk %d", e.Volume, e.Number)
+		}
+		x.byNumber[e.Number] = e
+	}
+	last := x.Files[len(x.Files)-1]
+	info, err := os.Stat(filepath.Join(x.dir(coll, last), last.Name))
+	if err != nil || info.Size() != last.Bytes {
+		return fmt.Errorf("chunk %d is not as indexed", last.Number)
+	}
+	return nil
+}
+
+// dir returns the directory holding the file of an entry of an index
+func (x *ChunkIndex) dir(coll Collection, e IndexEntry) string {
+	if e.Volume > 0 {
+		return coll.Volumes[e.Volume-1]
+	}
+	return coll.Path
+}
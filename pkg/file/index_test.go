@@ -0,0 +1,113 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestChunkIndex(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+
+	// writeCollection writes a collection of three chunks and indexes it
+	writeCollection := func(t *testing.T) (string, [][]byte) {
+		dir := t.TempDir()
+		collPath, err := CreateCollectionDirectory(ctx, dir, "3A5")
+		if err != nil {
+			t.Fatalf("CreateCollectionDirectory failed: %v", err)
+		}
+		var chunks [][]byte
+		for n := 1; n <= 3; n++ {
+			chunk := make([]byte, 1000*n)
+			rand.Read(chunk)
+			if err := os.WriteFile(filepath.Join(collPath, ChunkFileName(FormatBin, "3A5", n)), chunk, 0644); err != nil {
+				t.Fatalf("Failed to write chunk: %v", err)
+			}
+			chunks = append(chunks, chunk)
+		}
+		if err := WriteIndex(ctx, Collection{Name: "3A5", Path: collPath, Format: FormatBin}); err != nil {
+			t.Fatalf("WriteIndex failed: %v", err)
+		}
+		return dir, chunks
+	}
+	find := func(t *testing.T, dir string) Collection {
+		collections, _, err := FindCollections(ctx, dir)
+		if err != nil || len(collections) != 1 {
+			t.Fatalf("FindCollections returned %v, %v", collections, err)
+		}
+		return collections[0]
+	}
+
+	t.Run("Indexed", func(t *testing.T) {
+		dir, chunks := writeCollection(t)
+		coll := find(t, dir)
+		if coll.index == nil || coll.index.Chunks != 3 || coll.index.Files[2].Bytes != 3000 {
+			t.Fatalf("Expected an index of 3 chunks, got %+v", coll.index)
+		}
+		cr := NewCollectionReader(coll)
+		for _, chunk := range chunks {
+			data, err := cr.ReadNextChunk(ctx)
+			if err != nil || !bytes.Equal(data, chunk) {
+				t.Fatalf("ReadNextChunk returned %d bytes, %v", len(data), err)
+			}
+		}
+		if _, err := cr.ReadNextChunk(ctx); err != io.EOF {
+			t.Errorf("Expected EOF after the indexed chunks, got %v", err)
+		}
+	})
+
+	t.Run("Missing chunk", func(t *testing.T) {
+		// A chunk listed in the index but missing is damage, not the end
+		dir, _ := writeCollection(t)
+		if err := os.Remove(filepath.Join(dir, "3A5", ChunkFileName(FormatBin, "3A5", 2))); err != nil {
+			t.Fatalf("Failed to remove chunk: %v", err)
+		}
+		cr := NewCollectionReader(find(t, dir))
+		if _, err := cr.ReadNextChunk(ctx); err != nil {
+			t.Fatalf("ReadNextChunk failed: %v", err)
+		}
+		if _, err := cr.ReadNextChunk(ctx); !errors.Is(err, pad.ErrChunkCorrupt) {
+			t.Errorf("Expected ErrChunkCorrupt, got %v", err)
+		}
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		// An index that no longer describes the collection is ignored, and its
+		// chunks are found by scanning
+		dir, _ := writeCollection(t)
+		if err := os.Remove(filepath.Join(dir, "3A5", ChunkFileName(FormatBin, "3A5", 3))); err != nil {
+			t.Fatalf("Failed to remove chunk: %v", err)
+		}
+		coll := find(t, dir)
+		if coll.index != nil {
+			t.Errorf("Expected the stale index to be ignored")
+		}
+		cr := NewCollectionReader(coll)
+		for i := 0; i < 2; i++ {
+			if _, err := cr.ReadNextChunk(ctx); err != nil {
+				t.Fatalf("ReadNextChunk failed: %v", err)
+			}
+		}
+		if _, err := cr.ReadNextChunk(ctx); err != io.EOF {
+			t.Errorf("Expected EOF, got %v", err)
+		}
+	})
+
+	t.Run("Renamed", func(t *testing.T) {
+		dir, _ := writeCollection(t)
+		if err := WriteIndex(ctx, Collection{Name: "3A5", Path: filepath.Join(dir, "3A5"), Format: FormatPNG}); err != nil {
+			t.Fatalf("WriteIndex failed: %v", err)
+		}
+		if coll := find(t, dir); coll.index != nil {
+			t.Errorf("Expected an index of another format to be ignored, got %+v", coll.index)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// ChunkNumbers returns the numbers of the chunk files present in a collection,
+// across all of its volumes, in ascending order. Unlike a CollectionReader,
+// which stops at the first gap, it reports every chunk that is present.
+func ChunkNumbers(coll Collection) ([]int, error) {
+	dirs := coll.Volumes
+	if len(dirs) == 0 {
+		dirs = []string{coll.Path}
+	}
+
+	naming := coll.Naming.orDefault(coll.Format)
+	var numbers []int
+	seen := make(map[int]bool)
+	for _, dir := range dirs {
+		names, err := coll.fileNames(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			n, ok := naming.chunkNumber(coll.Name, name)
+			if !ok || seen[n] {
+				continue
+			}
+			seen[n] = true
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// ReadChunk reads one chunk of a collection by number, searching each volume
+// of multi-volume collections
+func ReadChunk(ctx context.Context, coll Collection, chunkNumber int) ([]byte, error) {
+	if coll.archive != nil {
+		return readArchivedChunk(ctx, coll, chunkNumber)
+	}
+	if coll.index != nil {
+		if entry, ok := coll.index.byNumber[chunkNumber]; ok {
+			return coll.formatter().ReadChunk(ctx, coll.index.dir(coll, entry), 0, chunkNumber)
+		}
+	}
+	collPath := coll.Path
+	for _, volumePath := range coll.Volumes {
+		if _, err := os.Stat(findFile(filepath.Join(volumePath, coll.chunkFileName(chunkNumber)))); err == nil {
+			collPath = volumePath
+			break
+		}
+	}
+	return coll.formatter().ReadChunk(ctx, collPath, 0, chunkNumber)
+}
+
+// readArchivedChunk reads one chunk of a collection in place from its archive.
+// A chunk that is missing or whose entry is damaged is reported as corrupt, so
+// that it can be rebuilt from parity or taken from other collections.
+func readArchivedChunk(ctx context.Context, coll Collection, chunkNumber int) ([]byte, error) {
+	log := trace.FromContext(ctx).WithPrefix("ARCHIVE")
+
+	name := findArchived(coll.archive, coll.chunkFileName(chunkNumber))
+	log.Debugf("Reading chunk %d from %s in %s", chunkNumber, name, coll.Path)
+	contents, err := coll.archive.readFile(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: chunk file does not exist: %s in %s", pad.ErrChunkCorrupt, name, coll.Path)
+		}
+		return nil, fmt.Errorf("%w: failed to read %s in %s: %w", pad.ErrChunkCorrupt, name, coll.Path, err)
+	}
+	return decodeChunkFile(coll.Format, contents)
+}
+
+// ChunkSource reads the chunks of a collection by number, so that a decode can
+// work around chunks that are missing from it. Chunks of collections with
+// parity files are verified, and rebuilt if they are missing or damaged. The
+// chunks of a collection holding snapshots are read from one snapshot, and
+// numbered from 1 within it.
+type ChunkSource struct {
+	coll    Collection
+	last    int
+	offset  int
+	numbers []int
+	parity  *collectionParity
+}
+
+// NewChunkSource creates a chunk source for a collection. A collection holding
+// snapshots is read from the one named with WithSnapshot, or else the latest.
+func NewChunkSource(ctx context.Context, coll Collection) (*ChunkSource, error) {
+	numbers, err := ChunkNumbers(coll)
+	if err != nil {
+		return nil, err
+	}
+	last := 0
+	if len(numbers) > 0 {
+		last = numbers[len(numbers)-1]
+	}
+	parity, err := loadParity(ctx, coll)
+	if err != nil {
+		return nil, err
+	}
+	if parity != nil {
+		last = max(last, parity.lastChunk())
+	}
+	source := &ChunkSource{coll: coll, last: last, numbers: numbers, parity: parity}
+
+	snapshots, err := ReadSnapshots(ctx, coll)
+	if err != nil {
+		return nil, err
+	}
+	if name := snapshotFromContext(ctx); len(snapshots) > 0 || name != "" {
+		snapshot, err := FindSnapshot(snapshots, name)
+		if err != nil {
+			return nil, fmt.Errorf("collection %s: %w", coll.Name, err)
+		}
+		source.offset, source.last = snapshot.FirstChunk-1, snapshot.LastChunk-snapshot.FirstChunk+1
+		source.numbers = nil
+		for _, n := range numbers {
+			if n >= snapshot.FirstChunk && n <= snapshot.LastChunk {
+				source.numbers = append(source.numbers, n-source.offset)
+			}
+		}
+	}
+	return source, nil
+}
+
+// Name returns the collection name
+func (s *ChunkSource) Name() string {
+	return s.coll.Name
+}
+
+// LastChunk returns the highest chunk number present in the collection
+func (s *ChunkSource) LastChunk() int {
+	return s.last
+}
+
+// ChunkNumbers returns the numbers of the chunks present in the collection, in order
+func (s *ChunkSource) ChunkNumbers() []int {
+	return s.numbers
+}
+
+// ReadChunk reads one chunk of the collection
+func (s *ChunkSource) ReadChunk(ctx context.Context, chunkNumber int) ([]byte, error) {
+	if s.parity != nil {
+		return s.parity.readChunk(ctx, chunkNumber+s.offset)
+	}
+	return ReadChunk(ctx, s.coll, chunkNumber+s.offset)
+}
+
+// Rebuilt returns the numbers of the chunks read so far that had to be rebuilt from parity
+func (s *ChunkSource) Rebuilt() []int {
+	if s.parity == nil {
+		return nil
+	}
+	return s.parity.rebuiltChunks()
+}
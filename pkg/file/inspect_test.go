@@ -0,0 +1,52 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestChunkNumbers(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "inspect-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A collection with a gap at chunk 2 and unrelated files
+	collPath := filepath.Join(tempDir, "2A3")
+	if err := os.MkdirAll(collPath, 0755); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	for _, name := range []string{"2A3_0001.bin", "2A3_0003.bin", "2B3_0002.bin", "README.txt", "2A3_bad.bin"} {
+		if err := os.WriteFile(filepath.Join(collPath, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	coll := Collection{Name: "2A3", Path: collPath, Format: FormatBin}
+	numbers, err := ChunkNumbers(coll)
+	if err != nil {
+		t.Fatalf("ChunkNumbers failed: %v", err)
+	}
+	if !reflect.DeepEqual(numbers, []int{1, 3}) {
+		t.Errorf("Expected chunks [1 3], got %v", numbers)
+	}
+
+	data, err := ReadChunk(ctx, coll, 3)
+	if err != nil || string(data) != "2A3_0003.bin" {
+		t.Errorf("ReadChunk returned %q (%v)", data, err)
+	}
+	if _, err := ReadChunk(ctx, coll, 2); err == nil {
+		t.Errorf("Expected an error reading a missing chunk")
+	}
+}
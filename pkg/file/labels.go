@@ -0,0 +1,85 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// LabelsFileName is the name of the file recording the labels of the
+// collections of a set, stored in each collection along with its chunks
+const LabelsFileName = "padlock-labels.json"
+
+// LabelsManifest records the labels given to the collections of a set, such as
+// "ALPHA" for 3A5, so that shares labeled by hand can be matched with their
+// collections. Every collection holds the labels of the whole set, so that any
+// one of them tells which labels to look for.
+type LabelsManifest struct {
+	Version int               `json:"version"` // Manifest format version
+	Labels  map[string]string `json:"labels"`  // Label of each collection of the set, by collection name
+}
+
+// AddLabels adds the labels of the collections of a set to a collection
+// through add, which writes a file of the collection with the given mode
+func AddLabels(labels map[string]string, add func(name string, data []byte, mode fs.FileMode) error) error {
+	data, err := json.MarshalIndent(LabelsManifest{Version: ManifestVersion, Labels: labels}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode labels: %w", err)
+	}
+	return add(LabelsFileName, append(data, '\n'), 0644)
+}
+
+// WriteLabels writes the labels of the collections of a set into a collection directory
+func WriteLabels(ctx context.Context, collPath string, labels map[string]string) error {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	return AddLabels(labels, func(name string, data []byte, mode fs.FileMode) error {
+		path := filepath.Join(collPath, name)
+		log.Debugf("Writing labels: %s", path)
+		if err := os.WriteFile(path, data, mode); err != nil {
+			log.Error(fmt.Errorf("failed to write labels %s: %w", path, err))
+			return fmt.Errorf("failed to write labels %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// ReadLabels reads the labels of the collections of a set recorded in one of
+// them, in its directory or archive. It returns nil without error for
+// collections that have none.
+func ReadLabels(ctx context.Context, coll Collection) (map[string]string, error) {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	r, err := coll.openFile(LabelsFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read labels of collection %s: %w", coll.Name, err))
+		return nil, fmt.Errorf("failed to read labels of collection %s: %w", coll.Name, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read labels of collection %s: %w", coll.Name, err))
+		return nil, fmt.Errorf("failed to read labels of collection %s: %w", coll.Name, err)
+	}
+
+	var m LabelsManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Error(fmt.Errorf("invalid labels in collection %s: %w", coll.Name, err))
+		return nil, fmt.Errorf("invalid labels in collection %s: %w", coll.Name, err)
+	}
+	if m.Version > ManifestVersion {
+		log.Error(fmt.Errorf("labels in collection %s have unsupported version %d", coll.Name, m.Version))
+		return nil, fmt.Errorf("labels in collection %s have unsupported version %d", coll.Name, m.Version)
+	}
+	return m.Labels, nil
+}
@@ -0,0 +1,62 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestLabels(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "labels-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	collPath := filepath.Join(tempDir, "2A3")
+	if err := os.MkdirAll(collPath, 0755); err != nil {
+		t.Fatalf("Failed to create collection directory: %v", err)
+	}
+	coll := Collection{Name: "2A3", Path: collPath, Format: FormatBin}
+
+	// A collection without labels has none, without error
+	if labels, err := ReadLabels(ctx, coll); err != nil || labels != nil {
+		t.Errorf("Expected no labels, got %v (%v)", labels, err)
+	}
+
+	want := map[string]string{"2A3": "ALPHA", "2B3": "BRAVO", "2C3": "CHARLIE"}
+	if err := WriteLabels(ctx, collPath, want); err != nil {
+		t.Fatalf("WriteLabels failed: %v", err)
+	}
+	labels, err := ReadLabels(ctx, coll)
+	if err != nil {
+		t.Fatalf("ReadLabels failed: %v", err)
+	}
+	if len(labels) != len(want) {
+		t.Errorf("Expected %d labels, got %v", len(want), labels)
+	}
+	for collName, label := range want {
+		if labels[collName] != label {
+			t.Errorf("Expected label %q for %s, got %q", label, collName, labels[collName])
+		}
+	}
+
+	// Labels of a newer format are rejected
+	badPath := filepath.Join(tempDir, "2B3")
+	if err := os.MkdirAll(badPath, 0755); err != nil {
+		t.Fatalf("Failed to create collection directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(badPath, LabelsFileName), []byte(`{"version": 99, "labels": {}}`), 0644); err != nil {
+		t.Fatalf("Failed to write labels: %v", err)
+	}
+	if _, err := ReadLabels(ctx, Collection{Name: "2B3", Path: badPath, Format: FormatBin}); err == nil {
+		t.Errorf("Expected an error reading labels of an unsupported version")
+	}
+}
@@ -0,0 +1,45 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// FilesystemLimits are the limits of a filesystem, and of the process, that
+// writing many chunk files to it can run into
+type FilesystemLimits struct {
+	FreeBytes int64 // Bytes available to the user, or -1 if unknown
+	FreeFiles int64 // Files (inodes) that can still be created, or -1 if unknown or not limited
+	OpenFiles int64 // Files the process may hold open at once, or -1 if unknown or not limited
+}
+
+// GetFilesystemLimits returns the limits of the filesystem holding path. An
+// output directory that doesn't exist yet is measured by its nearest existing
+// parent, on whose filesystem it will be created.
+func GetFilesystemLimits(path string) FilesystemLimits {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return filesystemLimits(path)
+}
+
+// IsNoSpace reports whether an error is the filesystem, or the user's quota on
+// it, running out of space or files
+func IsNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// IsTooManyOpenFiles reports whether an error is the process, or the system,
+// running out of open files
+func IsTooManyOpenFiles(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package file
+
+// filesystemLimits is not supported on this platform, where every limit is unknown
+func filesystemLimits(path string) FilesystemLimits {
+	return FilesystemLimits{FreeBytes: -1, FreeFiles: -1, OpenFiles: -1}
+}
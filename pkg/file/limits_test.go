@@ -0,0 +1,54 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestGetFilesystemLimits(t *testing.T) {
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "limits-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// An output directory yet to be created is measured by its parent
+	limits := GetFilesystemLimits(tempDir)
+	missing := GetFilesystemLimits(filepath.Join(tempDir, "out", "collections"))
+	if (missing.FreeBytes < 0) != (limits.FreeBytes < 0) || (missing.FreeFiles < 0) != (limits.FreeFiles < 0) {
+		t.Errorf("Expected a missing directory to be measured by its parent, got %+v and %+v", missing, limits)
+	}
+	if limits.FreeBytes == 0 || limits.FreeFiles == 0 || limits.OpenFiles == 0 {
+		t.Errorf("Expected every limit to be unknown or nonzero, got %+v", limits)
+	}
+}
+
+func TestIsNoSpace(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		noSpace   bool
+		openFiles bool
+	}{
+		{"No space", &os.PathError{Op: "write", Path: "3A5_0001.bin", Err: syscall.ENOSPC}, true, false},
+		{"Quota", fmt.Errorf("failed to write chunk data: %w", syscall.EDQUOT), true, false},
+		{"Too many open files", &os.PathError{Op: "open", Path: "3A5.zip", Err: syscall.EMFILE}, false, true},
+		{"Other", fmt.Errorf("failed to write chunk data: %w", syscall.EACCES), false, false},
+		{"None", nil, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsNoSpace(tt.err); got != tt.noSpace {
+				t.Errorf("IsNoSpace(%v) = %v, want %v", tt.err, got, tt.noSpace)
+			}
+			if got := IsTooManyOpenFiles(tt.err); got != tt.openFiles {
+				t.Errorf("IsTooManyOpenFiles(%v) = %v, want %v", tt.err, got, tt.openFiles)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux || darwin
+
+package file
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// filesystemLimits reads the free space and files of the filesystem holding
+// path, and the open file limit of the process
+func filesystemLimits(path string) FilesystemLimits {
+	limits := FilesystemLimits{FreeBytes: -1, FreeFiles: -1, OpenFiles: -1}
+
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err == nil {
+		limits.FreeBytes = int64(st.Bavail) * int64(st.Bsize)
+		// Filesystems that allocate inodes as needed report none at all
+		if st.Files > 0 {
+			limits.FreeFiles = int64(st.Ffree)
+		}
+	}
+
+	var rlimit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlimit); err == nil && rlimit.Cur != unix.RLIM_INFINITY {
+		limits.OpenFiles = int64(rlimit.Cur)
+	}
+	return limits
+}
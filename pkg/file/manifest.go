@@ -0,0 +1,126 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// ManifestFileName is the name of the manifest file stored alongside the chunks of a collection
+const ManifestFileName = "padlock.json"
+
+// ManifestVersion is the current version of the manifest format
+const ManifestVersion = 1
+
+// Manifest describes the contents of a collection directory. It holds only
+// layout information (never key material), so it is safe to store in the clear
+// next to the chunks it describes.
+type Manifest struct {
+	Version    int    `json:"version"`              // Manifest format version
+	Collection string `json:"collection,omitempty"` // Collection name (e.g., "3A5")
+	Volume     int    `json:"volume,omitempty"`     // 1-based index of this volume, for multi-volume collections
+	Volumes    int    `json:"volumes,omitempty"`    // Total number of volumes of the collection
+	FirstChunk int    `json:"firstChunk,omitempty"` // First chunk number stored in this directory
+	LastChunk  int    `json:"lastChunk,omitempty"`  // Last chunk number stored in this directory
+
+	// Chunks maps the obfuscated name of each chunk file of a collection whose
+	// names have been obfuscated to the number of its chunk, and Files that of
+	// each of its other files to its original name, in which "%s" stands for
+	// the collection name. Neither gives the collection away, which is read
+	// from the headers of its chunks instead.
+	Chunks map[string]int    `json:"chunks,omitempty"`
+	Files  map[string]string `json:"files,omitempty"`
+
+	// Store locates the chunk store holding the files of a stored collection,
+	// relative to the manifest unless it is absolute, and Objects maps the name
+	// of each of its files to the SHA-256 hash under which the store keeps it
+	Store   string            `json:"store,omitempty"`
+	Objects map[string]string `json:"objects,omitempty"`
+
+	// Snapshots lists the encodes appended to the collection, oldest first,
+	// each of which is decoded on its own
+	Snapshots []Snapshot `json:"snapshots,omitempty"`
+
+	// Metadata holds the key/value pairs describing the set given when it was
+	// encoded, such as its owner, purpose or expiry date
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Policy holds the expiry of the set and notes on how it may be used
+	Policy *Policy `json:"policy,omitempty"`
+}
+
+// Policy records how the collections of a set may be used, as hints that a
+// decode reports and may enforce
+type Policy struct {
+	NotAfter *time.Time `json:"notAfter,omitempty"` // When the collections expire and are to be rotated
+	Notes    string     `json:"notes,omitempty"`    // Notes on the use of the collections, e.g. their rotation schedule
+}
+
+// Expired reports whether the collections of a set are used after they expired
+func (p *Policy) Expired(now time.Time) bool {
+	return p != nil && p.NotAfter != nil && now.After(*p.NotAfter)
+}
+
+// WriteManifest writes a manifest into a collection directory
+func WriteManifest(ctx context.Context, collPath string, m Manifest) error {
+	return writeManifest(ctx, filepath.Join(collPath, ManifestFileName), m)
+}
+
+// writeManifest writes a manifest to path
+func writeManifest(ctx context.Context, path string, m Manifest) error {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	m.Version = ManifestVersion
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Error(fmt.Errorf("failed to encode manifest: %w", err))
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	log.Debugf("Writing manifest: %s", path)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		log.Error(fmt.Errorf("failed to write manifest %s: %w", path, err))
+		return fmt.Errorf("failed to write manifest %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadManifest reads the manifest of a collection directory. It returns nil
+// without error for collections that have no manifest.
+func ReadManifest(ctx context.Context, collPath string) (*Manifest, error) {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	path := filepath.Join(collPath, ManifestFileName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read manifest %s: %w", path, err))
+		return nil, fmt.Errorf("failed to read manifest %s: %w", path, err)
+	}
+
+	m, err := parseManifest(path, data)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseManifest parses the contents of a manifest read from path
+func parseManifest(path string, data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	if m.Version > ManifestVersion {
+		return nil, fmt.Errorf("manifest %s has unsupported version %d", path, m.Version)
+	}
+	return &m, nil
+}
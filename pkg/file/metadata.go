@@ -0,0 +1,89 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// AddMetadata adds a manifest recording the metadata and usage policy of a set
+// to one of its collections through add, which writes a file of the collection
+// with the given mode. It is used for collections written as they are encoded,
+// such as streamed zips, which hold no other manifest.
+func AddMetadata(collName string, metadata map[string]string, policy *Policy, add func(name string, data []byte, mode fs.FileMode) error) error {
+	m := Manifest{Version: ManifestVersion, Collection: collName, Metadata: metadata, Policy: policy}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return add(ManifestFileName, append(data, '\n'), 0644)
+}
+
+// WriteMetadata records the metadata and usage policy of a set in the manifest
+// of one of its collection directories, creating the manifest if the
+// collection has none
+func WriteMetadata(ctx context.Context, collPath string, collName string, metadata map[string]string, policy *Policy) error {
+	m, err := ReadManifest(ctx, collPath)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		m = &Manifest{Collection: collName}
+	}
+	m.Metadata, m.Policy = metadata, policy
+	return WriteManifest(ctx, collPath, *m)
+}
+
+// ReadCollectionManifest reads the manifest of a collection, in its directory
+// or archive. It returns nil without error for collections that have none.
+func ReadCollectionManifest(ctx context.Context, coll Collection) (*Manifest, error) {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	r, err := coll.openFile(ManifestFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read manifest of collection %s: %w", coll.Name, err))
+		return nil, fmt.Errorf("failed to read manifest of collection %s: %w", coll.Name, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read manifest of collection %s: %w", coll.Name, err))
+		return nil, fmt.Errorf("failed to read manifest of collection %s: %w", coll.Name, err)
+	}
+
+	m, err := parseManifest(filepath.Join(coll.Path, ManifestFileName), data)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReadMetadata reads the metadata of a set recorded in the manifest of one of
+// its collections. It returns nil without error for collections that have none.
+func ReadMetadata(ctx context.Context, coll Collection) (map[string]string, error) {
+	m, err := ReadCollectionManifest(ctx, coll)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	return m.Metadata, nil
+}
+
+// ReadPolicy reads the usage policy of a set recorded in the manifest of one
+// of its collections. It returns nil without error for collections that have none.
+func ReadPolicy(ctx context.Context, coll Collection) (*Policy, error) {
+	m, err := ReadCollectionManifest(ctx, coll)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	return m.Policy, nil
+}
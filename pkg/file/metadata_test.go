@@ -0,0 +1,115 @@
+package file
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "metadata-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	metadata := map[string]string{"owner": "alice", "ticket": "OPS-1234"}
+	check := func(name string, coll Collection) {
+		got, err := ReadMetadata(ctx, coll)
+		if err != nil {
+			t.Fatalf("%s: ReadMetadata failed: %v", name, err)
+		}
+		if len(got) != len(metadata) || got["owner"] != "alice" || got["ticket"] != "OPS-1234" {
+			t.Errorf("%s: unexpected metadata %v", name, got)
+		}
+	}
+
+	t.Run("Collection directory", func(t *testing.T) {
+		collPath := filepath.Join(tempDir, "2A3")
+		if err := os.MkdirAll(collPath, 0755); err != nil {
+			t.Fatalf("Failed to create collection directory: %v", err)
+		}
+		coll := Collection{Name: "2A3", Path: collPath, Format: FormatBin}
+
+		// A collection without a manifest has no metadata, without error
+		if got, err := ReadMetadata(ctx, coll); err != nil || got != nil {
+			t.Errorf("Expected no metadata, got %v (%v)", got, err)
+		}
+		if err := WriteMetadata(ctx, collPath, coll.Name, metadata, nil); err != nil {
+			t.Fatalf("WriteMetadata failed: %v", err)
+		}
+		check("directory", coll)
+	})
+
+	t.Run("Volume manifest", func(t *testing.T) {
+		collPath := filepath.Join(tempDir, "2B3.vol01", "2B3")
+		if err := os.MkdirAll(collPath, 0755); err != nil {
+			t.Fatalf("Failed to create volume directory: %v", err)
+		}
+		if err := WriteManifest(ctx, collPath, Manifest{Collection: "2B3", Volume: 1, Volumes: 2, FirstChunk: 1, LastChunk: 4}); err != nil {
+			t.Fatalf("WriteManifest failed: %v", err)
+		}
+
+		// The metadata is added to the manifest, keeping the layout it records
+		if err := WriteMetadata(ctx, collPath, "2B3", metadata, nil); err != nil {
+			t.Fatalf("WriteMetadata failed: %v", err)
+		}
+		m, err := ReadManifest(ctx, collPath)
+		if err != nil || m == nil {
+			t.Fatalf("ReadManifest failed: %v", err)
+		}
+		if m.Volume != 1 || m.Volumes != 2 || m.FirstChunk != 1 || m.LastChunk != 4 {
+			t.Errorf("Volume layout was not kept: %+v", m)
+		}
+		check("volume", Collection{Name: "2B3", Path: collPath, Format: FormatBin})
+	})
+
+	t.Run("Added", func(t *testing.T) {
+		collPath := filepath.Join(tempDir, "2C3")
+		if err := os.MkdirAll(collPath, 0755); err != nil {
+			t.Fatalf("Failed to create collection directory: %v", err)
+		}
+		err := AddMetadata("2C3", metadata, nil, func(name string, data []byte, mode fs.FileMode) error {
+			return os.WriteFile(filepath.Join(collPath, name), data, mode)
+		})
+		if err != nil {
+			t.Fatalf("AddMetadata failed: %v", err)
+		}
+		check("added", Collection{Name: "2C3", Path: collPath, Format: FormatBin})
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		collPath := filepath.Join(tempDir, "3A5")
+		if err := os.MkdirAll(collPath, 0755); err != nil {
+			t.Fatalf("Failed to create collection directory: %v", err)
+		}
+		coll := Collection{Name: "3A5", Path: collPath, Format: FormatBin}
+		if p, err := ReadPolicy(ctx, coll); err != nil || p != nil {
+			t.Errorf("Expected no policy, got %+v (%v)", p, err)
+		}
+
+		notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		if err := WriteMetadata(ctx, collPath, coll.Name, nil, &Policy{NotAfter: &notAfter, Notes: "rotate yearly"}); err != nil {
+			t.Fatalf("WriteMetadata failed: %v", err)
+		}
+		p, err := ReadPolicy(ctx, coll)
+		if err != nil || p == nil || p.NotAfter == nil || !p.NotAfter.Equal(notAfter) || p.Notes != "rotate yearly" {
+			t.Fatalf("Unexpected policy %+v (%v)", p, err)
+		}
+		if p.Expired(notAfter) || !p.Expired(notAfter.Add(time.Second)) {
+			t.Errorf("Expected the policy to expire just after %s", notAfter)
+		}
+		if (&Policy{Notes: "no expiry"}).Expired(time.Now()) || (*Policy)(nil).Expired(time.Now()) {
+			t.Errorf("Expected a policy without expiry never to expire")
+		}
+	})
+}
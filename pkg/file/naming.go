@@ -0,0 +1,307 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+)
+
+// ChunkNaming is the convention by which the files holding the chunks of a
+// collection are named: a prefix, the chunk number padded with zeros to a
+// number of digits, and an extension. The zero value stands for the default
+// naming of the collection's format, "IMG%s_%04d.PNG" or "%s_%04d.bin".
+type ChunkNaming struct {
+	Prefix    string // Text before the chunk number, in which "%s" stands for the collection name
+	Digits    int    // Minimum number of digits of the chunk number
+	Extension string // Text after the chunk number, starting with a dot, or empty
+}
+
+// ChunkNamingPresets are the named templates accepted by ParseChunkNaming
+var ChunkNamingPresets = map[string]string{
+	"camera": "DSC_%04d.PNG",
+	"phone":  "IMG_%04d.PNG",
+	"scan":   "scan%03d.bin",
+}
+
+// DefaultChunkNaming returns the default naming of the chunks of a format
+func DefaultChunkNaming(format Format) ChunkNaming {
+	if format == FormatPNG {
+		return ChunkNaming{Prefix: "IMG%s_", Digits: 4, Extension: ".PNG"}
+	}
+	return ChunkNaming{Prefix: "%s_", Digits: 4, Extension: ".bin"}
+}
+
+// chunkNamingTemplate matches a naming template such as "DSC_%04d.PNG"
+var chunkNamingTemplate = regexp.MustCompile(`^(.*?)%(0[1-9])?d(.*)$`)
+
+// ParseChunkNaming parses a naming template in which "%04d" (or "%d" for no
+// padding) stands for the chunk number and an optional "%s" before it for the
+// collection name, such as "DSC_%04d.PNG" or "IMG%s_%04d.PNG", or the name
+// of one of the ChunkNamingPresets
+func ParseChunkNaming(template string) (ChunkNaming, error) {
+	if preset, ok := ChunkNamingPresets[template]; ok {
+		template = preset
+	}
+	m := chunkNamingTemplate.FindStringSubmatch(template)
+	if m == nil {
+		return ChunkNaming{}, fmt.Errorf("invalid chunk naming '%s': expected a template such as DSC_%%04d.PNG", template)
+	}
+	n := ChunkNaming{Prefix: m[1], Digits: 1, Extension: m[3]}
+	if m[2] != "" {
+		n.Digits, _ = strconv.Atoi(m[2])
+	}
+	if err := n.validate(); err != nil {
+		return ChunkNaming{}, fmt.Errorf("invalid chunk naming '%s': %w", template, err)
+	}
+	return n, nil
+}
+
+// validate checks that the names of chunks can be told apart and parsed back
+func (n ChunkNaming) validate() error {
+	if strings.Count(n.Prefix, "%") != strings.Count(n.Prefix, "%s") || strings.Count(n.Prefix, "%s") > 1 {
+		return fmt.Errorf("the prefix may only hold %%s, once")
+	}
+	if strings.ContainsAny(n.Prefix+n.Extension, `/\`) {
+		return fmt.Errorf("names may not hold a path separator")
+	}
+	if strings.HasSuffix(n.Prefix, "%s") || (n.Prefix != "" && n.Prefix[len(n.Prefix)-1] >= '0' && n.Prefix[len(n.Prefix)-1] <= '9') {
+		return fmt.Errorf("the chunk number must not directly follow a digit or the collection name")
+	}
+	if strings.Contains(n.Extension, "%") {
+		return fmt.Errorf("the extension may not hold %%")
+	}
+	if n.Extension != "" && (!strings.HasPrefix(n.Extension, ".") || filepath.Ext(n.Extension) != n.Extension || len(n.Extension) < 2) {
+		return fmt.Errorf("the chunk number must be followed by an extension such as .PNG, or nothing")
+	}
+	if n.Extension == paritySuffix {
+		return fmt.Errorf("%s is the extension of parity files", paritySuffix)
+	}
+	if n.Digits < 1 || n.Digits > 9 {
+		return fmt.Errorf("the chunk number must have between 1 and 9 digits")
+	}
+	return nil
+}
+
+// CheckFormat checks that chunks of a format can be named with the naming, as
+// the extensions ".PNG" and ".bin" tell the format of chunks when decoding
+func (n ChunkNaming) CheckFormat(format Format) error {
+	ext := strings.ToLower(n.Extension)
+	if (ext == ".png" && format != FormatPNG) || (ext == ".bin" && format != FormatBin) {
+		return fmt.Errorf("chunks in %s format cannot be named %s", format, n)
+	}
+	return nil
+}
+
+// String returns the naming as a template accepted by ParseChunkNaming
+func (n ChunkNaming) String() string {
+	if n.Digits <= 1 {
+		return n.Prefix + "%d" + n.Extension
+	}
+	return fmt.Sprintf("%s%%0%dd%s", n.Prefix, n.Digits, n.Extension)
+}
+
+// orDefault returns the naming, or the default naming of a format if it is the zero value
+func (n ChunkNaming) orDefault(format Format) ChunkNaming {
+	if n == (ChunkNaming{}) {
+		return DefaultChunkNaming(format)
+	}
+	return n
+}
+
+// FileName returns the name of the file holding a chunk of a collection
+func (n ChunkNaming) FileName(collectionName string, chunkNumber int) string {
+	return n.prefix(collectionName) + fmt.Sprintf("%0*d", n.Digits, chunkNumber) + n.Extension
+}
+
+// prefix returns the prefix of the names of the chunks of a collection
+func (n ChunkNaming) prefix(collectionName string) string {
+	return strings.Replace(n.Prefix, "%s", collectionName, 1)
+}
+
+// chunkNumber returns the number of the chunk of a collection held in a file,
+// and whether the file is named as one of its chunks. Names are matched
+// regardless of case, so that chunks whose names were changed in case by a
+// copy between platforms, such as "img3a5_0001.png", are still found.
+func (n ChunkNaming) chunkNumber(collectionName string, fileName string) (int, bool) {
+	prefix := n.prefix(collectionName)
+	if len(fileName) < len(prefix)+len(n.Extension) || !strings.EqualFold(fileName[:len(prefix)], prefix) || !strings.EqualFold(fileName[len(fileName)-len(n.Extension):], n.Extension) {
+		return 0, false
+	}
+	digits := fileName[len(prefix) : len(fileName)-len(n.Extension)]
+	if len(digits) < n.Digits {
+		return 0, false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	number, err := strconv.Atoi(digits)
+	if err != nil || number <= 0 {
+		return 0, false
+	}
+	return number, true
+}
+
+// findFile returns the path of the file named by path or, if there is none, of
+// a file in the same directory whose name differs from it only in case, such
+// as "img3a5_0001.png" for "IMG3A5_0001.PNG". Paths with no such file are
+// returned unchanged.
+func findFile(path string) string {
+	if _, err := os.Lstat(path); err == nil {
+		return path
+	}
+	dir, base := filepath.Split(path)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return path
+	}
+	for _, entry := range entries {
+		if strings.EqualFold(entry.Name(), base) {
+			return filepath.Join(dir, entry.Name())
+		}
+	}
+	return path
+}
+
+// findArchived returns the name of the file of an archive named name or, if
+// there is none, one whose name differs from it only in case
+func findArchived(a collectionArchive, name string) string {
+	if a.has(name) {
+		return name
+	}
+	for _, candidate := range a.names() {
+		if strings.EqualFold(candidate, name) {
+			return candidate
+		}
+	}
+	return name
+}
+
+// chunkNamingKey is the context key of the naming of the chunks being written
+type chunkNamingKey struct{}
+
+// WithChunkNaming returns a context under which chunks are written with the given naming
+func WithChunkNaming(ctx context.Context, naming ChunkNaming) context.Context {
+	return context.WithValue(ctx, chunkNamingKey{}, naming)
+}
+
+// chunkNaming returns the naming with which chunks are written under a
+// context, the zero value standing for the default naming of their format
+func chunkNaming(ctx context.Context) ChunkNaming {
+	naming, _ := ctx.Value(chunkNamingKey{}).(ChunkNaming)
+	return naming
+}
+
+// chunkFileNamePattern splits the name of a chunk file into the prefix and the
+// chunk number, the extension being split off beforehand
+var chunkFileNamePattern = regexp.MustCompile(`^(.*[^0-9])?([0-9]+)$`)
+
+// pngSignature begins every PNG file
+var pngSignature = paddecode.PNGSignature
+
+// detectFormat determines the format of a collection and the naming of its
+// chunks from its files. The chunks are the largest group of files whose names
+// differ only in a number before their extension. Their format follows from
+// the extensions ".PNG" and ".bin", in either case, and is otherwise read from
+// their contents. Names that differ only in case, such as those of chunks copied
+// in part through a filesystem that changed their case, count as one group,
+// named as most of its files are.
+func detectFormat(a collectionArchive, collName string) (Format, ChunkNaming, error) {
+	type group struct {
+		naming    ChunkNaming
+		names     []string
+		spellings map[ChunkNaming]int
+	}
+	groups := make(map[ChunkNaming]*group)
+	for _, name := range a.names() {
+		switch name {
+		case ManifestFileName, ObfuscatedManifestFileName, CustodianManifestFileName, RecoveryInfoFileName, RecoveryReadmeFileName:
+			continue
+		}
+		ext := filepath.Ext(name)
+		if strings.EqualFold(ext, paritySuffix) || strings.Contains(name, "/") || isDecoderFile(name) {
+			continue
+		}
+		m := chunkFileNamePattern.FindStringSubmatch(strings.TrimSuffix(name, ext))
+		if m == nil {
+			continue
+		}
+		key := ChunkNaming{Prefix: strings.ToLower(m[1]), Extension: strings.ToLower(ext)}
+		g := groups[key]
+		if g == nil {
+			g = &group{naming: ChunkNaming{Digits: len(m[2])}, spellings: make(map[ChunkNaming]int)}
+			groups[key] = g
+		}
+		g.naming.Digits = min(g.naming.Digits, len(m[2]))
+		g.names = append(g.names, name)
+		g.spellings[ChunkNaming{Prefix: m[1], Extension: ext}]++
+	}
+
+	// Names differing only in case are of the same chunks, named as most of them are
+	for _, g := range groups {
+		var spelling ChunkNaming
+		for sp, count := range g.spellings {
+			if best := g.spellings[spelling]; count > best || (count == best && sp.String() < spelling.String()) {
+				spelling = sp
+			}
+		}
+		g.naming.Prefix, g.naming.Extension = spelling.Prefix, spelling.Extension
+	}
+
+	var best *group
+	for _, g := range groups {
+		if best == nil || len(g.names) > len(best.names) || (len(g.names) == len(best.names) && g.naming.String() < best.naming.String()) {
+			best = g
+		}
+	}
+	if best == nil {
+		return "", ChunkNaming{}, fmt.Errorf("unable to determine format for collection")
+	}
+
+	switch strings.ToLower(best.naming.Extension) {
+	case ".png":
+		return FormatPNG, best.naming.generalize(FormatPNG, collName), nil
+	case ".bin":
+		return FormatBin, best.naming.generalize(FormatBin, collName), nil
+	}
+	sort.Strings(best.names)
+	for _, name := range best.names {
+		contents, err := a.readFile(name)
+		if err != nil {
+			continue
+		}
+		if bytes.HasPrefix(contents, pngSignature) {
+			return FormatPNG, best.naming.generalize(FormatPNG, collName), nil
+		}
+		return FormatBin, best.naming.generalize(FormatBin, collName), nil
+	}
+	return "", ChunkNaming{}, fmt.Errorf("unable to determine format for collection")
+}
+
+// generalize turns a naming detected from the files of a collection back into
+// the template it was written with, in which "%s" stands for the collection
+// name, and into the zero value if that is the default naming of the format.
+// A collection name whose case was changed is kept as it is, so that the names
+// of the chunks follow from the naming exactly.
+func (n ChunkNaming) generalize(format Format, collName string) ChunkNaming {
+	if collName != "" && strings.Contains(n.Prefix, collName) {
+		general := n
+		general.Prefix = strings.Replace(n.Prefix, collName, "%s", 1)
+		if general.validate() == nil && general.prefix(collName) == n.Prefix {
+			n = general
+		}
+	}
+	if n == DefaultChunkNaming(format) {
+		return ChunkNaming{}
+	}
+	return n
+}
@@ -0,0 +1,223 @@
+package file
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestParseChunkNaming(t *testing.T) {
+	valid := map[string]ChunkNaming{
+		"camera":         {Prefix: "DSC_", Digits: 4, Extension: ".PNG"},
+		"scan":           {Prefix: "scan", Digits: 3, Extension: ".bin"},
+		"IMG%s_%04d.PNG": {Prefix: "IMG%s_", Digits: 4, Extension: ".PNG"},
+		"page-%d":        {Prefix: "page-", Digits: 1},
+		"%02d.dat":       {Prefix: "", Digits: 2, Extension: ".dat"},
+	}
+	for template, want := range valid {
+		got, err := ParseChunkNaming(template)
+		if err != nil || got != want {
+			t.Errorf("ParseChunkNaming(%q) = %+v, %v; expected %+v", template, got, err, want)
+		}
+	}
+
+	for _, template := range []string{"", "DSC.PNG", "%s%04d.PNG", "a1%04d.PNG", "x/%04d.PNG", "x%04d.%s", "x%04d_end", "x%04d.par", "%s_%s_%04d.bin"} {
+		if _, err := ParseChunkNaming(template); err == nil {
+			t.Errorf("Expected ParseChunkNaming(%q) to fail", template)
+		}
+	}
+
+	naming, _ := ParseChunkNaming("camera")
+	if err := naming.CheckFormat(FormatBin); err == nil {
+		t.Errorf("Expected .PNG names to be rejected for the bin format")
+	}
+	if err := naming.CheckFormat(FormatPNG); err != nil {
+		t.Errorf("Expected .PNG names to be accepted for the png format: %v", err)
+	}
+}
+
+func TestChunkNamingFileNames(t *testing.T) {
+	naming := ChunkNaming{Prefix: "IMG%s_", Digits: 4, Extension: ".PNG"}
+	if name := naming.FileName("3A5", 12); name != "IMG3A5_0012.PNG" {
+		t.Errorf("Expected IMG3A5_0012.PNG, got %s", name)
+	}
+	if name := ChunkFileName(FormatBin, "3A5", 12); name != "3A5_0012.bin" {
+		t.Errorf("Expected 3A5_0012.bin, got %s", name)
+	}
+	if name := (ChunkNaming{Prefix: "p", Digits: 1}).FileName("3A5", 12345); name != "p12345" {
+		t.Errorf("Expected p12345, got %s", name)
+	}
+	if name := naming.FileName("3A5", 250000); name != "IMG3A5_250000.PNG" {
+		t.Errorf("Expected IMG3A5_250000.PNG, got %s", name)
+	}
+
+	for name, want := range map[string]int{"IMG3A5_0012.PNG": 12, "IMG3A5_12345.PNG": 12345, "IMG3A5_250000.PNG": 250000, "IMG3A5_012.PNG": 0, "IMG3B5_0012.PNG": 0, "IMG3A5_0000.PNG": 0} {
+		n, ok := naming.chunkNumber("3A5", name)
+		if n != want || ok != (want > 0) {
+			t.Errorf("chunkNumber(%s) = %d, %v; expected %d", name, n, ok, want)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "naming-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tests := []struct {
+		name   string
+		naming ChunkNaming
+		format Format
+		chunks int
+	}{
+		{"Default bin", ChunkNaming{}, FormatBin, 3},
+		{"Default png", ChunkNaming{}, FormatPNG, 3},
+		{"Camera", ChunkNaming{Prefix: "DSC_", Digits: 4, Extension: ".PNG"}, FormatPNG, 3},
+		{"Collection name", ChunkNaming{Prefix: "page-%s-", Digits: 2, Extension: ".bin"}, FormatBin, 3},
+		{"Unpadded", ChunkNaming{Prefix: "p", Digits: 1, Extension: ".bin"}, FormatBin, 12},
+		{"Sniffed png", ChunkNaming{Prefix: "photo", Digits: 3, Extension: ".jpg"}, FormatPNG, 2},
+		{"Sniffed bin", ChunkNaming{Prefix: "", Digits: 2, Extension: ".dat"}, FormatBin, 2},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collPath := filepath.Join(tempDir, string(rune('A'+i)), "3A5")
+			for n := 1; n <= tt.chunks; n++ {
+				w := NewChunkWriter(ctx, GetNamedFormatter(tt.format, tt.naming), collPath, 0, n)
+				w.Write([]byte("chunk data"))
+				if err := w.Close(); err != nil {
+					t.Fatalf("Failed to write chunk: %v", err)
+				}
+			}
+			if err := WriteManifest(ctx, collPath, Manifest{Collection: "3A5"}); err != nil {
+				t.Fatalf("Failed to write manifest: %v", err)
+			}
+
+			format, naming, err := determineCollectionFormat(collPath)
+			if err != nil {
+				t.Fatalf("determineCollectionFormat failed: %v", err)
+			}
+			if format != tt.format || naming != tt.naming {
+				t.Errorf("Expected %s named %+v, got %s named %+v", tt.format, tt.naming, format, naming)
+			}
+
+			coll := Collection{Name: "3A5", Path: collPath, Format: format, Naming: naming}
+			numbers, err := ChunkNumbers(coll)
+			if err != nil || len(numbers) != tt.chunks {
+				t.Errorf("Expected %d chunks, got %v (%v)", tt.chunks, numbers, err)
+			}
+			data, err := ReadChunk(ctx, coll, tt.chunks)
+			if err != nil || string(data) != "chunk data" {
+				t.Errorf("Failed to read the last chunk: %q, %v", data, err)
+			}
+		})
+	}
+
+	t.Run("No chunks", func(t *testing.T) {
+		empty := filepath.Join(tempDir, "empty")
+		os.MkdirAll(empty, 0755)
+		if _, _, err := determineCollectionFormat(empty); err == nil {
+			t.Errorf("Expected an error for a directory without chunks")
+		}
+	})
+}
+
+func TestChunkNameCase(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+
+	// copyCollection writes a collection of PNG chunks, then renames those
+	// given to the names they might have after a copy between platforms
+	copyCollection := func(t *testing.T, rename map[int]string) string {
+		dir := t.TempDir()
+		collPath := filepath.Join(dir, "3A5")
+		for n := 1; n <= 4; n++ {
+			w := NewChunkWriter(ctx, GetFormatter(FormatPNG), collPath, 0, n)
+			w.Write([]byte{byte(n)})
+			if err := w.Close(); err != nil {
+				t.Fatalf("Failed to write chunk: %v", err)
+			}
+		}
+		for n, name := range rename {
+			if err := os.Rename(filepath.Join(collPath, ChunkFileName(FormatPNG, "3A5", n)), filepath.Join(collPath, name)); err != nil {
+				t.Fatalf("Failed to rename chunk: %v", err)
+			}
+		}
+		return dir
+	}
+	// readAll checks that all four chunks are found and read, by number and in turn
+	readAll := func(t *testing.T, dir string, naming ChunkNaming) {
+		collections, _, err := FindCollections(ctx, dir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer CloseCollections(collections)
+		coll := collections[0]
+		if coll.Format != FormatPNG || coll.Naming != naming {
+			t.Errorf("Expected png chunks named %+v, got %s named %+v", naming, coll.Format, coll.Naming)
+		}
+		if numbers, err := ChunkNumbers(coll); err != nil || len(numbers) != 4 {
+			t.Errorf("Expected 4 chunks, got %v (%v)", numbers, err)
+		}
+		for n := 1; n <= 4; n++ {
+			if data, err := ReadChunk(ctx, coll, n); err != nil || len(data) != 1 || data[0] != byte(n) {
+				t.Errorf("Failed to read chunk %d: %v, %v", n, data, err)
+			}
+		}
+		cr := NewCollectionReader(coll)
+		for n := 1; n <= 4; n++ {
+			if data, err := cr.ReadNextChunk(ctx); err != nil || data[0] != byte(n) {
+				t.Fatalf("Failed to read chunk %d in turn: %v", n, err)
+			}
+		}
+		if _, err := cr.ReadNextChunk(ctx); err != io.EOF {
+			t.Errorf("Expected EOF after the last chunk, got %v", err)
+		}
+	}
+
+	t.Run("Lowercased", func(t *testing.T) {
+		dir := copyCollection(t, map[int]string{1: "img3a5_0001.png", 2: "img3a5_0002.png", 3: "img3a5_0003.png", 4: "img3a5_0004.png"})
+		readAll(t, dir, ChunkNaming{Prefix: "img3a5_", Digits: 4, Extension: ".png"})
+	})
+
+	t.Run("Extension only", func(t *testing.T) {
+		dir := copyCollection(t, map[int]string{1: "IMG3A5_0001.png", 2: "IMG3A5_0002.png", 3: "IMG3A5_0003.png", 4: "IMG3A5_0004.png"})
+		readAll(t, dir, ChunkNaming{Prefix: "IMG%s_", Digits: 4, Extension: ".png"})
+	})
+
+	t.Run("Mixed", func(t *testing.T) {
+		// Most chunks keep their names, so the collection keeps the default naming
+		dir := copyCollection(t, map[int]string{2: "IMG3A5_0002.png", 4: "img3a5_0004.PNG"})
+		readAll(t, dir, ChunkNaming{})
+	})
+
+	t.Run("Mixed zip", func(t *testing.T) {
+		dir := copyCollection(t, map[int]string{3: "IMG3A5_0003.png"})
+		if _, err := ZipCollections(ctx, []Collection{{Name: "3A5", Path: filepath.Join(dir, "3A5")}}); err != nil {
+			t.Fatalf("ZipCollections failed: %v", err)
+		}
+		if err := os.RemoveAll(filepath.Join(dir, "3A5")); err != nil {
+			t.Fatalf("Failed to remove collection directory: %v", err)
+		}
+		readAll(t, dir, ChunkNaming{})
+	})
+
+	t.Run("Parity", func(t *testing.T) {
+		// Parity files are never taken for chunks, whatever their case
+		naming := DefaultChunkNaming(FormatPNG)
+		if _, ok := naming.chunkNumber("3A5", "IMG3A5_0001.PAR"); ok {
+			t.Errorf("Expected a parity file not to be taken for a chunk")
+		}
+		if n, ok := naming.chunkNumber("3A5", "img3a5_0012.png"); !ok || n != 12 {
+			t.Errorf("Expected chunk 12, got %d, %v", n, ok)
+		}
+	})
+}
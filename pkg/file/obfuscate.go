@@ -0,0 +1,273 @@
+package file
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// ObfuscatedManifestFileName is the name of the manifest of a collection whose
+// names have been obfuscated. Unlike ManifestFileName, it says nothing of what
+// the collection is.
+const ObfuscatedManifestFileName = "index.json"
+
+// ObfuscateCollections renames each collection directory and the files within
+// it to innocuous names derived from a keyed hash, so that neither the names
+// of the collections (e.g. "3A5"), which give away the threshold, nor those of
+// their chunks reveal how the collections were made. A manifest within each
+// collection records the number of the chunk each file holds, and the names of
+// its other files with the collection name left out, from which the collection
+// is read back once its chunks have identified it. The key is random and is not kept, so the names of one set
+// tell nothing about those of another. It returns the renamed collections.
+func ObfuscateCollections(ctx context.Context, collections []Collection) ([]Collection, error) {
+	log := trace.FromContext(ctx).WithPrefix("OBFUSCATE")
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Error(fmt.Errorf("failed to generate obfuscation key: %w", err))
+		return nil, fmt.Errorf("failed to generate obfuscation key: %w", err)
+	}
+
+	renamed := make([]Collection, len(collections))
+	for i, coll := range collections {
+		entries, err := os.ReadDir(coll.Path)
+		if err != nil {
+			log.Error(fmt.Errorf("failed to read collection directory %s: %w", coll.Path, err))
+			return nil, fmt.Errorf("failed to read collection directory %s: %w", coll.Path, err)
+		}
+
+		format, naming, err := detectFormat(dirArchive{dir: coll.Path}, coll.Name)
+		if err != nil {
+			log.Error(fmt.Errorf("failed to find the chunks of collection %s: %w", coll.Name, err))
+			return nil, fmt.Errorf("failed to find the chunks of collection %s: %w", coll.Name, err)
+		}
+		naming = naming.orDefault(format)
+
+		m := Manifest{Chunks: make(map[string]int), Files: make(map[string]string)}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			name := entry.Name()
+			obfuscated := obfuscatedName(key, coll.Name+"/"+name, 16) + strings.ToLower(filepath.Ext(name))
+			if err := os.Rename(filepath.Join(coll.Path, name), filepath.Join(coll.Path, obfuscated)); err != nil {
+				log.Error(fmt.Errorf("failed to rename %s in collection %s: %w", name, coll.Name, err))
+				return nil, fmt.Errorf("failed to rename %s in collection %s: %w", name, coll.Name, err)
+			}
+			if number, ok := naming.chunkNumber(coll.Name, name); ok {
+				m.Chunks[obfuscated] = number
+			} else {
+				m.Files[obfuscated] = strings.Replace(name, coll.Name, "%s", 1)
+			}
+		}
+		if err := writeManifest(ctx, filepath.Join(coll.Path, ObfuscatedManifestFileName), m); err != nil {
+			return nil, err
+		}
+
+		collPath := filepath.Join(filepath.Dir(coll.Path), obfuscatedName(key, coll.Name, 12))
+		if err := os.Rename(coll.Path, collPath); err != nil {
+			log.Error(fmt.Errorf("failed to rename collection directory %s: %w", coll.Path, err))
+			return nil, fmt.Errorf("failed to rename collection directory %s: %w", coll.Path, err)
+		}
+		log.Infof("Obfuscated collection %s: %s", coll.Name, collPath)
+
+		coll.Path = collPath
+		renamed[i] = coll
+	}
+	return renamed, nil
+}
+
+// obfuscatedName derives a name of the given number of hex digits from a
+// keyed hash of the original name
+func obfuscatedName(key []byte, name string, digits int) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))[:digits]
+}
+
+// openObfuscatedCollection opens a collection whose names have been obfuscated,
+// stored at collPath as a directory or archive, if the archive holds the manifest
+// of one. The collection is identified by the headers of its chunks, and its
+// files are read by the names they would have in a collection of that name.
+// Manifests written before they left out the collection name, which map each
+// file to its original name, are read as they are.
+func openObfuscatedCollection(ctx context.Context, archive collectionArchive, collPath string) (Collection, bool) {
+	log := trace.FromContext(ctx).WithPrefix("OBFUSCATE")
+
+	if !archive.has(ObfuscatedManifestFileName) {
+		return Collection{}, false
+	}
+	data, err := archive.readFile(ObfuscatedManifestFileName)
+	if err != nil {
+		log.Debugf("Failed to read the manifest of %s: %v", collPath, err)
+		return Collection{}, false
+	}
+	m, err := parseManifest(collPath, data)
+	if err == nil && len(m.Chunks) == 0 && (!isCollectionName(m.Collection) || len(m.Files) == 0) {
+		err = fmt.Errorf("the manifest lists no chunks")
+	}
+	if err != nil {
+		log.Debugf("%s does not hold an obfuscated collection: %v", collPath, err)
+		return Collection{}, false
+	}
+
+	renamed := &renamedArchive{archive: archive, files: make(map[string]string, len(m.Chunks)+len(m.Files))}
+	collName := m.Collection
+	if len(m.Chunks) > 0 {
+		// Number the chunks alone, for their headers to name the collection
+		chunks := &renamedArchive{archive: archive, files: make(map[string]string, len(m.Chunks))}
+		for obfuscated, number := range m.Chunks {
+			chunks.files[fmt.Sprintf("%d%s", number, filepath.Ext(obfuscated))] = obfuscated
+		}
+		var format Format
+		if collName, format, _, err = identifyCollection(chunks); err != nil {
+			log.Debugf("%s does not hold an obfuscated collection: %v", collPath, err)
+			return Collection{}, false
+		}
+		naming := DefaultChunkNaming(format)
+		for obfuscated, number := range m.Chunks {
+			renamed.files[naming.FileName(collName, number)] = obfuscated
+		}
+		for obfuscated, name := range m.Files {
+			renamed.files[strings.Replace(name, "%s", collName, 1)] = obfuscated
+		}
+	} else {
+		for obfuscated, name := range m.Files {
+			renamed.files[name] = obfuscated
+		}
+	}
+	format, naming, err := detectFormat(renamed, collName)
+	if err != nil {
+		return Collection{}, false
+	}
+	log.Debugf("Found obfuscated collection %s: %s", collName, collPath)
+	return Collection{Name: collName, Path: collPath, Format: format, Naming: naming, archive: renamed}, true
+}
+
+// openObfuscatedArchive opens a zip or tar.gz archive holding a collection
+// whose names have been obfuscated with open, to be read in place
+func openObfuscatedArchive(ctx context.Context, archivePath string, open func() (collectionArchive, error)) (Collection, bool) {
+	log := trace.FromContext(ctx).WithPrefix("OBFUSCATE")
+
+	base := filepath.Base(archivePath)
+	isZip := filepath.Ext(base) == ".zip"
+	if isZip {
+		base = strings.TrimSuffix(base, ".zip")
+	} else {
+		base, _ = tarArchiveBase(base)
+	}
+	if _, _, ok := parseVolumeDirName(base); ok {
+		return Collection{}, false
+	}
+
+	archive, err := open()
+	if err != nil {
+		log.Debugf("Not reading %s in place: %v", archivePath, err)
+		return Collection{}, false
+	}
+	coll, ok := openObfuscatedCollection(ctx, archive, archivePath)
+	if !ok {
+		archive.close()
+		return Collection{}, false
+	}
+	coll.Zip, coll.Tar = isZip, !isZip
+	return coll, true
+}
+
+// hasObfuscatedManifest reports whether a directory holds a collection whose
+// names have been obfuscated
+func hasObfuscatedManifest(dir string) bool {
+	return dirArchive{dir: dir}.has(ObfuscatedManifestFileName)
+}
+
+// renamedArchive presents the files of an obfuscated collection under their
+// original names
+type renamedArchive struct {
+	archive collectionArchive
+	files   map[string]string // Obfuscated name of each file, by original name
+}
+
+// names returns the original names of the files, in order
+func (a *renamedArchive) names() []string {
+	names := make([]string, 0, len(a.files))
+	for name := range a.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// has reports whether the collection holds a file
+func (a *renamedArchive) has(name string) bool {
+	obfuscated, ok := a.files[name]
+	return ok && a.archive.has(obfuscated)
+}
+
+// open opens a file by its original name
+func (a *renamedArchive) open(name string) (io.ReadCloser, error) {
+	obfuscated, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return a.archive.open(obfuscated)
+}
+
+// readFile reads a file by its original name
+func (a *renamedArchive) readFile(name string) ([]byte, error) {
+	obfuscated, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return a.archive.readFile(obfuscated)
+}
+
+// close closes the underlying archive
+func (a *renamedArchive) close() error {
+	return a.archive.close()
+}
+
+// dirArchive reads the files of a collection directory through the same
+// interface as an archive, so that they can be renamed
+type dirArchive struct {
+	dir string
+}
+
+// names returns the names of the files in the directory, in order
+func (a dirArchive) names() []string {
+	names, _ := Collection{}.fileNames(a.dir)
+	sort.Strings(names)
+	return names
+}
+
+// has reports whether the directory holds a file
+func (a dirArchive) has(name string) bool {
+	info, err := os.Stat(filepath.Join(a.dir, path.Clean("/"+name)))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// open opens a file in the directory
+func (a dirArchive) open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(a.dir, path.Clean("/"+name)))
+}
+
+// readFile reads a file in the directory
+func (a dirArchive) readFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(a.dir, path.Clean("/"+name)))
+}
+
+// close does nothing, as there is nothing to close
+func (a dirArchive) close() error {
+	return nil
+}
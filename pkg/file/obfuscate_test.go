@@ -0,0 +1,185 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestObfuscateCollections(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "obfuscate-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The same collections obfuscated as directories, zips and tar.gz archives,
+	// whose names may happen to look like those of collections (e.g. "3e07b1c95d24")
+	formats := map[string]Format{"dir": FormatPNG, "zip": FormatBin, "tgz": FormatBin}
+	chunks := make(map[string][]byte)
+	for i, name := range []string{"2A3", "2B3"} {
+		pieces := paddecode.PiecesPerCollection(2, 3)
+		data := make([]byte, paddecode.HeaderBytes+pieces*4)
+		data[1], data[2], data[3], data[4] = paddecode.HeaderVersion, byte(i), 2, 3
+		binary.BigEndian.PutUint32(data[5:9], 1)
+		binary.BigEndian.PutUint64(data[9:17], uint64(pieces*4))
+		chunks[name] = data
+	}
+	obfuscated := make(map[string][]Collection)
+	for _, kind := range []string{"dir", "zip", "tgz"} {
+		outputDir := filepath.Join(tempDir, kind)
+		collections, err := CreateCollections(ctx, outputDir, []string{"2A3", "2B3"})
+		if err != nil {
+			t.Fatalf("CreateCollections failed: %v", err)
+		}
+		for _, coll := range collections {
+			w := NewChunkWriter(ctx, GetFormatter(formats[kind]), coll.Path, 0, 1)
+			w.Write(chunks[coll.Name])
+			if err := w.Close(); err != nil {
+				t.Fatalf("Failed to write chunk: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(coll.Path, coll.Name+"_0001_P01.par"), []byte("parity"), 0644); err != nil {
+				t.Fatalf("Failed to write parity: %v", err)
+			}
+		}
+		renamed, err := ObfuscateCollections(ctx, collections)
+		if err != nil {
+			t.Fatalf("ObfuscateCollections failed: %v", err)
+		}
+		obfuscated[kind] = renamed
+		switch kind {
+		case "zip":
+			_, err = ZipCollections(ctx, renamed)
+		case "tgz":
+			_, err = TarCollections(ctx, renamed)
+		}
+		if err != nil {
+			t.Fatalf("Failed to archive collections: %v", err)
+		}
+	}
+
+	t.Run("Names", func(t *testing.T) {
+		innocuous := regexp.MustCompile(`^[0-9a-f]{12}$`)
+		for i, coll := range obfuscated["dir"] {
+			if coll.Name != []string{"2A3", "2B3"}[i] || !innocuous.MatchString(filepath.Base(coll.Path)) {
+				t.Errorf("Expected collection %d to keep its name and be renamed innocuously, got %s at %s", i, coll.Name, coll.Path)
+			}
+			entries, err := os.ReadDir(coll.Path)
+			if err != nil {
+				t.Fatalf("Failed to read collection: %v", err)
+			}
+			for _, entry := range entries {
+				if strings.Contains(entry.Name(), coll.Name) || strings.Contains(strings.ToLower(entry.Name()), "padlock") {
+					t.Errorf("Expected an innocuous file name, got %s", entry.Name())
+				}
+			}
+		}
+		// Nor do the contents of the collections or their archives give away
+		// the collections or the original names of their files
+		leaks := []string{"2A3", "2B3", "IMG"}
+		for _, kind := range []string{"dir", "zip"} {
+			err := filepath.WalkDir(filepath.Join(tempDir, kind), func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				for _, leak := range leaks {
+					if bytes.Contains(data, []byte(leak)) {
+						t.Errorf("Expected %s not to hold %q:\n%s", path, leak, data)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Failed to read collections: %v", err)
+			}
+		}
+		// The key is random, so the same collection is named differently each time
+		if filepath.Base(obfuscated["dir"][0].Path) == filepath.Base(obfuscated["zip"][0].Path) {
+			t.Errorf("Expected the names of separate sets to differ")
+		}
+	})
+
+	for _, kind := range []string{"dir", "zip", "tgz"} {
+		t.Run(fmt.Sprintf("Read %s", kind), func(t *testing.T) {
+			collections, tempPath, err := FindCollections(ctx, filepath.Join(tempDir, kind))
+			if err != nil {
+				t.Fatalf("FindCollections failed: %v", err)
+			}
+			defer CloseCollections(collections)
+			if tempPath != "" || len(collections) != 2 {
+				t.Fatalf("Expected 2 collections read in place, got %+v (%s)", collections, tempPath)
+			}
+			for _, coll := range collections {
+				if coll.Format != formats[kind] {
+					t.Errorf("Expected %s format for %s, got %s", formats[kind], coll.Name, coll.Format)
+				}
+				numbers, err := ChunkNumbers(coll)
+				if err != nil || fmt.Sprint(numbers) != "[1]" {
+					t.Errorf("Expected chunk [1] in %s, got %v (%v)", coll.Name, numbers, err)
+				}
+				data, err := ReadChunk(ctx, coll, 1)
+				if err != nil || !bytes.Equal(data, chunks[coll.Name]) {
+					t.Errorf("Expected to read the chunk of %s, got %q (%v)", coll.Name, data, err)
+				}
+				r, err := coll.openFile(coll.Name + "_0001_P01.par")
+				if err != nil {
+					t.Fatalf("Expected to read the parity of %s: %v", coll.Name, err)
+				}
+				parity, err := io.ReadAll(r)
+				r.Close()
+				if err != nil || string(parity) != "parity" {
+					t.Errorf("Expected to read the parity of %s, got %q (%v)", coll.Name, parity, err)
+				}
+			}
+		})
+	}
+
+	t.Run("Manifest outside the collection", func(t *testing.T) {
+		collPath := obfuscated["dir"][0].Path
+		m := Manifest{Chunks: map[string]int{"../../escaped.png": 1}}
+		if err := writeManifest(ctx, filepath.Join(collPath, ObfuscatedManifestFileName), m); err != nil {
+			t.Fatalf("writeManifest failed: %v", err)
+		}
+		os.WriteFile(filepath.Join(tempDir, "escaped.png"), chunks["2A3"], 0644)
+		if coll, ok := openObfuscatedCollection(ctx, dirArchive{dir: collPath}, collPath); ok {
+			t.Errorf("Expected a chunk outside the collection not to identify it, got %s", coll.Name)
+		}
+	})
+
+	t.Run("Manifest outside the collection, naming it", func(t *testing.T) {
+		// Manifests written before the collection name was left out, which map
+		// each file to its original name, are no more read outside it
+		collPath := obfuscated["dir"][0].Path
+		m := Manifest{Collection: "2A3", Files: map[string]string{"../../escaped.png": "IMG2A3_0001.PNG"}}
+		if err := writeManifest(ctx, filepath.Join(collPath, ObfuscatedManifestFileName), m); err != nil {
+			t.Fatalf("writeManifest failed: %v", err)
+		}
+		os.WriteFile(filepath.Join(tempDir, "escaped.png"), []byte("outside"), 0644)
+		coll, ok := openObfuscatedCollection(ctx, dirArchive{dir: collPath}, collPath)
+		if !ok {
+			t.Fatalf("Expected the collection to be opened")
+		}
+		if data, err := ReadChunk(ctx, coll, 1); err == nil {
+			t.Errorf("Expected a file outside the collection not to be read, got %q", data)
+		}
+	})
+}
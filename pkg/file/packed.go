@@ -0,0 +1,537 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// PackedSuffix is the extension of the file a packed collection is kept in
+// (e.g. "3A5.padlock")
+const PackedSuffix = ".padlock"
+
+// The layout of a packed collection file. The file starts with a header, after
+// which each file of the collection is appended as a frame holding its name,
+// mode and contents with a CRC-32 of them. Once every file has been written,
+// an index of the frames is appended, followed by a fixed-size trailer giving
+// its position, so that a reader can seek straight to any file. A file whose
+// writing was interrupted has no index, and is read by walking its frames
+// instead, as far as they are intact.
+//
+//	header:  "PADLOCKP" version(4)
+//	frame:   "PLFR" nameLen(2) mode(4) dataLen(8) name data crc(4)
+//	index:   { nameLen(2) mode(4) offset(8) dataLen(8) crc(4) name }...
+//	trailer: indexOffset(8) indexLen(8) indexCRC(4) "PLIX"
+//
+// Integers are big-endian, and CRCs are of the name and contents of a file.
+const (
+	packedVersion       = 1
+	packedHeaderSize    = 12
+	packedFrameHeader   = 18
+	packedTrailerSize   = 24
+	packedIndexEntryMin = 26
+)
+
+var (
+	packedMagic        = []byte("PADLOCKP")
+	packedFrameMagic   = []byte("PLFR")
+	packedTrailerMagic = []byte("PLIX")
+)
+
+// packedEntry locates one file in a packed collection
+type packedEntry struct {
+	name   string
+	mode   fs.FileMode
+	offset int64 // Offset of the file's frame
+	size   int64 // Size of the file's contents
+	crc    uint32
+}
+
+// dataOffset returns the offset of the file's contents within the packed file
+func (e packedEntry) dataOffset() int64 {
+	return e.offset + packedFrameHeader + int64(len(e.name))
+}
+
+// PackedWriter writes one collection into a single packed file as its chunks
+// are encoded, so that a collection of thousands of chunks is one file rather
+// than thousands, which is far faster to write and copy on most filesystems.
+// Frames are appended through a buffer, and nothing already written is
+// rewritten, so an interrupted write leaves every frame before it readable.
+type PackedWriter struct {
+	ctx        context.Context
+	path       string
+	collection string
+	f          *os.File
+	w          *bufio.Writer
+	offset     int64
+	entries    []packedEntry
+	err        error
+	finished   bool
+}
+
+// packedBufferSize is the size of the buffer frames are written through
+const packedBufferSize = 1 << 20
+
+// NewPackedWriter creates the packed file for a collection in outputDir, named
+// after the collection (e.g. "3A5.padlock")
+func NewPackedWriter(ctx context.Context, outputDir string, collectionName string) (*PackedWriter, error) {
+	log := trace.FromContext(ctx).WithPrefix("PACKED")
+
+	packedPath := filepath.Join(outputDir, collectionName+PackedSuffix)
+	f, err := os.Create(packedPath)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create packed file %s: %w", packedPath, err))
+		return nil, fmt.Errorf("failed to create packed file %s: %w", packedPath, err)
+	}
+	w := &PackedWriter{
+		ctx:        ctx,
+		path:       packedPath,
+		collection: collectionName,
+		f:          f,
+		w:          bufio.NewWriterSize(f, packedBufferSize),
+	}
+	header := make([]byte, packedHeaderSize)
+	copy(header, packedMagic)
+	binary.BigEndian.PutUint32(header[8:], packedVersion)
+	if err := w.write(header); err != nil {
+		f.Close()
+		os.Remove(packedPath)
+		log.Error(err)
+		return nil, err
+	}
+	log.Debugf("Streaming collection %s into %s", collectionName, packedPath)
+	return w, nil
+}
+
+// Collection returns the collection being written, read in place from its
+// packed file once Finish has been called
+func (w *PackedWriter) Collection() Collection {
+	return Collection{Name: w.collection, Path: w.path, Format: FormatPacked}
+}
+
+// NewChunkWriter returns a writer for the given chunk, which is appended to
+// the packed file when the writer is closed
+func (w *PackedWriter) NewChunkWriter(chunkNumber int) io.WriteCloser {
+	return &packedChunkWriter{w: w, chunkNumber: chunkNumber}
+}
+
+// AddFile adds a file other than a chunk, such as a recovery README, to the packed file
+func (w *PackedWriter) AddFile(name string, data []byte) error {
+	return w.AddFileMode(name, data, 0644)
+}
+
+// AddFileMode adds a file other than a chunk with the given mode, such as an
+// executable decoder, to the packed file
+func (w *PackedWriter) AddFileMode(name string, data []byte, mode fs.FileMode) error {
+	return w.add(name, bytes.NewReader(data), int64(len(data)), mode)
+}
+
+// add appends one file to the packed file as a frame
+func (w *PackedWriter) add(name string, r io.Reader, size int64, mode fs.FileMode) error {
+	log := trace.FromContext(w.ctx).WithPrefix("PACKED")
+
+	if w.err != nil {
+		return w.err
+	}
+	if w.finished {
+		return fmt.Errorf("packed file %s is already finished", w.path)
+	}
+	if len(name) == 0 || len(name) > 0xffff {
+		w.err = fmt.Errorf("invalid name %q for a file in packed file %s", name, w.path)
+		log.Error(w.err)
+		return w.err
+	}
+	entry := packedEntry{name: name, mode: mode.Perm(), offset: w.offset, size: size}
+	header := make([]byte, packedFrameHeader)
+	copy(header, packedFrameMagic)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(name)))
+	binary.BigEndian.PutUint32(header[6:], uint32(entry.mode))
+	binary.BigEndian.PutUint64(header[10:], uint64(size))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(name))
+	err := w.write(append(header, name...))
+	if err == nil {
+		var n int64
+		n, err = io.Copy(io.MultiWriter(w.w, crc), r)
+		w.offset += n
+		if err == nil && n != size {
+			err = fmt.Errorf("wrote %d bytes, expected %d", n, size)
+		}
+	}
+	if err == nil {
+		entry.crc = crc.Sum32()
+		err = w.write(binary.BigEndian.AppendUint32(nil, entry.crc))
+	}
+	if err != nil {
+		w.err = fmt.Errorf("failed to add %s to packed file %s: %w", name, w.path, err)
+		log.Error(w.err)
+		return w.err
+	}
+	w.entries = append(w.entries, entry)
+	log.Debugf("Added %s (%d bytes) to %s", name, size, w.path)
+	return nil
+}
+
+// write writes bytes to the packed file, counting them
+func (w *PackedWriter) write(p []byte) error {
+	n, err := w.w.Write(p)
+	w.offset += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write packed file %s: %w", w.path, err)
+	}
+	return nil
+}
+
+// Finish appends the index and trailer of the packed file, syncs it and closes
+// it, returning its path. Calling it again, as when cleaning up after a failed
+// encode, does nothing.
+func (w *PackedWriter) Finish() (string, error) {
+	log := trace.FromContext(w.ctx).WithPrefix("PACKED")
+
+	if w.finished {
+		return w.path, w.err
+	}
+	w.finished = true
+	if w.err == nil {
+		var index []byte
+		for _, e := range w.entries {
+			index = binary.BigEndian.AppendUint16(index, uint16(len(e.name)))
+			index = binary.BigEndian.AppendUint32(index, uint32(e.mode))
+			index = binary.BigEndian.AppendUint64(index, uint64(e.offset))
+			index = binary.BigEndian.AppendUint64(index, uint64(e.size))
+			index = binary.BigEndian.AppendUint32(index, e.crc)
+			index = append(index, e.name...)
+		}
+		trailer := binary.BigEndian.AppendUint64(nil, uint64(w.offset))
+		trailer = binary.BigEndian.AppendUint64(trailer, uint64(len(index)))
+		trailer = binary.BigEndian.AppendUint32(trailer, crc32.ChecksumIEEE(index))
+		trailer = append(trailer, packedTrailerMagic...)
+		if err := w.write(append(index, trailer...)); err != nil {
+			w.err = err
+		}
+	}
+	if w.err == nil {
+		if err := w.w.Flush(); err != nil {
+			w.err = fmt.Errorf("failed to write packed file %s: %w", w.path, err)
+		}
+	}
+	if w.err == nil {
+		if err := w.f.Sync(); err != nil {
+			w.err = fmt.Errorf("failed to sync packed file %s: %w", w.path, err)
+		}
+	}
+	if err := w.f.Close(); err != nil && w.err == nil {
+		w.err = fmt.Errorf("failed to close packed file %s: %w", w.path, err)
+	}
+	if w.err != nil {
+		log.Error(w.err)
+		return "", w.err
+	}
+	log.Debugf("Successfully created packed file with %d files: %s", len(w.entries), w.path)
+	return w.path, nil
+}
+
+// packedChunkWriter buffers one chunk, appending it to the packed file when closed
+type packedChunkWriter struct {
+	w           *PackedWriter
+	chunkNumber int
+	buf         bytes.Buffer
+	closed      bool
+}
+
+// Write buffers chunk data
+func (cw *packedChunkWriter) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, fmt.Errorf("write to closed chunk writer")
+	}
+	return cw.buf.Write(p)
+}
+
+// Close appends the chunk to the packed file
+func (cw *packedChunkWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	name := DefaultChunkNaming(FormatPacked).FileName(cw.w.collection, cw.chunkNumber)
+	return cw.w.add(name, &cw.buf, int64(cw.buf.Len()), 0644)
+}
+
+// PackCollection packs a collection directory into a single file named after
+// it (e.g. "3A5.padlock"), holding each of its files with chunks in order
+func PackCollection(ctx context.Context, collPath string) (string, error) {
+	log := trace.FromContext(ctx).WithPrefix("PACKED")
+
+	collName := filepath.Base(collPath)
+	w, err := NewPackedWriter(ctx, filepath.Dir(collPath), collName)
+	if err != nil {
+		return "", err
+	}
+	err = filepath.WalkDir(collPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(collPath, p)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", p, err)
+		}
+		defer src.Close()
+		return w.add(archiveEntryName(rel), src, info.Size(), info.Mode())
+	})
+	if _, finishErr := w.Finish(); err == nil {
+		err = finishErr
+	}
+	if err != nil {
+		os.Remove(w.path)
+		log.Error(fmt.Errorf("error packing collection %s: %w", collName, err))
+		return "", fmt.Errorf("error packing collection %s: %w", collName, err)
+	}
+	return w.path, nil
+}
+
+// PackCollections packs each collection directory into a single file
+func PackCollections(ctx context.Context, collections []Collection) ([]string, error) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
+
+	log.Infof("Packing %d collections", len(collections))
+	packedPaths := make([]string, len(collections))
+
+	for i, coll := range collections {
+		packedPath, err := PackCollection(ctx, coll.Path)
+		if err != nil {
+			log.Error(fmt.Errorf("failed to pack collection %s: %w", coll.Name, err))
+			return nil, err
+		}
+
+		// Remove the original directory
+		if err := CleanupCollectionDirectory(ctx, coll.Path); err != nil {
+			log.Error(fmt.Errorf("failed to remove original collection directory after packing: %w", err))
+			return nil, err
+		}
+
+		packedPaths[i] = packedPath
+		log.Infof("Created packed file for collection %s: %s", coll.Name, packedPath)
+	}
+
+	return packedPaths, nil
+}
+
+// packedArchive is a packed collection file opened so that its files can be
+// read in place, each directly at its offset
+type packedArchive struct {
+	path      string
+	f         *os.File
+	entries   map[string]packedEntry
+	recovered bool // The index was missing or damaged, so the frames were walked instead
+}
+
+// openPackedArchive opens a packed collection file and reads its index, or
+// if it has none, as when its writing was interrupted, walks its frames
+func openPackedArchive(packedPath string) (*packedArchive, error) {
+	f, err := os.Open(packedPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open packed file %s: %w", packedPath, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open packed file %s: %w", packedPath, err)
+	}
+	header := make([]byte, packedHeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil || !bytes.Equal(header[:8], packedMagic) {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a packed collection file", packedPath)
+	}
+	if version := binary.BigEndian.Uint32(header[8:]); version != packedVersion {
+		f.Close()
+		return nil, fmt.Errorf("packed file %s has unsupported version %d", packedPath, version)
+	}
+
+	a := &packedArchive{path: packedPath, f: f}
+	if a.entries, err = readPackedIndex(f, info.Size()); err != nil {
+		a.entries, a.recovered = walkPackedFrames(f, info.Size()), true
+	}
+	return a, nil
+}
+
+// readPackedIndex reads the index of a packed file from the position its trailer gives
+func readPackedIndex(f io.ReaderAt, size int64) (map[string]packedEntry, error) {
+	if size < packedHeaderSize+packedTrailerSize {
+		return nil, fmt.Errorf("no index")
+	}
+	trailer := make([]byte, packedTrailerSize)
+	if _, err := f.ReadAt(trailer, size-packedTrailerSize); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(trailer[20:], packedTrailerMagic) {
+		return nil, fmt.Errorf("no index")
+	}
+	indexOffset, indexLen := int64(binary.BigEndian.Uint64(trailer)), int64(binary.BigEndian.Uint64(trailer[8:]))
+	if indexOffset < packedHeaderSize || indexLen < 0 || indexOffset+indexLen != size-packedTrailerSize {
+		return nil, fmt.Errorf("invalid index position")
+	}
+	index := make([]byte, indexLen)
+	if _, err := f.ReadAt(index, indexOffset); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(index) != binary.BigEndian.Uint32(trailer[16:]) {
+		return nil, fmt.Errorf("index checksum mismatch")
+	}
+
+	entries := make(map[string]packedEntry)
+	for len(index) > 0 {
+		if len(index) < packedIndexEntryMin {
+			return nil, fmt.Errorf("truncated index")
+		}
+		nameLen := int(binary.BigEndian.Uint16(index))
+		e := packedEntry{
+			mode:   fs.FileMode(binary.BigEndian.Uint32(index[2:])).Perm(),
+			offset: int64(binary.BigEndian.Uint64(index[6:])),
+			size:   int64(binary.BigEndian.Uint64(index[14:])),
+			crc:    binary.BigEndian.Uint32(index[22:]),
+		}
+		if len(index) < packedIndexEntryMin+nameLen {
+			return nil, fmt.Errorf("truncated index")
+		}
+		e.name = string(index[packedIndexEntryMin : packedIndexEntryMin+nameLen])
+		index = index[packedIndexEntryMin+nameLen:]
+		if e.offset < packedHeaderSize || e.size < 0 || e.dataOffset()+e.size+4 > indexOffset {
+			return nil, fmt.Errorf("index entry %s lies outside the file", e.name)
+		}
+		entries[e.name] = e
+	}
+	return entries, nil
+}
+
+// walkPackedFrames finds the files of a packed file without an index by
+// reading its frames in order, stopping at the first that is incomplete. A
+// file appended more than once is taken from its last frame.
+func walkPackedFrames(f io.ReaderAt, size int64) map[string]packedEntry {
+	entries := make(map[string]packedEntry)
+	header := make([]byte, packedFrameHeader)
+	for offset := int64(packedHeaderSize); offset+packedFrameHeader <= size; {
+		if _, err := f.ReadAt(header, offset); err != nil || !bytes.Equal(header[:4], packedFrameMagic) {
+			break
+		}
+		nameLen := int64(binary.BigEndian.Uint16(header[4:]))
+		e := packedEntry{
+			mode:   fs.FileMode(binary.BigEndian.Uint32(header[6:])).Perm(),
+			offset: offset,
+			size:   int64(binary.BigEndian.Uint64(header[10:])),
+		}
+		end := offset + packedFrameHeader + nameLen + e.size + 4
+		if e.size < 0 || end > size || end < offset {
+			break
+		}
+		name := make([]byte, nameLen)
+		crc := make([]byte, 4)
+		if _, err := f.ReadAt(name, offset+packedFrameHeader); err != nil {
+			break
+		}
+		if _, err := f.ReadAt(crc, end-4); err != nil {
+			break
+		}
+		e.name, e.crc = string(name), binary.BigEndian.Uint32(crc)
+		entries[e.name] = e
+		offset = end
+	}
+	return entries
+}
+
+// names returns the names of the files in the packed file, in order
+func (a *packedArchive) names() []string {
+	names := make([]string, 0, len(a.entries))
+	for name := range a.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// has reports whether the packed file holds a file
+func (a *packedArchive) has(name string) bool {
+	_, ok := a.entries[name]
+	return ok
+}
+
+// open opens a file in the packed file, failing with fs.ErrNotExist if it
+// isn't there. The file is read directly from the packed file, so its
+// checksum is not verified, as it is when it is read whole by readFile.
+func (a *packedArchive) open(name string) (io.ReadCloser, error) {
+	e, ok := a.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%s in %s: %w", name, a.path, fs.ErrNotExist)
+	}
+	return io.NopCloser(io.NewSectionReader(a.f, e.dataOffset(), e.size)), nil
+}
+
+// readFile reads a file in the packed file, verifying its checksum
+func (a *packedArchive) readFile(name string) ([]byte, error) {
+	e, ok := a.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%s in %s: %w", name, a.path, fs.ErrNotExist)
+	}
+	data := make([]byte, e.size)
+	if _, err := a.f.ReadAt(data, e.dataOffset()); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("failed to read %s in %s: %w", name, a.path, err)
+	}
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(e.name))
+	crc.Write(data)
+	if crc.Sum32() != e.crc {
+		return nil, fmt.Errorf("failed to read %s in %s: checksum mismatch", name, a.path)
+	}
+	return data, nil
+}
+
+// close closes the packed file
+func (a *packedArchive) close() error {
+	return a.f.Close()
+}
+
+// openPackedCollection opens a packed collection file (e.g. "3A5.padlock") to
+// be read in place
+func openPackedCollection(ctx context.Context, packedPath string) (Collection, bool) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
+
+	collName := strings.TrimSuffix(filepath.Base(packedPath), PackedSuffix)
+	if !isCollectionName(collName) {
+		return Collection{}, false
+	}
+	archive, err := openPackedArchive(packedPath)
+	if err != nil {
+		log.Debugf("Not reading %s: %v", packedPath, err)
+		return Collection{}, false
+	}
+	if archive.recovered {
+		log.Infof("Warning: %s has no intact index, perhaps as its writing was interrupted; read %d files from its frames", packedPath, len(archive.entries))
+	}
+	if _, naming, err := detectFormat(archive, collName); err != nil || naming != (ChunkNaming{}) {
+		archive.close()
+		return Collection{}, false
+	}
+	return Collection{Name: collName, Path: packedPath, Format: FormatPacked, archive: archive}, true
+}
@@ -0,0 +1,197 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestPackedCollection(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+
+	// writePacked streams chunks and a file into a packed collection, out of
+	// order, and returns the chunks written
+	writePacked := func(t *testing.T, dir string, finish bool) map[int][]byte {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		w, err := NewPackedWriter(ctx, dir, "3A5")
+		if err != nil {
+			t.Fatalf("NewPackedWriter failed: %v", err)
+		}
+		chunks := make(map[int][]byte)
+		for _, n := range []int{2, 1, 3} {
+			chunks[n] = make([]byte, 20000+n)
+			rand.Read(chunks[n])
+			cw := w.NewChunkWriter(n)
+			if _, err := cw.Write(chunks[n]); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if err := cw.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+		}
+		if err := w.AddFileMode("decoders/padlock-decode", []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatalf("AddFileMode failed: %v", err)
+		}
+		if finish {
+			if path, err := w.Finish(); err != nil || path != filepath.Join(dir, "3A5"+PackedSuffix) {
+				t.Fatalf("Finish returned %s, %v", path, err)
+			}
+		} else {
+			w.w.Flush()
+			w.f.Close()
+		}
+		return chunks
+	}
+	readPacked := func(t *testing.T, dir string, chunks map[int][]byte) {
+		collections, tempDir, err := FindCollections(ctx, dir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer CloseCollections(collections)
+		if tempDir != "" {
+			t.Errorf("Expected the packed collection to be read in place, not extracted")
+		}
+		if len(collections) != 1 || collections[0].Name != "3A5" || collections[0].Format != FormatPacked {
+			t.Fatalf("Expected packed collection 3A5, got %+v", collections)
+		}
+		coll := collections[0]
+		numbers, err := ChunkNumbers(coll)
+		if err != nil || len(numbers) != len(chunks) {
+			t.Fatalf("ChunkNumbers returned %v, %v", numbers, err)
+		}
+		for _, n := range []int{3, 1, 2} {
+			data, err := ReadChunk(ctx, coll, n)
+			if err != nil {
+				t.Fatalf("ReadChunk %d failed: %v", n, err)
+			}
+			if !bytes.Equal(data, chunks[n]) {
+				t.Errorf("Chunk %d differs", n)
+			}
+		}
+		r, err := coll.OpenFile("decoders/padlock-decode")
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		defer r.Close()
+		if data, _ := io.ReadAll(r); string(data) != "#!/bin/sh\n" {
+			t.Errorf("Unexpected file contents %q", data)
+		}
+	}
+
+	t.Run("Streamed", func(t *testing.T) {
+		dir := t.TempDir()
+		chunks := writePacked(t, dir, true)
+		readPacked(t, dir, chunks)
+	})
+
+	t.Run("Interrupted", func(t *testing.T) {
+		// Without its index, the frames written are found by walking the file,
+		// up to one cut short
+		dir := t.TempDir()
+		chunks := writePacked(t, dir, false)
+		readPacked(t, dir, chunks)
+
+		path := filepath.Join(dir, "3A5"+PackedSuffix)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if err := os.Truncate(path, info.Size()-30); err != nil {
+			t.Fatalf("Truncate failed: %v", err)
+		}
+		archive, err := openPackedArchive(path)
+		if err != nil {
+			t.Fatalf("openPackedArchive failed: %v", err)
+		}
+		defer archive.close()
+		if !archive.recovered || len(archive.entries) != 3 || archive.has("decoders/padlock-decode") {
+			t.Errorf("Expected the three chunks before the truncated frame, got %v", archive.names())
+		}
+	})
+
+	t.Run("Damaged", func(t *testing.T) {
+		dir := t.TempDir()
+		chunks := writePacked(t, dir, true)
+		path := filepath.Join(dir, "3A5"+PackedSuffix)
+		archive, err := openPackedArchive(path)
+		if err != nil {
+			t.Fatalf("openPackedArchive failed: %v", err)
+		}
+		entry := archive.entries[ChunkFileName(FormatBin, "3A5", 2)]
+		archive.close()
+
+		// A flipped byte in a chunk fails its checksum, leaving the others readable
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read packed file: %v", err)
+		}
+		data[entry.dataOffset()+100] ^= 0xff
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write packed file: %v", err)
+		}
+		collections, _, err := FindCollections(ctx, dir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer CloseCollections(collections)
+		if _, err := ReadChunk(ctx, collections[0], 2); !errors.Is(err, pad.ErrChunkCorrupt) {
+			t.Errorf("Expected ErrChunkCorrupt, got %v", err)
+		}
+		if got, err := ReadChunk(ctx, collections[0], 3); err != nil || !bytes.Equal(got, chunks[3]) {
+			t.Errorf("Expected chunk 3 to be intact, got %v", err)
+		}
+	})
+
+	t.Run("Pack directory", func(t *testing.T) {
+		dir := t.TempDir()
+		collPath, err := CreateCollectionDirectory(ctx, dir, "3A5")
+		if err != nil {
+			t.Fatalf("CreateCollectionDirectory failed: %v", err)
+		}
+		chunks := make(map[int][]byte)
+		for n := 1; n <= 3; n++ {
+			chunks[n] = make([]byte, 5000)
+			rand.Read(chunks[n])
+			if err := os.WriteFile(filepath.Join(collPath, ChunkFileName(FormatBin, "3A5", n)), chunks[n], 0644); err != nil {
+				t.Fatalf("Failed to write chunk: %v", err)
+			}
+		}
+		if err := os.MkdirAll(filepath.Join(collPath, "decoders"), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(collPath, "decoders", "padlock-decode"), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		paths, err := PackCollections(ctx, []Collection{{Name: "3A5", Path: collPath}})
+		if err != nil {
+			t.Fatalf("PackCollections failed: %v", err)
+		}
+		if len(paths) != 1 || paths[0] != collPath+PackedSuffix {
+			t.Fatalf("Expected 3A5.padlock, got %v", paths)
+		}
+		if _, err := os.Stat(collPath); !os.IsNotExist(err) {
+			t.Errorf("Expected the collection directory to be removed")
+		}
+		readPacked(t, dir, chunks)
+	})
+
+	t.Run("Not packed", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "3A5"+PackedSuffix), []byte("not a packed file"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if _, _, err := FindCollections(ctx, dir); !errors.Is(err, ErrNoCollections) {
+			t.Errorf("Expected ErrNoCollections, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// PadsManifestFileName is the name of the manifest file stored alongside the
+// pad collections generated for an air-gapped encode
+const PadsManifestFileName = "padlock-pads.json"
+
+// PadsManifest describes a set of pad collections, generated on one machine to
+// be applied to the input of an encode on another. Pads must be used only once,
+// so the manifest records when they were.
+type PadsManifest struct {
+	Version   int        `json:"version"`        // Manifest format version
+	Required  int        `json:"required"`       // Collections required to decode the encode (K)
+	Copies    int        `json:"copies"`         // Collections of pads, and of the encode (N)
+	ChunkSize int        `json:"chunkSize"`      // Size of each chunk of each collection, in bytes
+	Chunks    int        `json:"chunks"`         // Chunks of pads in each collection
+	Capacity  int64      `json:"capacity"`       // Bytes of serialized input the pads can encode
+	Created   time.Time  `json:"created"`        // When the pads were generated
+	Used      *time.Time `json:"used,omitempty"` // When the pads were applied, after which they must not be again
+}
+
+// WritePadsManifest writes the manifest of a set of pads into the directory
+// holding their collections
+func WritePadsManifest(ctx context.Context, padsDir string, m PadsManifest) error {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	m.Version = ManifestVersion
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Error(fmt.Errorf("failed to encode pads manifest: %w", err))
+		return fmt.Errorf("failed to encode pads manifest: %w", err)
+	}
+
+	path := filepath.Join(padsDir, PadsManifestFileName)
+	log.Debugf("Writing pads manifest: %s", path)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		log.Error(fmt.Errorf("failed to write pads manifest %s: %w", path, err))
+		return fmt.Errorf("failed to write pads manifest %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadPadsManifest reads the manifest of a set of pads. Unlike other
+// manifests, it is required: a directory without one does not hold pads.
+func ReadPadsManifest(ctx context.Context, padsDir string) (*PadsManifest, error) {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	path := filepath.Join(padsDir, PadsManifestFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read pads manifest %s: %w", path, err))
+		return nil, fmt.Errorf("failed to read pads manifest %s: %w", path, err)
+	}
+
+	var m PadsManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Error(fmt.Errorf("invalid pads manifest %s: %w", path, err))
+		return nil, fmt.Errorf("invalid pads manifest %s: %w", path, err)
+	}
+	if m.Version > ManifestVersion {
+		log.Error(fmt.Errorf("pads manifest %s has unsupported version %d", path, m.Version))
+		return nil, fmt.Errorf("pads manifest %s has unsupported version %d", path, m.Version)
+	}
+	return &m, nil
+}
@@ -0,0 +1,50 @@
+package file
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestPadsManifest(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "pads-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A directory without a manifest does not hold pads
+	if _, err := ReadPadsManifest(ctx, tempDir); err == nil {
+		t.Errorf("Expected an error reading a missing pads manifest")
+	}
+
+	m := PadsManifest{Required: 2, Copies: 3, ChunkSize: 1024, Chunks: 4, Capacity: 1364, Created: time.Now().UTC()}
+	if err := WritePadsManifest(ctx, tempDir, m); err != nil {
+		t.Fatalf("WritePadsManifest failed: %v", err)
+	}
+	got, err := ReadPadsManifest(ctx, tempDir)
+	if err != nil {
+		t.Fatalf("ReadPadsManifest failed: %v", err)
+	}
+	if got.Version != ManifestVersion || got.Required != 2 || got.Copies != 3 || got.Chunks != 4 || got.Capacity != 1364 || got.Used != nil {
+		t.Errorf("Unexpected pads manifest: %+v", got)
+	}
+
+	// Recording the use of the pads survives a round trip
+	used := time.Now().UTC()
+	got.Used = &used
+	if err := WritePadsManifest(ctx, tempDir, *got); err != nil {
+		t.Fatalf("WritePadsManifest failed: %v", err)
+	}
+	got, err = ReadPadsManifest(ctx, tempDir)
+	if err != nil || got.Used == nil || !got.Used.Equal(used) {
+		t.Errorf("Use of the pads was not recorded: %+v (%v)", got, err)
+	}
+}
@@ -0,0 +1,354 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// paritySuffix is the extension of the parity files stored alongside the chunks
+// of a collection. It differs from that of chunk files, so that parity files are
+// never mistaken for chunks.
+const paritySuffix = ".par"
+
+// parityStripeChunks is the largest number of chunks protected together by one
+// set of parity files
+const parityStripeChunks = 64
+
+// parityHeader is the first line of a parity file. Every parity file of a stripe
+// records the size and checksum of each chunk of the stripe, so that any one of
+// them is enough to tell which chunks are damaged.
+type parityHeader struct {
+	Collection string   `json:"collection"` // Collection name (e.g., "3A5")
+	FirstChunk int      `json:"firstChunk"` // First chunk number of the stripe
+	Sizes      []int    `json:"sizes"`      // Size of each chunk of the stripe
+	Checksums  []string `json:"checksums"`  // SHA-256 of each chunk of the stripe
+	Parity     int      `json:"parity"`     // Number of parity files of the stripe
+	Index      int      `json:"index"`      // 0-based index of this parity file within the stripe
+	Checksum   string   `json:"checksum"`   // SHA-256 of the parity data following the header
+}
+
+// ParityFileName returns the name of a parity file of the stripe starting at firstChunk
+func ParityFileName(collectionName string, firstChunk int, index int) string {
+	return fmt.Sprintf("%s_%04d_P%02d%s", collectionName, firstChunk, index+1, paritySuffix)
+}
+
+// ParityFiles returns the number of parity files that WriteParity adds to a
+// collection of the given number of chunks
+func ParityFiles(chunks int64, percent int) int64 {
+	files := int64(0)
+	for start := int64(0); start < chunks; start += parityStripeChunks {
+		stripe := min(chunks-start, parityStripeChunks)
+		files += (stripe*int64(percent) + 99) / 100
+	}
+	return files
+}
+
+// WriteParity adds Reed-Solomon parity files to a collection directory, so that
+// chunks lost to a scratched disc or a partially corrupted zip can be rebuilt
+// when the collection is read. Chunks are protected in stripes of up to
+// parityStripeChunks, each with percent of its chunk count in parity files,
+// rounded up: with 10 percent, any 7 chunks of a 64-chunk stripe can be lost.
+func WriteParity(ctx context.Context, coll Collection, percent int) error {
+	log := trace.FromContext(ctx).WithPrefix("PARITY")
+
+	if percent < 1 || percent > 100 {
+		log.Error(fmt.Errorf("parity must be between 1 and 100 percent, got %d", percent))
+		return fmt.Errorf("parity must be between 1 and 100 percent, got %d", percent)
+	}
+	numbers, err := ChunkNumbers(coll)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	written := 0
+	for start := 0; start < len(numbers); start += parityStripeChunks {
+		stripe := numbers[start:min(start+parityStripeChunks, len(numbers))]
+		header := parityHeader{
+			Collection: coll.Name,
+			FirstChunk: stripe[0],
+			Parity:     (len(stripe)*percent + 99) / 100,
+		}
+
+		// Read the chunks of the stripe, padding them to the size of the largest
+		var chunks [][]byte
+		shardSize := 0
+		for i, n := range stripe {
+			if n != header.FirstChunk+i {
+				log.Error(fmt.Errorf("collection %s is missing chunk %d", coll.Name, header.FirstChunk+i))
+				return fmt.Errorf("collection %s is missing chunk %d", coll.Name, header.FirstChunk+i)
+			}
+			data, err := ReadChunk(ctx, coll, n)
+			if err != nil {
+				log.Error(fmt.Errorf("failed to read chunk %d of collection %s: %w", n, coll.Name, err))
+				return fmt.Errorf("failed to read chunk %d of collection %s: %w", n, coll.Name, err)
+			}
+			sum := sha256.Sum256(data)
+			header.Sizes = append(header.Sizes, len(data))
+			header.Checksums = append(header.Checksums, hex.EncodeToString(sum[:]))
+			chunks = append(chunks, data)
+			shardSize = max(shardSize, len(data))
+		}
+		for i := range chunks {
+			chunks[i] = append(chunks[i], make([]byte, shardSize-len(chunks[i]))...)
+		}
+
+		rs, err := newReedSolomon(len(chunks), header.Parity)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		parity, err := rs.encode(chunks)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		for i, shard := range parity {
+			header.Index = i
+			sum := sha256.Sum256(shard)
+			header.Checksum = hex.EncodeToString(sum[:])
+			line, err := json.Marshal(header)
+			if err != nil {
+				log.Error(fmt.Errorf("failed to encode parity header: %w", err))
+				return fmt.Errorf("failed to encode parity header: %w", err)
+			}
+			path := filepath.Join(coll.Path, ParityFileName(coll.Name, header.FirstChunk, i))
+			if err := os.WriteFile(path, append(append(line, '\n'), shard...), 0644); err != nil {
+				log.Error(fmt.Errorf("failed to write parity file: %w", err))
+				return fmt.Errorf("failed to write parity file: %w", err)
+			}
+			written++
+		}
+	}
+
+	log.Debugf("Collection %s: wrote %d parity files for %d chunks", coll.Name, written, len(numbers))
+	return nil
+}
+
+// parityStripe is one stripe of a collection's parity, as described by the
+// first readable header among its parity files
+type parityStripe struct {
+	header parityHeader
+	files  []string // Names of the stripe's parity files within the collection
+}
+
+// collectionParity reads the chunks of a collection directory or zip that has
+// parity files, verifying each chunk against its recorded checksum and
+// rebuilding those that are missing or damaged
+type collectionParity struct {
+	coll    Collection
+	stripes []*parityStripe
+	rebuilt map[int][]byte
+}
+
+// HasParity reports whether a collection has parity files
+func HasParity(coll Collection) bool {
+	if len(coll.Volumes) > 0 {
+		return false
+	}
+	names, err := coll.fileNames(coll.Path)
+	if err != nil {
+		return false
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, coll.Name+"_") && strings.HasSuffix(name, paritySuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadParity reads the parity headers of a collection, returning nil if the
+// collection has no parity files. Multi-volume collections never do.
+func loadParity(ctx context.Context, coll Collection) (*collectionParity, error) {
+	log := trace.FromContext(ctx).WithPrefix("PARITY")
+
+	if len(coll.Volumes) > 0 {
+		return nil, nil
+	}
+	names, err := coll.fileNames(coll.Path)
+	if err != nil {
+		return nil, err
+	}
+	stripes := make(map[int]*parityStripe)
+	for _, name := range names {
+		if !strings.HasPrefix(name, coll.Name+"_") || !strings.HasSuffix(name, paritySuffix) {
+			continue
+		}
+		header, _, err := readParityFile(coll, name, false)
+		if err != nil || header.Collection != coll.Name {
+			log.Infof("Warning: ignoring unreadable parity file %s", filepath.Join(coll.Path, name))
+			continue
+		}
+		stripe, ok := stripes[header.FirstChunk]
+		if !ok {
+			stripe = &parityStripe{header: header}
+			stripes[header.FirstChunk] = stripe
+		}
+		stripe.files = append(stripe.files, name)
+	}
+	if len(stripes) == 0 {
+		return nil, nil
+	}
+
+	cp := &collectionParity{coll: coll, rebuilt: make(map[int][]byte)}
+	for _, stripe := range stripes {
+		cp.stripes = append(cp.stripes, stripe)
+	}
+	sort.Slice(cp.stripes, func(i, j int) bool {
+		return cp.stripes[i].header.FirstChunk < cp.stripes[j].header.FirstChunk
+	})
+	return cp, nil
+}
+
+// readParityFile reads the header of a parity file of a collection and, if
+// requested, the parity data following it, verified against the header's checksum
+func readParityFile(coll Collection, name string, withData bool) (parityHeader, []byte, error) {
+	var header parityHeader
+	f, err := coll.openFile(name)
+	if err != nil {
+		return header, nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return header, nil, fmt.Errorf("parity file has no header: %w", err)
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return header, nil, fmt.Errorf("invalid parity header: %w", err)
+	}
+	if len(header.Sizes) == 0 || len(header.Sizes) != len(header.Checksums) || header.Index < 0 || header.Index >= header.Parity {
+		return header, nil, fmt.Errorf("invalid parity header")
+	}
+	if !withData {
+		return header, nil, nil
+	}
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(r); err != nil {
+		return header, nil, err
+	}
+	sum := sha256.Sum256(data.Bytes())
+	if hex.EncodeToString(sum[:]) != header.Checksum {
+		return header, nil, fmt.Errorf("parity file is damaged")
+	}
+	return header, data.Bytes(), nil
+}
+
+// lastChunk returns the highest chunk number protected by the parity
+func (cp *collectionParity) lastChunk() int {
+	last := cp.stripes[len(cp.stripes)-1].header
+	return last.FirstChunk + len(last.Sizes) - 1
+}
+
+// stripeOf returns the stripe protecting a chunk, or nil
+func (cp *collectionParity) stripeOf(chunkNumber int) *parityStripe {
+	for _, stripe := range cp.stripes {
+		if chunkNumber >= stripe.header.FirstChunk && chunkNumber < stripe.header.FirstChunk+len(stripe.header.Sizes) {
+			return stripe
+		}
+	}
+	return nil
+}
+
+// readChunk reads a chunk, rebuilding it from the parity of its stripe if it
+// is missing or does not match its checksum
+func (cp *collectionParity) readChunk(ctx context.Context, chunkNumber int) ([]byte, error) {
+	data, err := ReadChunk(ctx, cp.coll, chunkNumber)
+	stripe := cp.stripeOf(chunkNumber)
+	if stripe == nil {
+		return data, err
+	}
+	if err == nil && chunkIntact(stripe.header, chunkNumber, data) {
+		return data, nil
+	}
+	if data, ok := cp.rebuilt[chunkNumber]; ok {
+		return data, nil
+	}
+	if err := cp.rebuild(ctx, stripe); err != nil {
+		return nil, fmt.Errorf("%w: chunk %d of collection %s is damaged and cannot be rebuilt: %w", pad.ErrChunkCorrupt, chunkNumber, cp.coll.Name, err)
+	}
+	return cp.rebuilt[chunkNumber], nil
+}
+
+// rebuild reconstructs every missing or damaged chunk of a stripe
+func (cp *collectionParity) rebuild(ctx context.Context, stripe *parityStripe) error {
+	log := trace.FromContext(ctx).WithPrefix("PARITY")
+
+	h := stripe.header
+	shardSize := 0
+	for _, size := range h.Sizes {
+		shardSize = max(shardSize, size)
+	}
+
+	shards := make([][]byte, len(h.Sizes)+h.Parity)
+	var damaged []int
+	for i := range h.Sizes {
+		n := h.FirstChunk + i
+		data, err := ReadChunk(ctx, cp.coll, n)
+		if err != nil || !chunkIntact(h, n, data) {
+			damaged = append(damaged, n)
+			continue
+		}
+		shards[i] = append(data, make([]byte, shardSize-len(data))...)
+	}
+	for _, name := range stripe.files {
+		header, data, err := readParityFile(cp.coll, name, true)
+		if err != nil || header.FirstChunk != h.FirstChunk || header.Parity != h.Parity || len(data) != shardSize {
+			log.Infof("Warning: ignoring damaged parity file %s", filepath.Join(cp.coll.Path, name))
+			continue
+		}
+		shards[len(h.Sizes)+header.Index] = data
+	}
+
+	rs, err := newReedSolomon(len(h.Sizes), h.Parity)
+	if err != nil {
+		return err
+	}
+	if err := rs.reconstruct(shards); err != nil {
+		return err
+	}
+	for _, n := range damaged {
+		i := n - h.FirstChunk
+		data := shards[i][:h.Sizes[i]]
+		if !chunkIntact(h, n, data) {
+			return fmt.Errorf("rebuilt chunk %d does not match its checksum", n)
+		}
+		cp.rebuilt[n] = data
+		log.Infof("Warning: collection %s: chunk %d was missing or damaged and has been rebuilt from parity", cp.coll.Name, n)
+	}
+	return nil
+}
+
+// rebuiltChunks returns the numbers of the chunks rebuilt so far, in order
+func (cp *collectionParity) rebuiltChunks() []int {
+	var numbers []int
+	for n := range cp.rebuilt {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// chunkIntact reports whether a chunk matches the size and checksum recorded for it
+func chunkIntact(h parityHeader, chunkNumber int, data []byte) bool {
+	i := chunkNumber - h.FirstChunk
+	if len(data) != h.Sizes[i] {
+		return false
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) == h.Checksums[i]
+}
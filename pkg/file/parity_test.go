@@ -0,0 +1,114 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestParity(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "parity-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, format := range []Format{FormatBin, FormatPNG} {
+		t.Run(string(format), func(t *testing.T) {
+			// A 70-chunk collection spans two stripes, the last chunk shorter than the rest
+			collPath := filepath.Join(tempDir, string(format), "2A3")
+			coll := Collection{Name: "2A3", Path: collPath, Format: format}
+			formatter := GetFormatter(format)
+			chunks := make(map[int][]byte)
+			for n := 1; n <= 70; n++ {
+				chunks[n] = make([]byte, 200)
+				if n == 70 {
+					chunks[n] = chunks[n][:77]
+				}
+				if _, err := rand.Read(chunks[n]); err != nil {
+					t.Fatalf("Failed to generate chunk: %v", err)
+				}
+				if err := formatter.WriteChunk(ctx, collPath, 0, n, chunks[n]); err != nil {
+					t.Fatalf("Failed to write chunk: %v", err)
+				}
+			}
+			if err := WriteParity(ctx, coll, 5); err != nil {
+				t.Fatalf("WriteParity failed: %v", err)
+			}
+
+			// 5% of 64 chunks rounds up to 4 parity files, and of 6 chunks to 1
+			for _, name := range []string{ParityFileName("2A3", 1, 3), ParityFileName("2A3", 65, 0)} {
+				if _, err := os.Stat(filepath.Join(collPath, name)); err != nil {
+					t.Errorf("Expected parity file %s: %v", name, err)
+				}
+			}
+			if matches, _ := filepath.Glob(filepath.Join(collPath, "*"+paritySuffix)); int64(len(matches)) != ParityFiles(70, 5) {
+				t.Errorf("Expected %d parity files, got %d", ParityFiles(70, 5), len(matches))
+			}
+			if numbers, _ := ChunkNumbers(coll); len(numbers) != 70 {
+				t.Errorf("Parity files should not be counted as chunks, got %d chunks", len(numbers))
+			}
+
+			// Lose chunks in both stripes, including the last, and corrupt another
+			for _, n := range []int{2, 30, 70} {
+				os.Remove(filepath.Join(collPath, ChunkFileName(format, "2A3", n)))
+			}
+			corrupt := bytes.Clone(chunks[40])
+			corrupt[0] ^= 0xff
+			if err := formatter.WriteChunk(ctx, collPath, 0, 40, corrupt); err != nil {
+				t.Fatalf("Failed to corrupt chunk: %v", err)
+			}
+
+			source, err := NewChunkSource(ctx, coll)
+			if err != nil {
+				t.Fatalf("NewChunkSource failed: %v", err)
+			}
+			if source.LastChunk() != 70 {
+				t.Errorf("Expected the last chunk to be 70, got %d", source.LastChunk())
+			}
+			for n := 1; n <= 70; n++ {
+				data, err := source.ReadChunk(ctx, n)
+				if err != nil || !bytes.Equal(data, chunks[n]) {
+					t.Errorf("Chunk %d was not read back intact (%v)", n, err)
+				}
+			}
+			if !reflect.DeepEqual(source.Rebuilt(), []int{2, 30, 40, 70}) {
+				t.Errorf("Expected chunks [2 30 40 70] to be rebuilt, got %v", source.Rebuilt())
+			}
+
+			// Sequential readers see the same chunks
+			reader := NewCollectionReader(coll)
+			for n := 1; n <= 70; n++ {
+				data, err := reader.ReadNextChunk(ctx)
+				if err != nil || !bytes.Equal(data, chunks[n]) {
+					t.Fatalf("Chunk %d was not read back intact (%v)", n, err)
+				}
+			}
+			if _, err := reader.ReadNextChunk(ctx); err != io.EOF {
+				t.Errorf("Expected EOF after the last chunk, got %v", err)
+			}
+
+			// One parity file cannot make up for two lost chunks in the last stripe
+			os.Remove(filepath.Join(collPath, ChunkFileName(format, "2A3", 66)))
+			source, err = NewChunkSource(ctx, coll)
+			if err != nil {
+				t.Fatalf("NewChunkSource failed: %v", err)
+			}
+			if _, err := source.ReadChunk(ctx, 66); err == nil {
+				t.Errorf("Expected an error reading chunk 66 with two chunks of its stripe lost")
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// Archives always name their entries with forward slashes, as tar and zip
+// require, and are restored by converting each name to a path of the local
+// system. Names that are valid where they were archived may not be valid
+// there: Windows forbids some characters, names ending in a dot or a space,
+// and device names such as CON or LPT1, with or without an extension. Such
+// names are remapped to the nearest valid name, and the remapping reported,
+// rather than failing the restore.
+
+// windowsInvalidChars are the characters that Windows forbids in file names,
+// in addition to control characters
+const windowsInvalidChars = `<>:"/\|?*`
+
+// windowsReservedNames are the device names that Windows reserves in every
+// directory, whatever their extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true, "CONIN$": true, "CONOUT$": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"COM¹": true, "COM²": true, "COM³": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+	"LPT¹": true, "LPT²": true, "LPT³": true,
+}
+
+// windowsName returns the nearest name to elem that is valid as a single
+// element of a Windows path: forbidden and control characters become "_",
+// trailing dots and spaces become "_", and a reserved device name gets "_"
+// appended to its base (e.g. "aux.txt" becomes "aux_.txt").
+func windowsName(elem string) string {
+	if elem == "." || elem == ".." {
+		return elem
+	}
+	name := []rune(elem)
+	for i, r := range name {
+		if r < 0x20 || strings.ContainsRune(windowsInvalidChars, r) {
+			name[i] = '_'
+		}
+	}
+	for i := len(name) - 1; i >= 0 && (name[i] == '.' || name[i] == ' '); i-- {
+		name[i] = '_'
+	}
+	elem = string(name)
+
+	base, ext, _ := strings.Cut(elem, ".")
+	if windowsReservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		elem = base + "_"
+		if ext != "" {
+			elem += "." + ext
+		}
+	}
+	return elem
+}
+
+// archiveEntryName converts a path relative to a directory being archived to
+// the name of its entry, which always uses forward slashes
+func archiveEntryName(rel string) string {
+	return filepath.ToSlash(rel)
+}
+
+// zipEntryName returns the name of a zip entry with forward slashes, which
+// zips made by some Windows tools separate with backslashes instead, against
+// the zip specification
+func zipEntryName(name string) string {
+	return strings.ReplaceAll(name, `\`, "/")
+}
+
+// nameRemapper converts the names of archive entries to names that are valid
+// on the local system, keeping the names of distinct entries distinct, and
+// records each name it changes so that the restore can report them
+type nameRemapper struct {
+	ctx       context.Context
+	elem      func(string) string // Converts one element of a name, localNameElem by default
+	foldCase  bool                // Whether names that differ only in case collide
+	names     map[string]string   // Remapped name of each entry name seen
+	claimed   map[string]nameClaim
+	remapped  []string
+	collision int
+}
+
+// newNameRemapper creates a nameRemapper for the local system
+func newNameRemapper(ctx context.Context) *nameRemapper {
+	return &nameRemapper{
+		ctx:      ctx,
+		elem:     localNameElem,
+		foldCase: localNamesFoldCase,
+		names:    make(map[string]string),
+		claimed:  make(map[string]nameClaim),
+	}
+}
+
+// nameClaim is the entry that a remapped name was first given to
+type nameClaim struct {
+	name string
+	dir  bool
+}
+
+// localName returns the name, still separated by forward slashes, under which
+// an archive entry is restored, given whether the entry is a directory.
+// Backslashes separate the elements of a name only on systems where they
+// separate the elements of a path.
+func (nr *nameRemapper) localName(name string, dir bool) string {
+	name = strings.TrimSuffix(filepath.ToSlash(name), "/")
+	if mapped, ok := nr.names[name]; ok {
+		return mapped
+	}
+
+	// Entries are remapped element by element, so that the entries within a
+	// remapped directory follow it
+	var mapped string
+	if dir, base := path.Split(name); dir != "" {
+		mapped = nr.localName(dir, true) + "/" + nr.elem(base)
+	} else {
+		mapped = nr.elem(base)
+	}
+
+	// The name may coincide with that of another entry once remapped, such as
+	// "a?" and "a_", or once case is ignored, in which case the later entry is
+	// numbered rather than restored over the earlier one. Directories that
+	// coincide are merged, as they would be by copying one onto the other.
+	for n := 2; nr.claimedByOther(mapped, name, dir); n++ {
+		mapped = numberedName(nr.elem(path.Base(name)), n)
+		if parent := path.Dir(name); parent != "." {
+			mapped = nr.localName(parent, true) + "/" + mapped
+		}
+		if n == 2 {
+			nr.collision++
+		}
+	}
+	if mapped != name {
+		nr.remapped = append(nr.remapped, name+" -> "+mapped)
+	}
+	nr.names[name] = mapped
+	if _, ok := nr.claimed[nr.key(mapped)]; !ok {
+		nr.claimed[nr.key(mapped)] = nameClaim{name: name, dir: dir}
+	}
+	return mapped
+}
+
+// claimedByOther reports whether a remapped name is already the name of an
+// entry other than name, unless both are directories
+func (nr *nameRemapper) claimedByOther(mapped string, name string, dir bool) bool {
+	claim, ok := nr.claimed[nr.key(mapped)]
+	return ok && claim.name != name && !(claim.dir && dir)
+}
+
+// key returns the form of a name under which it collides with others
+func (nr *nameRemapper) key(name string) string {
+	if nr.foldCase {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// numberedName inserts a number before the extension of a name, e.g.
+// "aux_.txt" becomes "aux_~2.txt"
+func numberedName(name string, n int) string {
+	ext := path.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+	return fmt.Sprintf("%s~%d%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// summarize logs the entries that were restored under other names
+func (nr *nameRemapper) summarize() {
+	if len(nr.remapped) == 0 {
+		return
+	}
+	log := trace.FromContext(nr.ctx).WithPrefix("DESERIALIZE")
+	log.Infof("Warning: restored %d entries under other names, as their names are not valid on this system:", len(nr.remapped))
+	for i, name := range nr.remapped {
+		if i == maxConflictListing {
+			log.Infof("  ... and %d more", len(nr.remapped)-maxConflictListing)
+			break
+		}
+		log.Infof("  - %s", name)
+	}
+	if nr.collision > 0 {
+		log.Infof("Warning: %d of them were numbered to keep them apart from other entries of the same name", nr.collision)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package file
+
+// localNamesFoldCase is not set, as names that differ only in case are
+// distinct on most other systems
+const localNamesFoldCase = false
+
+// localNameElem returns an element of an archive entry name unchanged, as any
+// name that can be archived is valid on this system
+func localNameElem(elem string) string {
+	return elem
+}
+
+// longPath returns a path unchanged, as this system has no limit on the length
+// of a path that extended-length paths would lift
+func longPath(p string) string {
+	return p
+}
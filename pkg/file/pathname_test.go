@@ -0,0 +1,179 @@
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestWindowsName(t *testing.T) {
+	tests := map[string]string{
+		"report.txt":    "report.txt",
+		"a<b>c:d.txt":   "a_b_c_d.txt",
+		`what?*|"`:      "what____",
+		"tab\there":     "tab_here",
+		"trailing.":     "trailing_",
+		"spaces. . ":    "spaces____",
+		"CON":           "CON_",
+		"aux.txt":       "aux_.txt",
+		"lpt1.tar.gz":   "lpt1_.tar.gz",
+		"COM¹":          "COM¹_",
+		"console.txt":   "console.txt",
+		"nul ":          "nul_",
+		"..":            "..",
+		".hidden":       ".hidden",
+		"COM10":         "COM10",
+		"prn .log":      "prn _.log",
+		"Ünïcödé.txt":   "Ünïcödé.txt",
+		`back\slash.md`: "back_slash.md",
+	}
+	for elem, want := range tests {
+		if got := windowsName(elem); got != want {
+			t.Errorf("windowsName(%q) = %q; expected %q", elem, got, want)
+		}
+	}
+}
+
+func TestNameRemapper(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// The Windows rules are applied on every system, so that they are tested
+	// wherever the tests run
+	nr := newNameRemapper(ctx)
+	nr.elem, nr.foldCase = windowsName, true
+
+	tests := []struct{ name, want string }{
+		{"docs/", "docs"},
+		{"docs/plan.txt", "docs/plan.txt"},
+		{"aux/", "aux_"},
+		{"aux/notes.txt", "aux_/notes.txt"},
+		{"what?/", "what_"},
+		{"what?/why?.txt", "what_/why_.txt"},
+		{"why_", "why_"},
+		{"why?", "why_~2"},
+		{"Docs/", "Docs"},
+		{"Docs/Plan.txt", "Docs/Plan~2.txt"},
+		{"docs/plan.txt", "docs/plan.txt"},
+		{"what_", "what_~2"},
+		{"./rel/con.md", "./rel/con_.md"},
+		{"../up.txt", "../up.txt"},
+	}
+	for _, tt := range tests {
+		if got := nr.localName(tt.name, strings.HasSuffix(tt.name, "/")); got != tt.want {
+			t.Errorf("localName(%q) = %q; expected %q", tt.name, got, tt.want)
+		}
+	}
+	if len(nr.remapped) != 8 || nr.collision != 3 {
+		t.Errorf("Expected 8 entries remapped, 3 of them numbered, got %d and %d: %v", len(nr.remapped), nr.collision, nr.remapped)
+	}
+	nr.summarize()
+
+	// Names are unchanged where they are valid
+	local := newNameRemapper(ctx)
+	if name := local.localName("dir/file.txt", false); name != "dir/file.txt" || len(local.remapped) != 0 {
+		t.Errorf("Expected a valid name to be unchanged, got %s", name)
+	}
+}
+
+func TestNumberedName(t *testing.T) {
+	tests := map[string]string{"a.txt": "a~2.txt", "a": "a~2", ".hidden": ".hidden~2", "a.tar.gz": "a.tar~2.gz"}
+	for name, want := range tests {
+		if got := numberedName(name, 2); got != want {
+			t.Errorf("numberedName(%q) = %q; expected %q", name, got, want)
+		}
+	}
+}
+
+func TestZipEntryNames(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "pathname-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A bundle zipped by a Windows tool that separates names with backslashes
+	zipPath := filepath.Join(tempDir, "bundle.zip")
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create(`3A5\3A5_0001.bin`)
+	w.Write([]byte("chunk"))
+	zw.Close()
+	if err := os.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write zip: %v", err)
+	}
+
+	a, err := openZipArchive(ctx, zipPath)
+	if err != nil {
+		t.Fatalf("openZipArchive failed: %v", err)
+	}
+	defer a.close()
+	sub := a.sub("3A5")
+	defer sub.close()
+	if data, err := sub.readFile("3A5_0001.bin"); err != nil || string(data) != "chunk" {
+		t.Errorf("Expected to read the chunk within the collection's directory, got %q (%v)", data, err)
+	}
+
+	t.Run("Extraction stays within the collection", func(t *testing.T) {
+		evilPath := filepath.Join(tempDir, "evil.zip")
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, _ := zw.Create("../escape.bin")
+		w.Write([]byte("evil"))
+		zw.Close()
+		os.WriteFile(evilPath, buf.Bytes(), 0644)
+		extractDir := filepath.Join(tempDir, "extract")
+		os.MkdirAll(extractDir, 0755)
+		if _, err := ExtractZipCollection(ctx, evilPath, extractDir); err == nil {
+			t.Errorf("Expected an entry outside of the collection to be rejected")
+		}
+		if _, err := os.Stat(filepath.Join(extractDir, "escape.bin")); err == nil {
+			t.Errorf("File was written outside of the collection directory")
+		}
+	})
+}
+
+func TestDeserializeLocalNames(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "pathname-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Names that are valid on this system are restored as they are, however
+	// deep; see pathname_windows_test.go for those that are not
+	deep := ""
+	for i := 0; i < 30; i++ {
+		deep += "a-rather-long-directory-name/"
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: deep + "file.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4})
+	tw.Write([]byte("deep"))
+	tw.Close()
+
+	outputDir := filepath.Join(tempDir, "output")
+	if err := DeserializeDirectoryFromStream(ctx, outputDir, &buf, false, DeserializeOptions{}); err != nil {
+		t.Fatalf("DeserializeDirectoryFromStream failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(deep), "file.txt"))
+	if err != nil || string(data) != "deep" {
+		t.Errorf("Expected the deep file to be restored: %q (%v)", data, err)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build windows
+
+package file
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// localNamesFoldCase is set as names that differ only in case name the same
+// file on Windows
+const localNamesFoldCase = true
+
+// localNameElem converts one element of an archive entry name to a valid
+// Windows file name
+func localNameElem(elem string) string {
+	return windowsName(elem)
+}
+
+// longPath returns the extended-length form of a path (e.g. `\\?\C:\out`), in
+// which Windows allows paths longer than MAX_PATH (260 characters) whether or
+// not long paths are enabled on the system. Such paths are not normalized by
+// Windows, so the path is made absolute and cleaned first. Paths that cannot
+// be made absolute are returned unchanged.
+func longPath(p string) string {
+	if strings.HasPrefix(p, `\\?\`) || strings.HasPrefix(p, `\\.\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if rest, ok := strings.CutPrefix(abs, `\\`); ok {
+		return `\\?\UNC\` + rest
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,96 @@
+//go:build windows
+
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestLongPath(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd failed: %v", err)
+	}
+	tests := map[string]string{
+		`C:\out\dir`:             `\\?\C:\out\dir`,
+		`C:\out\..\dir\`:         `\\?\C:\dir`,
+		`\\server\share\out`:     `\\?\UNC\server\share\out`,
+		`\\?\C:\already`:         `\\?\C:\already`,
+		`out`:                    `\\?\` + filepath.Join(cwd, "out"),
+		`\\.\pipe\not-a-dir`:     `\\.\pipe\not-a-dir`,
+		`C:/forward/slashes.txt`: `\\?\C:\forward\slashes.txt`,
+	}
+	for p, want := range tests {
+		if got := longPath(p); got != want {
+			t.Errorf("longPath(%q) = %q; expected %q", p, got, want)
+		}
+	}
+}
+
+func TestDeserializeWindowsNames(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "pathname-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A tree archived on another system, with names that Windows forbids and a
+	// path well beyond MAX_PATH
+	deep := strings.Repeat("a-rather-long-directory-name/", 12)
+	entries := []struct {
+		name, content string
+	}{
+		{"con.txt", "reserved"},
+		{"notes: draft?.md", "forbidden"},
+		{"trailing dot.", "trailing"},
+		{"Readme.txt", "first"},
+		{"README.txt", "second"},
+		{deep + "file.txt", "deep"},
+		{`dir\file.txt`, "separated"},
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		tw.WriteHeader(&tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(e.content))})
+		tw.Write([]byte(e.content))
+	}
+	tw.Close()
+
+	// The output directory is relative, which Windows only extends past MAX_PATH
+	// in its extended-length form
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("Chdir failed: %v", err)
+	}
+	defer os.Chdir(os.TempDir())
+	if err := DeserializeDirectoryFromStream(ctx, "output", &buf, false, DeserializeOptions{}); err != nil {
+		t.Fatalf("DeserializeDirectoryFromStream failed: %v", err)
+	}
+
+	restored := map[string]string{
+		"con_.txt":         "reserved",
+		"notes_ draft_.md": "forbidden",
+		"trailing dot_":    "trailing",
+		"Readme.txt":       "first",
+		"README~2.txt":     "second",
+		deep + "file.txt":  "deep",
+		"dir/file.txt":     "separated",
+	}
+	for name, content := range restored {
+		data, err := os.ReadFile(longPath(filepath.Join(tempDir, "output", filepath.FromSlash(name))))
+		if err != nil || string(data) != content {
+			t.Errorf("Expected %s to hold %q, got %q (%v)", name, content, data, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// RecoveryInfoFileName is the name of the machine-readable recovery metadata
+// stored in each collection of a set with named custodians
+const RecoveryInfoFileName = "padlock-recovery.json"
+
+// RecoveryReadmeFileName is the name of the human-readable recovery
+// instructions stored alongside the recovery metadata
+const RecoveryReadmeFileName = "README.txt"
+
+// RecoveryCustodian describes one custodian of a set and the collections they hold
+type RecoveryCustodian struct {
+	Name         string   `json:"name"`                   // Custodian name
+	Contact      string   `json:"contact,omitempty"`      // How to reach the custodian (e.g., email or phone)
+	Instructions string   `json:"instructions,omitempty"` // Notes specific to this custodian
+	Collections  []string `json:"collections"`            // Collections held by the custodian
+}
+
+// RecoveryInfo explains what a collection is and how to recover the data it
+// protects, so that whoever finds it years later knows whom to contact. Like
+// the other manifests it never holds key material.
+type RecoveryInfo struct {
+	Version      int                 `json:"version"`                // Manifest format version
+	Collection   string              `json:"collection"`             // Collection name (e.g., "3A5")
+	Holder       string              `json:"holder"`                 // Name of the custodian holding this collection
+	Required     int                 `json:"required"`               // Collections required for reconstruction (K)
+	Copies       int                 `json:"copies"`                 // Collections in the set (N)
+	Created      string              `json:"created"`                // When the set was encoded, in RFC 3339 format
+	Instructions string              `json:"instructions,omitempty"` // Recovery instructions for the whole set
+	Custodians   []RecoveryCustodian `json:"custodians"`             // Every custodian of the set
+}
+
+// WriteRecoveryInfo writes the recovery metadata and a README generated from it
+// into a collection directory
+func WriteRecoveryInfo(ctx context.Context, collPath string, info RecoveryInfo) error {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	info.Version = ManifestVersion
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		log.Error(fmt.Errorf("failed to encode recovery info: %w", err))
+		return fmt.Errorf("failed to encode recovery info: %w", err)
+	}
+
+	path := filepath.Join(collPath, RecoveryInfoFileName)
+	log.Debugf("Writing recovery info: %s", path)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		log.Error(fmt.Errorf("failed to write recovery info %s: %w", path, err))
+		return fmt.Errorf("failed to write recovery info %s: %w", path, err)
+	}
+
+	path = filepath.Join(collPath, RecoveryReadmeFileName)
+	if err := os.WriteFile(path, []byte(RecoveryReadme(info)), 0644); err != nil {
+		log.Error(fmt.Errorf("failed to write recovery readme %s: %w", path, err))
+		return fmt.Errorf("failed to write recovery readme %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadRecoveryInfo reads the recovery metadata of a collection directory. It
+// returns nil without error for collections that have none.
+func ReadRecoveryInfo(ctx context.Context, collPath string) (*RecoveryInfo, error) {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	path := filepath.Join(collPath, RecoveryInfoFileName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read recovery info %s: %w", path, err))
+		return nil, fmt.Errorf("failed to read recovery info %s: %w", path, err)
+	}
+
+	var info RecoveryInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		log.Error(fmt.Errorf("invalid recovery info %s: %w", path, err))
+		return nil, fmt.Errorf("invalid recovery info %s: %w", path, err)
+	}
+	if info.Version > ManifestVersion {
+		log.Error(fmt.Errorf("recovery info %s has unsupported version %d", path, info.Version))
+		return nil, fmt.Errorf("recovery info %s has unsupported version %d", path, info.Version)
+	}
+	return &info, nil
+}
+
+// RecoveryReadme renders recovery metadata as plain-text instructions
+func RecoveryReadme(info RecoveryInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "PADLOCK COLLECTION %s\n\n", info.Collection)
+	fmt.Fprintf(&b, "This is collection %s of a set of %d created by padlock on %s.\n", info.Collection, info.Copies, info.Created)
+	if info.Holder != "" {
+		fmt.Fprintf(&b, "It is held by %s.\n", info.Holder)
+	}
+	b.WriteString("\nOn its own, this collection reveals nothing about the data it protects.\n")
+	fmt.Fprintf(&b, "Any %d collections of the set together reconstruct the data. Gather them,\n", info.Required)
+	b.WriteString("as directories or zip files, into a single directory and run:\n\n")
+	b.WriteString("    padlock decode <directory with the collections> <output directory>\n\n")
+	b.WriteString("padlock is available at https://github.com/rayozzie/padlock\n")
+
+	if len(info.Custodians) > 0 {
+		b.WriteString("\nCUSTODIANS\n\n")
+		for _, c := range info.Custodians {
+			fmt.Fprintf(&b, "  %s holds %s\n", c.Name, strings.Join(c.Collections, ", "))
+			if c.Contact != "" {
+				fmt.Fprintf(&b, "    Contact: %s\n", c.Contact)
+			}
+			if c.Instructions != "" {
+				fmt.Fprintf(&b, "    Notes: %s\n", c.Instructions)
+			}
+		}
+	}
+
+	if info.Instructions != "" {
+		b.WriteString("\nINSTRUCTIONS\n\n")
+		for _, line := range strings.Split(strings.TrimSpace(info.Instructions), "\n") {
+			fmt.Fprintf(&b, "  %s\n", line)
+		}
+	}
+	return b.String()
+}
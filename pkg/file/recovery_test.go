@@ -0,0 +1,70 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestRecoveryInfo(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "recovery-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if got, err := ReadRecoveryInfo(ctx, tempDir); err != nil || got != nil {
+		t.Fatalf("Expected no recovery info, got %+v (%v)", got, err)
+	}
+
+	info := RecoveryInfo{
+		Collection:   "2A3",
+		Holder:       "alice",
+		Required:     2,
+		Copies:       3,
+		Created:      "2026-01-02T03:04:05Z",
+		Instructions: "Meet at the bank.\nBring ID.",
+		Custodians: []RecoveryCustodian{
+			{Name: "alice", Contact: "alice@example.com", Collections: []string{"2A3", "2B3"}},
+			{Name: "bob", Instructions: "Safe deposit box 12", Collections: []string{"2C3"}},
+		},
+	}
+	if err := WriteRecoveryInfo(ctx, tempDir, info); err != nil {
+		t.Fatalf("WriteRecoveryInfo failed: %v", err)
+	}
+
+	got, err := ReadRecoveryInfo(ctx, tempDir)
+	if err != nil || got == nil {
+		t.Fatalf("ReadRecoveryInfo failed: %v", err)
+	}
+	if got.Version != ManifestVersion || got.Holder != "alice" || len(got.Custodians) != 2 || got.Custodians[1].Collections[0] != "2C3" {
+		t.Errorf("Unexpected recovery info: %+v", got)
+	}
+
+	readme, err := os.ReadFile(filepath.Join(tempDir, RecoveryReadmeFileName))
+	if err != nil {
+		t.Fatalf("Failed to read README: %v", err)
+	}
+	for _, want := range []string{
+		"collection 2A3 of a set of 3",
+		"held by alice",
+		"Any 2 collections",
+		"alice holds 2A3, 2B3",
+		"Contact: alice@example.com",
+		"Notes: Safe deposit box 12",
+		"  Bring ID.",
+	} {
+		if !strings.Contains(string(readme), want) {
+			t.Errorf("README does not contain %q:\n%s", want, readme)
+		}
+	}
+}
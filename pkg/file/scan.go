@@ -0,0 +1,258 @@
+package file
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// ScanDepth is how many directories deep below each root ScanForCollections looks
+const ScanDepth = 6
+
+// collectionEntryPattern matches the names under which collections are stored:
+// a collection directory ("3A5"), a volume of one ("3A5.vol01"), either in a
+// zip or tar.gz archive, a packed collection, or the manifest of a collection
+// in a chunk store
+var collectionEntryPattern = regexp.MustCompile(`^[0-9]+[A-Za-z][0-9]+((\.vol[0-9]+)?(\.zip|\.tar\.gz|\.tgz)?|\.padlock|\.store\.json)$`)
+
+// obfuscatedEntryPattern matches the names given to collections whose names
+// have been obfuscated, as directories or archives
+var obfuscatedEntryPattern = regexp.MustCompile(`^[0-9a-f]{12}(\.zip|\.tar\.gz|\.tgz)?$`)
+
+// Candidate is a collection found by ScanForCollections
+type Candidate struct {
+	Collection
+	Source     string   // Where the collection was found: its directory, or its zip when extracted from one
+	Required   int      // K recorded in the collection's name
+	Total      int      // N recorded in the collection's name
+	Letter     string   // The collection's letter within its set (e.g., "A")
+	Chunks     int      // Number of the collection's last chunk
+	Session    string   // Fingerprint shared by the collections of one encode
+	Duplicates []string // Sources of identical copies found after this one, which are not listed separately
+
+	fingerprint [sha256.Size]byte // Hash of the first chunk, identical in every copy of the collection
+}
+
+// ScanResult holds the collections found by ScanForCollections. Zips are read
+// in place, or extracted to temporary directories if they hold volumes or are
+// damaged, and Close closes or removes them.
+type ScanResult struct {
+	Candidates []Candidate // Distinct collections found, sorted by name
+	Skipped    []string    // Entries named like collections that are not, each with the reason
+
+	ctx      context.Context
+	found    []Collection
+	tempDirs []string
+}
+
+// Collections returns the collections found, one per candidate
+func (r *ScanResult) Collections() []Collection {
+	collections := make([]Collection, len(r.Candidates))
+	for i, c := range r.Candidates {
+		collections[i] = c.Collection
+	}
+	return collections
+}
+
+// Close closes the zips read in place and removes the temporary directories
+// holding collections extracted from zips
+func (r *ScanResult) Close() error {
+	CloseCollections(r.found)
+	r.found = nil
+	var firstErr error
+	for _, dir := range r.tempDirs {
+		if err := RemoveTemp(r.ctx, dir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	r.tempDirs = nil
+	return firstErr
+}
+
+// ScanForCollections searches every directory up to ScanDepth levels below
+// each root, such as the mount points of removable media, for collections:
+// directories, zips, volumes and custodian bundles, in bin or PNG format.
+// Directories are read only if they hold something named like a collection,
+// and each collection is confirmed by the header of one of its chunks.
+//
+// Copies of one collection are recognized by their identical first chunk, and
+// the copies after the first are listed in its Duplicates. Collections that
+// appear to come from one encode share a Session: one that names the same
+// K-of-N set, carries the same session ID in its chunk headers and agrees on
+// the number and sizes of its chunks. Roots that do
+// not exist are skipped.
+func ScanForCollections(ctx context.Context, roots []string) (*ScanResult, error) {
+	log := trace.FromContext(ctx).WithPrefix("SCAN")
+
+	result := &ScanResult{ctx: ctx}
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if _, err := os.Stat(root); err != nil {
+			log.Debugf("Skipping %s: %v", root, err)
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// Unreadable directories are common on mounted media and are passed over
+				log.Debugf("Skipping %s: %v", path, err)
+				if d != nil && d.IsDir() && path != root {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			depth := strings.Count(strings.TrimPrefix(path, root), string(filepath.Separator))
+			if depth > ScanDepth || (path != root && strings.HasPrefix(d.Name(), ".")) || seen[path] {
+				return filepath.SkipDir
+			}
+			seen[path] = true
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// Only directories holding something named like a collection are read
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return nil
+			}
+			candidate := false
+			for _, e := range entries {
+				if collectionEntryPattern.MatchString(e.Name()) || obfuscatedEntryPattern.MatchString(e.Name()) {
+					// The entry is read as part of this directory, not on its own,
+					// so that a volume isn't mistaken for a whole collection
+					seen[filepath.Join(path, e.Name())] = true
+					candidate = true
+				}
+			}
+			if !candidate {
+				return nil
+			}
+			// The walk itself searches the directories nested in this one
+			found, tempDir, err := findCollectionsIn(ctx, path, maxNestingDepth)
+			if tempDir != "" {
+				result.tempDirs = append(result.tempDirs, tempDir)
+			}
+			if err != nil {
+				log.Debugf("No collections in %s: %v", path, err)
+				return nil
+			}
+			result.found = append(result.found, found...)
+			for _, coll := range found {
+				result.add(ctx, coll, collectionSource(path, tempDir, coll))
+			}
+			return nil
+		})
+		if err != nil {
+			result.Close()
+			return nil, fmt.Errorf("failed to scan %s: %w", root, err)
+		}
+	}
+
+	sort.SliceStable(result.Candidates, func(i, j int) bool {
+		return result.Candidates[i].Name < result.Candidates[j].Name
+	})
+	log.Debugf("Found %d collections, skipped %d", len(result.Candidates), len(result.Skipped))
+	return result, nil
+}
+
+// add probes a collection found by the scan and records it as a candidate,
+// a duplicate of one, or a lookalike to be skipped
+func (r *ScanResult) add(ctx context.Context, coll Collection, source string) {
+	log := trace.FromContext(ctx).WithPrefix("SCAN")
+
+	c := Candidate{Collection: coll, Source: source}
+	k, n, letter, err := pad.ParseCollectionName(coll.Name)
+	if err != nil {
+		r.Skipped = append(r.Skipped, fmt.Sprintf("%s: %v", source, err))
+		return
+	}
+	c.Required, c.Total, c.Letter = k, n, letter
+	if reason := c.probe(ctx); reason != "" {
+		r.Skipped = append(r.Skipped, fmt.Sprintf("%s: %s", source, reason))
+		return
+	}
+	for i := range r.Candidates {
+		if r.Candidates[i].Name == c.Name && r.Candidates[i].fingerprint == c.fingerprint {
+			log.Debugf("Collection %s at %s is a copy of the one at %s", c.Name, source, r.Candidates[i].Source)
+			r.Candidates[i].Duplicates = append(r.Candidates[i].Duplicates, source)
+			return
+		}
+	}
+	log.Debugf("Found collection %s at %s", c.Name, source)
+	r.Candidates = append(r.Candidates, c)
+}
+
+// collectionSource returns where a collection found in dir was stored: its
+// directory or archive, or for one extracted to tempDir, the archive of its
+// first volume or of the custodian bundle holding it
+func collectionSource(dir, tempDir string, coll Collection) string {
+	if tempDir == "" || !strings.HasPrefix(coll.Path, tempDir+string(filepath.Separator)) {
+		return coll.Path
+	}
+	// Each archive is extracted to a directory of the same name within tempDir
+	if rel, err := filepath.Rel(tempDir, coll.Path); err == nil {
+		base := strings.Split(rel, string(filepath.Separator))[0]
+		for _, suffix := range append([]string{".zip"}, tarSuffixes...) {
+			archivePath := filepath.Join(dir, base+suffix)
+			if _, err := os.Stat(archivePath); err == nil {
+				return archivePath
+			}
+		}
+	}
+	return dir
+}
+
+// probe confirms that the collection's chunks have valid headers naming it and
+// works out its session, returning why not if it isn't a collection. Damaged
+// chunks are passed over, so any one intact chunk confirms the collection.
+func (c *Candidate) probe(ctx context.Context) string {
+	numbers, err := ChunkNumbers(c.Collection)
+	if err != nil || len(numbers) == 0 {
+		return "no chunks"
+	}
+	c.Chunks = numbers[len(numbers)-1]
+
+	// The size of the first and last chunks tell encodes apart, as the last is
+	// usually partial
+	first, last := -1, -1
+	var session uint64
+	reason := ""
+	for i, number := range []int{numbers[0], c.Chunks} {
+		data, err := ReadChunk(ctx, c.Collection, number)
+		if err != nil {
+			reason = fmt.Sprintf("chunk %d is unreadable", number)
+			continue
+		}
+		info, err := pad.InspectChunk(data)
+		switch {
+		case err != nil:
+			return "not a padlock collection"
+		case info.Collection != c.Name:
+			return fmt.Sprintf("chunk %d belongs to collection %s", number, info.Collection)
+		}
+		if i == 0 {
+			first = info.DataBytes
+			c.fingerprint = sha256.Sum256(data)
+		} else {
+			last = info.DataBytes
+		}
+		session = info.Session
+	}
+	if first < 0 && last < 0 {
+		return reason
+	}
+	c.Session = fmt.Sprintf("%d-of-%d/%d/%d/%d/%016x", c.Required, c.Total, c.Chunks, first, last, session)
+	return ""
+}
@@ -0,0 +1,146 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestScanForCollections(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory standing in for the mount points of drives
+	root, err := os.MkdirTemp("", "scan-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	// Two encodes of different inputs
+	first := filepath.Join(root, "first")
+	second := filepath.Join(root, "second")
+	encodeScanTestCollections(t, ctx, first, 5000)
+	encodeScanTestCollections(t, ctx, second, 700)
+
+	copyDir := func(src, dst string) {
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dst, err)
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", src, err)
+		}
+		for _, e := range entries {
+			data, err := os.ReadFile(filepath.Join(src, e.Name()))
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", e.Name(), err)
+			}
+			if err := os.WriteFile(filepath.Join(dst, e.Name()), data, 0644); err != nil {
+				t.Fatalf("Failed to write %s: %v", e.Name(), err)
+			}
+		}
+	}
+	media := filepath.Join(root, "media")
+	copyDir(filepath.Join(first, "2A3"), filepath.Join(media, "usb1", "backup", "2A3"))
+	copyDir(filepath.Join(first, "2A3"), filepath.Join(media, "usb3", "a", "b", "2A3"))
+	copyDir(filepath.Join(first, "2B3"), filepath.Join(media, "usb2", "2B3"))
+	zipPath, err := ZipCollection(ctx, filepath.Join(media, "usb2", "2B3"))
+	if err != nil {
+		t.Fatalf("ZipCollection failed: %v", err)
+	}
+	os.RemoveAll(filepath.Join(media, "usb2", "2B3"))
+	copyDir(filepath.Join(second, "2A3"), filepath.Join(media, "usb4", "2A3"))
+
+	// Collections in hidden directories or too deep are not searched for
+	copyDir(filepath.Join(first, "2C3"), filepath.Join(media, "usb1", ".Trashes", "2C3"))
+	copyDir(filepath.Join(first, "2C3"), filepath.Join(media, "usb1", "1", "2", "3", "4", "5", "6", "7", "2C3"))
+
+	// A lookalike whose chunk isn't a padlock chunk is skipped
+	lookalike := filepath.Join(media, "usb5", "2C3")
+	if err := os.MkdirAll(lookalike, 0755); err != nil {
+		t.Fatalf("Failed to create lookalike: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(lookalike, ChunkFileName(FormatBin, "2C3", 1)), []byte("not a chunk"), 0644); err != nil {
+		t.Fatalf("Failed to write lookalike chunk: %v", err)
+	}
+
+	result, err := ScanForCollections(ctx, []string{media, filepath.Join(root, "missing")})
+	if err != nil {
+		t.Fatalf("ScanForCollections failed: %v", err)
+	}
+	defer result.Close()
+
+	var found []string
+	sources := make(map[string]Candidate)
+	for _, c := range result.Candidates {
+		found = append(found, c.Name)
+		sources[c.Source] = c
+	}
+	if strings.Join(found, ",") != "2A3,2A3,2B3" {
+		t.Fatalf("Expected candidates 2A3,2A3,2B3, got %v", found)
+	}
+
+	a := sources[filepath.Join(media, "usb1", "backup", "2A3")]
+	if a.Name != "2A3" || a.Required != 2 || a.Total != 3 || a.Letter != "A" || a.Chunks != 3 {
+		t.Errorf("Unexpected candidate for the first 2A3: %+v", a)
+	}
+	if len(a.Duplicates) != 1 || a.Duplicates[0] != filepath.Join(media, "usb3", "a", "b", "2A3") {
+		t.Errorf("Expected the copy of 2A3 to be listed as a duplicate, got %v", a.Duplicates)
+	}
+	b, ok := sources[zipPath]
+	if !ok {
+		t.Fatalf("Expected 2B3 to be found in %s, got %+v", zipPath, result.Candidates)
+	}
+	if b.Session != a.Session {
+		t.Errorf("Expected 2A3 and 2B3 of one encode to share a session, got %q and %q", a.Session, b.Session)
+	}
+	other := sources[filepath.Join(media, "usb4", "2A3")]
+	if other.Session == "" || other.Session == a.Session || len(other.Duplicates) != 0 {
+		t.Errorf("Expected the 2A3 of another encode to be a separate candidate, got %+v", other)
+	}
+
+	if len(result.Skipped) != 1 || !strings.HasPrefix(result.Skipped[0], lookalike+":") {
+		t.Errorf("Expected the lookalike to be skipped, got %v", result.Skipped)
+	}
+	if got := len(result.Collections()); got != 3 {
+		t.Errorf("Expected 3 collections, got %d", got)
+	}
+
+	if !b.Zip || b.Path != zipPath {
+		t.Errorf("Expected 2B3 to be read in place from %s, got %+v", zipPath, b.Collection)
+	}
+	if err := result.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+// encodeScanTestCollections encodes inputBytes of random data as 2-of-3 collections in dir
+func encodeScanTestCollections(t *testing.T, ctx context.Context, dir string, inputBytes int) {
+	input := make([]byte, inputBytes)
+	if _, err := rand.Read(input); err != nil {
+		t.Fatalf("Failed to generate input: %v", err)
+	}
+	p, err := pad.NewPadForEncode(ctx, 3, 2)
+	if err != nil {
+		t.Fatalf("NewPadForEncode failed: %v", err)
+	}
+	newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		if err := os.MkdirAll(filepath.Join(dir, collectionName), 0755); err != nil {
+			return nil, err
+		}
+		return os.Create(filepath.Join(dir, collectionName, ChunkFileName(FormatBin, collectionName, chunkNumber)))
+	}
+	if err := p.Encode(ctx, 4000, bytes.NewReader(input), pad.NewDefaultRand(ctx), newChunk, "bin"); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+}
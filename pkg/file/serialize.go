@@ -5,19 +5,141 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/rayozzie/padlock/pkg/paddecode"
 	"github.com/rayozzie/padlock/pkg/trace"
 )
 
+// SerializeOptions controls which file system attributes are captured when a
+// directory is serialized to a tar stream. File contents, permission bits and
+// modification times are always recorded in the tar headers.
+type SerializeOptions struct {
+	PreserveSymlinks  bool // Store symlinks as link entries (otherwise they are skipped)
+	PreserveOwnership bool // Record numeric uid/gid and user/group names
+	PreserveXattrs    bool // Record extended attributes (including POSIX ACLs) as PAX records
+	FollowSymlinks    bool // Archive the files and directories that symlinks point to (with cycle detection)
+	OneFileSystem     bool // Don't descend into directories on other file systems (like tar --one-file-system)
+	PreserveSpecial   bool // Record FIFOs and device nodes as entries (otherwise they are skipped, as sockets always are)
+	PreserveHardlinks bool // Store the contents of hard-linked files once, with link entries for the other names
+	PreserveSparse    bool // Store the data of files with holes, such as VM images, as GNU sparse entries
+	RecordHashes      bool // Record the SHA-256 of each file in a manifest at the end of the stream, to verify restores
+
+	// Deterministic normalizes modification times, ownership and the tar format
+	// so that identical input produces a byte-identical stream across runs and
+	// machines. It overrides PreserveOwnership and PreserveSparse, since where
+	// a file system keeps holes varies.
+	Deterministic bool
+
+	// Include and Exclude are glob patterns (see ValidatePatterns) selecting the
+	// entries to serialize. Exclude takes precedence; an empty Include list
+	// includes everything.
+	Include []string
+	Exclude []string
+}
+
+// DeserializeOptions controls which of the attributes recorded in a tar stream
+// are applied to the files restored by DeserializeDirectoryFromStream.
+// File contents are always restored; files are created with their recorded
+// permission bits subject to the process umask.
+type DeserializeOptions struct {
+	RestoreSymlinks    bool // Recreate symlink entries (otherwise they are skipped)
+	RestorePermissions bool // Apply the exact recorded permission bits, ignoring the umask
+	RestoreModTimes    bool // Apply the recorded modification times
+	RestoreOwnership   bool // Apply the recorded uid/gid (usually requires elevated privileges)
+	RestoreXattrs      bool // Apply recorded extended attributes
+	RestoreSpecial     bool // Recreate recorded FIFOs and device nodes (otherwise they are skipped)
+
+	// Files, if not empty, restricts the restore to entries matching these glob
+	// patterns (see ValidatePatterns) or lying beneath a matching directory.
+	Files []string
+
+	// OnConflict determines how entries that already exist in the output
+	// directory are handled. With ConflictNone the output directory is expected
+	// to be empty.
+	OnConflict ConflictPolicy
+
+	// Strict, if set, makes a stream that is not a complete tar archive an
+	// error, instead of saving it as a raw file or keeping a sample of it.
+	Strict bool
+}
+
+// xattrPAXPrefix is the PAX record prefix used by GNU and BSD tar for extended attributes
+const xattrPAXPrefix = "SCHILY.xattr."
+
+// deterministicModTime is the modification time recorded for every entry in deterministic mode
+var deterministicModTime = time.Unix(0, 0).UTC()
+
 // SerializeDirectoryToStream takes an input directory path and generates an io.Reader
 // which is a 'tar' stream of the entire directory.
-func SerializeDirectoryToStream(ctx context.Context, inputDir string) (io.ReadCloser, error) {
+func SerializeDirectoryToStream(ctx context.Context, inputDir string, opts SerializeOptions) (io.ReadCloser, error) {
+	log := trace.FromContext(ctx).WithPrefix("SERIALIZE")
+	log.Debugf("Serializing directory to tar stream: %s (symlinks=%v, owner=%v, xattrs=%v, deterministic=%v)",
+		inputDir, opts.PreserveSymlinks, opts.PreserveOwnership, opts.PreserveXattrs, opts.Deterministic)
+	return serializeToStream(ctx, []string{inputDir}, []string{""}, opts)
+}
+
+// SerializeInputsToStream takes several input directories and files and
+// generates a 'tar' stream holding each under a top-level entry of its name
+// (see InputNames), so that they can be encoded together without first being
+// gathered into one directory. Include and exclude patterns match the names
+// within the stream, which begin with these top-level names.
+func SerializeInputsToStream(ctx context.Context, inputs []string, opts SerializeOptions) (io.ReadCloser, error) {
+	log := trace.FromContext(ctx).WithPrefix("SERIALIZE")
+	log.Debugf("Serializing %d inputs to tar stream: %s (symlinks=%v, owner=%v, xattrs=%v, deterministic=%v)",
+		len(inputs), strings.Join(inputs, ", "), opts.PreserveSymlinks, opts.PreserveOwnership, opts.PreserveXattrs, opts.Deterministic)
+	names, err := InputNames(inputs)
+	if err != nil {
+		return nil, err
+	}
+	return serializeToStream(ctx, inputs, names, opts)
+}
+
+// InputNames returns the top-level names under which SerializeInputsToStream
+// archives its inputs: the base name of each. Inputs without a name, such as
+// a file system root, and inputs that would share a name are an error.
+func InputNames(inputs []string) ([]string, error) {
+	names := make([]string, len(inputs))
+	seen := make(map[string]string)
+	for i, input := range inputs {
+		abs, err := filepath.Abs(input)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input path %s: %w", input, err)
+		}
+		name := filepath.Base(abs)
+		if name == string(filepath.Separator) {
+			return nil, fmt.Errorf("input %s has no name to archive it under; give a directory within it instead", input)
+		}
+		name = archiveEntryName(name)
+		if other, ok := seen[name]; ok {
+			return nil, fmt.Errorf("inputs %s and %s would both be archived as %s; rename one of them", other, input, name)
+		}
+		seen[name] = input
+		names[i] = name
+	}
+	return names, nil
+}
+
+// serializeToStream writes a tar stream of each input under the corresponding
+// top-level name, or, for a directory with an empty name, of its contents
+func serializeToStream(ctx context.Context, inputs []string, names []string, opts SerializeOptions) (io.ReadCloser, error) {
 	log := trace.FromContext(ctx).WithPrefix("SERIALIZE")
-	log.Debugf("Serializing directory to tar stream: %s", inputDir)
+
+	// Reject malformed filter patterns before starting the walk
+	if err := ValidatePatterns(opts.Include); err != nil {
+		return nil, fmt.Errorf("invalid include pattern: %w", err)
+	}
+	if err := ValidatePatterns(opts.Exclude); err != nil {
+		return nil, fmt.Errorf("invalid exclude pattern: %w", err)
+	}
+
 	pr, pw := io.Pipe()
 
 	go func() {
@@ -27,92 +149,503 @@ func SerializeDirectoryToStream(ctx context.Context, inputDir string) (io.ReadCl
 		tw := tar.NewWriter(pw)
 		defer tw.Close()
 
-		fileCount := 0
-		totalBytes := int64(0)
+		s := &tarWalker{
+			log:  log,
+			opts: opts,
+			tw:   tw,
+			w:    pw,
+		}
 
-		// Walk through the directory
-		err := filepath.Walk(inputDir, func(path string, info os.FileInfo, walkErr error) error {
-			if walkErr != nil {
-				log.Error(fmt.Errorf("error walking path %s: %w", path, walkErr))
-				return walkErr
+		// Walk through each input in turn
+		for i, input := range inputs {
+			if err := s.walk(input, names[i]); err != nil {
+				log.Error(fmt.Errorf("error during directory serialization: %w", err))
+				pw.CloseWithError(fmt.Errorf("error during directory serialization: %w", err))
+				return
 			}
+		}
 
-			// Skip the input directory itself
-			if path == inputDir {
-				return nil
+		// The hashes of the files are only known once they have all been read
+		if opts.RecordHashes {
+			if err := s.hashes.write(tw, opts.Deterministic); err != nil {
+				log.Error(fmt.Errorf("failed to write hash manifest: %w", err))
+				pw.CloseWithError(fmt.Errorf("failed to write hash manifest: %w", err))
+				return
 			}
+		}
 
-			// Skip symlinks
-			if info.Mode()&os.ModeSymlink != 0 {
-				return nil
-			}
+		log.Debugf("Directory serialization complete: %d files, %d bytes, %d excluded", s.fileCount, s.totalBytes, s.excludedCount)
+		s.special.summarize(log, "Warning: skipped %d special files, which have no contents to archive:", "")
+	}()
 
-			// Get the relative path for the tar entry
-			rel, err := filepath.Rel(inputDir, path)
-			if err != nil {
-				log.Error(fmt.Errorf("failed to determine relative path: %w", err))
-				return err
-			}
+	return pr, nil
+}
+
+// tarWalker holds the state of a single directory walk that writes a tar stream
+type tarWalker struct {
+	log  *trace.Tracer
+	opts SerializeOptions
+	tw   *tar.Writer
+	w    io.Writer // The stream under tw, for the sparse entries it cannot write
+
+	// Directories on the current walk path; when include patterns are in use
+	// their headers are only written once something beneath them is included
+	dirStack []*pendingDir
 
-			// Create a tar header
-			header, err := tar.FileInfoHeader(info, "")
+	// Device of the input directory, for OneFileSystem
+	rootDev    uint64
+	rootDevSet bool
+
+	// Resolved paths of the directories on the current walk path, for
+	// detecting cycles when following symlinks
+	realStack []string
+
+	// Sockets, FIFOs and devices that were not archived
+	special specialFiles
+
+	// SHA-256 of each file archived, for RecordHashes
+	hashes hashRecorder
+
+	// Name of the first entry archived for each file with several hard
+	// links, for PreserveHardlinks
+	links map[fileID]string
+
+	fileCount     int
+	totalBytes    int64
+	excludedCount int
+}
+
+// walk serializes the tree rooted at an input, as entries beneath name or, if
+// name is empty, as the top-level entries of the stream. Entries are visited
+// depth-first in lexical order, like filepath.Walk, but symlinks may be followed.
+func (s *tarWalker) walk(input string, name string) error {
+	// Deep trees are read through the extended-length form of the input,
+	// which the entry names never include
+	input = longPath(input)
+
+	// The input itself is always resolved, even if it is a symlink
+	rootInfo, err := os.Stat(input)
+	if err != nil {
+		s.log.Error(fmt.Errorf("error walking path %s: %w", input, err))
+		return err
+	}
+	if !rootInfo.IsDir() && name == "" {
+		return fmt.Errorf("input path is not a directory: %s", input)
+	}
+	s.rootDev, _, s.rootDevSet = fileIdentity(rootInfo)
+
+	s.realStack = nil
+	if s.opts.FollowSymlinks {
+		real, err := filepath.EvalSymlinks(input)
+		if err != nil {
+			return err
+		}
+		s.realStack = []string{real}
+	}
+
+	// A named input is itself an entry, under which a directory's entries lie
+	if name != "" {
+		err := s.visit(input, name, rootInfo)
+		if err == filepath.SkipDir {
+			return nil
+		}
+		if err != nil || !rootInfo.IsDir() {
+			return err
+		}
+	}
+	return s.walkDir(input, name)
+}
+
+// walkDir visits the entries of a directory and recurses into subdirectories
+func (s *tarWalker) walkDir(dir string, rel string) error {
+	log := s.log
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Error(fmt.Errorf("error walking path %s: %w", dir, err))
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		entryRel := entry.Name()
+		if rel != "" {
+			entryRel = rel + "/" + entry.Name()
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			log.Error(fmt.Errorf("error walking path %s: %w", path, err))
+			return err
+		}
+
+		// Optionally replace a symlink by what it points to
+		var realPath string
+		if info.Mode()&os.ModeSymlink != 0 && s.opts.FollowSymlinks {
+			targetInfo, err := os.Stat(path)
 			if err != nil {
-				log.Error(fmt.Errorf("tar FileInfoHeader for %s: %w", path, err))
-				return err
+				log.Infof("Warning: cannot follow dangling symlink %s: %v", entryRel, err)
+			} else {
+				if targetInfo.IsDir() {
+					realPath, err = filepath.EvalSymlinks(path)
+					if err != nil {
+						return err
+					}
+					if s.onRealStack(realPath) {
+						log.Infof("Warning: skipping symlink %s which creates a directory cycle", entryRel)
+						continue
+					}
+				}
+				info = targetInfo
 			}
-			header.Name = rel
+		}
 
-			// Write the header to the tar stream
-			if err := tw.WriteHeader(header); err != nil {
-				log.Error(fmt.Errorf("tar WriteHeader for %s: %w", rel, err))
-				return err
+		// Stay on the input directory's file system if requested
+		if s.opts.OneFileSystem && s.rootDevSet {
+			if dev, _, ok := fileIdentity(info); ok && dev != s.rootDev && info.Mode()&os.ModeSymlink == 0 {
+				if !info.IsDir() {
+					log.Debugf("Skipping entry on another file system: %s", entryRel)
+					continue
+				}
+				// Record the mount point itself, but none of its contents
+				log.Infof("Not descending into %s, which is on another file system", entryRel)
+				if err := s.visit(path, entryRel, info); err != nil && err != filepath.SkipDir {
+					return err
+				}
+				continue
 			}
+		}
 
-			// For directories, we're done after writing the header
-			if info.IsDir() {
-				return nil
-			}
+		err = s.visit(path, entryRel, info)
+		if err == filepath.SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
 
-			// Open the file to copy its contents
-			f, err := os.Open(path)
-			if err != nil {
-				log.Error(fmt.Errorf("open file for tar %s: %w", path, err))
-				return err
+		if info.IsDir() {
+			if s.opts.FollowSymlinks {
+				if realPath == "" {
+					if realPath, err = filepath.EvalSymlinks(path); err != nil {
+						return err
+					}
+				}
+				s.realStack = append(s.realStack, realPath)
+			}
+			err = s.walkDir(path, entryRel)
+			if s.opts.FollowSymlinks {
+				s.realStack = s.realStack[:len(s.realStack)-1]
 			}
-			defer f.Close()
-
-			// Copy the file data to the tar stream
-			n, err := io.Copy(tw, f)
 			if err != nil {
-				log.Error(fmt.Errorf("io.Copy to tar for %s: %w", rel, err))
 				return err
 			}
+		}
+	}
 
-			fileCount++
-			totalBytes += n
-			log.Debugf("Added to tar: %s (%d bytes)", rel, n)
+	return nil
+}
+
+// fileID identifies a file by its device and inode numbers
+type fileID struct {
+	dev, ino uint64
+}
+
+// onRealStack reports whether a resolved directory path is the input directory
+// or one of the directories currently being walked, which would create a cycle
+func (s *tarWalker) onRealStack(realPath string) bool {
+	for _, p := range s.realStack {
+		if p == realPath {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingDir is a directory on the current walk path
+type pendingDir struct {
+	rel      string
+	header   *tar.Header
+	included bool // The directory matched an include pattern, so everything below it is included
+	written  bool
+}
+
+// visit filters a single entry and writes it to the tar stream. It returns
+// filepath.SkipDir for directories whose contents must not be walked.
+func (s *tarWalker) visit(path string, rel string, info os.FileInfo) error {
+	log := s.log
+
+	// Leave the directories that are not ancestors of this entry
+	for len(s.dirStack) > 0 && !strings.HasPrefix(rel, s.dirStack[len(s.dirStack)-1].rel+"/") {
+		s.dirStack = s.dirStack[:len(s.dirStack)-1]
+	}
+
+	// Apply the exclude patterns, pruning whole directories
+	if matchAnyPattern(s.opts.Exclude, rel) {
+		log.Debugf("Excluded by filter: %s", rel)
+		s.excludedCount++
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+
+	// Symlinks are either stored as link entries or skipped entirely
+	isSymlink := info.Mode()&os.ModeSymlink != 0
+	if isSymlink && !s.opts.PreserveSymlinks {
+		log.Debugf("Skipping symlink: %s", path)
+		return nil
+	}
+
+	// Apply the include patterns; an entry is included if it or any of its
+	// parent directories match
+	included := len(s.opts.Include) == 0 || matchAnyPattern(s.opts.Include, rel)
+	if len(s.dirStack) > 0 && s.dirStack[len(s.dirStack)-1].included {
+		included = true
+	}
 
+	// Special files are never opened; FIFOs and devices are recorded without
+	// contents if requested, and otherwise skipped like sockets
+	special := specialFileKind(info.Mode())
+	if special != "" && !(s.opts.PreserveSpecial && specialRecordable(special)) {
+		if !included {
+			log.Debugf("Not matched by include filter: %s", rel)
+			s.excludedCount++
 			return nil
-		})
+		}
+		log.Debugf("Skipping %s: %s", special, path)
+		s.special.skip(special, rel)
+		return nil
+	}
+
+	header, err := s.buildHeader(path, rel, info, isSymlink)
+	if err != nil {
+		return err
+	}
+
+	// A file with several hard links is archived once, and its other names as
+	// links to the first
+	var id fileID
+	linked := false
+	if s.opts.PreserveHardlinks && included && info.Mode().IsRegular() {
+		if nlink, ok := linkCount(info); ok && nlink > 1 {
+			if id.dev, id.ino, linked = fileIdentity(info); linked {
+				if first, ok := s.links[id]; ok {
+					header.Typeflag, header.Linkname, header.Size = tar.TypeLink, first, 0
+				}
+			}
+		}
+	}
+
+	if info.IsDir() {
+		dir := &pendingDir{rel: rel, header: header, included: included}
+		s.dirStack = append(s.dirStack, dir)
+		if included {
+			return s.flushDirs()
+		}
+		return nil
+	}
+
+	if !included {
+		log.Debugf("Not matched by include filter: %s", rel)
+		s.excludedCount++
+		return nil
+	}
+
+	// Make sure the parent directories of an included entry are present
+	if err := s.flushDirs(); err != nil {
+		return err
+	}
+
+	// Files with holes are written as sparse entries, of their data only
+	if header.Typeflag == tar.TypeReg && s.opts.PreserveSparse && !s.opts.Deterministic {
+		written, err := s.visitSparse(path, rel, info, header)
+		if written && err == nil && linked {
+			s.addLink(id, rel)
+		}
+		if written || err != nil {
+			return err
+		}
+	}
+
+	// Write the header to the tar stream
+	if err := s.tw.WriteHeader(header); err != nil {
+		log.Error(fmt.Errorf("tar WriteHeader for %s: %w", rel, err))
+		return err
+	}
+
+	// For symlinks, special files and hard links, we're done after writing the header
+	if isSymlink || special != "" {
+		return nil
+	}
+	if header.Typeflag == tar.TypeLink {
+		if s.opts.RecordHashes {
+			s.hashes.addLink(rel, header.Linkname)
+		}
+		log.Debugf("Added to tar: %s (hard link to %s)", rel, header.Linkname)
+		return nil
+	}
+
+	// Open the file to copy its contents
+	f, err := os.Open(path)
+	if err != nil {
+		log.Error(fmt.Errorf("open file for tar %s: %w", path, err))
+		return err
+	}
+	defer f.Close()
 
+	// Copy the file data to the tar stream, hashing it if requested
+	var w io.Writer = s.tw
+	var h hash.Hash
+	if s.opts.RecordHashes {
+		h = sha256.New()
+		w = io.MultiWriter(s.tw, h)
+	}
+	n, err := io.Copy(w, f)
+	if err != nil {
+		log.Error(fmt.Errorf("io.Copy to tar for %s: %w", rel, err))
+		return err
+	}
+
+	s.fileCount++
+	s.totalBytes += n
+	log.Debugf("Added to tar: %s (%d bytes)", rel, n)
+	if h != nil {
+		s.hashes.add(rel, n, h)
+	}
+	if linked {
+		s.addLink(id, rel)
+	}
+
+	return nil
+}
+
+// addLink records the entry under which a file with several hard links was archived
+func (s *tarWalker) addLink(id fileID, rel string) {
+	if s.links == nil {
+		s.links = make(map[fileID]string)
+	}
+	s.links[id] = rel
+}
+
+// visitSparse writes a file with holes as a sparse entry, and reports whether
+// it did; files without holes are left to be written as regular entries
+func (s *tarWalker) visitSparse(path string, rel string, info os.FileInfo, header *tar.Header) (bool, error) {
+	log := s.log
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Error(fmt.Errorf("open file for tar %s: %w", path, err))
+		return false, err
+	}
+	defer f.Close()
+	fragments, ok := sparseFragments(f, info)
+	if !ok {
+		return false, nil
+	}
+	var h hash.Hash
+	if s.opts.RecordHashes {
+		h = sha256.New()
+	}
+	n, err := s.writeSparse(f, header, fragments, h)
+	if err != nil {
+		log.Error(fmt.Errorf("sparse entry for %s: %w", rel, err))
+		return true, err
+	}
+	if h != nil {
+		s.hashes.add(rel, header.Size, h)
+	}
+	s.fileCount++
+	s.totalBytes += n
+	log.Debugf("Added to tar: %s (%d bytes of data in %d fragments, %d bytes with holes)", rel, n, len(fragments), header.Size)
+	return true, nil
+}
+
+// flushDirs writes the headers of directories on the current walk path that have not yet been written
+func (s *tarWalker) flushDirs() error {
+	for _, dir := range s.dirStack {
+		if dir.written {
+			continue
+		}
+		if err := s.tw.WriteHeader(dir.header); err != nil {
+			s.log.Error(fmt.Errorf("tar WriteHeader for %s: %w", dir.rel, err))
+			return err
+		}
+		dir.written = true
+	}
+	return nil
+}
+
+// buildHeader creates the tar header for an entry, applying the ownership and xattr options
+func (s *tarWalker) buildHeader(path string, rel string, info os.FileInfo, isSymlink bool) (*tar.Header, error) {
+	log := s.log
+
+	// Determine the link target for symlinks
+	link := ""
+	if isSymlink {
+		var err error
+		link, err = os.Readlink(path)
 		if err != nil {
-			log.Error(fmt.Errorf("error during directory serialization: %w", err))
-			pw.CloseWithError(fmt.Errorf("error during directory serialization: %w", err))
-			return
+			log.Error(fmt.Errorf("readlink %s: %w", path, err))
+			return nil, err
 		}
+		link = archiveEntryName(link)
+	}
 
-		log.Debugf("Directory serialization complete: %d files, %d bytes", fileCount, totalBytes)
-	}()
+	// Create a tar header
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		log.Error(fmt.Errorf("tar FileInfoHeader for %s: %w", path, err))
+		return nil, err
+	}
+	header.Name = rel
+	if info.IsDir() {
+		header.Name += "/"
+	}
 
-	return pr, nil
+	// Strip ownership unless it was requested
+	if !s.opts.PreserveOwnership || s.opts.Deterministic {
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+	}
+
+	// Record extended attributes as PAX records
+	if s.opts.PreserveXattrs {
+		xattrs, err := readXattrs(path)
+		if err != nil {
+			log.Infof("Warning: cannot read extended attributes of %s: %v", rel, err)
+		}
+		for name, value := range xattrs {
+			if header.PAXRecords == nil {
+				header.PAXRecords = make(map[string]string)
+			}
+			header.PAXRecords[xattrPAXPrefix+name] = string(value)
+		}
+		if len(xattrs) > 0 {
+			header.Format = tar.FormatPAX
+		}
+	}
+
+	// Normalize timestamps so the stream depends only on names, modes and contents
+	if s.opts.Deterministic {
+		header.ModTime = deterministicModTime
+		header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	}
+
+	return header, nil
 }
 
 // DeserializeDirectoryFromStream takes a tar stream and extracts its contents
 // to the specified output directory. It returns errors encountered during extraction.
-func DeserializeDirectoryFromStream(ctx context.Context, outputDir string, r io.Reader, clearIfNotEmpty bool) error {
+// The opts control which recorded attributes beyond file contents are restored.
+func DeserializeDirectoryFromStream(ctx context.Context, outputDir string, r io.Reader, clearIfNotEmpty bool, opts DeserializeOptions) error {
 	log := trace.FromContext(ctx).WithPrefix("DESERIALIZE")
 	log.Debugf("Deserializing to directory: %s", outputDir)
 
+	if err := ValidatePatterns(opts.Files); err != nil {
+		return fmt.Errorf("invalid file pattern: %w", err)
+	}
+
 	// Ensure the output directory can be written to
 	if err := prepareOutputDirectory(ctx, outputDir, clearIfNotEmpty); err != nil {
 		log.Error(fmt.Errorf("failed to clear directory: %w", err))
@@ -130,13 +663,17 @@ func DeserializeDirectoryFromStream(ctx context.Context, outputDir string, r io.
 	// Read a small buffer to check if it looks like a tar file
 	// TAR files start with a 512-byte header
 	peekBuf := make([]byte, 512)
-	n, err := r.Read(peekBuf)
-	if err != nil && err != io.EOF {
+	n, err := io.ReadFull(r, peekBuf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		log.Error(fmt.Errorf("error reading from input stream: %w", err))
 		return fmt.Errorf("error reading from input stream: %w", err)
 	}
 
 	if n < 512 {
+		if opts.Strict {
+			log.Error(fmt.Errorf("%w: decoded stream is only %d bytes", ErrNotArchive, n))
+			return fmt.Errorf("%w: decoded stream is only %d bytes", ErrNotArchive, n)
+		}
 		log.Infof("Input data is small (%d bytes), treating as raw data", n)
 
 		// First, try to see if it looks like a gzip-compressed tar file (even if small)
@@ -172,8 +709,12 @@ func DeserializeDirectoryFromStream(ctx context.Context, outputDir string, r io.
 								break
 							}
 
-							// Get the output path
-							outPath := filepath.Join(outputDir, header.Name)
+							// Get the output path, refusing entries that point outside of it
+							outPath, err := safeJoin(outputDir, header.Name)
+							if err != nil {
+								log.Error(err)
+								return err
+							}
 
 							// Create parent directory
 							if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
@@ -257,13 +798,35 @@ func DeserializeDirectoryFromStream(ctx context.Context, outputDir string, r io.
 	combinedReader := io.MultiReader(bytes.NewReader(peekBuf[:n]), r)
 	tr := tar.NewReader(combinedReader)
 
+	// Entries are restored beneath the extended-length form of the output
+	// directory, so that deep paths can be restored on Windows, under names
+	// that are valid on this system
+	root := longPath(outputDir)
+	names := newNameRemapper(ctx)
+
 	fileCount := 0
 	totalBytes := int64(0)
+	restorer := newAttrRestorer(ctx, opts)
+	var skippedSpecial specialFiles
+
+	// Where each regular file was restored, for the hard links to it and
+	// for verifying the restore against the hash manifest
+	restored := make(map[string]string)
+	var manifest *HashManifest
+	unlinked := 0
+	var conflicts *conflictResolver
+	if opts.OnConflict != ConflictNone {
+		conflicts = newConflictResolver(ctx, opts.OnConflict, root)
+	}
 
 	// Iterate through tar entries
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
+			if fileCount == 0 && len(opts.Files) > 0 {
+				log.Error(fmt.Errorf("%w %v in the archive", ErrNoMatch, opts.Files))
+				return fmt.Errorf("%w %v in the archive", ErrNoMatch, opts.Files)
+			}
 			if fileCount == 0 {
 				log.Error(fmt.Errorf("no files found in tar archive"))
 				return fmt.Errorf("no files found in tar archive")
@@ -272,6 +835,9 @@ func DeserializeDirectoryFromStream(ctx context.Context, outputDir string, r io.
 		}
 		if err != nil {
 			log.Error(fmt.Errorf("tar header read error: %w", err))
+			if opts.Strict {
+				return fmt.Errorf("%w: tar header read error after %d file(s): %w", ErrNotArchive, fileCount, err)
+			}
 			// Create a sample file with the data we've seen
 			samplePath := filepath.Join(outputDir, "invalid_tar_sample.dat")
 			if err := os.WriteFile(samplePath, peekBuf[:n], 0644); err != nil {
@@ -279,19 +845,60 @@ func DeserializeDirectoryFromStream(ctx context.Context, outputDir string, r io.
 			} else {
 				log.Debugf("Wrote invalid tar sample to %s", samplePath)
 			}
-			return fmt.Errorf("tar header read error: %w", err)
+			return fmt.Errorf("%w: tar header read error: %w", ErrNotArchive, err)
 		}
 
-		// Get the full path for extraction
-		outPath := filepath.Join(outputDir, header.Name)
+		// The hash manifest verifies the restore rather than being restored
+		if IsHashManifest(header) {
+			if manifest, err = readHashManifest(tr); err != nil {
+				log.Infof("Warning: cannot verify the restored files: %v", err)
+			}
+			continue
+		}
+
+		// Restore only the selected entries
+		if len(opts.Files) > 0 && !matchPathOrParent(opts.Files, header.Name) {
+			continue
+		}
+
+		// Get the full path for extraction, refusing entries that would escape the output directory
+		outPath, err := safeJoin(root, names.localName(header.Name, header.Typeflag == tar.TypeDir))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		// Never write through a symlink that an earlier entry may have created
+		if err := checkNoSymlinkParents(root, outPath); err != nil {
+			log.Error(err)
+			return err
+		}
 
-		// Handle directory entries
+		// Handle directory entries, refusing one that is itself a symlink restored
+		// earlier, which creating it and applying its attributes would follow
 		if header.Typeflag == tar.TypeDir {
+			if info, err := os.Lstat(outPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				log.Error(fmt.Errorf("%w: refusing to extract directory %s through symlink", ErrUnsafePath, outPath))
+				return fmt.Errorf("%w: refusing to extract directory %s through symlink", ErrUnsafePath, outPath)
+			}
+			existed := false
+			if conflicts != nil {
+				if existed, err = conflicts.resolveDir(outPath); err != nil {
+					log.Error(err)
+					return err
+				}
+			}
 			log.Debugf("Creating directory: %s", outPath)
 			if err := os.MkdirAll(outPath, os.FileMode(header.Mode)); err != nil {
 				log.Error(fmt.Errorf("failed to create directory %s: %w", outPath, err))
 				return err
 			}
+			// Directory attributes are applied after extraction, since adding entries
+			// changes the modification time and a read-only mode would block writes.
+			// Existing directories are only modified when overwriting.
+			if !existed || opts.OnConflict == ConflictOverwrite {
+				restorer.deferDir(outPath, header)
+			}
 			continue
 		}
 
@@ -302,31 +909,268 @@ func DeserializeDirectoryFromStream(ctx context.Context, outputDir string, r io.
 			return err
 		}
 
-		// Create the file for writing
+		// Symlinks and special files that are not restored never conflict with existing files
+		if header.Typeflag == tar.TypeSymlink && !opts.RestoreSymlinks {
+			log.Debugf("Skipping symlink: %s -> %s", header.Name, header.Linkname)
+			continue
+		}
+		special := specialEntryKind(header)
+		if special != "" && !opts.RestoreSpecial {
+			log.Debugf("Skipping %s: %s", special, header.Name)
+			skippedSpecial.skip(special, header.Name)
+			continue
+		}
+
+		// Apply the conflict policy to entries that already exist
+		if conflicts != nil {
+			if outPath, err = conflicts.resolve(outPath); err != nil {
+				log.Error(err)
+				return err
+			}
+			if outPath == "" {
+				log.Debugf("Skipping existing: %s", header.Name)
+				continue
+			}
+		}
+
+		// Handle symlink entries
+		if header.Typeflag == tar.TypeSymlink {
+			log.Debugf("Creating symlink: %s -> %s", outPath, header.Linkname)
+			os.Remove(outPath)
+			if err := os.Symlink(filepath.FromSlash(header.Linkname), outPath); err != nil {
+				log.Error(fmt.Errorf("failed to create symlink %s: %w", outPath, err))
+				return err
+			}
+			restorer.apply(outPath, header)
+			fileCount++
+			continue
+		}
+
+		// Handle hard links to files restored earlier, copying them where the
+		// file system has no hard links
+		if header.Typeflag == tar.TypeLink {
+			target, ok := restored[header.Linkname]
+			if !ok {
+				log.Debugf("Cannot restore %s: hard link to %s, which was not restored", header.Name, header.Linkname)
+				unlinked++
+				continue
+			}
+			log.Debugf("Creating hard link: %s -> %s", outPath, target)
+			os.Remove(outPath)
+			if err := os.Link(target, outPath); err != nil {
+				log.Debugf("Cannot link %s, copying instead: %v", outPath, err)
+				if err := copyRestored(target, outPath, os.FileMode(header.Mode)); err != nil {
+					log.Error(fmt.Errorf("failed to restore %s: %w", outPath, err))
+					return err
+				}
+				restorer.apply(outPath, header)
+			}
+			restored[header.Name] = outPath
+			fileCount++
+			continue
+		}
+
+		// Handle FIFOs and device nodes, which have no contents
+		if special != "" {
+			log.Debugf("Creating %s: %s", special, outPath)
+			os.Remove(outPath)
+			if err := makeSpecial(outPath, header); err != nil {
+				log.Debugf("Cannot create %s %s: %v", special, outPath, err)
+				skippedSpecial.fail(special, header.Name)
+				continue
+			}
+			restorer.apply(outPath, header)
+			fileCount++
+			continue
+		}
+
+		// Create the file for writing, replacing whatever is there rather than
+		// writing through it, since an earlier entry may have left a symlink
+		// pointing outside the output directory
 		log.Debugf("Creating file: %s", outPath)
-		file, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
+		if info, err := os.Lstat(outPath); err == nil && !info.IsDir() {
+			os.Remove(outPath)
+		}
+		file, err := os.OpenFile(outPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(header.Mode))
 		if err != nil {
 			log.Error(fmt.Errorf("failed to create file %s: %w", outPath, err))
 			return err
 		}
 
-		// Copy file contents
-		n, err := io.Copy(file, tr)
+		// Copy file contents, restoring the holes of sparse files
+		var n int64
+		if isSparseEntry(header) {
+			hw := &holeWriter{f: file}
+			n, err = io.Copy(hw, tr)
+			if err == nil {
+				err = hw.Close()
+			}
+		} else {
+			n, err = io.Copy(file, tr)
+		}
 		file.Close()
 		if err != nil {
 			log.Error(fmt.Errorf("failed to write file %s: %w", outPath, err))
 			return err
 		}
+		restorer.apply(outPath, header)
+		restored[header.Name] = outPath
 
 		fileCount++
 		totalBytes += n
 		log.Debugf("Extracted: %s (%d bytes)", header.Name, n)
 	}
 
+	// Apply the deferred directory attributes now that all entries are in place
+	restorer.finish()
+	if conflicts != nil {
+		conflicts.summarize()
+	}
+	if unlinked > 0 {
+		log.Infof("Warning: %d hard links were not restored, since the files they link to were not (use -verbose for details)", unlinked)
+	}
+	skippedSpecial.summarize(log, "Skipped %d special files, which are restored only if requested:", "Warning: could not create %d special files:")
+	names.summarize()
+
 	log.Debugf("Directory deserialization complete: %d files, %d bytes", fileCount, totalBytes)
+
+	// Read back the restored files to check them against the hashes recorded
+	// when they were encoded
+	if manifest != nil {
+		return verifyHashes(ctx, manifest, restored)
+	}
 	return nil
 }
 
+// copyRestored copies a restored file to another path, in place of a hard link
+func copyRestored(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// safeJoin joins a tar entry name onto the output directory, rejecting names
+// that would resolve outside of it (absolute paths or ".." traversal).
+func safeJoin(outputDir string, name string) (string, error) {
+	return paddecode.SafeJoin(outputDir, name)
+}
+
+// checkNoSymlinkParents verifies that none of the existing directories between
+// root and path are symlinks, so that a crafted archive cannot use a previously
+// restored symlink to write outside of the output directory.
+func checkNoSymlinkParents(root string, path string) error {
+	return paddecode.CheckNoSymlinkParents(root, path)
+}
+
+// attrRestorer applies recorded tar attributes to restored files according to
+// DeserializeOptions. Failures to restore attributes are reported as warnings
+// rather than errors, since file contents are the primary concern of a restore.
+type attrRestorer struct {
+	ctx      context.Context
+	opts     DeserializeOptions
+	dirs     []*tar.Header
+	dirPaths []string
+	warnings int
+}
+
+// newAttrRestorer creates an attrRestorer for the given options
+func newAttrRestorer(ctx context.Context, opts DeserializeOptions) *attrRestorer {
+	return &attrRestorer{ctx: ctx, opts: opts}
+}
+
+// deferDir records a directory whose attributes are applied by finish
+func (ar *attrRestorer) deferDir(path string, header *tar.Header) {
+	ar.dirPaths = append(ar.dirPaths, path)
+	ar.dirs = append(ar.dirs, header)
+}
+
+// finish applies directory attributes deepest-first and logs a warning summary
+func (ar *attrRestorer) finish() {
+	for i := len(ar.dirs) - 1; i >= 0; i-- {
+		ar.apply(ar.dirPaths[i], ar.dirs[i])
+	}
+	if ar.warnings > 0 {
+		log := trace.FromContext(ar.ctx).WithPrefix("DESERIALIZE")
+		log.Infof("Warning: %d file attributes could not be restored (use -verbose for details)", ar.warnings)
+	}
+}
+
+// apply restores the requested attributes of a single entry
+func (ar *attrRestorer) apply(path string, header *tar.Header) {
+	log := trace.FromContext(ar.ctx).WithPrefix("DESERIALIZE")
+	isSymlink := header.Typeflag == tar.TypeSymlink
+
+	warn := func(what string, err error) {
+		ar.warnings++
+		log.Debugf("Cannot restore %s of %s: %v", what, path, err)
+	}
+
+	if ar.opts.RestoreOwnership {
+		if err := os.Lchown(path, header.Uid, header.Gid); err != nil {
+			warn("ownership", err)
+		}
+	}
+
+	if ar.opts.RestoreXattrs {
+		for key, value := range header.PAXRecords {
+			if !strings.HasPrefix(key, xattrPAXPrefix) {
+				continue
+			}
+			if err := writeXattr(path, strings.TrimPrefix(key, xattrPAXPrefix), []byte(value)); err != nil {
+				warn("extended attribute "+key, err)
+			}
+		}
+	}
+
+	// Permissions and times of symlinks themselves are not portable, so only
+	// apply them to the entries that they refer to directly
+	if isSymlink {
+		return
+	}
+
+	if ar.opts.RestorePermissions {
+		if err := os.Chmod(path, os.FileMode(header.Mode).Perm()|modeBits(header.Mode)); err != nil {
+			warn("permissions", err)
+		}
+	}
+
+	if ar.opts.RestoreModTimes && !header.ModTime.IsZero() {
+		atime := header.AccessTime
+		if atime.IsZero() {
+			atime = header.ModTime
+		}
+		if err := os.Chtimes(path, atime, header.ModTime); err != nil {
+			warn("modification time", err)
+		}
+	}
+}
+
+// modeBits converts the setuid/setgid/sticky bits of a tar mode into os.FileMode bits
+func modeBits(mode int64) os.FileMode {
+	var m os.FileMode
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
 // prepareOutputDirectory ensures the output directory is empty for deserialization
 func prepareOutputDirectory(ctx context.Context, dirPath string, clearIfNotEmpty bool) error {
 	log := trace.FromContext(ctx).WithPrefix("DESERIALIZE")
@@ -0,0 +1,632 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestSerializeDeserializeAttributes(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "serialize-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(filepath.Join(inputDir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+
+	// Create a file with a specific mode and modification time, plus a symlink to it
+	filePath := filepath.Join(inputDir, "sub", "secret.txt")
+	if err := os.WriteFile(filePath, []byte("secret content"), 0600); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	modTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set modification time: %v", err)
+	}
+	if err := os.Symlink("sub/secret.txt", filepath.Join(inputDir, "link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		serialize   SerializeOptions
+		deserialize DeserializeOptions
+		wantLink    bool
+		wantModTime bool
+	}{
+		{"Contents only", SerializeOptions{}, DeserializeOptions{}, false, false},
+		{"Symlinks not restored", SerializeOptions{PreserveSymlinks: true}, DeserializeOptions{}, false, false},
+		{"Full fidelity", SerializeOptions{PreserveSymlinks: true, PreserveOwnership: true},
+			DeserializeOptions{RestoreSymlinks: true, RestorePermissions: true, RestoreModTimes: true}, true, true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := SerializeDirectoryToStream(ctx, inputDir, tt.serialize)
+			if err != nil {
+				t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+			}
+			defer stream.Close()
+
+			outputDir := filepath.Join(tempDir, "output", string(rune('a'+i)))
+			if err := DeserializeDirectoryFromStream(ctx, outputDir, stream, false, tt.deserialize); err != nil {
+				t.Fatalf("DeserializeDirectoryFromStream failed: %v", err)
+			}
+
+			restored := filepath.Join(outputDir, "sub", "secret.txt")
+			data, err := os.ReadFile(restored)
+			if err != nil {
+				t.Fatalf("Failed to read restored file: %v", err)
+			}
+			if string(data) != "secret content" {
+				t.Errorf("Restored content mismatch: got %q", string(data))
+			}
+
+			info, err := os.Stat(restored)
+			if err != nil {
+				t.Fatalf("Failed to stat restored file: %v", err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+			}
+			if gotModTime := info.ModTime().Equal(modTime); gotModTime != tt.wantModTime {
+				t.Errorf("Modification time restored = %v, want %v (got %v)", gotModTime, tt.wantModTime, info.ModTime())
+			}
+
+			target, err := os.Readlink(filepath.Join(outputDir, "link"))
+			if tt.wantLink {
+				if err != nil {
+					t.Fatalf("Expected symlink to be restored: %v", err)
+				}
+				if target != "sub/secret.txt" {
+					t.Errorf("Expected symlink target sub/secret.txt, got %s", target)
+				}
+			} else if err == nil {
+				t.Errorf("Symlink should not have been restored")
+			}
+		})
+	}
+}
+
+func TestSerializeXattrs(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "xattr-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	filePath := filepath.Join(inputDir, "tagged.txt")
+	if err := os.WriteFile(filePath, []byte("tagged"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := writeXattr(filePath, "user.padlock.test", []byte("value")); err != nil {
+		t.Skipf("Extended attributes not supported here: %v", err)
+	}
+
+	stream, err := SerializeDirectoryToStream(ctx, inputDir, SerializeOptions{PreserveXattrs: true})
+	if err != nil {
+		t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	outputDir := filepath.Join(tempDir, "output")
+	if err := DeserializeDirectoryFromStream(ctx, outputDir, stream, false, DeserializeOptions{RestoreXattrs: true}); err != nil {
+		t.Fatalf("DeserializeDirectoryFromStream failed: %v", err)
+	}
+
+	xattrs, err := readXattrs(filepath.Join(outputDir, "tagged.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read restored xattrs: %v", err)
+	}
+	if string(xattrs["user.padlock.test"]) != "value" {
+		t.Errorf("Expected xattr value %q, got %q", "value", string(xattrs["user.padlock.test"]))
+	}
+}
+
+func TestDeserializeRejectsTraversal(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "traversal-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A directory outside the output directory, which no entry may create
+	// directories in or change the mode of
+	outside := filepath.Join(tempDir, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		entries []*tar.Header
+	}{
+		{"Dot-dot entry", []*tar.Header{
+			{Name: "../escape.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		}},
+		{"Write through symlink", []*tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: tempDir, Mode: 0777},
+			{Name: "link/escape.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		}},
+		{"Directory through symlink", []*tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+			{Name: "link/", Typeflag: tar.TypeDir, Mode: 0700},
+			{Name: "link/created/", Typeflag: tar.TypeDir, Mode: 0700},
+		}},
+		{"Directory beneath symlink", []*tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+			{Name: "link/created/", Typeflag: tar.TypeDir, Mode: 0700},
+		}},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Build a crafted tar archive, padded past the small-input heuristics
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, hdr := range tt.entries {
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Fatalf("Failed to write tar header: %v", err)
+				}
+				if hdr.Size > 0 {
+					tw.Write([]byte("evil"))
+				}
+			}
+			tw.Close()
+
+			outputDir := filepath.Join(tempDir, "output", string(rune('a'+i)))
+			err := DeserializeDirectoryFromStream(ctx, outputDir, &buf, false, DeserializeOptions{RestoreSymlinks: true, RestorePermissions: true, RestoreModTimes: true})
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("Expected crafted archive to be rejected as unsafe, got %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(tempDir, "escape.txt")); err == nil {
+				t.Errorf("File was written outside of the output directory")
+			}
+			if _, err := os.Stat(filepath.Join(outside, "created")); err == nil {
+				t.Errorf("Directory was created outside of the output directory")
+			}
+			if info, err := os.Stat(outside); err != nil || info.Mode().Perm() != 0755 {
+				t.Errorf("Expected the mode of the outside directory to be unchanged, got %v (%v)", info.Mode().Perm(), err)
+			}
+		})
+	}
+}
+
+func TestDeserializeReplacesSymlinkWithFile(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "replace-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	outsideFile := filepath.Join(tempDir, "outside.txt")
+	if err := os.WriteFile(outsideFile, []byte("original"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	// A symlink pointing outside, followed by a file of the same name
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: outsideFile, Mode: 0777})
+	tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeReg, Mode: 0644, Size: 5})
+	tw.Write([]byte("PWNED"))
+	tw.Close()
+
+	outputDir := filepath.Join(tempDir, "output")
+	if err := DeserializeDirectoryFromStream(ctx, outputDir, &buf, false, DeserializeOptions{RestoreSymlinks: true, RestorePermissions: true, RestoreModTimes: true}); err != nil {
+		t.Fatalf("DeserializeDirectoryFromStream failed: %v", err)
+	}
+	if data, err := os.ReadFile(outsideFile); err != nil || string(data) != "original" {
+		t.Errorf("Expected the file outside the output directory to be unchanged, got %q (%v)", data, err)
+	}
+	info, err := os.Lstat(filepath.Join(outputDir, "a"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("Expected the symlink to be replaced by a regular file, got %v (%v)", info, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outputDir, "a")); string(data) != "PWNED" {
+		t.Errorf("Expected the file to hold the contents of its entry, got %q", data)
+	}
+}
+
+func TestSerializeFollowSymlinks(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "follow-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// An external directory reached through a symlink, plus a cycle back to the root
+	externalDir := filepath.Join(tempDir, "external")
+	inputDir := filepath.Join(tempDir, "input")
+	for _, dir := range []string{externalDir, filepath.Join(inputDir, "sub")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(externalDir, "data.txt"), []byte("external"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	links := map[string]string{
+		filepath.Join(inputDir, "ext"):         externalDir,
+		filepath.Join(inputDir, "sub", "loop"): inputDir,
+		filepath.Join(inputDir, "dangling"):    filepath.Join(tempDir, "missing"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	stream, err := SerializeDirectoryToStream(ctx, inputDir, SerializeOptions{FollowSymlinks: true, PreserveSymlinks: true})
+	if err != nil {
+		t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	entries := make(map[string]byte)
+	tr := tar.NewReader(stream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar stream: %v", err)
+		}
+		entries[header.Name] = header.Typeflag
+	}
+
+	if entries["ext/"] != tar.TypeDir {
+		t.Errorf("Expected followed symlink ext/ to be a directory entry, got %v", entries)
+	}
+	if entries["ext/data.txt"] != tar.TypeReg {
+		t.Errorf("Expected ext/data.txt to be archived as a regular file, got %v", entries)
+	}
+	if _, found := entries["sub/loop/"]; found {
+		t.Errorf("Symlink cycle sub/loop should have been skipped")
+	}
+	if entries["dangling"] != tar.TypeSymlink {
+		t.Errorf("Expected dangling symlink to be kept as a link, got %v", entries)
+	}
+}
+
+func TestSerializeDeterministic(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "deterministic-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Build two identical trees whose files have different modification times
+	serialize := func(name string, modTime time.Time) []byte {
+		inputDir := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Join(inputDir, "sub"), 0755); err != nil {
+			t.Fatalf("Failed to create input dir: %v", err)
+		}
+		for _, rel := range []string{"b.txt", "a.txt", "sub/c.txt"} {
+			filePath := filepath.Join(inputDir, filepath.FromSlash(rel))
+			if err := os.WriteFile(filePath, []byte("content of "+rel), 0644); err != nil {
+				t.Fatalf("Failed to create test file: %v", err)
+			}
+			if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+				t.Fatalf("Failed to set modification time: %v", err)
+			}
+		}
+
+		stream, err := SerializeDirectoryToStream(ctx, inputDir, SerializeOptions{PreserveOwnership: true, Deterministic: true})
+		if err != nil {
+			t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+		}
+		defer stream.Close()
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatalf("Failed to read tar stream: %v", err)
+		}
+		return data
+	}
+
+	first := serialize("first", time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC))
+	second := serialize("second", time.Date(2022, 7, 8, 9, 10, 11, 0, time.UTC))
+	if !bytes.Equal(first, second) {
+		t.Errorf("Deterministic streams differ (%d vs %d bytes)", len(first), len(second))
+	}
+}
+
+func TestDeserializeStrict(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "strict-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"Small raw stream", []byte("not a tar archive")},
+		{"Large raw stream", bytes.Repeat([]byte("not a tar archive\n"), 100)},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Without strict, the data is saved as it is
+			outputDir := filepath.Join(tempDir, "lenient", string(rune('a'+i)))
+			DeserializeDirectoryFromStream(ctx, outputDir, bytes.NewReader(tt.input), false, DeserializeOptions{})
+			entries, _ := os.ReadDir(outputDir)
+			if len(entries) == 0 {
+				t.Errorf("Expected the raw data to be saved without strict")
+			}
+
+			// With strict, it is an error and nothing is written
+			outputDir = filepath.Join(tempDir, "strict", string(rune('a'+i)))
+			err := DeserializeDirectoryFromStream(ctx, outputDir, bytes.NewReader(tt.input), false, DeserializeOptions{Strict: true})
+			if !errors.Is(err, ErrNotArchive) {
+				t.Errorf("Expected strict deserialize to fail with %v, got %v", ErrNotArchive, err)
+			}
+			entries, _ = os.ReadDir(outputDir)
+			if len(entries) != 0 {
+				t.Errorf("Expected nothing to be written in strict mode, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+// FuzzDeserializeDirectoryFromStream checks that untrusted decoded streams are
+// restored without panicking, and never write outside of the output directory
+func FuzzDeserializeDirectoryFromStream(f *testing.F) {
+	// The fuzzer restores many streams a second, so nothing is logged
+	tracer := trace.NewTracer("TEST", trace.LogLevelError)
+	tracer.SetSinks()
+	ctx := trace.WithContext(context.Background(), tracer)
+
+	tarOf := func(entries ...*tar.Header) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range entries {
+			tw.WriteHeader(hdr)
+			tw.Write(bytes.Repeat([]byte("x"), int(hdr.Size)))
+		}
+		tw.Close()
+		return buf.Bytes()
+	}
+	gzipOf := func(data []byte) []byte {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write(data)
+		gw.Close()
+		return buf.Bytes()
+	}
+	f.Add(tarOf(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "dir/file.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 5}))
+	f.Add(tarOf(&tar.Header{Name: "../escape.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}))
+	f.Add(tarOf(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "..", Mode: 0777},
+		&tar.Header{Name: "link/escape.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}))
+	f.Add(gzipOf(tarOf(&tar.Header{Name: "../escape.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4})))
+	f.Add([]byte("small text input"))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parent := t.TempDir()
+		outputDir := filepath.Join(parent, "output")
+		DeserializeDirectoryFromStream(ctx, outputDir, bytes.NewReader(data), false, DeserializeOptions{RestoreSymlinks: true})
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", parent, err)
+		}
+		for _, e := range entries {
+			if e.Name() != "output" {
+				t.Errorf("Restoring wrote %s outside of the output directory", e.Name())
+			}
+		}
+	})
+}
+
+func TestSerializeInputs(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir := t.TempDir()
+	docs := filepath.Join(tempDir, "docs")
+	if err := os.MkdirAll(filepath.Join(docs, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	for _, rel := range []string{"docs/a.txt", "docs/sub/b.txt", "docs/skip.tmp", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(tempDir, filepath.FromSlash(rel)), []byte("content of "+rel), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	inputs := []string{docs, filepath.Join(tempDir, "notes.txt")}
+
+	t.Run("Names", func(t *testing.T) {
+		stream, err := SerializeInputsToStream(ctx, inputs, SerializeOptions{Exclude: []string{"*.tmp"}})
+		if err != nil {
+			t.Fatalf("SerializeInputsToStream failed: %v", err)
+		}
+		defer stream.Close()
+		var names []string
+		tr := tar.NewReader(stream)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to read tar stream: %v", err)
+			}
+			names = append(names, header.Name)
+		}
+		want := []string{"docs/", "docs/a.txt", "docs/sub/", "docs/sub/b.txt", "notes.txt"}
+		if strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Errorf("Expected entries %q, got %q", want, names)
+		}
+	})
+
+	t.Run("Restored side by side", func(t *testing.T) {
+		stream, err := SerializeInputsToStream(ctx, inputs, SerializeOptions{})
+		if err != nil {
+			t.Fatalf("SerializeInputsToStream failed: %v", err)
+		}
+		defer stream.Close()
+		outputDir := filepath.Join(t.TempDir(), "out")
+		if err := DeserializeDirectoryFromStream(ctx, outputDir, stream, false, DeserializeOptions{}); err != nil {
+			t.Fatalf("DeserializeDirectoryFromStream failed: %v", err)
+		}
+		for _, rel := range []string{"docs/sub/b.txt", "notes.txt"} {
+			data, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(rel)))
+			if err != nil || string(data) != "content of "+rel {
+				t.Errorf("Restored %s = %q, %v", rel, data, err)
+			}
+		}
+	})
+
+	t.Run("Same name", func(t *testing.T) {
+		other := filepath.Join(tempDir, "other", "docs")
+		if err := os.MkdirAll(other, 0755); err != nil {
+			t.Fatalf("Failed to create input dir: %v", err)
+		}
+		if _, err := SerializeInputsToStream(ctx, []string{docs, other}, SerializeOptions{}); err == nil {
+			t.Errorf("Expected inputs with the same name to be rejected")
+		}
+		if err := ValidateInputs(ctx, []string{docs, filepath.Join(tempDir, "missing")}); err == nil {
+			t.Errorf("Expected a missing input to be rejected")
+		}
+	})
+}
+
+func TestSerializeHardlinks(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	inputDir := filepath.Join(t.TempDir(), "input")
+	if err := os.MkdirAll(filepath.Join(inputDir, "objects"), 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	original := filepath.Join(inputDir, "a.txt")
+	if err := os.WriteFile(original, []byte("shared contents"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "objects", "c.txt"), []byte("other contents"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Link(original, filepath.Join(inputDir, "objects", "b.txt")); err != nil {
+		t.Skipf("Hard links not supported here: %v", err)
+	}
+	if info, err := os.Stat(original); err != nil {
+		t.Fatalf("Failed to stat test file: %v", err)
+	} else if _, ok := linkCount(info); !ok {
+		t.Skip("Hard links are not detected on this platform")
+	}
+
+	// restore serializes the input and restores it into a new directory
+	restore := func(t *testing.T, preserve bool, opts DeserializeOptions) string {
+		stream, err := SerializeDirectoryToStream(ctx, inputDir, SerializeOptions{PreserveHardlinks: preserve})
+		if err != nil {
+			t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+		}
+		defer stream.Close()
+		outputDir := filepath.Join(t.TempDir(), "output")
+		if err := DeserializeDirectoryFromStream(ctx, outputDir, stream, false, opts); err != nil {
+			t.Fatalf("DeserializeDirectoryFromStream failed: %v", err)
+		}
+		return outputDir
+	}
+
+	t.Run("Linked", func(t *testing.T) {
+		outputDir := restore(t, true, DeserializeOptions{})
+		a, errA := os.Stat(filepath.Join(outputDir, "a.txt"))
+		b, errB := os.Stat(filepath.Join(outputDir, "objects", "b.txt"))
+		if errA != nil || errB != nil || !os.SameFile(a, b) {
+			t.Errorf("Expected the files to be restored as hard links (%v, %v)", errA, errB)
+		}
+		if data, err := os.ReadFile(filepath.Join(outputDir, "objects", "b.txt")); err != nil || string(data) != "shared contents" {
+			t.Errorf("Restored link = %q, %v", data, err)
+		}
+	})
+
+	t.Run("Duplicated", func(t *testing.T) {
+		outputDir := restore(t, false, DeserializeOptions{})
+		a, errA := os.Stat(filepath.Join(outputDir, "a.txt"))
+		b, errB := os.Stat(filepath.Join(outputDir, "objects", "b.txt"))
+		if errA != nil || errB != nil || os.SameFile(a, b) {
+			t.Errorf("Expected separate copies without PreserveHardlinks (%v, %v)", errA, errB)
+		}
+	})
+
+	t.Run("Target not selected", func(t *testing.T) {
+		outputDir := restore(t, true, DeserializeOptions{Files: []string{"objects"}})
+		if _, err := os.Stat(filepath.Join(outputDir, "objects", "b.txt")); !os.IsNotExist(err) {
+			t.Errorf("Expected a link to an unrestored file to be skipped, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(outputDir, "objects", "c.txt")); err != nil {
+			t.Errorf("Expected the other selected file to be restored, got %v", err)
+		}
+	})
+
+	t.Run("Stdout", func(t *testing.T) {
+		stream, err := SerializeDirectoryToStream(ctx, inputDir, SerializeOptions{PreserveHardlinks: true})
+		if err != nil {
+			t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+		}
+		defer stream.Close()
+		var out bytes.Buffer
+		if err := ExtractFilesToWriter(ctx, stream, &out, nil); err != nil {
+			t.Fatalf("ExtractFilesToWriter failed: %v", err)
+		}
+		if out.String() != "shared contentsother contents" {
+			t.Errorf("Expected the contents to be written once, got %q", out.String())
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package file
+
+import (
+	"context"
+	"io"
+)
+
+// Serializer turns a directory into the stream that the threshold scheme
+// splits, and restores a decoded stream into a directory. Tar is the default,
+// as TarSerializer; embedders may plug in their own, such as cpio or a
+// database dump, and reuse the rest of the pipeline unchanged. The stream is
+// compressed and encrypted after it is serialized, so a serializer only needs
+// to be able to read back what it wrote.
+type Serializer interface {
+	// SerializeToStream returns a stream of inputDir, which the caller closes
+	SerializeToStream(ctx context.Context, inputDir string, opts SerializeOptions) (io.ReadCloser, error)
+
+	// DeserializeFromStream restores a stream written by SerializeToStream
+	// into outputDir, clearing it first if requested and it is not empty.
+	// It should read r to the end, so that the decoder writing it is never blocked.
+	DeserializeFromStream(ctx context.Context, outputDir string, r io.Reader, clearIfNotEmpty bool, opts DeserializeOptions) error
+}
+
+// TarSerializer is the default Serializer, writing and restoring tar streams
+// with SerializeDirectoryToStream and DeserializeDirectoryFromStream
+type TarSerializer struct{}
+
+// SerializeToStream implements Serializer
+func (TarSerializer) SerializeToStream(ctx context.Context, inputDir string, opts SerializeOptions) (io.ReadCloser, error) {
+	return SerializeDirectoryToStream(ctx, inputDir, opts)
+}
+
+// DeserializeFromStream implements Serializer
+func (TarSerializer) DeserializeFromStream(ctx context.Context, outputDir string, r io.Reader, clearIfNotEmpty bool, opts DeserializeOptions) error {
+	return DeserializeDirectoryFromStream(ctx, outputDir, r, clearIfNotEmpty, opts)
+}
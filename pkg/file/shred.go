@@ -0,0 +1,156 @@
+package file
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// ShredFiles overwrites each regular file of dir listed in the hash manifest
+// with random data, syncs it and removes it, then removes the directories left
+// empty, returning the number of files shredded. Entries that are not regular
+// files, such as symlinks, are left alone, as are files the manifest does not
+// list. A file with hard links the manifest does not list, which were not
+// encoded, is only removed, since overwriting it would destroy their contents
+// too; one whose links are all listed is overwritten once. Overwriting in place cannot reach copies the filesystem or the device
+// keeps elsewhere, such as those of SSDs, copy-on-write filesystems and snapshots.
+func ShredFiles(ctx context.Context, dir string, manifest *HashManifest) (int, error) {
+	log := trace.FromContext(ctx).WithPrefix("SHRED")
+
+	var paths []string
+	seen := make(map[string]bool)
+	for _, fh := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(fh.Path)) {
+			log.Error(fmt.Errorf("refusing to shred a file outside %s: %s", dir, fh.Path))
+			return 0, fmt.Errorf("refusing to shred a file outside %s: %s", dir, fh.Path)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(fh.Path))
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	// Count the links to each file that are listed, to tell whether any of
+	// its links are not
+	infos := make(map[string]os.FileInfo, len(paths))
+	listed := make(map[fileID]uint64)
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Error(fmt.Errorf("failed to shred %s: %w", path, err))
+			return 0, fmt.Errorf("failed to shred %s: %w", path, err)
+		}
+		infos[path] = info
+		if id, ok := shredID(info); ok {
+			listed[id]++
+		}
+	}
+
+	shredded := 0
+	overwritten := make(map[fileID]bool)
+	parents := make(map[string]bool)
+	for _, path := range paths {
+		info, ok := infos[path]
+		if !ok {
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			log.Debugf("Not shredding %s, which is not a regular file", path)
+			continue
+		}
+		id, linked := shredID(info)
+		nlink, _ := linkCount(info)
+		switch {
+		case linked && listed[id] < nlink:
+			log.Infof("Removing %s without overwriting it, as it has hard links outside those being shredded", path)
+			if err := os.Remove(path); err != nil {
+				log.Error(fmt.Errorf("failed to remove %s: %w", path, err))
+				return shredded, fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+		case linked && overwritten[id]:
+			// Another of its names was overwritten already
+			if err := os.Remove(path); err != nil {
+				log.Error(fmt.Errorf("failed to remove %s: %w", path, err))
+				return shredded, fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			log.Debugf("Removed: %s", path)
+		default:
+			if err := shredFile(path, info); err != nil {
+				log.Error(fmt.Errorf("failed to shred %s: %w", path, err))
+				return shredded, fmt.Errorf("failed to shred %s: %w", path, err)
+			}
+			log.Debugf("Shredded: %s", path)
+			shredded++
+			if linked {
+				overwritten[id] = true
+			}
+		}
+		for parent := filepath.Dir(path); parent != filepath.Clean(dir) && strings.HasPrefix(parent, filepath.Clean(dir)); parent = filepath.Dir(parent) {
+			parents[parent] = true
+		}
+	}
+
+	// Remove the directories emptied, deepest first; those still holding
+	// entries that were not shredded remain
+	var dirs []string
+	for parent := range parents {
+		dirs = append(dirs, parent)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, d := range dirs {
+		if err := os.Remove(d); err != nil {
+			log.Debugf("Leaving directory %s: %v", d, err)
+		}
+	}
+	return shredded, nil
+}
+
+// shredFile overwrites a file with random data of its size, syncs it to the
+// device and removes it. A read-only file is made writable to overwrite it.
+func shredFile(path string, info os.FileInfo) error {
+	if info.Mode().Perm()&0200 == 0 {
+		if err := os.Chmod(path, info.Mode().Perm()|0200); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, rand.Reader, info.Size()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// shredID returns the identity of a regular file with several hard links, and
+// whether it has several
+func shredID(info os.FileInfo) (fileID, bool) {
+	var id fileID
+	nlink, ok := linkCount(info)
+	if !ok || nlink < 2 || !info.Mode().IsRegular() {
+		return id, false
+	}
+	id.dev, id.ino, ok = fileIdentity(info)
+	return id, ok
+}
@@ -0,0 +1,87 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/audit"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestShredFiles(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.txt":          "secret a",
+		"sub/b.txt":      "secret b",
+		"sub/deep/c.txt": "secret c",
+		"kept/d.txt":     "not encoded",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(dir, "a.txt"), 0444); err != nil {
+		t.Fatalf("Failed to make a file read-only: %v", err)
+	}
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	if err := os.WriteFile(outside, []byte("elsewhere"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	// A file hard linked from outside the directory, and one whose links
+	// are both in it
+	outsideLink := filepath.Join(filepath.Dir(outside), "hardlink.txt")
+	for _, link := range [][2]string{
+		{filepath.Join(dir, "shared.txt"), outsideLink},
+		{filepath.Join(dir, "pair1.txt"), filepath.Join(dir, "sub", "pair2.txt")},
+	} {
+		if err := os.WriteFile(link[0], []byte("linked secret"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		if err := os.Link(link[0], link[1]); err != nil {
+			t.Skipf("Hard links are not supported: %v", err)
+		}
+	}
+
+	manifest := &HashManifest{Files: []audit.FileHash{
+		{Path: "a.txt"}, {Path: "sub/b.txt"}, {Path: "sub/deep/c.txt"}, {Path: "link"},
+		{Path: "shared.txt"}, {Path: "pair1.txt"}, {Path: "sub/pair2.txt"},
+	}}
+	n, err := ShredFiles(ctx, dir, manifest)
+	if err != nil {
+		t.Fatalf("ShredFiles failed: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Expected 4 files shredded, got %d", n)
+	}
+	if data, err := os.ReadFile(outsideLink); err != nil || string(data) != "linked secret" {
+		t.Errorf("Expected the hard link outside the directory to be untouched, got %q, %v", data, err)
+	}
+	for _, name := range []string{"a.txt", "sub", "shared.txt", "pair1.txt"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"kept/d.txt", "link"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to be left in place, got %v", name, err)
+		}
+	}
+	if data, err := os.ReadFile(outside); err != nil || string(data) != "elsewhere" {
+		t.Errorf("Expected the target of the symlink to be untouched, got %q, %v", data, err)
+	}
+
+	if _, err := ShredFiles(ctx, dir, &HashManifest{Files: []audit.FileHash{{Path: "../outside.txt"}}}); err == nil {
+		t.Errorf("Expected a path outside the directory to be refused")
+	}
+}
@@ -0,0 +1,76 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// Snapshot is one encode appended to a set of collections, decodable on its
+// own. Its chunks are numbered from 1 within the snapshot, and stored in the
+// collection under the numbers FirstChunk to LastChunk.
+type Snapshot struct {
+	Name       string    `json:"name"`       // Name the snapshot was encoded under
+	Created    time.Time `json:"created"`    // When the snapshot was encoded
+	FirstChunk int       `json:"firstChunk"` // Number of the file holding the first chunk of the snapshot
+	LastChunk  int       `json:"lastChunk"`  // Number of the file holding the last chunk of the snapshot
+}
+
+// snapshotKey is the context key of the name of the snapshot being read
+type snapshotKey struct{}
+
+// WithSnapshot returns a context under which the chunks of collections holding
+// snapshots are read from the named snapshot, rather than the latest
+func WithSnapshot(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, name)
+}
+
+// snapshotFromContext returns the name of the snapshot to read, if one is named
+func snapshotFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(snapshotKey{}).(string)
+	return name
+}
+
+// ReadSnapshots returns the snapshots recorded in the manifest of a
+// collection, oldest first, or nil if it holds a single encode
+func ReadSnapshots(ctx context.Context, coll Collection) ([]Snapshot, error) {
+	switch {
+	case coll.Store || len(coll.Volumes) > 0:
+		return nil, nil
+	case coll.archive != nil:
+		if !coll.archive.has(ManifestFileName) {
+			return nil, nil
+		}
+		data, err := coll.archive.readFile(ManifestFileName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest of %s: %w", coll.Path, err)
+		}
+		m, err := parseManifest(filepath.Join(coll.Path, ManifestFileName), data)
+		if err != nil {
+			return nil, err
+		}
+		return m.Snapshots, nil
+	}
+	m, err := ReadManifest(ctx, coll.Path)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	return m.Snapshots, nil
+}
+
+// FindSnapshot returns the named snapshot, or the latest if name is empty
+func FindSnapshot(snapshots []Snapshot, name string) (Snapshot, error) {
+	if len(snapshots) == 0 {
+		return Snapshot{}, fmt.Errorf("%w: no snapshots recorded", ErrSnapshotNotFound)
+	}
+	if name == "" {
+		return snapshots[len(snapshots)-1], nil
+	}
+	for _, s := range snapshots {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Snapshot{}, fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+}
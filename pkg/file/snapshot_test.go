@@ -0,0 +1,65 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestSnapshotChunkSource(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// A collection of two snapshots, the second missing its chunk 6
+	collPath := filepath.Join(t.TempDir(), "2A3")
+	if err := os.MkdirAll(collPath, 0755); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	for _, n := range []int{1, 2, 3, 4, 5, 7} {
+		name := fmt.Sprintf("2A3_%04d.bin", n)
+		if err := os.WriteFile(filepath.Join(collPath, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	err := WriteManifest(ctx, collPath, Manifest{Collection: "2A3", Snapshots: []Snapshot{
+		{Name: "initial", FirstChunk: 1, LastChunk: 3},
+		{Name: "second", FirstChunk: 4, LastChunk: 7},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	coll := Collection{Name: "2A3", Path: collPath, Format: FormatBin}
+
+	for _, tc := range []struct {
+		snapshot string
+		last     int
+		numbers  []int
+		first    string
+	}{
+		{"", 4, []int{1, 2, 4}, "2A3_0004.bin"},
+		{"second", 4, []int{1, 2, 4}, "2A3_0004.bin"},
+		{"initial", 3, []int{1, 2, 3}, "2A3_0001.bin"},
+	} {
+		source, err := NewChunkSource(WithSnapshot(ctx, tc.snapshot), coll)
+		if err != nil {
+			t.Fatalf("Snapshot %q: NewChunkSource failed: %v", tc.snapshot, err)
+		}
+		if source.LastChunk() != tc.last || !reflect.DeepEqual(source.ChunkNumbers(), tc.numbers) {
+			t.Errorf("Snapshot %q: expected chunks %v of %d, got %v of %d", tc.snapshot, tc.numbers, tc.last, source.ChunkNumbers(), source.LastChunk())
+		}
+		if data, err := source.ReadChunk(ctx, 1); err != nil || string(data) != tc.first {
+			t.Errorf("Snapshot %q: chunk 1 read %q (%v), expected %s", tc.snapshot, data, err, tc.first)
+		}
+	}
+
+	if _, err := NewChunkSource(WithSnapshot(ctx, "third"), coll); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Expected an unknown snapshot to fail with ErrSnapshotNotFound, got %v", err)
+	}
+}
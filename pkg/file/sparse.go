@@ -0,0 +1,263 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sparse files are archived in the GNU sparse format 1.0, which GNU tar, bsdtar
+// and Go's archive/tar all read: a PAX header records the real name and size,
+// and the entry's data begins with a map of the data fragments, followed by
+// the fragments themselves. archive/tar cannot write these entries, so they
+// are written as raw blocks between the entries of the tar.Writer.
+
+// sparseBlockSize is the size of a tar block
+const sparseBlockSize = 512
+
+// sparseMinSize is the smallest file checked for holes
+const sparseMinSize = 64 * 1024
+
+// holeBlockSize is the granularity at which runs of zeros are restored as holes
+const holeBlockSize = 4096
+
+// sparseFragment is a range of a sparse file that holds data
+type sparseFragment struct {
+	offset int64
+	length int64
+}
+
+// isSparseEntry reports whether a tar entry was archived as a sparse file
+func isSparseEntry(header *tar.Header) bool {
+	return header.Typeflag == tar.TypeGNUSparse || header.PAXRecords["GNU.sparse.major"] != ""
+}
+
+// writeSparse writes a regular file with holes as a sparse entry, reading only
+// its data fragments, and returns the number of data bytes written. The header
+// is that of the file as a regular entry. If h is not nil, the file's contents,
+// holes included, are hashed into it.
+func (s *tarWalker) writeSparse(f *os.File, header *tar.Header, fragments []sparseFragment, h hash.Hash) (int64, error) {
+	// The map lists the fragments, ending with an empty one at the end of the
+	// file if it ends in a hole, as GNU tar writes it
+	if last := fragments[len(fragments)-1]; last.offset+last.length < header.Size {
+		fragments = append(fragments, sparseFragment{offset: header.Size})
+	}
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(fragments))
+	var dataSize int64
+	for _, frag := range fragments {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", frag.offset, frag.length)
+		dataSize += frag.length
+	}
+	sparseMap.Write(make([]byte, blockPadding(int64(sparseMap.Len()))))
+	size := int64(sparseMap.Len()) + dataSize
+
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     header.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(header.Size, 10),
+		"mtime":               formatPAXTime(header.ModTime),
+	}
+	for k, v := range header.PAXRecords {
+		records[k] = v
+	}
+	if header.Uid != 0 || header.Gid != 0 {
+		records["uid"], records["gid"] = strconv.Itoa(header.Uid), strconv.Itoa(header.Gid)
+	}
+	if header.Uname != "" || header.Gname != "" {
+		records["uname"], records["gname"] = header.Uname, header.Gname
+	}
+	if size >= 1<<33 {
+		records["size"] = strconv.FormatInt(size, 10)
+	}
+	var pax bytes.Buffer
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pax.WriteString(formatPAXRecord(k, records[k]))
+	}
+
+	// Finish the previous entry, then write the PAX header, the entry's own
+	// header and its data directly to the stream
+	if err := s.tw.Flush(); err != nil {
+		return 0, err
+	}
+	dir, base := path.Split(header.Name)
+	mode := header.Mode & 07777
+	blocks := [][]byte{
+		ustarHeader(shortName(path.Join(dir, "PaxHeaders.0", base)), 'x', 0644, int64(pax.Len()), header.ModTime),
+		padBlocks(pax.Bytes()),
+		ustarHeader(shortName(path.Join(dir, "GNUSparseFile.0", base)), tar.TypeReg, mode, size, header.ModTime),
+		sparseMap.Bytes(),
+	}
+	for _, block := range blocks {
+		if _, err := s.w.Write(block); err != nil {
+			return 0, err
+		}
+	}
+	var w io.Writer = s.w
+	if h != nil {
+		w = io.MultiWriter(s.w, h)
+	}
+	pos := int64(0)
+	for _, frag := range fragments {
+		if h != nil {
+			io.CopyN(h, zeroReader{}, frag.offset-pos)
+		}
+		pos = frag.offset + frag.length
+		n, err := io.Copy(w, io.NewSectionReader(f, frag.offset, frag.length))
+		if err == nil && n != frag.length {
+			err = fmt.Errorf("file shrank while being archived")
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if _, err := s.w.Write(make([]byte, blockPadding(dataSize))); err != nil {
+		return 0, err
+	}
+	return dataSize, nil
+}
+
+// formatPAXRecord formats a PAX record, whose length includes its own digits
+func formatPAXRecord(k string, v string) string {
+	size := len(k) + len(v) + 3
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	if len(record) != size {
+		size = len(record)
+		record = strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	}
+	return record
+}
+
+// formatPAXTime formats a time as a PAX record value, in seconds with a fraction
+func formatPAXTime(t time.Time) string {
+	secs, nsecs := t.Unix(), t.Nanosecond()
+	if nsecs == 0 {
+		return strconv.FormatInt(secs, 10)
+	}
+	sign := ""
+	if secs < 0 {
+		sign, secs, nsecs = "-", -(secs + 1), 1e9-nsecs
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%d.%09d", sign, secs, nsecs), "0")
+}
+
+// ustarHeader formats a USTAR header block; the PAX records carry whatever
+// does not fit its fields, which are then left zero
+func ustarHeader(name string, typeflag byte, mode int64, size int64, modTime time.Time) []byte {
+	block := make([]byte, sparseBlockSize)
+	octal := func(field []byte, n int64) {
+		if n < 0 || len(strconv.FormatInt(n, 8)) > len(field)-1 {
+			n = 0
+		}
+		copy(field, fmt.Sprintf("%0*o", len(field)-1, n))
+	}
+	copy(block[0:100], name)
+	octal(block[100:108], mode)
+	octal(block[108:116], 0)
+	octal(block[116:124], 0)
+	octal(block[124:136], size)
+	octal(block[136:148], modTime.Unix())
+	block[156] = typeflag
+	copy(block[257:265], "ustar\x0000")
+	octal(block[329:337], 0)
+	octal(block[337:345], 0)
+
+	// The checksum is computed with the checksum field as spaces
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, c := range block {
+		sum += int64(c)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return block
+}
+
+// shortName fits a name into the 100 bytes of a USTAR name field, for the
+// entries whose real name is in their PAX records
+func shortName(name string) string {
+	if len(name) <= 100 {
+		return name
+	}
+	return name[len(name)-100:]
+}
+
+// padBlocks pads data to a whole number of tar blocks
+func padBlocks(data []byte) []byte {
+	return append(data, make([]byte, blockPadding(int64(len(data))))...)
+}
+
+// blockPadding returns the bytes needed to pad n bytes to a whole number of tar blocks
+func blockPadding(n int64) int64 {
+	return -n & (sparseBlockSize - 1)
+}
+
+// holeWriter writes a restored sparse file, seeking over blocks of zeros so
+// that they become holes again. Close sets the length of the file, in case it
+// ends in a hole.
+type holeWriter struct {
+	f   *os.File
+	pos int64
+}
+
+// Write implements io.Writer
+func (hw *holeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Split at block boundaries of the file
+		n := holeBlockSize - int(hw.pos%holeBlockSize)
+		if n > len(p) {
+			n = len(p)
+		}
+		block := p[:n]
+		if n == holeBlockSize && isZero(block) {
+			if _, err := hw.f.Seek(int64(n), io.SeekCurrent); err != nil {
+				return written, err
+			}
+		} else if _, err := hw.f.Write(block); err != nil {
+			return written, err
+		}
+		hw.pos += int64(n)
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close sets the length of the file, which a trailing hole does not extend
+func (hw *holeWriter) Close() error {
+	return hw.f.Truncate(hw.pos)
+}
+
+// zeroReader reads zeros, for hashing the holes of sparse files
+type zeroReader struct{}
+
+// Read implements io.Reader
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// isZero reports whether a block holds only zeros
+func isZero(block []byte) bool {
+	for _, c := range block {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
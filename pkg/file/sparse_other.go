@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package file
+
+import "os"
+
+// sparseFragments is not supported on this platform, where files are always
+// archived in full
+func sparseFragments(f *os.File, info os.FileInfo) ([]sparseFragment, bool) {
+	return nil, false
+}
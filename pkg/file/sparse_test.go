@@ -0,0 +1,129 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestSerializeSparse(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	const size = 4 << 20
+	inputDir := filepath.Join(t.TempDir(), "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	imagePath := filepath.Join(inputDir, "disk.img")
+	f, err := os.Create(imagePath)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	data := bytes.Repeat([]byte("padlock!"), 8192)
+	if _, err := f.WriteAt(data, 1<<20); err == nil {
+		err = f.Truncate(size)
+	}
+	f.Close()
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	expected := make([]byte, size)
+	copy(expected[1<<20:], data)
+
+	f, err = os.Open(imagePath)
+	if err != nil {
+		t.Fatalf("Failed to open test file: %v", err)
+	}
+	info, _ := f.Stat()
+	_, ok := sparseFragments(f, info)
+	f.Close()
+	if !ok {
+		t.Skip("Holes are not detected on this platform or file system")
+	}
+
+	// serialize returns the stream of the input
+	serialize := func(t *testing.T, opts SerializeOptions) []byte {
+		stream, err := SerializeDirectoryToStream(ctx, inputDir, opts)
+		if err != nil {
+			t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+		}
+		defer stream.Close()
+		archived, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		return archived
+	}
+
+	t.Run("Sparse entry", func(t *testing.T) {
+		archived := serialize(t, SerializeOptions{PreserveSparse: true})
+		if len(archived) >= size/2 {
+			t.Errorf("Expected only the data to be archived, got %d bytes", len(archived))
+		}
+		tr := tar.NewReader(bytes.NewReader(archived))
+		header, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Failed to read entry: %v", err)
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Failed to read contents: %v", err)
+		}
+		if header.Name != "disk.img" || header.Size != size || !bytes.Equal(contents, expected) {
+			t.Errorf("Expected disk.img of %d bytes, got %s of %d bytes (%d read)", size, header.Name, header.Size, len(contents))
+		}
+	})
+
+	t.Run("Full size", func(t *testing.T) {
+		for _, opts := range []SerializeOptions{{}, {PreserveSparse: true, Deterministic: true}} {
+			if archived := serialize(t, opts); len(archived) < size {
+				t.Errorf("Expected the full size to be archived with %+v, got %d bytes", opts, len(archived))
+			}
+		}
+	})
+
+	t.Run("Restored", func(t *testing.T) {
+		stream, err := SerializeDirectoryToStream(ctx, inputDir, SerializeOptions{PreserveSparse: true})
+		if err != nil {
+			t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+		}
+		defer stream.Close()
+		outputDir := filepath.Join(t.TempDir(), "output")
+		if err := DeserializeDirectoryFromStream(ctx, outputDir, stream, false, DeserializeOptions{}); err != nil {
+			t.Fatalf("DeserializeDirectoryFromStream failed: %v", err)
+		}
+		restored, err := os.ReadFile(filepath.Join(outputDir, "disk.img"))
+		if err != nil || !bytes.Equal(restored, expected) {
+			t.Errorf("Restored %d bytes that differ from the input (%v)", len(restored), err)
+		}
+	})
+
+	t.Run("Trailing hole", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "trailing")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		hw := &holeWriter{f: f}
+		if _, err := io.Copy(hw, bytes.NewReader(expected[:2<<20])); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		err = hw.Close()
+		f.Close()
+		if err != nil {
+			t.Fatalf("Failed to close: %v", err)
+		}
+		restored, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(restored, expected[:2<<20]) {
+			t.Errorf("Restored %d bytes that differ from those written (%v)", len(restored), err)
+		}
+	})
+}
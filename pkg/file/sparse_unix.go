@@ -0,0 +1,51 @@
+//go:build linux || darwin
+
+package file
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sparseFragments returns the data fragments of a file with holes, found with
+// SEEK_DATA and SEEK_HOLE, or false if it has none or they cannot be found.
+// Files whose allocated blocks cover their size are not searched.
+func sparseFragments(f *os.File, info os.FileInfo) ([]sparseFragment, bool) {
+	size := info.Size()
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || size < sparseMinSize || int64(st.Blocks)*512 >= size {
+		return nil, false
+	}
+
+	fd := int(f.Fd())
+	var fragments []sparseFragment
+	for offset := int64(0); offset < size; {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break // The rest of the file is a hole
+		}
+		if err != nil {
+			return nil, false
+		}
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, false
+		}
+		hole = min(hole, size)
+		fragments = append(fragments, sparseFragment{offset: data, length: hole - data})
+		offset = hole
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, false
+	}
+	if len(fragments) == 1 && fragments[0].length == size {
+		return nil, false
+	}
+	if len(fragments) == 0 {
+		fragments = []sparseFragment{{offset: size}}
+	}
+	return fragments, true
+}
@@ -0,0 +1,94 @@
+package file
+
+import (
+	"archive/tar"
+	"os"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// The kinds of special file, which have no contents to archive
+const (
+	specialSocket      = "socket"
+	specialFIFO        = "FIFO"
+	specialCharDevice  = "character device"
+	specialBlockDevice = "block device"
+)
+
+// maxSpecialListing limits how many special files are listed in the summary
+const maxSpecialListing = 10
+
+// specialFileKind returns the kind of special file of a mode, or "" for
+// regular files, directories and symlinks. Opening a FIFO blocks until a
+// writer appears and reading a device such as /dev/zero never ends, so
+// special files are never read like regular files.
+func specialFileKind(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSocket != 0:
+		return specialSocket
+	case mode&os.ModeNamedPipe != 0:
+		return specialFIFO
+	case mode&os.ModeCharDevice != 0:
+		return specialCharDevice
+	case mode&os.ModeDevice != 0:
+		return specialBlockDevice
+	}
+	return ""
+}
+
+// specialEntryKind returns the kind of special file of a tar entry, or "" if
+// it is not one
+func specialEntryKind(header *tar.Header) string {
+	switch header.Typeflag {
+	case tar.TypeFifo:
+		return specialFIFO
+	case tar.TypeChar:
+		return specialCharDevice
+	case tar.TypeBlock:
+		return specialBlockDevice
+	}
+	return ""
+}
+
+// specialRecordable reports whether a kind of special file can be recorded
+// in a tar stream; tar has no entry type for sockets, which are created by
+// the programs listening on them anyway
+func specialRecordable(kind string) bool {
+	return kind == specialFIFO || kind == specialCharDevice || kind == specialBlockDevice
+}
+
+// specialFiles records the special files skipped by a walk or a restore, for
+// the final summary
+type specialFiles struct {
+	skipped []string
+	failed  []string
+}
+
+// skip records a special file that was not archived or restored
+func (sf *specialFiles) skip(kind string, name string) {
+	sf.skipped = append(sf.skipped, name+" ("+kind+")")
+}
+
+// fail records a special file that could not be created
+func (sf *specialFiles) fail(kind string, name string) {
+	sf.failed = append(sf.failed, name+" ("+kind+")")
+}
+
+// summarize logs which special files were skipped, and which could not be created
+func (sf *specialFiles) summarize(log *trace.Tracer, skipped string, failed string) {
+	report := func(format string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		log.Infof(format, len(names))
+		for i, name := range names {
+			if i == maxSpecialListing {
+				log.Infof("  ... and %d more", len(names)-maxSpecialListing)
+				break
+			}
+			log.Infof("  - %s", name)
+		}
+	}
+	report(skipped, sf.skipped)
+	report(failed, sf.failed)
+}
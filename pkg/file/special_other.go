@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package file
+
+import (
+	"archive/tar"
+	"fmt"
+)
+
+// makeSpecial is not supported on this platform
+func makeSpecial(path string, header *tar.Header) error {
+	return fmt.Errorf("special files are not supported on this platform")
+}
@@ -0,0 +1,105 @@
+package file
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestSerializeSpecialFiles(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "file.txt"), []byte("contents"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := makeSpecial(filepath.Join(inputDir, "pipe"), &tar.Header{Typeflag: tar.TypeFifo, Mode: 0644}); err != nil {
+		t.Skipf("FIFOs not supported here: %v", err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(inputDir, "sock"))
+	if err != nil {
+		t.Skipf("Unix sockets not supported here: %v", err)
+	}
+	defer listener.Close()
+
+	// entries returns the names and types of the entries of a serialized input
+	entries := func(t *testing.T, opts SerializeOptions) map[string]byte {
+		stream, err := SerializeDirectoryToStream(ctx, inputDir, opts)
+		if err != nil {
+			t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+		}
+		defer stream.Close()
+		found := make(map[string]byte)
+		tr := tar.NewReader(stream)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to read tar stream: %v", err)
+			}
+			found[header.Name] = header.Typeflag
+		}
+		return found
+	}
+
+	t.Run("Skipped", func(t *testing.T) {
+		// Without special, the FIFO is never opened, which would block
+		found := entries(t, SerializeOptions{})
+		if len(found) != 1 || found["file.txt"] != tar.TypeReg {
+			t.Errorf("Expected only the regular file, got %v", found)
+		}
+	})
+
+	t.Run("Recorded", func(t *testing.T) {
+		found := entries(t, SerializeOptions{PreserveSpecial: true})
+		if found["pipe"] != tar.TypeFifo {
+			t.Errorf("Expected the FIFO to be recorded, got %v", found)
+		}
+		if _, ok := found["sock"]; ok {
+			t.Errorf("Expected the socket to be skipped")
+		}
+	})
+
+	t.Run("Excluded", func(t *testing.T) {
+		found := entries(t, SerializeOptions{PreserveSpecial: true, Include: []string{"file.txt"}})
+		if len(found) != 1 {
+			t.Errorf("Expected only the included file, got %v", found)
+		}
+	})
+
+	t.Run("Restored", func(t *testing.T) {
+		for _, restore := range []bool{false, true} {
+			stream, err := SerializeDirectoryToStream(ctx, inputDir, SerializeOptions{PreserveSpecial: true})
+			if err != nil {
+				t.Fatalf("SerializeDirectoryToStream failed: %v", err)
+			}
+			outputDir := filepath.Join(t.TempDir(), "output")
+			err = DeserializeDirectoryFromStream(ctx, outputDir, stream, false, DeserializeOptions{RestoreSpecial: restore})
+			stream.Close()
+			if err != nil {
+				t.Fatalf("DeserializeDirectoryFromStream failed: %v", err)
+			}
+			info, err := os.Lstat(filepath.Join(outputDir, "pipe"))
+			if restore && (err != nil || info.Mode()&os.ModeNamedPipe == 0) {
+				t.Errorf("Expected the FIFO to be recreated, got %v, %v", info, err)
+			}
+			if !restore && !os.IsNotExist(err) {
+				t.Errorf("Expected the FIFO to be skipped unless requested, got %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin
+
+package file
+
+import (
+	"archive/tar"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeSpecial creates the FIFO or device node recorded by a tar entry.
+// Creating a device node usually requires elevated privileges.
+func makeSpecial(path string, header *tar.Header) error {
+	mode := uint32(header.Mode) & 0777
+	switch header.Typeflag {
+	case tar.TypeFifo:
+		return unix.Mkfifo(path, mode)
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	default:
+		return fmt.Errorf("not a special file")
+	}
+	return unix.Mknod(path, mode, int(unix.Mkdev(uint32(header.Devmajor), uint32(header.Devminor))))
+}
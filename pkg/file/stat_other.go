@@ -0,0 +1,15 @@
+//go:build !unix
+
+package file
+
+import "os"
+
+// fileIdentity is not available on this platform
+func fileIdentity(info os.FileInfo) (dev uint64, ino uint64, ok bool) {
+	return 0, 0, false
+}
+
+// linkCount is not available on this platform
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
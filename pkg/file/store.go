@@ -0,0 +1,251 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// StoreManifestSuffix ends the name of the manifest of a collection kept in a
+// chunk store (e.g. "3A5.store.json"), which maps each of its files to the
+// hash under which the store holds its contents
+const StoreManifestSuffix = ".store.json"
+
+// StoreCollections moves the files of each collection directory into a chunk
+// store, a directory shared by any number of collections in which each file is
+// kept under the SHA-256 hash of its contents, so that identical files are kept
+// once and every file is verified by its name as it is read. The collections
+// are then separated only by their manifests, written to outputDir in place of
+// the collection directories. It returns the stored collections.
+func StoreCollections(ctx context.Context, collections []Collection, outputDir string, storeDir string) ([]Collection, error) {
+	log := trace.FromContext(ctx).WithPrefix("STORE")
+
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		log.Error(fmt.Errorf("failed to create chunk store %s: %w", storeDir, err))
+		return nil, fmt.Errorf("failed to create chunk store %s: %w", storeDir, err)
+	}
+
+	// The manifests locate the store relative to themselves where they can, so
+	// that the store and the manifests can be moved together
+	storeRef, err := storeReference(outputDir, storeDir)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to locate chunk store %s: %w", storeDir, err))
+		return nil, fmt.Errorf("failed to locate chunk store %s: %w", storeDir, err)
+	}
+
+	stored := make([]Collection, len(collections))
+	for i, coll := range collections {
+		names, err := coll.fileNames(coll.Path)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+
+		m := Manifest{Collection: coll.Name, Store: storeRef, Objects: make(map[string]string, len(names))}
+		shared := 0
+		for _, name := range names {
+			hash, existed, err := storeObject(storeDir, filepath.Join(coll.Path, name))
+			if err != nil {
+				log.Error(fmt.Errorf("failed to store %s of collection %s: %w", name, coll.Name, err))
+				return nil, fmt.Errorf("failed to store %s of collection %s: %w", name, coll.Name, err)
+			}
+			if existed {
+				shared++
+			}
+			m.Objects[name] = hash
+		}
+		manifestPath := filepath.Join(outputDir, coll.Name+StoreManifestSuffix)
+		if err := writeManifest(ctx, manifestPath, m); err != nil {
+			return nil, err
+		}
+		if err := CleanupCollectionDirectory(ctx, coll.Path); err != nil {
+			return nil, err
+		}
+		log.Infof("Stored collection %s in %s: %d files, %d already in the store", coll.Name, storeDir, len(names), shared)
+
+		coll.Path = manifestPath
+		coll.Store = true
+		stored[i] = coll
+	}
+	return stored, nil
+}
+
+// storeReference returns how a manifest in dir refers to the chunk store:
+// relative to dir if possible, and otherwise by its absolute path
+func storeReference(dir string, storeDir string) (string, error) {
+	absStore, err := filepath.Abs(storeDir)
+	if err != nil {
+		return "", err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(absDir, absStore); err == nil {
+		return filepath.ToSlash(rel), nil
+	}
+	return absStore, nil
+}
+
+// storeObjectPath returns the path of the file holding the contents with the
+// given hash in a chunk store, spread over subdirectories by the first two
+// digits of the hash so that no directory grows too large
+func storeObjectPath(storeDir string, hash string) string {
+	return filepath.Join(storeDir, hash[:2], hash)
+}
+
+// storeObject copies a file into a chunk store unless the store already holds
+// its contents, returning the hash of its contents and whether it was already
+// held. Contents already held are verified, and replaced if they are damaged.
+func storeObject(storeDir string, path string) (string, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	objectPath := storeObjectPath(storeDir, hash)
+
+	if existing, err := os.ReadFile(objectPath); err == nil {
+		if existingSum := sha256.Sum256(existing); existingSum == sum {
+			return hash, true, nil
+		}
+	}
+
+	// The object is written under a temporary name and renamed into place, so
+	// that an interrupted write never leaves damaged contents under the hash
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		return "", false, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(objectPath), ".tmp-*")
+	if err != nil {
+		return "", false, err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0444)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), objectPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", false, err
+	}
+	return hash, false, nil
+}
+
+// openStoreCollection opens a collection kept in a chunk store from its
+// manifest, if manifestPath holds the manifest of one
+func openStoreCollection(ctx context.Context, manifestPath string) (Collection, bool) {
+	log := trace.FromContext(ctx).WithPrefix("STORE")
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		log.Debugf("Failed to read %s: %v", manifestPath, err)
+		return Collection{}, false
+	}
+	m, err := parseManifest(manifestPath, data)
+	if err != nil || !isCollectionName(m.Collection) || m.Store == "" || len(m.Objects) == 0 {
+		log.Debugf("%s is not the manifest of a stored collection: %v", manifestPath, err)
+		return Collection{}, false
+	}
+	for name, hash := range m.Objects {
+		if len(hash) != sha256.Size*2 || strings.Trim(hash, "0123456789abcdef") != "" || strings.ContainsAny(name, `/\`) {
+			log.Error(fmt.Errorf("invalid entry %s in the manifest %s", name, manifestPath))
+			return Collection{}, false
+		}
+	}
+
+	storeDir := filepath.FromSlash(m.Store)
+	if !filepath.IsAbs(storeDir) {
+		storeDir = filepath.Join(filepath.Dir(manifestPath), storeDir)
+	}
+	if info, err := os.Stat(storeDir); err != nil || !info.IsDir() {
+		log.Error(fmt.Errorf("chunk store %s of collection %s is not available", storeDir, m.Collection))
+		return Collection{}, false
+	}
+
+	archive := &storeArchive{dir: storeDir, objects: m.Objects}
+	format, naming, err := detectFormat(archive, m.Collection)
+	if err != nil {
+		log.Debugf("Failed to determine the format of stored collection %s: %v", m.Collection, err)
+		return Collection{}, false
+	}
+	log.Debugf("Found collection %s in chunk store %s", m.Collection, storeDir)
+	return Collection{Name: m.Collection, Path: manifestPath, Format: format, Naming: naming, Store: true, archive: archive}, true
+}
+
+// storeArchive reads the files of a collection kept in a chunk store, verifying
+// the contents of each against the hash under which it is kept
+type storeArchive struct {
+	dir     string
+	objects map[string]string // Hash of the contents of each file, by name
+}
+
+// names returns the names of the collection's files, in order
+func (a *storeArchive) names() []string {
+	names := make([]string, 0, len(a.objects))
+	for name := range a.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// has reports whether the store holds a file of the collection
+func (a *storeArchive) has(name string) bool {
+	hash, ok := a.objects[name]
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(storeObjectPath(a.dir, hash))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// open opens a file of the collection, once its contents have been verified
+func (a *storeArchive) open(name string) (io.ReadCloser, error) {
+	data, err := a.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// readFile reads a file of the collection, failing if its contents don't
+// match their hash
+func (a *storeArchive) readFile(name string) ([]byte, error) {
+	hash, ok := a.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	data, err := os.ReadFile(storeObjectPath(a.dir, hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from the chunk store: %w", name, err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("%s in the chunk store %s does not match its hash %s", name, a.dir, hash[:16])
+	}
+	return data, nil
+}
+
+// close does nothing, as the store's files are opened only as they are read
+func (a *storeArchive) close() error {
+	return nil
+}
@@ -0,0 +1,132 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestStoreCollections(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "store-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Both collections hold the same recovery readme, which is stored once
+	outputDir := filepath.Join(tempDir, "out")
+	storeDir := filepath.Join(tempDir, "pool")
+	collections, err := CreateCollections(ctx, outputDir, []string{"2A2", "2B2"})
+	if err != nil {
+		t.Fatalf("CreateCollections failed: %v", err)
+	}
+	chunks := map[string][]byte{"2A2": bytes.Repeat([]byte("A"), 3000), "2B2": bytes.Repeat([]byte("B"), 3000)}
+	for i, coll := range collections {
+		collections[i].Format = FormatBin
+		if err := os.WriteFile(filepath.Join(coll.Path, ChunkFileName(FormatBin, coll.Name, 1)), chunks[coll.Name], 0644); err != nil {
+			t.Fatalf("Failed to write chunk: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(coll.Path, RecoveryReadmeFileName), []byte("shared readme"), 0644); err != nil {
+			t.Fatalf("Failed to write readme: %v", err)
+		}
+	}
+
+	stored, err := StoreCollections(ctx, collections, outputDir, storeDir)
+	if err != nil {
+		t.Fatalf("StoreCollections failed: %v", err)
+	}
+	for _, coll := range stored {
+		if _, err := os.Stat(filepath.Join(outputDir, coll.Name)); !os.IsNotExist(err) {
+			t.Errorf("Expected the directory of %s to be removed", coll.Name)
+		}
+		if coll.Path != filepath.Join(outputDir, coll.Name+StoreManifestSuffix) || !coll.Store {
+			t.Errorf("Expected %s to be read from its manifest, got %+v", coll.Name, coll)
+		}
+	}
+	var objects []string
+	filepath.WalkDir(storeDir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			objects = append(objects, path)
+		}
+		return err
+	})
+	if len(objects) != 3 {
+		t.Errorf("Expected 2 chunks and 1 shared readme in the store, got %d objects", len(objects))
+	}
+
+	t.Run("Read from the store", func(t *testing.T) {
+		found, tempPath, err := FindCollections(ctx, outputDir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer CloseCollections(found)
+		if tempPath != "" || len(found) != 2 {
+			t.Fatalf("Expected 2 collections read from the store, got %+v (%s)", found, tempPath)
+		}
+		for _, coll := range found {
+			if coll.Format != FormatBin || !coll.Store {
+				t.Errorf("Expected a stored bin collection, got %+v", coll)
+			}
+			data, err := ReadChunk(ctx, coll, 1)
+			if err != nil || !bytes.Equal(data, chunks[coll.Name]) {
+				t.Errorf("Expected to read the chunk of %s, got %d bytes (%v)", coll.Name, len(data), err)
+			}
+		}
+	})
+
+	t.Run("Storing again", func(t *testing.T) {
+		// Contents already held are not written again
+		again := filepath.Join(tempDir, "again")
+		colls, err := CreateCollections(ctx, again, []string{"2A2"})
+		if err != nil {
+			t.Fatalf("CreateCollections failed: %v", err)
+		}
+		os.WriteFile(filepath.Join(colls[0].Path, ChunkFileName(FormatBin, "2A2", 1)), chunks["2A2"], 0644)
+		if _, err := StoreCollections(ctx, colls, again, storeDir); err != nil {
+			t.Fatalf("StoreCollections failed: %v", err)
+		}
+		m, err := os.ReadFile(filepath.Join(again, "2A2"+StoreManifestSuffix))
+		if err != nil || !bytes.Contains(m, []byte(`"store": "../pool"`)) {
+			t.Errorf("Expected the manifest to locate the store relative to itself: %s (%v)", m, err)
+		}
+	})
+
+	t.Run("Damaged object", func(t *testing.T) {
+		for _, path := range objects {
+			data, _ := os.ReadFile(path)
+			if !bytes.Equal(data, chunks["2B2"]) {
+				continue
+			}
+			os.Chmod(path, 0644)
+			data[100] ^= 0x01
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatalf("Failed to damage object: %v", err)
+			}
+		}
+		found, _, err := FindCollections(ctx, outputDir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer CloseCollections(found)
+		if _, err := ReadChunk(ctx, found[1], 1); !errors.Is(err, pad.ErrChunkCorrupt) {
+			t.Errorf("Expected the damaged chunk to be corrupt, got %v", err)
+		}
+	})
+
+	t.Run("Missing store", func(t *testing.T) {
+		os.RemoveAll(storeDir)
+		if _, _, err := FindCollections(ctx, outputDir); !errors.Is(err, ErrNoCollections) {
+			t.Errorf("Expected ErrNoCollections without the store, got %v", err)
+		}
+	})
+}
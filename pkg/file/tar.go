@@ -0,0 +1,354 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// tarSuffixes are the extensions of gzipped tar archives of collections, the
+// first of which is used when creating them
+var tarSuffixes = []string{".tar.gz", ".tgz"}
+
+// tarArchiveBase returns the name of a gzipped tar archive without its
+// extension, and whether it is one
+func tarArchiveBase(name string) (string, bool) {
+	for _, suffix := range tarSuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return strings.TrimSuffix(name, suffix), true
+		}
+	}
+	return "", false
+}
+
+// TarCollection creates a gzipped tar archive of a collection directory, named
+// after it (e.g. "3A5.tar.gz"), whose entries are within a directory of the
+// same name, so that it unpacks with tar as the collection directory
+func TarCollection(ctx context.Context, collPath string) (string, error) {
+	log := trace.FromContext(ctx).WithPrefix("TAR")
+
+	collName := filepath.Base(collPath)
+	tarPath := filepath.Join(filepath.Dir(collPath), collName+tarSuffixes[0])
+	log.Debugf("Creating tar archive for collection %s: %s", collName, tarPath)
+
+	f, err := os.Create(tarPath)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create tar file %s: %w", tarPath, err))
+		return "", fmt.Errorf("failed to create tar file %s: %w", tarPath, err)
+	}
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+
+	// Files are added in name order, so that chunks can be read in order
+	// without seeking back
+	err = filepath.WalkDir(collPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(collPath, p)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("failed to create tar header: %w", err)
+		}
+		header.Name = path.Join(collName, archiveEntryName(rel))
+		if d.IsDir() {
+			header.Name += "/"
+		}
+		header.Uname, header.Gname = "", ""
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		log.Debugf("Adding file to tar: %s", header.Name)
+		src, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", p, err)
+		}
+		defer src.Close()
+		if _, err := io.Copy(tw, src); err != nil {
+			return fmt.Errorf("failed to write file to tar: %w", err)
+		}
+		return nil
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gzw.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tarPath)
+		log.Error(fmt.Errorf("error creating tar archive for collection %s: %w", collName, err))
+		return "", fmt.Errorf("error creating tar archive for collection %s: %w", collName, err)
+	}
+
+	log.Debugf("Successfully created tar archive: %s", tarPath)
+	return tarPath, nil
+}
+
+// TarCollections creates gzipped tar archives for each collection
+func TarCollections(ctx context.Context, collections []Collection) ([]string, error) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
+
+	log.Infof("Creating tar archives for %d collections", len(collections))
+	tarPaths := make([]string, len(collections))
+
+	for i, coll := range collections {
+		tarPath, err := TarCollection(ctx, coll.Path)
+		if err != nil {
+			log.Error(fmt.Errorf("failed to create tar archive for collection %s: %w", coll.Name, err))
+			return nil, err
+		}
+
+		// Remove the original directory
+		if err := CleanupCollectionDirectory(ctx, coll.Path); err != nil {
+			log.Error(fmt.Errorf("failed to remove original collection directory after archiving: %w", err))
+			return nil, err
+		}
+
+		tarPaths[i] = tarPath
+		log.Infof("Created tar archive for collection %s: %s", coll.Name, tarPath)
+	}
+
+	return tarPaths, nil
+}
+
+// ExtractTarCollection extracts a gzipped tar archive to a directory of the
+// same name within tempDir, returning the directory. The directory that the
+// archive's entries are within is not recreated, so that the layout matches
+// that of an extracted zip. A damaged archive is extracted as far as it can be.
+func ExtractTarCollection(ctx context.Context, tarPath string, tempDir string) (string, error) {
+	log := trace.FromContext(ctx).WithPrefix("TAR")
+
+	log.Debugf("Extracting tar collection: %s", tarPath)
+	base, _ := tarArchiveBase(filepath.Base(tarPath))
+	collectionDir := filepath.Join(tempDir, base)
+	if err := os.MkdirAll(collectionDir, 0755); err != nil {
+		log.Error(fmt.Errorf("failed to create temp collection directory: %w", err))
+		return "", fmt.Errorf("failed to create temp collection directory: %w", err)
+	}
+
+	f, err := os.Open(tarPath)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to open tar file %s: %w", tarPath, err))
+		return "", fmt.Errorf("failed to open tar file %s: %w", tarPath, err)
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to open tar file %s: %w", tarPath, err))
+		return "", fmt.Errorf("failed to open tar file %s: %w", tarPath, err)
+	}
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A damaged stream leaves the remaining chunks missing, which parity
+			// or the other collections may make up for
+			log.Infof("Warning: %s is damaged, extracted only as far as it could be read: %v", tarPath, err)
+			break
+		}
+		name, ok := tarEntryName(header.Name, base)
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		fpath := filepath.Join(collectionDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			log.Error(fmt.Errorf("failed to create directory for %s: %w", fpath, err))
+			return "", fmt.Errorf("failed to create directory for %s: %w", fpath, err)
+		}
+		out, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			log.Error(fmt.Errorf("failed to create output file %s: %w", fpath, err))
+			return "", fmt.Errorf("failed to create output file %s: %w", fpath, err)
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			log.Infof("Warning: skipping damaged entry %s in %s: %v", header.Name, tarPath, err)
+			os.Remove(fpath)
+			break
+		}
+		log.Debugf("Extracted file: %s", name)
+	}
+
+	log.Debugf("Successfully extracted tar collection to: %s", collectionDir)
+	return collectionDir, nil
+}
+
+// tarEntryName returns the name of a tar entry relative to the directory named
+// base that the entries of a collection's archive are within, if present, and
+// whether the entry lies within the archive rather than outside of it
+func tarEntryName(name string, base string) (string, bool) {
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+	if rest, ok := strings.CutPrefix(name, base+"/"); ok {
+		name = rest
+	}
+	if name == "." || name == base || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	return name, true
+}
+
+// tarArchive is a gzipped tar archive of a single collection opened so that
+// its files can be read in place. A gzip stream can only be read from the
+// start, so the archive is indexed once when it is opened, and files are then
+// read by decompressing forward from the last one read, which is fast when
+// chunks are read in order, as they are when decoding.
+type tarArchive struct {
+	path  string
+	base  string
+	index map[string]int // Position of each file among the archive's entries
+
+	mu   sync.Mutex
+	f    *os.File
+	tr   *tar.Reader
+	next int // Position of the entry that tr.Next returns
+}
+
+// openTarArchive opens a gzipped tar archive and indexes the files it holds
+func openTarArchive(tarPath string) (*tarArchive, error) {
+	base, _ := tarArchiveBase(filepath.Base(tarPath))
+	a := &tarArchive{path: tarPath, base: base, index: make(map[string]int)}
+	if err := a.rewind(); err != nil {
+		return nil, err
+	}
+	for i := 0; ; i++ {
+		header, err := a.tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			a.close()
+			return nil, fmt.Errorf("failed to read tar file %s: %w", tarPath, err)
+		}
+		if name, ok := tarEntryName(header.Name, base); ok && header.Typeflag == tar.TypeReg {
+			a.index[name] = i
+		}
+	}
+	if err := a.rewind(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// rewind reopens the archive to read it from the start
+func (a *tarArchive) rewind() error {
+	if a.f != nil {
+		a.f.Close()
+		a.f = nil
+	}
+	f, err := os.Open(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to open tar file %s: %w", a.path, err)
+	}
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open tar file %s: %w", a.path, err)
+	}
+	a.f, a.tr, a.next = f, tar.NewReader(gzr), 0
+	return nil
+}
+
+// names returns the names of the files in the archive, in order
+func (a *tarArchive) names() []string {
+	names := make([]string, 0, len(a.index))
+	for name := range a.index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// has reports whether the archive holds a file
+func (a *tarArchive) has(name string) bool {
+	_, ok := a.index[name]
+	return ok
+}
+
+// open opens a file in the archive, failing with fs.ErrNotExist if it isn't there
+func (a *tarArchive) open(name string) (io.ReadCloser, error) {
+	data, err := a.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// readFile reads a file in the archive
+func (a *tarArchive) readFile(name string) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pos, ok := a.index[name]
+	if !ok {
+		return nil, fmt.Errorf("%s in %s: %w", name, a.path, fs.ErrNotExist)
+	}
+	if pos < a.next || a.f == nil {
+		if err := a.rewind(); err != nil {
+			return nil, err
+		}
+	}
+	for a.next <= pos {
+		_, err := a.tr.Next()
+		a.next++
+		if err != nil {
+			// The stream can't be read beyond the damage, so it is read from
+			// the start again next time
+			a.f.Close()
+			a.f = nil
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("failed to read %s in %s: %w", name, a.path, err)
+		}
+	}
+	data, err := io.ReadAll(a.tr)
+	if err != nil {
+		a.f.Close()
+		a.f = nil
+		return nil, fmt.Errorf("failed to read %s in %s: %w", name, a.path, err)
+	}
+	return data, nil
+}
+
+// close closes the archive
+func (a *tarArchive) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return nil
+	}
+	err := a.f.Close()
+	a.f = nil
+	return err
+}
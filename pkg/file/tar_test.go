@@ -0,0 +1,185 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestTarCollection(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "tar-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputDir := filepath.Join(tempDir, "out")
+	collPath, err := CreateCollectionDirectory(ctx, outputDir, "3A5")
+	if err != nil {
+		t.Fatalf("CreateCollectionDirectory failed: %v", err)
+	}
+	chunks := make(map[int][]byte)
+	for n := 1; n <= 3; n++ {
+		chunks[n] = make([]byte, 20000)
+		rand.Read(chunks[n])
+		if err := os.WriteFile(filepath.Join(collPath, ChunkFileName(FormatBin, "3A5", n)), chunks[n], 0644); err != nil {
+			t.Fatalf("Failed to write chunk: %v", err)
+		}
+	}
+	tarPaths, err := TarCollections(ctx, []Collection{{Name: "3A5", Path: collPath}})
+	if err != nil {
+		t.Fatalf("TarCollections failed: %v", err)
+	}
+	if len(tarPaths) != 1 || tarPaths[0] != filepath.Join(outputDir, "3A5.tar.gz") {
+		t.Fatalf("Expected 3A5.tar.gz, got %v", tarPaths)
+	}
+	if _, err := os.Stat(collPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the collection directory to be removed")
+	}
+
+	t.Run("Read in place", func(t *testing.T) {
+		collections, tempPath, err := FindCollections(ctx, outputDir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer CloseCollections(collections)
+		if tempPath != "" || len(collections) != 1 || !collections[0].Tar {
+			t.Fatalf("Expected one collection read in place, got %+v (%s)", collections, tempPath)
+		}
+		numbers, err := ChunkNumbers(collections[0])
+		if err != nil || fmt.Sprint(numbers) != "[1 2 3]" {
+			t.Fatalf("Expected chunks [1 2 3], got %v (%v)", numbers, err)
+		}
+		// Out of order, so that the archive is read again from the start
+		for _, n := range []int{2, 3, 1, 3} {
+			data, err := ReadChunk(ctx, collections[0], n)
+			if err != nil || !bytes.Equal(data, chunks[n]) {
+				t.Errorf("Chunk %d: expected %d bytes, got %d (%v)", n, len(chunks[n]), len(data), err)
+			}
+		}
+		if _, err := ReadChunk(ctx, collections[0], 4); !errors.Is(err, pad.ErrChunkCorrupt) {
+			t.Errorf("Expected a missing chunk to be corrupt, got %v", err)
+		}
+	})
+
+	t.Run("Unpacks with tar", func(t *testing.T) {
+		if _, err := exec.LookPath("tar"); err != nil {
+			t.Skip("tar is not installed")
+		}
+		unpackDir := filepath.Join(tempDir, "unpacked")
+		os.MkdirAll(unpackDir, 0755)
+		if out, err := exec.Command("tar", "-xzf", tarPaths[0], "-C", unpackDir).CombinedOutput(); err != nil {
+			t.Fatalf("tar failed: %v: %s", err, out)
+		}
+		data, err := os.ReadFile(filepath.Join(unpackDir, "3A5", ChunkFileName(FormatBin, "3A5", 2)))
+		if err != nil || !bytes.Equal(data, chunks[2]) {
+			t.Errorf("Expected the collection directory to be unpacked, got %d bytes (%v)", len(data), err)
+		}
+	})
+
+	t.Run("Extract", func(t *testing.T) {
+		extractDir := filepath.Join(tempDir, "extracted")
+		collectionDir, err := ExtractTarCollection(ctx, tarPaths[0], extractDir)
+		if err != nil {
+			t.Fatalf("ExtractTarCollection failed: %v", err)
+		}
+		if collectionDir != filepath.Join(extractDir, "3A5") {
+			t.Errorf("Expected extraction to %s, got %s", filepath.Join(extractDir, "3A5"), collectionDir)
+		}
+		for n, want := range chunks {
+			data, err := os.ReadFile(filepath.Join(collectionDir, ChunkFileName(FormatBin, "3A5", n)))
+			if err != nil || !bytes.Equal(data, want) {
+				t.Errorf("Chunk %d: expected %d bytes, got %d (%v)", n, len(want), len(data), err)
+			}
+		}
+	})
+
+	t.Run("Truncated archive", func(t *testing.T) {
+		data, err := os.ReadFile(tarPaths[0])
+		if err != nil {
+			t.Fatalf("Failed to read tar: %v", err)
+		}
+		truncatedDir := filepath.Join(tempDir, "truncated")
+		os.MkdirAll(truncatedDir, 0755)
+		truncated := filepath.Join(truncatedDir, "3A5.tgz")
+		if err := os.WriteFile(truncated, data[:len(data)*2/3], 0644); err != nil {
+			t.Fatalf("Failed to write truncated tar: %v", err)
+		}
+
+		// A damaged archive is extracted as far as it can be read
+		collections, tempPath, err := FindCollections(ctx, truncatedDir)
+		if tempPath != "" {
+			defer os.RemoveAll(tempPath)
+		}
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer CloseCollections(collections)
+		if len(collections) != 1 || collections[0].Tar {
+			t.Fatalf("Expected the damaged archive to be extracted, got %+v", collections)
+		}
+		if data, err := ReadChunk(ctx, collections[0], 1); err != nil || !bytes.Equal(data, chunks[1]) {
+			t.Errorf("Expected the first chunk to survive, got %d bytes (%v)", len(data), err)
+		}
+		if _, err := ReadChunk(ctx, collections[0], 3); err == nil {
+			t.Errorf("Expected the last chunk to be lost")
+		}
+	})
+}
+
+func TestExtractTarCollectionOutsidePaths(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "tar-outside-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for _, name := range []string{"3A5/3A5_0001.bin", "../escaped.bin", "/abs.bin", "3A5/../../escaped2.bin"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 4, Typeflag: tar.TypeReg})
+		tw.Write([]byte("data"))
+	}
+	tw.Close()
+	gzw.Close()
+	tarPath := filepath.Join(tempDir, "3A5.tar.gz")
+	if err := os.WriteFile(tarPath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write tar: %v", err)
+	}
+
+	extractDir := filepath.Join(tempDir, "extract", "inner")
+	collectionDir, err := ExtractTarCollection(ctx, tarPath, extractDir)
+	if err != nil {
+		t.Fatalf("ExtractTarCollection failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(collectionDir, "3A5_0001.bin")); err != nil {
+		t.Errorf("Expected the chunk to be extracted: %v", err)
+	}
+	for _, name := range []string{"escaped.bin", "escaped2.bin", "abs.bin"} {
+		for _, dir := range []string{tempDir, filepath.Join(tempDir, "extract"), extractDir} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				t.Errorf("Expected %s not to be extracted into %s", name, dir)
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// ValidateTargets checks that each target is an existing directory, and warns
+// when two targets share a device, since the point of writing collections to
+// separate removable media is that each device holds exactly one collection.
+func ValidateTargets(ctx context.Context, targets []string) error {
+	log := trace.FromContext(ctx).WithPrefix("TARGET")
+
+	devices := make(map[uint64]string)
+	for _, target := range targets {
+		info, err := os.Stat(target)
+		if err != nil {
+			log.Error(fmt.Errorf("cannot access target %s: %w", target, err))
+			return fmt.Errorf("cannot access target %s: %w", target, err)
+		}
+		if !info.IsDir() {
+			log.Error(fmt.Errorf("target is not a directory: %s", target))
+			return fmt.Errorf("target is not a directory: %s", target)
+		}
+		if dev, _, ok := fileIdentity(info); ok {
+			if other, found := devices[dev]; found {
+				log.Infof("Warning: targets %s and %s are on the same device, so one device holds more than one collection", other, target)
+			}
+			devices[dev] = target
+		}
+	}
+	return nil
+}
+
+// TargetWriter writes one collection directly to a target directory, typically
+// the mount point of a removable device, verifying each chunk by reading it back
+// and comparing its hash with the data that was written.
+type TargetWriter struct {
+	ctx        context.Context
+	target     string
+	collection Collection
+	formatter  Formatter
+	chunks     []targetChunk
+	failures   int
+}
+
+// targetChunk is the verification record of one chunk written to a target
+type targetChunk struct {
+	file string
+	size int64
+	hash string
+	err  error
+}
+
+// NewTargetWriter creates the collection directory on the target and returns a
+// TargetWriter for it. An existing collection directory is cleared only if
+// clearIfNotEmpty is set.
+func NewTargetWriter(ctx context.Context, target string, collectionName string, format Format, clearIfNotEmpty bool) (*TargetWriter, error) {
+	collPath := filepath.Join(target, collectionName)
+	if err := PrepareOutputDirectory(ctx, collPath, clearIfNotEmpty); err != nil {
+		return nil, err
+	}
+	collection := Collection{Name: collectionName, Path: collPath, Format: format, Naming: chunkNaming(ctx)}
+	return &TargetWriter{
+		ctx:        ctx,
+		target:     target,
+		collection: collection,
+		formatter:  collection.formatter(),
+	}, nil
+}
+
+// Collection returns the collection being written to the target
+func (tw *TargetWriter) Collection() Collection {
+	return tw.collection
+}
+
+// NewChunkWriter returns a writer for the given chunk that verifies the chunk on close
+func (tw *TargetWriter) NewChunkWriter(chunkNumber int) io.WriteCloser {
+	return &verifyingChunkWriter{
+		ChunkWriter: NewChunkWriter(tw.ctx, tw.formatter, tw.collection.Path, 0, chunkNumber),
+		tw:          tw,
+	}
+}
+
+// verifyingChunkWriter is a ChunkWriter that reads its chunk back after writing it
+type verifyingChunkWriter struct {
+	*ChunkWriter
+	tw *TargetWriter
+}
+
+// Close writes the chunk, then reads it back and compares hashes
+func (w *verifyingChunkWriter) Close() error {
+	if err := w.ChunkWriter.Close(); err != nil {
+		return err
+	}
+	w.tw.verify(w.chunkNum, w.chunkData)
+	return nil
+}
+
+// verify reads a chunk back from the target and records whether it matches what
+// was written. Failures are recorded rather than returned so that the report
+// covers every chunk; Finish reports them.
+func (tw *TargetWriter) verify(chunkNumber int, written []byte) {
+	log := trace.FromContext(tw.ctx).WithPrefix("TARGET")
+
+	sum := sha256.Sum256(written)
+	record := targetChunk{
+		file: tw.collection.chunkFileName(chunkNumber),
+		hash: hex.EncodeToString(sum[:]),
+	}
+	if info, err := os.Stat(filepath.Join(tw.collection.Path, record.file)); err == nil {
+		record.size = info.Size()
+	}
+
+	readBack, err := tw.formatter.ReadChunk(tw.ctx, tw.collection.Path, 0, chunkNumber)
+	if err == nil && !bytes.Equal(readBack, written) {
+		readSum := sha256.Sum256(readBack)
+		err = fmt.Errorf("read-back hash %s does not match written hash %s", hex.EncodeToString(readSum[:8]), record.hash[:16])
+	}
+	if err != nil {
+		record.err = err
+		tw.failures++
+		log.Error(fmt.Errorf("verification of chunk %d on %s failed: %w", chunkNumber, tw.target, err))
+	}
+	tw.chunks = append(tw.chunks, record)
+}
+
+// Finish flushes the collection directory to the device and writes the
+// verification report to the root of the target. It returns the report path.
+func (tw *TargetWriter) Finish() (string, error) {
+	log := trace.FromContext(tw.ctx).WithPrefix("TARGET")
+
+	// Chunk files are synced as they are written; sync the directories that name them too
+	for _, dir := range []string{tw.collection.Path, tw.target} {
+		if err := syncDir(dir); err != nil {
+			log.Debugf("Cannot sync directory %s: %v", dir, err)
+		}
+	}
+
+	var report strings.Builder
+	var totalBytes int64
+	fmt.Fprintf(&report, "Padlock verification report\n")
+	fmt.Fprintf(&report, "Collection: %s\n", tw.collection.Name)
+	fmt.Fprintf(&report, "Target:     %s\n", tw.target)
+	fmt.Fprintf(&report, "Written:    %s\n\n", time.Now().Format(time.RFC3339))
+	for _, chunk := range tw.chunks {
+		status := "OK"
+		if chunk.err != nil {
+			status = "FAILED: " + chunk.err.Error()
+		}
+		fmt.Fprintf(&report, "%s %10d %s %s\n", chunk.hash, chunk.size, chunk.file, status)
+		totalBytes += chunk.size
+	}
+	fmt.Fprintf(&report, "\n%d chunks, %d bytes, %d failed verification\n", len(tw.chunks), totalBytes, tw.failures)
+
+	reportPath := filepath.Join(tw.target, tw.collection.Name+"-verify.txt")
+	if err := writeSynced(reportPath, []byte(report.String())); err != nil {
+		log.Error(fmt.Errorf("failed to write verification report %s: %w", reportPath, err))
+		return "", fmt.Errorf("failed to write verification report %s: %w", reportPath, err)
+	}
+
+	if tw.failures > 0 {
+		return reportPath, fmt.Errorf("%d chunks failed verification on %s (see %s)", tw.failures, tw.target, reportPath)
+	}
+	log.Infof("Collection %s written to %s: %d chunks (%d bytes) verified; safe to eject",
+		tw.collection.Name, tw.target, len(tw.chunks), totalBytes)
+	return reportPath, nil
+}
+
+// writeSynced writes a file and flushes it to the device
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes a directory's entries to the device
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,63 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestTargetWriter(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory standing in for a removable device
+	target, err := os.MkdirTemp("", "target-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(target)
+
+	if err := ValidateTargets(ctx, []string{target}); err != nil {
+		t.Fatalf("ValidateTargets failed: %v", err)
+	}
+	if err := ValidateTargets(ctx, []string{filepath.Join(target, "missing")}); err == nil {
+		t.Errorf("Expected ValidateTargets to reject a missing target")
+	}
+
+	tw, err := NewTargetWriter(ctx, target, "2A2", FormatBin, false)
+	if err != nil {
+		t.Fatalf("NewTargetWriter failed: %v", err)
+	}
+	for chunk := 1; chunk <= 2; chunk++ {
+		w := tw.NewChunkWriter(chunk)
+		if _, err := w.Write([]byte("chunk data")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+
+	reportPath, err := tw.Finish()
+	if err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	report, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	if !strings.Contains(string(report), "2A2_0002.bin OK") || !strings.Contains(string(report), "0 failed verification") {
+		t.Errorf("Unexpected verification report:\n%s", report)
+	}
+
+	// A chunk whose read-back differs from what was written fails verification
+	tw.verify(1, []byte("different data"))
+	if _, err := tw.Finish(); err == nil {
+		t.Errorf("Expected Finish to report the failed verification")
+	}
+}
@@ -0,0 +1,98 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// tempDirKey is the context key of the options for temporary directories
+type tempDirKey struct{}
+
+// tempDirOptions locates temporary directories and says whether they are kept
+type tempDirOptions struct {
+	dir  string
+	keep bool
+}
+
+// WithTempDir returns a context under which the temporary directories that
+// archives are extracted into are created within dir, rather than the system's
+// temporary directory, which may be too small or on an unencrypted volume. If
+// keep is set, they are left in place for debugging rather than removed.
+func WithTempDir(ctx context.Context, dir string, keep bool) context.Context {
+	return context.WithValue(ctx, tempDirKey{}, tempDirOptions{dir: dir, keep: keep})
+}
+
+// tempDirsMu guards tempDirs, the temporary directories created and not yet
+// removed, so that they can be removed when the process is interrupted
+var (
+	tempDirsMu sync.Mutex
+	tempDirs   = make(map[string]bool)
+)
+
+// MkdirTemp creates a temporary directory, readable only by the user, within
+// the directory given to WithTempDir or else the system's
+func MkdirTemp(ctx context.Context) (string, error) {
+	log := trace.FromContext(ctx).WithPrefix("TEMP")
+
+	opts, _ := ctx.Value(tempDirKey{}).(tempDirOptions)
+	dir, err := os.MkdirTemp(opts.dir, "padlock-*")
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create temporary directory: %w", err))
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	tempDirsMu.Lock()
+	tempDirs[dir] = true
+	tempDirsMu.Unlock()
+	log.Debugf("Created temporary directory: %s", dir)
+	return dir, nil
+}
+
+// RemoveTemp removes a temporary directory created by MkdirTemp, unless the
+// context asks for temporary directories to be kept
+func RemoveTemp(ctx context.Context, dir string) error {
+	log := trace.FromContext(ctx).WithPrefix("TEMP")
+
+	if dir == "" {
+		return nil
+	}
+	tempDirsMu.Lock()
+	delete(tempDirs, dir)
+	tempDirsMu.Unlock()
+	if opts, _ := ctx.Value(tempDirKey{}).(tempDirOptions); opts.keep {
+		log.Infof("Keeping temporary directory: %s", dir)
+		return nil
+	}
+	log.Debugf("Cleaning up temporary directory: %s", dir)
+	if err := os.RemoveAll(dir); err != nil {
+		log.Infof("Warning: failed to remove temporary directory %s: %v", dir, err)
+		return err
+	}
+	return nil
+}
+
+// RemoveTempDirs removes every temporary directory created by MkdirTemp and
+// not yet removed, for a process that is exiting without returning through
+// its cleanup, such as on an interrupt. It returns the directories removed.
+func RemoveTempDirs() []string {
+	tempDirsMu.Lock()
+	defer tempDirsMu.Unlock()
+	var removed []string
+	for dir := range tempDirs {
+		if _, err := os.Lstat(dir); err != nil {
+			// Already removed by other means
+			delete(tempDirs, dir)
+			continue
+		}
+		if os.RemoveAll(dir) == nil {
+			removed = append(removed, dir)
+		}
+		delete(tempDirs, dir)
+	}
+	sort.Strings(removed)
+	return removed
+}
@@ -0,0 +1,68 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestTempDir(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	t.Run("Configured", func(t *testing.T) {
+		parent := t.TempDir()
+		ctx := WithTempDir(ctx, parent, false)
+		dir, err := MkdirTemp(ctx)
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %v", err)
+		}
+		if filepath.Dir(dir) != parent {
+			t.Errorf("Expected the temporary directory within %s, got %s", parent, dir)
+		}
+		if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+			t.Errorf("Expected a directory readable only by the user, got %v, %v", info, err)
+		}
+		if err := RemoveTemp(ctx, dir); err != nil {
+			t.Fatalf("Failed to remove temporary directory: %v", err)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("Expected the temporary directory to be removed")
+		}
+	})
+
+	t.Run("Kept", func(t *testing.T) {
+		ctx := WithTempDir(ctx, t.TempDir(), true)
+		dir, err := MkdirTemp(ctx)
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %v", err)
+		}
+		if err := RemoveTemp(ctx, dir); err != nil {
+			t.Fatalf("Failed to keep temporary directory: %v", err)
+		}
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("Expected the temporary directory to be kept, got %v", err)
+		}
+	})
+
+	t.Run("Interrupted", func(t *testing.T) {
+		ctx := WithTempDir(ctx, t.TempDir(), false)
+		dir, err := MkdirTemp(ctx)
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %v", err)
+		}
+		if removed := RemoveTempDirs(); len(removed) != 1 || removed[0] != dir {
+			t.Errorf("Expected %s to be removed, got %v", dir, removed)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("Expected the temporary directory to be removed")
+		}
+		if removed := RemoveTempDirs(); len(removed) != 0 {
+			t.Errorf("Expected nothing left to remove, got %v", removed)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// volumeSeparator separates the collection name from the volume index in a
+// volume directory name, e.g. "3A5.vol02"
+const volumeSeparator = ".vol"
+
+// manifestReserve is the space set aside in each volume for its manifest
+const manifestReserve = 4096
+
+// VolumeDirName returns the name of the directory holding one volume of a
+// collection. Each volume directory contains an ordinary collection directory
+// (e.g. "3A5.vol02/3A5/") with the chunks stored on that volume, so the contents
+// of any single volume look like a regular collection.
+func VolumeDirName(collectionName string, volume int) string {
+	return fmt.Sprintf("%s%s%02d", collectionName, volumeSeparator, volume)
+}
+
+// parseVolumeDirName splits a volume directory name into its collection name and volume index
+func parseVolumeDirName(name string) (string, int, bool) {
+	i := strings.LastIndex(name, volumeSeparator)
+	if i < 0 {
+		return "", 0, false
+	}
+	collName := name[:i]
+	volume, err := strconv.Atoi(name[i+len(volumeSeparator):])
+	if err != nil || volume < 1 || !isCollectionName(collName) {
+		return "", 0, false
+	}
+	return collName, volume, true
+}
+
+// volume records the chunks written to one volume of a collection
+type volume struct {
+	dir        string // The volume directory (e.g. "out/3A5.vol01")
+	collPath   string // The collection directory within the volume (e.g. "out/3A5.vol01/3A5")
+	firstChunk int
+	lastChunk  int
+	used       int64
+}
+
+// VolumeWriter spreads the chunks of a single collection over a sequence of
+// volume directories, none of which exceeds a maximum size, so that each
+// volume fits on a fixed-size medium such as a DVD or flash drive.
+type VolumeWriter struct {
+	ctx            context.Context
+	outputDir      string
+	collectionName string
+	format         Format
+	naming         ChunkNaming
+	formatter      Formatter
+	maxSize        int64
+	largestChunk   int64
+	volumes        []*volume
+}
+
+// NewVolumeWriter creates a VolumeWriter for a collection whose volumes are created in outputDir
+func NewVolumeWriter(ctx context.Context, outputDir string, collectionName string, format Format, maxSize int64) *VolumeWriter {
+	return &VolumeWriter{
+		ctx:            ctx,
+		outputDir:      outputDir,
+		collectionName: collectionName,
+		format:         format,
+		naming:         chunkNaming(ctx),
+		formatter:      GetNamedFormatter(format, chunkNaming(ctx)),
+		maxSize:        maxSize,
+	}
+}
+
+// NewChunkWriter returns a writer for the given chunk, starting a new volume
+// when the current one cannot hold another chunk of the largest size seen so far
+func (vw *VolumeWriter) NewChunkWriter(chunkNumber int) (io.WriteCloser, error) {
+	log := trace.FromContext(vw.ctx).WithPrefix("VOLUME")
+
+	var current *volume
+	if len(vw.volumes) > 0 {
+		current = vw.volumes[len(vw.volumes)-1]
+	}
+	if current == nil || (current.lastChunk > 0 && current.used+vw.largestChunk > vw.maxSize) {
+		dir := filepath.Join(vw.outputDir, VolumeDirName(vw.collectionName, len(vw.volumes)+1))
+		collPath, err := CreateCollectionDirectory(vw.ctx, dir, vw.collectionName)
+		if err != nil {
+			return nil, err
+		}
+		current = &volume{dir: dir, collPath: collPath, firstChunk: chunkNumber, used: manifestReserve}
+		vw.volumes = append(vw.volumes, current)
+		log.Debugf("Starting volume %d of collection %s at chunk %d", len(vw.volumes), vw.collectionName, chunkNumber)
+	}
+
+	return &volumeChunkWriter{
+		ChunkWriter: NewChunkWriter(vw.ctx, vw.formatter, current.collPath, 0, chunkNumber),
+		vw:          vw,
+		volume:      current,
+		path:        filepath.Join(current.collPath, vw.naming.orDefault(vw.format).FileName(vw.collectionName, chunkNumber)),
+		chunkNumber: chunkNumber,
+	}, nil
+}
+
+// Finish writes the manifest of each volume and returns the volume directories in order
+func (vw *VolumeWriter) Finish() ([]string, error) {
+	dirs := make([]string, len(vw.volumes))
+	for i, v := range vw.volumes {
+		m := Manifest{
+			Collection: vw.collectionName,
+			Volume:     i + 1,
+			Volumes:    len(vw.volumes),
+			FirstChunk: v.firstChunk,
+			LastChunk:  v.lastChunk,
+		}
+		if err := WriteManifest(vw.ctx, v.collPath, m); err != nil {
+			return nil, err
+		}
+		dirs[i] = v.dir
+	}
+	return dirs, nil
+}
+
+// volumeChunkWriter is a ChunkWriter that accounts for the size of the chunk
+// file in its volume once it has been written
+type volumeChunkWriter struct {
+	*ChunkWriter
+	vw          *VolumeWriter
+	volume      *volume
+	path        string
+	chunkNumber int
+}
+
+// Close writes the chunk and records its size on disk
+func (w *volumeChunkWriter) Close() error {
+	if err := w.ChunkWriter.Close(); err != nil {
+		return err
+	}
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat chunk file %s: %w", w.path, err)
+	}
+	size := info.Size()
+	if size+manifestReserve > w.vw.maxSize {
+		return fmt.Errorf("chunk %d of collection %s is %d bytes, which does not fit in a %d byte volume",
+			w.chunkNumber, w.vw.collectionName, size, w.vw.maxSize)
+	}
+	if size > w.vw.largestChunk {
+		w.vw.largestChunk = size
+	}
+	w.volume.used += size
+	w.volume.lastChunk = w.chunkNumber
+	return nil
+}
+
+// volumePart is one volume of a collection found while locating collections
+type volumePart struct {
+	index    int
+	collPath string
+}
+
+// mergeVolumes combines the volumes found for a collection into a single
+// Collection, using their manifests to verify that no volume is missing
+func mergeVolumes(ctx context.Context, collName string, parts []volumePart) (Collection, error) {
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].index < parts[j].index
+	})
+
+	expected := 0
+	paths := make([]string, len(parts))
+	for i, part := range parts {
+		if i > 0 && parts[i-1].index == part.index {
+			return Collection{}, fmt.Errorf("collection %s has more than one copy of volume %d", collName, part.index)
+		}
+		m, err := ReadManifest(ctx, part.collPath)
+		if err != nil {
+			return Collection{}, err
+		}
+		if m != nil {
+			if m.Collection != collName || m.Volume != part.index {
+				return Collection{}, fmt.Errorf("volume %d of collection %s has a manifest for volume %d of %s",
+					part.index, collName, m.Volume, m.Collection)
+			}
+			expected = m.Volumes
+		}
+		paths[i] = part.collPath
+	}
+
+	// Every volume from 1 up to the recorded total must be present
+	if expected == 0 {
+		expected = parts[len(parts)-1].index
+	}
+	if len(parts) != expected || parts[len(parts)-1].index != expected {
+		var missing []string
+		present := make(map[int]bool)
+		for _, part := range parts {
+			present[part.index] = true
+		}
+		for i := 1; i <= expected; i++ {
+			if !present[i] {
+				missing = append(missing, strconv.Itoa(i))
+			}
+		}
+		if len(missing) == 0 {
+			return Collection{}, fmt.Errorf("collection %s has volumes beyond the %d recorded in its manifest", collName, expected)
+		}
+		return Collection{}, fmt.Errorf("%w: collection %s is missing volume(s) %s of %d", pad.ErrChunkCorrupt, collName, strings.Join(missing, ","), expected)
+	}
+
+	format, naming, err := determineCollectionFormat(paths[0])
+	if err != nil {
+		return Collection{}, fmt.Errorf("failed to determine format for collection %s: %w", collName, err)
+	}
+
+	return Collection{
+		Name:    collName,
+		Path:    paths[0],
+		Format:  format,
+		Naming:  naming,
+		Volumes: paths,
+	}, nil
+}
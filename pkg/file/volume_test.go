@@ -0,0 +1,118 @@
+package file
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestParseVolumeDirName(t *testing.T) {
+	tests := []struct {
+		name       string
+		expectColl string
+		expectVol  int
+		expectOk   bool
+	}{
+		{"3A5.vol01", "3A5", 1, true},
+		{"2B3.vol12", "2B3", 12, true},
+		{"3A5", "", 0, false},
+		{"3A5.vol00", "", 0, false},
+		{"3A5.volXX", "", 0, false},
+		{"notes.vol01", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coll, vol, ok := parseVolumeDirName(tt.name)
+			if coll != tt.expectColl || vol != tt.expectVol || ok != tt.expectOk {
+				t.Errorf("parseVolumeDirName(%s) = (%s, %d, %v), want (%s, %d, %v)",
+					tt.name, coll, vol, ok, tt.expectColl, tt.expectVol, tt.expectOk)
+			}
+		})
+	}
+}
+
+func TestVolumeWriterAndFindCollections(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "volume-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Each chunk file is 1000 bytes, so a volume holds at most 3 of them
+	const chunkCount = 7
+	vw := NewVolumeWriter(ctx, tempDir, "2A2", FormatBin, manifestReserve+3500)
+	for chunk := 1; chunk <= chunkCount; chunk++ {
+		w, err := vw.NewChunkWriter(chunk)
+		if err != nil {
+			t.Fatalf("NewChunkWriter failed: %v", err)
+		}
+		if _, err := w.Write([]byte(strings.Repeat(string(rune('a'+chunk)), 1000))); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+	dirs, err := vw.Finish()
+	if err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	if len(dirs) != 3 {
+		t.Fatalf("Expected 3 volumes, got %d", len(dirs))
+	}
+
+	m, err := ReadManifest(ctx, filepath.Join(dirs[1], "2A2"))
+	if err != nil || m == nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if m.Volume != 2 || m.Volumes != 3 || m.FirstChunk != 4 || m.LastChunk != 6 {
+		t.Errorf("Unexpected manifest for volume 2: %+v", m)
+	}
+
+	// The volumes are found as a single collection whose chunks are read in order
+	collections, tempExtractDir, err := FindCollections(ctx, tempDir)
+	if err != nil {
+		t.Fatalf("FindCollections failed: %v", err)
+	}
+	if tempExtractDir != "" {
+		defer os.RemoveAll(tempExtractDir)
+	}
+	if len(collections) != 1 || len(collections[0].Volumes) != 3 {
+		t.Fatalf("Expected one collection with 3 volumes, got %+v", collections)
+	}
+	reader := NewCollectionReader(collections[0])
+	for chunk := 1; ; chunk++ {
+		data, err := reader.ReadNextChunk(ctx)
+		if err == io.EOF {
+			if chunk != chunkCount+1 {
+				t.Errorf("Expected %d chunks, read %d", chunkCount, chunk-1)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadNextChunk failed: %v", err)
+		}
+		if data[0] != byte('a'+chunk) {
+			t.Errorf("Chunk %d read out of order", chunk)
+		}
+	}
+
+	// A missing volume makes the collection unusable rather than silently truncated
+	if err := os.RemoveAll(dirs[1]); err != nil {
+		t.Fatalf("Failed to remove volume: %v", err)
+	}
+	if _, _, err := FindCollections(ctx, tempDir); err == nil {
+		t.Errorf("Expected FindCollections to reject a collection with a missing volume")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package file
+
+import "fmt"
+
+// readXattrs is not supported on this platform and always returns an empty result
+func readXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// writeXattr is not supported on this platform
+func writeXattr(path string, name string, value []byte) error {
+	return fmt.Errorf("extended attributes are not supported on this platform")
+}
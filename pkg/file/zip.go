@@ -2,14 +2,19 @@ package file
 
 import (
 	"archive/zip"
+	"compress/flate"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/rayozzie/padlock/pkg/pad"
 	"github.com/rayozzie/padlock/pkg/trace"
 )
 
@@ -31,6 +36,13 @@ func ZipCollection(ctx context.Context, collPath string) (string, error) {
 	}
 
 	zw := zip.NewWriter(zipFile)
+	password, err := zipPassword(ctx, zipEncryptionKey{}, zipPath)
+	if err != nil {
+		zipFile.Close()
+		os.Remove(zipPath)
+		log.Error(fmt.Errorf("failed to get the password for %s: %w", zipPath, err))
+		return "", fmt.Errorf("failed to get the password for %s: %w", zipPath, err)
+	}
 
 	// Walk through collection directory and add files to zip
 	err = filepath.Walk(collPath, func(path string, info fs.FileInfo, err error) error {
@@ -56,11 +68,18 @@ func ZipCollection(ctx context.Context, collPath string) (string, error) {
 		if err != nil {
 			return fmt.Errorf("failed to create zip header: %w", err)
 		}
-		header.Name = rel
+		header.Name = archiveEntryName(rel)
 		header.Method = zip.Deflate
 
-		// Create the file in the zip
-		writer, err := zw.CreateHeader(header)
+		// Create the file in the zip, encrypted if the zip is
+		var writer io.Writer
+		var entry io.WriteCloser
+		if password != "" {
+			entry, err = createEncryptedZipEntry(zw, header, info.Size(), password)
+			writer = entry
+		} else {
+			writer, err = zw.CreateHeader(header)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to create zip entry: %w", err)
 		}
@@ -74,6 +93,9 @@ func ZipCollection(ctx context.Context, collPath string) (string, error) {
 
 		// Copy the file content to the zip entry
 		_, err = io.Copy(writer, file)
+		if err == nil && entry != nil {
+			err = entry.Close()
+		}
 		if err != nil {
 			return fmt.Errorf("failed to write file to zip: %w", err)
 		}
@@ -114,6 +136,10 @@ func ExtractZipCollection(ctx context.Context, zipPath string, tempDir string) (
 		return "", fmt.Errorf("failed to open zip file %s: %w", zipPath, err)
 	}
 	defer r.Close()
+	if err := openZipEncryption(ctx, &r.Reader, zipPath); err != nil {
+		log.Error(err)
+		return "", err
+	}
 
 	// Create a unique collection directory in the temp dir
 	collectionDir := strings.TrimSuffix(filepath.Join(tempDir, filepath.Base(zipPath)), ".zip")
@@ -127,7 +153,11 @@ func ExtractZipCollection(ctx context.Context, zipPath string, tempDir string) (
 	// Extract all files
 	log.Debugf("Extracting files from zip")
 	for _, f := range r.File {
-		fpath := filepath.Join(collectionDir, f.Name)
+		fpath, err := safeJoin(collectionDir, zipEntryName(f.Name))
+		if err != nil {
+			log.Error(err)
+			return "", err
+		}
 
 		// Ensure the file's directory exists
 		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
@@ -150,6 +180,11 @@ func ExtractZipCollection(ctx context.Context, zipPath string, tempDir string) (
 		rc, err := f.Open()
 		if err != nil {
 			outFile.Close()
+			if damagedZipEntry(err) {
+				log.Infof("Warning: skipping damaged entry %s in %s: %v", f.Name, zipPath, err)
+				os.Remove(fpath)
+				continue
+			}
 			log.Error(fmt.Errorf("failed to open zip entry: %w", err))
 			return "", fmt.Errorf("failed to open zip entry: %w", err)
 		}
@@ -158,6 +193,13 @@ func ExtractZipCollection(ctx context.Context, zipPath string, tempDir string) (
 		outFile.Close()
 		rc.Close()
 		if err != nil {
+			// A damaged entry leaves a chunk missing, which parity or the other
+			// collections may make up for, so the rest of the archive is still extracted
+			if damagedZipEntry(err) {
+				log.Infof("Warning: skipping damaged entry %s in %s: %v", f.Name, zipPath, err)
+				os.Remove(fpath)
+				continue
+			}
 			log.Error(fmt.Errorf("failed to copy zip entry content: %w", err))
 			return "", fmt.Errorf("failed to copy zip entry content: %w", err)
 		}
@@ -167,6 +209,14 @@ func ExtractZipCollection(ctx context.Context, zipPath string, tempDir string) (
 	return collectionDir, nil
 }
 
+// damagedZipEntry reports whether an error reading a zip entry means that the
+// entry itself is damaged, rather than that extraction cannot proceed
+func damagedZipEntry(err error) bool {
+	var corrupt flate.CorruptInputError
+	return errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) || errors.Is(err, zip.ErrAlgorithm) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &corrupt)
+}
+
 // CleanupCollectionDirectory removes a collection directory once zipping is complete
 func CleanupCollectionDirectory(ctx context.Context, collPath string) error {
 	log := trace.FromContext(ctx).WithPrefix("ZIP")
@@ -180,3 +230,275 @@ func CleanupCollectionDirectory(ctx context.Context, collPath string) error {
 	log.Debugf("Successfully removed original collection directory")
 	return nil
 }
+
+// zipArchive is a collection zip opened so that its files can be read in place,
+// without extracting them. Entries are looked up by name in the zip's central
+// directory, which is read once when the archive is opened. A zip holding
+// several collections, such as a custodian bundle, is shared by a view of each
+// collection's directory, and is closed when the last of them is.
+type zipArchive struct {
+	path   string
+	r      *zip.Reader
+	closer io.Closer // Closes the zip file, or nil for a zip held in memory
+	files  map[string]*zip.File
+	refs   *int // Number of open views of r
+	closed bool
+}
+
+// openZipArchive opens a zip and indexes the files it holds
+func openZipArchive(ctx context.Context, path string) (*zipArchive, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip file %s: %w", path, err)
+	}
+	a, err := newZipArchive(ctx, &r.Reader, r, path)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// readZipArchive indexes the files of a zip read from r, such as one held in
+// memory, which is known by the given name
+func readZipArchive(ctx context.Context, name string, r io.ReaderAt, size int64) (*zipArchive, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip file %s: %w", name, err)
+	}
+	return newZipArchive(ctx, zr, nil, name)
+}
+
+// newZipArchive indexes the files of an open zip, checking its password if it
+// is encrypted
+func newZipArchive(ctx context.Context, r *zip.Reader, closer io.Closer, path string) (*zipArchive, error) {
+	if err := openZipEncryption(ctx, r, path); err != nil {
+		return nil, err
+	}
+	a := &zipArchive{path: path, r: r, closer: closer, files: make(map[string]*zip.File, len(r.File)), refs: new(int)}
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() {
+			a.files[zipEntryName(f.Name)] = f
+		}
+	}
+	*a.refs = 1
+	return a, nil
+}
+
+// sub returns a view of the files within one directory of the archive, named
+// relative to it, which must be closed in addition to the archive itself
+func (a *zipArchive) sub(dir string) *zipArchive {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	v := &zipArchive{path: a.path, r: a.r, closer: a.closer, files: make(map[string]*zip.File), refs: a.refs}
+	for name, f := range a.files {
+		if rest, ok := strings.CutPrefix(name, prefix); ok && rest != "" {
+			v.files[rest] = f
+		}
+	}
+	*a.refs++
+	return v
+}
+
+// names returns the names of the files in the archive, in order
+func (a *zipArchive) names() []string {
+	names := make([]string, 0, len(a.files))
+	for name := range a.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// has reports whether the archive holds a file
+func (a *zipArchive) has(name string) bool {
+	_, ok := a.files[name]
+	return ok
+}
+
+// open opens a file in the archive, failing with fs.ErrNotExist if it isn't there
+func (a *zipArchive) open(name string) (io.ReadCloser, error) {
+	f, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s in %s: %w", name, a.path, fs.ErrNotExist)
+	}
+	return f.Open()
+}
+
+// readFile reads a file in the archive, verifying its checksum
+func (a *zipArchive) readFile(name string) ([]byte, error) {
+	rc, err := a.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// close closes the view, and the zip once no other view of it is open
+func (a *zipArchive) close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	*a.refs--
+	if *a.refs > 0 || a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// ZipWriter writes one collection straight into a zip archive as its chunks
+// are encoded, so that a zipped collection never exists on disk as a directory
+// and is written only once. Chunks are stored rather than compressed, since
+// encoded chunks are indistinguishable from random data, which keeps each one
+// directly readable in place. Archives grow to ZIP64 as needed, so collections
+// larger than 4GB or with more than 65535 chunks are supported.
+type ZipWriter struct {
+	ctx        context.Context
+	path       string
+	collection string
+	format     Format
+	naming     ChunkNaming
+	f          *os.File
+	zw         *zip.Writer
+	password   string
+	err        error
+	finished   bool
+}
+
+// NewZipWriter creates the zip archive for a collection in outputDir, named
+// after the collection (e.g. "3A5.zip")
+func NewZipWriter(ctx context.Context, outputDir string, collectionName string, format Format) (*ZipWriter, error) {
+	log := trace.FromContext(ctx).WithPrefix("ZIP")
+
+	zipPath := filepath.Join(outputDir, collectionName+".zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create zip file %s: %w", zipPath, err))
+		return nil, fmt.Errorf("failed to create zip file %s: %w", zipPath, err)
+	}
+	password, err := zipPassword(ctx, zipEncryptionKey{}, zipPath)
+	if err != nil {
+		f.Close()
+		os.Remove(zipPath)
+		log.Error(fmt.Errorf("failed to get the password for %s: %w", zipPath, err))
+		return nil, fmt.Errorf("failed to get the password for %s: %w", zipPath, err)
+	}
+	zw := zip.NewWriter(f)
+	log.Debugf("Streaming collection %s into %s", collectionName, zipPath)
+	return &ZipWriter{
+		ctx:        ctx,
+		path:       zipPath,
+		collection: collectionName,
+		format:     format,
+		naming:     chunkNaming(ctx),
+		f:          f,
+		zw:         zw,
+		password:   password,
+	}, nil
+}
+
+// Collection returns the collection being written, read in place from its zip
+// once Finish has been called
+func (w *ZipWriter) Collection() Collection {
+	return Collection{Name: w.collection, Path: w.path, Format: w.format, Naming: w.naming, Zip: true}
+}
+
+// NewChunkWriter returns a writer for the given chunk, which is added to the
+// archive when the writer is closed
+func (w *ZipWriter) NewChunkWriter(chunkNumber int) io.WriteCloser {
+	return &zipChunkWriter{w: w, chunkNumber: chunkNumber}
+}
+
+// AddFile adds a file other than a chunk, such as a recovery README, to the archive
+func (w *ZipWriter) AddFile(name string, data []byte) error {
+	return w.add(name, data, zip.Deflate, 0644)
+}
+
+// AddFileMode adds a file other than a chunk with the given mode, such as an
+// executable decoder, to the archive
+func (w *ZipWriter) AddFileMode(name string, data []byte, mode fs.FileMode) error {
+	return w.add(name, data, zip.Deflate, mode)
+}
+
+// add writes one entry to the archive. Entries are written one after another,
+// so chunks must be closed in the order they are to appear.
+func (w *ZipWriter) add(name string, data []byte, method uint16, mode fs.FileMode) error {
+	log := trace.FromContext(w.ctx).WithPrefix("ZIP")
+
+	if w.err != nil {
+		return w.err
+	}
+	if w.finished {
+		return fmt.Errorf("zip file %s is already finished", w.path)
+	}
+	header := &zip.FileHeader{Name: name, Method: method, Modified: time.Now()}
+	header.SetMode(mode)
+	var err error
+	if w.password != "" {
+		var entry io.WriteCloser
+		if entry, err = createEncryptedZipEntry(w.zw, header, int64(len(data)), w.password); err == nil {
+			if _, err = entry.Write(data); err == nil {
+				err = entry.Close()
+			}
+		}
+	} else {
+		var entry io.Writer
+		if entry, err = w.zw.CreateHeader(header); err == nil {
+			_, err = entry.Write(data)
+		}
+	}
+	if err != nil {
+		w.err = fmt.Errorf("failed to add %s to zip file %s: %w", name, w.path, err)
+		log.Error(w.err)
+		return w.err
+	}
+	log.Debugf("Added %s (%d bytes) to %s", name, len(data), w.path)
+	return nil
+}
+
+// Finish writes the archive's central directory and closes it, returning its
+// path. Calling it again, as when cleaning up after a failed encode, does nothing.
+func (w *ZipWriter) Finish() (string, error) {
+	log := trace.FromContext(w.ctx).WithPrefix("ZIP")
+
+	if w.finished {
+		return w.path, w.err
+	}
+	w.finished = true
+	if err := w.zw.Close(); err != nil && w.err == nil {
+		w.err = fmt.Errorf("failed to close zip writer: %w", err)
+	}
+	if err := w.f.Close(); err != nil && w.err == nil {
+		w.err = fmt.Errorf("failed to close zip file: %w", err)
+	}
+	if w.err != nil {
+		log.Error(w.err)
+		return "", w.err
+	}
+	log.Debugf("Successfully created zip archive: %s", w.path)
+	return w.path, nil
+}
+
+// zipChunkWriter collects one chunk and adds it to its collection's archive on Close
+type zipChunkWriter struct {
+	w           *ZipWriter
+	chunkNumber int
+	data        []byte
+}
+
+func (c *zipChunkWriter) Write(p []byte) (int, error) {
+	c.data = append(c.data, p...)
+	return len(p), nil
+}
+
+func (c *zipChunkWriter) Close() error {
+	if err := pad.CheckRandomness(c.w.ctx, fmt.Sprintf("collection %s chunk %d", c.w.collection, c.chunkNumber), c.data); err != nil {
+		return err
+	}
+	contents, err := encodeChunkFile(c.w.format, c.data)
+	if err != nil {
+		return fmt.Errorf("failed to format chunk %d of collection %s: %w", c.chunkNumber, c.w.collection, err)
+	}
+	return c.w.add(c.w.Collection().chunkFileName(c.chunkNumber), contents, zip.Store, 0644)
+}
@@ -1,11 +1,18 @@
 package file
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/rayozzie/padlock/pkg/pad"
 	"github.com/rayozzie/padlock/pkg/trace"
 )
 
@@ -158,3 +165,347 @@ func TestExtractZipCollection(t *testing.T) {
 		}
 	}
 }
+
+func TestExtractDamagedZipCollection(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "zip-damaged-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A collection whose second chunk is stored uncompressed, so that it can be damaged in place
+	zipPath := filepath.Join(tempDir, "3A5.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatalf("Failed to create zip: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"3A5_0001.bin", "3A5_0002.bin", "3A5_0003.bin"} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		w.Write([]byte("intact content of " + name))
+	}
+	zw.Close()
+	f.Close()
+
+	data, err := os.ReadFile(zipPath)
+	if err != nil {
+		t.Fatalf("Failed to read zip: %v", err)
+	}
+	i := bytes.Index(data, []byte("intact content of 3A5_0002.bin"))
+	data[i] = 'X'
+	if err := os.WriteFile(zipPath, data, 0644); err != nil {
+		t.Fatalf("Failed to damage zip: %v", err)
+	}
+
+	// The damaged entry is skipped and the rest extracted
+	extractDir := filepath.Join(tempDir, "extract")
+	collDir, err := ExtractZipCollection(ctx, zipPath, extractDir)
+	if err != nil {
+		t.Fatalf("ExtractZipCollection failed: %v", err)
+	}
+	for name, want := range map[string]bool{"3A5_0001.bin": true, "3A5_0002.bin": false, "3A5_0003.bin": true} {
+		if _, err := os.Stat(filepath.Join(collDir, name)); (err == nil) != want {
+			t.Errorf("Expected %s to be extracted: %v, got error %v", name, want, err)
+		}
+	}
+}
+
+func TestZipWriter(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	for _, format := range []Format{FormatBin, FormatPNG} {
+		t.Run(string(format), func(t *testing.T) {
+			tempDir, err := os.MkdirTemp("", "zip-writer-test-*")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			// Stream three chunks and a README into the zip
+			chunks := [][]byte{[]byte("first chunk"), []byte("second chunk"), []byte("third chunk")}
+			zw, err := NewZipWriter(ctx, tempDir, "2A3", format)
+			if err != nil {
+				t.Fatalf("NewZipWriter failed: %v", err)
+			}
+			for i, chunk := range chunks {
+				w := zw.NewChunkWriter(i + 1)
+				if _, err := w.Write(chunk); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatalf("Close failed: %v", err)
+				}
+			}
+			if err := zw.AddFile("README.txt", []byte("how to recover")); err != nil {
+				t.Fatalf("AddFile failed: %v", err)
+			}
+			zipPath, err := zw.Finish()
+			if err != nil {
+				t.Fatalf("Finish failed: %v", err)
+			}
+			if zipPath != filepath.Join(tempDir, "2A3.zip") {
+				t.Errorf("Expected zip path %s, got %s", filepath.Join(tempDir, "2A3.zip"), zipPath)
+			}
+			if _, err := zw.Finish(); err != nil {
+				t.Errorf("Expected a second Finish to do nothing, got %v", err)
+			}
+
+			// Chunks are stored, other files compressed
+			r, err := zip.OpenReader(zipPath)
+			if err != nil {
+				t.Fatalf("Failed to open zip: %v", err)
+			}
+			for _, f := range r.File {
+				if want := map[bool]uint16{true: zip.Deflate, false: zip.Store}[f.Name == "README.txt"]; f.Method != want {
+					t.Errorf("Expected %s to have method %d, got %d", f.Name, want, f.Method)
+				}
+			}
+			r.Close()
+
+			// The collection is found and read in place, without extracting it
+			collections, extractDir, err := FindCollections(ctx, tempDir)
+			if err != nil {
+				t.Fatalf("FindCollections failed: %v", err)
+			}
+			defer CloseCollections(collections)
+			if extractDir != "" {
+				os.RemoveAll(extractDir)
+				t.Errorf("Expected the zip to be read in place, but it was extracted to %s", extractDir)
+			}
+			if len(collections) != 1 || !collections[0].Zip || collections[0].Path != zipPath || collections[0].Format != format {
+				t.Fatalf("Unexpected collections: %+v", collections)
+			}
+			numbers, err := ChunkNumbers(collections[0])
+			if err != nil || len(numbers) != 3 || numbers[2] != 3 {
+				t.Errorf("Expected chunks 1 to 3, got %v (%v)", numbers, err)
+			}
+			reader := NewCollectionReader(collections[0])
+			for i, want := range chunks {
+				data, err := reader.ReadNextChunk(ctx)
+				if err != nil {
+					t.Fatalf("ReadNextChunk %d failed: %v", i+1, err)
+				}
+				if !bytes.Equal(data, want) {
+					t.Errorf("Chunk %d is %q, expected %q", i+1, data, want)
+				}
+			}
+			if _, err := reader.ReadNextChunk(ctx); err != io.EOF {
+				t.Errorf("Expected EOF after the last chunk, got %v", err)
+			}
+			if _, err := ReadChunk(ctx, collections[0], 4); !errors.Is(err, pad.ErrChunkCorrupt) {
+				t.Errorf("Expected a missing chunk to be reported as corrupt, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadDamagedZipChunkInPlace(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "zip-damaged-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	zw, err := NewZipWriter(ctx, tempDir, "3A5", FormatBin)
+	if err != nil {
+		t.Fatalf("NewZipWriter failed: %v", err)
+	}
+	for n := 1; n <= 3; n++ {
+		w := zw.NewChunkWriter(n)
+		w.Write([]byte(fmt.Sprintf("intact content of chunk %d", n)))
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+	zipPath, err := zw.Finish()
+	if err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	data, err := os.ReadFile(zipPath)
+	if err != nil {
+		t.Fatalf("Failed to read zip: %v", err)
+	}
+	data[bytes.Index(data, []byte("intact content of chunk 2"))] = 'X'
+	if err := os.WriteFile(zipPath, data, 0644); err != nil {
+		t.Fatalf("Failed to damage zip: %v", err)
+	}
+
+	// Only the damaged chunk fails, as corrupt, so it can be made up for elsewhere
+	collections, _, err := FindCollections(ctx, tempDir)
+	if err != nil {
+		t.Fatalf("FindCollections failed: %v", err)
+	}
+	defer CloseCollections(collections)
+	for n, wantErr := range map[int]bool{1: false, 2: true, 3: false} {
+		_, err := ReadChunk(ctx, collections[0], n)
+		if wantErr && !errors.Is(err, pad.ErrChunkCorrupt) || !wantErr && err != nil {
+			t.Errorf("Chunk %d: expected an error %v, got %v", n, wantErr, err)
+		}
+	}
+}
+
+func TestZipArchiveViews(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "zip-views-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A bundle-like zip holding two collection directories
+	zipPath := filepath.Join(tempDir, "ceo.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatalf("Failed to create zip: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"2A3/2A3_0001.bin", "2A3/2A3_0002.bin", "2B3/2B3_0001.bin", CustodianManifestFileName} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		w.Write([]byte(name))
+	}
+	zw.Close()
+	f.Close()
+
+	archive, err := openZipArchive(ctx, zipPath)
+	if err != nil {
+		t.Fatalf("openZipArchive failed: %v", err)
+	}
+	a, b := archive.sub("2A3"), archive.sub("2B3")
+	if got := strings.Join(a.names(), ","); got != "2A3_0001.bin,2A3_0002.bin" {
+		t.Errorf("Expected the files of 2A3, got %s", got)
+	}
+	if data, err := b.readFile("2B3_0001.bin"); err != nil || string(data) != "2B3/2B3_0001.bin" {
+		t.Errorf("Expected to read 2B3_0001.bin, got %q (%v)", data, err)
+	}
+
+	// The zip stays open until every view is closed, however often each is
+	archive.close()
+	a.close()
+	a.close()
+	if _, err := b.readFile("2B3_0001.bin"); err != nil {
+		t.Errorf("Expected the zip to remain open for the last view, got %v", err)
+	}
+	if err := b.close(); err != nil {
+		t.Errorf("close failed: %v", err)
+	}
+	if *b.refs != 0 {
+		t.Errorf("Expected no open views, got %d", *b.refs)
+	}
+}
+
+func TestZipWriterManyChunks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping ZIP64 test in short mode")
+	}
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelNormal)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "zip-zip64-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// More entries than a zip without ZIP64 extensions can count
+	const chunkCount = 70000
+	zw, err := NewZipWriter(ctx, tempDir, "2B2", FormatBin)
+	if err != nil {
+		t.Fatalf("NewZipWriter failed: %v", err)
+	}
+	for n := 1; n <= chunkCount; n++ {
+		w := zw.NewChunkWriter(n)
+		w.Write([]byte{byte(n)})
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close of chunk %d failed: %v", n, err)
+		}
+	}
+	if _, err := zw.Finish(); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+
+	collections, _, err := FindCollections(ctx, tempDir)
+	if err != nil {
+		t.Fatalf("FindCollections failed: %v", err)
+	}
+	defer CloseCollections(collections)
+	numbers, err := ChunkNumbers(collections[0])
+	if err != nil || len(numbers) != chunkCount {
+		t.Fatalf("Expected %d chunks, got %d (%v)", chunkCount, len(numbers), err)
+	}
+	data, err := ReadChunk(ctx, collections[0], chunkCount)
+	if err != nil || len(data) != 1 || data[0] != byte(chunkCount%256) {
+		t.Errorf("Unexpected last chunk %v (%v)", data, err)
+	}
+}
+
+// FuzzExtractZipCollection checks that untrusted zips are extracted without
+// panicking, and never write outside of the collection's directory
+func FuzzExtractZipCollection(f *testing.F) {
+	// The fuzzer extracts many zips a second, so nothing is logged
+	tracer := trace.NewTracer("TEST", trace.LogLevelError)
+	tracer.SetSinks()
+	ctx := trace.WithContext(context.Background(), tracer)
+	ctx = WithZipPasswords(ctx, func(name string) (string, error) { return "fuzz", nil })
+
+	zipOf := func(names ...string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, name := range names {
+			w, _ := zw.Create(name)
+			w.Write([]byte("content of " + name))
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+	f.Add(zipOf("2A3/IMG2A3_0001.PNG", "2A3/IMG2A3_0002.PNG"))
+	f.Add(zipOf("2A3_0001.bin", "subdir/2A3_0002.bin"))
+	f.Add(zipOf("../escape.bin"))
+	f.Add(zipOf(`..\escape.bin`))
+	f.Add(zipOf("/absolute.bin"))
+	f.Add([]byte("PK\x03\x04"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dir := t.TempDir()
+		zipPath := filepath.Join(dir, "2A3.zip")
+		if err := os.WriteFile(zipPath, data, 0644); err != nil {
+			t.Fatalf("Failed to write zip: %v", err)
+		}
+		tempDir := filepath.Join(dir, "extract")
+		if err := os.MkdirAll(tempDir, 0755); err != nil {
+			t.Fatalf("Failed to create extract dir: %v", err)
+		}
+		ExtractZipCollection(ctx, zipPath, tempDir)
+		for _, parent := range []string{dir, tempDir} {
+			entries, err := os.ReadDir(parent)
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", parent, err)
+			}
+			for _, e := range entries {
+				if name := e.Name(); name != "2A3.zip" && name != "extract" && name != "2A3" {
+					t.Errorf("Extracting wrote %s outside of the collection directory", filepath.Join(parent, name))
+				}
+			}
+		}
+	})
+}
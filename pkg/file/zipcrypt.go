@@ -0,0 +1,382 @@
+package file
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Zips are encrypted with WinZip AES-256, which most zip tools can open, so
+// that an encrypted collection can also be inspected by hand. Each entry is
+// recorded with method zipMethodAES and an extra field holding its real method,
+// and its data is a random salt, a password verifier, the entry encrypted with
+// AES in counter mode, and an HMAC-SHA1 authentication code. Entries are
+// written as AE-2, whose CRC field is zero, as the CRC of a small entry such
+// as a manifest would give its contents away; the authentication code checks
+// them instead. Zips written as AE-1, which keeps the CRC, are read too.
+const (
+	zipMethodAES       = 99
+	zipAESExtraID      = 0x9901
+	zipAESVersion      = 2
+	zipAESStrength256  = 3
+	zipAESKeySize      = 32
+	zipAESSaltSize     = 16
+	zipAESVerifierSize = 2
+	zipAESMACSize      = 10
+	zipAESIterations   = 1000
+)
+
+// ErrZipPassword is returned when an encrypted zip is opened without its
+// password or with the wrong one
+var ErrZipPassword = errors.New("incorrect or missing zip password")
+
+// ZipPasswordFunc returns the password of the zip of a collection, or of the
+// custodian bundle named name. The volumes of a collection share its password.
+type ZipPasswordFunc func(name string) (string, error)
+
+type zipPasswordsKey struct{}
+type zipEncryptionKey struct{}
+
+// WithZipPasswords returns a context under which encrypted zips are opened
+// with the passwords supplied by fn
+func WithZipPasswords(ctx context.Context, fn ZipPasswordFunc) context.Context {
+	return context.WithValue(ctx, zipPasswordsKey{}, fn)
+}
+
+// WithZipEncryption returns a context under which the zips created are
+// encrypted, each with the password supplied by fn
+func WithZipEncryption(ctx context.Context, fn ZipPasswordFunc) context.Context {
+	return context.WithValue(ctx, zipEncryptionKey{}, fn)
+}
+
+// zipPassword returns the password of a zip from the function stored under key,
+// or "" if the context has none
+func zipPassword(ctx context.Context, key any, zipPath string) (string, error) {
+	fn, _ := ctx.Value(key).(ZipPasswordFunc)
+	if fn == nil {
+		return "", nil
+	}
+	name := strings.TrimSuffix(filepath.Base(zipPath), ".zip")
+	if collName, _, ok := parseVolumeDirName(name); ok {
+		name = collName
+	}
+	return fn(name)
+}
+
+// LoadZipPasswords reads a file of zip passwords, one NAME=PASSWORD per line,
+// where NAME is a collection (e.g. "3A5") or custodian, or "*" for any other.
+// Blank lines and lines starting with # are ignored.
+func LoadZipPasswords(path string) (ZipPasswordFunc, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip passwords: %w", err)
+	}
+	defer f.Close()
+
+	passwords := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(strings.TrimSpace(text), "#") {
+			continue
+		}
+		name, password, ok := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || password == "" {
+			return nil, fmt.Errorf("%s:%d: expected NAME=PASSWORD", path, line)
+		}
+		passwords[name] = password
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read zip passwords: %w", err)
+	}
+	return func(name string) (string, error) {
+		if password, ok := passwords[name]; ok {
+			return password, nil
+		}
+		if password, ok := passwords["*"]; ok {
+			return password, nil
+		}
+		return "", fmt.Errorf("%s has no password for %s", path, name)
+	}, nil
+}
+
+// createEncryptedZipEntry adds an entry of size bytes to a zip, encrypted with
+// the password as it is written. Its data is stored, since compression would
+// only be worthwhile for the few entries other than chunks. The entry is
+// written raw, with its sizes given up front, so that the zip writer records
+// no CRC of its contents. Exactly size bytes must be written, and the writer
+// closed to end the entry.
+func createEncryptedZipEntry(zw *zip.Writer, h *zip.FileHeader, size int64, password string) (io.WriteCloser, error) {
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], zipAESExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], zipAESVersion)
+	copy(extra[6:], "AE")
+	extra[8] = zipAESStrength256
+	binary.LittleEndian.PutUint16(extra[9:], zip.Store)
+	h.Extra = append(h.Extra, extra...)
+	h.Method = zipMethodAES
+	h.Flags = h.Flags&^0x8 | 0x1
+	if utf8.ValidString(h.Name) && strings.ContainsFunc(h.Name, func(r rune) bool { return r >= utf8.RuneSelf }) {
+		h.Flags |= 0x800
+	}
+	h.CreatorVersion = h.CreatorVersion&0xff00 | 20
+	h.ReaderVersion = 20
+	if !h.Modified.IsZero() {
+		t := h.Modified
+		h.ModifiedDate = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+		h.ModifiedTime = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	}
+	h.CRC32 = 0
+	h.UncompressedSize64 = uint64(size)
+	h.CompressedSize64 = uint64(size) + zipAESSaltSize + zipAESVerifierSize + zipAESMACSize
+
+	w, err := zw.CreateRaw(h)
+	if err != nil {
+		return nil, err
+	}
+	return newZipAESWriter(w, password, size)
+}
+
+// zipEncrypted reports whether a zip holds encrypted entries
+func zipEncrypted(r *zip.Reader) bool {
+	for _, f := range r.File {
+		if f.Method == zipMethodAES {
+			return true
+		}
+	}
+	return false
+}
+
+// openZipEncryption prepares an opened zip for reading, with the password
+// from the context if it is encrypted
+func openZipEncryption(ctx context.Context, r *zip.Reader, zipPath string) error {
+	if !zipEncrypted(r) {
+		return nil
+	}
+	password, err := zipPassword(ctx, zipPasswordsKey{}, zipPath)
+	if err != nil {
+		return fmt.Errorf("failed to get the password for %s: %w", zipPath, err)
+	}
+	return decryptZipReader(r, zipPath, password)
+}
+
+// decryptZipReader registers the AES decompressor on a zip reader, first
+// checking the password against the verifier of an encrypted entry
+func decryptZipReader(r *zip.Reader, zipPath string, password string) error {
+	for _, f := range r.File {
+		if f.Method != zipMethodAES {
+			continue
+		}
+		if zipAESMethod(f) != zip.Store {
+			return fmt.Errorf("%s in %s uses an unsupported encryption", f.Name, zipPath)
+		}
+		if password == "" {
+			return fmt.Errorf("%s is encrypted: %w", zipPath, ErrZipPassword)
+		}
+		raw, err := f.OpenRaw()
+		if err != nil {
+			return fmt.Errorf("failed to read %s in %s: %w", f.Name, zipPath, err)
+		}
+		header := make([]byte, zipAESSaltSize+zipAESVerifierSize)
+		if _, err := io.ReadFull(raw, header); err != nil {
+			return fmt.Errorf("failed to read %s in %s: %w", f.Name, zipPath, zip.ErrFormat)
+		}
+		if _, _, _, err := zipAESKeys(password, header, true); err != nil {
+			return fmt.Errorf("%s: %w", zipPath, err)
+		}
+		break
+	}
+	r.RegisterDecompressor(zipMethodAES, func(rd io.Reader) io.ReadCloser {
+		return &zipAESReader{r: rd, password: password}
+	})
+	return nil
+}
+
+// zipAESMethod returns the real method of an encrypted entry, from its AES
+// extra field
+func zipAESMethod(f *zip.File) uint16 {
+	extra := f.Extra
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if id == zipAESExtraID && size == 7 && extra[8] == zipAESStrength256 {
+			return binary.LittleEndian.Uint16(extra[9:])
+		}
+		extra = extra[4+size:]
+	}
+	return 0xffff
+}
+
+// zipAESKeys derives the encryption and authentication keys of an entry from
+// the password and the salt at the start of header, which must be followed by
+// the password verifier unless verify is false
+func zipAESKeys(password string, header []byte, verify bool) (cipher.Block, hash.Hash, []byte, error) {
+	key, err := pbkdf2.Key(sha1.New, password, header[:zipAESSaltSize], zipAESIterations, 2*zipAESKeySize+zipAESVerifierSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	verifier := key[2*zipAESKeySize:]
+	if verify && subtle.ConstantTimeCompare(verifier, header[zipAESSaltSize:zipAESSaltSize+zipAESVerifierSize]) != 1 {
+		return nil, nil, nil, ErrZipPassword
+	}
+	block, err := aes.NewCipher(key[:zipAESKeySize])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return block, hmac.New(sha1.New, key[zipAESKeySize:2*zipAESKeySize]), verifier, nil
+}
+
+// zipAESStream is AES in the counter mode of WinZip AES, whose counter is
+// little-endian and starts at 1
+type zipAESStream struct {
+	block     cipher.Block
+	counter   [aes.BlockSize]byte
+	keystream [aes.BlockSize]byte
+	used      int
+}
+
+func newZipAESStream(block cipher.Block) *zipAESStream {
+	return &zipAESStream{block: block, used: aes.BlockSize}
+}
+
+// XORKeyStream encrypts or decrypts src into dst
+func (s *zipAESStream) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.used == aes.BlockSize {
+			for j := range s.counter {
+				s.counter[j]++
+				if s.counter[j] != 0 {
+					break
+				}
+			}
+			s.block.Encrypt(s.keystream[:], s.counter[:])
+			s.used = 0
+		}
+		dst[i] = src[i] ^ s.keystream[s.used]
+		s.used++
+	}
+}
+
+// zipAESWriter encrypts one entry as it is written. The salt and verifier are
+// written with the first data, as the zip writer creates the compressor of an
+// entry before writing its header.
+type zipAESWriter struct {
+	w       io.Writer
+	header  []byte
+	stream  *zipAESStream
+	mac     hash.Hash
+	buf     []byte
+	size    int64 // Bytes the entry was created with
+	written int64
+}
+
+func newZipAESWriter(w io.Writer, password string, size int64) (*zipAESWriter, error) {
+	salt := make([]byte, zipAESSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	block, mac, verifier, err := zipAESKeys(password, salt, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive the zip encryption key: %w", err)
+	}
+	return &zipAESWriter{w: w, header: append(salt, verifier...), stream: newZipAESStream(block), mac: mac, size: size}, nil
+}
+
+// writeHeader writes the salt and verifier ahead of the entry's data
+func (z *zipAESWriter) writeHeader() error {
+	if z.header == nil {
+		return nil
+	}
+	_, err := z.w.Write(z.header)
+	z.header = nil
+	return err
+}
+
+func (z *zipAESWriter) Write(p []byte) (int, error) {
+	if z.written+int64(len(p)) > z.size {
+		return 0, fmt.Errorf("entry is larger than its %d bytes", z.size)
+	}
+	if err := z.writeHeader(); err != nil {
+		return 0, err
+	}
+	z.written += int64(len(p))
+	z.buf = append(z.buf[:0], p...)
+	z.stream.XORKeyStream(z.buf, z.buf)
+	z.mac.Write(z.buf)
+	if _, err := z.w.Write(z.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the authentication code that ends the entry
+func (z *zipAESWriter) Close() error {
+	if z.written != z.size {
+		return fmt.Errorf("entry holds %d of its %d bytes", z.written, z.size)
+	}
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+	_, err := z.w.Write(z.mac.Sum(nil)[:zipAESMACSize])
+	return err
+}
+
+// zipAESReader decrypts one entry, which is read and authenticated in full
+// before any of it is returned, so that tampering is never mistaken for data
+type zipAESReader struct {
+	r        io.Reader
+	password string
+	plain    *bytes.Reader
+}
+
+func (z *zipAESReader) Read(p []byte) (int, error) {
+	if z.plain == nil {
+		data, err := io.ReadAll(z.r)
+		if err != nil {
+			return 0, err
+		}
+		if len(data) < zipAESSaltSize+zipAESVerifierSize+zipAESMACSize {
+			return 0, zip.ErrFormat
+		}
+		// The password was verified when the zip was opened, so an entry
+		// whose verifier doesn't match it is damaged
+		block, mac, _, err := zipAESKeys(z.password, data, true)
+		if err != nil {
+			return 0, zip.ErrChecksum
+		}
+		body := data[zipAESSaltSize+zipAESVerifierSize : len(data)-zipAESMACSize]
+		mac.Write(body)
+		if !hmac.Equal(mac.Sum(nil)[:zipAESMACSize], data[len(data)-zipAESMACSize:]) {
+			return 0, zip.ErrChecksum
+		}
+		newZipAESStream(block).XORKeyStream(body, body)
+		z.plain = bytes.NewReader(body)
+	}
+	return z.plain.Read(p)
+}
+
+func (z *zipAESReader) Close() error {
+	return nil
+}
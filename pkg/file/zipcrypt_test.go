@@ -0,0 +1,154 @@
+package file
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestEncryptedZip(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "zipcrypt-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	passwordFile := filepath.Join(tempDir, "passwords.txt")
+	if err := os.WriteFile(passwordFile, []byte("# test passwords\n2A3=first secret\n\n*=other=secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write passwords: %v", err)
+	}
+	passwords, err := LoadZipPasswords(passwordFile)
+	if err != nil {
+		t.Fatalf("LoadZipPasswords failed: %v", err)
+	}
+	for name, want := range map[string]string{"2A3": "first secret", "2B3": "other=secret"} {
+		if got, err := passwords(name); err != nil || got != want {
+			t.Errorf("Expected password %q for %s, got %q (%v)", want, name, got, err)
+		}
+	}
+
+	// One collection zipped from a directory, the other streamed into its zip
+	outputDir := filepath.Join(tempDir, "out")
+	encrypting := WithZipEncryption(ctx, passwords)
+	chunks := map[string][]byte{"2A3": bytes.Repeat([]byte("A"), 5000), "2B3": bytes.Repeat([]byte("B"), 5000)}
+	collPath, err := CreateCollectionDirectory(ctx, outputDir, "2A3")
+	if err != nil {
+		t.Fatalf("CreateCollectionDirectory failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(collPath, ChunkFileName(FormatBin, "2A3", 1)), chunks["2A3"], 0644); err != nil {
+		t.Fatalf("Failed to write chunk: %v", err)
+	}
+	if _, err := ZipCollection(encrypting, collPath); err != nil {
+		t.Fatalf("ZipCollection failed: %v", err)
+	}
+	os.RemoveAll(collPath)
+	zw, err := NewZipWriter(encrypting, outputDir, "2B3", FormatBin)
+	if err != nil {
+		t.Fatalf("NewZipWriter failed: %v", err)
+	}
+	w := zw.NewChunkWriter(1)
+	w.Write(chunks["2B3"])
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := zw.Finish(); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+
+	// Nothing of a chunk is readable from its zip without the password
+	for name := range chunks {
+		data, err := os.ReadFile(filepath.Join(outputDir, name+".zip"))
+		if err != nil {
+			t.Fatalf("Failed to read zip: %v", err)
+		}
+		if bytes.Contains(data, chunks[name][:64]) {
+			t.Errorf("Expected the chunk of %s to be encrypted", name)
+		}
+
+		// Nor is its CRC, which is zero in AE-2 entries
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("Failed to open zip: %v", err)
+		}
+		for _, f := range zr.File {
+			i := bytes.Index(f.Extra, []byte{0x01, 0x99, 7, 0})
+			if f.CRC32 != 0 || i < 0 || binary.LittleEndian.Uint16(f.Extra[i+4:]) != 2 {
+				t.Errorf("Expected %s in %s to be AE-2 with no CRC, got CRC %08x and extra %x", f.Name, name, f.CRC32, f.Extra)
+			}
+		}
+	}
+
+	t.Run("Correct passwords", func(t *testing.T) {
+		collections, tempPath, err := FindCollections(WithZipPasswords(ctx, passwords), outputDir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer CloseCollections(collections)
+		if tempPath != "" || len(collections) != 2 {
+			t.Fatalf("Expected 2 collections read in place, got %+v (%s)", collections, tempPath)
+		}
+		for _, coll := range collections {
+			data, err := ReadChunk(ctx, coll, 1)
+			if err != nil || !bytes.Equal(data, chunks[coll.Name]) {
+				t.Errorf("Expected to read the chunk of %s, got %d bytes (%v)", coll.Name, len(data), err)
+			}
+		}
+	})
+
+	t.Run("Wrong or missing password", func(t *testing.T) {
+		wrong := func(name string) (string, error) { return "wrong", nil }
+		for name, readCtx := range map[string]context.Context{"wrong": WithZipPasswords(ctx, wrong), "missing": ctx} {
+			collections, tempPath, err := FindCollections(readCtx, outputDir)
+			CloseCollections(collections)
+			if tempPath != "" {
+				os.RemoveAll(tempPath)
+			}
+			if !errors.Is(err, ErrNoCollections) {
+				t.Errorf("%s: expected ErrNoCollections, got %v", name, err)
+			}
+		}
+		if _, err := openZipArchive(WithZipPasswords(ctx, wrong), filepath.Join(outputDir, "2A3.zip")); !errors.Is(err, ErrZipPassword) {
+			t.Errorf("Expected ErrZipPassword, got %v", err)
+		}
+	})
+
+	t.Run("Tampered chunk", func(t *testing.T) {
+		zipPath := filepath.Join(outputDir, "2B3.zip")
+		data, err := os.ReadFile(zipPath)
+		if err != nil {
+			t.Fatalf("Failed to read zip: %v", err)
+		}
+		data[len(data)/2] ^= 0x01
+		if err := os.WriteFile(zipPath, data, 0644); err != nil {
+			t.Fatalf("Failed to tamper with zip: %v", err)
+		}
+		collections, _, err := FindCollections(WithZipPasswords(ctx, passwords), outputDir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer CloseCollections(collections)
+		if _, err := ReadChunk(ctx, collections[1], 1); !errors.Is(err, pad.ErrChunkCorrupt) {
+			t.Errorf("Expected the tampered chunk to be corrupt, got %v", err)
+		}
+	})
+
+	t.Run("Invalid password file", func(t *testing.T) {
+		bad := filepath.Join(tempDir, "bad.txt")
+		os.WriteFile(bad, []byte("2A3 secret\n"), 0600)
+		if _, err := LoadZipPasswords(bad); err == nil {
+			t.Errorf("Expected an error for a line without =")
+		}
+	})
+}
@@ -0,0 +1,226 @@
+package pad
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// An encode can be split in two, so that the random pads are generated on one
+// machine, typically air-gapped with a trusted random source, and applied to
+// the plaintext on another, which never needs a random source at all.
+//
+// In every permutation of K collections, the first collection's piece is the
+// plaintext XORed with the other K-1 pieces, which are random. GeneratePads
+// writes a set of pad collections holding the random pieces, and in place of
+// each first piece the XOR of the random ones: the encode of an input of zeros.
+// ApplyPads then XORs the plaintext into the first pieces, which is the only
+// step that needs it, producing collections identical in form to an encode.
+//
+// Pad collections must be used only once, and must be destroyed once applied:
+// they decode to zeros on their own, but XORed with cipher collections made
+// from them they reveal the plaintext.
+
+// GeneratePads writes pad collections with room for chunks chunks of
+// chunkDataBytes of input each, for ApplyPads to consume.
+//
+// Parameters:
+//   - ctx: Context for logging, cancellation, and tracing
+//   - chunks: Number of chunks of pads to generate
+//   - chunkDataBytes: Input bytes each chunk can hold, the size of each piece
+//   - randomSource: Source of cryptographically secure random bytes
+//   - newChunk: Function to create output files for each chunk
+//   - chunkFormat: Format for output files (e.g., "bin" or "png")
+func (p *Pad) GeneratePads(ctx context.Context, chunks int, chunkDataBytes int, randomSource RNG, newChunk NewChunkFunc, chunkFormat string) error {
+	ctx, span := trace.StartSpan(ctx, "generate pads")
+	defer span.End()
+	log := trace.FromContext(ctx).WithPrefix("PADS")
+
+	if chunks <= 0 || chunkDataBytes <= 0 {
+		return fmt.Errorf("%w: pads need at least one chunk of at least one byte, got %d chunks of %d bytes", ErrInvalidParameters, chunks, chunkDataBytes)
+	}
+	log.Debugf("Generating %d chunks of pads of %d bytes", chunks, chunkDataBytes)
+	st := &encodeState{}
+	zeros := make([]byte, chunkDataBytes)
+	for chunkNumber := 1; chunkNumber <= chunks; chunkNumber++ {
+		if err := p.encodeOneChunk(ctx, st, zeros, chunkNumber, randomSource, newChunk, chunkFormat); err != nil {
+			return err
+		}
+	}
+	log.Debugf("Generated pads for %d bytes of input", chunks*chunkDataBytes)
+	return nil
+}
+
+// ApplyPads encodes input with the pads of pad collections written by
+// GeneratePads, writing the cipher collections through newChunk. No random
+// numbers are needed: each chunk of input is XORed into the first piece of
+// every permutation of a chunk of the pads, and every piece is cut to the
+// length of the input the chunk holds. The collections written keep the
+// session ID of the pads.
+//
+// All N pad collections are required, as each holds pieces no other does. The
+// input must fit in the pads, or ErrPadsTooSmall is returned; any chunks of pads left over are not written.
+//
+// Parameters:
+//   - ctx: Context for logging, cancellation, and tracing
+//   - pads: One reader per pad collection, in any order, all N of them
+//   - input: The data to encode
+//   - newChunk: Function to create output files for each chunk
+//   - chunkFormat: Format for output files (e.g., "bin" or "png")
+func (p *Pad) ApplyPads(ctx context.Context, pads []io.Reader, input io.Reader, newChunk NewChunkFunc, chunkFormat string) error {
+	ctx, span := trace.StartSpan(ctx, "apply pads")
+	defer span.End()
+	return countFailure(p.applyPads(ctx, pads, input, newChunk, chunkFormat))
+}
+
+// applyPads is ApplyPads, without counting its failure
+func (p *Pad) applyPads(ctx context.Context, pads []io.Reader, input io.Reader, newChunk NewChunkFunc, chunkFormat string) error {
+	log := trace.FromContext(ctx).WithPrefix("PADS")
+
+	log.Debugf("Applying %d pad collections", len(pads))
+	if len(pads) == 0 {
+		return fmt.Errorf("%w: no pad collections", ErrInsufficientCollections)
+	}
+	padInitialized := false
+	var session uint64
+	var buffer []byte
+	defer func() { Zeroize(buffer) }()
+	for chunkNumber := 1; ; chunkNumber++ {
+
+		// Read the next chunk of pads of every collection, keyed by collection letter
+		bodies := make(map[string][]byte, len(pads))
+		chunkDataBytes := 0
+		done := 0
+		for i, r := range pads {
+			header, err := readChunkHeader(r)
+			if err == io.EOF {
+				done++
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("pad collection %d: %w", i, err)
+			}
+			collName := header.collName
+			requiredCopies, totalCopies, collLetter, err := extractFromCollectionLabel(collName)
+			if err != nil {
+				return fmt.Errorf("pad collection %d: invalid collection name %s: %w", i, collName, err)
+			}
+
+			// The first chunk determines the scheme, which must include every collection
+			if !padInitialized {
+				scheme, err := p.forScheme(ctx, totalCopies, requiredCopies)
+				if err != nil {
+					return err
+				}
+				if len(pads) != totalCopies {
+					return fmt.Errorf("%w: applying pads requires all %d pad collections, got %d", ErrInsufficientCollections, totalCopies, len(pads))
+				}
+				p, session = scheme, header.session
+				padInitialized = true
+			}
+			if requiredCopies != p.RequiredCopies || totalCopies != p.TotalCopies || header.session != session {
+				return fmt.Errorf("%w: pad collection %s does not belong to the same set of pads", ErrSessionMismatch, collName)
+			}
+			if header.number != chunkNumber {
+				return fmt.Errorf("%w: pad collection %s: chunk number mismatch: expected %d, got %d", ErrChunkCorrupt, collName, chunkNumber, header.number)
+			}
+			if _, dup := bodies[collLetter]; dup {
+				return fmt.Errorf("pad collection %s was supplied more than once", collName)
+			}
+			if chunkDataBytes != 0 && header.dataBytes != chunkDataBytes {
+				return fmt.Errorf("%w: pad collection %s: chunk %d holds %d bytes, expected %d", ErrSessionMismatch, collName, chunkNumber, header.dataBytes, chunkDataBytes)
+			}
+			chunkDataBytes = header.dataBytes
+
+			body := make([]byte, header.dataBytes*p.PermutationCount)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return fmt.Errorf("%w: pad collection %s: failed to read chunk %d: %w", ErrChunkCorrupt, collName, chunkNumber, err)
+			}
+			bodies[collLetter] = body
+		}
+		if done > 0 && done < len(pads) {
+			return fmt.Errorf("%w: pad collections end at different chunks: %d of %d ended before chunk %d", ErrChunkCorrupt, done, len(pads), chunkNumber)
+		}
+
+		// Read the chunk of input the pads have room for, stopping at its end
+		if len(buffer) < chunkDataBytes {
+			Zeroize(buffer)
+			buffer = make([]byte, chunkDataBytes)
+		}
+		var bytesRead int
+		var err error
+		if done == 0 {
+			bytesRead, err = io.ReadFull(input, buffer[:chunkDataBytes])
+		} else {
+			// The pads are used up, so the input must be too
+			bytesRead, err = io.ReadFull(input, make([]byte, 1))
+			if bytesRead > 0 {
+				return fmt.Errorf("%w: the %d chunks of pads are used up", ErrPadsTooSmall, chunkNumber-1)
+			}
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("input read error: %w", err)
+		}
+		if bytesRead == 0 {
+			for _, body := range bodies {
+				Zeroize(body)
+			}
+			log.Debugf("Applied pads to %d chunks of input", chunkNumber-1)
+			return nil
+		}
+
+		// XOR the input into the first piece of every permutation
+		err = paddecode.ForEachPermutation(p.RequiredCopies, p.TotalCopies, func(perm string) error {
+			letter := perm[:1]
+			piece, err := p.pieceOffset(letter, perm, chunkDataBytes)
+			if err != nil {
+				return err
+			}
+			body := bodies[letter]
+			for j := 0; j < bytesRead; j++ {
+				body[piece+j] ^= buffer[j]
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Write each collection's chunk, with every piece cut to the input it holds
+		for _, collName := range p.Collections {
+			_, _, collLetter, _ := extractFromCollectionLabel(collName)
+			w, err := newChunk(collName, chunkNumber, chunkFormat)
+			if err != nil {
+				return fmt.Errorf("failed to create chunk writer for collection %s: %w", collName, err)
+			}
+			header, err := chunkHeader{collName: collName, number: chunkNumber, dataBytes: bytesRead, session: session}.marshal()
+			if err != nil {
+				w.Close()
+				return err
+			}
+			if _, err := w.Write(header); err != nil {
+				w.Close()
+				return fmt.Errorf("failed to write chunk header for collection %s: %w", collName, err)
+			}
+			body := bodies[collLetter]
+			for i := 0; i < p.PermutationCount; i++ {
+				if _, err := w.Write(body[i*chunkDataBytes : i*chunkDataBytes+bytesRead]); err != nil {
+					w.Close()
+					return fmt.Errorf("failed to write chunk data for collection %s: %w", collName, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				return fmt.Errorf("failed to write chunk %d of collection %s: %w", chunkNumber, collName, err)
+			}
+		}
+		for _, body := range bodies {
+			Zeroize(body)
+		}
+		metrics.chunksEncoded.Add(1)
+		metrics.bytesEncoded.Add(int64(bytesRead))
+		log.Debugf("Chunk %d: applied pads to %d bytes", chunkNumber, bytesRead)
+	}
+}
@@ -0,0 +1,145 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// TestAirGap tests that pads generated in one pass and applied in another produce a decodable set
+func TestAirGap(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// generate writes 2-of-3 pads of the given chunks of 100 bytes, by collection name
+	generate := func(chunks int) map[string]*bytes.Buffer {
+		pads := make(map[string]*bytes.Buffer)
+		newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+			if pads[collectionName] == nil {
+				pads[collectionName] = &bytes.Buffer{}
+			}
+			return nopWriteCloser{pads[collectionName]}, nil
+		}
+		p, err := NewPadForEncode(ctx, 3, 2)
+		if err != nil {
+			t.Fatalf("NewPadForEncode failed: %v", err)
+		}
+		if err := p.GeneratePads(ctx, chunks, 100, NewDefaultRand(ctx), newChunk, "bin"); err != nil {
+			t.Fatalf("GeneratePads failed: %v", err)
+		}
+		return pads
+	}
+
+	// apply runs ApplyPads over the named pads, returning the cipher collections by name
+	apply := func(pads map[string]*bytes.Buffer, input []byte, names ...string) (map[string]*bytes.Buffer, error) {
+		readers := make([]io.Reader, len(names))
+		for i, name := range names {
+			readers[i] = bytes.NewReader(pads[name].Bytes())
+		}
+		ciphers := make(map[string]*bytes.Buffer)
+		newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+			if ciphers[collectionName] == nil {
+				ciphers[collectionName] = &bytes.Buffer{}
+			}
+			return nopWriteCloser{ciphers[collectionName]}, nil
+		}
+		p, err := NewPadForDecode(ctx, len(readers))
+		if err != nil {
+			return nil, err
+		}
+		return ciphers, p.ApplyPads(ctx, readers, bytes.NewReader(input), newChunk, "bin")
+	}
+
+	// decode decodes the named collections
+	decode := func(colls map[string]*bytes.Buffer, names ...string) ([]byte, error) {
+		readers := make([]io.Reader, len(names))
+		for i, name := range names {
+			readers[i] = bytes.NewReader(colls[name].Bytes())
+		}
+		p, err := NewPadForDecode(ctx, len(readers))
+		if err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		err = p.Decode(ctx, readers, &out)
+		return out.Bytes(), err
+	}
+
+	input := make([]byte, 250)
+	if _, err := rand.Read(input); err != nil {
+		t.Fatalf("Failed to generate input: %v", err)
+	}
+
+	t.Run("Decodes with any K collections", func(t *testing.T) {
+		pads := generate(4)
+		ciphers, err := apply(pads, input, "2C3", "2A3", "2B3")
+		if err != nil {
+			t.Fatalf("ApplyPads failed: %v", err)
+		}
+		for _, names := range [][]string{{"2A3", "2B3"}, {"2A3", "2C3"}, {"2B3", "2C3"}} {
+			output, err := decode(ciphers, names...)
+			if err != nil {
+				t.Fatalf("Decode of %v failed: %v", names, err)
+			}
+			if !bytes.Equal(output, input) {
+				t.Errorf("Decode of %v produced %d bytes that differ from the input", names, len(output))
+			}
+		}
+
+		// The pads alone decode to zeros, and the ciphers differ from them
+		output, err := decode(pads, "2A3", "2B3")
+		if err != nil {
+			t.Fatalf("Decode of pads failed: %v", err)
+		}
+		if !bytes.Equal(output, make([]byte, 400)) {
+			t.Errorf("Pads decoded to data other than zeros")
+		}
+		if bytes.Equal(pads["2A3"].Bytes()[:200], ciphers["2A3"].Bytes()[:200]) {
+			t.Errorf("Cipher collection is identical to its pads")
+		}
+	})
+
+	t.Run("Input exactly filling the pads", func(t *testing.T) {
+		pads := generate(3)
+		ciphers, err := apply(pads, input[:200], "2A3", "2B3", "2C3")
+		if err != nil {
+			t.Fatalf("ApplyPads failed: %v", err)
+		}
+		output, err := decode(ciphers, "2A3", "2B3")
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if !bytes.Equal(output, input[:200]) {
+			t.Errorf("Decode produced %d bytes that differ from the input", len(output))
+		}
+	})
+
+	t.Run("Input larger than the pads", func(t *testing.T) {
+		pads := generate(2)
+		if _, err := apply(pads, input, "2A3", "2B3", "2C3"); !errors.Is(err, ErrPadsTooSmall) {
+			t.Errorf("Expected ErrPadsTooSmall, got %v", err)
+		}
+	})
+
+	t.Run("Missing pad collection", func(t *testing.T) {
+		pads := generate(3)
+		if _, err := apply(pads, input, "2A3", "2B3"); !errors.Is(err, ErrInsufficientCollections) {
+			t.Errorf("Expected ErrInsufficientCollections, got %v", err)
+		}
+	})
+
+	t.Run("Pads of different sets", func(t *testing.T) {
+		pads := generate(3)
+		other := generate(3)
+		pads["2C3"] = other["2C3"]
+		if _, err := apply(pads, input, "2A3", "2B3", "2C3"); !errors.Is(err, ErrSessionMismatch) {
+			t.Errorf("Expected ErrSessionMismatch, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+// This file contains the drand remote source, which mixes in the rounds of a
+// drand beacon once their BLS signatures have been verified against the
+// public key of the beacon's chain. A drand round is public and permanent,
+// so anyone can later fetch the round that was mixed into an encode and
+// confirm that it was what the beacon published.
+
+package pad
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/circl/sign/bls"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// DrandAPI is the base URL of the public drand HTTP API
+const DrandAPI = "https://api.drand.sh"
+
+// Signature schemes of drand chains
+const (
+	DrandChained     = "pedersen-bls-chained"     // Public key on G1, each signature covering the previous one
+	DrandUnchainedG1 = "bls-unchained-g1-rfc9380" // Public key on G2, short signatures on G1 covering the round alone
+)
+
+// drandMaxAge is how far a round may lag behind the chain's current round,
+// so that a stale or replayed round is not mixed in as if it were fresh
+const drandMaxAge = 5 * time.Minute
+
+// DrandChain identifies a drand chain and the public key its rounds are
+// verified against. The key is the collective key of the League of
+// Entropy's nodes; no single node can produce a valid round.
+type DrandChain struct {
+	Name        string // Short name, e.g. "quicknet"
+	Hash        string // Chain hash, in hex, which the API serves the chain under
+	Scheme      string // DrandChained or DrandUnchainedG1
+	PublicKey   string // Public key of the chain, in hex
+	GenesisTime int64  // Unix time of round 1
+	Period      int64  // Seconds between rounds
+}
+
+// DrandChains are the chains of the League of Entropy known by name
+var DrandChains = map[string]DrandChain{
+	"quicknet": {
+		Name:        "quicknet",
+		Hash:        "52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971",
+		Scheme:      DrandUnchainedG1,
+		PublicKey:   "83cf0f2896adee7eb8b5f01fcad3912212c437e0073e911fb90022d3e760183c8c4b450b6a0a6c3ac6a5776a2d1064510d1fec758c921cc22b0e17e63aaf4bcb5ed66304de9cf809bd274ca73bab4af5a6e9c76a4bc09e76eae8991ef5ece45a",
+		GenesisTime: 1692803367,
+		Period:      3,
+	},
+	"mainnet": {
+		Name:        "mainnet",
+		Hash:        "8990e7a9aaed2ffed73dbd7092123d6f289930540d7651336225dc172e51b2ce",
+		Scheme:      DrandChained,
+		PublicKey:   "868f005eb8e6e4ca0a47c8a77ceaa5309a47978a7c71bc5cce96366b5d7a569937c529eeda66c7293784a9402801af31",
+		GenesisTime: 1595431050,
+		Period:      30,
+	},
+}
+
+// DefaultDrandChain is the chain used by the drand source when none is named
+const DefaultDrandChain = "quicknet"
+
+// ParseDrandEntropy returns a drand source for a chain of DrandChains by
+// name, or for the default chain if name is empty
+func ParseDrandEntropy(name string) (*DrandEntropy, error) {
+	if name == "" {
+		name = DefaultDrandChain
+	}
+	chain, ok := DrandChains[strings.ToLower(name)]
+	if !ok {
+		known := make([]string, 0, len(DrandChains))
+		for n := range DrandChains {
+			known = append(known, n)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("%w: drand chain must be one of %s, got %q", ErrInvalidParameters, strings.Join(known, ", "), name)
+	}
+	return &DrandEntropy{URL: DrandAPI + "/" + chain.Hash + "/public/latest", Chain: chain}, nil
+}
+
+// DrandEntropy fetches the latest round of a drand beacon. The round's BLS
+// signature is verified against the public key of its chain, its randomness
+// is checked to be the SHA-256 of that signature, and it must be recent.
+type DrandEntropy struct {
+	URL   string
+	Chain DrandChain
+	Now   func() time.Time // Clock the round's age is checked against, for tests (default: time.Now)
+}
+
+// Name implements RemoteEntropy
+func (e *DrandEntropy) Name() string { return "drand" }
+
+// Remote implements RemoteEntropy
+func (e *DrandEntropy) Remote() string { return e.URL }
+
+// Fetch implements RemoteEntropy
+func (e *DrandEntropy) Fetch(ctx context.Context, client *http.Client) ([]byte, error) {
+	log := trace.FromContext(ctx).WithPrefix("DRAND")
+
+	var resp struct {
+		Round             uint64 `json:"round"`
+		Randomness        string `json:"randomness"`
+		Signature         string `json:"signature"`
+		PreviousSignature string `json:"previous_signature"`
+	}
+	if err := fetchJSON(ctx, client, e.URL, &resp); err != nil {
+		return nil, err
+	}
+	randomness, err1 := hex.DecodeString(resp.Randomness)
+	signature, err2 := hex.DecodeString(resp.Signature)
+	previous, err3 := hex.DecodeString(resp.PreviousSignature)
+	if err1 != nil || err2 != nil || err3 != nil || len(signature) == 0 || resp.Round == 0 {
+		return nil, fmt.Errorf("round %d is malformed", resp.Round)
+	}
+	if err := e.Chain.Verify(resp.Round, signature, previous); err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(signature); !bytes.Equal(sum[:], randomness) {
+		return nil, fmt.Errorf("the randomness of round %d does not match its signature", resp.Round)
+	}
+	if e.Chain.Period > 0 {
+		now := time.Now
+		if e.Now != nil {
+			now = e.Now
+		}
+		published := time.Unix(e.Chain.GenesisTime+int64(resp.Round-1)*e.Chain.Period, 0)
+		if age := now().Sub(published); age > drandMaxAge || age < -time.Duration(e.Chain.Period)*time.Second {
+			return nil, fmt.Errorf("round %d of %s was published at %s, which is not current", resp.Round, e.Chain.Name, published.UTC().Format(time.RFC3339))
+		}
+	}
+	log.Infof("Verified round %d of drand chain %s (%s), which anyone can fetch to audit this encode", resp.Round, e.Chain.Name, e.Chain.Hash)
+	return randomness, nil
+}
+
+// Verify checks the BLS signature of a round of the chain. The previous
+// round's signature is needed only by chained schemes.
+func (c DrandChain) Verify(round uint64, signature, previous []byte) error {
+	key, err := hex.DecodeString(c.PublicKey)
+	if err != nil {
+		return fmt.Errorf("the public key of drand chain %s is not hex", c.Name)
+	}
+	msg := binary.BigEndian.AppendUint64(nil, round)
+	valid := false
+	switch c.Scheme {
+	case DrandUnchainedG1:
+		var pub bls.PublicKey[bls.G2]
+		if err := pub.UnmarshalBinary(key); err != nil {
+			return fmt.Errorf("the public key of drand chain %s is invalid: %w", c.Name, err)
+		}
+		digest := sha256.Sum256(msg)
+		valid = bls.Verify(&pub, digest[:], signature)
+	case DrandChained:
+		var pub bls.PublicKey[bls.G1]
+		if err := pub.UnmarshalBinary(key); err != nil {
+			return fmt.Errorf("the public key of drand chain %s is invalid: %w", c.Name, err)
+		}
+		digest := sha256.Sum256(append(previous, msg...))
+		valid = bls.Verify(&pub, digest[:], signature)
+	default:
+		return fmt.Errorf("drand chain %s uses the unsupported scheme %q", c.Name, c.Scheme)
+	}
+	if !valid {
+		return fmt.Errorf("the signature of round %d does not verify against the public key of drand chain %s", round, c.Name)
+	}
+	return nil
+}
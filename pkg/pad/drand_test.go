@@ -0,0 +1,116 @@
+package pad
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/circl/sign/bls"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// testDrandChain returns a chain with a freshly generated key, whose given
+// round is current, and that round's signature
+func testDrandChain(t *testing.T, round uint64) (DrandChain, []byte) {
+	t.Helper()
+	key, err := bls.KeyGen[bls.G2]([]byte("a test drand chain's secret key material"), nil, nil)
+	if err != nil {
+		t.Fatalf("KeyGen failed: %v", err)
+	}
+	pub, _ := key.PublicKey().MarshalBinary()
+	chain := DrandChain{
+		Name:        "test",
+		Scheme:      DrandUnchainedG1,
+		PublicKey:   hex.EncodeToString(pub),
+		GenesisTime: time.Now().Unix() - int64(round-1)*3,
+		Period:      3,
+	}
+	digest := sha256.Sum256(binary.BigEndian.AppendUint64(nil, round))
+	return chain, bls.Sign(key, digest[:])
+}
+
+func TestDrand(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+
+	t.Run("Known chains", func(t *testing.T) {
+		for name, chain := range DrandChains {
+			if err := chain.Verify(1, make([]byte, 96), make([]byte, 96)); err == nil {
+				t.Errorf("Expected a zero signature not to verify on %s", name)
+			} else if err.Error() != fmt.Sprintf("the signature of round 1 does not verify against the public key of drand chain %s", name) {
+				t.Errorf("Expected the public key of %s to be valid, got %v", name, err)
+			}
+		}
+		for _, spec := range []string{"drand", "drand:mainnet", "DRAND:quicknet"} {
+			if _, err := ParseRemoteEntropy(spec); err != nil {
+				t.Errorf("ParseRemoteEntropy(%q) failed: %v", spec, err)
+			}
+		}
+		if _, err := ParseRemoteEntropy("drand:nochain"); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("Expected an unknown chain to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("Chained scheme", func(t *testing.T) {
+		key, err := bls.KeyGen[bls.G1]([]byte("a test drand chain's secret key material"), nil, nil)
+		if err != nil {
+			t.Fatalf("KeyGen failed: %v", err)
+		}
+		pub, _ := key.PublicKey().MarshalBinary()
+		chain := DrandChain{Name: "chained", Scheme: DrandChained, PublicKey: hex.EncodeToString(pub)}
+		previous := []byte("the signature of round 41")
+		digest := sha256.Sum256(binary.BigEndian.AppendUint64(append([]byte{}, previous...), 42))
+		signature := bls.Sign(key, digest[:])
+		if err := chain.Verify(42, signature, previous); err != nil {
+			t.Errorf("Expected the round to verify: %v", err)
+		}
+		if err := chain.Verify(42, signature, []byte("another chain")); err == nil {
+			t.Errorf("Expected a round with another previous signature not to verify")
+		}
+	})
+
+	chain, signature := testDrandChain(t, 1000)
+	round := func(n uint64, sig []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"round":%d,"randomness":"%x","signature":"%x"}`, n, sha256.Sum256(sig), sig)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest", round(1000, signature))
+	mux.HandleFunc("/replayed", round(999, signature))
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	other, _ := testDrandChain(t, 1000)
+	other.PublicKey = DrandChains["quicknet"].PublicKey
+	tests := []struct {
+		name  string
+		path  string
+		chain DrandChain
+		now   time.Time
+		ok    bool
+	}{
+		{"Current round", "/latest", chain, time.Now(), true},
+		{"Signature of another round", "/replayed", chain, time.Now(), false},
+		{"Another chain's key", "/latest", other, time.Now(), false},
+		{"Stale round", "/latest", chain, time.Now().Add(time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &DrandEntropy{URL: server.URL + tt.path, Chain: tt.chain, Now: func() time.Time { return tt.now }}
+			data, err := e.Fetch(ctx, server.Client())
+			if tt.ok && (err != nil || len(data) != sha256.Size) {
+				t.Errorf("Expected the round to be accepted, got %x, %v", data, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("Expected the round to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package pad
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// EncodeDual encodes two inputs into one set of collections, for plausible
+// deniability: any K collections that include one of the decoy collections,
+// named by their letters (e.g. "C" or "DE"), decode to the decoy, while any K
+// collections without them decode to the input.
+//
+// Every permutation of K collections is an independent encoding of a chunk, so
+// permutations holding a decoy collection simply encode the decoy's chunk in
+// place of the input's. Both inputs are encoded as with PadToChunk, in chunks
+// of one size, and the set lasts as long as the longer of the two, so nothing
+// in the collections shows that there are two inputs or which one is which.
+//
+// A decode combines the collections with the lowest letters among those given,
+// so the decoy collections should be the last ones, handed over only when the
+// decoy is to be revealed. Chunks damaged in some collections can be recovered
+// only from other collections on the same side, which a tolerant decode cannot
+// tell; a decode mixing the two usually fails to decompress or unarchive.
+//
+// Parameters:
+//   - ctx: Context for logging, cancellation, and tracing
+//   - outputChunkBytes: Maximum size of each chunk written to a collection
+//   - input: The data revealed by collections other than the decoy collections
+//   - decoy: The data revealed by any K collections including a decoy collection
+//   - decoyLetters: Letters of the decoy collections; at least K collections must remain
+//   - randomSource: Source of cryptographically secure random bytes
+//   - newChunk: Function to create output files for each chunk
+//   - chunkFormat: Format for output files (e.g., "bin" or "png")
+func (p *Pad) EncodeDual(ctx context.Context, outputChunkBytes int, input io.Reader, decoy io.Reader, decoyLetters string, randomSource RNG, newChunk NewChunkFunc, chunkFormat string) error {
+	log := trace.FromContext(ctx).WithPrefix("ENCODE")
+	ctx, span := trace.StartSpan(ctx, "encode")
+	defer span.End()
+
+	if err := CheckDecoy(p.TotalCopies, p.RequiredCopies, decoyLetters); err != nil {
+		log.Error(err)
+		return countFailure(err)
+	}
+	st := &encodeState{decoyLetters: strings.ToUpper(decoyLetters)}
+
+	inputChunkBytes := outputChunkBytes / p.PermutationCount
+	log.Debugf("Starting dual encode with inputChunkBytes=%d outputChunkBytes=%d, decoy collections %s in %d of %d permutations",
+		inputChunkBytes, outputChunkBytes, st.decoyLetters, p.decoyPermutationCount(st.decoyLetters), paddecode.PermutationCount(p.RequiredCopies, p.TotalCopies))
+	return countFailure(p.encodePadded(ctx, st, inputChunkBytes, input, decoy, randomSource, newChunk, chunkFormat))
+}
+
+// CheckDecoy checks that the letters of decoy collections name at least one
+// collection of a K-of-N set, and leave at least K others to reveal the input
+func CheckDecoy(totalCopies, requiredCopies int, decoyLetters string) error {
+	decoy := make(map[rune]bool)
+	for _, r := range strings.ToUpper(decoyLetters) {
+		if r < 'A' || int(r-'A') >= totalCopies {
+			return fmt.Errorf("%w: decoy collection %c is not one of the %d collections", ErrInvalidParameters, r, totalCopies)
+		}
+		decoy[r] = true
+	}
+	if len(decoy) == 0 {
+		return fmt.Errorf("%w: no decoy collections given", ErrInvalidParameters)
+	}
+	if totalCopies-len(decoy) < requiredCopies {
+		return fmt.Errorf("%w: with %d decoy collections, fewer than the %d required remain to reveal the input",
+			ErrInvalidParameters, len(decoy), requiredCopies)
+	}
+	return nil
+}
+
+// decoyPermutationCount returns the number of permutations that include any
+// of the decoy collections: all but those of the other collections alone
+func (p *Pad) decoyPermutationCount(decoyLetters string) int {
+	decoy := make(map[rune]bool)
+	for _, r := range decoyLetters {
+		decoy[r] = true
+	}
+	return paddecode.PermutationCount(p.RequiredCopies, p.TotalCopies) - paddecode.PermutationCount(p.RequiredCopies, p.TotalCopies-len(decoy))
+}
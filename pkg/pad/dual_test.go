@@ -0,0 +1,137 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// TestEncodeDual tests that each side of a dual encode decodes to its own input
+func TestEncodeDual(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tests := []struct {
+		name       string
+		n, k       int
+		decoy      string
+		realBytes  int
+		decoyBytes int
+		reveal     map[string]bool // Collections decoded, and whether they should give the decoy
+	}{
+		{"2-of-3 longer input", 3, 2, "C", 5000, 100, map[string]bool{"2A3,2B3": false, "2A3,2C3": true, "2B3,2C3": true}},
+		{"2-of-3 longer decoy", 3, 2, "c", 100, 5000, map[string]bool{"2A3,2B3": false, "2B3,2C3": true}},
+		{"3-of-5 two decoys", 5, 3, "DE", 3000, 3000, map[string]bool{"3A5,3B5,3C5": false, "3A5,3B5,3E5": true, "3C5,3D5,3E5": true}},
+		{"Empty decoy", 3, 2, "C", 2000, 0, map[string]bool{"2A3,2B3": false, "2A3,2C3": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := make([]byte, tt.realBytes)
+			decoy := make([]byte, tt.decoyBytes)
+			if _, err := rand.Read(input); err != nil {
+				t.Fatalf("Failed to generate input: %v", err)
+			}
+			if _, err := rand.Read(decoy); err != nil {
+				t.Fatalf("Failed to generate decoy: %v", err)
+			}
+
+			streams := make(map[string]*bytes.Buffer)
+			sizes := make(map[int]map[int]bool)
+			newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+				if streams[collectionName] == nil {
+					streams[collectionName] = &bytes.Buffer{}
+				}
+				if sizes[chunkNumber] == nil {
+					sizes[chunkNumber] = make(map[int]bool)
+				}
+				return &sizeRecorder{w: streams[collectionName], sizes: sizes[chunkNumber]}, nil
+			}
+			p, err := NewPadForEncode(ctx, tt.n, tt.k)
+			if err != nil {
+				t.Fatalf("NewPadForEncode failed: %v", err)
+			}
+			if err := p.EncodeDual(ctx, 2000, bytes.NewReader(input), bytes.NewReader(decoy), tt.decoy, NewDefaultRand(ctx), newChunk, "bin"); err != nil {
+				t.Fatalf("EncodeDual failed: %v", err)
+			}
+			// Every chunk is the same size
+			for n, chunkSizes := range sizes {
+				if len(chunkSizes) != 1 {
+					t.Errorf("Expected chunk %d to have one size in every collection, got %d sizes", n, len(chunkSizes))
+				}
+				for size := range chunkSizes {
+					if size != headerBytes+2000/p.PermutationCount*p.PermutationCount {
+						t.Errorf("Chunk %d is %d bytes, expected a full chunk", n, size)
+					}
+				}
+			}
+
+			for names, wantDecoy := range tt.reveal {
+				var readers []io.Reader
+				for _, name := range bytes.Split([]byte(names), []byte(",")) {
+					readers = append(readers, bytes.NewReader(streams[string(name)].Bytes()))
+				}
+				var output bytes.Buffer
+				if err := DecodeFromReaders(ctx, readers, &output); err != nil {
+					t.Fatalf("Decode of %s failed: %v", names, err)
+				}
+				want := input
+				if wantDecoy {
+					want = decoy
+				}
+				if !bytes.Equal(output.Bytes(), want) {
+					t.Errorf("Collections %s decoded %d bytes, expected the %d bytes of the %s", names, output.Len(), len(want),
+						map[bool]string{false: "input", true: "decoy"}[wantDecoy])
+				}
+			}
+		})
+	}
+}
+
+// TestEncodeDualInvalid tests that decoy collections leaving no way to the input are rejected
+func TestEncodeDualInvalid(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		return nopWriteCloser{io.Discard}, nil
+	}
+	for _, decoy := range []string{"", "D", "BC", "A,B", "1"} {
+		t.Run(decoy, func(t *testing.T) {
+			p, err := NewPadForEncode(ctx, 3, 2)
+			if err != nil {
+				t.Fatalf("NewPadForEncode failed: %v", err)
+			}
+			err = p.EncodeDual(ctx, 2000, bytes.NewReader([]byte("input")), bytes.NewReader([]byte("decoy")), decoy, NewDefaultRand(ctx), newChunk, "bin")
+			if !errors.Is(err, ErrInvalidParameters) {
+				t.Errorf("Expected ErrInvalidParameters for decoy collections %q, got %v", decoy, err)
+			}
+			if err := CheckDecoy(3, 2, decoy); !errors.Is(err, ErrInvalidParameters) {
+				t.Errorf("Expected CheckDecoy to reject decoy collections %q, got %v", decoy, err)
+			}
+		})
+	}
+}
+
+// sizeRecorder records the size of each chunk written through it
+type sizeRecorder struct {
+	w     io.Writer
+	n     int
+	sizes map[int]bool
+}
+
+func (s *sizeRecorder) Write(p []byte) (int, error) {
+	s.n += len(p)
+	return s.w.Write(p)
+}
+
+func (s *sizeRecorder) Close() error {
+	s.sizes[s.n] = true
+	return nil
+}
@@ -0,0 +1,42 @@
+package pad
+
+import (
+	"errors"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+)
+
+// Errors returned by the pad, wrapped with the details of each failure, so that
+// callers can tell failures apart with errors.Is rather than by their text.
+// Those detected when decoding are the same values as paddecode's.
+var (
+	// ErrInvalidParameters means the N and K of a set are out of range
+	ErrInvalidParameters = errors.New("invalid parameters")
+
+	// ErrBadLabel means a collection name or chunk name is malformed
+	ErrBadLabel = paddecode.ErrBadLabel
+
+	// ErrInsufficientCollections means fewer than K collections of a set were supplied
+	ErrInsufficientCollections = paddecode.ErrInsufficientCollections
+
+	// ErrSessionMismatch means the collections supplied come from different
+	// encodes, or different copies of one collection disagree
+	ErrSessionMismatch = paddecode.ErrSessionMismatch
+
+	// ErrChunkCorrupt means a chunk is missing, truncated or damaged beyond what
+	// the other collections can make up for
+	ErrChunkCorrupt = paddecode.ErrChunkCorrupt
+
+	// ErrPadsTooSmall means the input of an encode does not fit in the pads
+	// generated for it
+	ErrPadsTooSmall = errors.New("input is larger than the pads")
+
+	// ErrUnhealthyRNG means a random number generator failed its health tests
+	ErrUnhealthyRNG = errors.New("random number generator failed its health tests")
+
+	// ErrNotRandom means pads or collection chunks failed the randomness checks
+	ErrNotRandom = errors.New("data failed the randomness check")
+
+	// ErrOffline means an operation would use the network in offline mode
+	ErrOffline = errors.New("network access is disabled in offline mode")
+)
@@ -0,0 +1,98 @@
+package pad
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+)
+
+// Every chunk begins with a header naming the collection and chunk it belongs
+// to and the size of the payload that follows it, laid out as described in
+// paddecode, which reads it. The legacy header, a length-prefixed ASCII name
+// of the form "<collectionName>:<chunkNumber>:<chunkDataBytes>", is still read,
+// and written when legacy collections are repaired or refreshed.
+const (
+	headerMarker  = paddecode.HeaderMarker
+	headerVersion = paddecode.HeaderVersion
+	headerBytes   = paddecode.HeaderBytes
+	legacyVersion = paddecode.LegacyVersion
+)
+
+// chunkHeader is the header of a chunk
+type chunkHeader struct {
+	collName  string // Collection the chunk belongs to (e.g., "3A5")
+	number    int    // 1-based chunk number
+	dataBytes int    // Bytes of input data the chunk encodes, the size of each piece
+	session   uint64 // Session ID of the encode, zero in a legacy header
+	version   int    // legacyVersion for a named header, else headerVersion
+}
+
+// payloadBytes returns the size of the payload following the header: one
+// piece of dataBytes for each permutation the collection participates in
+func (h chunkHeader) payloadBytes() int {
+	requiredCopies, totalCopies, _, err := extractFromCollectionLabel(h.collName)
+	if err != nil {
+		return 0
+	}
+	return PiecesPerCollection(requiredCopies, totalCopies) * h.dataBytes
+}
+
+// marshal returns the header as written at the start of a chunk, in the
+// format of its version
+func (h chunkHeader) marshal() ([]byte, error) {
+	if h.version == legacyVersion {
+		name := buildChunkName(h.collName, h.number, h.dataBytes)
+		return append([]byte{byte(len(name))}, name...), nil
+	}
+	requiredCopies, totalCopies, collLetter, err := extractFromCollectionLabel(h.collName)
+	if err != nil {
+		return nil, err
+	}
+	if h.number <= 0 || int64(h.number) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: chunk number %d out of range", ErrBadLabel, h.number)
+	}
+	b := make([]byte, headerBytes)
+	b[0] = headerMarker
+	b[1] = headerVersion
+	b[2] = collLetter[0] - 'A'
+	b[3] = byte(requiredCopies)
+	b[4] = byte(totalCopies)
+	binary.BigEndian.PutUint32(b[5:9], uint32(h.number))
+	binary.BigEndian.PutUint64(b[9:17], uint64(h.payloadBytes()))
+	binary.BigEndian.PutUint64(b[17:25], h.session)
+	return b, nil
+}
+
+// readChunkHeader reads the header at the start of a chunk, in either format.
+// It returns io.EOF at the end of the collection.
+func readChunkHeader(r io.Reader) (chunkHeader, error) {
+	return fromDecodeHeader(paddecode.ReadChunkHeader(r))
+}
+
+// parseChunkHeader parses a binary header, validating each field
+func parseChunkHeader(b []byte) (chunkHeader, error) {
+	return fromDecodeHeader(paddecode.ParseChunkHeader(b))
+}
+
+// fromDecodeHeader converts a header read by paddecode
+func fromDecodeHeader(h paddecode.ChunkHeader, err error) (chunkHeader, error) {
+	if err != nil {
+		return chunkHeader{}, err
+	}
+	return chunkHeader{collName: h.Collection, number: h.Number, dataBytes: h.DataBytes, session: h.Session, version: h.Version}, nil
+}
+
+// newSession draws the session ID of an encode
+func newSession(ctx context.Context, randomSource RNG) (uint64, error) {
+	b := make([]byte, 8)
+	defer Zeroize(b)
+	if err := randomSource.Read(ctx, b); err != nil {
+		return 0, fmt.Errorf("random generator error: %w", err)
+	}
+	metrics.rngBytes.Add(int64(len(b)))
+	return binary.BigEndian.Uint64(b), nil
+}
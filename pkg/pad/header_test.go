@@ -0,0 +1,228 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestChunkHeader(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	t.Run("Round trip", func(t *testing.T) {
+		for _, h := range []chunkHeader{
+			{collName: "2A3", number: 1, dataBytes: 1000, session: 0x0123456789abcdef},
+			{collName: "26Z26", number: 1<<31 - 1, dataBytes: 1, session: 1},
+			{collName: "13M26", number: 7, dataBytes: 400, session: 1 << 63},
+			{collName: "3C5", number: 12, dataBytes: 99, version: legacyVersion},
+		} {
+			raw, err := h.marshal()
+			if err != nil {
+				t.Fatalf("marshal %+v failed: %v", h, err)
+			}
+			if h.version != legacyVersion && len(raw) != headerBytes {
+				t.Errorf("Expected a %d byte header, got %d", headerBytes, len(raw))
+			}
+			got, err := readChunkHeader(bytes.NewReader(raw))
+			if h.version == 0 {
+				h.version = headerVersion
+			}
+			if err != nil || got != h {
+				t.Errorf("Header %+v read back as %+v, %v", h, got, err)
+			}
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		valid, err := chunkHeader{collName: "2B3", number: 3, dataBytes: 100, session: 42}.marshal()
+		if err != nil {
+			t.Fatalf("marshal failed: %v", err)
+		}
+		tests := []struct {
+			name   string
+			modify func(b []byte)
+			target error
+		}{
+			{"Unknown version", func(b []byte) { b[1] = 3 }, ErrBadLabel},
+			{"Letter beyond N", func(b []byte) { b[2] = 3 }, ErrBadLabel},
+			{"Letter beyond Z", func(b []byte) { b[2] = 26 }, ErrBadLabel},
+			{"K above N", func(b []byte) { b[3] = 4 }, ErrBadLabel},
+			{"N of 1", func(b []byte) { b[3], b[4] = 1, 1; b[2] = 0 }, ErrBadLabel},
+			{"Chunk zero", func(b []byte) { copy(b[5:9], []byte{0, 0, 0, 0}) }, ErrBadLabel},
+			{"No payload", func(b []byte) { copy(b[9:17], make([]byte, 8)) }, ErrBadLabel},
+			{"Partial piece", func(b []byte) { b[16]++ }, ErrBadLabel},
+			{"Huge payload", func(b []byte) { b[9] = 0x80 }, ErrChunkCorrupt},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				b := append([]byte{}, valid...)
+				tt.modify(b)
+				if _, err := readChunkHeader(bytes.NewReader(b)); !errors.Is(err, tt.target) {
+					t.Errorf("Expected %v, got %v", tt.target, err)
+				}
+			})
+		}
+		if _, err := readChunkHeader(bytes.NewReader(valid[:headerBytes-1])); !errors.Is(err, io.ErrUnexpectedEOF) || !errors.Is(err, ErrChunkCorrupt) {
+			t.Errorf("Expected a truncated header to be ErrChunkCorrupt, got %v", err)
+		}
+		if _, err := readChunkHeader(bytes.NewReader(nil)); err != io.EOF {
+			t.Errorf("Expected io.EOF at the end of a collection, got %v", err)
+		}
+	})
+
+	t.Run("Large chunks", func(t *testing.T) {
+		// Payloads beyond 4GB are accepted wherever an int can address them
+		const payload = 6 << 30
+		raw, err := chunkHeader{collName: "2B3", number: 300000, dataBytes: 1, session: 42}.marshal()
+		if err != nil {
+			t.Fatalf("marshal failed: %v", err)
+		}
+		binary.BigEndian.PutUint64(raw[9:17], payload)
+		got, err := readChunkHeader(bytes.NewReader(raw))
+		if strconv.IntSize == 32 {
+			if !errors.Is(err, ErrChunkCorrupt) {
+				t.Errorf("Expected ErrChunkCorrupt for a %d byte payload on a 32-bit platform, got %v", uint64(payload), err)
+			}
+			return
+		}
+		if err != nil || got.number != 300000 || uint64(got.payloadBytes()) != payload {
+			t.Errorf("Header with a %d byte payload read back as %+v, %v", uint64(payload), got, err)
+		}
+		if again, err := got.marshal(); err != nil || !bytes.Equal(again, raw) {
+			t.Errorf("Header with a %d byte payload written as %x, %v", uint64(payload), again, err)
+		}
+	})
+
+	// encode encodes a 2-of-3 set, returning the chunks of each collection
+	input := bytes.Repeat([]byte("header "), 1000)
+	encode := func(seed byte) map[string][][]byte {
+		encoded := make(map[string][]*bytes.Buffer)
+		newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+			buf := &bytes.Buffer{}
+			encoded[collectionName] = append(encoded[collectionName], buf)
+			return nopWriteCloser{buf}, nil
+		}
+		p, err := NewPadForEncode(ctx, 3, 2)
+		if err != nil {
+			t.Fatalf("NewPadForEncode failed: %v", err)
+		}
+		if err := p.Encode(ctx, 2000, bytes.NewReader(input), NewTestRNG(seed), newChunk, "bin"); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		result := make(map[string][][]byte)
+		for name, chunks := range encoded {
+			for _, buf := range chunks {
+				result[name] = append(result[name], buf.Bytes())
+			}
+		}
+		return result
+	}
+
+	// legacy rewrites a chunk with the named header written before the binary one
+	legacy := func(chunk []byte) []byte {
+		r := bytes.NewReader(chunk)
+		h, err := readChunkHeader(r)
+		if err != nil {
+			t.Fatalf("readChunkHeader failed: %v", err)
+		}
+		h.version, h.session = legacyVersion, 0
+		raw, _ := h.marshal()
+		return append(raw, chunk[len(chunk)-r.Len():]...)
+	}
+
+	// decode decodes the collections, each given as its chunks
+	decode := func(collections ...[][]byte) ([]byte, error) {
+		readers := make([]io.Reader, len(collections))
+		for i, chunks := range collections {
+			readers[i] = bytes.NewReader(bytes.Join(chunks, nil))
+		}
+		var output bytes.Buffer
+		err := DecodeFromReaders(ctx, readers, &output)
+		return output.Bytes(), err
+	}
+
+	t.Run("Legacy collections", func(t *testing.T) {
+		set := encode(1)
+		old := make(map[string][][]byte)
+		for name, chunks := range set {
+			for _, chunk := range chunks {
+				old[name] = append(old[name], legacy(chunk))
+			}
+		}
+		info, err := InspectChunk(old["2A3"][0])
+		if err != nil || !info.Legacy || info.Session != 0 || info.Collection != "2A3" || info.PayloadBytes != info.DataBytes*2 {
+			t.Errorf("Unexpected legacy chunk info %+v, %v", info, err)
+		}
+		if output, err := decode(old["2A3"], old["2C3"]); err != nil || !bytes.Equal(output, input) {
+			t.Errorf("Legacy collections do not decode to the input: %v", err)
+		}
+		if _, err := decode(old["2A3"], set["2C3"]); !errors.Is(err, ErrSessionMismatch) {
+			t.Errorf("Expected ErrSessionMismatch mixing legacy and binary headers, got %v", err)
+		}
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		first, second := encode(1), encode(2)
+		a, err := InspectChunk(first["2A3"][0])
+		if err != nil || a.Legacy || a.Session == 0 {
+			t.Fatalf("Unexpected chunk info %+v, %v", a, err)
+		}
+		for _, chunk := range first["2B3"] {
+			if b, _ := InspectChunk(chunk); b.Session != a.Session {
+				t.Errorf("Chunk %d of 2B3 has session %x, expected %x", b.Number, b.Session, a.Session)
+			}
+		}
+		if output, err := decode(first["2A3"], first["2B3"]); err != nil || !bytes.Equal(output, input) {
+			t.Errorf("Collections do not decode to the input: %v", err)
+		}
+
+		// Collections of another encode, though shaped the same, are rejected
+		if _, err := decode(first["2A3"], second["2B3"]); !errors.Is(err, ErrSessionMismatch) {
+			t.Errorf("Expected ErrSessionMismatch decoding collections of two encodes, got %v", err)
+		}
+	})
+}
+
+// FuzzReadChunkHeader checks that the headers read from untrusted collections
+// are parsed without panicking, and that any accepted survive being written
+// and read again
+func FuzzReadChunkHeader(f *testing.F) {
+	for _, h := range []chunkHeader{
+		{collName: "3A5", number: 1, dataBytes: 1024, session: 7},
+		{collName: "26Z26", number: 1<<31 - 1, dataBytes: 1},
+		{collName: "3A5", number: 1, dataBytes: 1024, version: legacyVersion},
+	} {
+		raw, _ := h.marshal()
+		f.Add(raw)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0})
+	f.Add([]byte{0, 2, 0, 2, 2, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := readChunkHeader(bytes.NewReader(data))
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, ErrBadLabel) && !errors.Is(err, ErrChunkCorrupt) {
+				t.Errorf("readChunkHeader(%x) failed with %v, not ErrBadLabel or ErrChunkCorrupt", data, err)
+			}
+			return
+		}
+		if h.number <= 0 || h.dataBytes <= 0 {
+			t.Errorf("readChunkHeader(%x) accepted chunk %d of %d bytes", data, h.number, h.dataBytes)
+		}
+		raw, err := h.marshal()
+		if err != nil {
+			t.Fatalf("readChunkHeader(%x) accepted %+v, which fails to marshal: %v", data, h, err)
+		}
+		if got, err := readChunkHeader(bytes.NewReader(raw)); err != nil || got != h {
+			t.Errorf("%x read as %+v, but %x as %+v (%v)", data, h, raw, got, err)
+		}
+	})
+}
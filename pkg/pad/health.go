@@ -0,0 +1,147 @@
+// This file contains the health tests run on random number generators before
+// they are trusted with the pads of an encode.
+
+package pad
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// HealthSampleBytes is the number of bytes drawn from each source by CheckHealth
+const HealthSampleBytes = 64 * 1024
+
+// Health test parameters, after the repetition count and adaptive proportion
+// tests of NIST SP 800-90B (section 4.4), with cutoffs for bytes assumed to
+// hold at least 4 bits of min-entropy each and a false alarm rate of about
+// 2^-20, and a chi-square test of the byte frequencies at a false alarm rate of
+// about 10^-6 in either direction
+const (
+	healthRepetitionCutoff = 6   // Identical bytes in a row that fail the repetition count test
+	healthProportionWindow = 512 // Bytes in each window of the adaptive proportion test
+	healthProportionCutoff = 63  // Occurrences of a window's first byte within it that fail the test
+	healthChiSquareLow     = 160 // Chi-square (255 degrees of freedom) below which bytes are too evenly spread to be random
+	healthChiSquareHigh    = 380 // Chi-square above which bytes are too unevenly spread to be random
+)
+
+// HealthResult is the outcome of the health tests on the bytes drawn from one
+// random source
+type HealthResult struct {
+	Source        string  `json:"source"`            // Name of the source, e.g. "crypto", or "multi" for the mix of all of them
+	Bytes         int     `json:"bytes"`             // Bytes drawn and tested
+	LongestRun    int     `json:"longestRun"`        // Most identical bytes in a row
+	MaxProportion int     `json:"maxProportion"`     // Most occurrences of a window's first byte within its window
+	ChiSquare     float64 `json:"chiSquare"`         // Chi-square statistic of the byte frequencies
+	Problem       string  `json:"problem,omitempty"` // Why the source failed, or empty if it passed
+	Err           string  `json:"error,omitempty"`   // Why the source could not be read, or empty
+}
+
+// Passed reports whether the source was read and passed every test
+func (r HealthResult) Passed() bool {
+	return r.Problem == "" && r.Err == ""
+}
+
+// String describes the result in one line
+func (r HealthResult) String() string {
+	if r.Err != "" {
+		return fmt.Sprintf("%s: failed to read: %s", r.Source, r.Err)
+	}
+	status := "ok"
+	if r.Problem != "" {
+		status = "FAILED: " + r.Problem
+	}
+	return fmt.Sprintf("%s: %s (%d bytes, longest run %d, max proportion %d/%d, chi-square %.1f)",
+		r.Source, status, r.Bytes, r.LongestRun, r.MaxProportion, healthProportionWindow, r.ChiSquare)
+}
+
+// CheckHealth draws sampleBytes (HealthSampleBytes if zero) from rng, and from
+// each of its sources if it is a MultiRNG, and runs the health tests on them.
+// It returns the result for each source, the mix last, and an error wrapping
+// ErrUnhealthyRNG if any of them failed. A source that fails makes no
+// difference to the security of a MultiRNG whose other sources are sound, but
+// a generator that fails its health tests is broken and should not be used
+// until the cause is known.
+func CheckHealth(ctx context.Context, rng RNG, sampleBytes int) ([]HealthResult, error) {
+	if sampleBytes <= 0 {
+		sampleBytes = HealthSampleBytes
+	}
+	sources := []RNG{rng}
+	if m, ok := rng.(*MultiRNG); ok {
+		sources = append(append([]RNG(nil), m.Sources...), rng)
+	}
+
+	results := make([]HealthResult, len(sources))
+	var failed []string
+	sample := make([]byte, sampleBytes)
+	for i, source := range sources {
+		results[i] = HealthResult{Source: source.Name(), Bytes: sampleBytes}
+		if err := source.Read(ctx, sample); err != nil {
+			results[i].Err = err.Error()
+		} else {
+			results[i] = testHealth(source.Name(), sample)
+		}
+		if !results[i].Passed() {
+			failed = append(failed, source.Name())
+		}
+	}
+	Zeroize(sample)
+
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%w: %s", ErrUnhealthyRNG, strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+// testHealth runs the health tests on a sample of random bytes
+func testHealth(source string, sample []byte) HealthResult {
+	r := HealthResult{Source: source, Bytes: len(sample)}
+
+	// Repetition count: the longest run of identical bytes
+	run := 0
+	for i := range sample {
+		if i > 0 && sample[i] == sample[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		r.LongestRun = max(r.LongestRun, run)
+	}
+
+	// Adaptive proportion: how often the first byte of each window recurs in it
+	for start := 0; start+healthProportionWindow <= len(sample); start += healthProportionWindow {
+		window := sample[start : start+healthProportionWindow]
+		count := 0
+		for _, b := range window {
+			if b == window[0] {
+				count++
+			}
+		}
+		r.MaxProportion = max(r.MaxProportion, count)
+	}
+
+	// Chi-square of the byte frequencies against a uniform distribution
+	var counts [256]int
+	for _, b := range sample {
+		counts[b]++
+	}
+	expected := float64(len(sample)) / 256
+	for _, count := range counts {
+		d := float64(count) - expected
+		r.ChiSquare += d * d / expected
+	}
+	r.ChiSquare = math.Round(r.ChiSquare*10) / 10
+
+	switch {
+	case r.LongestRun >= healthRepetitionCutoff:
+		r.Problem = fmt.Sprintf("%d identical bytes in a row", r.LongestRun)
+	case r.MaxProportion >= healthProportionCutoff:
+		r.Problem = fmt.Sprintf("a byte recurs %d times in %d", r.MaxProportion, healthProportionWindow)
+	case len(sample) >= 256*64 && r.ChiSquare > healthChiSquareHigh:
+		r.Problem = fmt.Sprintf("byte frequencies are uneven (chi-square %.1f)", r.ChiSquare)
+	case len(sample) >= 256*64 && r.ChiSquare < healthChiSquareLow:
+		r.Problem = fmt.Sprintf("byte frequencies are too even to be random (chi-square %.1f)", r.ChiSquare)
+	}
+	return r
+}
@@ -0,0 +1,71 @@
+package pad
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// constantRNG is a broken RNG that returns the same byte over and over
+type constantRNG struct{}
+
+func (constantRNG) Name() string { return "constant" }
+
+func (constantRNG) Read(ctx context.Context, p []byte) error {
+	for i := range p {
+		p[i] = 0x5a
+	}
+	return nil
+}
+
+// failingRNG is an RNG that cannot be read
+type failingRNG struct{}
+
+func (failingRNG) Name() string { return "failing" }
+
+func (failingRNG) Read(ctx context.Context, p []byte) error {
+	return errors.New("device unavailable")
+}
+
+func TestCheckHealth(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+
+	t.Run("Default sources pass", func(t *testing.T) {
+		results, err := CheckHealth(ctx, NewDefaultRand(ctx), 0)
+		if err != nil {
+			t.Fatalf("CheckHealth failed: %v", err)
+		}
+		if len(results) != 6 || results[5].Source != "multi" {
+			t.Fatalf("expected a result for each of the 5 sources and the mix, got %v", results)
+		}
+		for _, r := range results {
+			if !r.Passed() || r.Bytes != HealthSampleBytes {
+				t.Errorf("unexpected result %s", r)
+			}
+		}
+	})
+
+	tests := []struct {
+		name   string
+		rng    RNG
+		failed string
+	}{
+		{"Repeated byte", constantRNG{}, "constant"},
+		{"Counter", NewTestRNG(0), "test"},
+		{"Unreadable", failingRNG{}, "failing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			multi := &MultiRNG{Sources: []RNG{NewCryptoRand(), tt.rng}}
+			results, err := CheckHealth(ctx, multi, 0)
+			if !errors.Is(err, ErrUnhealthyRNG) {
+				t.Fatalf("expected ErrUnhealthyRNG, got %v", err)
+			}
+			if len(results) != 3 || !results[0].Passed() || results[1].Passed() || results[1].Source != tt.failed {
+				t.Errorf("expected only %s to fail, got %v", tt.failed, results)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package pad
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+)
+
+// ChunkInfo describes a chunk as recorded in its header, along with how much
+// payload actually follows the header
+type ChunkInfo struct {
+	Collection   string // Collection named in the header (e.g., "3A5")
+	Number       int    // 1-based chunk number
+	DataBytes    int    // Bytes of input data the chunk encodes
+	PayloadBytes int    // Bytes following the header
+	Session      uint64 // Session ID shared by the chunks of one encode, zero in a legacy header
+	Legacy       bool   // Whether the header is a legacy named header rather than binary
+}
+
+// ExpectedPayloadBytes returns the payload size a complete chunk of a K-of-N
+// collection carries: one piece of DataBytes for each permutation the
+// collection participates in
+func (c ChunkInfo) ExpectedPayloadBytes(requiredCopies, totalCopies int) int {
+	return PiecesPerCollection(requiredCopies, totalCopies) * c.DataBytes
+}
+
+// InspectChunk parses the header of an encoded chunk without decoding it
+func InspectChunk(data []byte) (ChunkInfo, error) {
+	r := bytes.NewReader(data)
+	header, err := readChunkHeader(r)
+	if err == io.EOF {
+		return ChunkInfo{}, fmt.Errorf("%w: chunk is empty", ErrChunkCorrupt)
+	}
+	if err != nil {
+		return ChunkInfo{}, err
+	}
+	return ChunkInfo{
+		Collection:   header.collName,
+		Number:       header.number,
+		DataBytes:    header.dataBytes,
+		PayloadBytes: r.Len(),
+		Session:      header.session,
+		Legacy:       header.version == legacyVersion,
+	}, nil
+}
+
+// ParseCollectionName parses a collection name like "3A5" into its required
+// copies (K), total copies (N) and letter, validating each
+func ParseCollectionName(name string) (requiredCopies int, totalCopies int, collLetter string, err error) {
+	return extractFromCollectionLabel(name)
+}
+
+// PiecesPerCollection returns the number of permutations each collection of a
+// K-of-N scheme participates in, C(N-1, K-1), without enumerating them
+func PiecesPerCollection(requiredCopies, totalCopies int) int {
+	return paddecode.PiecesPerCollection(requiredCopies, totalCopies)
+}
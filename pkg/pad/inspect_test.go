@@ -0,0 +1,68 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// TestInspectChunk tests parsing the header of a chunk written by EncodeToWriters
+func TestInspectChunk(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	buffers := make([]bytes.Buffer, 4)
+	writers := make([]io.Writer, len(buffers))
+	for i := range buffers {
+		writers[i] = &buffers[i]
+	}
+	if err := EncodeToWriters(ctx, bytes.NewReader([]byte("hello, world")), writers, 3); err != nil {
+		t.Fatalf("EncodeToWriters failed: %v", err)
+	}
+
+	data := buffers[1].Bytes()
+	info, err := InspectChunk(data)
+	if err != nil {
+		t.Fatalf("InspectChunk failed: %v", err)
+	}
+	if info.Collection != "3B4" || info.Number != 1 || info.DataBytes != 12 {
+		t.Errorf("Unexpected chunk info: %+v", info)
+	}
+	if info.PayloadBytes != info.ExpectedPayloadBytes(3, 4) {
+		t.Errorf("Payload of %d bytes, expected %d", info.PayloadBytes, info.ExpectedPayloadBytes(3, 4))
+	}
+
+	// A truncated chunk reports the payload that is actually present
+	truncated, err := InspectChunk(data[:len(data)-5])
+	if err != nil || truncated.PayloadBytes != info.PayloadBytes-5 {
+		t.Errorf("Expected %d payload bytes in the truncated chunk, got %+v (%v)", info.PayloadBytes-5, truncated, err)
+	}
+	if _, err := InspectChunk(nil); !errors.Is(err, ErrChunkCorrupt) {
+		t.Errorf("Expected a corrupt chunk error for an empty chunk, got %v", err)
+	}
+	if _, err := InspectChunk([]byte{3, 'x', 'y', 'z'}); !errors.Is(err, ErrBadLabel) {
+		t.Errorf("Expected a bad label error for an invalid header, got %v", err)
+	}
+}
+
+func TestPiecesPerCollection(t *testing.T) {
+	tests := []struct {
+		k, n int
+	}{
+		{2, 2}, {2, 3}, {3, 5}, {4, 8},
+	}
+	for _, tt := range tests {
+		_, perms, _ := UniqueSortedCombinations(tt.k, tt.n)
+		if got := PiecesPerCollection(tt.k, tt.n); got != len(perms["A"]) {
+			t.Errorf("PiecesPerCollection(%d, %d) = %d, want %d", tt.k, tt.n, got, len(perms["A"]))
+		}
+	}
+	if got := PiecesPerCollection(13, 26); got != 5200300 {
+		t.Errorf("PiecesPerCollection(13, 26) = %d, want 5200300", got)
+	}
+}
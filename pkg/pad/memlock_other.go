@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package pad
+
+import "fmt"
+
+// LockMemory is not supported on this platform
+func LockMemory() error {
+	return fmt.Errorf("locking memory is not supported on this platform")
+}
@@ -0,0 +1,41 @@
+//go:build linux || darwin
+
+package pad
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// LockMemory locks all current and future memory of the process into RAM, so
+// that plaintext and pads are never written to swap, and disables core dumps,
+// so that they are never written to a core file should the process crash.
+//
+// Locking requires the locked-memory limit (ulimit -l) to be unlimited, or the
+// process to run as root: once the limit is reached, the Go runtime could not
+// allocate memory and would abort, so a finite limit is refused up front.
+func LockMemory() error {
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &limit); err != nil {
+		return fmt.Errorf("failed to read the locked-memory limit: %w", err)
+	}
+	if limit.Cur != unix.RLIM_INFINITY && limit.Max == unix.RLIM_INFINITY {
+		limit.Cur = unix.RLIM_INFINITY
+		if err := unix.Setrlimit(unix.RLIMIT_MEMLOCK, &limit); err != nil {
+			return fmt.Errorf("failed to raise the locked-memory limit: %w", err)
+		}
+	}
+	if limit.Cur != unix.RLIM_INFINITY && os.Geteuid() != 0 {
+		return fmt.Errorf("locked memory is limited to %d bytes; raise the limit with 'ulimit -l unlimited' or run as root", limit.Cur)
+	}
+
+	if err := unix.Setrlimit(unix.RLIMIT_CORE, &unix.Rlimit{Cur: 0, Max: 0}); err != nil {
+		return fmt.Errorf("failed to disable core dumps: %w", err)
+	}
+	if err := unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE); err != nil {
+		return fmt.Errorf("failed to lock memory: %w", err)
+	}
+	return nil
+}
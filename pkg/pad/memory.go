@@ -0,0 +1,15 @@
+package pad
+
+import "runtime"
+
+// Zeroize overwrites b with zeros, so that plaintext, pads and ciphertext don't
+// linger in memory once a chunk has been processed.
+//
+// Buffers are zeroized after each chunk by Encode, Decode, DecodeTolerant and
+// Refresh. This limits what a later memory dump, swap file or core file could
+// reveal, but cannot reach copies made outside of this package, such as by the
+// archive and compression streams or the writers that receive the output.
+func Zeroize(b []byte) {
+	clear(b)
+	runtime.KeepAlive(b)
+}
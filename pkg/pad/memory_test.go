@@ -0,0 +1,99 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// recordingRNG remembers the buffers it fills, so that tests can check they were zeroized
+type recordingRNG struct {
+	TestRNG
+	filled [][]byte
+}
+
+// Read implements RNG
+func (r *recordingRNG) Read(ctx context.Context, p []byte) error {
+	r.filled = append(r.filled, p)
+	return r.TestRNG.Read(ctx, p)
+}
+
+// retainingWriter keeps the buffers written to it rather than copying them,
+// which io.Writer forbids, so that tests can check they were zeroized
+type retainingWriter struct {
+	written [][]byte
+	copied  bytes.Buffer
+}
+
+// Write implements io.Writer
+func (w *retainingWriter) Write(p []byte) (int, error) {
+	w.written = append(w.written, p)
+	return w.copied.Write(p)
+}
+
+func TestZeroize(t *testing.T) {
+	b := []byte("sensitive")
+	Zeroize(b)
+	if !bytes.Equal(b, make([]byte, len(b))) {
+		t.Errorf("Expected zeros, got %q", b)
+	}
+	Zeroize(nil)
+}
+
+func TestEncodeDecodeZeroize(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	p, err := NewPadForEncode(ctx, 3, 2)
+	if err != nil {
+		t.Fatalf("Failed to create pad: %v", err)
+	}
+	input := bytes.Repeat([]byte("plaintext "), 100)
+	streams := make(map[string]*retainingWriter)
+	newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		if streams[collectionName] == nil {
+			streams[collectionName] = &retainingWriter{}
+		}
+		return nopWriteCloser{streams[collectionName]}, nil
+	}
+	rng := &recordingRNG{}
+	if err := p.Encode(ctx, 300, bytes.NewReader(input), rng, newChunk, "bin"); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	// Every pad, and every plaintext and ciphertext piece, has been cleared
+	if len(rng.filled) == 0 {
+		t.Fatalf("Expected pads to be generated")
+	}
+	for i, pad := range rng.filled {
+		if !bytes.Equal(pad, make([]byte, len(pad))) {
+			t.Errorf("Pad %d was not zeroized", i)
+		}
+	}
+	for name, stream := range streams {
+		for i, piece := range stream.written {
+			if len(piece) != headerBytes && !bytes.Equal(piece, make([]byte, len(piece))) {
+				t.Errorf("Write %d to collection %s was not zeroized", i, name)
+			}
+		}
+	}
+
+	// Decoding still reconstructs the input, and clears each decoded chunk
+	output := &retainingWriter{}
+	readers := []io.Reader{&streams["2C3"].copied, &streams["2A3"].copied}
+	if err := DecodeFromReaders(ctx, readers, output); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !bytes.Equal(output.copied.Bytes(), input) {
+		t.Fatalf("Decoded data does not match the input")
+	}
+	for i, chunk := range output.written {
+		if !bytes.Equal(chunk, make([]byte, len(chunk))) {
+			t.Errorf("Decoded chunk %d was not zeroized", i+1)
+		}
+	}
+}
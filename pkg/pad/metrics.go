@@ -0,0 +1,58 @@
+package pad
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// metrics counts the work of every encode and decode in the process. The
+// counters are updated as each chunk is processed, so that services and
+// long-running commands can report the progress of operations under way.
+var metrics struct {
+	bytesEncoded      atomic.Int64
+	chunksEncoded     atomic.Int64
+	bytesDecoded      atomic.Int64
+	chunksDecoded     atomic.Int64
+	activeCollections atomic.Int64
+	rngBytes          atomic.Int64
+	errors            atomic.Int64
+}
+
+// Metrics is a snapshot of the work done by the encodes and decodes of the
+// process since it started. Every count but ActiveCollections only grows.
+type Metrics struct {
+	BytesEncoded      int64 `json:"bytesEncoded"`      // Bytes of data encoded, including any padding
+	ChunksEncoded     int64 `json:"chunksEncoded"`     // Chunks encoded, each counted once however many collections it is written to
+	BytesDecoded      int64 `json:"bytesDecoded"`      // Bytes of data reconstructed, including any padding
+	ChunksDecoded     int64 `json:"chunksDecoded"`     // Chunks reconstructed
+	ActiveCollections int64 `json:"activeCollections"` // Collections being read by the decodes under way
+	RNGBytes          int64 `json:"rngBytes"`          // Random bytes drawn for pads, session IDs and padding
+	Errors            int64 `json:"errors"`            // Encodes and decodes that failed
+}
+
+// ReadMetrics returns the current counts of the encodes and decodes of the process
+func ReadMetrics() Metrics {
+	return Metrics{
+		BytesEncoded:      metrics.bytesEncoded.Load(),
+		ChunksEncoded:     metrics.chunksEncoded.Load(),
+		BytesDecoded:      metrics.bytesDecoded.Load(),
+		ChunksDecoded:     metrics.chunksDecoded.Load(),
+		ActiveCollections: metrics.activeCollections.Load(),
+		RNGBytes:          metrics.rngBytes.Load(),
+		Errors:            metrics.errors.Load(),
+	}
+}
+
+// String returns the counts as one line for a log
+func (m Metrics) String() string {
+	return fmt.Sprintf("encoded %d bytes in %d chunks, decoded %d bytes in %d chunks, %d active collections, %d random bytes, %d errors",
+		m.BytesEncoded, m.ChunksEncoded, m.BytesDecoded, m.ChunksDecoded, m.ActiveCollections, m.RNGBytes, m.Errors)
+}
+
+// countFailure counts an encode or decode that failed, returning its error
+func countFailure(err error) error {
+	if err != nil {
+		metrics.errors.Add(1)
+	}
+	return err
+}
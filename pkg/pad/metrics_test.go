@@ -0,0 +1,91 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// metricsWriter records the collections being decoded as the output is written
+type metricsWriter struct {
+	bytes.Buffer
+	active int64
+}
+
+func (w *metricsWriter) Write(b []byte) (int, error) {
+	w.active = ReadMetrics().ActiveCollections
+	return w.Buffer.Write(b)
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	input := bytes.Repeat([]byte("metrics "), 1000)
+	before := ReadMetrics()
+
+	// A 2-of-3 encode draws a session ID and one pad for each of the 3
+	// permutations of every chunk
+	encoded := make(map[string]*bytes.Buffer)
+	newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		if encoded[collectionName] == nil {
+			encoded[collectionName] = &bytes.Buffer{}
+		}
+		return nopWriteCloser{encoded[collectionName]}, nil
+	}
+	p, err := NewPadForEncode(ctx, 3, 2)
+	if err != nil {
+		t.Fatalf("NewPadForEncode failed: %v", err)
+	}
+	if err := p.Encode(ctx, 3000, bytes.NewReader(input), NewTestRNG(1), newChunk, "bin"); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	after := ReadMetrics()
+	if got := after.ChunksEncoded - before.ChunksEncoded; got != 6 {
+		t.Errorf("Expected 6 chunks encoded, got %d", got)
+	}
+	if got := after.BytesEncoded - before.BytesEncoded; got != int64(len(input)) {
+		t.Errorf("Expected %d bytes encoded, got %d", len(input), got)
+	}
+	if got := after.RNGBytes - before.RNGBytes; got != 8+3*int64(len(input)) {
+		t.Errorf("Expected %d random bytes, got %d", 8+3*len(input), got)
+	}
+
+	// The collections are active only while being decoded
+	var output metricsWriter
+	if err := DecodeFromReaders(ctx, []io.Reader{bytes.NewReader(encoded["2A3"].Bytes()), bytes.NewReader(encoded["2C3"].Bytes())}, &output); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	decoded := ReadMetrics()
+	if output.active != before.ActiveCollections+2 || decoded.ActiveCollections != before.ActiveCollections {
+		t.Errorf("Expected 2 active collections during the decode and none after, got %d and %d",
+			output.active-before.ActiveCollections, decoded.ActiveCollections-before.ActiveCollections)
+	}
+	if got := decoded.ChunksDecoded - after.ChunksDecoded; got != 6 {
+		t.Errorf("Expected 6 chunks decoded, got %d", got)
+	}
+	if got := decoded.BytesDecoded - after.BytesDecoded; got != int64(len(input)) {
+		t.Errorf("Expected %d bytes decoded, got %d", len(input), got)
+	}
+
+	// Only failures are counted as errors
+	if decoded.Errors != before.Errors {
+		t.Errorf("Expected no errors, got %d", decoded.Errors-before.Errors)
+	}
+	truncated := encoded["2C3"].Bytes()[:encoded["2C3"].Len()-1]
+	if err := DecodeFromReaders(ctx, []io.Reader{bytes.NewReader(encoded["2A3"].Bytes()), bytes.NewReader(truncated)}, io.Discard); err == nil {
+		t.Fatalf("Expected decoding a truncated collection to fail")
+	}
+	if failed := ReadMetrics(); failed.Errors != decoded.Errors+1 {
+		t.Errorf("Expected a failed decode to be counted, got %d errors", failed.Errors-decoded.Errors)
+	}
+
+	if line := ReadMetrics().String(); !strings.Contains(line, "chunks, decoded") {
+		t.Errorf("Unexpected metrics line %q", line)
+	}
+}
@@ -0,0 +1,58 @@
+// This file contains the offline mode, in which no random source may reach
+// out over the network.
+
+package pad
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// offlineKey is the context key of offline mode
+type offlineKey struct{}
+
+// WithOffline returns a context in which random sources that fetch randomness
+// over the network are refused, for air-gapped operation
+func WithOffline(ctx context.Context, offline bool) context.Context {
+	return context.WithValue(ctx, offlineKey{}, offline)
+}
+
+// IsOffline reports whether the context is in offline mode
+func IsOffline(ctx context.Context) bool {
+	offline, _ := ctx.Value(offlineKey{}).(bool)
+	return offline
+}
+
+// RemoteSource is implemented by random sources that fetch randomness over
+// the network. Remote returns where they fetch it from, e.g. a URL.
+type RemoteSource interface {
+	Remote() string
+}
+
+// RemoteSources returns where each of the sources of rng that fetch
+// randomness over the network fetches it from, looking into MultiRNGs
+func RemoteSources(rng RNG) []string {
+	var remotes []string
+	if r, ok := rng.(RemoteSource); ok {
+		remotes = append(remotes, rng.Name()+" ("+r.Remote()+")")
+	}
+	if m, ok := rng.(*MultiRNG); ok {
+		for _, source := range m.Sources {
+			remotes = append(remotes, RemoteSources(source)...)
+		}
+	}
+	return remotes
+}
+
+// CheckOffline fails with ErrOffline if the context is in offline mode and
+// rng would reach out over the network, before it is ever read
+func CheckOffline(ctx context.Context, rng RNG) error {
+	if !IsOffline(ctx) || rng == nil {
+		return nil
+	}
+	if remotes := RemoteSources(rng); len(remotes) > 0 {
+		return fmt.Errorf("%w: random sources would use the network: %s", ErrOffline, strings.Join(remotes, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package pad
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// remoteRNG stands in for a random source that fetches randomness over the
+// network
+type remoteRNG struct{ CryptoRand }
+
+func (*remoteRNG) Name() string   { return "remote" }
+func (*remoteRNG) Remote() string { return "https://beacon.example" }
+
+func TestCheckOffline(t *testing.T) {
+	ctx := context.Background()
+	multi := &MultiRNG{Sources: []RNG{NewCryptoRand(), &remoteRNG{}}}
+
+	if err := CheckOffline(ctx, multi); err != nil {
+		t.Errorf("expected remote sources to be allowed online, got %v", err)
+	}
+	offline := WithOffline(ctx, true)
+	if err := CheckOffline(offline, NewDefaultRand(offline)); err != nil {
+		t.Errorf("expected the default sources to be allowed offline, got %v", err)
+	}
+	err := CheckOffline(offline, multi)
+	if !errors.Is(err, ErrOffline) || !strings.Contains(err.Error(), "remote (https://beacon.example)") {
+		t.Errorf("expected the remote source to be refused offline, got %v", err)
+	}
+}
@@ -19,23 +19,27 @@
 // - Each chunk is split across N collections
 // - Collections are generated so that any K of them can reconstruct the original data
 // - File names on disk use format "<collectionName>_<chunkNumber>.<format>" (e.g., "3A5_0001.bin")
-// - Internally within files, chunk names are stored as "<collectionName>-<chunkNumber>" (e.g., "3A5-1")
+// - Internally within files, each chunk begins with a binary header naming its collection and number (see paddecode)
 //
 // Usage warnings:
 // - The security of this system depends entirely on the quality of the random number generator
 // - One-time pads must NEVER be reused
 // - Data reconstruction requires exactly K or more of the original N collections
+//
+// Concurrency:
+// - A Pad is safe for concurrent use once configured, as each operation keeps its own state
+// - The RNGs of this package are safe for concurrent use, except TestRNG; others must be if shared
+// - The metrics are updated atomically
+// - The readers, writers and NewChunkFunc given to an operation are used only by its own goroutine
 package pad
 
 import (
 	"context"
 	"fmt"
 	"io"
-	"sort"
-	"strconv"
 	"strings"
-	"unicode"
 
+	"github.com/rayozzie/padlock/pkg/paddecode"
 	"github.com/rayozzie/padlock/pkg/trace"
 )
 
@@ -58,13 +62,26 @@ type NewChunkFunc func(collectionName string, chunkNumber int, chunkFormat strin
 // the threshold properties. Each collection contains specific permutations of the data,
 // carefully constructed so that only with K or more collections can the permutations
 // be combined to recover the original data.
+//
+// A Pad is safe for concurrent use once configured: its methods only read it,
+// keeping the state of each encode, decode, refresh or repair to themselves, so
+// one Pad can run any number of them at once. Set PadToChunk and ExtraChunks
+// before first use, and don't change the Pad while it is in use.
 type Pad struct {
-	TotalCopies      int                 // N: Total number of collections to create (2-26)
-	RequiredCopies   int                 // K: Minimum collections needed for reconstruction (2-N)
-	Collections      []string            // Names of each collection (e.g., ["3A5", "3B5", "3C5", ...])
-	PermutationCount int                 // Number of unique combinations for K-of-N
-	Permutations     map[string][]string // Unique combinations for each collection (maps collection letter to array of permutations)
-	Ciphers          map[string][][]byte // Unique K-of-N combinations as byte slices (maps permutation key to array of byte slices)
+	TotalCopies      int      // N: Total number of collections to create (2-26)
+	RequiredCopies   int      // K: Minimum collections needed for reconstruction (2-N)
+	Collections      []string // Names of each collection (e.g., ["3A5", "3B5", "3C5", ...])
+	PermutationCount int      // Number of permutations each collection participates in, C(N-1, K-1), and so of pieces in each of its chunks
+	PadToChunk       bool     // Encode every chunk at full size, so that collection sizes don't reveal the exact input length
+	ExtraChunks      int      // With PadToChunk, follow the data with a random number, up to this many, of empty chunks
+}
+
+// encodeState is the state of one encode, kept apart from the Pad so that a Pad
+// can run several encodes at once
+type encodeState struct {
+	session      uint64 // Session ID in the header of every chunk, drawn as the first chunk is encoded
+	decoyChunk   []byte // With EncodeDual, the decoy data of the chunk being encoded
+	decoyLetters string // With EncodeDual, the letters of the decoy collections, whose permutations carry the decoy
 }
 
 // NewPadForEncode creates a new Pad instance with the specified parameters for a K-of-N threshold scheme.
@@ -99,6 +116,9 @@ func NewPadForEncode(ctx context.Context, totalCopies, requiredCopies int) (*Pad
 // Returns:
 //   - A configured Pad instance that can be used until parameters can be extracted
 //   - An error if the parameters are invalid
+//
+// Each decode learns the K-of-N set from the chunks it reads, without changing
+// the pad, so one pad can decode any number of sets, even at once.
 func NewPadForDecode(ctx context.Context, availableCopies int) (*Pad, error) {
 	p := &Pad{}
 	return p, PadInit(ctx, p, availableCopies, availableCopies)
@@ -126,18 +146,8 @@ func NewPadForDecode(ctx context.Context, availableCopies int) (*Pad, error) {
 func PadInit(ctx context.Context, p *Pad, totalCopies, requiredCopies int) error {
 	log := trace.FromContext(ctx).WithPrefix("PAD-INIT")
 	// Validate parameters to ensure they meet the requirements of the threshold scheme
-	if totalCopies < 2 || totalCopies > 26 {
-		return fmt.Errorf("totalCopies must be between 2 and 26, got %d", totalCopies)
-	}
-	// Validate parameters to ensure they meet the requirements of the threshold scheme
-	if totalCopies < 2 || totalCopies > 26 {
-		return fmt.Errorf("totalCopies must be between 2 and 26, got %d", totalCopies)
-	}
-	if requiredCopies < 2 {
-		return fmt.Errorf("requiredCopies must be at least 2, got %d", requiredCopies)
-	}
-	if requiredCopies > totalCopies {
-		return fmt.Errorf("requiredCopies cannot be greater than totalCopies, got %d > %d", requiredCopies, totalCopies)
+	if err := CheckParameters(totalCopies, requiredCopies); err != nil {
+		return err
 	}
 
 	// Set up the Pad instance with the specified parameters
@@ -152,22 +162,26 @@ func PadInit(ctx context.Context, p *Pad, totalCopies, requiredCopies int) error
 		p.Collections[i] = buildCollectionLabel(requiredCopies, totalCopies, collLetter)
 	}
 
-	// Generate the key combinations for the K-of-N scheme
-	p.PermutationCount, p.Permutations, p.Ciphers = UniqueSortedCombinations(p.RequiredCopies, p.TotalCopies)
-
-	// Log the generated collections and their permutations
-	for i := 0; i < totalCopies; i++ {
-		log.Debugf("Pad Collections: %s %v", collectionLetterFromIndex(i), p.Permutations[collectionLetterFromIndex(i)])
-	}
-	keys := make([]string, 0, len(p.Ciphers))
-	for k := range p.Ciphers {
-		keys = append(keys, k)
-	}
-	log.Debugf("Pad %d Permutations K=%d N=%d  %v", p.PermutationCount, p.RequiredCopies, p.TotalCopies, keys)
+	// Count the permutations of the K-of-N scheme each collection participates
+	// in; the permutations themselves are generated as each chunk needs them,
+	// as there are millions of them in the largest schemes
+	p.PermutationCount = paddecode.PiecesPerCollection(p.RequiredCopies, p.TotalCopies)
+	log.Debugf("Pad Collections: %v, each in %d of %d Permutations K=%d N=%d", p.Collections, p.PermutationCount,
+		paddecode.PermutationCount(p.RequiredCopies, p.TotalCopies), p.RequiredCopies, p.TotalCopies)
 
 	return nil
 }
 
+// forScheme returns a pad for the K-of-N set of the collections being read,
+// configured as p is, leaving p, which other goroutines may be using, as it is
+func (p *Pad) forScheme(ctx context.Context, totalCopies, requiredCopies int) (*Pad, error) {
+	scheme := &Pad{PadToChunk: p.PadToChunk, ExtraChunks: p.ExtraChunks}
+	if err := PadInit(ctx, scheme, totalCopies, requiredCopies); err != nil {
+		return nil, err
+	}
+	return scheme, nil
+}
+
 // Create a collection label from parameters
 func buildCollectionLabel(requiredCopies, totalCopies int, collLetter string) string {
 	return fmt.Sprintf("%d%s%d", requiredCopies, collLetter, totalCopies)
@@ -176,49 +190,7 @@ func buildCollectionLabel(requiredCopies, totalCopies int, collLetter string) st
 // extractFromCollectionLabel parses a label like "3A5" and returns requiredCopies, totalCopies, and collLetter
 // with full validation according to the defined rules.
 func extractFromCollectionLabel(label string) (requiredCopies int, totalCopies int, collLetter string, err error) {
-	if len(label) < 3 {
-		return 0, 0, "", fmt.Errorf("label too short")
-	}
-
-	// Find first non-digit: expected to be the collection letter
-	i := 0
-	for i < len(label) && unicode.IsDigit(rune(label[i])) {
-		i++
-	}
-	if i == 0 || i >= len(label)-1 {
-		return 0, 0, "", fmt.Errorf("invalid format: expected digits, then letter, then digits")
-	}
-
-	requiredStr := label[:i]
-	letterChar := label[i]
-	totalStr := label[i+1:]
-
-	requiredCopies, err = strconv.Atoi(requiredStr)
-	if err != nil {
-		return 0, 0, "", fmt.Errorf("invalid requiredCopies: %v", err)
-	}
-
-	totalCopies, err = strconv.Atoi(totalStr)
-	if err != nil {
-		return 0, 0, "", fmt.Errorf("invalid totalCopies: %v", err)
-	}
-
-	// Validation: total ∈ [2, 26]
-	if totalCopies < 2 || totalCopies > 26 {
-		return 0, 0, "", fmt.Errorf("totalCopies out of range: %d", totalCopies)
-	}
-
-	// Validation: required ∈ [2, total]
-	if requiredCopies < 2 || requiredCopies > totalCopies {
-		return 0, 0, "", fmt.Errorf("requiredCopies out of range: %d", requiredCopies)
-	}
-
-	// Validation: letter is uppercase and within allowed range
-	if letterChar < 'A' || letterChar > byte('A'+totalCopies-1) {
-		return 0, 0, "", fmt.Errorf("collLetter %q out of range for total %d", letterChar, totalCopies)
-	}
-
-	return requiredCopies, totalCopies, string(letterChar), nil
+	return paddecode.ParseCollectionLabel(label)
 }
 
 // Get the collection letter in a permutation by index
@@ -268,24 +240,7 @@ func buildChunkName(collName string, chunkNumber, chunkDataBytes int) string {
 
 // extractFromChunkName parses chunkName into its parts, validating each field.
 func extractFromChunkName(chunkName string) (collName string, chunkNumber int, chunkDataBytes int, err error) {
-	parts := strings.Split(chunkName, ":")
-	if len(parts) != 3 {
-		return "", 0, 0, fmt.Errorf("invalid chunk name format: expected 3 parts separated by ':'")
-	}
-
-	collName = parts[0]
-
-	chunkNumber, err = strconv.Atoi(parts[1])
-	if err != nil || chunkNumber <= 0 {
-		return "", 0, 0, fmt.Errorf("invalid chunkNumber: must be positive integer")
-	}
-
-	chunkDataBytes, err = strconv.Atoi(parts[2])
-	if err != nil || chunkDataBytes <= 0 {
-		return "", 0, 0, fmt.Errorf("invalid chunkDataBytes: must be positive integer")
-	}
-
-	return collName, chunkNumber, chunkDataBytes, nil
+	return paddecode.ParseChunkName(chunkName)
 }
 
 // UniqueSortedCombinations generates the combinatorial structures needed for the K-of-N threshold scheme.
@@ -326,60 +281,26 @@ func extractFromChunkName(chunkName string) (collName string, chunkNumber int, c
 // the data from K or more collections to reconstruct the original information.
 //
 // The algorithm uses recursive backtracking to efficiently generate all combinations,
-// with O(C(N,K)) complexity. For typical values of K and N (K≤N≤26), this is highly efficient.
-// The sorting of combinations ensures deterministic behavior across different platforms.
+// with O(C(N,K)) complexity. The sorting of combinations ensures deterministic behavior
+// across different platforms. As it holds every combination at once, which runs to
+// millions with the largest K and N, the Pad itself never calls it: it generates the
+// combinations one at a time with paddecode.ForEachPermutation, and finds the piece of
+// each in a collection's chunks with paddecode.PieceIndex.
 func UniqueSortedCombinations(K, N int) (int, map[string][]string, map[string][][]byte) {
-	// Create labels for each collection (A, B, C, ...)
-	labels := make([]string, N)
-	for i := 0; i < N; i++ {
-		labels[i] = collectionLetterFromIndex(i)
-	}
+	// The combinations each label (collection) participates in, sorted
+	result := paddecode.Permutations(K, N)
 
-	// Initialize the result maps
-	result := make(map[string][]string, N)
+	// Initialize the byte slices array for each combination
 	uniqueMap := make(map[string][][]byte)
-
-	// Generate all K-combinations of N labels using recursive backtracking
-	var allCombos [][]string
-	var comb func(start int, path []string)
-	comb = func(start int, path []string) {
-		// If we have selected K labels, add this combination to our results
-		if len(path) == K {
-			c := make([]string, K)
-			copy(c, path)
-			allCombos = append(allCombos, c)
-			return
-		}
-		// Otherwise, try adding each remaining label and continue recursively
-		for i := start; i < N; i++ {
-			comb(i+1, append(path, labels[i]))
+	for _, perms := range result {
+		for _, perm := range perms {
+			uniqueMap[perm] = make([][]byte, K)
 		}
 	}
-	// Start the recursive generation
-	comb(0, nil)
-
-	// Process all generated combinations
-	for _, combo := range allCombos {
-		// Create a unique string key for this combination (e.g., "ABC")
-		joined := strings.Join(combo, "")
-
-		// Initialize the byte slices array for this combination
-		uniqueMap[joined] = make([][]byte, K)
-
-		// For each label in this combination, add the combination to its list
-		for _, label := range combo {
-			result[label] = append(result[label], joined)
-		}
-	}
-
-	// Sort the combinations for each label for deterministic behavior
-	for k := range result {
-		sort.Strings(result[k])
-	}
 
 	// Return the number of combinations each label participates in,
 	// the map of each label to its combinations, and the initialized uniqueMap
-	return len(result[labels[0]]), result, uniqueMap
+	return len(result[collectionLetterFromIndex(0)]), result, uniqueMap
 }
 
 // Encode implements the one-time pad encoding process with K-of-N threshold security.
@@ -409,14 +330,28 @@ func UniqueSortedCombinations(K, N int) (int, map[string][]string, map[string][]
 //   - The same pad must NEVER be reused
 //   - Each chunk has a unique name to ensure it's properly tracked during decoding
 func (p *Pad) Encode(ctx context.Context, outputChunkBytes int, input io.Reader, randomSource RNG, newChunk NewChunkFunc, chunkFormat string) error {
+	ctx, span := trace.StartSpan(ctx, "encode")
+	defer span.End()
+	return countFailure(p.encode(ctx, &encodeState{}, outputChunkBytes, input, randomSource, newChunk, chunkFormat))
+}
+
+// encode is Encode, without counting its failure
+func (p *Pad) encode(ctx context.Context, st *encodeState, outputChunkBytes int, input io.Reader, randomSource RNG, newChunk NewChunkFunc, chunkFormat string) error {
 	log := trace.FromContext(ctx).WithPrefix("ENCODE")
 
 	// Compute a size of input to process in each chunk, given the number of ciphers that must fit into the chunk
 	inputChunkBytes := outputChunkBytes / p.PermutationCount
 	log.Debugf("Starting encode with inputChunkBytes=%d outputChunkBytes=%d", inputChunkBytes, outputChunkBytes)
+	if p.PadToChunk {
+		return p.encodePadded(ctx, st, inputChunkBytes, input, nil, randomSource, newChunk, chunkFormat)
+	}
+	if outputChunkBytes < p.MinChunkSize() {
+		return fmt.Errorf("%w: chunks of %d bytes are too small for the %d pieces of each chunk", ErrInvalidParameters, outputChunkBytes, p.PermutationCount)
+	}
 
 	// Process input data chunk by chunk until end of stream
 	buffer := make([]byte, inputChunkBytes)
+	defer Zeroize(buffer)
 	for chunkIndex := 1; ; chunkIndex++ {
 
 		// Read a chunk of data from the input stream
@@ -424,7 +359,7 @@ func (p *Pad) Encode(ctx context.Context, outputChunkBytes int, input io.Reader,
 		if bytesRead > 0 {
 
 			// Create a new chunk
-			if err := p.encodeOneChunk(ctx, buffer[:bytesRead], chunkIndex, randomSource, newChunk, chunkFormat); err != nil {
+			if err := p.encodeOneChunk(ctx, st, buffer[:bytesRead], chunkIndex, randomSource, newChunk, chunkFormat); err != nil {
 				return err
 			}
 		}
@@ -477,6 +412,7 @@ func (p *Pad) Encode(ctx context.Context, outputChunkBytes int, input io.Reader,
 //
 // Parameters:
 //   - ctx: Context for logging, cancellation, and tracing
+//   - st: The state of the encode the chunk belongs to
 //   - chunkData: The input data to encode (may be less than a full chunk at the end of the stream)
 //   - chunkNumber: The sequential number of this chunk (starting at 1)
 //   - randomSource: Source of cryptographically secure random bytes
@@ -491,36 +427,32 @@ func (p *Pad) Encode(ctx context.Context, outputChunkBytes int, input io.Reader,
 //   - XOR distribution creates combinatorially secure threshold guarantees
 //   - System has mathematical, not just computational, security guarantees
 //   - Security level is independent of chunk size - even 1-byte chunks have perfect secrecy
-func (p *Pad) encodeOneChunk(ctx context.Context, chunkData []byte, chunkNumber int, randomSource RNG, newChunk NewChunkFunc, chunkFormat string) error {
+func (p *Pad) encodeOneChunk(ctx context.Context, st *encodeState, chunkData []byte, chunkNumber int, randomSource RNG, newChunk NewChunkFunc, chunkFormat string) error {
 	log := trace.FromContext(ctx).WithPrefix("ENCODE")
+	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("chunk %d", chunkNumber))
+	defer span.End()
 
 	// Handle the actual size of the input data, which may be less than a full chunk
 	chunkDataBytes := len(chunkData)
 	log.Debugf("Chunk %d: processing %d bytes of data", chunkNumber, chunkDataBytes)
 
-	// Generate all ciphers that will be needed for this chunk
-	for key, cipher := range p.Ciphers {
-		cipher := make([][]byte, len(cipher))
-		cipher[0] = make([]byte, chunkDataBytes)
-		copy(cipher[0], chunkData)
-		for i := 1; i < len(cipher); i++ {
-			// Generate the random pad for this permutation
-			cipher[i] = make([]byte, chunkDataBytes)
-			err := randomSource.Read(ctx, cipher[i])
-			if err != nil {
-				log.Error(fmt.Errorf("random generator error: %w", err))
-				return fmt.Errorf("random generator error: %w", err)
-			}
-			// XOR plaintext (chunkData) with pad to get ciphertext
-			log.Debugf("Chunk %d: %s XORing chunk data with pad[%s] to generate ciphertext[%s]", chunkNumber, key, collectionLetterFromPermutationIndex(key, i), collectionLetterFromPermutationIndex(key, 0))
-			for j := 0; j < chunkDataBytes; j++ {
-				cipher[0][j] = cipher[0][j] ^ cipher[i][j]
-			}
+	// Every chunk of the encode carries the session ID drawn for the first
+	if chunkNumber == 1 {
+		session, err := newSession(ctx, randomSource)
+		if err != nil {
+			return err
 		}
-		p.Ciphers[key] = cipher
+		st.session = session
 	}
 
-	// Distribute the chunk across all collections
+	// Open the chunk of every collection, writing its header, so that the pieces
+	// of each permutation can be written as soon as they are generated
+	writers := make(map[string]io.WriteCloser, len(p.Collections))
+	defer func() {
+		for _, w := range writers {
+			w.Close()
+		}
+	}()
 	for _, collName := range p.Collections {
 		_, _, collLetter, err := extractFromCollectionLabel(collName)
 		if err != nil {
@@ -532,36 +464,84 @@ func (p *Pad) encodeOneChunk(ctx context.Context, chunkData []byte, chunkNumber
 		if err != nil {
 			return fmt.Errorf("failed to create chunk writer for collection %s: %w", collName, err)
 		}
+		writers[collLetter] = w
 
-		// Generate the chunk name
-		chunkName := buildChunkName(collName, chunkNumber, chunkDataBytes)
-		log.Debugf("Chunk %d: processing collection %s", chunkNumber, collName)
-
-		// Write the chunk name to the chunk
-		nameHeader := []byte{byte(len(chunkName))}
-		nameHeader = append(nameHeader, []byte(chunkName)...)
-		if _, err := w.Write(nameHeader); err != nil {
+		// Write the header to the chunk
+		header, err := chunkHeader{collName: collName, number: chunkNumber, dataBytes: chunkDataBytes, session: st.session}.marshal()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(header); err != nil {
 			return fmt.Errorf("failed to write chunk header for collection %s: %w", collName, err)
 		}
+	}
 
-		// Write the ciphers for each permutations to the chunk
-		for _, perm := range p.Permutations[collLetter] {
-			collIndex, err := permutationIndex(perm, collLetter)
+	// Generate the pieces of each permutation in order, so that an encode is
+	// reproduced exactly by a seeded RNG, and so that each collection receives
+	// the pieces of the permutations it participates in in the order of its
+	// chunk. Only the K pieces of one permutation are held at a time, and
+	// nothing of them once the chunk has been written.
+	cipher := make([][]byte, p.RequiredCopies)
+	for i := range cipher {
+		cipher[i] = make([]byte, chunkDataBytes)
+	}
+	defer func() {
+		for _, piece := range cipher {
+			Zeroize(piece)
+		}
+	}()
+	err := paddecode.ForEachPermutation(p.RequiredCopies, p.TotalCopies, func(key string) error {
+		if st.decoyLetters != "" && strings.ContainsAny(key, st.decoyLetters) {
+			copy(cipher[0], st.decoyChunk)
+		} else {
+			copy(cipher[0], chunkData)
+		}
+		for i := 1; i < len(cipher); i++ {
+			// Generate the random pad for this permutation
+			err := randomSource.Read(ctx, cipher[i])
 			if err != nil {
-				return fmt.Errorf("failed to find permutation index in %s for collection %s: %w", perm, collLetter, err)
+				log.Error(fmt.Errorf("random generator error: %w", err))
+				return fmt.Errorf("random generator error: %w", err)
 			}
-			// Write the cipher data for this collection
-			cipher := p.Ciphers[perm][collIndex]
-			if _, err := w.Write(cipher); err != nil {
-				return fmt.Errorf("failed to write chunk data for collection %s: %w", collName, err)
+			metrics.rngBytes.Add(int64(chunkDataBytes))
+			if err := CheckRandomness(ctx, fmt.Sprintf("pad of chunk %d", chunkNumber), cipher[i]); err != nil {
+				return err
+			}
+			// XOR plaintext (chunkData) with pad to get ciphertext
+			log.Debugf("Chunk %d: %s XORing chunk data with pad[%s] to generate ciphertext[%s]", chunkNumber, key, collectionLetterFromPermutationIndex(key, i), collectionLetterFromPermutationIndex(key, 0))
+			for j := 0; j < chunkDataBytes; j++ {
+				cipher[0][j] = cipher[0][j] ^ cipher[i][j]
 			}
-			log.Debugf("Chunk %d: wrote %d byte permutation %s for collection %s", chunkNumber, len(cipher), perm, collLetter)
 		}
 
-		// Close the chunk writer
-		w.Close()
+		// Write each piece to the chunk of its collection
+		for i, piece := range cipher {
+			collLetter := collectionLetterFromPermutationIndex(key, i)
+			if _, err := writers[collLetter].Write(piece); err != nil {
+				return fmt.Errorf("failed to write chunk data for collection %s: %w", collLetter, err)
+			}
+			log.Debugf("Chunk %d: wrote %d byte permutation %s for collection %s", chunkNumber, len(piece), key, collLetter)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Complete the chunk of every collection, timing each
+	for _, collName := range p.Collections {
+		_, collSpan := trace.StartSpan(ctx, "collection "+collName)
+		_, _, collLetter, _ := extractFromCollectionLabel(collName)
+		err := writers[collLetter].Close()
+		delete(writers, collLetter)
+		collSpan.End()
+		if err != nil {
+			return fmt.Errorf("failed to complete chunk %d of collection %s: %w", chunkNumber, collName, err)
+		}
 	}
 
+	metrics.chunksEncoded.Add(1)
+	metrics.bytesEncoded.Add(int64(chunkDataBytes))
 	log.Debugf("Chunk %d: completed successfully", chunkNumber)
 	return nil
 }
@@ -585,6 +565,14 @@ func (p *Pad) encodeOneChunk(ctx context.Context, chunkData []byte, chunkNumber
 //     a. Read and validate chunk headers and names
 //     b. Decode the chunk data using the threshold scheme
 //     c. Write the decoded data to the output
+//  4. Stop once every collection ends, between chunks, at the same chunk
+//
+// Collections of one encode hold the same number of chunks, each holding as
+// much data as the first but the last, which may hold less. A collection that
+// ends within a chunk, or chunks before the others, has been truncated; rather
+// than writing output that silently stops short, Decode fails with
+// ErrChunkCorrupt naming the collections and where they end. DecodeTolerant
+// instead recovers those chunks from the other collections.
 //
 // Security considerations:
 //   - Attempting to decode with fewer than K collections will fail completely
@@ -592,196 +580,18 @@ func (p *Pad) encodeOneChunk(ctx context.Context, chunkData []byte, chunkNumber
 //   - Chunk numbers and collection names are verified for consistency
 //   - The decoding process is deterministic and will produce the exact original data
 func (p *Pad) Decode(ctx context.Context, collections []io.Reader, output io.Writer) error {
-	log := trace.FromContext(ctx).WithPrefix("DECODE")
-
-	log.Debugf("Starting decode with %d collections", len(collections))
-
-	// Create a structure to track collection state
-	type collectionState struct {
-		reader           io.Reader
-		nextChunkNumber  int
-		collectionName   string
-		collectionLetter string
-		done             bool
-	}
-
-	states := make([]collectionState, len(collections))
-	for i, reader := range collections {
-		states[i] = collectionState{
-			reader:          reader,
-			nextChunkNumber: 1, // Start at chunk 1
-		}
-	}
-
-	// We need to reinitialize the pad when we get some real data
-	padReinitialized := false
-
-	// Read chunks until we've processed all available chunks in all collections
-	var chunkDataBytes int
-	for chunkIndex := 1; ; chunkIndex++ {
-		// For each collection, read the next chunk
-		chunks := make([][]byte, len(collections))
-
-		for i, state := range states {
-			state.done = false
-
-			// Read the chunk name
-			lengthBuf := make([]byte, 1)
-			_, err := io.ReadFull(state.reader, lengthBuf)
-			if err == io.EOF {
-				// No more chunks in this collection
-				log.Debugf("Collection %d is done (EOF)", i)
-				states[i].done = true
-				continue
-			}
-			if err != nil {
-				return fmt.Errorf("failed to read chunk name length: %w", err)
-			}
-
-			nameLength := int(lengthBuf[0])
-			nameBuf := make([]byte, nameLength)
-			_, err = io.ReadFull(state.reader, nameBuf)
-			if err != nil {
-				return fmt.Errorf("failed to read chunk name length %d: %w", nameLength, err)
-			}
-
-			chunkName := string(nameBuf)
-			log.Debugf("Collection %d: Chunk name: %s", i, chunkName)
-
-			// Parse the collection name and chunk number from the chunk name
-			var collName string
-			var chunkNum int
-			collName, chunkNum, chunkDataBytes, err = extractFromChunkName(chunkName)
-			if err != nil {
-				return fmt.Errorf("invalid chunk name format (missing hyphen): %s", chunkName)
-			}
-			requiredCopies, totalCopies, collLetter, err := extractFromCollectionLabel(collName)
-			if err != nil {
-				return fmt.Errorf("invalid chunk name format (missing hyphen): %s", chunkName)
-			}
-
-			// Initialize the pad if we haven't done so
-			if !padReinitialized {
-				padReinitialized = true
-				err = PadInit(ctx, p, totalCopies, requiredCopies)
-				if err != nil {
-					return fmt.Errorf("invalid chunk name format (missing hyphen): %s", chunkName)
-				}
-				log.Debugf("Pad initialized with totalCopies:%d requiredCopies:%d", p.TotalCopies, p.RequiredCopies)
-			}
-
-			// If this is the first chunk, initialize the collection name
-			if states[i].collectionName == "" {
-				states[i].collectionName = collName
-				states[i].collectionLetter = collLetter
-				log.Debugf("Collection %d: Initialized collection name: %s", i, collName)
-			} else if states[i].collectionName != collName {
-				return fmt.Errorf("collection name mismatch: expected %s, got %s",
-					states[i].collectionName, collName)
-			}
-
-			// Verify the copies
-			if requiredCopies != p.RequiredCopies {
-				return fmt.Errorf("required copies mismatch: expected %d, got %d",
-					p.RequiredCopies, requiredCopies)
-			}
-			if totalCopies != p.TotalCopies {
-				return fmt.Errorf("total copies mismatch: expected %d, got %d",
-					p.TotalCopies, totalCopies)
-			}
-
-			// Verify the chunk number
-			if chunkNum != states[i].nextChunkNumber {
-				log.Debugf("Collection %d: Chunk number mismatch: expected %d, got %d",
-					i, states[i].nextChunkNumber, chunkNum)
-				return fmt.Errorf("chunk number mismatch: expected %d, got %d",
-					states[i].nextChunkNumber, chunkNum)
-			}
-			states[i].nextChunkNumber++
-
-			// Compute the chunk length
-			readLength := chunkDataBytes * p.PermutationCount
-
-			// Read the chunk data
-			log.Debugf("Collection %d: Reading %d bytes of chunk data for %d byte chunk", i, readLength, chunkDataBytes)
-			chunk := make([]byte, readLength)
-			n, err := io.ReadFull(state.reader, chunk)
-			if err != nil {
-				return fmt.Errorf("failed to read chunk data: %w", err)
-			}
-			if n != readLength {
-				return fmt.Errorf("failed to read %d bytes of chunk data got:%d: %w", readLength, n, err)
-			}
-			chunks[i] = chunk
-			log.Debugf("Collection %d: Read %d bytes of chunk data", i, len(chunk))
-		}
-
-		// Check if all collections have been fully processed
-		allDone := true
-		anyDone := false
-		for _, state := range states {
-			if state.done {
-				anyDone = true
-			}
-			if !state.done {
-				allDone = false
-				break
-			}
-		}
-		if allDone {
-			log.Debugf("All collections have been fully processed")
-			return nil
-		}
-		if anyDone {
-			log.Debugf("Some collections have been processed while others are fully processed")
-			return nil
-		}
-
-		// Loop through all the collections to find the first permutation that matches
-		chunkLetters := []string{}
-		for _, state := range states {
-			chunkLetters = append(chunkLetters, state.collectionLetter)
-		}
-		if len(chunkLetters) < p.RequiredCopies {
-			return fmt.Errorf("not enough copies to decode: %d < %d", len(chunkLetters), p.RequiredCopies)
-		}
-		sort.Strings(chunkLetters)
-		chunkLetters = chunkLetters[0:p.RequiredCopies]
-		permutation := strings.Join(chunkLetters, "")
-		log.Debugf("Permutation %s will be used for decode", permutation)
-
-		// Generate the final data
-		decodedChunk := make([]byte, chunkDataBytes)
-		for i := 0; i < len(chunkLetters); i++ {
-			// Find the permutations for this collectionLetter such as B: [ABC ABD ABE BCD BCE BDE]
-			perm, found := p.Permutations[chunkLetters[i]]
-			if !found {
-				return fmt.Errorf("failed to find permutation for collection %s", chunkLetters[i])
-			}
-			// Find the index of the desired permutation in that list
-			permIndex := -1
-			for j, p := range perm {
-				if p == permutation {
-					permIndex = j
-					break
-				}
-			}
-			if permIndex == -1 {
-				return fmt.Errorf("failed to find permutation index for collection %s", chunkLetters[i])
-			}
-			log.Debugf("Collection %s: XORing data from permutation %d for %s", chunkLetters[i], permIndex, permutation)
-			// XOR the data with the appropriate permutation within that chunk
-			permBase := permIndex * chunkDataBytes
-			for j := 0; j < chunkDataBytes; j++ {
-				decodedChunk[j] = decodedChunk[j] ^ chunks[i][permBase+j]
-			}
-		}
-
-		// Write the decoded data to the output
-		_, err := output.Write(decodedChunk)
-		if err != nil {
-			return fmt.Errorf("failed to write decoded data: %w", err)
-		}
+	ctx, span := trace.StartSpan(ctx, "decode")
+	defer span.End()
+	metrics.activeCollections.Add(int64(len(collections)))
+	defer metrics.activeCollections.Add(-int64(len(collections)))
+	return countFailure(p.decode(ctx, collections, output))
+}
 
-	}
+// decode is Decode, without counting its collections or failure
+func (p *Pad) decode(ctx context.Context, collections []io.Reader, output io.Writer) error {
+	d := &paddecode.Decoder{OnChunk: func(dataBytes int) {
+		metrics.chunksDecoded.Add(1)
+		metrics.bytesDecoded.Add(int64(dataBytes))
+	}}
+	return d.Decode(ctx, collections, output)
 }
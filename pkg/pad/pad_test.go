@@ -3,11 +3,16 @@ package pad
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/bits"
+	mathrand "math/rand/v2"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/rayozzie/padlock/pkg/trace"
 )
@@ -35,8 +40,8 @@ func TestNewPad(t *testing.T) {
 			pad, err := NewPadForEncode(context.Background(), tt.totalCopies, tt.requiredCopies)
 
 			if tt.expectError {
-				if err == nil {
-					t.Errorf("Expected error for N=%d, K=%d but got nil", tt.totalCopies, tt.requiredCopies)
+				if !errors.Is(err, ErrInvalidParameters) {
+					t.Errorf("Expected invalid parameters error for N=%d, K=%d but got %v", tt.totalCopies, tt.requiredCopies, err)
 				}
 			} else {
 				if err != nil {
@@ -144,10 +149,9 @@ func TestPadStreamEncodeDecode(t *testing.T) {
 		t.Logf("First %d bytes of input: %v", compareLen, input[:compareLen])
 		t.Logf("First %d bytes of output: %v", compareLen, output[:compareLen])
 
-		// With the threshold scheme we're using, we expect output to be valid data
-		// but not necessarily identical to the input due to the way permutations work.
-		// The successful decode is the important part of this test.
-		t.Logf("Decode of streamed data completed successfully")
+		if !bytes.Equal(output, input) {
+			t.Errorf("Decoded output differs from the input")
+		}
 
 		// Statistical check on the output data randomness
 		var zeroBits, oneBits int
@@ -352,21 +356,12 @@ func TestPadEncodeDecodeRoundTrip(t *testing.T) {
 		t.Fatalf("Failed to decode: %v", err)
 	}
 
-	// Verify the output - Note: in K-of-N threshold schemes, output may differ from input
-	// due to the way data is split and recombined. We just verify we got some output.
+	// Verify the output, which must be exactly the input
 	output := outputBuffer.Bytes()
-
-	// Log the results - the main success indicator is that decode completed without error
-	if len(output) > 0 {
-		t.Logf("Successfully decoded data (%d bytes)", len(output))
-		t.Logf("Input was %d bytes, output is %d bytes", len(input), len(output))
-
-		if len(output) > 0 && len(input) > 0 {
-			t.Logf("First %d bytes of input: %v", min(len(input), 10), input[:min(len(input), 10)])
-			t.Logf("First %d bytes of output: %v", min(len(output), 10), output[:min(len(output), 10)])
-		}
-	} else {
-		t.Logf("Warning: Decode succeeded but produced no output bytes")
+	if !bytes.Equal(output, input) {
+		t.Errorf("Decoded %d bytes that differ from the %d bytes of input", len(output), len(input))
+		t.Logf("First %d bytes of input: %v", min(len(input), 10), input[:min(len(input), 10)])
+		t.Logf("First %d bytes of output: %v", min(len(output), 10), output[:min(len(output), 10)])
 	}
 }
 
@@ -415,4 +410,435 @@ func min(a, b int) int {
 		return a
 	}
 	return b
-}
\ No newline at end of file
+}
+
+// TestRoundTripMatrix checks, for random inputs and every K-of-N scheme with N
+// up to 8, that any K collections decode the input exactly whatever order they
+// are supplied in, and that no K-1 collections decode at all
+func TestRoundTripMatrix(t *testing.T) {
+	// Thousands of decodes are run, so nothing is logged
+	tracer := trace.NewTracer("TEST", trace.LogLevelError)
+	tracer.SetSinks()
+	ctx := trace.WithContext(context.Background(), tracer)
+
+	seed := uint64(time.Now().UnixNano())
+	rng := mathrand.New(mathrand.NewPCG(seed, 0))
+	t.Logf("Random seed %d", seed)
+
+	for n := 2; n <= 8; n++ {
+		for k := 2; k <= n; k++ {
+			t.Run(fmt.Sprintf("%dof%d", k, n), func(t *testing.T) {
+				p, err := NewPadForEncode(ctx, n, k)
+				if err != nil {
+					t.Fatalf("NewPadForEncode failed: %v", err)
+				}
+
+				// Up to four chunks of input, padded or not; only a padded encode
+				// of empty input writes a chunk to check the collections against
+				p.PadToChunk = rng.IntN(2) == 0
+				dataBytes := 1 + rng.IntN(64)
+				minLength := 1
+				if p.PadToChunk {
+					dataBytes += len(paddedMagic) + paddedCountBytes
+					minLength = 0
+				}
+				input := make([]byte, minLength+rng.IntN(4*dataBytes))
+				for i := range input {
+					input[i] = byte(rng.Uint32())
+				}
+
+				encoded := make(map[string]*bytes.Buffer)
+				newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+					if encoded[collectionName] == nil {
+						encoded[collectionName] = &bytes.Buffer{}
+					}
+					return nopWriteCloser{encoded[collectionName]}, nil
+				}
+				if err := p.Encode(ctx, dataBytes*p.PermutationCount, bytes.NewReader(input), NewDefaultRand(ctx), newChunk, "bin"); err != nil {
+					t.Fatalf("Encode of %d bytes failed: %v", len(input), err)
+				}
+
+				for mask := 1; mask < 1<<n; mask++ {
+					count := bits.OnesCount(uint(mask))
+					if count != k && count != k-1 {
+						continue
+					}
+					var names []string
+					for i := 0; i < n; i++ {
+						if mask&(1<<i) != 0 {
+							names = append(names, p.Collections[i])
+						}
+					}
+					rng.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+					readers := make([]io.Reader, len(names))
+					for i, name := range names {
+						readers[i] = bytes.NewReader(encoded[name].Bytes())
+					}
+
+					var output bytes.Buffer
+					err := DecodeFromReaders(ctx, readers, &output)
+					switch {
+					case count == k && err != nil:
+						t.Errorf("Decoding %d bytes (padded %v) from %v failed: %v", len(input), p.PadToChunk, names, err)
+					case count == k && !bytes.Equal(output.Bytes(), input):
+						t.Errorf("Decoding %d bytes (padded %v) from %v produced %d bytes that differ", len(input), p.PadToChunk, names, output.Len())
+					case count < k && err == nil:
+						t.Errorf("Decoding %d bytes (padded %v) from only %v succeeded", len(input), p.PadToChunk, names)
+					case count < k && count > 1 && !errors.Is(err, ErrInsufficientCollections):
+						t.Errorf("Decoding from only %v failed with %v, not ErrInsufficientCollections", names, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestLargeScheme tests that schemes with many permutations are set up without
+// enumerating them, and encode and decode a permutation at a time
+func TestLargeScheme(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelError)
+	ctx = trace.WithContext(ctx, tracer)
+
+	t.Run("Largest scheme", func(t *testing.T) {
+		p, err := NewPadForEncode(ctx, 26, 13)
+		if err != nil {
+			t.Fatalf("NewPadForEncode failed: %v", err)
+		}
+		if p.PermutationCount != 5200300 {
+			t.Errorf("Expected 5200300 permutations per collection, got %d", p.PermutationCount)
+		}
+		if offset, err := p.pieceOffset("M", "ABCDEFGHIJKLM", 10); err != nil || offset != 0 {
+			t.Errorf("Expected the first piece of M at 0, got %d (%v)", offset, err)
+		}
+		if offset, err := p.pieceOffset("Z", "NOPQRSTUVWXYZ", 10); err != nil || offset != (p.PermutationCount-1)*10 {
+			t.Errorf("Expected the last piece of Z at %d, got %d (%v)", (p.PermutationCount-1)*10, offset, err)
+		}
+	})
+
+	t.Run("Encode and decode", func(t *testing.T) {
+		p, err := NewPadForEncode(ctx, 14, 7)
+		if err != nil {
+			t.Fatalf("NewPadForEncode failed: %v", err)
+		}
+		input := bytes.Repeat([]byte("many permutations "), 100)
+		encoded := make(map[string]*bytes.Buffer)
+		newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+			if encoded[collectionName] == nil {
+				encoded[collectionName] = &bytes.Buffer{}
+			}
+			return nopWriteCloser{encoded[collectionName]}, nil
+		}
+		if err := p.Encode(ctx, 1000*p.PermutationCount, bytes.NewReader(input), NewDefaultRand(ctx), newChunk, "bin"); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		readers := make([]io.Reader, 0, 7)
+		for _, name := range p.Collections[7:] {
+			readers = append(readers, bytes.NewReader(encoded[name].Bytes()))
+		}
+		var output bytes.Buffer
+		if err := DecodeFromReaders(ctx, readers, &output); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if !bytes.Equal(output.Bytes(), input) {
+			t.Errorf("Decoded %d bytes that differ from the input", output.Len())
+		}
+	})
+}
+
+// TestDecodeUnevenCollections tests that collections that end at different
+// chunks, or within a chunk, fail the decode rather than truncating its output
+func TestDecodeUnevenCollections(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// encode encodes a 2-of-3 set in memory, one buffer per chunk; each 3000
+	// byte chunk holds 1500 bytes of input
+	encode := func(size int) map[string]map[int][]byte {
+		encoded := make(map[string]map[int]*bytes.Buffer)
+		newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+			if encoded[collectionName] == nil {
+				encoded[collectionName] = make(map[int]*bytes.Buffer)
+			}
+			encoded[collectionName][chunkNumber] = &bytes.Buffer{}
+			return nopWriteCloser{encoded[collectionName][chunkNumber]}, nil
+		}
+		p, err := NewPadForEncode(ctx, 3, 2)
+		if err != nil {
+			t.Fatalf("NewPadForEncode failed: %v", err)
+		}
+		input := make([]byte, size)
+		for i := range input {
+			input[i] = byte(i % 251)
+		}
+		if err := p.Encode(ctx, 3000, bytes.NewReader(input), NewTestRNG(0), newChunk, "bin"); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		result := make(map[string]map[int][]byte)
+		for name, chunks := range encoded {
+			result[name] = make(map[int][]byte)
+			for n, buf := range chunks {
+				result[name][n] = buf.Bytes()
+			}
+		}
+		return result
+	}
+	full := encode(10000)  // Seven chunks, the last holding 1000 bytes
+	short := encode(2000)  // Two chunks, the last holding 500 bytes
+	single := encode(1000) // One chunk holding 1000 bytes
+	if len(full["2A3"]) != 7 {
+		t.Fatalf("Expected 7 chunks, got %d", len(full["2A3"]))
+	}
+
+	// stream joins chunks of the given sets into the stream of one collection
+	type part struct {
+		set    map[string]map[int][]byte
+		number int
+	}
+	stream := func(name string, parts ...part) []byte {
+		var b bytes.Buffer
+		for _, p := range parts {
+			b.Write(p.set[name][p.number])
+		}
+		return b.Bytes()
+	}
+	chunksOf := func(set map[string]map[int][]byte, first, last int) []part {
+		var parts []part
+		for n := first; n <= last; n++ {
+			parts = append(parts, part{set, n})
+		}
+		return parts
+	}
+	fullA := stream("2A3", chunksOf(full, 1, 7)...)
+	fullB := stream("2B3", chunksOf(full, 1, 7)...)
+
+	tests := []struct {
+		name     string
+		a, b     []byte
+		wantSize int
+		wantErr  string
+	}{
+		{"Intact", fullA, fullB, 10000, ""},
+		{"Empty", nil, nil, 0, ""},
+		{"Missing last chunk", fullA, stream("2B3", chunksOf(full, 1, 6)...), 0, "2B3 end after 6 chunks, but 2A3 hold chunk 7"},
+		{"Missing every chunk", nil, fullB, 0, "1 end after 0 chunks, but 2B3 hold chunk 1"},
+		{"Truncated within a chunk", fullA, fullB[:len(fullB)-100], 0, "2B3 is truncated within chunk 7"},
+		{"Truncated within a header", fullA, append(stream("2B3", chunksOf(full, 1, 6)...), full["2B3"][7][:3]...), 0, "within the header of chunk 7"},
+		{"Short chunk not last",
+			stream("2A3", append(chunksOf(short, 1, 2), chunksOf(full, 3, 7)...)...),
+			stream("2B3", append(chunksOf(short, 1, 2), chunksOf(full, 3, 7)...)...),
+			0, "chunk 2 holds only 500 of 1500 bytes"},
+		{"Chunk larger than the first",
+			stream("2A3", append(chunksOf(single, 1, 1), chunksOf(full, 2, 7)...)...),
+			stream("2B3", append(chunksOf(single, 1, 1), chunksOf(full, 2, 7)...)...),
+			0, "chunk 2 holds 1500 bytes, more than the 1000 of chunk 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPadForDecode(ctx, 2)
+			if err != nil {
+				t.Fatalf("NewPadForDecode failed: %v", err)
+			}
+			var output bytes.Buffer
+			err = p.Decode(ctx, []io.Reader{bytes.NewReader(tt.a), bytes.NewReader(tt.b)}, &output)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Decode failed: %v", err)
+				}
+				if output.Len() != tt.wantSize {
+					t.Errorf("Expected %d bytes of output, got %d", tt.wantSize, output.Len())
+				}
+				return
+			}
+			if !errors.Is(err, ErrChunkCorrupt) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected ErrChunkCorrupt mentioning %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// FuzzExtractFromChunkName checks that the chunk names read from untrusted
+// collections are parsed without panicking, and that any accepted survive
+// being built and parsed again
+func FuzzExtractFromChunkName(f *testing.F) {
+	for _, seed := range []string{"3A5:1:1024", "2B3:9999:1", "26Z26:1:1", "", ":", "::", "3A5::", "3A5:-1:10",
+		"3A5:1:0", "3A5:1:1024:1", "3A5:+1:1", "3A5:1:99999999999999999999", "\xff:1:1"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		collName, chunkNumber, chunkDataBytes, err := extractFromChunkName(name)
+		if err != nil {
+			if !errors.Is(err, ErrBadLabel) {
+				t.Errorf("extractFromChunkName(%q) failed with %v, not ErrBadLabel", name, err)
+			}
+			return
+		}
+		if chunkNumber <= 0 || chunkDataBytes <= 0 {
+			t.Errorf("extractFromChunkName(%q) accepted chunk %d of %d bytes", name, chunkNumber, chunkDataBytes)
+		}
+		rebuilt := buildChunkName(collName, chunkNumber, chunkDataBytes)
+		c, n, d, err := extractFromChunkName(rebuilt)
+		if err != nil || c != collName || n != chunkNumber || d != chunkDataBytes {
+			t.Errorf("%q parsed as %q %d %d, but %q as %q %d %d (%v)", name, collName, chunkNumber, chunkDataBytes, rebuilt, c, n, d, err)
+		}
+	})
+}
+
+// FuzzExtractFromCollectionLabel checks that the collection names read from
+// untrusted chunks are parsed without panicking, and that any accepted name a
+// collection of a valid scheme
+func FuzzExtractFromCollectionLabel(f *testing.F) {
+	for _, seed := range []string{"3A5", "2B3", "26Z26", "2C2", "1A2", "3A27", "A35", "35", "3a5", "03A05", "3A", "", "9999999999999999999A2"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, label string) {
+		requiredCopies, totalCopies, collLetter, err := extractFromCollectionLabel(label)
+		if err != nil {
+			if !errors.Is(err, ErrBadLabel) {
+				t.Errorf("extractFromCollectionLabel(%q) failed with %v, not ErrBadLabel", label, err)
+			}
+			return
+		}
+		if totalCopies < 2 || totalCopies > 26 || requiredCopies < 2 || requiredCopies > totalCopies {
+			t.Errorf("extractFromCollectionLabel(%q) accepted %d of %d", label, requiredCopies, totalCopies)
+		}
+		if len(collLetter) != 1 || collLetter[0] < 'A' || int(collLetter[0]-'A') >= totalCopies {
+			t.Errorf("extractFromCollectionLabel(%q) accepted letter %q of %d", label, collLetter, totalCopies)
+		}
+		rebuilt := buildCollectionLabel(requiredCopies, totalCopies, collLetter)
+		k, n, l, err := extractFromCollectionLabel(rebuilt)
+		if err != nil || k != requiredCopies || n != totalCopies || l != collLetter {
+			t.Errorf("%q parsed as %d %d %q, but %q as %d %d %q (%v)", label, requiredCopies, totalCopies, collLetter, rebuilt, k, n, l, err)
+		}
+	})
+}
+
+// FuzzDecode checks that decoding untrusted collections fails cleanly, rather
+// than panicking or allocating what their chunk headers claim, however they
+// are damaged or forged
+func FuzzDecode(f *testing.F) {
+	// The fuzzer runs the decode many times a second, so nothing is logged
+	tracer := trace.NewTracer("TEST", trace.LogLevelError)
+	tracer.SetSinks()
+	ctx := trace.WithContext(context.Background(), tracer)
+
+	var a, b bytes.Buffer
+	if err := EncodeToWriters(ctx, strings.NewReader("fuzz the decoder"), []io.Writer{&a, &b}, 2); err != nil {
+		f.Fatalf("Failed to encode seed: %v", err)
+	}
+	f.Add(a.Bytes(), b.Bytes())
+	f.Add(a.Bytes(), a.Bytes())
+	f.Add(a.Bytes()[:20], b.Bytes())
+	f.Add([]byte("\x122A2:1:999999999999"), []byte("\x122B2:1:999999999999"))
+	f.Add([]byte("\x0e2A2:1:99999999"), []byte("\x0e2B2:1:99999999"))
+	f.Add([]byte{}, []byte{0xff})
+
+	f.Fuzz(func(t *testing.T, first, second []byte) {
+		var output bytes.Buffer
+		DecodeFromReaders(ctx, []io.Reader{bytes.NewReader(first), bytes.NewReader(second)}, &output)
+		if output.Len() > len(first)+len(second) {
+			t.Errorf("Decoded %d bytes from collections of %d and %d", output.Len(), len(first), len(second))
+		}
+	})
+}
+
+// spanRecorder keeps the paths of the spans exported to it
+type spanRecorder struct {
+	paths []string
+}
+
+func (r *spanRecorder) ExportSpan(span *trace.Span) error {
+	r.paths = append(r.paths, span.Path)
+	return nil
+}
+
+// TestSpans tests that encodes and decodes are timed chunk by chunk, and
+// encodes collection by collection
+func TestSpans(t *testing.T) {
+	tracer := trace.NewTracer("TEST", trace.LogLevelNormal)
+	recorder := &spanRecorder{}
+	tracer.SetSpanExporters(recorder)
+	ctx := trace.WithContext(context.Background(), tracer)
+
+	input := bytes.Repeat([]byte("spans "), 500)
+	buffers := make([]bytes.Buffer, 3)
+	writers := []io.Writer{&buffers[0], &buffers[1], &buffers[2]}
+	opCtx, op := trace.StartOperation(ctx, "test")
+	if err := EncodeToWriters(opCtx, bytes.NewReader(input), writers, 2); err != nil {
+		t.Fatalf("EncodeToWriters failed: %v", err)
+	}
+	op.End()
+	want := []string{"test/encode/chunk 1/collection 2A3", "test/encode/chunk 1/collection 2B3", "test/encode/chunk 1/collection 2C3", "test/encode/chunk 1", "test/encode", "test"}
+	if strings.Join(recorder.paths, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected encode spans %q, got %q", want, recorder.paths)
+	}
+
+	recorder.paths = nil
+	if err := DecodeFromReaders(ctx, []io.Reader{&buffers[0], &buffers[2]}, io.Discard); err != nil {
+		t.Fatalf("DecodeFromReaders failed: %v", err)
+	}
+	if want := []string{"decode/chunk 1", "decode"}; strings.Join(recorder.paths, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected decode spans %q, got %q", want, recorder.paths)
+	}
+}
+
+// TestPadConcurrentUse tests that one Pad and one RNG can serve several encodes
+// and decodes at once; run it with -race to check for data races
+func TestPadConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	encoder, err := NewPadForEncode(ctx, 4, 2)
+	if err != nil {
+		t.Fatalf("NewPadForEncode failed: %v", err)
+	}
+	decoder, err := NewPadForDecode(ctx, 2)
+	if err != nil {
+		t.Fatalf("NewPadForDecode failed: %v", err)
+	}
+	rng := NewDefaultRand(ctx)
+
+	const workers = 8
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			input := bytes.Repeat([]byte{byte(w)}, 5000+w*997)
+			streams := make(map[string]*bytes.Buffer)
+			newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+				if streams[collectionName] == nil {
+					streams[collectionName] = &bytes.Buffer{}
+				}
+				return nopWriteCloser{streams[collectionName]}, nil
+			}
+			if err := encoder.Encode(ctx, 1200, bytes.NewReader(input), rng, newChunk, "bin"); err != nil {
+				errs <- fmt.Errorf("worker %d: Encode failed: %w", w, err)
+				return
+			}
+			var output bytes.Buffer
+			readers := []io.Reader{streams["2D4"], streams["2B4"]}
+			if err := decoder.Decode(ctx, readers, &output); err != nil {
+				errs <- fmt.Errorf("worker %d: Decode failed: %w", w, err)
+				return
+			}
+			if !bytes.Equal(output.Bytes(), input) {
+				errs <- fmt.Errorf("worker %d: decoded %d bytes that differ from the input", w, output.Len())
+				return
+			}
+			errs <- nil
+		}(w)
+	}
+	for w := 0; w < workers; w++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The pads are left as they were configured
+	if encoder.RequiredCopies != 2 || encoder.TotalCopies != 4 || decoder.RequiredCopies != 2 || decoder.TotalCopies != 2 {
+		t.Errorf("Expected the pads to keep their sets, got %d-of-%d and %d-of-%d",
+			encoder.RequiredCopies, encoder.TotalCopies, decoder.RequiredCopies, decoder.TotalCopies)
+	}
+}
@@ -0,0 +1,128 @@
+package pad
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// paddedMagic and paddedCountBytes lead the plaintext of a padded encode, and
+// legacyPaddedMagic that of one written before its count was widened. They are
+// described with paddecode, which drops the padding when decoding.
+var (
+	paddedMagic       = paddecode.PaddedMagic
+	legacyPaddedMagic = paddecode.LegacyPaddedMagic
+)
+
+const paddedCountBytes = paddecode.PaddedCountBytes
+
+// MinChunkSize returns the smallest chunk size Encode accepts for a K-of-N
+// set: one byte of input in each piece of a chunk or, if padded, room in each
+// for the marker and count that lead a padded chunk and a byte of input
+func MinChunkSize(totalCopies, requiredCopies int, padded bool) int {
+	if padded {
+		return PiecesPerCollection(requiredCopies, totalCopies) * (len(paddedMagic) + paddedCountBytes + 1)
+	}
+	return PiecesPerCollection(requiredCopies, totalCopies)
+}
+
+// MinChunkSize returns the smallest chunk size Encode accepts for the pad
+func (p *Pad) MinChunkSize() int {
+	return MinChunkSize(p.TotalCopies, p.RequiredCopies, p.PadToChunk)
+}
+
+// encodePadded encodes the input as Encode does, but fills every chunk to the
+// full chunk size, so that neither the size of the last chunk nor, with
+// ExtraChunks, the number of chunks reveals the exact length of the input.
+// Each chunk's plaintext starts with the number of data bytes it holds, which
+// decoders use to drop the padding; the count is encrypted with the data.
+//
+// If decoy is not nil, it is read in step with the input into st.decoyChunk, for
+// the permutations in st.decoyPerms, and the encode lasts as long as the longer
+// of the two; the shorter is followed by chunks that hold no data.
+func (p *Pad) encodePadded(ctx context.Context, st *encodeState, inputChunkBytes int, input io.Reader, decoy io.Reader, randomSource RNG, newChunk NewChunkFunc, chunkFormat string) error {
+	log := trace.FromContext(ctx).WithPrefix("ENCODE")
+
+	if inputChunkBytes <= len(paddedMagic)+paddedCountBytes {
+		return fmt.Errorf("%w: chunks of %d bytes are too small to pad", ErrInvalidParameters, inputChunkBytes*p.PermutationCount)
+	}
+
+	buffer := make([]byte, inputChunkBytes)
+	defer Zeroize(buffer)
+	if decoy != nil {
+		st.decoyChunk = make([]byte, inputChunkBytes)
+		defer Zeroize(st.decoyChunk)
+	}
+	chunkIndex := 1
+	for ; ; chunkIndex++ {
+		// The first chunk is written even for empty input, so that it carries the marker
+		bytesRead, ended, err := readPadded(input, buffer, chunkIndex)
+		if err != nil {
+			return err
+		}
+		if decoy != nil {
+			decoyRead, decoyEnded, err := readPadded(decoy, st.decoyChunk, chunkIndex)
+			if err != nil {
+				return err
+			}
+			bytesRead, ended = bytesRead+decoyRead, ended && decoyEnded
+		}
+		if bytesRead == 0 && chunkIndex > 1 {
+			break
+		}
+		if err := p.encodeOneChunk(ctx, st, buffer, chunkIndex, randomSource, newChunk, chunkFormat); err != nil {
+			return err
+		}
+		if ended {
+			chunkIndex++
+			break
+		}
+	}
+	log.Debugf("Padded %d chunks to %d bytes of data each", chunkIndex-1, inputChunkBytes)
+
+	// Follow the data with a random number of chunks holding no data at all
+	if p.ExtraChunks > 0 {
+		var b [4]byte
+		if err := randomSource.Read(ctx, b[:]); err != nil {
+			log.Error(fmt.Errorf("random generator error: %w", err))
+			return fmt.Errorf("random generator error: %w", err)
+		}
+		metrics.rngBytes.Add(int64(len(b)))
+		extra := int(binary.BigEndian.Uint32(b[:]) % uint32(p.ExtraChunks+1))
+		for i := 0; i < extra; i, chunkIndex = i+1, chunkIndex+1 {
+			clear(buffer)
+			clear(st.decoyChunk)
+			if err := p.encodeOneChunk(ctx, st, buffer, chunkIndex, randomSource, newChunk, chunkFormat); err != nil {
+				return err
+			}
+		}
+		log.Debugf("Added %d empty chunks", extra)
+	}
+	return nil
+}
+
+// readPadded fills buffer with the plaintext of a padded chunk: the marker if
+// this is the first chunk, the count of data bytes, and as much of the input as
+// fits. It returns the number of bytes read and whether the input has ended.
+func readPadded(input io.Reader, buffer []byte, chunkIndex int) (int, bool, error) {
+	clear(buffer)
+	header := paddedCountBytes
+	if chunkIndex == 1 {
+		header += copy(buffer, paddedMagic)
+	}
+	bytesRead, err := io.ReadFull(input, buffer[header:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, false, fmt.Errorf("input read error: %w", err)
+	}
+	binary.BigEndian.PutUint64(buffer[header-paddedCountBytes:header], uint64(bytesRead))
+	return bytesRead, err != nil, nil
+}
+
+// newUnpadWriter returns a writer that drops the padding of a padded encode
+func newUnpadWriter(w io.Writer) io.Writer {
+	return paddecode.NewUnpadWriter(w)
+}
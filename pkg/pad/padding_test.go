@@ -0,0 +1,187 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// TestEncodePadded tests that padded encodes hide the input length and decode to the input
+func TestEncodePadded(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tests := []struct {
+		name        string
+		inputBytes  int
+		extraChunks int
+	}{
+		{"Empty input", 0, 0},
+		{"Less than one chunk", 10, 0},
+		{"Exactly one chunk", 1500 - len(paddedMagic) - paddedCountBytes, 0},
+		{"Just over one chunk", 1500 - len(paddedMagic) - paddedCountBytes + 1, 0},
+		{"Several chunks", 4321, 0},
+		{"Extra chunks", 4321, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := make([]byte, tt.inputBytes)
+			if _, err := rand.Read(input); err != nil {
+				t.Fatalf("Failed to generate input: %v", err)
+			}
+
+			encoded := make(map[string]map[int]*bytes.Buffer)
+			newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+				if encoded[collectionName] == nil {
+					encoded[collectionName] = make(map[int]*bytes.Buffer)
+				}
+				encoded[collectionName][chunkNumber] = &bytes.Buffer{}
+				return nopWriteCloser{encoded[collectionName][chunkNumber]}, nil
+			}
+			p, err := NewPadForEncode(ctx, 3, 2)
+			if err != nil {
+				t.Fatalf("NewPadForEncode failed: %v", err)
+			}
+			p.PadToChunk, p.ExtraChunks = true, tt.extraChunks
+			if err := p.Encode(ctx, 3000, bytes.NewReader(input), NewDefaultRand(ctx), newChunk, "bin"); err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+
+			// Every chunk of every collection has the same size
+			size := -1
+			for name, chunks := range encoded {
+				for n, chunk := range chunks {
+					if size == -1 {
+						size = chunk.Len()
+					}
+					if chunk.Len() != size {
+						t.Errorf("Chunk %d of %s has %d bytes, expected %d", n, name, chunk.Len(), size)
+					}
+				}
+			}
+			// Each chunk holds 3000/PermutationCount bytes of data and padding
+			chunkData := 3000/p.PermutationCount - paddedCountBytes
+			dataChunks := max(1, (tt.inputBytes+len(paddedMagic)+chunkData-1)/chunkData)
+			if chunks := len(encoded["2A3"]); chunks < dataChunks || chunks > dataChunks+tt.extraChunks {
+				t.Errorf("Expected %d to %d chunks, got %d", dataChunks, dataChunks+tt.extraChunks, chunks)
+			}
+
+			// Both decoders drop the padding
+			var readers []io.Reader
+			var sources []ChunkSource
+			for _, name := range []string{"2A3", "2C3"} {
+				var stream bytes.Buffer
+				s := &memorySource{name: name, chunks: make(map[int][]byte)}
+				for n := 1; n <= len(encoded[name]); n++ {
+					stream.Write(encoded[name][n].Bytes())
+					s.chunks[n] = encoded[name][n].Bytes()
+				}
+				readers = append(readers, &stream)
+				sources = append(sources, s)
+			}
+			var output bytes.Buffer
+			if err := DecodeFromReaders(ctx, readers, &output); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !bytes.Equal(output.Bytes(), input) {
+				t.Errorf("Decoded %d bytes, expected the %d bytes of input", output.Len(), len(input))
+			}
+			dp, err := NewPadForDecode(ctx, 3)
+			if err != nil {
+				t.Fatalf("NewPadForDecode failed: %v", err)
+			}
+			output.Reset()
+			if _, err := dp.DecodeTolerant(ctx, sources, &output, true); err != nil {
+				t.Fatalf("DecodeTolerant failed: %v", err)
+			}
+			if !bytes.Equal(output.Bytes(), input) {
+				t.Errorf("Tolerant decode gave %d bytes, expected the %d bytes of input", output.Len(), len(input))
+			}
+		})
+	}
+}
+
+// TestEncodePaddedTooSmall tests that chunks too small to hold the padding header are rejected,
+// and that MinChunkSize is the smallest accepted, padded or not
+func TestEncodePaddedTooSmall(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	p, err := NewPadForEncode(ctx, 3, 2)
+	if err != nil {
+		t.Fatalf("NewPadForEncode failed: %v", err)
+	}
+	p.PadToChunk = true
+	newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		return nopWriteCloser{io.Discard}, nil
+	}
+	err = p.Encode(ctx, p.PermutationCount*len(paddedMagic), bytes.NewReader([]byte("data")), NewDefaultRand(ctx), newChunk, "bin")
+	if !errors.Is(err, ErrInvalidParameters) {
+		t.Errorf("Expected ErrInvalidParameters, got %v", err)
+	}
+
+	for _, padded := range []bool{true, false} {
+		p.PadToChunk = padded
+		size := p.MinChunkSize()
+		if err := p.Encode(ctx, size, bytes.NewReader([]byte("data")), NewDefaultRand(ctx), newChunk, "bin"); err != nil {
+			t.Errorf("Encode of %d-byte chunks, padded %v, failed: %v", size, padded, err)
+		}
+		if err := p.Encode(ctx, size-1, bytes.NewReader([]byte("data")), NewDefaultRand(ctx), newChunk, "bin"); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("Expected ErrInvalidParameters for %d-byte chunks, padded %v, got %v", size-1, padded, err)
+		}
+	}
+}
+
+// TestUnpadWriter tests how decoded chunks are passed on
+func TestUnpadWriter(t *testing.T) {
+	// chunk builds the plaintext of a padded chunk
+	chunk := func(first bool, data string, size int) []byte {
+		var b []byte
+		if first {
+			b = append(b, paddedMagic...)
+		}
+		b = append(b, 0, 0, 0, 0, 0, 0, 0, byte(len(data)))
+		b = append(b, data...)
+		return append(b, make([]byte, size-len(b))...)
+	}
+
+	tests := []struct {
+		name    string
+		chunks  [][]byte
+		want    string
+		wantErr error
+	}{
+		{"Unpadded", [][]byte{[]byte("plain "), []byte("data")}, "plain data", nil},
+		{"Padded", [][]byte{chunk(true, "padded ", 64), chunk(false, "data", 64), chunk(false, "", 64)}, "padded data", nil},
+		{"Magic only in first chunk", [][]byte{[]byte("plain "), paddedMagic}, "plain " + string(paddedMagic), nil},
+		{"Legacy count", [][]byte{append(append(append([]byte{}, legacyPaddedMagic...), 0, 0, 0, 6), "legacy   "...), {0, 0, 0, 4, 'd', 'a', 't', 'a', 0}}, "legacydata", nil},
+		{"Count too large", [][]byte{chunk(true, "padded", 64), append([]byte{0, 0, 0, 0, 0, 0, 1, 0}, make([]byte, 56)...)}, "padded", ErrChunkCorrupt},
+		{"Count beyond an int", [][]byte{chunk(true, "padded", 64), append([]byte{0x80, 0, 0, 0, 0, 0, 0, 0}, make([]byte, 56)...)}, "padded", ErrChunkCorrupt},
+		{"Chunk too small", [][]byte{chunk(true, "padded", 64), {0, 0}}, "padded", ErrChunkCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			w := newUnpadWriter(&output)
+			var err error
+			for _, c := range tt.chunks {
+				if _, err = w.Write(c); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if output.String() != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, output.String())
+			}
+		})
+	}
+}
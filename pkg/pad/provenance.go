@@ -0,0 +1,127 @@
+// This file contains the recording of where the random numbers of an encode
+// came from, for a report that documents the quality of its one-time pads.
+
+package pad
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"sync"
+	"time"
+)
+
+// StateHasher is implemented by random sources whose state can be committed
+// to without revealing it, such as a RemoteRNG, whose seed anyone holding its
+// responses can recompute. StateHash returns the SHA-256 of that state.
+type StateHasher interface {
+	StateHash() []byte
+}
+
+// EntropyReport documents the random sources of an encode, what each of them
+// contributed and how they fared in the health tests run before it
+type EntropyReport struct {
+	Created time.Time      `json:"created"`          // When the report was completed
+	Sources []SourceReport `json:"sources"`          // Each source mixed into the pads, in the order they are mixed
+	Bytes   int64          `json:"bytes"`            // Random bytes drawn from the mix of all of them
+	Health  []HealthResult `json:"health,omitempty"` // Results of the health tests, for each source and then the mix
+}
+
+// SourceReport documents one random source of an EntropyReport
+type SourceReport struct {
+	Source       string        `json:"source"`                 // Name of the source, e.g. "crypto"
+	Remote       string        `json:"remote,omitempty"`       // Where a remote source fetched its randomness from
+	Bytes        int64         `json:"bytes"`                  // Random bytes the source contributed
+	OutputSHA256 string        `json:"outputSha256,omitempty"` // SHA-256 of those bytes, for a source that is one of several
+	StateSHA256  string        `json:"stateSha256,omitempty"`  // SHA-256 of the source's state, for a StateHasher
+	Fetches      []RemoteFetch `json:"fetches,omitempty"`      // Responses mixed in by a RemoteRNG
+}
+
+// EntropyRecorder records what each source of an RNG contributes, for an
+// EntropyReport. It is safe for concurrent use.
+type EntropyRecorder struct {
+	mix     *meteredRNG
+	sources []*meteredRNG
+}
+
+// RecordEntropy returns an RNG that reads from rng, recording what it and
+// each of its sources, if it is a MultiRNG, contribute, and the recorder
+// that reports it. The output of a source is hashed only if it is one of
+// several: the SHA-256 of the bytes of a lone source, which are the pads
+// themselves, would let anyone with the collections confirm a guess of the
+// input, while no one source of a mix reveals anything about the pads.
+func RecordEntropy(rng RNG) (RNG, *EntropyRecorder) {
+	rec := &EntropyRecorder{}
+	m, ok := rng.(*MultiRNG)
+	if !ok {
+		rec.mix = &meteredRNG{RNG: rng}
+		rec.sources = []*meteredRNG{rec.mix}
+		return rec.mix, rec
+	}
+	sources := make([]RNG, len(m.Sources))
+	for i, source := range m.Sources {
+		r := &meteredRNG{RNG: source}
+		if len(m.Sources) > 1 {
+			r.hash = sha256.New()
+		}
+		rec.sources = append(rec.sources, r)
+		sources[i] = r
+	}
+	rec.mix = &meteredRNG{RNG: &MultiRNG{Sources: sources}}
+	return rec.mix, rec
+}
+
+// Report returns the report of what has been drawn so far, with the results
+// of health tests run beforehand, if any
+func (rec *EntropyRecorder) Report(health []HealthResult) EntropyReport {
+	report := EntropyReport{Created: time.Now().UTC(), Bytes: rec.mix.bytes(), Health: health}
+	for _, r := range rec.sources {
+		s := SourceReport{Source: r.Name(), Bytes: r.bytes()}
+		if remote, ok := r.RNG.(RemoteSource); ok {
+			s.Remote = remote.Remote()
+		}
+		if r.hash != nil {
+			r.lock.Lock()
+			s.OutputSHA256 = hex.EncodeToString(r.hash.Sum(nil))
+			r.lock.Unlock()
+		}
+		if h, ok := r.RNG.(StateHasher); ok {
+			s.StateSHA256 = hex.EncodeToString(h.StateHash())
+		}
+		if remote, ok := r.RNG.(*RemoteRNG); ok {
+			s.Fetches = remote.Fetches()
+		}
+		report.Sources = append(report.Sources, s)
+	}
+	return report
+}
+
+// meteredRNG counts, and optionally hashes, the bytes read from an RNG
+type meteredRNG struct {
+	RNG
+	lock  sync.Mutex
+	count int64
+	hash  hash.Hash
+}
+
+// Read implements RNG
+func (r *meteredRNG) Read(ctx context.Context, p []byte) error {
+	if err := r.RNG.Read(ctx, p); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.count += int64(len(p))
+	if r.hash != nil {
+		r.hash.Write(p)
+	}
+	return nil
+}
+
+// bytes returns the number of bytes read so far
+func (r *meteredRNG) bytes() int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.count
+}
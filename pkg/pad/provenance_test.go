@@ -0,0 +1,47 @@
+package pad
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestRecordEntropy(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+
+	rng, rec := RecordEntropy(&MultiRNG{Sources: []RNG{NewTestRNG(1), NewTestRNG(100)}})
+	buf := make([]byte, 100)
+	for i := 0; i < 3; i++ {
+		if err := rng.Read(ctx, buf); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+	}
+
+	// The output of each source is hashed as it was drawn
+	want := make([]byte, 300)
+	NewTestRNG(1).Read(ctx, want)
+	sum := sha256.Sum256(want)
+
+	report := rec.Report(nil)
+	if report.Bytes != 300 || len(report.Sources) != 2 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	for _, s := range report.Sources {
+		if s.Source != "test" || s.Bytes != 300 || len(s.OutputSHA256) != 64 {
+			t.Errorf("Unexpected source report: %+v", s)
+		}
+	}
+	if report.Sources[0].OutputSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the hash of the first source's output, got %s", report.Sources[0].OutputSHA256)
+	}
+
+	// The output of a lone source is the pads themselves, and is not hashed
+	rng, rec = RecordEntropy(NewTestRNG(1))
+	rng.Read(ctx, buf)
+	if report := rec.Report(nil); report.Bytes != 100 || report.Sources[0].OutputSHA256 != "" {
+		t.Errorf("Unexpected report of a lone source: %+v", report)
+	}
+}
@@ -0,0 +1,165 @@
+// This file contains the randomness checks run on the pads and collection
+// chunks an encode writes, which should be indistinguishable from random
+// bytes: a chunk that is not is a sign of a bug, or of a broken generator.
+
+package pad
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// RandomnessPolicy decides what is done about data that fails the randomness checks
+type RandomnessPolicy string
+
+// Randomness policies
+const (
+	RandomnessOff  RandomnessPolicy = "off"  // The checks are not run
+	RandomnessWarn RandomnessPolicy = "warn" // Failures are warned about, and the encode goes on
+	RandomnessFail RandomnessPolicy = "fail" // A failure fails the encode with ErrNotRandom
+)
+
+// ParseRandomnessPolicy parses a randomness policy: off, warn or fail
+func ParseRandomnessPolicy(value string) (RandomnessPolicy, error) {
+	switch p := RandomnessPolicy(strings.ToLower(strings.TrimSpace(value))); p {
+	case RandomnessOff, RandomnessWarn, RandomnessFail:
+		return p, nil
+	case "":
+		return RandomnessWarn, nil
+	}
+	return "", fmt.Errorf("%w: randomness policy must be off, warn or fail, got %q", ErrInvalidParameters, value)
+}
+
+// Randomness check parameters. Below randomnessMinBytes, random data too
+// often fails the checks by chance to tell anything, and is not checked;
+// from it on, random data fails them with negligible probability.
+const (
+	randomnessMinBytes   = 256 // Smallest data checked
+	randomnessMinEntropy = 6.5 // Shannon entropy in bits per byte below which data fails
+	randomnessMaxUnused  = 200 // Byte values that may never appear in the data
+	randomnessMaxCommon  = 5   // Byte values that may each make up more than a tenth of the data
+)
+
+// RandomnessFinding is one piece of data that failed the randomness checks
+type RandomnessFinding struct {
+	Subject string  // What was checked, e.g. "collection 2A3 chunk 4" or "pad of chunk 4"
+	Bytes   int     // Size of the data
+	Problem string  // Why it failed
+	Entropy float64 // Its Shannon entropy in bits per byte
+}
+
+// String describes the finding in one line
+func (f RandomnessFinding) String() string {
+	return fmt.Sprintf("%s: %s (%d bytes, %.2f bits per byte)", f.Subject, f.Problem, f.Bytes, f.Entropy)
+}
+
+// RandomnessChecker runs the randomness checks on the data of an encode
+// under a policy, and keeps their findings for its summary. It is safe for
+// concurrent use.
+type RandomnessChecker struct {
+	Policy RandomnessPolicy
+
+	lock     sync.Mutex
+	checked  int
+	findings []RandomnessFinding
+}
+
+// NewRandomnessChecker returns a checker applying policy
+func NewRandomnessChecker(policy RandomnessPolicy) *RandomnessChecker {
+	return &RandomnessChecker{Policy: policy}
+}
+
+// randomnessKey is the context key of the randomness checker
+type randomnessKey struct{}
+
+// WithRandomnessChecker returns a context in which the pads generated and
+// the chunks written are checked by c
+func WithRandomnessChecker(ctx context.Context, c *RandomnessChecker) context.Context {
+	return context.WithValue(ctx, randomnessKey{}, c)
+}
+
+// CheckRandomness checks data with the randomness checker of the context,
+// or under RandomnessWarn if it has none. It returns an error wrapping
+// ErrNotRandom if the data fails and the policy is RandomnessFail.
+func CheckRandomness(ctx context.Context, subject string, data []byte) error {
+	c, _ := ctx.Value(randomnessKey{}).(*RandomnessChecker)
+	if c == nil {
+		c = NewRandomnessChecker(RandomnessWarn)
+	}
+	return c.Check(ctx, subject, data)
+}
+
+// Check runs the randomness checks on data, recording a failure as a
+// finding, warning about it under RandomnessWarn and returning an error
+// wrapping ErrNotRandom under RandomnessFail
+func (c *RandomnessChecker) Check(ctx context.Context, subject string, data []byte) error {
+	if c.Policy == RandomnessOff || len(data) < randomnessMinBytes {
+		return nil
+	}
+	log := trace.FromContext(ctx).WithPrefix("RANDOMNESS-CHECK")
+
+	problem, entropy := testRandomness(data)
+	c.lock.Lock()
+	c.checked++
+	if problem != "" {
+		c.findings = append(c.findings, RandomnessFinding{Subject: subject, Bytes: len(data), Problem: problem, Entropy: entropy})
+	}
+	c.lock.Unlock()
+
+	if problem == "" {
+		log.Debugf("%s passed the randomness check: entropy = %.2f bits per byte", subject, entropy)
+		return nil
+	}
+	if c.Policy == RandomnessFail {
+		return fmt.Errorf("%w: %s: %s", ErrNotRandom, subject, problem)
+	}
+	log.Infof("Warning: %s failed the randomness check: %s", subject, problem)
+	return nil
+}
+
+// Summary returns how much data was checked, and the findings of the checks
+func (c *RandomnessChecker) Summary() (checked int, findings []RandomnessFinding) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.checked, append([]RandomnessFinding(nil), c.findings...)
+}
+
+// testRandomness runs the randomness checks on data, returning why it failed,
+// or an empty string if it passed, and its Shannon entropy
+func testRandomness(data []byte) (string, float64) {
+	counts := make([]int, 256)
+	for _, b := range data {
+		counts[b]++
+	}
+
+	unused, common := 0, 0
+	entropy := 0.0
+	for _, count := range counts {
+		if count == 0 {
+			unused++
+			continue
+		}
+		if count > len(data)/10 {
+			common++
+		}
+		p := float64(count) / float64(len(data))
+		entropy -= p * math.Log2(p)
+	}
+
+	switch {
+	case counts[0] > len(data)/4:
+		return fmt.Sprintf("%d%% of its bytes are zero", 100*counts[0]/len(data)), entropy
+	case unused > randomnessMaxUnused:
+		return fmt.Sprintf("only %d of 256 byte values appear", 256-unused), entropy
+	case common > randomnessMaxCommon:
+		return fmt.Sprintf("%d byte values each make up more than a tenth of it", common), entropy
+	case entropy < randomnessMinEntropy:
+		return fmt.Sprintf("its entropy is %.2f bits per byte, where random data has close to 8", entropy), entropy
+	}
+	return "", entropy
+}
@@ -0,0 +1,71 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestRandomnessChecker(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+
+	random := make([]byte, 4096)
+	rand.Read(random)
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"Random", random, true},
+		{"Smallest random", random[:randomnessMinBytes], true},
+		{"Too small to check", make([]byte, randomnessMinBytes-1), true},
+		{"Zeros", make([]byte, 1024), false},
+		{"Text", []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 30)), false},
+		{"Counter", bytes.Repeat([]byte{0, 1, 2, 3, 4, 5, 6, 7}, 128), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warn := NewRandomnessChecker(RandomnessWarn)
+			if err := warn.Check(ctx, tt.name, tt.data); err != nil {
+				t.Errorf("Expected warn not to fail, got %v", err)
+			}
+			if _, findings := warn.Summary(); (len(findings) == 0) != tt.ok {
+				t.Errorf("Expected ok=%v, got findings %v", tt.ok, findings)
+			}
+
+			err := NewRandomnessChecker(RandomnessFail).Check(ctx, tt.name, tt.data)
+			if tt.ok && err != nil {
+				t.Errorf("Expected the data to pass, got %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrNotRandom) {
+				t.Errorf("Expected ErrNotRandom, got %v", err)
+			}
+
+			off := NewRandomnessChecker(RandomnessOff)
+			if err := off.Check(ctx, tt.name, tt.data); err != nil {
+				t.Errorf("Expected off not to check, got %v", err)
+			}
+			if checked, _ := off.Summary(); checked != 0 {
+				t.Errorf("Expected off not to check, got %d checked", checked)
+			}
+		})
+	}
+
+	t.Run("Context", func(t *testing.T) {
+		c := NewRandomnessChecker(RandomnessFail)
+		if err := CheckRandomness(WithRandomnessChecker(ctx, c), "zeros", make([]byte, 1024)); !errors.Is(err, ErrNotRandom) {
+			t.Errorf("Expected the checker of the context to fail, got %v", err)
+		}
+		if err := CheckRandomness(ctx, "zeros", make([]byte, 1024)); err != nil {
+			t.Errorf("Expected only a warning without a checker, got %v", err)
+		}
+		if _, err := ParseRandomnessPolicy("strict"); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("Expected an unknown policy to be rejected, got %v", err)
+		}
+	})
+}
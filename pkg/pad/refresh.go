@@ -0,0 +1,193 @@
+package pad
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// Refresh produces mathematically new shares of the same data from a complete
+// set of N collections, without ever reconstructing the data.
+//
+// Every permutation of K collections holds K pieces whose XOR is the plaintext.
+// For each permutation of each chunk, Refresh generates K random masks whose XOR
+// is zero and XORs one mask into each piece. The XOR of the pieces, and hence the
+// data, is unchanged, but every piece is now independent of its previous value,
+// so a share that leaked before the refresh is useless when combined with shares
+// issued after it.
+//
+// Because every collection holds a piece of every permutation it belongs to, all
+// N collections are required. The refreshed chunks are written through newChunk
+// with the same names and sizes as the originals, but a new session ID, as
+// they no longer decode with the shares they replace.
+//
+// Parameters:
+//   - ctx: Context for logging, cancellation, and tracing
+//   - collections: One reader per collection, in any order, all N of them
+//   - randomSource: Source of random bytes for the masks
+//   - newChunk: Function to create output files for each refreshed chunk
+//   - chunkFormat: Format for output files (e.g., "bin" or "png")
+func (p *Pad) Refresh(ctx context.Context, collections []io.Reader, randomSource RNG, newChunk NewChunkFunc, chunkFormat string) error {
+	log := trace.FromContext(ctx).WithPrefix("REFRESH")
+
+	log.Debugf("Starting refresh with %d collections", len(collections))
+	padInitialized := false
+	var session uint64
+	for chunkNumber := 1; ; chunkNumber++ {
+
+		// Read the next chunk of every collection, keyed by collection letter
+		bodies := make(map[string][]byte, len(collections))
+		headers := make(map[string]chunkHeader, len(collections))
+		chunkDataBytes := 0
+		done := 0
+		for i, r := range collections {
+			header, err := readChunkHeader(r)
+			if err == io.EOF {
+				done++
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("collection %d: %w", i, err)
+			}
+			collName, num, dataBytes := header.collName, header.number, header.dataBytes
+			requiredCopies, totalCopies, collLetter, err := extractFromCollectionLabel(collName)
+			if err != nil {
+				return fmt.Errorf("collection %d: invalid collection name %s: %w", i, collName, err)
+			}
+
+			// The first chunk determines the scheme, which must include every collection
+			if !padInitialized {
+				scheme, err := p.forScheme(ctx, totalCopies, requiredCopies)
+				if err != nil {
+					return err
+				}
+				p = scheme
+				if len(collections) != totalCopies {
+					return fmt.Errorf("%w: refresh requires all %d collections, got %d", ErrInsufficientCollections, totalCopies, len(collections))
+				}
+				padInitialized = true
+			}
+			if requiredCopies != p.RequiredCopies || totalCopies != p.TotalCopies {
+				return fmt.Errorf("%w: collection %s does not belong to a %d-of-%d set", ErrSessionMismatch, collName, p.RequiredCopies, p.TotalCopies)
+			}
+			if num != chunkNumber {
+				return fmt.Errorf("%w: collection %s: chunk number mismatch: expected %d, got %d", ErrChunkCorrupt, collName, chunkNumber, num)
+			}
+			if _, dup := bodies[collLetter]; dup {
+				return fmt.Errorf("collection %s was supplied more than once", collName)
+			}
+			if chunkDataBytes != 0 && dataBytes != chunkDataBytes {
+				return fmt.Errorf("%w: collection %s: chunk %d holds %d bytes, expected %d", ErrSessionMismatch, collName, chunkNumber, dataBytes, chunkDataBytes)
+			}
+			chunkDataBytes = dataBytes
+
+			body := make([]byte, dataBytes*p.PermutationCount)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return fmt.Errorf("%w: collection %s: failed to read chunk %d: %w", ErrChunkCorrupt, collName, chunkNumber, err)
+			}
+			bodies[collLetter] = body
+			headers[collLetter] = header
+		}
+
+		if done == len(collections) {
+			log.Debugf("Refresh completed after %d chunks", chunkNumber-1)
+			return nil
+		}
+		if done > 0 {
+			return fmt.Errorf("%w: collections end at different chunks: %d of %d ended before chunk %d", ErrChunkCorrupt, done, len(collections), chunkNumber)
+		}
+
+		// Mask each permutation's pieces with random values that XOR to zero
+		mask := make([]byte, chunkDataBytes)
+		last := make([]byte, chunkDataBytes)
+		err := paddecode.ForEachPermutation(p.RequiredCopies, p.TotalCopies, func(perm string) error {
+			for i := range last {
+				last[i] = 0
+			}
+			for i := 0; i < len(perm); i++ {
+				letter := perm[i : i+1]
+				if i < len(perm)-1 {
+					if err := randomSource.Read(ctx, mask); err != nil {
+						log.Error(fmt.Errorf("random generator error: %w", err))
+						return fmt.Errorf("random generator error: %w", err)
+					}
+					metrics.rngBytes.Add(int64(len(mask)))
+					if err := CheckRandomness(ctx, fmt.Sprintf("refresh mask of chunk %d", chunkNumber), mask); err != nil {
+						return err
+					}
+					for j := range mask {
+						last[j] ^= mask[j]
+					}
+				} else {
+					copy(mask, last)
+				}
+				piece, err := p.pieceOffset(letter, perm, chunkDataBytes)
+				if err != nil {
+					return err
+				}
+				body := bodies[letter]
+				for j := range mask {
+					body[piece+j] ^= mask[j]
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Write the refreshed chunks, under a new session ID, so that a decode
+		// mixing them with the shares they replace fails rather than
+		// producing garbage
+		if chunkNumber == 1 {
+			if session, err = newSession(ctx, randomSource); err != nil {
+				return err
+			}
+		}
+		for _, collName := range p.Collections {
+			_, _, collLetter, _ := extractFromCollectionLabel(collName)
+			w, err := newChunk(collName, chunkNumber, chunkFormat)
+			if err != nil {
+				return fmt.Errorf("failed to create chunk writer for collection %s: %w", collName, err)
+			}
+			header := headers[collLetter]
+			if header.version != legacyVersion {
+				header.session = session
+			}
+			raw, err := header.marshal()
+			if err != nil {
+				w.Close()
+				return err
+			}
+			if _, err := w.Write(raw); err != nil {
+				w.Close()
+				return fmt.Errorf("failed to write chunk header for collection %s: %w", collName, err)
+			}
+			if _, err := w.Write(bodies[collLetter]); err != nil {
+				w.Close()
+				return fmt.Errorf("failed to write chunk data for collection %s: %w", collName, err)
+			}
+			if err := w.Close(); err != nil {
+				return fmt.Errorf("failed to write chunk %d of collection %s: %w", chunkNumber, collName, err)
+			}
+		}
+		Zeroize(mask)
+		Zeroize(last)
+		for _, body := range bodies {
+			Zeroize(body)
+		}
+		log.Debugf("Chunk %d: refreshed %d permutations", chunkNumber, paddecode.PermutationCount(p.RequiredCopies, p.TotalCopies))
+	}
+}
+
+// pieceOffset returns the offset within a collection's chunk of its piece of a permutation
+func (p *Pad) pieceOffset(collLetter string, perm string, chunkDataBytes int) (int, error) {
+	i, err := paddecode.PieceIndex(perm, collLetter, p.TotalCopies)
+	if err != nil {
+		return 0, err
+	}
+	return i * chunkDataBytes, nil
+}
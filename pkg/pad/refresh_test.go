@@ -0,0 +1,96 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// TestRefresh tests that refreshed shares decode to the same data but cannot be mixed with the old shares
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	input := make([]byte, 10000)
+	if _, err := rand.Read(input); err != nil {
+		t.Fatalf("Failed to generate input: %v", err)
+	}
+
+	// Encode a 2-of-3 set in memory
+	old := make([]bytes.Buffer, 3)
+	writers := make([]io.Writer, len(old))
+	for i := range old {
+		writers[i] = &old[i]
+	}
+	if err := EncodeToWriters(ctx, bytes.NewReader(input), writers, 2); err != nil {
+		t.Fatalf("EncodeToWriters failed: %v", err)
+	}
+
+	// refresh runs Refresh over the given old collections, returning the refreshed streams by name
+	refresh := func(indexes ...int) (map[string]*bytes.Buffer, error) {
+		readers := make([]io.Reader, len(indexes))
+		for i, index := range indexes {
+			readers[i] = bytes.NewReader(old[index].Bytes())
+		}
+		refreshed := make(map[string]*bytes.Buffer)
+		newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+			if refreshed[collectionName] == nil {
+				refreshed[collectionName] = &bytes.Buffer{}
+			}
+			return nopWriteCloser{refreshed[collectionName]}, nil
+		}
+		p, err := NewPadForDecode(ctx, len(readers))
+		if err != nil {
+			return nil, err
+		}
+		return refreshed, p.Refresh(ctx, readers, NewDefaultRand(ctx), newChunk, "bin")
+	}
+
+	// Collections are accepted in any order
+	refreshed, err := refresh(2, 0, 1)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if len(refreshed) != 3 {
+		t.Fatalf("Expected 3 refreshed collections, got %d", len(refreshed))
+	}
+	if refreshed["2A3"].Len() != old[0].Len() || bytes.Equal(refreshed["2A3"].Bytes(), old[0].Bytes()) {
+		t.Errorf("Refreshed collection should be the same size as, but differ from, the original")
+	}
+
+	// decode decodes the given streams
+	decode := func(streams ...[]byte) []byte {
+		readers := make([]io.Reader, len(streams))
+		for i, stream := range streams {
+			readers[i] = bytes.NewReader(stream)
+		}
+		var output bytes.Buffer
+		if err := DecodeFromReaders(ctx, readers, &output); err != nil {
+			t.Fatalf("DecodeFromReaders failed: %v", err)
+		}
+		return output.Bytes()
+	}
+
+	if !bytes.Equal(decode(refreshed["2A3"].Bytes(), refreshed["2C3"].Bytes()), input) {
+		t.Errorf("Refreshed collections do not decode to the original data")
+	}
+	// Refreshed collections are a new session, which the old ones don't decode with
+	var output bytes.Buffer
+	if err := DecodeFromReaders(ctx, []io.Reader{bytes.NewReader(old[0].Bytes()), bytes.NewReader(refreshed["2B3"].Bytes())}, &output); !errors.Is(err, ErrSessionMismatch) {
+		t.Errorf("Expected ErrSessionMismatch decoding an old collection with a refreshed one, got %v", err)
+	}
+
+	// Refresh needs every collection
+	if _, err := refresh(0, 1); err == nil {
+		t.Errorf("Expected an error refreshing with a missing collection")
+	}
+	if _, err := refresh(0, 1, 1); err == nil {
+		t.Errorf("Expected an error refreshing with a duplicated collection")
+	}
+}
@@ -0,0 +1,408 @@
+// This file contains the random sources that fetch randomness over the
+// network, such as public randomness beacons and quantum random number
+// services, and the RemoteRNG that mixes them into the local sources.
+
+package pad
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+	"golang.org/x/crypto/chacha20"
+)
+
+// Default endpoints of the built-in remote entropy backends
+const (
+	ANUURL  = "https://qrng.anu.edu.au/API/jsonI.php?length=32&type=uint8"
+	NISTURL = "https://beacon.nist.gov/beacon/2.0/pulse/last"
+)
+
+// maxRemoteResponse is the largest response read from a remote backend
+const maxRemoteResponse = 64 * 1024
+
+// RemoteEntropy is a backend that fetches randomness over the network, such
+// as a quantum random number service or a public randomness beacon. Fetch
+// returns the random bytes of one response, once the response has been
+// checked against what the backend promises, such as a beacon's signature.
+type RemoteEntropy interface {
+	Name() string
+	Remote() string // Where the randomness is fetched from, e.g. a URL
+	Fetch(ctx context.Context, client *http.Client) ([]byte, error)
+}
+
+// RemotePolicy decides how many backends of a RemoteRNG must answer
+type RemotePolicy string
+
+// Remote entropy policies
+const (
+	RemoteAll      RemotePolicy = "all"      // Every backend must answer, or the RNG cannot be used
+	RemoteAny      RemotePolicy = "any"      // At least one backend must answer
+	RemoteOptional RemotePolicy = "optional" // Backends that answer are mixed in, and none need to
+)
+
+// ParseRemotePolicy parses a remote entropy policy: all, any or optional
+func ParseRemotePolicy(value string) (RemotePolicy, error) {
+	switch p := RemotePolicy(strings.ToLower(strings.TrimSpace(value))); p {
+	case RemoteAll, RemoteAny, RemoteOptional:
+		return p, nil
+	case "":
+		return RemoteAll, nil
+	}
+	return "", fmt.Errorf("%w: remote entropy policy must be all, any or optional, got %q", ErrInvalidParameters, value)
+}
+
+// ParseRemoteEntropy parses the name of a built-in backend, anu, drand (or
+// drand:CHAIN) or nist, or an https URL returning JSON, whose fragment is the dotted path of
+// the field holding the random bytes, e.g.
+// https://example.com/random#data.value (default: "random")
+func ParseRemoteEntropy(spec string) (RemoteEntropy, error) {
+	spec = strings.TrimSpace(spec)
+	switch strings.ToLower(spec) {
+	case "anu":
+		return &ANUEntropy{URL: ANUURL}, nil
+	case "nist":
+		return &NISTEntropy{URL: NISTURL}, nil
+	}
+	if name, ok := strings.CutPrefix(strings.ToLower(spec), "drand"); ok && (name == "" || name[0] == ':') {
+		return ParseDrandEntropy(strings.TrimPrefix(name, ":"))
+	}
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("%w: remote entropy must be anu, drand, nist or an https URL, got %q", ErrInvalidParameters, spec)
+	}
+	field := u.Fragment
+	if field == "" {
+		field = "random"
+	}
+	u.Fragment = ""
+	return &URLEntropy{URL: u.String(), Field: field}, nil
+}
+
+// ANUEntropy fetches quantum random numbers from the ANU Quantum Random
+// Numbers Server, which measures the vacuum fluctuations of light. Its
+// responses are authenticated only by TLS.
+type ANUEntropy struct {
+	URL string
+}
+
+// Name implements RemoteEntropy
+func (e *ANUEntropy) Name() string { return "anu" }
+
+// Remote implements RemoteEntropy
+func (e *ANUEntropy) Remote() string { return e.URL }
+
+// Fetch implements RemoteEntropy
+func (e *ANUEntropy) Fetch(ctx context.Context, client *http.Client) ([]byte, error) {
+	var resp struct {
+		Success bool  `json:"success"`
+		Data    []int `json:"data"`
+	}
+	if err := fetchJSON(ctx, client, e.URL, &resp); err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("the service reported a failure")
+	}
+	return byteArray(resp.Data)
+}
+
+// NISTEntropy fetches the latest pulse of the NIST Randomness Beacon, whose
+// output value is 512 bits. Its responses are authenticated only by TLS.
+type NISTEntropy struct {
+	URL string
+}
+
+// Name implements RemoteEntropy
+func (e *NISTEntropy) Name() string { return "nist" }
+
+// Remote implements RemoteEntropy
+func (e *NISTEntropy) Remote() string { return e.URL }
+
+// Fetch implements RemoteEntropy
+func (e *NISTEntropy) Fetch(ctx context.Context, client *http.Client) ([]byte, error) {
+	var resp struct {
+		Pulse struct {
+			PulseIndex  uint64 `json:"pulseIndex"`
+			OutputValue string `json:"outputValue"`
+		} `json:"pulse"`
+	}
+	if err := fetchJSON(ctx, client, e.URL, &resp); err != nil {
+		return nil, err
+	}
+	output, err := hex.DecodeString(resp.Pulse.OutputValue)
+	if err != nil || len(output) != 64 {
+		return nil, fmt.Errorf("pulse %d is malformed", resp.Pulse.PulseIndex)
+	}
+	return output, nil
+}
+
+// URLEntropy fetches random bytes from any https URL returning JSON, from
+// the field at a dotted path, which holds hex, base64 or an array of bytes
+type URLEntropy struct {
+	URL   string
+	Field string
+}
+
+// Name implements RemoteEntropy
+func (e *URLEntropy) Name() string { return "url" }
+
+// Remote implements RemoteEntropy
+func (e *URLEntropy) Remote() string { return e.URL }
+
+// Fetch implements RemoteEntropy
+func (e *URLEntropy) Fetch(ctx context.Context, client *http.Client) ([]byte, error) {
+	var resp any
+	if err := fetchJSON(ctx, client, e.URL, &resp); err != nil {
+		return nil, err
+	}
+	value := resp
+	for _, key := range strings.Split(e.Field, ".") {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("the response has no field %s", e.Field)
+		}
+		if value, ok = obj[key]; !ok {
+			return nil, fmt.Errorf("the response has no field %s", e.Field)
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		if b, err := hex.DecodeString(v); err == nil {
+			return b, nil
+		}
+		if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+			return b, nil
+		}
+	case []any:
+		ints := make([]int, len(v))
+		for i, n := range v {
+			f, ok := n.(float64)
+			if !ok || f != float64(int(f)) {
+				return nil, fmt.Errorf("field %s is not an array of bytes", e.Field)
+			}
+			ints[i] = int(f)
+		}
+		return byteArray(ints)
+	}
+	return nil, fmt.Errorf("field %s is not hex, base64 or an array of bytes", e.Field)
+}
+
+// fetchJSON gets a URL and decodes its JSON response into v
+func fetchJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteResponse))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("the response is not valid JSON: %w", err)
+	}
+	return nil
+}
+
+// byteArray converts an array of numbers to bytes, failing if any is out of range
+func byteArray(values []int) ([]byte, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("the response holds no random bytes")
+	}
+	b := make([]byte, len(values))
+	for i, v := range values {
+		if v < 0 || v > 255 {
+			return nil, fmt.Errorf("the response holds %d, which is not a byte", v)
+		}
+		b[i] = byte(v)
+	}
+	return b, nil
+}
+
+// RemoteConfig configures a RemoteRNG
+type RemoteConfig struct {
+	Backends []RemoteEntropy     // Backends to fetch randomness from
+	Policy   RemotePolicy        // How many of them must answer (default: RemoteAll)
+	Pins     map[string][]string // SHA-256 pins of the public keys each host must present, by host, as "sha256/BASE64"
+	Timeout  time.Duration       // How long each backend has to answer (default: 10s)
+	Client   *http.Client        // Client to fetch with, for tests (default: one enforcing Pins)
+}
+
+// RemoteRNG mixes randomness fetched over the network into the random numbers
+// of an encode. The responses of its backends are fetched once, when it is
+// created, and hashed together with SHA-256 into the key of a ChaCha20
+// keystream, which is its output. A remote source thus contributes a 256-bit
+// seed: it adds computational strength and independence from the local
+// machine, while the one-time pads still rely on the local sources for their
+// information-theoretic security. It is meant to be one of the Sources of a
+// MultiRNG, whose XOR it can never weaken.
+type RemoteRNG struct {
+	lock    sync.Mutex
+	stream  *chacha20.Cipher
+	remotes []string
+	fetches []RemoteFetch
+	state   [sha256.Size]byte
+}
+
+// RemoteFetch records one response mixed into a RemoteRNG, for its report
+type RemoteFetch struct {
+	Source string `json:"source"` // Name of the backend, e.g. "drand"
+	Remote string `json:"remote"` // Where the response was fetched from
+	Bytes  int    `json:"bytes"`  // Random bytes in the response
+	SHA256 string `json:"sha256"` // SHA-256 of those bytes, which a public beacon's can be checked against
+}
+
+// NewRemoteRNG fetches randomness from the backends of cfg and returns a
+// RemoteRNG seeded with it, or nil if none answered and the policy is
+// RemoteOptional. It fails with ErrOffline in offline mode, before any
+// connection is made, and if backends fail to answer as the policy requires.
+func NewRemoteRNG(ctx context.Context, cfg RemoteConfig) (*RemoteRNG, error) {
+	log := trace.FromContext(ctx).WithPrefix("REMOTE-RNG")
+
+	if IsOffline(ctx) {
+		var remotes []string
+		for _, b := range cfg.Backends {
+			remotes = append(remotes, b.Name()+" ("+b.Remote()+")")
+		}
+		return nil, fmt.Errorf("%w: random sources would use the network: %s", ErrOffline, strings.Join(remotes, ", "))
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = RemoteAll
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	client := cfg.Client
+	if client == nil {
+		client = pinnedClient(cfg.Pins)
+	}
+
+	h := sha256.New()
+	r := &RemoteRNG{}
+	var failures []error
+	for _, b := range cfg.Backends {
+		fetchCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		data, err := b.Fetch(fetchCtx, client)
+		cancel()
+		if err != nil {
+			err = fmt.Errorf("%s remote source %s failed: %w", b.Name(), b.Remote(), err)
+			log.Error(err)
+			failures = append(failures, err)
+			continue
+		}
+		log.Debugf("Fetched %d random bytes from %s (%s)", len(data), b.Name(), b.Remote())
+		h.Write(binary.BigEndian.AppendUint32([]byte(b.Name()), uint32(len(data))))
+		h.Write(data)
+		r.remotes = append(r.remotes, b.Name()+" ("+b.Remote()+")")
+		sum := sha256.Sum256(data)
+		r.fetches = append(r.fetches, RemoteFetch{Source: b.Name(), Remote: b.Remote(), Bytes: len(data), SHA256: hex.EncodeToString(sum[:])})
+	}
+
+	switch {
+	case len(failures) > 0 && cfg.Policy == RemoteAll:
+		return nil, errors.Join(failures...)
+	case len(r.remotes) == 0 && cfg.Policy == RemoteAny:
+		return nil, errors.Join(failures...)
+	case len(r.remotes) == 0:
+		log.Infof("Warning: no remote source answered, so none is mixed in")
+		return nil, nil
+	}
+
+	key := h.Sum(nil)
+	stream, err := chacha20.NewUnauthenticatedCipher(key, make([]byte, chacha20.NonceSize))
+	if err != nil {
+		return nil, err
+	}
+	r.stream = stream
+	r.state = sha256.Sum256(key)
+	return r, nil
+}
+
+// Name implements RNG
+func (r *RemoteRNG) Name() string {
+	return "remote"
+}
+
+// Remote implements RemoteSource, listing the backends mixed in
+func (r *RemoteRNG) Remote() string {
+	return strings.Join(r.remotes, ", ")
+}
+
+// Fetches returns the responses mixed in
+func (r *RemoteRNG) Fetches() []RemoteFetch {
+	return r.fetches
+}
+
+// StateHash implements StateHasher with the SHA-256 of the keystream's key,
+// which anyone holding the responses, such as the public rounds of a beacon,
+// can recompute
+func (r *RemoteRNG) StateHash() []byte {
+	return r.state[:]
+}
+
+// Read implements RNG with the keystream seeded by the remote backends
+func (r *RemoteRNG) Read(ctx context.Context, p []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	clear(p)
+	r.stream.XORKeyStream(p, p)
+	return nil
+}
+
+// pinnedClient returns an HTTP client that, beyond the usual verification of
+// certificates, requires the hosts with pins to present one of the public
+// keys pinned for them
+func pinnedClient(pins map[string][]string) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			want, ok := pins[cs.ServerName]
+			if !ok {
+				return nil
+			}
+			for _, cert := range cs.PeerCertificates {
+				if containsPin(want, cert) {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s presented none of the public keys pinned for it", cs.ServerName)
+		},
+	}
+	return &http.Client{Transport: transport}
+}
+
+// containsPin reports whether the public key of a certificate is among pins
+func containsPin(pins []string, cert *x509.Certificate) bool {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	pin := "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+	for _, p := range pins {
+		if p == pin {
+			return true
+		}
+	}
+	return false
+}
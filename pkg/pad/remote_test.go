@@ -0,0 +1,121 @@
+package pad
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestRemoteRNG(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+
+	chain, signature := testDrandChain(t, 7)
+	randomness := sha256.Sum256(signature)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/anu", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"type":"uint8","length":4,"data":[1,2,3,250],"success":true}`)
+	})
+	mux.HandleFunc("/drand", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"round":7,"randomness":"%x","signature":"%x"}`, randomness, signature)
+	})
+	mux.HandleFunc("/forged", func(w http.ResponseWriter, r *http.Request) {
+		forged := []byte("forged")
+		fmt.Fprintf(w, `{"round":7,"randomness":"%x","signature":"%x"}`, sha256.Sum256(forged), forged)
+	})
+	mux.HandleFunc("/custom", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data":{"value":"%s"}}`, base64.StdEncoding.EncodeToString([]byte("custom random bytes")))
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	custom, err := ParseRemoteEntropy(server.URL + "/custom#data.value")
+	if err != nil {
+		t.Fatalf("ParseRemoteEntropy failed: %v", err)
+	}
+	data, err := custom.Fetch(ctx, server.Client())
+	if err != nil || string(data) != "custom random bytes" {
+		t.Fatalf("Fetch of a custom URL = %q, %v", data, err)
+	}
+	for _, spec := range []string{"http://example.com/random", "quantum"} {
+		if _, err := ParseRemoteEntropy(spec); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("ParseRemoteEntropy(%q) should fail, got %v", spec, err)
+		}
+	}
+
+	drand := &DrandEntropy{URL: server.URL + "/drand", Chain: chain}
+	good := []RemoteEntropy{&ANUEntropy{URL: server.URL + "/anu"}, drand, custom}
+	tests := []struct {
+		name     string
+		backends []RemoteEntropy
+		policy   RemotePolicy
+		mixed    int  // Backends mixed in, or -1 if the RNG fails
+		none     bool // No RNG is returned, as none answered
+	}{
+		{"All answer", good, RemoteAll, 3, false},
+		{"Forged drand round", append(good[:1:1], &DrandEntropy{URL: server.URL + "/forged", Chain: chain}), RemoteAll, -1, false},
+		{"Any, one down", append(good[:1:1], &NISTEntropy{URL: server.URL + "/down"}), RemoteAny, 1, false},
+		{"Any, all down", []RemoteEntropy{&NISTEntropy{URL: server.URL + "/down"}}, RemoteAny, -1, false},
+		{"Optional, all down", []RemoteEntropy{&NISTEntropy{URL: server.URL + "/down"}}, RemoteOptional, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng, err := NewRemoteRNG(ctx, RemoteConfig{Backends: tt.backends, Policy: tt.policy, Client: server.Client()})
+			switch {
+			case tt.mixed < 0:
+				if err == nil {
+					t.Fatalf("Expected NewRemoteRNG to fail")
+				}
+				return
+			case err != nil:
+				t.Fatalf("NewRemoteRNG failed: %v", err)
+			case tt.none:
+				if rng != nil {
+					t.Fatalf("Expected no RNG when no backend answered")
+				}
+				return
+			}
+			if got := len(strings.Split(rng.Remote(), ", ")); got != tt.mixed {
+				t.Errorf("Expected %d backends mixed in, got %s", tt.mixed, rng.Remote())
+			}
+			a, b := make([]byte, 32), make([]byte, 32)
+			rng.Read(ctx, a)
+			rng.Read(ctx, b)
+			if hex.EncodeToString(a) == hex.EncodeToString(b) {
+				t.Errorf("Expected the keystream to advance")
+			}
+			if len(RemoteSources(&MultiRNG{Sources: []RNG{NewCryptoRand(), rng}})) != 1 {
+				t.Errorf("Expected the RNG to be reported as remote")
+			}
+		})
+	}
+
+	t.Run("Offline", func(t *testing.T) {
+		_, err := NewRemoteRNG(WithOffline(ctx, true), RemoteConfig{Backends: good, Client: server.Client()})
+		if !errors.Is(err, ErrOffline) {
+			t.Errorf("Expected ErrOffline, got %v", err)
+		}
+	})
+
+	t.Run("Pinned key", func(t *testing.T) {
+		cert := server.Certificate()
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		if !containsPin([]string{"sha256/" + base64.StdEncoding.EncodeToString(sum[:])}, cert) {
+			t.Errorf("Expected the server's key to match its pin")
+		}
+		if containsPin([]string{"sha256/" + base64.StdEncoding.EncodeToString(make([]byte, 32))}, cert) {
+			t.Errorf("Expected another key's pin not to match")
+		}
+	})
+}
@@ -0,0 +1,401 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// RepairReport records how Repair rewrote each chunk of a damaged collection
+type RepairReport struct {
+	Chunks       int      // Chunks in the collection
+	Repaired     []int    // Chunks that were missing or damaged, regenerated from the other collections
+	Unrepairable []int    // Chunks that were missing or damaged and could not be regenerated
+	Missing      []string // Collections not supplied, which the unrepairable chunks may need
+}
+
+// Err returns an error describing the chunks that could not be repaired, if there are any
+func (r *RepairReport) Err() error {
+	if len(r.Unrepairable) == 0 {
+		return nil
+	}
+	items := make([]string, len(r.Unrepairable))
+	for i, n := range r.Unrepairable {
+		items[i] = fmt.Sprintf("%d", n)
+	}
+	reason := "they are not intact in every other collection of the set"
+	if len(r.Missing) > 0 {
+		reason = fmt.Sprintf("they also need collection(s) %s", strings.Join(r.Missing, ", "))
+	}
+	return fmt.Errorf("%w: %d of %d chunks could not be repaired, as %s: %s",
+		ErrChunkCorrupt, len(r.Unrepairable), r.Chunks, reason, strings.Join(items, ", "))
+}
+
+// Repair rewrites a damaged collection, regenerating the chunks that are
+// missing from it or damaged from the same chunks of the other collections of
+// its set, without reencoding the set. A collection's piece of a permutation is
+// fixed by the data and the other pieces of that permutation, so a regenerated
+// chunk is the very chunk that was lost: no randomness is involved, and the
+// other collections, and any parity protecting this one, remain valid.
+//
+// Regenerating the collection's piece of a permutation takes the pieces of
+// every other collection in it, so a damaged chunk can only be repaired when
+// it is intact in every other collection of the set. The data is taken from
+// the permutations the damaged collection is not part of, grouped by what the
+// other collections show they reveal: all the same data, or with a decoy, one
+// of two. Which group stands in for each of the collection's own permutations
+// is the one whose data its pieces reveal most of across its chunks, which
+// they still do where damaged. Every piece of every chunk is checked against
+// its stand-in, so that a chunk whose payload has been altered is regenerated
+// too; only its pieces shared with collections that were supplied can be checked.
+//
+// Chunks are written through newChunk, intact ones as they are. Those that
+// cannot be repaired are not written, and are listed in the report; callers
+// should check report.Err() once the returned error is nil.
+func (p *Pad) Repair(ctx context.Context, target ChunkSource, others []ChunkSource, newChunk NewChunkFunc, chunkFormat string) (*RepairReport, error) {
+	log := trace.FromContext(ctx).WithPrefix("REPAIR")
+	report := &RepairReport{}
+
+	requiredCopies, totalCopies, targetLetter, err := extractFromCollectionLabel(target.Name())
+	if err != nil {
+		return report, err
+	}
+	if p, err = p.forScheme(ctx, totalCopies, requiredCopies); err != nil {
+		return report, err
+	}
+
+	// Use each other collection of the set once
+	sources := make(map[string]ChunkSource)
+	for _, o := range others {
+		k, n, letter, err := extractFromCollectionLabel(o.Name())
+		if err != nil || k != requiredCopies || n != totalCopies {
+			log.Infof("Warning: collection %s is not part of the %d-of-%d set of %s; ignoring it", o.Name(), requiredCopies, totalCopies, target.Name())
+			continue
+		}
+		if letter == targetLetter {
+			continue
+		}
+		if _, ok := sources[letter]; ok {
+			log.Infof("Warning: collection %s was supplied more than once; using one copy", o.Name())
+			continue
+		}
+		sources[letter] = o
+	}
+	if len(sources) < requiredCopies {
+		return report, fmt.Errorf("%w: repairing collection %s takes at least %d other collections of its set, got %d",
+			ErrInsufficientCollections, target.Name(), requiredCopies, len(sources))
+	}
+
+	lastChunk := target.LastChunk()
+	for _, s := range sources {
+		lastChunk = max(lastChunk, s.LastChunk())
+	}
+	report.Chunks = lastChunk
+
+	// The permutations of the target, and those it is not part of
+	var own, foreign []string
+	paddecode.ForEachPermutation(p.RequiredCopies, p.TotalCopies, func(perm string) error {
+		if strings.Contains(perm, targetLetter) {
+			own = append(own, perm)
+		} else {
+			foreign = append(foreign, perm)
+		}
+		return nil
+	})
+
+	// intactPayload returns the payload of a chunk read from a collection, if
+	// its header is that of the chunk and the payload is complete
+	intactPayload := func(name string, data []byte, n int) ([]byte, ChunkInfo, bool) {
+		info, err := InspectChunk(data)
+		if err != nil || info.Collection != name || info.Number != n || info.PayloadBytes != info.DataBytes*p.PermutationCount {
+			return nil, ChunkInfo{}, false
+		}
+		return data[len(data)-info.PayloadBytes:], info, true
+	}
+
+	// Regenerated chunks carry the session of the set, in the header format
+	// of its other collections
+	var session *ChunkInfo
+
+	// readChunk reads chunk n of the target and the payloads of the other
+	// collections holding it intact, at the size most of them agree on
+	readChunk := func(n int) (data []byte, payloads map[string][]byte, dataBytes int) {
+		payloads = make(map[string][]byte)
+		sizes := make(map[int]int)
+		for letter, s := range sources {
+			chunk, err := s.ReadChunk(ctx, n)
+			if err != nil {
+				continue
+			}
+			if payload, info, ok := intactPayload(s.Name(), chunk, n); ok {
+				payloads[letter] = payload
+				sizes[info.DataBytes]++
+				if session == nil {
+					session = &info
+				}
+			}
+		}
+		for size, count := range sizes {
+			if count > sizes[dataBytes] || (count == sizes[dataBytes] && size > dataBytes) {
+				dataBytes = size
+			}
+		}
+		for letter, payload := range payloads {
+			if len(payload) != dataBytes*p.PermutationCount {
+				delete(payloads, letter)
+			}
+		}
+		data, _ = target.ReadChunk(ctx, n)
+		return data, payloads, dataBytes
+	}
+
+	// combine returns the data revealed by a permutation, or nil if one of its
+	// collections does not hold the chunk intact
+	combine := func(perm string, payloads map[string][]byte, dataBytes int) []byte {
+		result := make([]byte, dataBytes)
+		for i := 0; i < len(perm); i++ {
+			letter := perm[i : i+1]
+			payload, ok := payloads[letter]
+			if !ok {
+				return nil
+			}
+			offset, _ := p.pieceOffset(letter, perm, dataBytes)
+			for j := range result {
+				result[j] ^= payload[offset+j]
+			}
+		}
+		return result
+	}
+
+	// Compare what the permutations reveal in every chunk: those without the
+	// target against each other, and the target's own against them, where the
+	// data is not zero, since the padding of the two inputs of a decoy agrees
+	type pair struct{ a, b string }
+	compared := make(map[pair]bool)
+	differ := make(map[pair]bool)
+	agree := make(map[pair]*[2]int64) // Bytes equal and bytes compared
+	for n := 1; n <= lastChunk; n++ {
+		data, payloads, dataBytes := readChunk(n)
+		if dataBytes == 0 {
+			continue
+		}
+		revealed := make(map[string][]byte)
+		for _, f := range foreign {
+			if d := combine(f, payloads, dataBytes); d != nil {
+				revealed[f] = d
+			}
+		}
+		for f, d := range revealed {
+			for g, e := range revealed {
+				compared[pair{f, g}] = true
+				if !bytes.Equal(d, e) {
+					differ[pair{f, g}] = true
+				}
+			}
+		}
+		if payload, info, ok := intactPayload(target.Name(), data, n); ok && info.DataBytes == dataBytes {
+			payloads[targetLetter] = payload
+			for _, perm := range own {
+				d := combine(perm, payloads, dataBytes)
+				if d == nil {
+					continue
+				}
+				for f, e := range revealed {
+					count := agree[pair{perm, f}]
+					if count == nil {
+						count = &[2]int64{}
+						agree[pair{perm, f}] = count
+					}
+					for i := range d {
+						if e[i] != 0 {
+							count[1]++
+							if d[i] == e[i] {
+								count[0]++
+							}
+						}
+					}
+				}
+				Zeroize(d)
+			}
+		}
+		for _, d := range revealed {
+			Zeroize(d)
+		}
+	}
+
+	// Group the permutations without the target that reveal the same data
+	// wherever the other collections hold them both: all of them, unless the
+	// set has a decoy
+	var groups [][]string
+	for _, f := range foreign {
+		joined := false
+		for i, group := range groups {
+			same := true
+			for _, g := range group {
+				same = same && compared[pair{f, g}] && !differ[pair{f, g}]
+			}
+			if same {
+				groups[i] = append(group, f)
+				joined = true
+				break
+			}
+		}
+		if !joined {
+			groups = append(groups, []string{f})
+		}
+	}
+
+	// Each permutation of the target stands in for the group whose data its
+	// pieces reveal most of, which they still do where damaged. Unrelated data
+	// agrees on one byte in 256, so pieces agreeing on fewer than one in 16,
+	// such as those revealing the only input of a decoy set that the other
+	// collections do not, have no stand-in and are left as they are.
+	standIn := make(map[string][]string)
+	for _, perm := range own {
+		best := 0.0
+		for _, group := range groups {
+			var equal, total int64
+			for _, f := range group {
+				if count := agree[pair{perm, f}]; count != nil {
+					equal, total = equal+count[0], total+count[1]
+				}
+			}
+			if total > 0 && float64(equal)/float64(total) > best {
+				best = float64(equal) / float64(total)
+				if best >= 1.0/16 {
+					standIn[perm] = group
+				}
+			}
+		}
+	}
+	if len(standIn) == 0 {
+		return report, fmt.Errorf("%w: collection %s holds no chunk intact alongside the other collections to check a repair against; replace it with a reshare instead",
+			ErrChunkCorrupt, target.Name())
+	}
+
+	// reveal returns the data revealed by the first of a group of permutations
+	// whose collections hold the chunk intact, or nil if none of them do
+	reveal := func(group []string, payloads map[string][]byte, dataBytes int) []byte {
+		for _, f := range group {
+			if d := combine(f, payloads, dataBytes); d != nil {
+				return d
+			}
+		}
+		return nil
+	}
+
+	// regenerate returns the payload of a chunk of the target, each piece the
+	// data of its permutation's stand-in XORed with the permutation's other
+	// pieces, or nil if a piece cannot be regenerated
+	regenerate := func(payloads map[string][]byte, dataBytes int) []byte {
+		payload := make([]byte, dataBytes*p.PermutationCount)
+		for _, perm := range own {
+			group, ok := standIn[perm]
+			if !ok {
+				return nil
+			}
+			piece := reveal(group, payloads, dataBytes)
+			if piece == nil {
+				return nil
+			}
+			for i := 0; i < len(perm); i++ {
+				letter := perm[i : i+1]
+				if letter == targetLetter {
+					continue
+				}
+				other, ok := payloads[letter]
+				if !ok {
+					Zeroize(piece)
+					return nil
+				}
+				offset, _ := p.pieceOffset(letter, perm, dataBytes)
+				for j := range piece {
+					piece[j] ^= other[offset+j]
+				}
+			}
+			offset, _ := p.pieceOffset(targetLetter, perm, dataBytes)
+			copy(payload[offset:], piece)
+			Zeroize(piece)
+		}
+		return payload
+	}
+
+	for n := 1; n <= lastChunk; n++ {
+		data, payloads, dataBytes := readChunk(n)
+		if dataBytes == 0 {
+			log.Debugf("Chunk %d: not intact in any other collection", n)
+			report.Unrepairable = append(report.Unrepairable, n)
+			continue
+		}
+
+		// An intact chunk is kept if its pieces agree with the other collections
+		intact := false
+		if payload, info, ok := intactPayload(target.Name(), data, n); ok && info.DataBytes == dataBytes {
+			intact = true
+			payloads[targetLetter] = payload
+			for _, perm := range own {
+				group, ok := standIn[perm]
+				if !ok {
+					continue
+				}
+				d, e := combine(perm, payloads, dataBytes), reveal(group, payloads, dataBytes)
+				if d != nil && e != nil && !bytes.Equal(d, e) {
+					intact = false
+				}
+				Zeroize(d)
+				Zeroize(e)
+			}
+			delete(payloads, targetLetter)
+		}
+
+		chunk := data
+		if !intact {
+			payload := regenerate(payloads, dataBytes)
+			if payload == nil {
+				log.Debugf("Chunk %d: missing or damaged, and cannot be regenerated", n)
+				report.Unrepairable = append(report.Unrepairable, n)
+				continue
+			}
+			log.Debugf("Chunk %d: missing or damaged, regenerated", n)
+			header := chunkHeader{collName: target.Name(), number: n, dataBytes: dataBytes, session: session.Session}
+			if session.Legacy {
+				header.version = legacyVersion
+			}
+			raw, err := header.marshal()
+			if err != nil {
+				return report, err
+			}
+			chunk = append(raw, payload...)
+			report.Repaired = append(report.Repaired, n)
+		}
+
+		w, err := newChunk(target.Name(), n, chunkFormat)
+		if err != nil {
+			return report, fmt.Errorf("failed to create chunk writer for collection %s: %w", target.Name(), err)
+		}
+		_, err = w.Write(chunk)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to write chunk %d of collection %s: %w", n, target.Name(), err)
+		}
+	}
+
+	// Name the collections that were not supplied, which may be what the
+	// unrepairable chunks lack
+	if len(report.Unrepairable) > 0 {
+		for i := 0; i < totalCopies; i++ {
+			letter := collectionLetterFromIndex(i)
+			if _, ok := sources[letter]; !ok && letter != targetLetter {
+				report.Missing = append(report.Missing, buildCollectionLabel(requiredCopies, totalCopies, letter))
+			}
+		}
+	}
+	log.Debugf("Repaired %d of %d chunks of collection %s", len(report.Repaired), lastChunk, target.Name())
+	return report, nil
+}
@@ -0,0 +1,199 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// TestRepair tests that the missing and damaged chunks of a collection are
+// regenerated exactly from the others
+func TestRepair(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	input := make([]byte, 10000)
+	decoy := make([]byte, 3000)
+	for _, b := range [][]byte{input, decoy} {
+		if _, err := rand.Read(b); err != nil {
+			t.Fatalf("Failed to generate input: %v", err)
+		}
+	}
+
+	// encode encodes a 2-of-4 set in memory, one buffer per chunk, with a decoy
+	// revealed by the collections named if one is given
+	encode := func(decoy []byte, decoyLetters string, chunkBytes int) map[string]map[int][]byte {
+		encoded := make(map[string]map[int]*bytes.Buffer)
+		newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+			if encoded[collectionName] == nil {
+				encoded[collectionName] = make(map[int]*bytes.Buffer)
+			}
+			encoded[collectionName][chunkNumber] = &bytes.Buffer{}
+			return nopWriteCloser{encoded[collectionName][chunkNumber]}, nil
+		}
+		p, err := NewPadForEncode(ctx, 4, 2)
+		if err != nil {
+			t.Fatalf("NewPadForEncode failed: %v", err)
+		}
+		if decoy != nil {
+			err = p.EncodeDual(ctx, chunkBytes, bytes.NewReader(input), bytes.NewReader(decoy), decoyLetters, NewDefaultRand(ctx), newChunk, "bin")
+		} else {
+			err = p.Encode(ctx, chunkBytes, bytes.NewReader(input), NewDefaultRand(ctx), newChunk, "bin")
+		}
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		result := make(map[string]map[int][]byte)
+		for name, chunks := range encoded {
+			result[name] = make(map[int][]byte)
+			for n, buf := range chunks {
+				result[name][n] = buf.Bytes()
+			}
+		}
+		return result
+	}
+
+	// damage returns a copy of a collection missing chunk 2, with a byte of the
+	// payload of chunk 3 flipped and chunk 4 truncated
+	damage := func(chunks map[int][]byte) *memorySource {
+		s := &memorySource{name: "2B4", chunks: make(map[int][]byte)}
+		for n, data := range chunks {
+			s.chunks[n] = bytes.Clone(data)
+		}
+		delete(s.chunks, 2)
+		s.chunks[3][len(s.chunks[3])-1] ^= 0x01
+		s.chunks[4] = s.chunks[4][:len(s.chunks[4])-10]
+		return s
+	}
+
+	sourcesOf := func(set map[string]map[int][]byte, names ...string) []ChunkSource {
+		var result []ChunkSource
+		for _, name := range names {
+			result = append(result, &memorySource{name: name, chunks: set[name]})
+		}
+		return result
+	}
+
+	repair := func(target ChunkSource, others []ChunkSource) (map[int][]byte, *RepairReport, error) {
+		repaired := make(map[int]*bytes.Buffer)
+		newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+			if collectionName != target.Name() {
+				t.Fatalf("Repair wrote a chunk of collection %s", collectionName)
+			}
+			repaired[chunkNumber] = &bytes.Buffer{}
+			return nopWriteCloser{repaired[chunkNumber]}, nil
+		}
+		p, err := NewPadForDecode(ctx, len(others)+1)
+		if err != nil {
+			t.Fatalf("NewPadForDecode failed: %v", err)
+		}
+		report, err := p.Repair(ctx, target, others, newChunk, "bin")
+		result := make(map[int][]byte)
+		for n, buf := range repaired {
+			result[n] = buf.Bytes()
+		}
+		return result, report, err
+	}
+
+	for _, withDecoy := range []bool{false, true} {
+		name := "Plain"
+		var d []byte
+		if withDecoy {
+			name, d = "Decoy", decoy
+		}
+		t.Run(name, func(t *testing.T) {
+			set := encode(d, "D", 3000)
+			if len(set["2B4"]) < 5 {
+				t.Fatalf("Expected at least 5 chunks, got %d", len(set["2B4"]))
+			}
+
+			// Every other collection regenerates the lost chunks exactly
+			repaired, report, err := repair(damage(set["2B4"]), sourcesOf(set, "2A4", "2C4", "2D4"))
+			if err != nil || report.Err() != nil {
+				t.Fatalf("Repair failed: %v %v", err, report.Err())
+			}
+			if !reflect.DeepEqual(report.Repaired, []int{2, 3, 4}) {
+				t.Errorf("Expected chunks 2, 3 and 4 to be repaired, got %v", report.Repaired)
+			}
+			if !reflect.DeepEqual(repaired, set["2B4"]) {
+				t.Errorf("Repaired collection differs from the original")
+			}
+
+			// Without one of the others, the lost chunks cannot be regenerated. The
+			// flipped byte of chunk 3, in its piece shared with 2D4, goes unnoticed.
+			repaired, report, err = repair(damage(set["2B4"]), sourcesOf(set, "2A4", "2C4"))
+			if err != nil {
+				t.Fatalf("Repair failed: %v", err)
+			}
+			if !errors.Is(report.Err(), ErrChunkCorrupt) || !reflect.DeepEqual(report.Unrepairable, []int{2, 4}) || !reflect.DeepEqual(report.Missing, []string{"2D4"}) {
+				t.Errorf("Expected chunks 2 and 4 to need collection 2D4, got %v %v", report.Unrepairable, report.Missing)
+			}
+			if _, ok := repaired[2]; ok || !bytes.Equal(repaired[1], set["2B4"][1]) {
+				t.Errorf("Expected only the intact chunks to be written")
+			}
+		})
+	}
+
+	// A collection of a single chunk, with part of its payload altered, is
+	// checked against the others and regenerated
+	for _, withDecoy := range []bool{false, true} {
+		name := "Plain single chunk"
+		var d []byte
+		if withDecoy {
+			name, d = "Decoy single chunk", decoy
+		}
+		t.Run(name, func(t *testing.T) {
+			set := encode(d, "D", 100000)
+			if len(set["2C4"]) != 1 {
+				t.Fatalf("Expected a single chunk, got %d", len(set["2C4"]))
+			}
+			damaged := &memorySource{name: "2C4", chunks: map[int][]byte{1: bytes.Clone(set["2C4"][1])}}
+			middle := len(damaged.chunks[1]) / 2
+			for i := middle; i < middle+1000; i++ {
+				damaged.chunks[1][i] ^= 0xff
+			}
+			repaired, report, err := repair(damaged, sourcesOf(set, "2A4", "2B4", "2D4"))
+			if err != nil || report.Err() != nil {
+				t.Fatalf("Repair failed: %v %v", err, report.Err())
+			}
+			if !reflect.DeepEqual(report.Repaired, []int{1}) {
+				t.Errorf("Expected chunk 1 to be repaired, got %v", report.Repaired)
+			}
+			if !bytes.Equal(repaired[1], set["2C4"][1]) {
+				t.Errorf("Repaired chunk differs from the original")
+			}
+		})
+	}
+
+	// With decoy collections C and D, the others only reveal the decoy along
+	// with A, and the piece of A revealing the input with B is left as it is
+	t.Run("Input revealed by no other collection", func(t *testing.T) {
+		set := encode(decoy, "CD", 100000)
+		repaired, report, err := repair(&memorySource{name: "2A4", chunks: set["2A4"]}, sourcesOf(set, "2B4", "2C4", "2D4"))
+		if err != nil || report.Err() != nil {
+			t.Fatalf("Repair failed: %v %v", err, report.Err())
+		}
+		if len(report.Repaired) != 0 || !bytes.Equal(repaired[1], set["2A4"][1]) {
+			t.Errorf("Expected collection 2A4 to be left as it is, got chunks %v repaired", report.Repaired)
+		}
+	})
+
+	set := encode(nil, "", 3000)
+	t.Run("Too few collections", func(t *testing.T) {
+		if _, _, err := repair(damage(set["2B4"]), sourcesOf(set, "2A4")); !errors.Is(err, ErrInsufficientCollections) {
+			t.Errorf("Expected ErrInsufficientCollections, got %v", err)
+		}
+	})
+	t.Run("Nothing intact", func(t *testing.T) {
+		if _, _, err := repair(&memorySource{name: "2B4", chunks: map[int][]byte{}}, sourcesOf(set, "2A4", "2C4", "2D4")); !errors.Is(err, ErrChunkCorrupt) {
+			t.Errorf("Expected ErrChunkCorrupt for a collection with no intact chunk, got %v", err)
+		}
+	})
+}
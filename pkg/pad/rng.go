@@ -7,7 +7,6 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"sync"
 
 	"github.com/rayozzie/padlock/pkg/trace"
 )
@@ -35,6 +34,9 @@ func IsQuantumEnabled(ctx context.Context) bool {
 // - Generate non-repeating sequences across multiple calls
 // - Return errors rather than providing low-quality randomness
 // - Use the context for logging and potential cancellation
+// - Be safe for concurrent use, as one RNG may serve several encodes at once
+//
+// The RNGs of this package are safe for concurrent use, except TestRNG.
 //
 // Security note: One-time pad encryption is only as secure as its random number
 // generator. If the RNG is compromised, the entire security model fails.
@@ -58,7 +60,7 @@ type RNG interface {
 // - Security depends only on the strongest available source
 // - Compromising all but one source still leaves the system secure
 // - XOR mixing preserves the statistical properties of the best source
-// - Protected against concurrent access with internal locking
+// - Safe for concurrent use, without a lock of its own, as each source guards its own state
 // - Continues to function even if some sources fail (with error propagation)
 //
 // Cryptographic principle:
@@ -88,17 +90,15 @@ type RNG interface {
 // - Combines all outputs through byte-by-byte XOR operations
 // - Propagates errors if any source fails to provide randomness
 // - Provides detailed logging with context awareness
-// - Uses mutex locks to ensure thread safety in concurrent environments
-// - Each source has its own internal state management
+// - Each source has its own internal state management and lock, so concurrent
+//   reads proceed through the sources in parallel rather than one at a time
 //
 // Usage context:
 // This is the recommended RNG implementation for production use,
 // obtained through the NewDefaultRand() function.
 type MultiRNG struct {
-	// Sources is a slice of RNG implementations to combine
+	// Sources is a slice of RNG implementations to combine, each safe for concurrent use
 	Sources []RNG
-	// lock protects against concurrent access
-	lock sync.Mutex
 }
 
 // Name
@@ -111,9 +111,6 @@ func (m *MultiRNG) Name() string {
 func (m *MultiRNG) Read(ctx context.Context, p []byte) error {
 	log := trace.FromContext(ctx).WithPrefix("MULTI-RNG")
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
 	// Initialize accumulator
 	acc := make([]byte, len(p))
 
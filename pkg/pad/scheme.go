@@ -0,0 +1,111 @@
+package pad
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+)
+
+// Scheme describes how a K-of-N set is laid out, for audits and independent
+// implementations of the decoder. Each group is a K-letter combination of the
+// collections, which together reveal the data by XORing their pieces of it.
+//
+// A chunk of a collection is a header of HeaderBytes, laid out as described in
+// paddecode, then one piece of data bytes for each group the collection is
+// part of, in the order of its Groups: piece i starts i*dataBytes after the
+// header. Within a group, the
+// first collection's piece is the data XORed with the pieces of the others,
+// which are random pads. An encode with a decoy writes the decoy in place of
+// the data in the groups that include a decoy collection.
+type Scheme struct {
+	Required    int                `json:"required"`    // K, the collections needed to decode
+	Copies      int                `json:"copies"`      // N, the collections in the set
+	Pieces      int                `json:"pieces"`      // Pieces in each chunk of a collection, C(N-1, K-1)
+	HeaderBytes int                `json:"headerBytes"` // Bytes of the header that begins each chunk
+	Groups      []SchemeGroup      `json:"groups"`      // Every K-letter combination, in alphabetical order
+	Collections []SchemeCollection `json:"collections"` // The collections, in order of their letters
+}
+
+// SchemeGroup is one XOR group of a scheme
+type SchemeGroup struct {
+	Letters    string `json:"letters"`    // The letters of its collections, e.g. "ACE"
+	Ciphertext string `json:"ciphertext"` // The letter whose piece is the data XORed with the pads of the others
+	Pieces     []int  `json:"pieces"`     // The index of the group's piece in each collection's chunk, in order of Letters
+}
+
+// SchemeCollection is one collection of a scheme
+type SchemeCollection struct {
+	Name   string   `json:"name"`   // The collection's name, e.g. "3A5"
+	Letter string   `json:"letter"` // The collection's letter, e.g. "A"
+	Groups []string `json:"groups"` // The groups of its pieces, in the order they follow the chunk header
+}
+
+// CheckParameters checks that the N and K of a set are in range
+func CheckParameters(totalCopies, requiredCopies int) error {
+	if totalCopies < 2 || totalCopies > 26 {
+		return fmt.Errorf("%w: totalCopies must be between 2 and 26, got %d", ErrInvalidParameters, totalCopies)
+	}
+	if requiredCopies < 2 {
+		return fmt.Errorf("%w: requiredCopies must be at least 2, got %d", ErrInvalidParameters, requiredCopies)
+	}
+	if requiredCopies > totalCopies {
+		return fmt.Errorf("%w: requiredCopies cannot be greater than totalCopies, got %d > %d", ErrInvalidParameters, requiredCopies, totalCopies)
+	}
+	return nil
+}
+
+// DescribeScheme returns the groups and chunk layout of a K-of-N set, as
+// Encode writes them
+func DescribeScheme(totalCopies, requiredCopies int) (Scheme, error) {
+	if err := CheckParameters(totalCopies, requiredCopies); err != nil {
+		return Scheme{}, err
+	}
+
+	permutations := paddecode.Permutations(requiredCopies, totalCopies)
+	s := Scheme{Required: requiredCopies, Copies: totalCopies, Pieces: paddecode.PiecesPerCollection(requiredCopies, totalCopies), HeaderBytes: headerBytes}
+	for i := 0; i < totalCopies; i++ {
+		letter := collectionLetterFromIndex(i)
+		s.Collections = append(s.Collections, SchemeCollection{
+			Name:   buildCollectionLabel(requiredCopies, totalCopies, letter),
+			Letter: letter,
+			Groups: permutations[letter],
+		})
+	}
+
+	paddecode.ForEachPermutation(requiredCopies, totalCopies, func(perm string) error {
+		g := SchemeGroup{Letters: perm, Ciphertext: perm[:1]}
+		for i := 0; i < len(perm); i++ {
+			j, _ := paddecode.PieceIndex(perm, perm[i:i+1], totalCopies)
+			g.Pieces = append(g.Pieces, j)
+		}
+		s.Groups = append(s.Groups, g)
+		return nil
+	})
+	return s, nil
+}
+
+// DecodingGroup returns the group that Decode uses for a subset of the
+// collections given by their letters, e.g. "EBD": that of the first K letters
+// in alphabetical order. It fails if the subset holds fewer than K distinct
+// collections of the set.
+func (s Scheme) DecodingGroup(subset string) (string, error) {
+	seen := make(map[string]bool)
+	var letters []string
+	for _, r := range strings.ToUpper(subset) {
+		letter := string(r)
+		if r < 'A' || int(r-'A') >= s.Copies {
+			return "", fmt.Errorf("%w: %q is not a collection of a %d-of-%d set", ErrInvalidParameters, letter, s.Required, s.Copies)
+		}
+		if !seen[letter] {
+			seen[letter] = true
+			letters = append(letters, letter)
+		}
+	}
+	if len(letters) < s.Required {
+		return "", fmt.Errorf("%w: %d of the %d required", ErrInsufficientCollections, len(letters), s.Required)
+	}
+	sort.Strings(letters)
+	return strings.Join(letters[:s.Required], ""), nil
+}
@@ -0,0 +1,125 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// TestDescribeScheme tests that the described layout is the one Encode writes
+func TestDescribeScheme(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	s, err := DescribeScheme(5, 3)
+	if err != nil {
+		t.Fatalf("DescribeScheme failed: %v", err)
+	}
+	if s.Pieces != 6 || len(s.Groups) != 10 || len(s.Collections) != 5 {
+		t.Fatalf("Expected 10 groups of 6 pieces per collection, got %d groups, %d pieces", len(s.Groups), s.Pieces)
+	}
+	if g := s.Groups[0]; g.Letters != "ABC" || g.Ciphertext != "A" || !reflect.DeepEqual(g.Pieces, []int{0, 0, 0}) {
+		t.Errorf("Unexpected first group %+v", g)
+	}
+	if g := s.Groups[9]; g.Letters != "CDE" || !reflect.DeepEqual(g.Pieces, []int{5, 5, 5}) {
+		t.Errorf("Unexpected last group %+v", g)
+	}
+	if c := s.Collections[1]; c.Name != "3B5" || !reflect.DeepEqual(c.Groups, []string{"ABC", "ABD", "ABE", "BCD", "BCE", "BDE"}) {
+		t.Errorf("Unexpected collection %+v", c)
+	}
+
+	t.Run("Matches encode", func(t *testing.T) {
+		input := make([]byte, 500)
+		if _, err := rand.Read(input); err != nil {
+			t.Fatalf("Failed to generate input: %v", err)
+		}
+		chunks := make(map[string]*bytes.Buffer)
+		newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+			chunks[collectionName] = &bytes.Buffer{}
+			return nopWriteCloser{chunks[collectionName]}, nil
+		}
+		p, err := NewPadForEncode(ctx, 5, 3)
+		if err != nil {
+			t.Fatalf("NewPadForEncode failed: %v", err)
+		}
+		if err := p.Encode(ctx, len(input)*s.Pieces, bytes.NewReader(input), NewDefaultRand(ctx), newChunk, "bin"); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+
+		// Every group, XORing the pieces the scheme places, reveals the input
+		for _, g := range s.Groups {
+			data := make([]byte, len(input))
+			for i := 0; i < len(g.Letters); i++ {
+				coll := s.Collections[g.Letters[i]-'A']
+				chunk := chunks[coll.Name].Bytes()
+				header, err := parseChunkHeader(chunk[:headerBytes])
+				if err != nil || header.collName != coll.Name || header.number != 1 || header.dataBytes != len(input) {
+					t.Fatalf("Chunk of %s does not begin with its header: %+v, %v", coll.Name, header, err)
+				}
+				piece := chunk[headerBytes+g.Pieces[i]*len(input):]
+				for j := range data {
+					data[j] ^= piece[j]
+				}
+			}
+			if !bytes.Equal(data, input) {
+				t.Errorf("Group %s does not reveal the input", g.Letters)
+			}
+		}
+	})
+
+	t.Run("Decoding group", func(t *testing.T) {
+		for subset, want := range map[string]string{"EBD": "BDE", "abcde": "ABC", "CEDC": "CDE"} {
+			if got, err := s.DecodingGroup(subset); err != nil || got != want {
+				t.Errorf("DecodingGroup(%q) = %q, %v, want %q", subset, got, err, want)
+			}
+		}
+		if _, err := s.DecodingGroup("ABB"); !errors.Is(err, ErrInsufficientCollections) {
+			t.Errorf("Expected ErrInsufficientCollections for two collections, got %v", err)
+		}
+		if _, err := s.DecodingGroup("ABF"); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("Expected ErrInvalidParameters for a letter outside the set, got %v", err)
+		}
+	})
+
+	t.Run("Invalid parameters", func(t *testing.T) {
+		for _, kn := range [][2]int{{1, 3}, {4, 3}, {2, 27}} {
+			if _, err := DescribeScheme(kn[1], kn[0]); !errors.Is(err, ErrInvalidParameters) {
+				t.Errorf("Expected ErrInvalidParameters for %d-of-%d, got %v", kn[0], kn[1], err)
+			}
+			if err := CheckParameters(kn[1], kn[0]); !errors.Is(err, ErrInvalidParameters) {
+				t.Errorf("Expected CheckParameters to reject %d-of-%d, got %v", kn[0], kn[1], err)
+			}
+		}
+	})
+
+	t.Run("Piece count", func(t *testing.T) {
+		for n := 2; n <= 9; n++ {
+			for k := 2; k <= n; k++ {
+				p, err := NewPadForEncode(ctx, n, k)
+				if err != nil {
+					t.Fatalf("NewPadForEncode failed: %v", err)
+				}
+				if got := PiecesPerCollection(k, n); got != p.PermutationCount {
+					t.Errorf("PiecesPerCollection(%d, %d) = %d, want %d", k, n, got, p.PermutationCount)
+				}
+			}
+		}
+
+		// The largest sets, too large to enumerate quickly, are computed
+		for _, tt := range []struct{ n, k, want int }{{26, 13, 5200300}, {26, 2, 25}, {26, 26, 1}, {26, 25, 25}} {
+			if got := PiecesPerCollection(tt.k, tt.n); got != tt.want {
+				t.Errorf("PiecesPerCollection(%d, %d) = %d, want %d", tt.k, tt.n, got, tt.want)
+			}
+		}
+		if got := MinChunkSize(26, 13, true); got != 5200300*(len(paddedMagic)+paddedCountBytes+1) {
+			t.Errorf("MinChunkSize(26, 13, true) = %d", got)
+		}
+	})
+}
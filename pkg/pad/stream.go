@@ -0,0 +1,87 @@
+package pad
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// DefaultChunkSize is the output chunk size used by the in-memory API, matching
+// the default chunk size of the command line tool
+const DefaultChunkSize = 2 * 1024 * 1024
+
+// EncodeToWriters splits the input across one writer per collection without
+// touching the filesystem, so that applications can embed the threshold scheme
+// and store or transmit the collections however they like.
+//
+// The number of collections (N) is the number of writers, and any requiredCopies
+// (K) of the resulting streams can reconstruct the input with DecodeFromReaders.
+// Each writer receives the concatenated chunks of one collection, exactly as they
+// would be read back from a collection directory; writers[i] holds the collection
+// named Collections[i] of the pad (e.g. "3A5", "3B5", ...). Chunks are of
+// DefaultChunkSize, and randomness comes from the default multi-source RNG.
+func EncodeToWriters(ctx context.Context, input io.Reader, writers []io.Writer, requiredCopies int) error {
+	return EncodeToWritersWithRNG(ctx, input, writers, requiredCopies, DefaultChunkSize, NewDefaultRand(ctx))
+}
+
+// EncodeToWritersWithRNG is EncodeToWriters with the output chunk size and the
+// source of randomness given, such as the RNG chosen for an encode, a seeded
+// one for reproducible output, or one recording its entropy
+func EncodeToWritersWithRNG(ctx context.Context, input io.Reader, writers []io.Writer, requiredCopies int, chunkSize int, randomSource RNG) error {
+	log := trace.FromContext(ctx).WithPrefix("ENCODE")
+
+	p, err := NewPadForEncode(ctx, len(writers), requiredCopies)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create pad: %w", err))
+		return fmt.Errorf("failed to create pad: %w", err)
+	}
+
+	byCollection := make(map[string]io.Writer, len(writers))
+	for i, collName := range p.Collections {
+		byCollection[collName] = writers[i]
+	}
+
+	newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		w, ok := byCollection[collectionName]
+		if !ok {
+			return nil, fmt.Errorf("no writer for collection %s", collectionName)
+		}
+		return nopWriteCloser{w}, nil
+	}
+
+	return p.Encode(ctx, chunkSize, input, randomSource, newChunk, "bin")
+}
+
+// DecodeFromReaders reconstructs the original data from the collection streams
+// produced by EncodeToWriters, without touching the filesystem. At least K of
+// the N streams must be provided, in any order.
+func DecodeFromReaders(ctx context.Context, readers []io.Reader, output io.Writer) error {
+	log := trace.FromContext(ctx).WithPrefix("DECODE")
+
+	p, err := NewPadForDecode(ctx, len(readers))
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create pad: %w", err))
+		return fmt.Errorf("failed to create pad: %w", err)
+	}
+
+	return p.Decode(ctx, readers, output)
+}
+
+// CollectionName returns the name of the collection at a 0-based index of a
+// K-of-N pad, which is also the name EncodeToWriters gives writers[index]
+func CollectionName(requiredCopies, totalCopies, index int) string {
+	return buildCollectionLabel(requiredCopies, totalCopies, collectionLetterFromIndex(index))
+}
+
+// nopWriteCloser adapts a writer shared by every chunk of a collection, which
+// must stay open after each chunk is closed
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,115 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// TestEncodeDecodeStreams tests a roundtrip through the in-memory API using every K-sized subset order
+func TestEncodeDecodeStreams(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Larger than one input chunk so that the streams hold several chunks
+	input := make([]byte, DefaultChunkSize/2)
+	if _, err := rand.Read(input); err != nil {
+		t.Fatalf("Failed to generate input: %v", err)
+	}
+
+	buffers := make([]bytes.Buffer, 4)
+	writers := make([]io.Writer, len(buffers))
+	for i := range buffers {
+		writers[i] = &buffers[i]
+	}
+	if err := EncodeToWriters(ctx, bytes.NewReader(input), writers, 2); err != nil {
+		t.Fatalf("EncodeToWriters failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		indexes []int
+		wantErr bool
+	}{
+		{"First two", []int{0, 1}, false},
+		{"Reversed", []int{3, 1}, false},
+		{"All four", []int{2, 0, 3, 1}, false},
+		{"Only one", []int{2}, true},
+		{"Duplicate", []int{0, 2, 0}, false},
+		{"Same one twice", []int{1, 1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readers := make([]io.Reader, len(tt.indexes))
+			for i, index := range tt.indexes {
+				readers[i] = bytes.NewReader(buffers[index].Bytes())
+			}
+
+			var output bytes.Buffer
+			err := DecodeFromReaders(ctx, readers, &output)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error decoding from %d stream(s)", len(readers))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeFromReaders failed: %v", err)
+			}
+			if !bytes.Equal(output.Bytes(), input) {
+				t.Errorf("Decoded %d bytes do not match the %d byte input", output.Len(), len(input))
+			}
+		})
+	}
+
+	// Fewer writers than the required copies is rejected
+	if err := EncodeToWriters(ctx, bytes.NewReader(input), writers[:2], 3); err == nil {
+		t.Errorf("Expected an error when required copies exceed the number of writers")
+	}
+}
+
+// TestEncodeToWritersWithRNG tests that the chunk size and RNG given are used,
+// so that a seeded RNG reproduces the streams exactly
+func TestEncodeToWritersWithRNG(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	input := bytes.Repeat([]byte("reproducible "), 1000)
+	encode := func() [][]byte {
+		buffers := make([]bytes.Buffer, 3)
+		writers := make([]io.Writer, len(buffers))
+		for i := range buffers {
+			writers[i] = &buffers[i]
+		}
+		if err := EncodeToWritersWithRNG(ctx, bytes.NewReader(input), writers, 2, 1024, NewTestRNG(7)); err != nil {
+			t.Fatalf("EncodeToWritersWithRNG failed: %v", err)
+		}
+		return [][]byte{buffers[0].Bytes(), buffers[1].Bytes(), buffers[2].Bytes()}
+	}
+	first, second := encode(), encode()
+	for i := range first {
+		if !bytes.Equal(first[i], second[i]) {
+			t.Errorf("Expected stream %d to be reproduced by the same seed", i)
+		}
+	}
+
+	info, err := InspectChunk(first[0])
+	if err != nil {
+		t.Fatalf("InspectChunk failed: %v", err)
+	}
+	if payload := info.ExpectedPayloadBytes(2, 3); payload > 1024 {
+		t.Errorf("Expected chunks of at most 1024 bytes, got %d", payload)
+	}
+
+	var output bytes.Buffer
+	if err := DecodeFromReaders(ctx, []io.Reader{bytes.NewReader(first[0]), bytes.NewReader(first[2])}, &output); err != nil || !bytes.Equal(output.Bytes(), input) {
+		t.Errorf("Expected the streams to decode to the input, got %d bytes, %v", output.Len(), err)
+	}
+}
@@ -12,7 +12,8 @@ import (
 //
 // The key property is that it will produce the exact same sequence of bytes
 // when created with the same initial counter value, which allows for
-// deterministic test behavior.
+// deterministic test behavior. Unlike the other RNGs, it is not safe for
+// concurrent use.
 type TestRNG struct {
 	// counter is a byte that increments with each byte generated
 	counter byte
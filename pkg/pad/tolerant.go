@@ -0,0 +1,442 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// ChunkSource gives access to the chunks of one collection by number, so that
+// a decode can skip a chunk that is missing or damaged in one collection and
+// take it from others instead
+type ChunkSource interface {
+	// Name returns the collection name (e.g., "3A5")
+	Name() string
+	// LastChunk returns the highest chunk number present in the collection
+	LastChunk() int
+	// ReadChunk returns a chunk, header included, or an error if it is missing
+	ReadChunk(ctx context.Context, chunkNumber int) ([]byte, error)
+}
+
+// DecodeReport records how DecodeTolerant recovered each chunk
+type DecodeReport struct {
+	Chunks        int              // Chunks decoded, including unrecoverable ones
+	Damaged       map[string][]int // Chunks of each collection that were missing or unusable
+	Fallbacks     map[int]string   // Chunks decoded with a different subset of collections than the rest, by permutation
+	Ignored       []string         // Collections not used at all, such as those of a different K-of-N set or encode
+	Duplicates    []string         // Collections supplied more than once, once for each extra identical copy
+	Unrecoverable []int            // Chunks held intact by fewer than K collections
+}
+
+// Err returns an error describing the unrecoverable chunks, if there are any
+func (r *DecodeReport) Err() error {
+	if len(r.Unrecoverable) == 0 {
+		return nil
+	}
+	items := make([]string, len(r.Unrecoverable))
+	for i, n := range r.Unrecoverable {
+		items[i] = fmt.Sprintf("%d", n)
+	}
+	return fmt.Errorf("%w: %d of %d chunks could not be recovered, as fewer than the required collections hold them intact: %s",
+		ErrChunkCorrupt, len(r.Unrecoverable), r.Chunks, strings.Join(items, ", "))
+}
+
+// DecodeTolerant reconstructs the data from K or more collections like Decode,
+// but chooses the collections to combine chunk by chunk. A chunk that is
+// missing, truncated or mislabeled in one collection is taken from any K others
+// that hold it intact, so the decode succeeds as long as every chunk survives
+// in K collections. Chunks that do not are written as zeros so that the rest of
+// the output keeps its place, and are listed in the report; callers should
+// check report.Err() once the returned error is nil.
+//
+// In strict mode, only recoveries that are verified are allowed: setting aside
+// a collection, choosing between collections that disagree on the size of a
+// chunk, or losing a chunk is an error rather than a judgement call.
+func (p *Pad) DecodeTolerant(ctx context.Context, sources []ChunkSource, output io.Writer, strict bool) (*DecodeReport, error) {
+	ctx, span := trace.StartSpan(ctx, "decode")
+	defer span.End()
+	metrics.activeCollections.Add(int64(len(sources)))
+	defer metrics.activeCollections.Add(-int64(len(sources)))
+	report, err := p.decodeTolerant(ctx, sources, output, strict)
+	return report, countFailure(err)
+}
+
+// decodeTolerant is DecodeTolerant, without counting its collections or failure
+func (p *Pad) decodeTolerant(ctx context.Context, sources []ChunkSource, output io.Writer, strict bool) (*DecodeReport, error) {
+	log := trace.FromContext(ctx).WithPrefix("DECODE")
+	report := &DecodeReport{Damaged: make(map[string][]int), Fallbacks: make(map[int]string)}
+	output = newUnpadWriter(output)
+
+	// The K-of-N set is the one named by most collections
+	type sourceInfo struct {
+		source ChunkSource
+		letter string
+	}
+	counts := make(map[[2]int]int)
+	var best [2]int
+	for _, source := range sources {
+		k, n, _, err := extractFromCollectionLabel(source.Name())
+		if err != nil {
+			continue
+		}
+		key := [2]int{k, n}
+		counts[key]++
+		if counts[key] > counts[best] || (counts[key] == counts[best] && (key[0] < best[0] || (key[0] == best[0] && key[1] < best[1]))) {
+			best = key
+		}
+	}
+	if counts[best] == 0 {
+		return report, fmt.Errorf("%w: no valid collections to decode", ErrInsufficientCollections)
+	}
+	p, err := p.forScheme(ctx, best[1], best[0])
+	if err != nil {
+		return report, err
+	}
+
+	// Use each collection letter once, in letter order
+	var usable []sourceInfo
+	for _, source := range sources {
+		k, n, letter, err := extractFromCollectionLabel(source.Name())
+		if err != nil || k != p.RequiredCopies || n != p.TotalCopies {
+			if strict {
+				return report, fmt.Errorf("%w: collection %s is not part of the %d-of-%d set being decoded", ErrSessionMismatch, source.Name(), p.RequiredCopies, p.TotalCopies)
+			}
+			report.Ignored = append(report.Ignored, source.Name())
+			continue
+		}
+		usable = append(usable, sourceInfo{source: source, letter: letter})
+	}
+	sort.SliceStable(usable, func(i, j int) bool {
+		return usable[i].letter < usable[j].letter
+	})
+
+	// Collections of one encode share the session ID in their chunk headers,
+	// and the encode decoded is the one most collection letters belong to
+	sessions := make([]uint64, len(usable))
+	known := make([]bool, len(usable))
+	letters := make(map[uint64]map[string]bool)
+	var session uint64
+	for i, u := range usable {
+		sessions[i], known[i] = sessionOf(ctx, u.source)
+		if !known[i] {
+			continue
+		}
+		if letters[sessions[i]] == nil {
+			letters[sessions[i]] = make(map[string]bool)
+		}
+		letters[sessions[i]][u.letter] = true
+		if len(letters[sessions[i]]) > len(letters[session]) {
+			session = sessions[i]
+		}
+	}
+	var inSession []sourceInfo
+	for i, u := range usable {
+		if known[i] && sessions[i] != session {
+			if strict {
+				return report, fmt.Errorf("%w: collection %s is from a different encode than the other collections", ErrSessionMismatch, u.source.Name())
+			}
+			report.Ignored = append(report.Ignored, u.source.Name())
+			continue
+		}
+		inSession = append(inSession, u)
+	}
+	usable = inSession
+
+	// Several copies of one collection may be supplied, such as the same letter
+	// in two zips. Identical copies back each other up chunk by chunk, while a
+	// copy from another encode cannot be combined with the rest.
+	var sameLetter []ChunkSource
+	var kept []sourceInfo
+	for i, u := range usable {
+		sameLetter = append(sameLetter, u.source)
+		if i+1 < len(usable) && usable[i+1].letter == u.letter {
+			continue
+		}
+		if len(sameLetter) > 1 {
+			var others []ChunkSource
+			for _, o := range usable {
+				if o.letter != u.letter {
+					others = append(others, o.source)
+				}
+			}
+			copies, err := p.sortOutCopies(ctx, sameLetter, others, report, strict)
+			if err != nil {
+				return report, err
+			}
+			for _, c := range copies {
+				kept = append(kept, sourceInfo{source: c, letter: u.letter})
+			}
+		} else {
+			kept = append(kept, u)
+		}
+		sameLetter = nil
+	}
+	usable = kept
+
+	distinct, lastChunk := 0, 0
+	for i, u := range usable {
+		if i == 0 || usable[i-1].letter != u.letter {
+			distinct++
+		}
+		lastChunk = max(lastChunk, u.source.LastChunk())
+	}
+	if distinct < p.RequiredCopies {
+		return report, fmt.Errorf("%w: %d of the %d required", ErrInsufficientCollections, distinct, p.RequiredCopies)
+	}
+	log.Debugf("Decoding %d chunks from %d collections", lastChunk, len(usable))
+
+	// The subset of collections used for most chunks
+	var defaultLetters []string
+	for _, u := range usable {
+		if len(defaultLetters) < p.RequiredCopies && (len(defaultLetters) == 0 || defaultLetters[len(defaultLetters)-1] != u.letter) {
+			defaultLetters = append(defaultLetters, u.letter)
+		}
+	}
+	defaultPermutation := strings.Join(defaultLetters, "")
+
+	// Decode the chunks, timing each
+	chunkDataBytes := 0
+	var chunkSpan *trace.Span
+	defer func() { chunkSpan.End() }()
+	for chunkNumber := 1; chunkNumber <= lastChunk; chunkNumber++ {
+		chunkSpan.End()
+		_, chunkSpan = trace.StartSpan(ctx, fmt.Sprintf("chunk %d", chunkNumber))
+		report.Chunks = chunkNumber
+
+		// Read the chunk from every collection, keeping the intact ones
+		type candidate struct {
+			letter    string
+			name      string
+			dataBytes int
+			payload   []byte
+		}
+		var candidates []candidate
+		sizes := make(map[int]int)
+		for _, u := range usable {
+			data, err := u.source.ReadChunk(ctx, chunkNumber)
+			if err != nil {
+				log.Debugf("Collection %s: chunk %d unavailable: %v", u.source.Name(), chunkNumber, err)
+				report.Damaged[u.source.Name()] = append(report.Damaged[u.source.Name()], chunkNumber)
+				continue
+			}
+			info, err := InspectChunk(data)
+			if err != nil || info.Collection != u.source.Name() || info.Number != chunkNumber ||
+				info.PayloadBytes != info.DataBytes*p.PermutationCount || info.Session != session {
+				log.Debugf("Collection %s: chunk %d is damaged", u.source.Name(), chunkNumber)
+				report.Damaged[u.source.Name()] = append(report.Damaged[u.source.Name()], chunkNumber)
+				continue
+			}
+			candidates = append(candidates, candidate{
+				letter:    u.letter,
+				name:      u.source.Name(),
+				dataBytes: info.DataBytes,
+				payload:   data[len(data)-info.PayloadBytes:],
+			})
+			sizes[info.DataBytes]++
+		}
+
+		// Collections of one encode agree on the size of each chunk, so the size
+		// held by most of them is taken as the true one
+		if strict && len(sizes) > 1 {
+			return report, fmt.Errorf("%w: collections disagree on the size of chunk %d", ErrSessionMismatch, chunkNumber)
+		}
+		for size, count := range sizes {
+			if count > sizes[chunkDataBytes] || (count == sizes[chunkDataBytes] && size > chunkDataBytes) {
+				chunkDataBytes = size
+			}
+		}
+
+		// Combine the first K distinct collections that hold the chunk intact
+		var letters []string
+		var pieces [][]byte
+		for _, c := range candidates {
+			if c.dataBytes != chunkDataBytes {
+				report.Damaged[c.name] = append(report.Damaged[c.name], chunkNumber)
+				continue
+			}
+			if len(letters) < p.RequiredCopies && (len(letters) == 0 || letters[len(letters)-1] != c.letter) {
+				letters = append(letters, c.letter)
+				pieces = append(pieces, c.payload)
+			}
+		}
+
+		decodedChunk := make([]byte, chunkDataBytes)
+		if len(letters) < p.RequiredCopies {
+			if strict {
+				return report, fmt.Errorf("%w: chunk %d is intact in only %d of the %d required collections", ErrChunkCorrupt, chunkNumber, len(letters), p.RequiredCopies)
+			}
+			// Keep the output aligned, and report the loss
+			log.Error(fmt.Errorf("chunk %d is intact in only %d of the %d required collections", chunkNumber, len(letters), p.RequiredCopies))
+			report.Unrecoverable = append(report.Unrecoverable, chunkNumber)
+		} else {
+			permutation := strings.Join(letters, "")
+			if permutation != defaultPermutation {
+				log.Debugf("Chunk %d: falling back to permutation %s", chunkNumber, permutation)
+				report.Fallbacks[chunkNumber] = permutation
+			}
+			for i, letter := range letters {
+				offset, err := p.pieceOffset(letter, permutation, chunkDataBytes)
+				if err != nil {
+					return report, err
+				}
+				for j := 0; j < chunkDataBytes; j++ {
+					decodedChunk[j] ^= pieces[i][offset+j]
+				}
+			}
+		}
+
+		// Chunks read from the sources are theirs, and may be kept by them
+		_, err := output.Write(decodedChunk)
+		Zeroize(decodedChunk)
+		if err != nil {
+			return report, fmt.Errorf("failed to write decoded data: %w", err)
+		}
+		metrics.chunksDecoded.Add(1)
+		metrics.bytesDecoded.Add(int64(chunkDataBytes))
+	}
+
+	return report, nil
+}
+
+// sortOutCopies decides which of several copies of one collection to use. Copies
+// are compared on a chunk that all of them hold intact: identical copies of a
+// collection agree on it, while copies from different encodes differ, as the pad
+// is random. Copies that differ are told apart by the number and size of their
+// chunks, which must match those of the other collections supplied; in strict
+// mode, copies that differ are an error.
+func (p *Pad) sortOutCopies(ctx context.Context, copies []ChunkSource, others []ChunkSource, report *DecodeReport, strict bool) ([]ChunkSource, error) {
+	log := trace.FromContext(ctx).WithPrefix("DECODE")
+	name := copies[0].Name()
+
+	// intactChunk reads a chunk whose header and length are consistent with the set
+	intactChunk := func(source ChunkSource, chunkNumber int) ([]byte, ChunkInfo, bool) {
+		data, err := source.ReadChunk(ctx, chunkNumber)
+		if err != nil {
+			return nil, ChunkInfo{}, false
+		}
+		info, err := InspectChunk(data)
+		if err != nil || info.Number != chunkNumber || info.PayloadBytes != info.DataBytes*p.PermutationCount {
+			return nil, ChunkInfo{}, false
+		}
+		return data, info, true
+	}
+
+	// Find a chunk held intact by every copy, and group the copies by its contents
+	lastChunk := 0
+	for _, c := range copies {
+		lastChunk = max(lastChunk, c.LastChunk())
+	}
+	for chunkNumber := 1; chunkNumber <= lastChunk; chunkNumber++ {
+		var groups [][]ChunkSource
+		var contents [][]byte
+		var sizes []int
+		for _, c := range copies {
+			data, info, ok := intactChunk(c, chunkNumber)
+			if !ok {
+				groups = nil
+				break
+			}
+			found := false
+			for i := range contents {
+				if bytes.Equal(contents[i], data) {
+					groups[i] = append(groups[i], c)
+					found = true
+					break
+				}
+			}
+			if !found {
+				groups = append(groups, []ChunkSource{c})
+				contents = append(contents, data)
+				sizes = append(sizes, info.DataBytes)
+			}
+		}
+		if groups == nil {
+			continue
+		}
+
+		// Identical copies are all kept, as each can stand in for a damaged chunk of another
+		if len(groups) == 1 {
+			log.Debugf("Collection %s: %d identical copies", name, len(copies))
+			for range copies[1:] {
+				report.Duplicates = append(report.Duplicates, name)
+			}
+			return copies, nil
+		}
+
+		if strict {
+			return nil, fmt.Errorf("%w: collection %s is supplied more than once with different contents; remove the copies that do not belong with the other collections", ErrSessionMismatch, name)
+		}
+
+		// Keep the copies shaped like the other collections of the set
+		var matching []int
+		for i, group := range groups {
+			matches := len(others) > 0
+			for _, o := range others {
+				_, info, ok := intactChunk(o, chunkNumber)
+				if o.LastChunk() != group[0].LastChunk() || (ok && info.DataBytes != sizes[i]) {
+					matches = false
+					break
+				}
+			}
+			if matches {
+				matching = append(matching, i)
+			}
+		}
+		if len(matching) != 1 {
+			return nil, fmt.Errorf("%w: collection %s is supplied more than once with different contents, and which copy belongs with the other collections cannot be told; remove the copies that do not", ErrSessionMismatch, name)
+		}
+		for i, group := range groups {
+			if i == matching[0] {
+				continue
+			}
+			for _, c := range group {
+				log.Debugf("Collection %s: setting aside a copy from a different encode", name)
+				report.Ignored = append(report.Ignored, c.Name())
+			}
+		}
+		if len(groups[matching[0]]) > 1 {
+			for range groups[matching[0]][1:] {
+				report.Duplicates = append(report.Duplicates, name)
+			}
+		}
+		return groups[matching[0]], nil
+	}
+
+	return nil, fmt.Errorf("%w: collection %s is supplied more than once, and its copies have no intact chunk in common to compare; remove all but one", ErrSessionMismatch, name)
+}
+
+// sessionVotes is how many chunks of a collection must agree on its session for
+// it to be taken before all of its chunks are read
+const sessionVotes = 2
+
+// sessionOf returns the session ID recorded in the chunks of a collection, or
+// false if none of its chunks can be read. A single chunk, such as a stray one
+// from another encode, does not decide it: chunks are read until sessionVotes
+// of them agree, and otherwise the session of most of them is taken.
+func sessionOf(ctx context.Context, source ChunkSource) (uint64, bool) {
+	votes := make(map[uint64]int)
+	var session uint64
+	for n := 1; n <= source.LastChunk(); n++ {
+		data, err := source.ReadChunk(ctx, n)
+		if err != nil {
+			continue
+		}
+		info, err := InspectChunk(data)
+		if err != nil || info.Collection != source.Name() || info.Number != n {
+			continue
+		}
+		votes[info.Session]++
+		if votes[info.Session] > votes[session] {
+			session = info.Session
+		}
+		if votes[session] >= sessionVotes {
+			break
+		}
+	}
+	return session, votes[session] > 0
+}
@@ -0,0 +1,266 @@
+package pad
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// memorySource is a ChunkSource over chunks held in memory
+type memorySource struct {
+	name   string
+	chunks map[int][]byte
+}
+
+func (s *memorySource) Name() string {
+	return s.name
+}
+
+func (s *memorySource) LastChunk() int {
+	last := 0
+	for n := range s.chunks {
+		last = max(last, n)
+	}
+	return last
+}
+
+func (s *memorySource) ReadChunk(ctx context.Context, chunkNumber int) ([]byte, error) {
+	data, ok := s.chunks[chunkNumber]
+	if !ok {
+		return nil, fmt.Errorf("chunk %d not found", chunkNumber)
+	}
+	return data, nil
+}
+
+// TestDecodeTolerant tests that chunks missing or damaged in some collections are taken from others
+func TestDecodeTolerant(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	input := make([]byte, 10000)
+	if _, err := rand.Read(input); err != nil {
+		t.Fatalf("Failed to generate input: %v", err)
+	}
+
+	// encode encodes a 2-of-3 set in memory, one buffer per chunk
+	encode := func(input []byte) map[string]map[int]*bytes.Buffer {
+		encoded := make(map[string]map[int]*bytes.Buffer)
+		newChunk := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+			if encoded[collectionName] == nil {
+				encoded[collectionName] = make(map[int]*bytes.Buffer)
+			}
+			encoded[collectionName][chunkNumber] = &bytes.Buffer{}
+			return nopWriteCloser{encoded[collectionName][chunkNumber]}, nil
+		}
+		p, err := NewPadForEncode(ctx, 3, 2)
+		if err != nil {
+			t.Fatalf("NewPadForEncode failed: %v", err)
+		}
+		if err := p.Encode(ctx, 3000, bytes.NewReader(input), NewDefaultRand(ctx), newChunk, "bin"); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		return encoded
+	}
+	encoded := encode(input)
+	if len(encoded["2A3"]) < 3 {
+		t.Fatalf("Expected at least 3 chunks, got %d", len(encoded["2A3"]))
+	}
+
+	// sourcesOf returns fresh copies of the given collections of a set
+	sourcesOf := func(set map[string]map[int]*bytes.Buffer, names ...string) []ChunkSource {
+		var result []ChunkSource
+		for _, name := range names {
+			s := &memorySource{name: name, chunks: make(map[int][]byte)}
+			for n, buf := range set[name] {
+				s.chunks[n] = bytes.Clone(buf.Bytes())
+			}
+			result = append(result, s)
+		}
+		return result
+	}
+	sources := func(names ...string) []ChunkSource {
+		return sourcesOf(encoded, names...)
+	}
+
+	tests := []struct {
+		name          string
+		sources       []string
+		damage        func(s []ChunkSource)
+		fallbacks     map[int]string
+		unrecoverable []int
+		duplicates    int
+	}{
+		{
+			name:   "intact",
+			damage: func(s []ChunkSource) {},
+		},
+		{
+			name: "missing chunk",
+			damage: func(s []ChunkSource) {
+				delete(s[0].(*memorySource).chunks, 2)
+			},
+			fallbacks: map[int]string{2: "BC"},
+		},
+		{
+			name: "truncated chunk",
+			damage: func(s []ChunkSource) {
+				chunk := s[1].(*memorySource).chunks[1]
+				s[1].(*memorySource).chunks[1] = chunk[:len(chunk)/2]
+			},
+			fallbacks: map[int]string{1: "AC"},
+		},
+		{
+			name: "unrecoverable chunk",
+			damage: func(s []ChunkSource) {
+				delete(s[0].(*memorySource).chunks, 2)
+				delete(s[1].(*memorySource).chunks, 2)
+			},
+			unrecoverable: []int{2},
+		},
+		{
+			name:    "identical copies stand in for each other",
+			sources: []string{"2A3", "2A3", "2B3"},
+			damage: func(s []ChunkSource) {
+				delete(s[0].(*memorySource).chunks, 2)
+				delete(s[1].(*memorySource).chunks, 3)
+			},
+			duplicates: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			names := tc.sources
+			if names == nil {
+				names = []string{"2A3", "2B3", "2C3"}
+			}
+			s := sources(names...)
+			tc.damage(s)
+			var output bytes.Buffer
+			report, err := new(Pad).DecodeTolerant(ctx, s, &output, false)
+			if err != nil {
+				t.Fatalf("DecodeTolerant failed: %v", err)
+			}
+			if len(report.Fallbacks) != len(tc.fallbacks) {
+				t.Errorf("Expected fallbacks %v, got %v", tc.fallbacks, report.Fallbacks)
+			}
+			for n, permutation := range tc.fallbacks {
+				if report.Fallbacks[n] != permutation {
+					t.Errorf("Expected chunk %d to fall back to %s, got %q", n, permutation, report.Fallbacks[n])
+				}
+			}
+			if len(report.Duplicates) != tc.duplicates {
+				t.Errorf("Expected %d duplicates, got %v", tc.duplicates, report.Duplicates)
+			}
+			if fmt.Sprint(report.Unrecoverable) != fmt.Sprint(tc.unrecoverable) {
+				t.Errorf("Expected unrecoverable chunks %v, got %v", tc.unrecoverable, report.Unrecoverable)
+			}
+			if len(tc.unrecoverable) == 0 {
+				if report.Err() != nil {
+					t.Errorf("Unexpected report error: %v", report.Err())
+				}
+				if !bytes.Equal(output.Bytes(), input) {
+					t.Errorf("Decoded data does not match the original")
+				}
+				return
+			}
+			if !errors.Is(report.Err(), ErrChunkCorrupt) {
+				t.Errorf("Expected a corrupt chunk report error for unrecoverable chunks, got %v", report.Err())
+			}
+			if output.Len() != len(input) {
+				t.Errorf("Expected output to keep its length %d, got %d", len(input), output.Len())
+			}
+		})
+	}
+
+	// Collections of another K-of-N set are ignored
+	foreign := &memorySource{name: "3A4", chunks: map[int][]byte{}}
+	var output bytes.Buffer
+	report, err := new(Pad).DecodeTolerant(ctx, append(sources("2A3", "2C3"), foreign), &output, false)
+	if err != nil {
+		t.Fatalf("DecodeTolerant failed: %v", err)
+	}
+	if len(report.Ignored) != 1 || report.Ignored[0] != "3A4" || !bytes.Equal(output.Bytes(), input) {
+		t.Errorf("Expected 3A4 to be ignored and the data decoded, got ignored %v", report.Ignored)
+	}
+
+	// A copy of a collection from another encode is set aside, told apart by
+	// the session ID in its chunk headers
+	longer := encode(append(bytes.Clone(input), input...))
+	output.Reset()
+	report, err = new(Pad).DecodeTolerant(ctx, append(sourcesOf(longer, "2A3"), sources("2A3", "2B3")...), &output, false)
+	if err != nil {
+		t.Fatalf("DecodeTolerant failed: %v", err)
+	}
+	if len(report.Ignored) != 1 || !bytes.Equal(output.Bytes(), input) {
+		t.Errorf("Expected the copy of 2A3 from another encode to be ignored and the data decoded, got ignored %v", report.Ignored)
+	}
+	other := encode(input)
+	output.Reset()
+	report, err = new(Pad).DecodeTolerant(ctx, append(sourcesOf(other, "2A3"), sources("2A3", "2B3")...), &output, false)
+	if err != nil {
+		t.Fatalf("DecodeTolerant failed: %v", err)
+	}
+	if len(report.Ignored) != 1 || !bytes.Equal(output.Bytes(), input) {
+		t.Errorf("Expected the copy of 2A3 from another encode of the same input to be ignored and the data decoded, got ignored %v", report.Ignored)
+	}
+
+	// A stray chunk of another encode in a collection is passed over, rather
+	// than setting the whole collection aside, as its other chunks outvote it
+	stray := sources("2A3", "2B3", "2C3")
+	stray[0].(*memorySource).chunks[1] = bytes.Clone(other["2A3"][1].Bytes())
+	output.Reset()
+	report, err = new(Pad).DecodeTolerant(ctx, stray, &output, false)
+	if err != nil {
+		t.Fatalf("DecodeTolerant failed: %v", err)
+	}
+	if len(report.Ignored) != 0 || fmt.Sprint(report.Damaged["2A3"]) != "[1]" || !bytes.Equal(output.Bytes(), input) {
+		t.Errorf("Expected only chunk 1 of 2A3 to be passed over and the data decoded, got ignored %v, damaged %v", report.Ignored, report.Damaged)
+	}
+
+	// Strict mode allows verified recoveries only
+	strict := []struct {
+		name    string
+		sources []ChunkSource
+		damage  func(s []ChunkSource)
+		wantErr error
+	}{
+		{"strict intact", sources("2A3", "2B3"), func(s []ChunkSource) {}, nil},
+		{"strict missing chunk", sources("2A3", "2B3", "2C3"), func(s []ChunkSource) {
+			delete(s[0].(*memorySource).chunks, 2)
+		}, nil},
+		{"strict identical copies", sources("2A3", "2A3", "2B3"), func(s []ChunkSource) {}, nil},
+		{"strict foreign collection", append(sources("2A3", "2B3"), foreign), func(s []ChunkSource) {}, ErrSessionMismatch},
+		{"strict unrecoverable chunk", sources("2A3", "2B3"), func(s []ChunkSource) {
+			delete(s[0].(*memorySource).chunks, 2)
+		}, ErrChunkCorrupt},
+		{"strict copy from another encode", append(sourcesOf(longer, "2A3"), sources("2A3", "2B3")...), func(s []ChunkSource) {}, ErrSessionMismatch},
+		{"strict too few collections", sources("2A3"), func(s []ChunkSource) {}, ErrInsufficientCollections},
+	}
+	for _, tc := range strict {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.damage(tc.sources)
+			var output bytes.Buffer
+			report, err := new(Pad).DecodeTolerant(ctx, tc.sources, &output, true)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("Expected strict decode to fail with %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeTolerant failed: %v", err)
+			}
+			if report.Err() != nil || !bytes.Equal(output.Bytes(), input) {
+				t.Errorf("Expected the data to be decoded, got report error %v", report.Err())
+			}
+		})
+	}
+}
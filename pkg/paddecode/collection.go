@@ -0,0 +1,350 @@
+package paddecode
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// manifestFileName is the manifest padlock writes into a collection, of which
+// the decoder reads only the snapshots and what marks features it lacks
+const manifestFileName = "padlock.json"
+
+// zipMethodAES is the compression method of an AES-encrypted zip entry
+const zipMethodAES = 99
+
+// chunkFileNamePattern splits the name of a chunk file, less its extension,
+// into the prefix and the chunk number
+var chunkFileNamePattern = regexp.MustCompile(`^(.*[^0-9])?([0-9]+)$`)
+
+// manifest holds the fields of a collection's manifest that the decoder reads
+type manifest struct {
+	Volumes   int               `json:"volumes,omitempty"`
+	Files     map[string]string `json:"files,omitempty"`
+	Store     string            `json:"store,omitempty"`
+	Snapshots []struct {
+		Name       string `json:"name"`
+		FirstChunk int    `json:"firstChunk"`
+		LastChunk  int    `json:"lastChunk"`
+	} `json:"snapshots,omitempty"`
+}
+
+// Collection is one collection found in the input directory, a directory of
+// chunk files or a zip of one read in place
+type Collection struct {
+	Name  string   // Collection name (e.g., "3A5")
+	Path  string   // Path of the directory or zip holding the collection
+	Files []string // Names of its chunk files, in chunk order
+
+	open  func(name string) (io.ReadCloser, error)
+	close func() error
+}
+
+// FindCollections locates the collection directories and zip files in the
+// input directory, such as "3A5" and "3A5.zip". Collections using features
+// that only the full padlock command reads, such as volumes, chunk stores,
+// obfuscated names or encrypted zips, are skipped with a warning, and if they
+// are all that is found the result is ErrUnsupported rather than
+// ErrNoCollections. Collections should be closed with CloseCollections.
+func FindCollections(ctx context.Context, inputDir string) ([]Collection, error) {
+	log := trace.FromContext(ctx).WithPrefix("COLLECTION")
+
+	entries, err := os.ReadDir(inputDir)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to read input directory: %w", err))
+		return nil, fmt.Errorf("failed to read input directory: %w", err)
+	}
+
+	var collections []Collection
+	var unsupported []string
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(inputDir, name)
+		collName := name
+		var coll Collection
+		var err error
+		switch {
+		case entry.IsDir():
+			if base, _, ok := strings.Cut(name, ".vol"); ok && isCollectionName(base) {
+				unsupported = append(unsupported, name+" (a volume)")
+				continue
+			}
+			if !isCollectionName(name) {
+				if coll, ok := openRenamedCollection(openDirCollection(path)); ok {
+					log.Debugf("Identified %s as collection %s by its chunks", path, coll.Name)
+					collections = append(collections, coll)
+				}
+				continue
+			}
+			coll, err = openDirCollection(path)
+		case strings.HasSuffix(name, ".zip"):
+			collName = strings.TrimSuffix(name, ".zip")
+			if !isCollectionName(collName) {
+				if coll, ok := openRenamedCollection(openZipCollection(path)); ok {
+					log.Debugf("Identified %s as collection %s by its chunks", path, coll.Name)
+					collections = append(collections, coll)
+				}
+				continue
+			}
+			coll, err = openZipCollection(path)
+		case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+			unsupported = append(unsupported, name+" (extract it with tar first)")
+			continue
+		default:
+			continue
+		}
+		if err != nil {
+			log.Infof("Warning: skipping %s: %v", name, err)
+			if errors.Is(err, ErrUnsupported) {
+				unsupported = append(unsupported, name)
+			}
+			continue
+		}
+		coll.Name = collName
+		collections = append(collections, coll)
+		log.Debugf("Found collection %s with %d chunk files: %s", coll.Name, len(coll.Files), coll.Path)
+	}
+
+	if len(collections) == 0 {
+		if len(unsupported) > 0 {
+			log.Error(fmt.Errorf("%w: %s", ErrUnsupported, strings.Join(unsupported, ", ")))
+			return nil, fmt.Errorf("%w: %s; decode these with padlock", ErrUnsupported, strings.Join(unsupported, ", "))
+		}
+		log.Error(fmt.Errorf("%w in %s", ErrNoCollections, inputDir))
+		return nil, fmt.Errorf("%w in %s", ErrNoCollections, inputDir)
+	}
+	for _, name := range unsupported {
+		log.Infof("Warning: skipping %s, which this decoder does not read", name)
+	}
+
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+	return collections, nil
+}
+
+// CloseCollections closes the zips of collections read in place
+func CloseCollections(collections []Collection) {
+	for _, coll := range collections {
+		if coll.close != nil {
+			coll.close()
+		}
+	}
+}
+
+// Reader returns the concatenation of the chunks of the collection, in order,
+// reading each chunk file only once the previous one has been consumed
+func (c Collection) Reader() io.Reader {
+	return &chunkReader{coll: c}
+}
+
+// chunkReader reads the chunks of a collection one file at a time
+type chunkReader struct {
+	coll    Collection
+	next    int
+	current *bytes.Reader
+}
+
+// Read implements io.Reader
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for cr.current == nil || cr.current.Len() == 0 {
+		if cr.next == len(cr.coll.Files) {
+			return 0, io.EOF
+		}
+		name := cr.coll.Files[cr.next]
+		cr.next++
+		data, err := cr.coll.readChunk(name)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s of collection %s: %w", ErrChunkCorrupt, name, cr.coll.Name, err)
+		}
+		cr.current = bytes.NewReader(data)
+	}
+	return cr.current.Read(p)
+}
+
+// readChunk reads the chunk held by a chunk file, which is either the chunk
+// itself or a PNG embedding it
+func (c Collection) readChunk(name string) ([]byte, error) {
+	f, err := c.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	contents, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(contents, PNGSignature) {
+		return ExtractDataFromPNG(bytes.NewReader(contents))
+	}
+	return contents, nil
+}
+
+// openDirCollection opens a collection directory
+func openDirCollection(dir string) (Collection, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Collection{}, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, name))
+	}
+	return newCollection(dir, names, open, nil)
+}
+
+// openZipCollection opens the zip of a collection, to be read in place
+func openZipCollection(path string) (Collection, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return Collection{}, err
+	}
+	files := make(map[string]*zip.File, len(r.File))
+	var names []string
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if f.Method == zipMethodAES {
+			r.Close()
+			return Collection{}, fmt.Errorf("%w: the zip is encrypted", ErrUnsupported)
+		}
+		name := strings.ReplaceAll(f.Name, `\`, "/")
+		files[name] = f
+		names = append(names, name)
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		f, ok := files[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return f.Open()
+	}
+	coll, err := newCollection(path, names, open, r.Close)
+	if err != nil {
+		r.Close()
+	}
+	return coll, err
+}
+
+// newCollection identifies the chunk files of a collection among the names of
+// its files. They are the largest group of files whose names differ only in a
+// number before their extension, in the order of that number, and limited to
+// the latest snapshot if the manifest records snapshots.
+func newCollection(path string, names []string, open func(string) (io.ReadCloser, error), closer func() error) (Collection, error) {
+	var m manifest
+	for _, name := range names {
+		switch {
+		case name == "index.json":
+			return Collection{}, fmt.Errorf("%w: the collection's names are obfuscated", ErrUnsupported)
+		case name == manifestFileName:
+			f, err := open(name)
+			if err != nil {
+				return Collection{}, err
+			}
+			err = json.NewDecoder(f).Decode(&m)
+			f.Close()
+			if err != nil {
+				return Collection{}, fmt.Errorf("invalid %s: %w", manifestFileName, err)
+			}
+		}
+	}
+	switch {
+	case m.Volumes > 0:
+		return Collection{}, fmt.Errorf("%w: the collection is split into volumes", ErrUnsupported)
+	case m.Store != "" || len(m.Files) > 0:
+		return Collection{}, fmt.Errorf("%w: the collection is held in a chunk store or its names are obfuscated", ErrUnsupported)
+	}
+
+	type chunkFile struct {
+		name   string
+		number int
+	}
+	groups := make(map[string][]chunkFile)
+	for _, name := range names {
+		ext := filepath.Ext(name)
+		if strings.HasPrefix(name, "padlock") || name == "README.txt" || strings.EqualFold(ext, ".par") || strings.Contains(name, "/") {
+			continue
+		}
+		match := chunkFileNamePattern.FindStringSubmatch(strings.TrimSuffix(name, ext))
+		if match == nil {
+			continue
+		}
+		number, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		// Names differing only in case, as after a copy between platforms, are of the same chunks
+		key := strings.ToLower(match[1] + "\x00" + ext)
+		groups[key] = append(groups[key], chunkFile{name: name, number: number})
+	}
+	var bestKey string
+	for key, group := range groups {
+		if best := groups[bestKey]; len(group) > len(best) || (len(group) == len(best) && key < bestKey) {
+			bestKey = key
+		}
+	}
+	chunks := groups[bestKey]
+	if len(chunks) == 0 {
+		return Collection{}, fmt.Errorf("no chunk files found")
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].number < chunks[j].number })
+
+	// A collection holding snapshots is decoded from its latest
+	first, last := 1, chunks[len(chunks)-1].number
+	if n := len(m.Snapshots); n > 0 {
+		first, last = m.Snapshots[n-1].FirstChunk, m.Snapshots[n-1].LastChunk
+	}
+	coll := Collection{Path: path, open: open, close: closer}
+	for _, chunk := range chunks {
+		if chunk.number >= first && chunk.number <= last {
+			coll.Files = append(coll.Files, chunk.name)
+		}
+	}
+	return coll, nil
+}
+
+// openRenamedCollection names a collection whose directory or zip is not named
+// as a collection, such as one renamed "dad's share", after the header of its
+// first intact chunk, closing it and returning false if it is not a collection.
+// Every chunk names its collection, so the loss of the first does not lose it.
+func openRenamedCollection(coll Collection, err error) (Collection, bool) {
+	if err != nil {
+		return Collection{}, false
+	}
+	for _, name := range coll.Files {
+		if data, err := coll.readChunk(name); err == nil {
+			if h, err := ReadChunkHeader(bytes.NewReader(data)); err == nil && isCollectionName(h.Collection) {
+				coll.Name = h.Collection
+				return coll, true
+			}
+		}
+	}
+	if coll.close != nil {
+		coll.close()
+	}
+	return Collection{}, false
+}
+
+// isCollectionName checks if a string looks like a collection name (e.g. "3A5")
+func isCollectionName(name string) bool {
+	_, _, _, err := ParseCollectionLabel(name)
+	return err == nil
+}
@@ -0,0 +1,220 @@
+package paddecode
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// encodeChunks encodes data as the chunks of a 2-of-2 set, each of chunkSize
+// bytes but the last, returning the contents of each chunk file of 2A2 and 2B2
+func encodeChunks(data []byte, chunkSize int, session uint64) (a, b [][]byte) {
+	rng := rand.New(rand.NewSource(int64(session)))
+	for number := 1; len(data) > 0 || number == 1; number++ {
+		n := min(chunkSize, len(data))
+		pad := make([]byte, n)
+		rng.Read(pad)
+		xored := make([]byte, n)
+		for i := range xored {
+			xored[i] = pad[i] ^ data[i]
+		}
+		header := func(letter byte) []byte {
+			h := make([]byte, HeaderBytes)
+			h[1], h[2], h[3], h[4] = HeaderVersion, letter, 2, 2
+			binary.BigEndian.PutUint32(h[5:9], uint32(number))
+			binary.BigEndian.PutUint64(h[9:17], uint64(n))
+			binary.BigEndian.PutUint64(h[17:25], session)
+			return h
+		}
+		a = append(a, append(header(0), pad...))
+		b = append(b, append(header(1), xored...))
+		data = data[n:]
+	}
+	return a, b
+}
+
+// writeCollections writes the 2-of-2 collections of data into dir as
+// collection directories of bin chunk files
+func writeCollections(t *testing.T, dir string, data []byte, chunkSize int) {
+	a, b := encodeChunks(data, chunkSize, 1)
+	for name, chunks := range map[string][][]byte{"2A2": a, "2B2": b} {
+		writeChunks(t, filepath.Join(dir, name), name, 1, chunks)
+	}
+}
+
+// writeChunks writes chunk files into a collection directory, numbered from first
+func writeChunks(t *testing.T, dir string, name string, first int, chunks [][]byte) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create collection directory: %v", err)
+	}
+	for i, chunk := range chunks {
+		path := filepath.Join(dir, fmt.Sprintf("%s_%04d.bin", name, first+i))
+		if err := os.WriteFile(path, chunk, 0644); err != nil {
+			t.Fatalf("Failed to write chunk file: %v", err)
+		}
+	}
+}
+
+func TestFindCollections(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	data := bytes.Repeat([]byte("collection data "), 20)
+
+	// decode reads the collections found in dir
+	decode := func(t *testing.T, dir string) ([]byte, error) {
+		collections, err := FindCollections(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		defer CloseCollections(collections)
+		readers := make([]io.Reader, len(collections))
+		for i, coll := range collections {
+			readers[i] = coll.Reader()
+		}
+		var d Decoder
+		var output bytes.Buffer
+		err = d.Decode(ctx, readers, &output)
+		return output.Bytes(), err
+	}
+
+	t.Run("Directories", func(t *testing.T) {
+		dir := t.TempDir()
+		writeCollections(t, dir, data, 64)
+		// Files that are not chunks are ignored
+		for _, name := range []string{"padlock-recovery.json", "README.txt", "notes.md"} {
+			if err := os.WriteFile(filepath.Join(dir, "2A2", name), []byte("{}"), 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+		collections, err := FindCollections(ctx, dir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		defer CloseCollections(collections)
+		if len(collections) != 2 || collections[0].Name != "2A2" || len(collections[0].Files) != 5 {
+			t.Fatalf("Expected 2 collections of 5 chunk files, got %+v", collections)
+		}
+		output, err := decode(t, dir)
+		if err != nil || !bytes.Equal(output, data) {
+			t.Errorf("Expected the data, got %d bytes, %v", len(output), err)
+		}
+	})
+
+	t.Run("Zip", func(t *testing.T) {
+		dir := t.TempDir()
+		writeCollections(t, dir, data, 64)
+		f, err := os.Create(filepath.Join(dir, "2B2.zip"))
+		if err != nil {
+			t.Fatalf("Failed to create zip: %v", err)
+		}
+		zw := zip.NewWriter(f)
+		entries, _ := os.ReadDir(filepath.Join(dir, "2B2"))
+		for _, entry := range entries {
+			contents, _ := os.ReadFile(filepath.Join(dir, "2B2", entry.Name()))
+			w, _ := zw.Create(entry.Name())
+			w.Write(contents)
+		}
+		zw.Close()
+		f.Close()
+		os.RemoveAll(filepath.Join(dir, "2B2"))
+
+		output, err := decode(t, dir)
+		if err != nil || !bytes.Equal(output, data) {
+			t.Errorf("Expected the data, got %d bytes, %v", len(output), err)
+		}
+	})
+
+	t.Run("Changed case", func(t *testing.T) {
+		// Chunks copied between platforms may come out with some of their
+		// names in another case, and are still read as one collection
+		dir := t.TempDir()
+		writeCollections(t, dir, data, 64)
+		for _, n := range []int{2, 4} {
+			from := filepath.Join(dir, "2A2", fmt.Sprintf("2A2_%04d.bin", n))
+			if err := os.Rename(from, filepath.Join(dir, "2A2", fmt.Sprintf("2a2_%04d.BIN", n))); err != nil {
+				t.Fatalf("Failed to rename chunk: %v", err)
+			}
+		}
+		output, err := decode(t, dir)
+		if err != nil || !bytes.Equal(output, data) {
+			t.Errorf("Expected the data, got %d bytes, %v", len(output), err)
+		}
+	})
+
+	t.Run("Renamed", func(t *testing.T) {
+		// Collections are named by their chunks when their directory or zip is not
+		dir := t.TempDir()
+		writeCollections(t, dir, data, 64)
+		if err := os.Rename(filepath.Join(dir, "2A2"), filepath.Join(dir, "dad's share")); err != nil {
+			t.Fatalf("Failed to rename collection: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Join(dir, "photos"), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "photos", "IMG_0001.JPG"), []byte("not a chunk"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		collections, err := FindCollections(ctx, dir)
+		if err != nil {
+			t.Fatalf("FindCollections failed: %v", err)
+		}
+		CloseCollections(collections)
+		if len(collections) != 2 || collections[0].Name != "2A2" || collections[0].Path != filepath.Join(dir, "dad's share") {
+			t.Fatalf("Expected 2A2 in the renamed directory, got %+v", collections)
+		}
+		output, err := decode(t, dir)
+		if err != nil || !bytes.Equal(output, data) {
+			t.Errorf("Expected the data, got %d bytes, %v", len(output), err)
+		}
+	})
+
+	t.Run("Latest snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		writeCollections(t, dir, []byte("the first snapshot"), 64)
+		newer := []byte("the second snapshot, which is decoded")
+		a, b := encodeChunks(newer, 16, 2)
+		writeChunks(t, filepath.Join(dir, "2A2"), "2A2", 2, a)
+		writeChunks(t, filepath.Join(dir, "2B2"), "2B2", 2, b)
+		manifest := fmt.Sprintf(`{"snapshots":[{"name":"initial","firstChunk":1,"lastChunk":1},{"name":"second","firstChunk":2,"lastChunk":%d}]}`, 1+len(a))
+		for _, name := range []string{"2A2", "2B2"} {
+			if err := os.WriteFile(filepath.Join(dir, name, manifestFileName), []byte(manifest), 0644); err != nil {
+				t.Fatalf("Failed to write manifest: %v", err)
+			}
+		}
+		output, err := decode(t, dir)
+		if err != nil || !bytes.Equal(output, newer) {
+			t.Errorf("Expected %q, got %q, %v", newer, output, err)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		dir := t.TempDir()
+		writeCollections(t, filepath.Join(dir, "volumes"), data, 64)
+		os.Rename(filepath.Join(dir, "volumes", "2A2"), filepath.Join(dir, "2A2.vol01"))
+		os.RemoveAll(filepath.Join(dir, "volumes"))
+		if err := os.WriteFile(filepath.Join(dir, "2B2.tar.gz"), nil, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if _, err := FindCollections(ctx, dir); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("Expected ErrUnsupported, got %v", err)
+		}
+	})
+
+	t.Run("None", func(t *testing.T) {
+		if _, err := FindCollections(ctx, t.TempDir()); !errors.Is(err, ErrNoCollections) {
+			t.Errorf("Expected ErrNoCollections, got %v", err)
+		}
+	})
+}
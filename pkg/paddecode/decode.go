@@ -0,0 +1,303 @@
+// Package paddecode decodes padlock collections with nothing but the standard
+// library, for decode-only builds such as padlock-decode that recovery
+// environments (an initramfs, a rescue USB stick) can carry.
+//
+// It holds the format shared with the pad package, which encodes collections
+// and delegates to it to read them: the chunk header, the collection labels,
+// the permutations each collection's pieces belong to and the padding of a
+// padded encode. On top of the decode itself, it finds the collections in a
+// directory and restores the archive they hold. It reads collection
+// directories and zips in either format, but leaves volumes, chunk stores,
+// obfuscated names, encrypted zips, hybrid encodes and damaged chunks to the
+// full padlock command, which recovers what it can from them.
+package paddecode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// Decoder reconstructs the data of K or more collections of one encode. What
+// it learns of the set from the chunks it reads is kept in its fields.
+type Decoder struct {
+	RequiredCopies int    // K, from the first chunk read
+	TotalCopies    int    // N, from the first chunk read
+	Session        uint64 // Session ID of the encode, zero for legacy chunks
+
+	// OnChunk, if set, is called with the data bytes of each chunk decoded
+	OnChunk func(dataBytes int)
+}
+
+// Decode performs the one-time pad decoding process to reconstruct the original data.
+//
+// Each collection is read as the concatenation of its chunks, in order. For
+// each chunk, the header of every collection is read and validated, and the
+// pieces of the first K collections, by letter, that share a permutation are
+// XORed together to give the data, which is written to output without the
+// padding of a padded encode. Decoding stops once every collection ends,
+// between chunks, at the same chunk.
+//
+// Collections of one encode hold the same number of chunks, each holding as
+// much data as the first but the last, which may hold less. A collection that
+// ends within a chunk, or chunks before the others, has been truncated; rather
+// than writing output that silently stops short, Decode fails with
+// ErrChunkCorrupt naming the collections and where they end.
+func (d *Decoder) Decode(ctx context.Context, collections []io.Reader, output io.Writer) error {
+	log := trace.FromContext(ctx).WithPrefix("DECODE")
+
+	log.Debugf("Starting decode with %d collections", len(collections))
+	output = NewUnpadWriter(output)
+
+	// Create a structure to track collection state
+	type collectionState struct {
+		reader           io.Reader
+		nextChunkNumber  int
+		collectionName   string
+		collectionLetter string
+		done             bool
+	}
+
+	states := make([]collectionState, len(collections))
+	for i, reader := range collections {
+		states[i] = collectionState{
+			reader:          reader,
+			nextChunkNumber: 1, // Start at chunk 1
+		}
+	}
+
+	// The scheme is learned from the first chunk, along with the session of
+	// the encode
+	pieces := 0
+	sessionVersion := 0
+
+	// Name a collection in errors, by position until its first chunk is read
+	label := func(i int) string {
+		if states[i].collectionName != "" {
+			return states[i].collectionName
+		}
+		return fmt.Sprintf("%d", i+1)
+	}
+
+	// Read chunks until we've processed all available chunks in all
+	// collections, timing each
+	var chunkDataBytes, fullDataBytes, prevDataBytes int
+	var chunkSpan *trace.Span
+	defer func() { chunkSpan.End() }()
+	for chunkIndex := 1; ; chunkIndex++ {
+		chunkSpan.End()
+		_, chunkSpan = trace.StartSpan(ctx, fmt.Sprintf("chunk %d", chunkIndex))
+
+		// For each collection, read the next chunk
+		chunks := make([][]byte, len(collections))
+		firstDataBytes, firstName := 0, ""
+
+		for i := range states {
+			state := &states[i]
+
+			// Read the chunk header; a collection may only end between chunks
+			header, err := ReadChunkHeader(state.reader)
+			if err == io.EOF {
+				// No more chunks in this collection
+				log.Debugf("Collection %d is done (EOF) after %d chunks", i, chunkIndex-1)
+				state.done = true
+				continue
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("%w: collection %s is truncated within the header of chunk %d", ErrChunkCorrupt, label(i), chunkIndex)
+			}
+			if err != nil {
+				return fmt.Errorf("collection %s: %w", label(i), err)
+			}
+			log.Debugf("Collection %d: Chunk %d of %s, session %016x", i, header.Number, header.Collection, header.Session)
+
+			// Parse the collection name from the chunk header
+			collName, chunkNum := header.Collection, header.Number
+			chunkDataBytes = header.DataBytes
+			requiredCopies, totalCopies, collLetter, err := ParseCollectionLabel(collName)
+			if err != nil {
+				return fmt.Errorf("chunk %d: %w", chunkNum, err)
+			}
+
+			// Learn the scheme if we haven't done so
+			if pieces == 0 {
+				d.RequiredCopies, d.TotalCopies = requiredCopies, totalCopies
+				pieces = PiecesPerCollection(requiredCopies, totalCopies)
+				d.Session, sessionVersion = header.Session, header.Version
+				log.Debugf("Scheme is totalCopies:%d requiredCopies:%d", d.TotalCopies, d.RequiredCopies)
+			}
+
+			// If this is the first chunk, initialize the collection name
+			if states[i].collectionName == "" {
+				states[i].collectionName = collName
+				states[i].collectionLetter = collLetter
+				log.Debugf("Collection %d: Initialized collection name: %s", i, collName)
+			} else if states[i].collectionName != collName {
+				return fmt.Errorf("%w: collection name mismatch: expected %s, got %s",
+					ErrSessionMismatch, states[i].collectionName, collName)
+			}
+
+			// Verify the copies
+			if requiredCopies != d.RequiredCopies {
+				return fmt.Errorf("%w: required copies mismatch: expected %d, got %d",
+					ErrSessionMismatch, d.RequiredCopies, requiredCopies)
+			}
+			if totalCopies != d.TotalCopies {
+				return fmt.Errorf("%w: total copies mismatch: expected %d, got %d",
+					ErrSessionMismatch, d.TotalCopies, totalCopies)
+			}
+
+			// Every chunk of one encode carries its session ID
+			if header.Session != d.Session || header.Version != sessionVersion {
+				return fmt.Errorf("%w: chunk %d of collection %s is from a different encode, with session %016x rather than %016x",
+					ErrSessionMismatch, chunkNum, collName, header.Session, d.Session)
+			}
+
+			// Verify the chunk number
+			if chunkNum != states[i].nextChunkNumber {
+				log.Debugf("Collection %d: Chunk number mismatch: expected %d, got %d",
+					i, states[i].nextChunkNumber, chunkNum)
+				return fmt.Errorf("%w: chunk number mismatch: expected %d, got %d",
+					ErrChunkCorrupt, states[i].nextChunkNumber, chunkNum)
+			}
+			states[i].nextChunkNumber++
+
+			// Every collection of one encode holds the same amount of data per chunk
+			if firstName == "" {
+				firstDataBytes, firstName = chunkDataBytes, collName
+			} else if chunkDataBytes != firstDataBytes {
+				return fmt.Errorf("%w: chunk %d size mismatch: collection %s holds %d bytes but collection %s holds %d",
+					ErrSessionMismatch, chunkNum, firstName, firstDataBytes, collName, chunkDataBytes)
+			}
+
+			// Compute the chunk length
+			if chunkDataBytes > math.MaxInt/pieces {
+				return fmt.Errorf("%w: chunk %d of collection %s claims %d bytes of data, more than this platform can address",
+					ErrChunkCorrupt, chunkNum, collName, chunkDataBytes)
+			}
+			readLength := chunkDataBytes * pieces
+
+			// Read the chunk data, growing the buffer as it arrives rather than
+			// trusting the size in the header of a chunk that may be forged
+			log.Debugf("Collection %d: Reading %d bytes of chunk data for %d byte chunk", i, readLength, chunkDataBytes)
+			chunk, err := io.ReadAll(io.LimitReader(state.reader, int64(readLength)))
+			if err != nil {
+				return fmt.Errorf("%w: failed to read chunk data: %w", ErrChunkCorrupt, err)
+			}
+			if n := len(chunk); n < readLength {
+				return fmt.Errorf("%w: collection %s is truncated within chunk %d, holding %d of its %d bytes",
+					ErrChunkCorrupt, collName, chunkNum, n, readLength)
+			}
+			chunks[i] = chunk
+			log.Debugf("Collection %d: Read %d bytes of chunk data", i, len(chunk))
+		}
+
+		// Check if all collections have been fully processed
+		var ended, continued []string
+		for i, state := range states {
+			if state.done {
+				ended = append(ended, label(i))
+			} else {
+				continued = append(continued, label(i))
+			}
+		}
+		if len(continued) == 0 {
+			log.Debugf("All collections have been fully processed after %d chunks", chunkIndex-1)
+			chunkSpan = nil // There is no such chunk
+			return nil
+		}
+		if len(ended) > 0 {
+			// Collections of one encode all hold the same number of chunks, so
+			// stopping here would silently truncate the output
+			return fmt.Errorf("%w: collection(s) %s end after %d chunks, but %s hold chunk %d; the collections are truncated or from different encodes",
+				ErrChunkCorrupt, strings.Join(ended, ", "), chunkIndex-1, strings.Join(continued, ", "), chunkIndex)
+		}
+
+		// Every chunk holds as much data as the first but the last, which may hold less
+		if chunkIndex == 1 {
+			fullDataBytes = chunkDataBytes
+		} else if chunkDataBytes > fullDataBytes {
+			return fmt.Errorf("%w: chunk %d holds %d bytes, more than the %d of chunk 1",
+				ErrChunkCorrupt, chunkIndex, chunkDataBytes, fullDataBytes)
+		} else if prevDataBytes < fullDataBytes {
+			return fmt.Errorf("%w: chunk %d holds only %d of %d bytes, so it should be the last, yet chunk %d follows it",
+				ErrChunkCorrupt, chunkIndex-1, prevDataBytes, fullDataBytes, chunkIndex)
+		}
+		prevDataBytes = chunkDataBytes
+
+		// Loop through all the collections to find the first permutation that matches,
+		// keeping each collection's chunk with its letter since readers may arrive in any order
+		order := make([]int, len(states))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool {
+			return states[order[a]].collectionLetter < states[order[b]].collectionLetter
+		})
+		// A collection supplied more than once is used once, as a permutation
+		// names each collection only once
+		chunkLetters := []string{}
+		sortedChunks := [][]byte{}
+		for _, i := range order {
+			if n := len(chunkLetters); n > 0 && chunkLetters[n-1] == states[i].collectionLetter {
+				if chunkIndex == 1 {
+					log.Infof("Warning: collection %s was supplied more than once; using one copy", states[i].collectionName)
+				}
+				continue
+			}
+			chunkLetters = append(chunkLetters, states[i].collectionLetter)
+			sortedChunks = append(sortedChunks, chunks[i])
+		}
+		if len(chunkLetters) < d.RequiredCopies {
+			return fmt.Errorf("%w: %d of the %d required", ErrInsufficientCollections, len(chunkLetters), d.RequiredCopies)
+		}
+		chunkLetters = chunkLetters[0:d.RequiredCopies]
+		chunks = sortedChunks
+		permutation := strings.Join(chunkLetters, "")
+		log.Debugf("Permutation %s will be used for decode", permutation)
+
+		// Generate the final data
+		decodedChunk := make([]byte, chunkDataBytes)
+		for i := 0; i < len(chunkLetters); i++ {
+			// Find the index of the permutation among those of this collectionLetter,
+			// such as 3 for ABE among B's [ABC ABD ABE BCD BCE BDE]
+			permIndex, err := PieceIndex(permutation, chunkLetters[i], d.TotalCopies)
+			if err != nil {
+				return fmt.Errorf("failed to find permutation index for collection %s: %w", chunkLetters[i], err)
+			}
+			log.Debugf("Collection %s: XORing data from permutation %d for %s", chunkLetters[i], permIndex, permutation)
+			// XOR the data with the appropriate permutation within that chunk
+			permBase := permIndex * chunkDataBytes
+			for j := 0; j < chunkDataBytes; j++ {
+				decodedChunk[j] = decodedChunk[j] ^ chunks[i][permBase+j]
+			}
+		}
+
+		// Write the decoded data to the output
+		_, err := output.Write(decodedChunk)
+		zeroize(decodedChunk)
+		for _, chunk := range chunks {
+			zeroize(chunk)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write decoded data: %w", err)
+		}
+		if d.OnChunk != nil {
+			d.OnChunk(chunkDataBytes)
+		}
+	}
+}
+
+// zeroize overwrites b with zeros, so that plaintext, pads and ciphertext don't
+// linger in memory once a chunk has been decoded
+func zeroize(b []byte) {
+	clear(b)
+	runtime.KeepAlive(b)
+}
@@ -0,0 +1,79 @@
+package paddecode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// vector holds the fields of a conformance vector that the decoder is checked against
+type vector struct {
+	Name        string            `json:"name"`
+	Required    int               `json:"required"`
+	Copies      int               `json:"copies"`
+	Collections map[string][]byte `json:"collections"`
+	Cases       []struct {
+		Collections []string `json:"collections"`
+		Output      []byte   `json:"output"`
+		Error       string   `json:"error"`
+	} `json:"cases"`
+}
+
+// readVectors reads the conformance vectors directly, as an implementation
+// outside of padlock would, since the conformance package depends on this one
+func readVectors(t *testing.T) []vector {
+	paths, err := filepath.Glob(filepath.Join("..", "conformance", "testdata", "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("Failed to find the conformance vectors: %v", err)
+	}
+	var vectors []vector
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		var v vector
+		if err := json.Unmarshal(data, &v); err != nil {
+			t.Fatalf("Failed to parse %s: %v", path, err)
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors
+}
+
+func TestDecode(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	for _, v := range readVectors(t) {
+		t.Run(v.Name, func(t *testing.T) {
+			for _, c := range v.Cases {
+				readers := make([]io.Reader, len(c.Collections))
+				for i, key := range c.Collections {
+					readers[i] = bytes.NewReader(v.Collections[key])
+				}
+				chunks := 0
+				d := Decoder{OnChunk: func(int) { chunks++ }}
+				var output bytes.Buffer
+				err := d.Decode(ctx, readers, &output)
+				switch {
+				case c.Error != "" && err == nil:
+					t.Errorf("Decoding %v succeeded, but should fail: %s", c.Collections, c.Error)
+				case c.Error == "" && err != nil:
+					t.Errorf("Decoding %v failed: %v", c.Collections, err)
+				case c.Error == "" && !bytes.Equal(output.Bytes(), c.Output):
+					t.Errorf("Decoding %v produced %q, expected %q", c.Collections, output.Bytes(), c.Output)
+				case c.Error == "" && (d.RequiredCopies != v.Required || d.TotalCopies != v.Copies || chunks == 0):
+					t.Errorf("Expected %d of %d over some chunks, got %d of %d over %d", v.Required, v.Copies, d.RequiredCopies, d.TotalCopies, chunks)
+				}
+			}
+		})
+	}
+}
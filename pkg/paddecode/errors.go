@@ -0,0 +1,39 @@
+package paddecode
+
+import "errors"
+
+// Errors returned by the decoder, wrapped with the details of each failure, so
+// that callers can tell failures apart with errors.Is rather than by their
+// text. The pad package returns the same values.
+var (
+	// ErrBadLabel means a collection name or chunk name is malformed
+	ErrBadLabel = errors.New("invalid label")
+
+	// ErrInsufficientCollections means fewer than K collections of a set were supplied
+	ErrInsufficientCollections = errors.New("not enough collections")
+
+	// ErrSessionMismatch means the collections supplied come from different
+	// encodes, or different copies of one collection disagree
+	ErrSessionMismatch = errors.New("collections from different encodes")
+
+	// ErrChunkCorrupt means a chunk is missing, truncated or damaged beyond what
+	// the other collections can make up for
+	ErrChunkCorrupt = errors.New("corrupt chunk")
+
+	// ErrNoCollections means no collections were found in the input directory
+	ErrNoCollections = errors.New("no collections found")
+
+	// ErrUnsupported means the collections use a feature that only the full
+	// padlock command decodes, such as volumes or a hybrid encode
+	ErrUnsupported = errors.New("not supported by this decoder")
+
+	// ErrOutputNotEmpty means the output directory already holds files
+	ErrOutputNotEmpty = errors.New("output directory is not empty")
+
+	// ErrNotArchive means the decoded stream is not a valid tar archive
+	ErrNotArchive = errors.New("not a tar archive")
+
+	// ErrUnsafePath means an archive entry would be restored outside of the
+	// output directory
+	ErrUnsafePath = errors.New("unsafe path in archive")
+)
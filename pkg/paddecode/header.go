@@ -0,0 +1,314 @@
+package paddecode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Every chunk begins with a header naming the collection and chunk it belongs
+// to and the size of the payload that follows it. Chunks are written with a
+// fixed binary header:
+//
+//	offset  size  field
+//	0       1     0, where a legacy header holds the nonzero length of its name
+//	1       1     version, 2
+//	2       1     collection letter, 0 for A
+//	3       1     K, the collections required
+//	4       1     N, the collections in the set
+//	5       4     chunk number, from 1
+//	9       8     payload length, the bytes following the header
+//	17      8     session ID, random and shared by every chunk of one encode
+//
+// with its integers big-endian. Legacy chunks, written before the binary
+// header, begin instead with the length of an ASCII name of the form
+// "<collectionName>:<chunkNumber>:<chunkDataBytes>" (e.g., "3A5:1:1024"), and
+// are still read.
+const (
+	HeaderMarker   = 0
+	HeaderVersion  = 2
+	HeaderBytes    = 25
+	LegacyVersion  = 1
+	maxPayloadSize = math.MaxInt
+)
+
+// ChunkHeader is the header of a chunk
+type ChunkHeader struct {
+	Collection string // Collection the chunk belongs to (e.g., "3A5")
+	Number     int    // 1-based chunk number
+	DataBytes  int    // Bytes of input data the chunk encodes, the size of each piece
+	Session    uint64 // Session ID of the encode, zero in a legacy header
+	Version    int    // LegacyVersion for a named header, else HeaderVersion
+}
+
+// ReadChunkHeader reads the header at the start of a chunk, in either format.
+// It returns io.EOF at the end of the collection.
+func ReadChunkHeader(r io.Reader) (ChunkHeader, error) {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(r, first); err != nil {
+		if err == io.EOF {
+			return ChunkHeader{}, io.EOF
+		}
+		return ChunkHeader{}, fmt.Errorf("%w: failed to read chunk header: %w", ErrChunkCorrupt, err)
+	}
+
+	// A legacy header is a name of the length given by the first byte
+	if first[0] != HeaderMarker {
+		nameBuf := make([]byte, int(first[0]))
+		if _, err := io.ReadFull(r, nameBuf); err != nil {
+			return ChunkHeader{}, fmt.Errorf("%w: failed to read chunk name: %w", ErrChunkCorrupt, err)
+		}
+		collName, chunkNumber, chunkDataBytes, err := ParseChunkName(string(nameBuf))
+		if err != nil {
+			return ChunkHeader{}, fmt.Errorf("invalid chunk name %q: %w", nameBuf, err)
+		}
+		return ChunkHeader{Collection: collName, Number: chunkNumber, DataBytes: chunkDataBytes, Version: LegacyVersion}, nil
+	}
+
+	b := make([]byte, HeaderBytes)
+	if _, err := io.ReadFull(r, b[1:]); err != nil {
+		return ChunkHeader{}, fmt.Errorf("%w: failed to read chunk header: %w", ErrChunkCorrupt, err)
+	}
+	return ParseChunkHeader(b)
+}
+
+// ParseChunkHeader parses a binary header, validating each field
+func ParseChunkHeader(b []byte) (ChunkHeader, error) {
+	if len(b) != HeaderBytes || b[0] != HeaderMarker {
+		return ChunkHeader{}, fmt.Errorf("%w: not a binary chunk header", ErrBadLabel)
+	}
+	if b[1] != HeaderVersion {
+		return ChunkHeader{}, fmt.Errorf("%w: unsupported chunk header version %d", ErrBadLabel, b[1])
+	}
+	requiredCopies, totalCopies := int(b[3]), int(b[4])
+	if int(b[2]) >= 26 {
+		return ChunkHeader{}, fmt.Errorf("%w: collection index %d out of range", ErrBadLabel, b[2])
+	}
+	collName := fmt.Sprintf("%d%c%d", requiredCopies, 'A'+b[2], totalCopies)
+	if _, _, _, err := ParseCollectionLabel(collName); err != nil {
+		return ChunkHeader{}, err
+	}
+
+	// A chunk number beyond the range of an int, on 32-bit platforms, is negative
+	chunkNumber := int(binary.BigEndian.Uint32(b[5:9]))
+	if chunkNumber <= 0 {
+		return ChunkHeader{}, fmt.Errorf("%w: chunkNumber must be a positive integer", ErrBadLabel)
+	}
+
+	// The payload holds a piece of equal size for each permutation
+	payload := binary.BigEndian.Uint64(b[9:17])
+	pieces := uint64(PiecesPerCollection(requiredCopies, totalCopies))
+	if payload == 0 || payload%pieces != 0 {
+		return ChunkHeader{}, fmt.Errorf("%w: payload of %d bytes is not %d whole pieces", ErrBadLabel, payload, pieces)
+	}
+	if payload > uint64(maxPayloadSize) {
+		return ChunkHeader{}, fmt.Errorf("%w: chunk %d of collection %s claims %d bytes of payload, more than this platform can address",
+			ErrChunkCorrupt, chunkNumber, collName, payload)
+	}
+
+	return ChunkHeader{
+		Collection: collName,
+		Number:     chunkNumber,
+		DataBytes:  int(payload / pieces),
+		Session:    binary.BigEndian.Uint64(b[17:25]),
+		Version:    HeaderVersion,
+	}, nil
+}
+
+// ParseCollectionLabel parses a label like "3A5" and returns requiredCopies,
+// totalCopies, and collLetter with full validation according to the defined rules.
+func ParseCollectionLabel(label string) (requiredCopies int, totalCopies int, collLetter string, err error) {
+	if len(label) < 3 {
+		return 0, 0, "", fmt.Errorf("%w: label too short", ErrBadLabel)
+	}
+
+	// Find first non-digit: expected to be the collection letter
+	i := 0
+	for i < len(label) && unicode.IsDigit(rune(label[i])) {
+		i++
+	}
+	if i == 0 || i >= len(label)-1 {
+		return 0, 0, "", fmt.Errorf("%w: expected digits, then letter, then digits", ErrBadLabel)
+	}
+
+	requiredStr := label[:i]
+	letterChar := label[i]
+	totalStr := label[i+1:]
+
+	requiredCopies, err = strconv.Atoi(requiredStr)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("%w: invalid requiredCopies: %v", ErrBadLabel, err)
+	}
+
+	totalCopies, err = strconv.Atoi(totalStr)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("%w: invalid totalCopies: %v", ErrBadLabel, err)
+	}
+
+	// Validation: total ∈ [2, 26]
+	if totalCopies < 2 || totalCopies > 26 {
+		return 0, 0, "", fmt.Errorf("%w: totalCopies out of range: %d", ErrBadLabel, totalCopies)
+	}
+
+	// Validation: required ∈ [2, total]
+	if requiredCopies < 2 || requiredCopies > totalCopies {
+		return 0, 0, "", fmt.Errorf("%w: requiredCopies out of range: %d", ErrBadLabel, requiredCopies)
+	}
+
+	// Validation: letter is uppercase and within allowed range
+	if letterChar < 'A' || letterChar > byte('A'+totalCopies-1) {
+		return 0, 0, "", fmt.Errorf("%w: collLetter %q out of range for total %d", ErrBadLabel, letterChar, totalCopies)
+	}
+
+	return requiredCopies, totalCopies, string(letterChar), nil
+}
+
+// ParseChunkName parses the name in a legacy chunk header into its parts,
+// validating each field.
+func ParseChunkName(chunkName string) (collName string, chunkNumber int, chunkDataBytes int, err error) {
+	parts := strings.Split(chunkName, ":")
+	if len(parts) != 3 {
+		return "", 0, 0, fmt.Errorf("%w: chunk name must have 3 parts separated by ':'", ErrBadLabel)
+	}
+
+	collName = parts[0]
+
+	chunkNumber, err = strconv.Atoi(parts[1])
+	if err != nil || chunkNumber <= 0 {
+		return "", 0, 0, fmt.Errorf("%w: chunkNumber must be a positive integer", ErrBadLabel)
+	}
+
+	chunkDataBytes, err = strconv.Atoi(parts[2])
+	if err != nil || chunkDataBytes <= 0 {
+		return "", 0, 0, fmt.Errorf("%w: chunkDataBytes must be a positive integer", ErrBadLabel)
+	}
+
+	return collName, chunkNumber, chunkDataBytes, nil
+}
+
+// PiecesPerCollection returns the number of permutations each collection of a
+// K-of-N scheme participates in, C(N-1, K-1), without enumerating them
+func PiecesPerCollection(requiredCopies, totalCopies int) int {
+	return binomial(totalCopies-1, requiredCopies-1)
+}
+
+// PermutationCount returns the number of permutations of a K-of-N scheme,
+// C(N, K), without enumerating them
+func PermutationCount(requiredCopies, totalCopies int) int {
+	return binomial(totalCopies, requiredCopies)
+}
+
+// binomial returns C(n, k), the number of ways of choosing k of n items
+func binomial(n, k int) int {
+	if k < 0 || k > n {
+		return 0
+	}
+	result := 1
+	for i := 1; i <= k; i++ {
+		result = result * (n - k + i) / i
+	}
+	return result
+}
+
+// ForEachPermutation calls fn with each K-letter permutation of a K-of-N
+// scheme, in sorted order, generating them one at a time rather than all at
+// once, so that schemes with millions of them need no more memory than a few.
+// It stops at the first error fn returns, returning it.
+func ForEachPermutation(requiredCopies, totalCopies int, fn func(perm string) error) error {
+	if requiredCopies < 1 || requiredCopies > totalCopies || totalCopies > 26 {
+		return nil
+	}
+	indexes := make([]int, requiredCopies)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	letters := make([]byte, requiredCopies)
+	for {
+		for i, index := range indexes {
+			letters[i] = byte('A' + index)
+		}
+		if err := fn(string(letters)); err != nil {
+			return err
+		}
+
+		// Advance the last letter that can still advance, following it with
+		// the letters after it
+		i := requiredCopies - 1
+		for i >= 0 && indexes[i] == totalCopies-requiredCopies+i {
+			i--
+		}
+		if i < 0 {
+			return nil
+		}
+		indexes[i]++
+		for j := i + 1; j < requiredCopies; j++ {
+			indexes[j] = indexes[j-1] + 1
+		}
+	}
+}
+
+// PieceIndex returns the position of a collection's piece of a permutation
+// within its chunks: the number of the permutations it participates in that
+// sort before it. It is computed from the letters of the permutation, which
+// must be sorted, rather than by enumerating the others.
+func PieceIndex(perm string, collLetter string, totalCopies int) (int, error) {
+	if len(collLetter) != 1 || !strings.Contains(perm, collLetter) {
+		return 0, fmt.Errorf("collection %s is not part of permutation %s", collLetter, perm)
+	}
+
+	// The permutations a collection participates in, less its own letter, are
+	// the sorted (K-1)-letter combinations of the other N-1 collections, so
+	// the piece is at the rank of the permutation among those combinations
+	n, k := totalCopies-1, len(perm)-1
+	index, prev, i := 0, -1, 0
+	for j := 0; j < len(perm); j++ {
+		c := int(perm[j] - 'A')
+		if c < 0 || c >= totalCopies || (j > 0 && perm[j] <= perm[j-1]) {
+			return 0, fmt.Errorf("%s is not a sorted permutation of %d collections", perm, totalCopies)
+		}
+		if perm[j] == collLetter[0] {
+			continue
+		}
+		if perm[j] > collLetter[0] {
+			c--
+		}
+		for skipped := prev + 1; skipped < c; skipped++ {
+			index += binomial(n-1-skipped, k-1-i)
+		}
+		prev = c
+		i++
+	}
+	return index, nil
+}
+
+// Permutations returns, for each collection letter of a K-of-N scheme, the
+// K-letter combinations of collections it participates in, sorted. A chunk
+// of a collection holds one piece for each, in this order. For example, with
+// K=2, N=3, A participates in [AB AC], B in [AB BC] and C in [AC BC].
+func Permutations(requiredCopies, totalCopies int) map[string][]string {
+	result := make(map[string][]string, totalCopies)
+	var comb func(start int, path []byte)
+	comb = func(start int, path []byte) {
+		// Once K letters are selected, each of them participates in the combination
+		if len(path) == requiredCopies {
+			joined := string(path)
+			for _, letter := range path {
+				result[string(letter)] = append(result[string(letter)], joined)
+			}
+			return
+		}
+		for i := start; i < totalCopies; i++ {
+			comb(i+1, append(path, byte('A'+i)))
+		}
+	}
+	comb(0, nil)
+	for k := range result {
+		sort.Strings(result[k])
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+package paddecode
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseCollectionLabel(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		k, n, letter, err := ParseCollectionLabel("3C5")
+		if err != nil || k != 3 || n != 5 || letter != "C" {
+			t.Errorf("Expected 3, 5, C, got %d, %d, %s, %v", k, n, letter, err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, label := range []string{"", "3A", "A35", "3F5", "1A5", "6A5", "2A27", "3a5"} {
+			if _, _, _, err := ParseCollectionLabel(label); !errors.Is(err, ErrBadLabel) {
+				t.Errorf("Expected ErrBadLabel for %q, got %v", label, err)
+			}
+		}
+	})
+}
+
+func TestPermutations(t *testing.T) {
+	perms := Permutations(2, 3)
+	expected := map[string][]string{"A": {"AB", "AC"}, "B": {"AB", "BC"}, "C": {"AC", "BC"}}
+	if !reflect.DeepEqual(perms, expected) {
+		t.Errorf("Expected %v, got %v", expected, perms)
+	}
+	for _, scheme := range [][2]int{{2, 2}, {2, 5}, {3, 5}, {4, 7}} {
+		k, n := scheme[0], scheme[1]
+		if got, want := len(Permutations(k, n)["A"]), PiecesPerCollection(k, n); got != want {
+			t.Errorf("%d of %d: expected %d permutations per collection, got %d", k, n, want, got)
+		}
+	}
+}
+
+func TestForEachPermutation(t *testing.T) {
+	for _, scheme := range [][2]int{{2, 2}, {2, 3}, {3, 5}, {4, 7}, {5, 9}} {
+		k, n := scheme[0], scheme[1]
+		all := Permutations(k, n)
+
+		// Permutations are generated sorted, and are those each collection
+		// participates in, each at the index of its piece
+		var perms []string
+		err := ForEachPermutation(k, n, func(perm string) error {
+			perms = append(perms, perm)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%d of %d: ForEachPermutation failed: %v", k, n, err)
+		}
+		if len(perms) != PermutationCount(k, n) || !sort.StringsAreSorted(perms) {
+			t.Errorf("%d of %d: expected %d sorted permutations, got %v", k, n, PermutationCount(k, n), perms)
+		}
+		for letter, own := range all {
+			for want, perm := range own {
+				if got, err := PieceIndex(perm, letter, n); err != nil || got != want {
+					t.Errorf("%d of %d: expected piece %d of %s in %s, got %d (%v)", k, n, want, letter, perm, got, err)
+				}
+			}
+		}
+	}
+
+	// Iteration stops at the first error
+	stop := errors.New("stop")
+	count := 0
+	err := ForEachPermutation(3, 5, func(perm string) error {
+		if count++; count == 4 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || count != 4 {
+		t.Errorf("Expected iteration to stop after 4 permutations, got %d (%v)", count, err)
+	}
+
+	// Large schemes need not be enumerated to find a piece
+	if got := PermutationCount(13, 26); got != 10400600 {
+		t.Errorf("PermutationCount(13, 26) = %d, want 10400600", got)
+	}
+	if got, err := PieceIndex("NOPQRSTUVWXYZ", "Z", 26); err != nil || got != PiecesPerCollection(13, 26)-1 {
+		t.Errorf("Expected the last piece of Z, got %d (%v)", got, err)
+	}
+	for _, bad := range [][2]string{{"AB", "C"}, {"BA", "A"}, {"AZ", "A"}, {"AB", ""}} {
+		if _, err := PieceIndex(bad[0], bad[1], 5); err == nil {
+			t.Errorf("Expected an error for the piece of %s in %s", bad[1], bad[0])
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package paddecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// PaddedMagic begins the plaintext of the first chunk of a padded encode, which
+// is how decoders recognize one. It is encrypted like the rest of the chunk.
+var PaddedMagic = []byte("padlock-padded\x00\x02")
+
+// PaddedCountBytes is the size of the count of data bytes at the start of the
+// plaintext of every chunk of a padded encode, after PaddedMagic in the first
+const PaddedCountBytes = 8
+
+// LegacyPaddedMagic began padded encodes written before the count of data
+// bytes was widened to 64 bits, and is followed by a count of
+// LegacyPaddedCountBytes. Such encodes are still decoded.
+var LegacyPaddedMagic = []byte("padlock-padded\x00\x01")
+
+// LegacyPaddedCountBytes is the size of the count of a legacy padded encode
+const LegacyPaddedCountBytes = 4
+
+// unpadWriter receives the decoded chunks of an encode, one per write, and
+// passes on their data. If the first chunk starts with PaddedMagic, each chunk
+// is passed on without its padding; otherwise chunks are passed on unchanged.
+// A chunk that could not be recovered, written as zeros, holds no data once
+// its padding is dropped.
+type unpadWriter struct {
+	w          io.Writer
+	started    bool
+	padded     bool
+	countBytes int // Size of the count of data bytes, which is narrower in a legacy padded encode
+}
+
+// NewUnpadWriter returns a writer that receives decoded chunks, one per write,
+// and drops the padding of a padded encode
+func NewUnpadWriter(w io.Writer) io.Writer {
+	return &unpadWriter{w: w}
+}
+
+// Write implements io.Writer for one decoded chunk
+func (u *unpadWriter) Write(chunk []byte) (int, error) {
+	header := 0
+	if !u.started {
+		u.started = true
+		switch {
+		case len(chunk) >= len(PaddedMagic)+PaddedCountBytes && bytes.HasPrefix(chunk, PaddedMagic):
+			u.padded, u.countBytes = true, PaddedCountBytes
+		case len(chunk) >= len(LegacyPaddedMagic)+LegacyPaddedCountBytes && bytes.HasPrefix(chunk, LegacyPaddedMagic):
+			u.padded, u.countBytes = true, LegacyPaddedCountBytes
+		}
+		header += len(PaddedMagic)
+	}
+	if !u.padded {
+		return u.w.Write(chunk)
+	}
+	header += u.countBytes
+	if len(chunk) < header {
+		return 0, fmt.Errorf("%w: chunk of %d bytes is too small to hold its count of data bytes", ErrChunkCorrupt, len(chunk))
+	}
+	var count uint64
+	if u.countBytes == LegacyPaddedCountBytes {
+		count = uint64(binary.BigEndian.Uint32(chunk[header-u.countBytes : header]))
+	} else {
+		count = binary.BigEndian.Uint64(chunk[header-u.countBytes : header])
+	}
+	if count > uint64(len(chunk)-header) {
+		return 0, fmt.Errorf("%w: chunk claims %d bytes of data but holds at most %d", ErrChunkCorrupt, count, len(chunk)-header)
+	}
+	if _, err := u.w.Write(chunk[header : header+int(count)]); err != nil {
+		return 0, err
+	}
+	return len(chunk), nil
+}
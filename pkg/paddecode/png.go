@@ -0,0 +1,80 @@
+package paddecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// PNGSignature begins every PNG file, and so every chunk file of the png format
+var PNGSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// ExtractDataFromPNG extracts embedded data from a PNG's custom 'rAWd' chunks.
+//
+// This function reverses the steganographic encoding of chunk files in the png
+// format, recovering the original data embedded in the custom chunk. The
+// process is:
+// 1. Read the entire PNG file into memory
+// 2. Locate the 'rAWd' custom chunk
+// 3. Extract the data payload from the chunk and any 'rAWd' chunks following it
+// 4. Verify the CRC of each chunk to ensure data integrity
+//
+// Parameters:
+//   - r: Reader providing the PNG data to extract from
+//
+// Returns:
+//   - The extracted data as a byte slice
+//   - An error if the operation fails (invalid PNG, missing chunk, CRC error)
+//
+// Security notes:
+//   - CRC verification ensures data hasn't been corrupted or tampered with
+//   - No decryption is performed (that happens later in the pad decoding process)
+//   - Fails gracefully if the PNG doesn't contain the expected chunk
+func ExtractDataFromPNG(r io.Reader) ([]byte, error) {
+	all, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read PNG data: %w", err)
+	}
+	chunkType := []byte("rAWd")
+	chunkPos := bytes.Index(all, chunkType)
+	if chunkPos == -1 {
+		return nil, fmt.Errorf("'rAWd' chunk not found")
+	}
+	if chunkPos < 4 {
+		return nil, fmt.Errorf("invalid structure, chunk at offset <4")
+	}
+	var parts [][]byte
+	for {
+		lengthBuf := all[chunkPos-4 : chunkPos]
+		length := binary.BigEndian.Uint32(lengthBuf)
+		dataStart := chunkPos + len(chunkType)
+		if int64(length) > int64(len(all)-dataStart) {
+			return nil, fmt.Errorf("invalid PNG chunk length, out of range")
+		}
+		dataEnd := dataStart + int(length)
+		part := all[dataStart:dataEnd]
+		crcPos := dataEnd
+		if crcPos+4 > len(all) {
+			return nil, fmt.Errorf("invalid chunk: no CRC found")
+		}
+		expectedCRC := binary.BigEndian.Uint32(all[crcPos : crcPos+4])
+		crcCalc := crc32.NewIEEE()
+		crcCalc.Write(chunkType)
+		crcCalc.Write(part)
+		if crcCalc.Sum32() != expectedCRC {
+			return nil, fmt.Errorf("CRC mismatch in 'rAWd' chunk %d", len(parts)+1)
+		}
+		parts = append(parts, part)
+
+		// Data too large for one PNG chunk continues in the next
+		chunkPos = crcPos + 4 + 4
+		if chunkPos+len(chunkType) > len(all) || !bytes.Equal(all[chunkPos:chunkPos+len(chunkType)], chunkType) {
+			if len(parts) == 1 {
+				return parts[0], nil
+			}
+			return bytes.Join(parts, nil), nil
+		}
+	}
+}
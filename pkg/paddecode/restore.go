@@ -0,0 +1,309 @@
+package paddecode
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// StreamMagic begins the stream that padlock encodes from a directory, and is
+// followed by a byte recording how the archive after it is compressed
+var StreamMagic = []byte("padlock-stream\x00\x01")
+
+// The compression recorded in the stream header
+const (
+	StreamCompressionNone = 0
+	StreamCompressionGzip = 1
+)
+
+// HybridMagic begins the stream that the collections of a hybrid encode hold
+// in place of the archive, which only the full padlock command decodes
+var HybridMagic = []byte("padlock-hybrid\x00\x01")
+
+// HashManifestRecord is the PAX record marking the hash manifest that padlock
+// appends to the archive, which is not one of the files restored
+const HashManifestRecord = "PADLOCK.hashes"
+
+// WriteArchive decodes the collections found in inputDir and writes the tar
+// archive they hold to w, decompressed
+func WriteArchive(ctx context.Context, inputDir string, w io.Writer) error {
+	return decodeArchive(ctx, inputDir, func(r io.Reader) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// Restore decodes the collections found in inputDir and restores the files of
+// the archive they hold into outputDir, which must be empty or not yet exist
+func Restore(ctx context.Context, inputDir string, outputDir string) error {
+	log := trace.FromContext(ctx).WithPrefix("RESTORE")
+
+	entries, err := os.ReadDir(outputDir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error(fmt.Errorf("failed to read output directory: %w", err))
+		return fmt.Errorf("failed to read output directory: %w", err)
+	}
+	if len(entries) > 0 {
+		log.Error(fmt.Errorf("%w: %s", ErrOutputNotEmpty, outputDir))
+		return fmt.Errorf("%w: %s", ErrOutputNotEmpty, outputDir)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Error(fmt.Errorf("failed to create output directory: %w", err))
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	return decodeArchive(ctx, inputDir, func(r io.Reader) error {
+		return extractArchive(ctx, r, outputDir)
+	})
+}
+
+// decodeArchive decodes the collections found in inputDir, handing the archive
+// they hold, decompressed, to consume as it is decoded
+func decodeArchive(ctx context.Context, inputDir string, consume func(io.Reader) error) error {
+	log := trace.FromContext(ctx).WithPrefix("DECODE")
+
+	collections, err := FindCollections(ctx, inputDir)
+	if err != nil {
+		return err
+	}
+	defer CloseCollections(collections)
+	readers := make([]io.Reader, len(collections))
+	for i, coll := range collections {
+		readers[i] = coll.Reader()
+	}
+	log.Infof("Decoding %d collections", len(collections))
+
+	// The archive is consumed as it is decoded
+	pr, pw := io.Pipe()
+	decoded := make(chan error, 1)
+	go func() {
+		var d Decoder
+		err := d.Decode(ctx, readers, pw)
+		pw.CloseWithError(err)
+		decoded <- err
+	}()
+
+	err = consumeStream(ctx, pr, consume)
+	if err == nil {
+		// Read past the end of the archive, to the end of the decoded stream
+		_, err = io.Copy(io.Discard, pr)
+	}
+	pr.CloseWithError(err)
+	decodeErr := <-decoded
+
+	// A decode that fails explains why the archive could not be read, and
+	// one that stops because the archive could not be read does not
+	if decodeErr != nil && (err == nil || !errors.Is(decodeErr, io.ErrClosedPipe)) {
+		log.Error(decodeErr)
+		return decodeErr
+	}
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// consumeStream reads the header of a decoded stream, if it has one, and hands
+// the archive after it to consume, decompressed
+func consumeStream(ctx context.Context, r io.Reader, consume func(io.Reader) error) error {
+	log := trace.FromContext(ctx).WithPrefix("DECODE")
+
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(HybridMagic)); bytes.Equal(magic, HybridMagic) {
+		return fmt.Errorf("%w: the collections are of a hybrid encode; decode them with padlock", ErrUnsupported)
+	}
+
+	// Streams encoded before the header was recorded are compressed if they
+	// begin as gzip does
+	compressed := false
+	header, _ := br.Peek(len(StreamMagic) + 1)
+	if len(header) > len(StreamMagic) && bytes.Equal(header[:len(StreamMagic)], StreamMagic) {
+		switch header[len(StreamMagic)] {
+		case StreamCompressionNone:
+		case StreamCompressionGzip:
+			compressed = true
+		default:
+			return fmt.Errorf("%w: decoded stream records unknown compression %d", ErrNotArchive, header[len(StreamMagic)])
+		}
+		if _, err := br.Discard(len(header)); err != nil {
+			return err
+		}
+	} else if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		compressed = true
+	}
+	log.Debugf("Decoded stream is compressed: %v", compressed)
+
+	if !compressed {
+		return consume(br)
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return fmt.Errorf("%w: failed to decompress the decoded stream: %w", ErrNotArchive, err)
+	}
+	defer zr.Close()
+	return consume(zr)
+}
+
+// extractArchive restores the entries of a tar archive into outputDir. Regular
+// files, directories, symlinks and hard links are restored with their
+// permissions and modification times; other entries, such as devices, are
+// skipped with a warning.
+func extractArchive(ctx context.Context, r io.Reader, outputDir string) error {
+	log := trace.FromContext(ctx).WithPrefix("RESTORE")
+
+	// Directories are given their modification times last, deepest first, as
+	// restoring into them changes them
+	type restoredDir struct {
+		path    string
+		mode    os.FileMode
+		modTime time.Time
+	}
+	var dirs []restoredDir
+	files := 0
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: tar header read error: %w", ErrNotArchive, err)
+		}
+		if header.PAXRecords[HashManifestRecord] != "" {
+			continue
+		}
+		target, err := SafeJoin(outputDir, header.Name)
+		if err != nil {
+			return err
+		}
+		if target == filepath.Clean(outputDir) {
+			continue
+		}
+		if err := CheckNoSymlinkParents(outputDir, target); err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeDir {
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", header.Name, err)
+			}
+		}
+
+		mode := os.FileMode(header.Mode) & os.ModePerm
+		switch header.Typeflag {
+		case tar.TypeDir:
+			// Its mode and times are set once every entry is restored, so it
+			// must not be a symlink left by an earlier entry
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				return fmt.Errorf("%w: refusing to extract directory %s over a symlink or file", ErrUnsafePath, header.Name)
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", header.Name, err)
+			}
+			dirs = append(dirs, restoredDir{path: target, mode: mode, modTime: header.ModTime})
+			continue
+		case tar.TypeReg, tar.TypeGNUSparse:
+			if err := writeFile(target, tr, mode); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", header.Name, err)
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return fmt.Errorf("failed to restore symlink %s: %w", header.Name, err)
+			}
+			files++
+			continue
+		case tar.TypeLink:
+			linkTarget, err := SafeJoin(outputDir, header.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := CheckNoSymlinkParents(outputDir, linkTarget); err != nil {
+				return err
+			}
+			if err := os.Link(linkTarget, target); err != nil {
+				return fmt.Errorf("failed to restore hard link %s: %w", header.Name, err)
+			}
+			files++
+			continue
+		default:
+			log.Infof("Warning: skipping %s, an entry of type %q that this decoder does not restore", header.Name, header.Typeflag)
+			continue
+		}
+		if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+			log.Infof("Warning: failed to set the modification time of %s: %v", header.Name, err)
+		}
+		files++
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		if err := os.Chmod(dir.path, dir.mode); err != nil {
+			log.Infof("Warning: failed to set the permissions of %s: %v", dir.path, err)
+		}
+		if err := os.Chtimes(dir.path, dir.modTime, dir.modTime); err != nil {
+			log.Infof("Warning: failed to set the modification time of %s: %v", dir.path, err)
+		}
+	}
+	log.Infof("Restored %d files and %d directories into %s", files, len(dirs), outputDir)
+	return nil
+}
+
+// writeFile writes a restored file, which must not already exist
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SafeJoin joins a tar entry name onto the output directory, rejecting names
+// that would resolve outside of it (absolute paths or ".." traversal).
+func SafeJoin(outputDir string, name string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: tar entry %q points outside of the output directory", ErrUnsafePath, name)
+	}
+	return filepath.Join(outputDir, cleaned), nil
+}
+
+// CheckNoSymlinkParents verifies that none of the existing directories between
+// root and path are symlinks, so that a crafted archive cannot use a previously
+// restored symlink to write outside of the output directory.
+func CheckNoSymlinkParents(root string, path string) error {
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil || rel == "." {
+		return err
+	}
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: refusing to extract %s through symlink %s", ErrUnsafePath, path, current)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package paddecode
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// tarEntry is an entry of an archive built for a test
+type tarEntry struct {
+	header *tar.Header
+	body   string
+}
+
+// buildArchive returns a tar archive of the entries
+func buildArchive(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		e.header.Size = int64(len(e.body))
+		if err := tw.WriteHeader(e.header); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatalf("Failed to write tar entry: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	archive := buildArchive(t, []tarEntry{
+		{header: &tar.Header{Typeflag: tar.TypeDir, Name: "sub/", Mode: 0750, ModTime: modTime}},
+		{header: &tar.Header{Typeflag: tar.TypeReg, Name: "sub/a.txt", Mode: 0600, ModTime: modTime}, body: "first file"},
+		{header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "sub/link", Linkname: "a.txt", ModTime: modTime}},
+		{header: &tar.Header{Typeflag: tar.TypeLink, Name: "hard.txt", Linkname: "sub/a.txt", ModTime: modTime}},
+		{header: &tar.Header{Typeflag: tar.TypeFifo, Name: "fifo", Mode: 0644, ModTime: modTime}},
+		{header: &tar.Header{Typeflag: tar.TypeReg, Name: ".padlock-sha256.json", Mode: 0644, ModTime: modTime,
+			PAXRecords: map[string]string{HashManifestRecord: "1"}}, body: `{"files":[]}`},
+	})
+
+	// restore restores the collections of a stream into a new directory
+	restore := func(t *testing.T, stream []byte) (string, error) {
+		inputDir, outputDir := t.TempDir(), filepath.Join(t.TempDir(), "output")
+		writeCollections(t, inputDir, stream, 256)
+		return outputDir, Restore(ctx, inputDir, outputDir)
+	}
+
+	// withHeader prefixes an archive with a stream header
+	withHeader := func(compression byte, data []byte) []byte {
+		return append(append(append([]byte{}, StreamMagic...), compression), data...)
+	}
+
+	// gzipped compresses data
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	for name, stream := range map[string][]byte{
+		"Uncompressed":      withHeader(StreamCompressionNone, archive),
+		"Compressed":        withHeader(StreamCompressionGzip, gzipped(archive)),
+		"Legacy compressed": gzipped(archive),
+	} {
+		t.Run(name, func(t *testing.T) {
+			outputDir, err := restore(t, stream)
+			if err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+			data, err := os.ReadFile(filepath.Join(outputDir, "hard.txt"))
+			if err != nil || string(data) != "first file" {
+				t.Errorf("Expected the hard link to hold the file, got %q, %v", data, err)
+			}
+			if target, err := os.Readlink(filepath.Join(outputDir, "sub", "link")); err != nil || target != "a.txt" {
+				t.Errorf("Expected the symlink to a.txt, got %q, %v", target, err)
+			}
+			info, err := os.Stat(filepath.Join(outputDir, "sub", "a.txt"))
+			if err != nil || info.Mode().Perm() != 0600 || !info.ModTime().Equal(modTime) {
+				t.Errorf("Expected mode 0600 and time %v, got %v", modTime, info)
+			}
+			if info, err := os.Stat(filepath.Join(outputDir, "sub")); err != nil || info.Mode().Perm() != 0750 || !info.ModTime().Equal(modTime) {
+				t.Errorf("Expected directory mode 0750 and time %v, got %v", modTime, info)
+			}
+			for _, name := range []string{"fifo", ".padlock-sha256.json"} {
+				if _, err := os.Lstat(filepath.Join(outputDir, name)); !os.IsNotExist(err) {
+					t.Errorf("Expected %s not to be restored, got %v", name, err)
+				}
+			}
+		})
+	}
+
+	t.Run("Archive", func(t *testing.T) {
+		inputDir := t.TempDir()
+		writeCollections(t, inputDir, withHeader(StreamCompressionGzip, gzipped(archive)), 256)
+		var output bytes.Buffer
+		if err := WriteArchive(ctx, inputDir, &output); err != nil {
+			t.Fatalf("WriteArchive failed: %v", err)
+		}
+		if !bytes.Equal(output.Bytes(), archive) {
+			t.Errorf("Expected the archive, got %d bytes", output.Len())
+		}
+	})
+
+	t.Run("Unsafe path", func(t *testing.T) {
+		// A directory outside the output directory, whose mode no entry may change
+		outside := t.TempDir()
+		if err := os.Chmod(outside, 0755); err != nil {
+			t.Fatalf("Failed to set the mode of %s: %v", outside, err)
+		}
+		for _, entries := range [][]tarEntry{
+			{{header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "d", Linkname: outside}},
+				{header: &tar.Header{Typeflag: tar.TypeDir, Name: "d/", Mode: 0700, ModTime: time.Unix(0, 0)}}},
+			{{header: &tar.Header{Typeflag: tar.TypeReg, Name: "../escape.txt", Mode: 0644}, body: "x"}},
+			{{header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "out", Linkname: "/tmp"}},
+				{header: &tar.Header{Typeflag: tar.TypeReg, Name: "out/escape.txt", Mode: 0644}, body: "x"}},
+		} {
+			if _, err := restore(t, withHeader(StreamCompressionNone, buildArchive(t, entries))); !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("Expected ErrUnsafePath, got %v", err)
+			}
+		}
+		if info, err := os.Stat(outside); err != nil || info.Mode().Perm() != 0755 || info.ModTime().Unix() == 0 {
+			t.Errorf("Expected the directory outside the output directory to be unchanged, got %v (%v)", info.Mode().Perm(), err)
+		}
+	})
+
+	t.Run("Not an archive", func(t *testing.T) {
+		if _, err := restore(t, withHeader(StreamCompressionNone, bytes.Repeat([]byte("not a tar archive"), 64))); !errors.Is(err, ErrNotArchive) {
+			t.Errorf("Expected ErrNotArchive, got %v", err)
+		}
+	})
+
+	t.Run("Hybrid", func(t *testing.T) {
+		if _, err := restore(t, append(append([]byte{}, HybridMagic...), "key record"...)); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("Expected ErrUnsupported, got %v", err)
+		}
+	})
+
+	t.Run("Output not empty", func(t *testing.T) {
+		inputDir, outputDir := t.TempDir(), t.TempDir()
+		writeCollections(t, inputDir, withHeader(StreamCompressionNone, archive), 256)
+		if err := os.WriteFile(filepath.Join(outputDir, "existing"), nil, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := Restore(ctx, inputDir, outputDir); !errors.Is(err, ErrOutputNotEmpty) {
+			t.Errorf("Expected ErrOutputNotEmpty, got %v", err)
+		}
+	})
+}
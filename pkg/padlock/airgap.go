@@ -0,0 +1,175 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// PadsConfig holds configuration parameters for generating the pads of an
+// air-gapped encode. This structure is created by the command-line interface
+// and passed to GeneratePads.
+type PadsConfig struct {
+	OutputDir       string           // Path where the pad collections will be created
+	N               int              // Total number of collections of the encode (N value)
+	K               int              // Minimum collections required for reconstruction (K value)
+	Format          Format           // Format of the pad collections (binary or PNG)
+	ChunkNaming     file.ChunkNaming // How chunk files are named, or the zero value for the format's default
+	ChunkSize       int              // Size of each chunk of each collection, in bytes
+	Size            int64            // Bytes of serialized input the pads must be able to encode
+	RNG             pad.RNG          // Random number generator for the pads
+	ClearIfNotEmpty bool             // Whether to clear the output directory if not empty
+	Verbose         bool             // Enable verbose logging
+}
+
+// GeneratePads writes the N pad collections of an air-gapped encode to the
+// output directory, with a manifest recording their parameters. The pads are
+// all the random data of the encode, generated here so that a machine that
+// has a trusted random source but never sees the input can produce them; an
+// encode given the pads with EncodeConfig.PadsDir then needs no random source.
+//
+// Pads are one-time pads: they must be applied to one input only, and must be
+// destroyed once applied, as with the collections they produce they reveal
+// the input.
+func GeneratePads(ctx context.Context, cfg PadsConfig) error {
+	ctx, op := trace.StartOperation(ctx, "generate pads")
+	defer op.End()
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	start := time.Now()
+	log.Infof("Starting pad generation: OutputDir=%s Size=%d", cfg.OutputDir, cfg.Size)
+
+	if cfg.OutputDir == "" || cfg.RNG == nil {
+		log.Error(fmt.Errorf("%w: pads require an output directory and a random number generator", ErrInvalidConfig))
+		return fmt.Errorf("%w: pads require an output directory and a random number generator", ErrInvalidConfig)
+	}
+	if err := pad.CheckOffline(ctx, cfg.RNG); err != nil {
+		log.Error(err)
+		return err
+	}
+	if cfg.Size <= 0 {
+		log.Error(fmt.Errorf("%w: the size of the input the pads are for must be positive, got %d", ErrInvalidConfig, cfg.Size))
+		return fmt.Errorf("%w: the size of the input the pads are for must be positive, got %d", ErrInvalidConfig, cfg.Size)
+	}
+	if cfg.Format != FormatBin && cfg.Format != FormatPNG {
+		log.Error(fmt.Errorf("%w: format must be %s or %s, got %q", ErrInvalidConfig, FormatBin, FormatPNG, cfg.Format))
+		return fmt.Errorf("%w: format must be %s or %s, got %q", ErrInvalidConfig, FormatBin, FormatPNG, cfg.Format)
+	}
+	if err := cfg.ChunkNaming.CheckFormat(cfg.Format); err != nil {
+		log.Error(fmt.Errorf("%w: %w", ErrInvalidConfig, err))
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	p, err := pad.NewPadForEncode(ctx, cfg.N, cfg.K)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create pad instance: %w", err))
+		return err
+	}
+	if cfg.ChunkSize < p.MinChunkSize() {
+		log.Error(fmt.Errorf("%w: chunks of %d bytes are too small for the %d pieces of each chunk", ErrInvalidConfig, cfg.ChunkSize, p.PermutationCount))
+		return fmt.Errorf("%w: chunks of %d bytes are too small for the %d pieces of each chunk", ErrInvalidConfig, cfg.ChunkSize, p.PermutationCount)
+	}
+	inputChunkBytes := cfg.ChunkSize / p.PermutationCount
+	chunks := int((cfg.Size + int64(inputChunkBytes) - 1) / int64(inputChunkBytes))
+
+	if err := file.PrepareOutputDirectory(ctx, cfg.OutputDir, cfg.ClearIfNotEmpty); err != nil {
+		return err
+	}
+	collections, err := file.CreateCollections(ctx, cfg.OutputDir, p.Collections)
+	if err != nil {
+		return err
+	}
+	paths := make(map[string]string, len(collections))
+	for _, coll := range collections {
+		paths[coll.Name] = coll.Path
+	}
+	formatter := file.GetNamedFormatter(cfg.Format, cfg.ChunkNaming)
+	newChunkFunc := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		collPath, ok := paths[collectionName]
+		if !ok {
+			return nil, fmt.Errorf("collection not found: %s", collectionName)
+		}
+		return file.NewChunkWriter(ctx, formatter, collPath, 0, chunkNumber), nil
+	}
+
+	if err := p.GeneratePads(ctx, chunks, inputChunkBytes, cfg.RNG, newChunkFunc, string(cfg.Format)); err != nil {
+		log.Error(fmt.Errorf("pad generation failed: %w", err))
+		return outputFullError(fmt.Errorf("pad generation failed: %w", err))
+	}
+	m := file.PadsManifest{
+		Required:  cfg.K,
+		Copies:    cfg.N,
+		ChunkSize: cfg.ChunkSize,
+		Chunks:    chunks,
+		Capacity:  int64(chunks) * int64(inputChunkBytes),
+		Created:   time.Now().UTC(),
+	}
+	if err := file.WritePadsManifest(ctx, cfg.OutputDir, m); err != nil {
+		return err
+	}
+
+	log.Infof("Pad generation complete (%s): %d collections of %d chunks, for up to %d bytes of input", time.Since(start), len(collections), chunks, m.Capacity)
+	return nil
+}
+
+// readPads reads the manifest of the pads of an encode, checking that they
+// are for the set being encoded and have not been used before
+func readPads(ctx context.Context, cfg EncodeConfig) (*file.PadsManifest, error) {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	m, err := file.ReadPadsManifest(ctx, cfg.PadsDir)
+	if err != nil {
+		return nil, err
+	}
+	if m.Used != nil {
+		log.Error(fmt.Errorf("%w: the pads in %s were already used at %s, and must never be used again", ErrInvalidConfig, cfg.PadsDir, m.Used.Format(time.RFC3339)))
+		return nil, fmt.Errorf("%w: the pads in %s were already used at %s, and must never be used again", ErrInvalidConfig, cfg.PadsDir, m.Used.Format(time.RFC3339))
+	}
+	if m.Copies != cfg.N || m.Required != cfg.K {
+		log.Error(fmt.Errorf("%w: the pads in %s are for %d-of-%d collections, not %d-of-%d", ErrInvalidConfig, cfg.PadsDir, m.Required, m.Copies, cfg.K, cfg.N))
+		return nil, fmt.Errorf("%w: the pads in %s are for %d-of-%d collections, not %d-of-%d", ErrInvalidConfig, cfg.PadsDir, m.Required, m.Copies, cfg.K, cfg.N)
+	}
+	return m, nil
+}
+
+// applyPads encodes the input with the pads in cfg.PadsDir, writing the
+// collections through newChunk. The pads are recorded as used before they
+// are applied, so that even a failed encode never leaves them to be reused.
+func applyPads(ctx context.Context, cfg EncodeConfig, p *pad.Pad, input io.Reader, newChunk pad.NewChunkFunc) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	m, err := readPads(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	pads, tempDir, err := file.FindCollections(ctx, cfg.PadsDir)
+	if err != nil {
+		return err
+	}
+	defer file.CloseCollections(pads)
+	defer file.RemoveTemp(ctx, tempDir)
+	if len(pads) == 0 {
+		log.Error(fmt.Errorf("%w in pads directory", ErrNoCollections))
+		return fmt.Errorf("%w in pads directory", ErrNoCollections)
+	}
+
+	used := time.Now().UTC()
+	m.Used = &used
+	if err := file.WritePadsManifest(ctx, cfg.PadsDir, *m); err != nil {
+		log.Infof("Warning: could not record that the pads in %s are used; destroy them once the encode is complete, as they must never be used again", cfg.PadsDir)
+	}
+
+	readers := make([]io.Reader, len(pads))
+	for i, coll := range pads {
+		readers[i] = file.NewChunkReaderAdapter(ctx, file.NewCollectionReader(coll))
+	}
+	log.Debugf("Applying %d pad collections, for up to %d bytes of input", len(pads), m.Capacity)
+	if err := p.ApplyPads(ctx, readers, input, newChunk, string(cfg.Format)); err != nil {
+		return err
+	}
+	log.Infof("Applied the pads in %s; destroy them, as together with the collections they reveal the input", cfg.PadsDir)
+	return nil
+}
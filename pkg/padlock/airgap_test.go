@@ -0,0 +1,127 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestAirGappedEncode(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "padlock-airgap-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input directory: %v", err)
+	}
+	testContent := strings.Repeat("air-gapped content\n", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// generate writes pads for a 2-of-3 set able to encode size bytes
+	generate := func(name string, size int64) string {
+		padsDir := filepath.Join(tempDir, name)
+		padsConfig := PadsConfig{
+			OutputDir: padsDir,
+			N:         3,
+			K:         2,
+			Format:    FormatBin,
+			ChunkSize: 1024,
+			Size:      size,
+			RNG:       pad.NewDefaultRand(ctx),
+		}
+		if err := GeneratePads(ctx, padsConfig); err != nil {
+			t.Fatalf("Failed to generate pads: %v", err)
+		}
+		return padsDir
+	}
+
+	// encodeConfig encodes the input with the given pads, without any random source
+	encodeConfig := func(padsDir string, outputDir string) EncodeConfig {
+		return EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   filepath.Join(tempDir, outputDir),
+			N:           3,
+			K:           2,
+			Format:      FormatPNG,
+			Compression: CompressionNone,
+			PadsDir:     padsDir,
+		}
+	}
+
+	t.Run("Encode and decode", func(t *testing.T) {
+		padsDir := generate("pads", 20000)
+		m, err := file.ReadPadsManifest(ctx, padsDir)
+		if err != nil {
+			t.Fatalf("Failed to read pads manifest: %v", err)
+		}
+		if m.Copies != 3 || m.Required != 2 || m.ChunkSize != 1024 || m.Capacity < 20000 || m.Used != nil {
+			t.Errorf("Unexpected pads manifest: %+v", m)
+		}
+
+		if err := EncodeDirectory(ctx, encodeConfig(padsDir, "output")); err != nil {
+			t.Fatalf("Failed to encode with pads: %v", err)
+		}
+		if m, err := file.ReadPadsManifest(ctx, padsDir); err != nil || m.Used == nil {
+			t.Errorf("Pads were not recorded as used (%v)", err)
+		}
+
+		// Any two collections restore the original data
+		subsetDir := filepath.Join(tempDir, "subset")
+		if err := os.MkdirAll(subsetDir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", subsetDir, err)
+		}
+		for _, coll := range []string{"2A3", "2C3"} {
+			if err := os.Rename(filepath.Join(tempDir, "output", coll), filepath.Join(subsetDir, coll)); err != nil {
+				t.Fatalf("Failed to move collection %s: %v", coll, err)
+			}
+		}
+		restoreDir := filepath.Join(tempDir, "restore")
+		if err := DecodeDirectory(ctx, DecodeConfig{InputDir: subsetDir, OutputDir: restoreDir}); err != nil {
+			t.Fatalf("Failed to decode collections: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(restoreDir, "data.txt"))
+		if err != nil || string(data) != testContent {
+			t.Errorf("Restored data does not match the original (%v)", err)
+		}
+
+		// Pads are never used twice
+		if err := EncodeDirectory(ctx, encodeConfig(padsDir, "again")); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig reusing pads, got %v", err)
+		}
+	})
+
+	t.Run("Pads too small", func(t *testing.T) {
+		padsDir := generate("small", 1000)
+		if err := EncodeDirectory(ctx, encodeConfig(padsDir, "small-output")); !errors.Is(err, ErrPadsTooSmall) {
+			t.Errorf("Expected ErrPadsTooSmall, got %v", err)
+		}
+	})
+
+	t.Run("Pads for another set", func(t *testing.T) {
+		padsDir := generate("other", 20000)
+		cfg := encodeConfig(padsDir, "other-output")
+		cfg.N = 4
+		if err := EncodeDirectory(ctx, cfg); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig, got %v", err)
+		}
+		if m, err := file.ReadPadsManifest(ctx, padsDir); err != nil || m.Used != nil {
+			t.Errorf("Pads that were not applied were recorded as used (%v)", err)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/audit"
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// AuditLogName is the name of the audit log kept alongside an output directory
+// when no other path is configured
+const AuditLogName = "padlock-audit.jsonl"
+
+// DefaultAuditLogPath returns the path of the audit log kept alongside the
+// output directory, in its parent, so that it is shared by every operation
+// writing there and does not mingle with the collections or restored files
+func DefaultAuditLogPath(outputDir string) string {
+	return filepath.Join(filepath.Dir(filepath.Clean(outputDir)), AuditLogName)
+}
+
+// auditEncode records an encode in cfg.Audit, returning the error of the encode
+// or, if it succeeded, any failure to record it
+func auditEncode(ctx context.Context, cfg EncodeConfig, encodeErr error) error {
+	params := map[string]string{
+		"input":       cfg.InputDir,
+		"format":      string(cfg.Format),
+		"chunk":       strconv.Itoa(cfg.ChunkSize),
+		"compression": compressionName(cfg.Compression),
+	}
+	switch {
+	case len(cfg.Groups) > 0:
+		params["groups"] = groupPolicySpec(cfg.Groups)
+	case len(cfg.Custodians) > 0:
+		params["custodians"] = custodianSpec(cfg.Custodians)
+		params["required"] = strconv.Itoa(cfg.K)
+	default:
+		params["copies"] = strconv.Itoa(cfg.N)
+		params["required"] = strconv.Itoa(cfg.K)
+	}
+	if len(cfg.Targets) > 0 {
+		params["targets"] = strings.Join(cfg.Targets, ",")
+	} else {
+		params["output"] = cfg.OutputDir
+	}
+	if len(cfg.Destinations) > 0 {
+		params["destinations"] = destinationSpec(cfg.Destinations)
+	}
+	if cfg.ZipCollections {
+		params["zip"] = "true"
+	}
+	if cfg.TarCollections {
+		params["tgz"] = "true"
+	}
+	if cfg.ObfuscateNames {
+		params["obfuscate"] = "true"
+	}
+	if cfg.StoreDir != "" {
+		params["store"] = cfg.StoreDir
+	}
+	if cfg.ChunkNaming.Digits > 0 {
+		params["chunk-names"] = cfg.ChunkNaming.String()
+	}
+	if cfg.VolumeSize > 0 {
+		params["volume"] = strconv.FormatInt(cfg.VolumeSize, 10)
+	}
+	if cfg.ParityPercent > 0 {
+		params["parity"] = strconv.Itoa(cfg.ParityPercent)
+	}
+	if cfg.PadLength {
+		params["pad"] = strconv.Itoa(cfg.PadChunks)
+	}
+	if cfg.DecoyDir != "" {
+		params["decoy"] = cfg.DecoyDir
+		params["decoy-collections"] = strings.ToUpper(cfg.DecoyLetters)
+	}
+
+	rec := audit.Record{Operation: audit.OpEncode, Params: params}
+	if len(cfg.Inputs) > 0 {
+		// The files of each input are recorded under the name it is archived as
+		params["input"] = strings.Join(cfg.Inputs, ",")
+		names, err := file.InputNames(cfg.Inputs)
+		if err != nil {
+			return recordAudit(ctx, cfg.Audit, rec, encodeErr, err)
+		}
+		for i, input := range cfg.Inputs {
+			inputs, err := audit.HashTree(input)
+			if err != nil {
+				return recordAudit(ctx, cfg.Audit, rec, encodeErr, err)
+			}
+			for _, h := range inputs {
+				h.Path = path.Join(names[i], h.Path)
+				rec.Inputs = append(rec.Inputs, h)
+			}
+		}
+	} else {
+		inputs, err := audit.HashTree(cfg.InputDir)
+		if err != nil {
+			return recordAudit(ctx, cfg.Audit, rec, encodeErr, err)
+		}
+		rec.Inputs = inputs
+	}
+	if cfg.DecoyDir != "" {
+		decoyInputs, err := audit.HashTree(cfg.DecoyDir)
+		if err != nil {
+			return recordAudit(ctx, cfg.Audit, rec, encodeErr, err)
+		}
+		for _, h := range decoyInputs {
+			h.Path = path.Join(filepath.ToSlash(cfg.DecoyDir), h.Path)
+			rec.Inputs = append(rec.Inputs, h)
+		}
+	}
+	if encodeErr == nil {
+		// Files on targets or destinations are identified by the directory
+		// they were written to
+		roots := []string{cfg.OutputDir}
+		if len(cfg.Targets) > 0 {
+			roots = cfg.Targets
+		} else if len(cfg.Destinations) > 0 {
+			roots = destinationRoots(cfg)
+		}
+		for _, root := range roots {
+			outputs, err := audit.HashTree(root)
+			if err != nil {
+				return recordAudit(ctx, cfg.Audit, rec, encodeErr, err)
+			}
+			for _, h := range outputs {
+				if len(cfg.Targets) > 0 || len(cfg.Destinations) > 0 {
+					h.Path = path.Join(filepath.ToSlash(root), h.Path)
+				}
+				rec.Outputs = append(rec.Outputs, h)
+			}
+		}
+	}
+	return recordAudit(ctx, cfg.Audit, rec, encodeErr, nil)
+}
+
+// auditDecode records a decode in cfg.Audit, returning the error of the decode
+// or, if it succeeded, any failure to record it
+func auditDecode(ctx context.Context, cfg DecodeConfig, decodeErr error) error {
+	params := map[string]string{
+		"input":       cfg.InputDir,
+		"compression": compressionName(cfg.Compression),
+	}
+	if cfg.OutputWriter != nil {
+		params["output"] = "stdout"
+	} else {
+		params["output"] = cfg.OutputDir
+	}
+	if cfg.Strict {
+		params["strict"] = "true"
+	}
+	if len(cfg.Deserialize.Files) > 0 {
+		params["files"] = strings.Join(cfg.Deserialize.Files, ",")
+	}
+
+	// The collections are recorded even when they fail to decode. Those found
+	// elsewhere are identified by where they were found.
+	rec := audit.Record{Operation: audit.OpDecode, Params: params}
+	roots := []string{cfg.InputDir}
+	if len(cfg.Collections) > 0 {
+		// The collections of a zipped custodian bundle share its path
+		roots = nil
+		seen := make(map[string]bool)
+		for _, coll := range cfg.Collections {
+			paths := coll.Volumes
+			if len(paths) == 0 {
+				paths = []string{coll.Path}
+			}
+			for _, p := range paths {
+				if !seen[p] {
+					seen[p] = true
+					roots = append(roots, p)
+				}
+			}
+		}
+	}
+	for _, root := range roots {
+		inputs, err := audit.HashTree(root)
+		if err != nil {
+			return recordAudit(ctx, cfg.Audit, rec, decodeErr, err)
+		}
+		for _, h := range inputs {
+			if len(cfg.Collections) > 0 {
+				h.Path = path.Join(filepath.ToSlash(root), h.Path)
+			}
+			rec.Inputs = append(rec.Inputs, h)
+		}
+	}
+	if decodeErr == nil && cfg.OutputWriter == nil {
+		outputs, err := audit.HashTree(cfg.OutputDir)
+		if err != nil {
+			return recordAudit(ctx, cfg.Audit, rec, decodeErr, err)
+		}
+		rec.Outputs = outputs
+	}
+	return recordAudit(ctx, cfg.Audit, rec, decodeErr, nil)
+}
+
+// recordAudit completes a record with the outcome of its operation and appends
+// it to the log. A failure to hash the operation's files (hashErr) or to write
+// the record fails an operation that otherwise succeeded, since an operation
+// that must be audited but can't be is not complete.
+func recordAudit(ctx context.Context, l *audit.Log, rec audit.Record, opErr, hashErr error) error {
+	log := trace.FromContext(ctx).WithPrefix("AUDIT")
+
+	rec.Session = audit.NewSession()
+	rec.Result = "ok"
+	switch {
+	case opErr != nil:
+		rec.Result, rec.Error = "error", opErr.Error()
+	case hashErr != nil:
+		rec.Result, rec.Error = "error", hashErr.Error()
+	}
+	if err := l.Append(rec); err != nil {
+		log.Error(fmt.Errorf("failed to record %s in audit log: %w", rec.Operation, err))
+		if opErr != nil {
+			return opErr
+		}
+		return fmt.Errorf("failed to record %s in audit log: %w", rec.Operation, err)
+	}
+	log.Debugf("Recorded %s session %s in %s", rec.Operation, rec.Session, l.Path())
+	if opErr == nil && hashErr != nil {
+		log.Error(fmt.Errorf("failed to audit %s: %w", rec.Operation, hashErr))
+		return fmt.Errorf("failed to audit %s: %w", rec.Operation, hashErr)
+	}
+	return opErr
+}
+
+// compressionName names a compression mode in the audit log
+func compressionName(c Compression) string {
+	if c == CompressionGzip {
+		return "gzip"
+	}
+	return "none"
+}
@@ -0,0 +1,108 @@
+package padlock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/audit"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestAuditEncodeDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-audit-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	encodeOutputDir := filepath.Join(tempDir, "encoded")
+	decodeOutputDir := filepath.Join(tempDir, "decoded")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "secret.txt"), []byte("audited secret"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	logPath := DefaultAuditLogPath(encodeOutputDir + "/")
+	if logPath != filepath.Join(tempDir, AuditLogName) {
+		t.Errorf("Expected the audit log alongside the output directory, got %s", logPath)
+	}
+	auditLog, err := audit.Open(logPath)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	err = EncodeDirectory(ctx, EncodeConfig{
+		InputDir:    inputDir,
+		OutputDir:   encodeOutputDir,
+		N:           3,
+		K:           2,
+		Format:      FormatBin,
+		ChunkSize:   1024,
+		RNG:         pad.NewDefaultRand(ctx),
+		Compression: CompressionGzip,
+		Audit:       auditLog,
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+	os.RemoveAll(filepath.Join(encodeOutputDir, "2B3"))
+	decodeConfig := DecodeConfig{
+		InputDir:    encodeOutputDir,
+		OutputDir:   decodeOutputDir,
+		Compression: CompressionGzip,
+		Audit:       auditLog,
+	}
+	if err := DecodeDirectory(ctx, decodeConfig); err != nil {
+		t.Fatalf("Failed to decode directory: %v", err)
+	}
+	// A second decode fails because the output is no longer empty, and is audited too
+	if err := DecodeDirectory(ctx, decodeConfig); err == nil {
+		t.Fatalf("Expected decode into a non-empty directory to fail")
+	}
+
+	records, err := audit.VerifyFile(logPath)
+	if err != nil {
+		t.Fatalf("Audit log does not verify: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	enc, dec, failed := records[0], records[1], records[2]
+	if enc.Operation != audit.OpEncode || enc.Result != "ok" || enc.Params["copies"] != "3" || enc.Params["required"] != "2" {
+		t.Errorf("Unexpected encode record: %+v", enc)
+	}
+	if dec.Operation != audit.OpDecode || dec.Result != "ok" || failed.Result != "error" || failed.Error == "" {
+		t.Errorf("Unexpected decode records: %+v %+v", dec, failed)
+	}
+	if enc.Session == dec.Session {
+		t.Errorf("Expected each operation to have its own session")
+	}
+
+	// The collections a decode read are among those the encode wrote, and the
+	// files it restored are those the encode read
+	written := make(map[audit.FileHash]bool)
+	for _, h := range enc.Outputs {
+		written[h] = true
+	}
+	if len(enc.Outputs) == 0 || len(dec.Inputs) == 0 || len(dec.Inputs) >= len(enc.Outputs) {
+		t.Fatalf("Expected the decode to read some of the %d files written, read %d", len(enc.Outputs), len(dec.Inputs))
+	}
+	for _, h := range dec.Inputs {
+		if !written[h] {
+			t.Errorf("Decode read %s, which the encode did not write", h.Path)
+		}
+	}
+	if len(enc.Inputs) != 1 || len(dec.Outputs) != 1 || enc.Inputs[0] != dec.Outputs[0] {
+		t.Errorf("Expected the restored file to match the encoded one: %+v %+v", enc.Inputs, dec.Outputs)
+	}
+}
@@ -0,0 +1,333 @@
+package padlock
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/audit"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// CeremonyRecordKind identifies a ceremony record among other JSON files
+const CeremonyRecordKind = "padlock-ceremony"
+
+// CeremonyConfig holds the configuration of a key ceremony, in which the
+// shares of a set are generated in front of their custodians, each of whom
+// confirms receipt of theirs. This structure is created by the command-line
+// interface and passed to RunCeremony.
+type CeremonyConfig struct {
+	Encode     EncodeConfig       // The encode of the ceremony, which must give Custodians and an RNG
+	RecordPath string             // Where the signed ceremony record is written (default: DefaultCeremonyRecordPath)
+	SigningKey ed25519.PrivateKey // Key signing the record; if nil, a key is generated for the ceremony, to be noted by its fingerprint
+	Input      io.Reader          // Where the custodians' confirmations are read
+	Output     io.Writer          // Where the steps of the ceremony and the questions are written
+}
+
+// CeremonyCustodian is what a ceremony record holds of one custodian: the
+// bundle they received and when they confirmed receipt of it
+type CeremonyCustodian struct {
+	Name        string   `json:"name"`
+	Collections []string `json:"collections"` // Collections in the custodian's bundle
+	Bundle      string   `json:"bundle"`      // Name of the bundle, e.g. "ceo.zip"
+	SHA256      string   `json:"sha256"`      // Verification hash of the bundle (see bundleHash)
+	Code        string   `json:"code"`        // Short code derived from the hash, which the custodian entered
+	Confirmed   string   `json:"confirmed"`   // When the custodian confirmed receipt, RFC 3339 UTC
+}
+
+// CeremonyRecord is the signed record of a key ceremony. It is signed with
+// Ed25519 over its JSON encoding with Signature empty.
+type CeremonyRecord struct {
+	Kind       string              `json:"kind"`     // CeremonyRecordKind
+	Started    string              `json:"started"`  // When the ceremony started, RFC 3339 UTC
+	Finished   string              `json:"finished"` // When the last custodian confirmed receipt, RFC 3339 UTC
+	Required   int                 `json:"required"`
+	Copies     int                 `json:"copies"`
+	Format     string              `json:"format"`
+	Health     []pad.HealthResult  `json:"health"` // Results of the health tests of the random number generator
+	Custodians []CeremonyCustodian `json:"custodians"`
+	PublicKey  string              `json:"publicKey"` // Ed25519 public key of the signature, base64
+	Signature  string              `json:"signature"` // Ed25519 signature, base64
+}
+
+// signedBytes returns the bytes of a record that its signature covers
+func (r CeremonyRecord) signedBytes() ([]byte, error) {
+	r.Signature = ""
+	return json.Marshal(r)
+}
+
+// DefaultCeremonyRecordPath returns the path of the record of a ceremony that
+// started at the given time, kept alongside its output directory like the
+// audit log, e.g. padlock-ceremony-20261017-093000.json
+func DefaultCeremonyRecordPath(outputDir string, started time.Time) string {
+	return filepath.Join(filepath.Dir(filepath.Clean(outputDir)), CeremonyRecordKind+"-"+started.Format("20060102-150405")+".json")
+}
+
+// ReadSigningKey reads an Ed25519 private key in PKCS #8 PEM form, such as
+// one generated by "openssl genpkey -algorithm ed25519"
+func ReadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s is not a PEM file", ErrInvalidConfig, path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid signing key %s: %w", ErrInvalidConfig, path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: signing key %s is not an Ed25519 key", ErrInvalidConfig, path)
+	}
+	return edKey, nil
+}
+
+// KeyFingerprint returns a short fingerprint of a public key, to be noted down
+// and compared when a record signed with it is verified
+func KeyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	h := hex.EncodeToString(sum[:8])
+	return h[0:4] + ":" + h[4:8] + ":" + h[8:12] + ":" + h[12:16]
+}
+
+// verificationCode derives a short code from a hash, of eight characters of
+// FriendlyLabelAlphabet in two groups, e.g. "K7QM-3XPA", which is easily read
+// out and typed back without confusing I and 1 or O and 0
+func verificationCode(sum []byte) string {
+	var b strings.Builder
+	var bits uint64
+	for _, c := range sum[:5] {
+		bits = bits<<8 | uint64(c)
+	}
+	for i := 0; i < 8; i++ {
+		if i == 4 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(FriendlyLabelAlphabet[(bits>>(35-5*i))&31])
+	}
+	return b.String()
+}
+
+// sameCode reports whether a code was typed as given, ignoring case, spaces
+// and dashes
+func sameCode(typed, code string) bool {
+	normalize := func(s string) string {
+		return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(s))
+	}
+	return normalize(typed) == normalize(code)
+}
+
+// bundleHash returns the verification hash of a custodian's bundle, a file or
+// a directory: the SHA-256 of a listing of the path, size and SHA-256 of each
+// of its files
+func bundleHash(path string) ([]byte, error) {
+	hashes, err := audit.HashTree(path)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	for _, fh := range hashes {
+		fmt.Fprintf(h, "%s %d %s\n", fh.Path, fh.Size, fh.SHA256)
+	}
+	return h.Sum(nil), nil
+}
+
+// findBundle returns the path of the bundle written for a custodian, which is
+// zipped, archived or left as a directory
+func findBundle(outputDir, custodian string) (string, error) {
+	for _, name := range []string{custodian + ".zip", custodian + ".tar.gz", custodian} {
+		path := filepath.Join(outputDir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no bundle found for custodian %s in %s", custodian, outputDir)
+}
+
+// RunCeremony guides an in-person key ceremony. It checks the health of the
+// random number generator, encodes the input into one bundle per custodian,
+// and then, custodian by custodian, shows the verification hash of their
+// bundle and a short code derived from it, which the custodian copies onto
+// their receipt and types back to confirm receipt. Once every custodian has
+// confirmed, it writes a record of the ceremony signed with cfg.SigningKey, or
+// a key generated for the ceremony whose fingerprint is shown to be noted down
+// with the record, and returns it. A custodian who gives up on confirming
+// abandons the ceremony, whose bundles should then be destroyed.
+func RunCeremony(ctx context.Context, cfg CeremonyConfig) (*CeremonyRecord, error) {
+	log := trace.FromContext(ctx).WithPrefix("CEREMONY")
+	in := bufio.NewScanner(cfg.Input)
+	out := cfg.Output
+	enc := cfg.Encode
+
+	if len(enc.Custodians) == 0 || enc.RNG == nil || enc.PadsDir != "" || len(enc.Targets) > 0 || enc.OutputDir == "" {
+		log.Error(fmt.Errorf("%w: a ceremony needs custodians, an output directory and a random number generator, not pads or targets", ErrInvalidConfig))
+		return nil, fmt.Errorf("%w: a ceremony needs custodians, an output directory and a random number generator, not pads or targets", ErrInvalidConfig)
+	}
+	if err := enc.Validate(ctx); err != nil {
+		return nil, err
+	}
+	started := time.Now().UTC()
+	record := &CeremonyRecord{
+		Kind:     CeremonyRecordKind,
+		Started:  started.Format(time.RFC3339),
+		Required: enc.K,
+		Copies:   custodianCopies(enc.Custodians),
+		Format:   string(enc.Format),
+	}
+	recordPath := cfg.RecordPath
+	if recordPath == "" {
+		recordPath = DefaultCeremonyRecordPath(enc.OutputDir, started)
+	}
+
+	// Refuse a random number generator that fails its health tests
+	fmt.Fprintf(out, "Checking the health of the random number generator...\n")
+	health, err := pad.CheckHealth(ctx, enc.RNG, 0)
+	for _, r := range health {
+		fmt.Fprintf(out, "  %s\n", r)
+	}
+	record.Health = health
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	// Generate the shares, one bundle per custodian
+	fmt.Fprintf(out, "Generating %d-of-%d shares for %d custodians...\n", record.Required, record.Copies, len(enc.Custodians))
+	if err := EncodeDirectory(ctx, enc); err != nil {
+		return nil, err
+	}
+
+	// Each custodian confirms receipt of their bundle with its code
+	assignment := custodianAssignment(enc.Custodians, enc.K)
+	for _, c := range enc.Custodians {
+		bundle, err := findBundle(enc.OutputDir, c.Name)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		sum, err := bundleHash(bundle)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		code := verificationCode(sum)
+		fmt.Fprintf(out, "\nCustodian %s: collections %s\n", c.Name, strings.Join(assignment[c.Name], ", "))
+		fmt.Fprintf(out, "  Bundle:  %s\n", bundle)
+		fmt.Fprintf(out, "  SHA-256: %s\n", hex.EncodeToString(sum))
+		fmt.Fprintf(out, "  Code:    %s\n", code)
+		for {
+			fmt.Fprintf(out, "%s, copy the hash and code onto your receipt, take the bundle, and type the code to confirm receipt (q to abandon): ", c.Name)
+			if !in.Scan() || strings.EqualFold(strings.TrimSpace(in.Text()), "q") {
+				fmt.Fprintln(out)
+				log.Error(fmt.Errorf("ceremony abandoned: custodian %s did not confirm receipt; destroy the bundles in %s", c.Name, enc.OutputDir))
+				return nil, fmt.Errorf("ceremony abandoned: custodian %s did not confirm receipt; destroy the bundles in %s", c.Name, enc.OutputDir)
+			}
+			if sameCode(in.Text(), code) {
+				break
+			}
+			fmt.Fprintf(out, "That is not the code of %s's bundle.\n", c.Name)
+		}
+		record.Custodians = append(record.Custodians, CeremonyCustodian{
+			Name:        c.Name,
+			Collections: assignment[c.Name],
+			Bundle:      filepath.Base(bundle),
+			SHA256:      hex.EncodeToString(sum),
+			Code:        code,
+			Confirmed:   time.Now().UTC().Format(time.RFC3339),
+		})
+		log.Infof("Custodian %s confirmed receipt of %s", c.Name, filepath.Base(bundle))
+	}
+	record.Finished = time.Now().UTC().Format(time.RFC3339)
+
+	// Sign the record and write it alongside the output
+	key := cfg.SigningKey
+	if key == nil {
+		if _, key, err = ed25519.GenerateKey(rand.Reader); err != nil {
+			return nil, fmt.Errorf("failed to generate the signing key: %w", err)
+		}
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	record.PublicKey = base64.StdEncoding.EncodeToString(pub)
+	signed, err := record.signedBytes()
+	if err != nil {
+		return nil, err
+	}
+	record.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, signed))
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(recordPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to write the ceremony record: %w", err))
+		return nil, fmt.Errorf("failed to write the ceremony record: %w", err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Error(fmt.Errorf("failed to write the ceremony record: %w", err))
+		return nil, fmt.Errorf("failed to write the ceremony record: %w", err)
+	}
+
+	fmt.Fprintf(out, "\nEvery custodian confirmed receipt. Ceremony record: %s\n", recordPath)
+	fmt.Fprintf(out, "Signing key fingerprint: %s\n", KeyFingerprint(pub))
+	if cfg.SigningKey == nil {
+		fmt.Fprintf(out, "The key was generated for this ceremony and discarded; note the fingerprint down with the record.\n")
+	}
+	return record, nil
+}
+
+// VerifyCeremonyRecord checks the signature of a ceremony record, returning
+// the record and the fingerprint of the key that signed it, which must be
+// compared with the one noted at the ceremony, or an error wrapping
+// ErrAuditTampered if the record has been altered since it was signed
+func VerifyCeremonyRecord(data []byte) (*CeremonyRecord, string, error) {
+	var record CeremonyRecord
+	if err := json.Unmarshal(data, &record); err != nil || record.Kind != CeremonyRecordKind {
+		return nil, "", fmt.Errorf("%w: not a ceremony record", ErrInvalidConfig)
+	}
+	pub, err := base64.StdEncoding.DecodeString(record.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, "", fmt.Errorf("%w: the ceremony record has no valid public key", ErrAuditTampered)
+	}
+	sig, err := base64.StdEncoding.DecodeString(record.Signature)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: the ceremony record has no valid signature", ErrAuditTampered)
+	}
+	signed, err := record.signedBytes()
+	if err != nil {
+		return nil, "", err
+	}
+	if !ed25519.Verify(pub, signed, sig) {
+		return nil, "", fmt.Errorf("%w: the signature of the ceremony record does not match its contents", ErrAuditTampered)
+	}
+	return &record, KeyFingerprint(pub), nil
+}
+
+// IsCeremonyRecord reports whether data looks like a ceremony record rather
+// than an audit log
+func IsCeremonyRecord(data []byte) bool {
+	var probe struct {
+		Kind string `json:"kind"`
+	}
+	return json.Unmarshal(data, &probe) == nil && probe.Kind == CeremonyRecordKind
+}
@@ -0,0 +1,146 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// custodianTyper answers each confirmation of a ceremony: first with a
+// mistyped code, then with the code last shown in its output
+type custodianTyper struct {
+	out     *bytes.Buffer
+	answers int
+	give    int // Custodians who confirm before the rest give up
+}
+
+var shownCode = regexp.MustCompile(`Code:\s+(\S+)`)
+
+func (c *custodianTyper) Read(p []byte) (int, error) {
+	c.answers++
+	if c.answers > 2*c.give {
+		return copy(p, "q\n"), nil
+	}
+	if c.answers%2 == 1 {
+		return copy(p, "AAAA-AAAA\n"), nil
+	}
+	codes := shownCode.FindAllStringSubmatch(c.out.String(), -1)
+	return copy(p, strings.ToLower(codes[len(codes)-1][1])+"\n"), nil
+}
+
+func TestRunCeremony(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+	tempDir := t.TempDir()
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "key.txt"), []byte("root signing key"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	custodians, err := ParseCustodians("ceo:2,cfo,cto")
+	if err != nil {
+		t.Fatalf("ParseCustodians failed: %v", err)
+	}
+	encodeConfig := func(outputDir string) EncodeConfig {
+		return EncodeConfig{
+			InputDir:       inputDir,
+			OutputDir:      outputDir,
+			K:              2,
+			Format:         FormatBin,
+			ChunkSize:      1024,
+			RNG:            pad.NewDefaultRand(ctx),
+			Compression:    CompressionGzip,
+			ZipCollections: true,
+			Custodians:     custodians,
+		}
+	}
+
+	t.Run("Confirmed", func(t *testing.T) {
+		var out bytes.Buffer
+		recordPath := filepath.Join(tempDir, "record.json")
+		record, err := RunCeremony(ctx, CeremonyConfig{
+			Encode:     encodeConfig(filepath.Join(tempDir, "confirmed")),
+			RecordPath: recordPath,
+			Input:      &custodianTyper{out: &out, give: 3},
+			Output:     &out,
+		})
+		if err != nil {
+			t.Fatalf("RunCeremony failed: %v\n%s", err, out.String())
+		}
+		if len(record.Custodians) != 3 || record.Custodians[0].Bundle != "ceo.zip" || strings.Join(record.Custodians[0].Collections, ",") != "2A4,2B4" {
+			t.Errorf("Unexpected custodians: %+v", record.Custodians)
+		}
+		if len(record.Health) != 6 || record.Copies != 4 || record.Required != 2 {
+			t.Errorf("Unexpected record: %+v", record)
+		}
+		if !strings.Contains(out.String(), "That is not the code") {
+			t.Errorf("Expected the mistyped code to be refused:\n%s", out.String())
+		}
+
+		// The record verifies, and any change to it breaks the signature
+		data, err := os.ReadFile(recordPath)
+		if err != nil {
+			t.Fatalf("Failed to read record: %v", err)
+		}
+		if !IsCeremonyRecord(data) {
+			t.Fatalf("Record is not recognized")
+		}
+		verified, fingerprint, err := VerifyCeremonyRecord(data)
+		if err != nil || verified.Custodians[2].Code != record.Custodians[2].Code || !strings.Contains(out.String(), fingerprint) {
+			t.Fatalf("VerifyCeremonyRecord failed: %v", err)
+		}
+		altered := bytes.Replace(data, []byte(`"cto"`), []byte(`"eve"`), 1)
+		if _, _, err := VerifyCeremonyRecord(altered); !errors.Is(err, ErrAuditTampered) {
+			t.Errorf("Expected an altered record to fail verification, got %v", err)
+		}
+	})
+
+	t.Run("Abandoned", func(t *testing.T) {
+		var out bytes.Buffer
+		outputDir := filepath.Join(tempDir, "abandoned")
+		_, err := RunCeremony(ctx, CeremonyConfig{
+			Encode: encodeConfig(outputDir),
+			Input:  &custodianTyper{out: &out, give: 1},
+			Output: &out,
+		})
+		if err == nil || !strings.Contains(err.Error(), "cfo did not confirm") {
+			t.Fatalf("Expected the ceremony to be abandoned, got %v", err)
+		}
+		if matches, _ := filepath.Glob(filepath.Join(tempDir, CeremonyRecordKind+"-*.json")); len(matches) != 0 {
+			t.Errorf("Expected no record of an abandoned ceremony, got %v", matches)
+		}
+	})
+
+	t.Run("Unhealthy RNG", func(t *testing.T) {
+		var out bytes.Buffer
+		cfg := encodeConfig(filepath.Join(tempDir, "unhealthy"))
+		cfg.RNG = pad.NewTestRNG(0)
+		_, err := RunCeremony(ctx, CeremonyConfig{Encode: cfg, Input: strings.NewReader(""), Output: &out})
+		if !errors.Is(err, ErrUnhealthyRNG) {
+			t.Fatalf("Expected ErrUnhealthyRNG, got %v", err)
+		}
+		if _, err := os.Stat(cfg.OutputDir); !os.IsNotExist(err) {
+			t.Errorf("Expected nothing to be encoded with an unhealthy RNG")
+		}
+	})
+}
+
+func TestVerificationCode(t *testing.T) {
+	code := verificationCode(bytes.Repeat([]byte{0xff}, 32))
+	if code != "9999-9999" {
+		t.Errorf("verificationCode = %q, want 9999-9999", code)
+	}
+	if !sameCode(" k7qm 3xpa", "K7QM-3XPA") || sameCode("K7QM-3XPB", "K7QM-3XPA") {
+		t.Errorf("sameCode does not compare codes as typed")
+	}
+}
@@ -0,0 +1,145 @@
+package padlock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// CheckCodeConfig holds configuration parameters for checking a collection
+// against the verification code printed when it was encoded. This structure
+// is created by the command-line interface and passed to CheckCode.
+type CheckCodeConfig struct {
+	Path   string    // A collection directory, zip or tar.gz, a custodian bundle, or a directory holding collections
+	Code   string    // The code issued with the collection, to compare; if empty, the code is only shown
+	Output io.Writer // Where the code of each collection is written
+}
+
+// codeDigest is the digest that a collection's verification code is derived
+// from: the SHA-256 of its name and of the number and SHA-256 of each of its
+// chunks, in order. It depends only on the chunks themselves, so a collection
+// keeps its code when it is zipped, archived, split into volumes or converted
+// to another format, and loses it if any chunk is changed, added or lost.
+type codeDigest struct {
+	name   string
+	chunks map[int][sha256.Size]byte
+}
+
+// newCodeDigest starts the digest of a collection
+func newCodeDigest(name string) *codeDigest {
+	return &codeDigest{name: name, chunks: make(map[int][sha256.Size]byte)}
+}
+
+// add records the contents of one of the collection's chunks
+func (d *codeDigest) add(chunkNumber int, data []byte) {
+	d.chunks[chunkNumber] = sha256.Sum256(data)
+}
+
+// code returns the verification code of the chunks added, e.g. "K7QM-3XPA"
+func (d *codeDigest) code() string {
+	numbers := make([]int, 0, len(d.chunks))
+	for n := range d.chunks {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	h := sha256.New()
+	h.Write([]byte(d.name))
+	for _, n := range numbers {
+		sum := d.chunks[n]
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+		h.Write(sum[:])
+	}
+	return verificationCode(h.Sum(nil))
+}
+
+// codeWriter passes the data of a chunk through to the writer of its file,
+// adding it to the digest of its collection once the chunk is complete
+type codeWriter struct {
+	io.WriteCloser
+	digest      *codeDigest
+	chunkNumber int
+	data        []byte
+}
+
+// Write implements io.Writer
+func (w *codeWriter) Write(p []byte) (int, error) {
+	w.data = append(w.data, p...)
+	return w.WriteCloser.Write(p)
+}
+
+// Close implements io.Closer
+func (w *codeWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	w.digest.add(w.chunkNumber, w.data)
+	return nil
+}
+
+// CollectionCode reads every chunk of a collection and returns its
+// verification code, and the number of chunks read. A chunk that cannot be
+// read fails the check, as the collection is then not as it was issued.
+func CollectionCode(ctx context.Context, coll file.Collection) (string, int, error) {
+	numbers, err := file.ChunkNumbers(coll)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(numbers) == 0 {
+		return "", 0, fmt.Errorf("%w: collection %s has no chunks", ErrChunkCorrupt, coll.Name)
+	}
+	digest := newCodeDigest(coll.Name)
+	for _, n := range numbers {
+		data, err := file.ReadChunk(ctx, coll, n)
+		if err != nil {
+			return "", 0, fmt.Errorf("%w: collection %s: chunk %d is unreadable: %w", ErrChunkCorrupt, coll.Name, n, err)
+		}
+		digest.add(n, data)
+	}
+	return digest.code(), len(numbers), nil
+}
+
+// CheckCode finds the collections at cfg.Path, which need not be enough to
+// decode, and writes the verification code of each. A custodian compares it
+// with the code issued with their share to confirm that the share is intact
+// and the one they were given. If cfg.Code is set, it is compared for them,
+// and CheckCode fails with ErrCodeMismatch unless the code of one collection
+// found matches it.
+func CheckCode(ctx context.Context, cfg CheckCodeConfig) error {
+	log := trace.FromContext(ctx).WithPrefix("CHECKCODE")
+
+	collections, tempDir, err := file.FindCollectionsAt(ctx, cfg.Path)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer file.RemoveTemp(ctx, tempDir)
+	defer file.CloseCollections(collections)
+
+	matched := false
+	for _, coll := range collections {
+		code, chunks, err := CollectionCode(ctx, coll)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		status := ""
+		if cfg.Code != "" {
+			status = "  does not match"
+			if sameCode(cfg.Code, code) {
+				status, matched = "  matches", true
+			}
+		}
+		fmt.Fprintf(cfg.Output, "%-6s %s  (%d chunks)%s\n", coll.Name, code, chunks, status)
+	}
+	if cfg.Code != "" && !matched {
+		return fmt.Errorf("%w: no collection at %s has the code %s", ErrCodeMismatch, cfg.Path, strings.ToUpper(cfg.Code))
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestCheckCode(t *testing.T) {
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "seed.txt"), bytes.Repeat([]byte("wallet seed "), 500), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	issued := regexp.MustCompile(`Verification code for collection (\S+): (\S+)`)
+
+	for _, tc := range []struct {
+		name   string
+		format Format
+		zip    bool
+	}{
+		{"Bin directories", FormatBin, false},
+		{"PNG zips", FormatPNG, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			tracer := trace.NewTracer("TEST", trace.LogLevelNormal)
+			tracer.SetSinks(trace.TextSink(&logged))
+			ctx := trace.WithContext(context.Background(), tracer)
+
+			outputDir := filepath.Join(tempDir, strings.ReplaceAll(tc.name, " ", "-"))
+			err := EncodeDirectory(ctx, EncodeConfig{
+				InputDir:       inputDir,
+				OutputDir:      outputDir,
+				N:              3,
+				K:              2,
+				Format:         tc.format,
+				ChunkSize:      1024,
+				RNG:            pad.NewDefaultRand(ctx),
+				Compression:    CompressionNone,
+				ZipCollections: tc.zip,
+			})
+			if err != nil {
+				t.Fatalf("EncodeDirectory failed: %v", err)
+			}
+			codes := make(map[string]string)
+			for _, m := range issued.FindAllStringSubmatch(logged.String(), -1) {
+				codes[m[1]] = m[2]
+			}
+			if len(codes) != 3 {
+				t.Fatalf("Expected a code for each of 3 collections, got %v", codes)
+			}
+
+			// Each collection, given on its own, has the code it was issued with
+			collPath := filepath.Join(outputDir, "2B3")
+			if tc.zip {
+				collPath += ".zip"
+			}
+			var out bytes.Buffer
+			if err := CheckCode(ctx, CheckCodeConfig{Path: collPath, Code: strings.ToLower(codes["2B3"]), Output: &out}); err != nil {
+				t.Fatalf("CheckCode failed: %v\n%s", err, out.String())
+			}
+			if !strings.Contains(out.String(), "2B3    "+codes["2B3"]) || !strings.Contains(out.String(), "matches") || strings.Contains(out.String(), "2A3") {
+				t.Errorf("Unexpected output: %s", out.String())
+			}
+			if err := CheckCode(ctx, CheckCodeConfig{Path: collPath, Code: codes["2A3"], Output: &out}); !errors.Is(err, ErrCodeMismatch) {
+				t.Errorf("Expected ErrCodeMismatch for another collection's code, got %v", err)
+			}
+
+			// A directory of collections lists the code of each
+			out.Reset()
+			if err := CheckCode(ctx, CheckCodeConfig{Path: outputDir, Output: &out}); err != nil {
+				t.Fatalf("CheckCode failed: %v", err)
+			}
+			for name, code := range codes {
+				if !strings.Contains(out.String(), name+"    "+code) {
+					t.Errorf("Expected %s %s in output: %s", name, code, out.String())
+				}
+			}
+		})
+	}
+
+	t.Run("Altered chunk", func(t *testing.T) {
+		ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+		collPath := filepath.Join(tempDir, "Bin-directories", "2A3")
+		var out bytes.Buffer
+		if err := CheckCode(ctx, CheckCodeConfig{Path: collPath, Output: &out}); err != nil {
+			t.Fatalf("CheckCode failed: %v", err)
+		}
+		before := out.String()
+
+		chunks, _ := filepath.Glob(filepath.Join(collPath, "*.bin"))
+		if len(chunks) < 2 {
+			t.Fatalf("Expected several chunks, got %v", chunks)
+		}
+		if err := os.Remove(chunks[len(chunks)-1]); err != nil {
+			t.Fatalf("Failed to remove chunk: %v", err)
+		}
+		out.Reset()
+		if err := CheckCode(ctx, CheckCodeConfig{Path: collPath, Output: &out}); err != nil {
+			t.Fatalf("CheckCode failed: %v", err)
+		}
+		if out.String() == before {
+			t.Errorf("Expected a collection missing a chunk to have another code: %s", out.String())
+		}
+	})
+}
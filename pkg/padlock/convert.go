@@ -0,0 +1,158 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// ConvertConfig holds configuration parameters for converting collections.
+// This structure is created by the command-line interface and passed to ConvertCollections.
+type ConvertConfig struct {
+	InputDir        string           // Path to the directory containing the collections to convert
+	OutputDir       string           // Path where the converted collections will be created
+	Format          Format           // Format of the converted chunks, or empty to keep each collection's format; packed collections are written as single files
+	ChunkNaming     file.ChunkNaming // Naming of the converted chunk files, or the zero value to keep each collection's where it suits the format
+	ClearIfNotEmpty bool             // Whether to clear the output directory if not empty
+	Verbose         bool             // Enable verbose logging
+	ZipCollections  bool             // Whether to create ZIP archives for the converted collections
+	TarCollections  bool             // Whether to create gzipped tar archives for the converted collections
+}
+
+// ConvertCollections writes a copy of each collection in the input directory to
+// the output directory, its chunks rewrapped in another format or packaged
+// differently: as directories, packed files, zips or tar archives. This lets a collection be
+// moved after the fact through a channel that only carries images, or only a
+// single file. Unlike a refresh or a reshare, each collection is converted on
+// its own, so any number of them can be, and nothing is decoded; the converted
+// chunks are the very same shares, and decode with unconverted collections.
+func ConvertCollections(ctx context.Context, cfg ConvertConfig) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	start := time.Now()
+	log.Infof("Starting convert: InputDir=%s OutputDir=%s Format=%s", cfg.InputDir, cfg.OutputDir, cfg.Format)
+
+	// Validate input directory to ensure it exists and is accessible
+	if err := file.ValidateInputDirectory(ctx, cfg.InputDir); err != nil {
+		return err
+	}
+
+	if cfg.ZipCollections && cfg.TarCollections {
+		log.Error(fmt.Errorf("%w: collections cannot be archived as both zip and tar", ErrInvalidConfig))
+		return fmt.Errorf("%w: collections cannot be archived as both zip and tar", ErrInvalidConfig)
+	}
+	if cfg.Format == FormatPacked && (cfg.ZipCollections || cfg.TarCollections || cfg.ChunkNaming != (file.ChunkNaming{})) {
+		log.Error(fmt.Errorf("%w: packed collections cannot be archived or have their chunks named", ErrInvalidConfig))
+		return fmt.Errorf("%w: packed collections cannot be archived or have their chunks named", ErrInvalidConfig)
+	}
+	if cfg.ChunkNaming != (file.ChunkNaming{}) {
+		if cfg.Format == "" {
+			log.Error(fmt.Errorf("%w: a chunk naming needs the format it names", ErrInvalidConfig))
+			return fmt.Errorf("%w: a chunk naming needs the format it names", ErrInvalidConfig)
+		}
+		if err := cfg.ChunkNaming.CheckFormat(cfg.Format); err != nil {
+			log.Error(fmt.Errorf("%w: %w", ErrInvalidConfig, err))
+			return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	// Preparing the output would destroy the collections being read
+	inputAbs, err1 := filepath.Abs(cfg.InputDir)
+	outputAbs, err2 := filepath.Abs(cfg.OutputDir)
+	if err1 == nil && err2 == nil && inputAbs == outputAbs {
+		log.Error(fmt.Errorf("%w: output directory must differ from the input directory", ErrInvalidConfig))
+		return fmt.Errorf("%w: output directory must differ from the input directory", ErrInvalidConfig)
+	}
+
+	// Find collections (directories or archives) in the input directory
+	collections, tempDir, err := file.FindCollections(ctx, cfg.InputDir)
+	if err != nil {
+		return err
+	}
+	defer file.CloseCollections(collections)
+	defer file.RemoveTemp(ctx, tempDir)
+	if len(collections) == 0 {
+		log.Error(fmt.Errorf("%w in input directory", ErrNoCollections))
+		return fmt.Errorf("%w in input directory", ErrNoCollections)
+	}
+	if err := checkNoSnapshots(ctx, collections, "converted"); err != nil {
+		return err
+	}
+
+	// The same collection supplied twice would be written over itself
+	names := make([]string, len(collections))
+	seen := make(map[string]bool)
+	for i, coll := range collections {
+		if seen[coll.Name] {
+			log.Error(fmt.Errorf("%w: collection %s is in the input directory more than once", ErrInvalidConfig, coll.Name))
+			return fmt.Errorf("%w: collection %s is in the input directory more than once", ErrInvalidConfig, coll.Name)
+		}
+		seen[coll.Name] = true
+		names[i] = coll.Name
+	}
+
+	if err := file.PrepareOutputDirectory(ctx, cfg.OutputDir, cfg.ClearIfNotEmpty); err != nil {
+		return err
+	}
+	converted, err := file.CreateCollections(ctx, cfg.OutputDir, names)
+	if err != nil {
+		return err
+	}
+
+	var packed []file.Collection
+	for i, coll := range collections {
+		format, naming := convertedFormat(coll, cfg)
+		if err := file.ConvertCollection(ctx, coll, converted[i].Path, format, naming); err != nil {
+			return err
+		}
+		converted[i].Format, converted[i].Naming = format, naming
+		if format == FormatPacked {
+			packed = append(packed, converted[i])
+		}
+		log.Infof("Converted collection %s from %s to %s", coll.Name, coll.Format, format)
+	}
+	if len(packed) > 0 {
+		if _, err := file.PackCollections(ctx, packed); err != nil {
+			return err
+		}
+	}
+
+	if cfg.ZipCollections {
+		if _, err := file.ZipCollections(ctx, converted); err != nil {
+			return err
+		}
+	}
+	if cfg.TarCollections {
+		if _, err := file.TarCollections(ctx, converted); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Convert complete (%s): %d collections", time.Since(start), len(converted))
+	return nil
+}
+
+// convertedFormat returns the format and chunk naming of a converted
+// collection. A collection keeps its own naming unless another is given or
+// its naming, by its extension, doesn't suit the new format. A packed
+// collection that is to be archived is unpacked into binary chunks, and
+// packed chunks keep their default names.
+func convertedFormat(coll file.Collection, cfg ConvertConfig) (Format, file.ChunkNaming) {
+	format, naming := cfg.Format, cfg.ChunkNaming
+	if format == "" {
+		format = coll.Format
+	}
+	if format == FormatPacked && (cfg.ZipCollections || cfg.TarCollections) {
+		format = FormatBin
+	}
+	if format == FormatPacked {
+		return format, file.ChunkNaming{}
+	}
+	if naming == (file.ChunkNaming{}) && coll.Naming.CheckFormat(format) == nil {
+		naming = coll.Naming
+	}
+	return format, naming
+}
@@ -0,0 +1,149 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestConvertCollections(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "padlock-convert-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	encodedDir := filepath.Join(tempDir, "encoded")
+	shareDir := filepath.Join(tempDir, "share")
+	convertedDir := filepath.Join(tempDir, "converted")
+	restoreDir := filepath.Join(tempDir, "restore")
+	for _, dir := range []string{inputDir, shareDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+
+	testContent := strings.Repeat("converted content\n", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	encodeConfig := EncodeConfig{
+		InputDir:       inputDir,
+		OutputDir:      encodedDir,
+		N:              3,
+		K:              2,
+		Format:         FormatBin,
+		ChunkSize:      1024,
+		RNG:            pad.NewDefaultRand(ctx),
+		Compression:    CompressionGzip,
+		ZipCollections: true,
+		ParityPercent:  10,
+	}
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	// One zipped bin collection becomes a directory of PNGs
+	if err := os.Rename(filepath.Join(encodedDir, "2B3.zip"), filepath.Join(shareDir, "2B3.zip")); err != nil {
+		t.Fatalf("Failed to move collection: %v", err)
+	}
+	convertConfig := ConvertConfig{
+		InputDir:  shareDir,
+		OutputDir: convertedDir,
+		Format:    FormatPNG,
+	}
+	if err := ConvertCollections(ctx, convertConfig); err != nil {
+		t.Fatalf("Failed to convert collections: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(convertedDir, "2B3", file.ChunkFileName(FormatPNG, "2B3", 1))); err != nil {
+		t.Errorf("Expected the converted collection to hold PNG chunks: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(convertedDir, "2B3", "*.par")); len(matches) == 0 {
+		t.Errorf("Expected the parity files to be kept")
+	}
+
+	// The converted collection decodes with an unconverted one
+	if err := os.Rename(filepath.Join(encodedDir, "2A3.zip"), filepath.Join(convertedDir, "2A3.zip")); err != nil {
+		t.Fatalf("Failed to move collection: %v", err)
+	}
+	decodeConfig := DecodeConfig{
+		InputDir:    convertedDir,
+		OutputDir:   restoreDir,
+		Compression: CompressionGzip,
+	}
+	if err := DecodeDirectory(ctx, decodeConfig); err != nil {
+		t.Fatalf("Failed to decode converted collections: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(restoreDir, "data.txt"))
+	if err != nil || string(data) != testContent {
+		t.Errorf("Restored data does not match the original (%v)", err)
+	}
+
+	// Converting back to bin and zipping restores the packaging
+	convertConfig = ConvertConfig{
+		InputDir:       convertedDir,
+		OutputDir:      filepath.Join(tempDir, "zipped"),
+		Format:         FormatBin,
+		ZipCollections: true,
+	}
+	if err := ConvertCollections(ctx, convertConfig); err != nil {
+		t.Fatalf("Failed to convert collections back: %v", err)
+	}
+	for _, name := range []string{"2A3.zip", "2B3.zip"} {
+		if _, err := os.Stat(filepath.Join(convertConfig.OutputDir, name)); err != nil {
+			t.Errorf("Expected %s: %v", name, err)
+		}
+	}
+
+	// Converting to packed writes each collection as a single file, which
+	// decodes as the directories did
+	packedDir := filepath.Join(tempDir, "packed")
+	convertConfig = ConvertConfig{
+		InputDir:  convertedDir,
+		OutputDir: packedDir,
+		Format:    FormatPacked,
+	}
+	if err := ConvertCollections(ctx, convertConfig); err != nil {
+		t.Fatalf("Failed to convert collections to packed: %v", err)
+	}
+	for _, name := range []string{"2A3", "2B3"} {
+		if _, err := os.Stat(filepath.Join(packedDir, name+file.PackedSuffix)); err != nil {
+			t.Errorf("Expected %s%s: %v", name, file.PackedSuffix, err)
+		}
+		if _, err := os.Stat(filepath.Join(packedDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected the directory of %s to be packed and removed", name)
+		}
+	}
+	decodeConfig.InputDir, decodeConfig.OutputDir = packedDir, filepath.Join(tempDir, "restore-packed")
+	if err := DecodeDirectory(ctx, decodeConfig); err != nil {
+		t.Fatalf("Failed to decode packed collections: %v", err)
+	}
+	data, err = os.ReadFile(filepath.Join(decodeConfig.OutputDir, "data.txt"))
+	if err != nil || string(data) != testContent {
+		t.Errorf("Restored data does not match the original (%v)", err)
+	}
+
+	// A naming that doesn't suit the format is refused
+	convertConfig = ConvertConfig{
+		InputDir:    convertedDir,
+		OutputDir:   filepath.Join(tempDir, "misnamed"),
+		Format:      FormatBin,
+		ChunkNaming: file.DefaultChunkNaming(FormatPNG),
+	}
+	if err := ConvertCollections(ctx, convertConfig); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig naming bin chunks .PNG, got %v", err)
+	}
+}
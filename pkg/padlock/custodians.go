@@ -0,0 +1,226 @@
+package padlock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// Custodian is a named holder of one or more collections. A custodian with a
+// weight of 2 holds two collections, and so counts twice towards the threshold.
+type Custodian struct {
+	Name         string `json:"name"`                   // Custodian name, also the name of its bundle (e.g., "ceo")
+	Weight       int    `json:"weight,omitempty"`       // Number of collections held by the custodian
+	Contact      string `json:"contact,omitempty"`      // How to reach the custodian, recorded in each collection
+	Instructions string `json:"instructions,omitempty"` // Notes specific to the custodian, recorded in each collection
+}
+
+// CustodiansFile is the format of the custodians file given to encode, e.g.
+//
+//	{
+//	  "instructions": "Contact the other custodians and decode together.",
+//	  "custodians": [
+//	    {"name": "ceo", "weight": 2, "contact": "ceo@example.com"},
+//	    {"name": "cfo", "contact": "+1 555 0100"}
+//	  ]
+//	}
+type CustodiansFile struct {
+	Instructions string      `json:"instructions,omitempty"` // Recovery instructions for the whole set
+	Custodians   []Custodian `json:"custodians"`             // Custodians, each of weight 1 unless given
+}
+
+// custodianSpecPattern matches one custodian of a spec, e.g. "ceo" or "ceo:2"
+var custodianSpecPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_.-]*)(?::(\d+))?$`)
+
+// ParseCustodians parses a comma-separated custodian spec of the form
+// "NAME[:WEIGHT],NAME[:WEIGHT],..." into custodians, each of weight 1 unless given
+func ParseCustodians(spec string) ([]Custodian, error) {
+	var custodians []Custodian
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		m := custodianSpecPattern.FindStringSubmatch(item)
+		if m == nil {
+			return nil, fmt.Errorf("invalid custodian %q: expected NAME or NAME:WEIGHT, e.g. ceo:2", item)
+		}
+		weight := 1
+		if m[2] != "" {
+			weight, _ = strconv.Atoi(m[2])
+		}
+		custodians = append(custodians, Custodian{Name: m[1], Weight: weight})
+	}
+	return custodians, validateCustodians(custodians)
+}
+
+// ReadCustodiansFile reads and validates a custodians file
+func ReadCustodiansFile(path string) (*CustodiansFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read custodians file: %w", err)
+	}
+	var f CustodiansFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid custodians file %s: %w", path, err)
+	}
+	for i := range f.Custodians {
+		if f.Custodians[i].Weight == 0 {
+			f.Custodians[i].Weight = 1
+		}
+	}
+	if err := validateCustodians(f.Custodians); err != nil {
+		return nil, fmt.Errorf("invalid custodians file %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// custodianSpec formats custodians in the form parsed by ParseCustodians
+func custodianSpec(custodians []Custodian) string {
+	items := make([]string, len(custodians))
+	for i, c := range custodians {
+		items[i] = c.Name
+		if c.Weight != 1 {
+			items[i] = fmt.Sprintf("%s:%d", c.Name, c.Weight)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// custodianCopies returns the number of collections held by all custodians
+func custodianCopies(custodians []Custodian) int {
+	n := 0
+	for _, c := range custodians {
+		n += c.Weight
+	}
+	return n
+}
+
+// validateCustodians checks that a set of custodians can be encoded
+func validateCustodians(custodians []Custodian) error {
+	if len(custodians) < 2 {
+		return fmt.Errorf("at least 2 custodians are required, got %d", len(custodians))
+	}
+	seen := make(map[string]bool)
+	for _, c := range custodians {
+		if !custodianSpecPattern.MatchString(c.Name) {
+			return fmt.Errorf("invalid custodian name %q", c.Name)
+		}
+		if seen[strings.ToLower(c.Name)] {
+			return fmt.Errorf("custodian %s is listed more than once", c.Name)
+		}
+		seen[strings.ToLower(c.Name)] = true
+		if c.Weight < 1 {
+			return fmt.Errorf("custodian %s: weight must be at least 1, got %d", c.Name, c.Weight)
+		}
+	}
+	if n := custodianCopies(custodians); n > 26 {
+		return fmt.Errorf("custodians hold %d collections in total, at most 26 are allowed", n)
+	}
+	return nil
+}
+
+// custodianAssignment assigns the collections of a K-of-N set to custodians in
+// order, so that the first custodian of weight 2 holds collections A and B
+func custodianAssignment(custodians []Custodian, k int) map[string][]string {
+	n := custodianCopies(custodians)
+	assignment := make(map[string][]string)
+	index := 0
+	for _, c := range custodians {
+		for i := 0; i < c.Weight; i++ {
+			assignment[c.Name] = append(assignment[c.Name], pad.CollectionName(k, n, index))
+			index++
+		}
+	}
+	return assignment
+}
+
+// encodeDirectoryCustodians encodes the input stream into a set holding one
+// collection per unit of custodian weight, records recovery instructions in
+// each collection, and then bundles each custodian's collections, zipped if
+// requested, into a single artifact named after them
+func encodeDirectoryCustodians(ctx context.Context, cfg EncodeConfig, openInput func() (io.ReadCloser, error)) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	if err := validateCustodians(cfg.Custodians); err != nil {
+		log.Error(fmt.Errorf("%w: invalid custodians: %w", ErrInvalidConfig, err))
+		return fmt.Errorf("%w: invalid custodians: %w", ErrInvalidConfig, err)
+	}
+	if len(cfg.Targets) > 0 || cfg.VolumeSize > 0 {
+		log.Error(fmt.Errorf("%w: targets and volumes cannot be combined with custodians", ErrInvalidConfig))
+		return fmt.Errorf("%w: targets and volumes cannot be combined with custodians", ErrInvalidConfig)
+	}
+
+	// The bundles, rather than the collections, are archived
+	streamCfg := cfg
+	streamCfg.N = custodianCopies(cfg.Custodians)
+	streamCfg.ZipCollections = false
+	streamCfg.TarCollections = false
+	if err := encodeStream(ctx, streamCfg, openInput); err != nil {
+		return err
+	}
+
+	// Explain in each collection what it is and who holds the others
+	assignment := custodianAssignment(cfg.Custodians, cfg.K)
+	info := file.RecoveryInfo{
+		Required:     cfg.K,
+		Copies:       streamCfg.N,
+		Created:      time.Now().Format(time.RFC3339),
+		Instructions: cfg.Instructions,
+	}
+	for _, c := range cfg.Custodians {
+		info.Custodians = append(info.Custodians, file.RecoveryCustodian{
+			Name:         c.Name,
+			Contact:      c.Contact,
+			Instructions: c.Instructions,
+			Collections:  assignment[c.Name],
+		})
+	}
+	for _, c := range cfg.Custodians {
+		for _, collName := range assignment[c.Name] {
+			info.Collection, info.Holder = collName, c.Name
+			if err := file.WriteRecoveryInfo(ctx, filepath.Join(cfg.OutputDir, collName), info); err != nil {
+				return err
+			}
+		}
+	}
+
+	var bundles []file.Collection
+	for _, c := range cfg.Custodians {
+		bundleDir, err := file.CreateCustodianBundle(ctx, cfg.OutputDir, file.CustodianManifest{
+			Custodian:   c.Name,
+			Collections: assignment[c.Name],
+			Required:    cfg.K,
+			Copies:      streamCfg.N,
+			Custodians:  assignment,
+		})
+		if err != nil {
+			return err
+		}
+		bundles = append(bundles, file.Collection{Name: c.Name, Path: bundleDir})
+		log.Infof("Custodian %s: %s", c.Name, strings.Join(assignment[c.Name], ", "))
+	}
+
+	if cfg.ZipCollections {
+		if _, err := file.ZipCollections(ctx, bundles); err != nil {
+			return err
+		}
+	}
+	if cfg.TarCollections {
+		if _, err := file.TarCollections(ctx, bundles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package padlock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestParseCustodians(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    int
+		wantErr bool
+	}{
+		{"ceo:2,cfo,cto,counsel", 5, false},
+		{" ceo , cfo ", 2, false},
+		{"ceo:2", 0, true},
+		{"ceo,ceo", 0, true},
+		{"ceo:0,cfo", 0, true},
+		{"ceo=2,cfo", 0, true},
+		{"2A3,cfo", 0, true},
+		{"ceo:20,cfo:7", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			custodians, err := ParseCustodians(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCustodians(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && custodianCopies(custodians) != tt.want {
+				t.Errorf("ParseCustodians(%q) holds %d collections, want %d", tt.spec, custodianCopies(custodians), tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCustodiansFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-custodians-file-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tests := []struct {
+		name    string
+		content string
+		want    int
+		wantErr bool
+	}{
+		{"valid", `{"instructions": "Decode together.", "custodians": [{"name": "ceo", "weight": 2, "contact": "ceo@example.com"}, {"name": "cfo"}]}`, 3, false},
+		{"single", `{"custodians": [{"name": "ceo"}]}`, 0, true},
+		{"bad-name", `{"custodians": [{"name": "the ceo"}, {"name": "cfo"}]}`, 0, true},
+		{"malformed", `{"custodians": [`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir, tt.name+".json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write custodians file: %v", err)
+			}
+			f, err := ReadCustodiansFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadCustodiansFile error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && custodianCopies(f.Custodians) != tt.want {
+				t.Errorf("ReadCustodiansFile holds %d collections, want %d", custodianCopies(f.Custodians), tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeDecodeCustodians(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "padlock-custodians-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	outputDir := filepath.Join(tempDir, "output")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("weighted content\n", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	custodians, err := ParseCustodians("ceo:2,cfo,cto")
+	if err != nil {
+		t.Fatalf("ParseCustodians failed: %v", err)
+	}
+	encodeConfig := EncodeConfig{
+		InputDir:       inputDir,
+		OutputDir:      outputDir,
+		K:              2,
+		Format:         FormatBin,
+		ChunkSize:      1024,
+		RNG:            pad.NewDefaultRand(ctx),
+		Compression:    CompressionGzip,
+		ZipCollections: true,
+		Custodians:     custodians,
+		Instructions:   "Decode together.",
+	}
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		t.Fatalf("Failed to read output dir: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, " ") != "ceo.zip cfo.zip cto.zip" {
+		t.Fatalf("Expected one zip per custodian, got %v", names)
+	}
+
+	// decode decodes the output directory into a fresh directory
+	decode := func(name string) error {
+		restoreDir := filepath.Join(tempDir, name)
+		cfg := DecodeConfig{
+			InputDir:    outputDir,
+			OutputDir:   restoreDir,
+			Compression: CompressionGzip,
+		}
+		if err := DecodeDirectory(ctx, cfg); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(restoreDir, "data.txt"))
+		if err != nil || string(data) != testContent {
+			t.Errorf("Restored data does not match the original (%v)", err)
+		}
+		return nil
+	}
+
+	// The ceo's weight of 2 reaches the threshold alone
+	for _, name := range []string{"cfo.zip", "cto.zip"} {
+		if err := os.Remove(filepath.Join(outputDir, name)); err != nil {
+			t.Fatalf("Failed to remove bundle: %v", err)
+		}
+	}
+	if err := decode("restore"); err != nil {
+		t.Fatalf("Failed to decode from the ceo's bundle: %v", err)
+	}
+
+	// The bundle's collections are read in place from its zip
+	collections, tempPath, err := file.FindCollections(ctx, outputDir)
+	if err != nil {
+		t.Fatalf("FindCollections failed: %v", err)
+	}
+	file.CloseCollections(collections)
+	if tempPath != "" || len(collections) != 2 || !collections[0].Zip || collections[0].Path != filepath.Join(outputDir, "ceo.zip") {
+		t.Errorf("Expected the ceo's collections to be read in place, got %+v (%s)", collections, tempPath)
+	}
+
+	// The bundle's manifest records every custodian's collections
+	bundleDir, err := file.ExtractZipCollection(ctx, filepath.Join(outputDir, "ceo.zip"), tempDir)
+	if err != nil {
+		t.Fatalf("ExtractZipCollection failed: %v", err)
+	}
+	m, err := file.ReadCustodianManifest(ctx, bundleDir)
+	if err != nil || m == nil {
+		t.Fatalf("ReadCustodianManifest failed: %v", err)
+	}
+	if strings.Join(m.Collections, ",") != "2A4,2B4" || strings.Join(m.Custodians["cto"], ",") != "2D4" || m.Copies != 4 {
+		t.Errorf("Unexpected custodian manifest: %+v", m)
+	}
+
+	// Each collection explains itself
+	info, err := file.ReadRecoveryInfo(ctx, filepath.Join(bundleDir, "2B4"))
+	if err != nil || info == nil {
+		t.Fatalf("ReadRecoveryInfo failed: %v", err)
+	}
+	if info.Collection != "2B4" || info.Holder != "ceo" || info.Required != 2 || info.Instructions != "Decode together." || len(info.Custodians) != 3 {
+		t.Errorf("Unexpected recovery info: %+v", info)
+	}
+	if _, err := os.Stat(filepath.Join(bundleDir, "2B4", file.RecoveryReadmeFileName)); err != nil {
+		t.Errorf("Expected a README in the collection: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/file"
+)
+
+// ParseDestinations parses a destination spec mapping collection letters to
+// the directories they are written to, e.g. "A=/mnt/usb1,B=/mnt/usb2". Each
+// collection of an encode is written straight to its own directory, so no
+// two shares ever share a disk, even for a moment.
+func ParseDestinations(spec string) (map[string]string, error) {
+	destinations := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		letter, dir, ok := strings.Cut(strings.TrimSpace(item), "=")
+		letter, dir = strings.ToUpper(strings.TrimSpace(letter)), strings.TrimSpace(dir)
+		if !ok || len(letter) != 1 || letter[0] < 'A' || letter[0] > 'Z' || dir == "" {
+			return nil, fmt.Errorf("%w: invalid destination %q: expected LETTER=DIRECTORY, e.g. A=/mnt/usb1", ErrInvalidConfig, item)
+		}
+		if _, dup := destinations[letter]; dup {
+			return nil, fmt.Errorf("%w: collection %s is given more than one destination", ErrInvalidConfig, letter)
+		}
+		destinations[letter] = dir
+	}
+	return destinations, nil
+}
+
+// validateDestinations checks that the destinations name collections of a
+// set of n, that no two of them share a directory, and that every collection
+// has somewhere to go
+func validateDestinations(destinations map[string]string, n int, outputDir string) error {
+	dirs := make(map[string]string)
+	for _, letter := range sortedLetters(destinations) {
+		if letter[0]-'A' >= byte(n) {
+			return fmt.Errorf("%w: destination given for collection %s, but the set has only %d collections", ErrInvalidConfig, letter, n)
+		}
+		dir := filepath.Clean(destinations[letter])
+		if other, dup := dirs[dir]; dup {
+			return fmt.Errorf("%w: collections %s and %s are both written to %s", ErrInvalidConfig, other, letter, dir)
+		}
+		dirs[dir] = letter
+	}
+	if outputDir == "" && len(destinations) < n {
+		return fmt.Errorf("%w: %d destinations given for %d collections, and no output directory for the others", ErrInvalidConfig, len(destinations), n)
+	}
+	return nil
+}
+
+// collectionDirs returns the directory each of the N collections of an
+// encode is written to, in order: its destination, or else the output directory
+func (cfg EncodeConfig) collectionDirs() []string {
+	dirs := make([]string, cfg.N)
+	for i := range dirs {
+		dirs[i] = cfg.OutputDir
+		if dir, ok := cfg.Destinations[string(rune('A'+i))]; ok {
+			dirs[i] = dir
+		}
+	}
+	return dirs
+}
+
+// prepareDestinations prepares each directory the collections are written
+// to, clearing it if requested, and warns when two of them share a device
+func prepareDestinations(ctx context.Context, cfg EncodeConfig) error {
+	roots := destinationRoots(cfg)
+	for _, dir := range roots {
+		if err := file.PrepareOutputDirectory(ctx, dir, cfg.ClearIfNotEmpty); err != nil {
+			return err
+		}
+	}
+	return file.ValidateTargets(ctx, roots)
+}
+
+// sortedLetters returns the collection letters of the destinations in order
+func sortedLetters(destinations map[string]string) []string {
+	letters := make([]string, 0, len(destinations))
+	for letter := range destinations {
+		letters = append(letters, letter)
+	}
+	sort.Strings(letters)
+	return letters
+}
+
+// destinationSpec formats destinations as ParseDestinations parses them
+func destinationSpec(destinations map[string]string) string {
+	var items []string
+	for _, letter := range sortedLetters(destinations) {
+		items = append(items, letter+"="+destinations[letter])
+	}
+	return strings.Join(items, ",")
+}
+
+// destinationRoots returns the distinct directories the collections of an
+// encode with destinations were written to
+func destinationRoots(cfg EncodeConfig) []string {
+	var roots []string
+	seen := make(map[string]bool)
+	for _, dir := range cfg.collectionDirs() {
+		if !seen[dir] {
+			seen[dir] = true
+			roots = append(roots, dir)
+		}
+	}
+	return roots
+}
@@ -0,0 +1,123 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestParseDestinations(t *testing.T) {
+	got, err := ParseDestinations("a=/mnt/usb1, B=/mnt/usb2")
+	if err != nil {
+		t.Fatalf("ParseDestinations failed: %v", err)
+	}
+	if len(got) != 2 || got["A"] != "/mnt/usb1" || got["B"] != "/mnt/usb2" {
+		t.Errorf("Unexpected destinations: %v", got)
+	}
+	if spec := destinationSpec(got); spec != "A=/mnt/usb1,B=/mnt/usb2" {
+		t.Errorf("destinationSpec = %q", spec)
+	}
+	for _, spec := range []string{"A", "AB=/mnt/usb1", "1=/mnt/usb1", "A=", "A=/mnt/usb1,A=/mnt/usb2"} {
+		if _, err := ParseDestinations(spec); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ParseDestinations(%q) should fail, got %v", spec, err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		destinations map[string]string
+		outputDir    string
+		ok           bool
+	}{
+		{"Some, with an output directory", map[string]string{"A": "/a"}, "/out", true},
+		{"All, without one", map[string]string{"A": "/a", "B": "/b", "C": "/c"}, "", true},
+		{"Some, without one", map[string]string{"A": "/a"}, "", false},
+		{"Beyond the set", map[string]string{"D": "/d"}, "/out", false},
+		{"Shared directory", map[string]string{"A": "/a", "B": "/a/"}, "/out", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDestinations(tt.destinations, 3, tt.outputDir)
+			if tt.ok != (err == nil) {
+				t.Errorf("validateDestinations: expected ok=%v, got %v", tt.ok, err)
+			}
+		})
+	}
+}
+
+func TestEncodeDestinations(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	content := strings.Repeat("wallet seed ", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "seed.txt"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for _, zip := range []bool{false, true} {
+		name := map[bool]string{false: "dirs", true: "zips"}[zip]
+		t.Run(name, func(t *testing.T) {
+			root := filepath.Join(tempDir, name)
+			usb1, usb2, outputDir := filepath.Join(root, "usb1"), filepath.Join(root, "usb2"), filepath.Join(root, "output")
+			err := EncodeDirectory(ctx, EncodeConfig{
+				InputDir:       inputDir,
+				OutputDir:      outputDir,
+				Destinations:   map[string]string{"A": usb1, "B": usb2},
+				N:              3,
+				K:              2,
+				Format:         FormatBin,
+				ChunkSize:      1024,
+				RNG:            pad.NewDefaultRand(ctx),
+				Compression:    CompressionNone,
+				ZipCollections: zip,
+			})
+			if err != nil {
+				t.Fatalf("EncodeDirectory failed: %v", err)
+			}
+
+			// Each collection is alone in its directory
+			for dir, want := range map[string]string{usb1: "2A3", usb2: "2B3", outputDir: "2C3"} {
+				entries, err := os.ReadDir(dir)
+				if err != nil {
+					t.Fatalf("Failed to read %s: %v", dir, err)
+				}
+				if zip {
+					want += ".zip"
+				}
+				if len(entries) != 1 || entries[0].Name() != want {
+					t.Errorf("Expected only %s in %s, got %v", want, dir, entries)
+				}
+			}
+
+			// The collections on the two devices decode together
+			var collections []file.Collection
+			for _, dir := range []string{usb1, usb2} {
+				found, tempDir, err := file.FindCollections(ctx, dir)
+				if err != nil {
+					t.Fatalf("FindCollections failed: %v", err)
+				}
+				defer file.RemoveTemp(ctx, tempDir)
+				collections = append(collections, found...)
+			}
+			defer file.CloseCollections(collections)
+			restored := filepath.Join(root, "restored")
+			if err := DecodeDirectory(ctx, DecodeConfig{Collections: collections, OutputDir: restored, Compression: CompressionNone}); err != nil {
+				t.Fatalf("DecodeDirectory failed: %v", err)
+			}
+			data, err := os.ReadFile(filepath.Join(restored, "seed.txt"))
+			if err != nil || string(data) != content {
+				t.Errorf("Restored content differs: %v", err)
+			}
+		})
+	}
+}
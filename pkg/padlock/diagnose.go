@@ -0,0 +1,481 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// DiagnoseConfig holds configuration parameters for diagnosing a set of collections.
+// This structure is created by the command-line interface and passed to DiagnoseCollections.
+type DiagnoseConfig struct {
+	InputDir string    // Path to the directory containing collections to diagnose
+	Verbose  bool      // Enable verbose logging
+	Output   io.Writer // Where the report is written
+}
+
+// CollectionDiagnosis describes the state of one supplied collection
+type CollectionDiagnosis struct {
+	Name      string   // Collection name (e.g., "3A5")
+	Path      string   // Where the collection was found
+	Required  int      // K recorded in the collection name
+	Copies    int      // N recorded in the collection name
+	Chunks    int      // Chunk files present
+	Missing   []int    // Chunks absent although other chunks of the set exist
+	Truncated []int    // Chunks holding less payload than their header calls for
+	Corrupt   []string // Chunks that are unreadable or inconsistent with the collection
+	Rebuilt   []int    // Chunks missing or damaged on disk but rebuilt from the collection's parity
+	Session   int      // Apparent encode the collection belongs to, numbered from 1
+	Duplicate bool     // Another copy of the same collection of the same encode was found first
+
+	dataBytes map[int]int // Data bytes recorded in the header of each readable chunk
+	session   uint64      // Session ID recorded in its chunk headers, zero in legacy headers
+	sessioned bool        // Whether session has been read from a chunk
+}
+
+// Damaged reports whether any chunk of the collection is missing, truncated or corrupt
+func (c *CollectionDiagnosis) Damaged() bool {
+	return len(c.Missing) > 0 || len(c.Truncated) > 0 || len(c.Corrupt) > 0
+}
+
+// Diagnosis is the result of inspecting every collection supplied for a decode.
+// Collections are grouped into apparent encodes by their K-of-N parameters and
+// the session IDs and sizes recorded in their chunk headers, since collections
+// of one encode agree on all of them.
+type Diagnosis struct {
+	Collections   []*CollectionDiagnosis // Every collection found, sorted by name
+	Sessions      int                    // Number of apparent encodes among the collections
+	Session       int                    // The encode with the most distinct collections, which a decode can use
+	Required      int                    // K of that encode
+	Copies        int                    // N of that encode
+	Chunks        int                    // Chunks in each collection of that encode
+	Usable        []string               // Intact, distinct collections of that encode
+	Unrecoverable []int                  // Chunks intact in fewer than K collections of that encode
+	Warnings      []string               // Damage that a decode works around, one line each
+	Problems      []string               // What prevents a decode, one line each
+	Fixes         []string               // The smallest set of changes that makes the collections decodable
+
+	kind error // Class of the first problem, as one of the exported errors
+}
+
+// Decodable reports whether the supplied collections, as they are, can be decoded
+func (d *Diagnosis) Decodable() bool {
+	return len(d.Problems) == 0
+}
+
+// Err returns nil if the collections can be decoded, and otherwise an error
+// listing the problems that matches the class of the first of them with errors.Is
+func (d *Diagnosis) Err() error {
+	if d.Decodable() {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", d.kind, strings.Join(d.Problems, "; "))
+}
+
+// addProblem records a problem that prevents a decode, and its class
+func (d *Diagnosis) addProblem(kind error, problem string) {
+	if d.kind == nil {
+		d.kind = kind
+	}
+	d.Problems = append(d.Problems, problem)
+}
+
+// WriteReport writes a human-readable report of the diagnosis
+func (d *Diagnosis) WriteReport(w io.Writer) {
+	fmt.Fprintf(w, "Collections: %d\n", len(d.Collections))
+	for _, c := range d.Collections {
+		status := "OK"
+		switch {
+		case c.Duplicate:
+			status = "duplicate"
+		case d.Sessions > 1 && c.Session != d.Session:
+			status = fmt.Sprintf("different encode (#%d)", c.Session)
+		case c.Damaged():
+			status = "damaged"
+		}
+		fmt.Fprintf(w, "  %-6s %4d chunks  %-24s %s\n", c.Name, c.Chunks, status, c.Path)
+	}
+	if len(d.Warnings) > 0 {
+		fmt.Fprintf(w, "Warnings:\n")
+		for _, warning := range d.Warnings {
+			fmt.Fprintf(w, "  - %s\n", warning)
+		}
+	}
+	if len(d.Problems) == 0 {
+		if len(d.Warnings) > 0 {
+			fmt.Fprintf(w, "The collections can be decoded: every chunk is intact in at least %d of them.\n", d.Required)
+		} else {
+			fmt.Fprintf(w, "No problems found: any %d of these %d collections reconstruct the data.\n", d.Required, len(d.Usable))
+		}
+		return
+	}
+	fmt.Fprintf(w, "Problems:\n")
+	for _, p := range d.Problems {
+		fmt.Fprintf(w, "  - %s\n", p)
+	}
+	fmt.Fprintf(w, "To fix:\n")
+	for _, f := range d.Fixes {
+		fmt.Fprintf(w, "  - %s\n", f)
+	}
+}
+
+// DiagnoseCollections inspects the collections in cfg.InputDir and writes a
+// report of what, if anything, prevents them from being decoded. It returns an
+// error if they cannot be decoded as they are.
+func DiagnoseCollections(ctx context.Context, cfg DiagnoseConfig) error {
+	if err := file.ValidateInputDirectory(ctx, cfg.InputDir); err != nil {
+		return err
+	}
+
+	d, err := diagnoseDirectory(ctx, cfg.InputDir)
+	if err != nil {
+		return err
+	}
+	d.WriteReport(cfg.Output)
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("collections in %s cannot be decoded as they are: %w", cfg.InputDir, err)
+	}
+	return nil
+}
+
+// diagnoseDirectory finds and inspects the collections in inputDir
+func diagnoseDirectory(ctx context.Context, inputDir string) (*Diagnosis, error) {
+	collections, tempDir, err := file.FindCollections(ctx, inputDir)
+	if err != nil {
+		return nil, err
+	}
+	defer file.CloseCollections(collections)
+	defer file.RemoveTemp(ctx, tempDir)
+	return diagnose(ctx, collections), nil
+}
+
+// diagnose inspects every chunk of each collection and works out what stands
+// in the way of decoding them
+func diagnose(ctx context.Context, collections []file.Collection) *Diagnosis {
+	log := trace.FromContext(ctx).WithPrefix("DIAGNOSE")
+	d := &Diagnosis{}
+
+	// Inspect each collection on its own
+	var invalid []*CollectionDiagnosis
+	for _, coll := range collections {
+		c := inspectCollection(ctx, coll)
+		d.Collections = append(d.Collections, c)
+		if c.Required == 0 {
+			invalid = append(invalid, c)
+		}
+		log.Debugf("Collection %s: %d chunks, %d corrupt, %d truncated", c.Name, c.Chunks, len(c.Corrupt), len(c.Truncated))
+	}
+
+	// Group the collections into apparent encodes, comparing against the most
+	// intact member of each
+	ordered := make([]*CollectionDiagnosis, 0, len(d.Collections))
+	for _, c := range d.Collections {
+		if c.Required != 0 {
+			ordered = append(ordered, c)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return damage(ordered[i]) < damage(ordered[j])
+	})
+	var sessions [][]*CollectionDiagnosis
+	var lastChunks []int
+	for _, c := range ordered {
+		for i, members := range sessions {
+			if sameSession(members[0], c) {
+				c.Session = i + 1
+				sessions[i] = append(sessions[i], c)
+				break
+			}
+		}
+		if c.Session == 0 {
+			sessions = append(sessions, []*CollectionDiagnosis{c})
+			c.Session = len(sessions)
+		}
+	}
+	d.Sessions = len(sessions)
+
+	// Within each encode, every chunk up to the highest one seen must be present,
+	// and of several copies of a collection the least damaged one is kept
+	for _, members := range sessions {
+		seen := make(map[string]bool)
+		lastChunk := 0
+		for _, c := range members {
+			for n := range c.dataBytes {
+				lastChunk = max(lastChunk, n)
+			}
+		}
+		lastChunks = append(lastChunks, lastChunk)
+		for _, c := range members {
+			for n := 1; n <= lastChunk; n++ {
+				if _, ok := c.dataBytes[n]; !ok && !containsChunk(c.Corrupt, n) {
+					c.Missing = append(c.Missing, n)
+				}
+			}
+		}
+		sort.SliceStable(members, func(i, j int) bool {
+			return damage(members[i]) < damage(members[j])
+		})
+		for _, c := range members {
+			c.Duplicate = seen[c.Name]
+			seen[c.Name] = true
+		}
+	}
+
+	// The encode with the most distinct collections is the one to decode
+	best := 0
+	for i, members := range sessions {
+		if distinctNames(members) > distinctNames(sessions[best]) {
+			best = i
+		}
+	}
+	if len(sessions) == 0 {
+		d.addProblem(ErrBadLabel, "no collection has a valid name")
+		d.Fixes = append(d.Fixes, "Supply collections named as encoded, e.g. 3A5")
+		return d
+	}
+	d.Session = best + 1
+	chosen := sessions[best]
+	d.Required, d.Copies, d.Chunks = chosen[0].Required, chosen[0].Copies, lastChunks[best]
+	for _, c := range chosen {
+		if !c.Duplicate && !c.Damaged() {
+			d.Usable = append(d.Usable, c.Name)
+		}
+	}
+	sort.Strings(d.Usable)
+
+	// Report what is wrong and how to fix it
+	for _, c := range invalid {
+		d.addProblem(ErrBadLabel, fmt.Sprintf("%s is not a valid collection name", c.Path))
+		d.Fixes = append(d.Fixes, fmt.Sprintf("Remove %s", c.Path))
+	}
+	if d.Sessions > 1 {
+		var others []string
+		for i, members := range sessions {
+			if i == best {
+				continue
+			}
+			for _, c := range members {
+				others = append(others, fmt.Sprintf("%s (%s)", c.Name, c.Path))
+			}
+		}
+		d.addProblem(ErrSessionMismatch, fmt.Sprintf("collections come from %d different encodes: %d-of-%d collections %s were supplied together with %s",
+			d.Sessions, d.Required, d.Copies, strings.Join(distinctList(chosen), ", "), strings.Join(others, ", ")))
+		d.Fixes = append(d.Fixes, fmt.Sprintf("Remove %s, which belong to a different encode", strings.Join(others, ", ")))
+	}
+	for _, c := range chosen {
+		if len(c.Rebuilt) > 0 {
+			d.Warnings = append(d.Warnings, fmt.Sprintf("collection %s: chunks %s were missing or damaged and have been rebuilt from its parity", c.Name, chunkList(c.Rebuilt)))
+		}
+	}
+	for _, c := range chosen {
+		if c.Duplicate {
+			d.Warnings = append(d.Warnings, fmt.Sprintf("collection %s is supplied more than once; the copy at %s is not needed", c.Name, c.Path))
+		}
+	}
+	for _, c := range chosen {
+		if c.Duplicate || !c.Damaged() {
+			continue
+		}
+		var issues []string
+		if len(c.Missing) > 0 {
+			issues = append(issues, "missing chunks "+chunkList(c.Missing))
+		}
+		if len(c.Truncated) > 0 {
+			issues = append(issues, "truncated chunks "+chunkList(c.Truncated))
+		}
+		issues = append(issues, c.Corrupt...)
+		d.Warnings = append(d.Warnings, fmt.Sprintf("collection %s is damaged: %s", c.Name, strings.Join(issues, "; ")))
+	}
+
+	// Each chunk is decoded from any K collections that hold it intact, so the
+	// collections can be decoded as long as every chunk survives in K of them
+	short := 0
+	for n := 1; n <= d.Chunks; n++ {
+		intact := make(map[string]bool)
+		for _, c := range chosen {
+			if _, ok := c.dataBytes[n]; ok && !containsInt(c.Truncated, n) {
+				intact[c.Name] = true
+			}
+		}
+		if len(intact) < d.Required {
+			d.Unrecoverable = append(d.Unrecoverable, n)
+			short = max(short, d.Required-len(intact))
+		}
+	}
+	if present := distinctNames(chosen); present < d.Required {
+		d.addProblem(ErrInsufficientCollections, fmt.Sprintf("only %d collection(s) of the %d-of-%d set are present, %d are required",
+			present, d.Required, d.Copies, d.Required))
+		short = d.Required - present
+	} else if len(d.Unrecoverable) > 0 {
+		d.addProblem(ErrChunkCorrupt, fmt.Sprintf("chunks %s are intact in fewer than the %d required collections",
+			chunkList(d.Unrecoverable), d.Required))
+	}
+
+	// Any shortfall must be made up with intact collections, including intact
+	// copies of damaged ones
+	if short > 0 {
+		usable := make(map[string]bool)
+		for _, name := range d.Usable {
+			usable[name] = true
+		}
+		var candidates []string
+		for i := 0; i < d.Copies; i++ {
+			if name := pad.CollectionName(d.Required, d.Copies, i); !usable[name] {
+				candidates = append(candidates, name)
+			}
+		}
+		d.Fixes = append(d.Fixes, fmt.Sprintf("Add %d more intact collection(s) of the set, any of: %s", short, strings.Join(candidates, ", ")))
+	}
+	return d
+}
+
+// inspectCollection reads and checks every chunk of a collection
+func inspectCollection(ctx context.Context, coll file.Collection) *CollectionDiagnosis {
+	c := &CollectionDiagnosis{Name: coll.Name, Path: coll.Path, dataBytes: make(map[int]int)}
+	k, n, _, err := pad.ParseCollectionName(coll.Name)
+	if err != nil {
+		return c
+	}
+	c.Required, c.Copies = k, n
+
+	// Chunks are read as a decode reads them, so that those rebuilt from parity count as intact
+	source, err := file.NewChunkSource(ctx, coll)
+	if err != nil {
+		c.Corrupt = append(c.Corrupt, err.Error())
+		return c
+	}
+	present := make(map[int]bool)
+	for _, number := range source.ChunkNumbers() {
+		present[number] = true
+	}
+	type chunk struct {
+		number int
+		info   pad.ChunkInfo
+		err    error
+	}
+	var chunks []chunk
+	for number := 1; number <= source.LastChunk(); number++ {
+		data, err := source.ReadChunk(ctx, number)
+		if err != nil {
+			if present[number] {
+				c.Chunks++
+				c.Corrupt = append(c.Corrupt, fmt.Sprintf("chunk %d is unreadable", number))
+			}
+			continue
+		}
+		c.Chunks++
+		info, err := pad.InspectChunk(data)
+		chunks = append(chunks, chunk{number: number, info: info, err: err})
+	}
+
+	// Every chunk records the session of its encode, and the collection's is
+	// the one most of its chunks record, so that a stray chunk of
+	// another encode is reported rather than the chunks around it
+	votes := make(map[uint64]int)
+	for _, ch := range chunks {
+		if ch.err == nil && ch.info.Collection == coll.Name && ch.info.Number == ch.number && ch.info.PayloadBytes <= ch.info.ExpectedPayloadBytes(k, n) {
+			votes[ch.info.Session]++
+			if !c.sessioned || votes[ch.info.Session] > votes[c.session] {
+				c.session, c.sessioned = ch.info.Session, true
+			}
+		}
+	}
+	for _, ch := range chunks {
+		number, info := ch.number, ch.info
+		switch {
+		case ch.err != nil:
+			c.Corrupt = append(c.Corrupt, fmt.Sprintf("chunk %d has an invalid header", number))
+		case info.Collection != coll.Name:
+			c.Corrupt = append(c.Corrupt, fmt.Sprintf("chunk %d belongs to collection %s", number, info.Collection))
+		case info.Number != number:
+			c.Corrupt = append(c.Corrupt, fmt.Sprintf("chunk %d is labeled as chunk %d", number, info.Number))
+		case c.sessioned && info.Session != c.session:
+			c.Corrupt = append(c.Corrupt, fmt.Sprintf("chunk %d is from a different encode than the other chunks", number))
+		case info.PayloadBytes > info.ExpectedPayloadBytes(k, n):
+			c.Corrupt = append(c.Corrupt, fmt.Sprintf("chunk %d has %d bytes of unexpected data", number, info.PayloadBytes-info.ExpectedPayloadBytes(k, n)))
+		case info.PayloadBytes < info.ExpectedPayloadBytes(k, n):
+			c.dataBytes[number] = info.DataBytes
+			c.Truncated = append(c.Truncated, number)
+		default:
+			c.dataBytes[number] = info.DataBytes
+		}
+	}
+	c.Rebuilt = source.Rebuilt()
+	return c
+}
+
+// sameSession reports whether two collections appear to come from the same
+// encode: they share K and N and the session ID of their chunk headers, and
+// agree on the size of every chunk both hold
+func sameSession(a, b *CollectionDiagnosis) bool {
+	if a.Required != b.Required || a.Copies != b.Copies || (a.sessioned && b.sessioned && a.session != b.session) {
+		return false
+	}
+	for n, size := range a.dataBytes {
+		if other, ok := b.dataBytes[n]; ok && other != size {
+			return false
+		}
+	}
+	return true
+}
+
+// damage returns the number of chunks of a collection that are not intact
+func damage(c *CollectionDiagnosis) int {
+	return len(c.Missing) + len(c.Truncated) + len(c.Corrupt)
+}
+
+// containsInt reports whether numbers contains n
+func containsInt(numbers []int, n int) bool {
+	for _, m := range numbers {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// containsChunk reports whether a corrupt-chunk description refers to chunk n
+func containsChunk(corrupt []string, n int) bool {
+	prefix := fmt.Sprintf("chunk %d ", n)
+	for _, s := range corrupt {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// distinctNames returns the number of distinct collections of an encode
+func distinctNames(members []*CollectionDiagnosis) int {
+	return len(distinctList(members))
+}
+
+// distinctList returns the distinct collection names of an encode, sorted
+func distinctList(members []*CollectionDiagnosis) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, c := range members {
+		if !seen[c.Name] {
+			seen[c.Name] = true
+			names = append(names, c.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// chunkList formats chunk numbers as a comma-separated list
+func chunkList(numbers []int) string {
+	items := make([]string, len(numbers))
+	for i, n := range numbers {
+		items[i] = strconv.Itoa(n)
+	}
+	return strings.Join(items, ", ")
+}
@@ -0,0 +1,201 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestDiagnoseCollections(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "padlock-diagnose-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Two 3-of-4 encodes of different data, and another of the first
+	encode := func(name string, content string) string {
+		inputDir := filepath.Join(tempDir, name+"-input")
+		outputDir := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(inputDir, 0755); err != nil {
+			t.Fatalf("Failed to create input dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		cfg := EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   outputDir,
+			N:           4,
+			K:           3,
+			Format:      FormatBin,
+			ChunkSize:   1024,
+			RNG:         pad.NewDefaultRand(ctx),
+			Compression: CompressionNone,
+		}
+		if err := EncodeDirectory(ctx, cfg); err != nil {
+			t.Fatalf("Failed to encode directory: %v", err)
+		}
+		return outputDir
+	}
+	setA := encode("set-a", strings.Repeat("first set of data\n", 300))
+	setB := encode("set-b", strings.Repeat("second, longer set of data\n", 400))
+	setC := encode("set-c", strings.Repeat("first set of data\n", 300))
+
+	// assemble copies collections into a fresh input directory, then applies damage
+	assemble := func(name string, colls map[string]string, damage func(dir string)) string {
+		dir := filepath.Join(tempDir, name)
+		for dest, src := range colls {
+			if err := os.CopyFS(filepath.Join(dir, dest), os.DirFS(src)); err != nil {
+				t.Fatalf("Failed to copy %s: %v", src, err)
+			}
+		}
+		if damage != nil {
+			damage(dir)
+		}
+		return dir
+	}
+
+	tests := []struct {
+		name      string
+		colls     map[string]string
+		damage    func(dir string)
+		decodable bool
+		decodes   bool // Whether a decode succeeds anyway, setting aside the collections the diagnosis objects to
+		kind      error
+		warnings  []string
+		fixes     []string
+	}{
+		{
+			name:      "intact",
+			colls:     map[string]string{"3A4": filepath.Join(setA, "3A4"), "3B4": filepath.Join(setA, "3B4"), "3C4": filepath.Join(setA, "3C4")},
+			decodable: true,
+		},
+		{
+			name:  "missing chunk with a spare",
+			colls: map[string]string{"3A4": filepath.Join(setA, "3A4"), "3B4": filepath.Join(setA, "3B4"), "3C4": filepath.Join(setA, "3C4"), "3D4": filepath.Join(setA, "3D4")},
+			damage: func(dir string) {
+				os.Remove(filepath.Join(dir, "3B4", "3B4_0002.bin"))
+			},
+			decodable: true,
+			warnings:  []string{"collection 3B4 is damaged: missing chunks 2"},
+		},
+		{
+			name:  "truncated chunk without a spare",
+			colls: map[string]string{"3A4": filepath.Join(setA, "3A4"), "3B4": filepath.Join(setA, "3B4"), "3C4": filepath.Join(setA, "3C4")},
+			damage: func(dir string) {
+				path := filepath.Join(dir, "3C4", "3C4_0001.bin")
+				data, _ := os.ReadFile(path)
+				os.WriteFile(path, data[:len(data)/2], 0644)
+			},
+			kind:  ErrChunkCorrupt,
+			fixes: []string{"Add 1 more intact collection(s) of the set, any of: 3C4, 3D4"},
+		},
+		{
+			name:  "different encodes",
+			colls: map[string]string{"3A4": filepath.Join(setA, "3A4"), "3B4": filepath.Join(setA, "3B4"), "3C4": filepath.Join(setA, "3C4"), "x/3D4": filepath.Join(setB, "3D4")},
+			damage: func(dir string) {
+				os.Rename(filepath.Join(dir, "x", "3D4"), filepath.Join(dir, "3D4"))
+			},
+			decodes: true,
+			kind:    ErrSessionMismatch,
+			fixes:   []string{"which belong to a different encode"},
+		},
+		{
+			// Told apart by the session IDs in their chunk headers, as their sizes agree
+			name:  "different encodes of the same data",
+			colls: map[string]string{"3A4": filepath.Join(setA, "3A4"), "3B4": filepath.Join(setA, "3B4"), "3C4": filepath.Join(setA, "3C4"), "x/3D4": filepath.Join(setC, "3D4")},
+			damage: func(dir string) {
+				os.Rename(filepath.Join(dir, "x", "3D4"), filepath.Join(dir, "3D4"))
+			},
+			decodes: true,
+			kind:    ErrSessionMismatch,
+			fixes:   []string{"which belong to a different encode"},
+		},
+		{
+			// The chunks of a collection outvote a stray chunk of another encode
+			name:  "stray chunk of another encode",
+			colls: map[string]string{"3A4": filepath.Join(setA, "3A4"), "3B4": filepath.Join(setA, "3B4"), "3C4": filepath.Join(setA, "3C4"), "3D4": filepath.Join(setA, "3D4")},
+			damage: func(dir string) {
+				data, _ := os.ReadFile(filepath.Join(setC, "3B4", "3B4_0001.bin"))
+				os.WriteFile(filepath.Join(dir, "3B4", "3B4_0001.bin"), data, 0644)
+			},
+			decodable: true,
+			warnings:  []string{"chunk 1 is from a different encode than the other chunks"},
+		},
+		{
+			name:  "too few collections",
+			colls: map[string]string{"3A4": filepath.Join(setA, "3A4"), "3C4": filepath.Join(setA, "3C4")},
+			kind:  ErrInsufficientCollections,
+			fixes: []string{"Add 1 more intact collection(s) of the set, any of: 3B4, 3D4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := assemble(strings.ReplaceAll(tt.name, " ", "-"), tt.colls, tt.damage)
+			d, err := diagnoseDirectory(ctx, dir)
+			if err != nil {
+				t.Fatalf("diagnoseDirectory failed: %v", err)
+			}
+			var report strings.Builder
+			d.WriteReport(&report)
+			t.Logf("Report:\n%s", report.String())
+
+			if d.Decodable() != tt.decodable {
+				t.Errorf("Decodable() = %v, want %v", d.Decodable(), tt.decodable)
+			}
+			if !tt.decodable && !errors.Is(d.Err(), tt.kind) {
+				t.Errorf("Err() = %v, want %v", d.Err(), tt.kind)
+			}
+			warnings := strings.Join(d.Warnings, "\n")
+			for _, want := range tt.warnings {
+				if !strings.Contains(warnings, want) {
+					t.Errorf("Expected a warning containing %q, got:\n%s", want, warnings)
+				}
+			}
+			fixes := strings.Join(d.Fixes, "\n")
+			for _, want := range tt.fixes {
+				if !strings.Contains(fixes, want) {
+					t.Errorf("Expected a fix containing %q, got:\n%s", want, fixes)
+				}
+			}
+
+			// A decodable set decodes despite its damage, as does one whose other
+			// encodes are told apart by their session IDs, and a failing decode
+			// explains itself
+			restoreDir := filepath.Join(tempDir, "restore-"+filepath.Base(dir))
+			err = DecodeDirectory(ctx, DecodeConfig{
+				InputDir:    dir,
+				OutputDir:   restoreDir,
+				Compression: CompressionNone,
+			})
+			if tt.decodable || tt.decodes {
+				if err != nil {
+					t.Fatalf("Failed to decode: %v", err)
+				}
+				data, err := os.ReadFile(filepath.Join(restoreDir, "data.txt"))
+				if err != nil || string(data) != strings.Repeat("first set of data\n", 300) {
+					t.Errorf("Decoded data does not match the original: %v", err)
+				}
+			} else {
+				if err == nil || !strings.Contains(err.Error(), d.Problems[0]) {
+					t.Errorf("Expected the decode error to include %q, got %v", d.Problems[0], err)
+				}
+				if !errors.Is(err, tt.kind) {
+					t.Errorf("Expected the decode error to match %v, got %v", tt.kind, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package padlock
+
+import (
+	"errors"
+
+	"github.com/rayozzie/padlock/pkg/audit"
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+)
+
+// Errors returned by padlock, wrapped with the details of each failure, so that
+// callers can tell failures apart with errors.Is rather than by their text.
+// Most are detected by the pad and file packages and are the same values as
+// theirs, repeated here so that callers need only import padlock.
+var (
+	// ErrInvalidConfig means an option, or a combination of options, is not valid
+	ErrInvalidConfig = errors.New("invalid configuration")
+
+	// ErrInvalidParameters means the N and K of a set are out of range
+	ErrInvalidParameters = pad.ErrInvalidParameters
+
+	// ErrBadLabel means a collection name or chunk name is malformed
+	ErrBadLabel = pad.ErrBadLabel
+
+	// ErrInsufficientCollections means fewer than K collections of a set, or
+	// not every group of a hierarchical set, were supplied
+	ErrInsufficientCollections = pad.ErrInsufficientCollections
+
+	// ErrSessionMismatch means the collections supplied come from different encodes
+	ErrSessionMismatch = pad.ErrSessionMismatch
+
+	// ErrChunkCorrupt means chunks are missing or damaged in too many collections to be recovered
+	ErrChunkCorrupt = pad.ErrChunkCorrupt
+
+	// ErrPadsTooSmall means the input of an encode does not fit in the pads
+	// generated for it
+	ErrPadsTooSmall = pad.ErrPadsTooSmall
+
+	// ErrNoCollections means no collections were found in the input directory
+	ErrNoCollections = file.ErrNoCollections
+
+	// ErrOutputNotEmpty means the output directory holds files and neither
+	// clearing it nor a conflict policy was requested
+	ErrOutputNotEmpty = file.ErrOutputNotEmpty
+
+	// ErrFileExists means a restored file already exists and the conflict policy is to fail
+	ErrFileExists = file.ErrFileExists
+
+	// ErrNotArchive means the decoded data is not a valid archive
+	ErrNotArchive = file.ErrNotArchive
+
+	// ErrUnsafePath means an archive entry would be restored outside of the output directory
+	ErrUnsafePath = file.ErrUnsafePath
+
+	// ErrNoMatch means no archive entries matched the files selected for restore
+	ErrNoMatch = file.ErrNoMatch
+
+	// ErrOutputFull means the filesystem written to has too little space, or
+	// too few free files, for the collections
+	ErrOutputFull = file.ErrOutputFull
+
+	// ErrHashMismatch means restored files are missing or differ from the
+	// hashes recorded when they were encoded
+	ErrHashMismatch = file.ErrHashMismatch
+
+	// ErrSnapshotNotFound means the snapshot to decode is not recorded in the collections
+	ErrSnapshotNotFound = file.ErrSnapshotNotFound
+
+	// ErrMetadataMismatch means collections do not record the metadata
+	// required of a decode
+	ErrMetadataMismatch = errors.New("metadata mismatch")
+
+	// ErrExpired means collections are used after the expiry recorded in them,
+	// and their policy is enforced
+	ErrExpired = errors.New("collections expired")
+
+	// ErrAuditTampered means the records of an audit log do not form an intact
+	// chain, or a ceremony record does not match its signature
+	ErrAuditTampered = audit.ErrTampered
+
+	// ErrUnhealthyRNG means a random number generator failed its health tests
+	ErrUnhealthyRNG = pad.ErrUnhealthyRNG
+
+	// ErrNotRandom means pads or collection chunks failed the randomness
+	// checks under the fail policy, a sign of a bug or a broken generator
+	ErrNotRandom = pad.ErrNotRandom
+
+	// ErrOffline means an operation would use the network in offline mode
+	ErrOffline = pad.ErrOffline
+
+	// ErrCodeMismatch means a collection does not have the verification code
+	// issued with it
+	ErrCodeMismatch = errors.New("verification code mismatch")
+
+	// ErrVerifyFailed means the collections just written by an encode do not
+	// decode to the stream that was encoded
+	ErrVerifyFailed = errors.New("collections do not reconstruct the input")
+
+	// ErrInventoryMismatch means collections listed in a set manifest are
+	// missing or modified where they are kept
+	ErrInventoryMismatch = errors.New("collections missing or modified")
+)
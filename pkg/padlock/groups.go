@@ -0,0 +1,267 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// errGroupDecodeStopped is the error seen by a group's decoder when the shares are no longer being read
+var errGroupDecodeStopped = errors.New("decode stopped")
+
+// GroupPolicy is the threshold of one group of custodians in a hierarchical
+// scheme. Every group must reach its own threshold to reconstruct the data, so
+// "board:2of3,engineers:3of5" means 2 of the board AND 3 of the engineers.
+type GroupPolicy struct {
+	Name string // Group name, also the name of its directory (e.g., "board")
+	N    int    // Collections issued to the group
+	K    int    // Collections of the group required for reconstruction
+}
+
+// groupSpecPattern matches one group of a policy spec, e.g. "board:2of3"
+var groupSpecPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_.-]*):(\d+)of(\d+)$`)
+
+// ParseGroupPolicies parses a comma-separated policy spec of the form
+// "NAME:KofN,NAME:KofN,..." into group policies
+func ParseGroupPolicies(spec string) ([]GroupPolicy, error) {
+	var groups []GroupPolicy
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		m := groupSpecPattern.FindStringSubmatch(item)
+		if m == nil {
+			return nil, fmt.Errorf("invalid group %q: expected NAME:KofN, e.g. board:2of3", item)
+		}
+		k, _ := strconv.Atoi(m[2])
+		n, _ := strconv.Atoi(m[3])
+		groups = append(groups, GroupPolicy{Name: m[1], N: n, K: k})
+	}
+	return groups, validateGroupPolicies(groups)
+}
+
+// groupPolicySpec formats group policies in the form parsed by ParseGroupPolicies
+func groupPolicySpec(groups []GroupPolicy) string {
+	items := make([]string, len(groups))
+	for i, g := range groups {
+		items[i] = fmt.Sprintf("%s:%dof%d", g.Name, g.K, g.N)
+	}
+	return strings.Join(items, ",")
+}
+
+// validateGroupPolicies checks that a set of group policies can be encoded
+func validateGroupPolicies(groups []GroupPolicy) error {
+	if len(groups) < 2 || len(groups) > 26 {
+		return fmt.Errorf("between 2 and 26 groups are required, got %d", len(groups))
+	}
+	seen := make(map[string]bool)
+	for _, g := range groups {
+		if !groupSpecPattern.MatchString(groupPolicySpec([]GroupPolicy{g})) {
+			return fmt.Errorf("invalid group name %q", g.Name)
+		}
+		if seen[strings.ToLower(g.Name)] {
+			return fmt.Errorf("group %s is listed more than once", g.Name)
+		}
+		seen[strings.ToLower(g.Name)] = true
+		if g.N < 2 || g.N > 26 || g.K < 2 || g.K > g.N {
+			return fmt.Errorf("group %s: need 2 <= K <= N <= 26, got %dof%d", g.Name, g.K, g.N)
+		}
+	}
+	return nil
+}
+
+// encodeDirectoryGroups validates a hierarchical encode, prepares its output
+// directory and encodes the input stream into one subdirectory per group
+func encodeDirectoryGroups(ctx context.Context, cfg EncodeConfig, openInput func() (io.ReadCloser, error)) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	if err := validateGroupPolicies(cfg.Groups); err != nil {
+		log.Error(fmt.Errorf("%w: invalid group policy: %w", ErrInvalidConfig, err))
+		return fmt.Errorf("%w: invalid group policy: %w", ErrInvalidConfig, err)
+	}
+	if len(cfg.Targets) > 0 {
+		log.Error(fmt.Errorf("%w: targets cannot be combined with groups", ErrInvalidConfig))
+		return fmt.Errorf("%w: targets cannot be combined with groups", ErrInvalidConfig)
+	}
+	if err := file.PrepareOutputDirectory(ctx, cfg.OutputDir, cfg.ClearIfNotEmpty); err != nil {
+		return err
+	}
+
+	input, err := openInput()
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	return encodeGroups(ctx, cfg, input)
+}
+
+// encodeGroups encodes the input stream into a hierarchical set. The stream is
+// first split with an all-groups-required scheme into one share per group, and
+// each group's share is then encoded with that group's K-of-N scheme into the
+// group's own directory. Fewer than all groups, or fewer than K collections of
+// any group, reveal nothing about the data.
+func encodeGroups(ctx context.Context, cfg EncodeConfig, input io.Reader) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	names := make([]string, len(cfg.Groups))
+	for i, g := range cfg.Groups {
+		names[i] = g.Name
+	}
+
+	// The groups are encoded concurrently, so they share the RNG under a lock
+	rng := &lockedRNG{rng: cfg.RNG}
+
+	// Each group's encoder reads its share from a pipe fed by the outer split
+	writers := make([]io.Writer, len(cfg.Groups))
+	pipes := make([]*io.PipeWriter, len(cfg.Groups))
+	errs := make([]error, len(cfg.Groups))
+	var wg sync.WaitGroup
+	for i, g := range cfg.Groups {
+		pr, pw := io.Pipe()
+		writers[i], pipes[i] = pw, pw
+
+		groupCfg := cfg
+		groupCfg.OutputDir = filepath.Join(cfg.OutputDir, g.Name)
+		groupCfg.N, groupCfg.K = g.N, g.K
+		groupCfg.Groups = nil
+		groupCfg.RNG = rng
+
+		wg.Add(1)
+		go func(i int, g GroupPolicy) {
+			defer wg.Done()
+			groupCtx := trace.WithContext(ctx, log.WithPrefix("GROUP-"+strings.ToUpper(g.Name)))
+			err := encodeStream(groupCtx, groupCfg, func() (io.ReadCloser, error) {
+				return pr, nil
+			})
+			if err == nil {
+				err = file.WriteGroupManifest(ctx, groupCfg.OutputDir, file.GroupManifest{
+					Group:    g.Name,
+					Required: g.K,
+					Copies:   g.N,
+					Groups:   names,
+				})
+			}
+			// Unblock the outer split if this group stopped reading early
+			pr.CloseWithError(fmt.Errorf("group %s stopped", g.Name))
+			errs[i] = err
+		}(i, g)
+	}
+
+	// Split the input into one share per group, all of which are required
+	err := pad.EncodeToWritersWithRNG(ctx, input, writers, len(cfg.Groups), cfg.ChunkSize, rng)
+	for _, pw := range pipes {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+
+	for i, groupErr := range errs {
+		if groupErr != nil {
+			log.Error(fmt.Errorf("group %s: %w", cfg.Groups[i].Name, groupErr))
+			return fmt.Errorf("group %s: %w", cfg.Groups[i].Name, groupErr)
+		}
+	}
+	if err != nil {
+		log.Error(fmt.Errorf("failed to split input between groups: %w", err))
+		return fmt.Errorf("failed to split input between groups: %w", err)
+	}
+	return nil
+}
+
+// decodeGroups decodes each group of a hierarchical set to its share, then
+// combines the shares and hands the reconstructed stream to consume
+func decodeGroups(ctx context.Context, groupDirs []string, compression Compression, strict bool, consume func(ctx context.Context, r io.Reader) error) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	// Every group recorded in the manifests must be present
+	present := make(map[string]bool)
+	for _, dir := range groupDirs {
+		present[filepath.Base(dir)] = true
+	}
+	for _, dir := range groupDirs {
+		m, err := file.ReadGroupManifest(ctx, dir)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+		var missing []string
+		for _, name := range m.Groups {
+			if !present[name] {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			log.Error(fmt.Errorf("%w: missing group(s) %s: every group of %s is required", ErrInsufficientCollections, strings.Join(missing, ", "), strings.Join(m.Groups, ", ")))
+			return fmt.Errorf("%w: missing group(s) %s: every group of %s is required", ErrInsufficientCollections, strings.Join(missing, ", "), strings.Join(m.Groups, ", "))
+		}
+		break
+	}
+	log.Infof("Groups: %d", len(groupDirs))
+
+	// Each group decodes into a pipe read by the outer combination
+	readers := make([]io.Reader, len(groupDirs))
+	pipes := make([]*io.PipeReader, len(groupDirs))
+	errs := make([]error, len(groupDirs))
+	var wg sync.WaitGroup
+	for i, dir := range groupDirs {
+		pr, pw := io.Pipe()
+		readers[i], pipes[i] = pr, pr
+
+		wg.Add(1)
+		go func(i int, dir string) {
+			defer wg.Done()
+			groupCtx := trace.WithContext(ctx, log.WithPrefix("GROUP-"+strings.ToUpper(filepath.Base(dir))))
+			err := decodeCollections(groupCtx, dir, compressionRaw, strict, func(ctx context.Context, r io.Reader) error {
+				_, err := io.Copy(pw, r)
+				return err
+			})
+			pw.CloseWithError(err)
+			errs[i] = err
+		}(i, dir)
+	}
+
+	err := decodeReaders(ctx, readers, compression, strict, consume)
+	for _, pr := range pipes {
+		pr.CloseWithError(errGroupDecodeStopped)
+	}
+	wg.Wait()
+
+	// A group that could not reach its threshold explains any failure
+	for i, groupErr := range errs {
+		if groupErr != nil && err != nil && !errors.Is(groupErr, errGroupDecodeStopped) {
+			log.Error(fmt.Errorf("group %s: %w", filepath.Base(groupDirs[i]), groupErr))
+			return fmt.Errorf("group %s: %w", filepath.Base(groupDirs[i]), groupErr)
+		}
+	}
+	return err
+}
+
+// lockedRNG serializes access to an RNG shared between goroutines
+type lockedRNG struct {
+	mu  sync.Mutex
+	rng pad.RNG
+}
+
+// Name returns the name of the underlying RNG
+func (r *lockedRNG) Name() string {
+	return r.rng.Name()
+}
+
+// Read fills p from the underlying RNG
+func (r *lockedRNG) Read(ctx context.Context, p []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Read(ctx, p)
+}
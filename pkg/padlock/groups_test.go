@@ -0,0 +1,150 @@
+package padlock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestParseGroupPolicies(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    int
+		wantErr bool
+	}{
+		{"board:2of3,engineers:3of5", 2, false},
+		{" board:2of2 , ops:2of4 , legal:3of3 ", 3, false},
+		{"board:2of3", 0, true},
+		{"board:2of3,board:2of2", 0, true},
+		{"board:4of3,engineers:3of5", 0, true},
+		{"board=2of3,engineers:3of5", 0, true},
+		{"2A3:2of3,engineers:3of5", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			groups, err := ParseGroupPolicies(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseGroupPolicies(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && len(groups) != tt.want {
+				t.Errorf("ParseGroupPolicies(%q) returned %d groups, want %d", tt.spec, len(groups), tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeDecodeGroups(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "padlock-groups-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	outputDir := filepath.Join(tempDir, "output")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("grouped content\n", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	groups, err := ParseGroupPolicies("board:2of3,engineers:2of2")
+	if err != nil {
+		t.Fatalf("ParseGroupPolicies failed: %v", err)
+	}
+	rng := &countingRNG{rng: pad.NewDefaultRand(ctx)}
+	encodeConfig := EncodeConfig{
+		InputDir:    inputDir,
+		OutputDir:   outputDir,
+		Format:      FormatBin,
+		ChunkSize:   1024,
+		RNG:         rng,
+		Compression: CompressionGzip,
+		Groups:      groups,
+	}
+	before := pad.ReadMetrics().RNGBytes
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	// Every random byte, those of the split between groups included, comes
+	// from the encode's RNG
+	if drawn := pad.ReadMetrics().RNGBytes - before; rng.bytes.Load() != drawn {
+		t.Errorf("Expected all %d random bytes drawn to come from the encode's RNG, got %d", drawn, rng.bytes.Load())
+	}
+	for _, coll := range []string{"board/2A3", "board/2C3", "engineers/2B2", "board/padlock-group.json"} {
+		if _, err := os.Stat(filepath.Join(outputDir, coll)); err != nil {
+			t.Fatalf("Expected %s in the output: %v", coll, err)
+		}
+	}
+
+	// decode decodes the output directory into a fresh directory
+	decode := func(name string) error {
+		restoreDir := filepath.Join(tempDir, name)
+		cfg := DecodeConfig{
+			InputDir:    outputDir,
+			OutputDir:   restoreDir,
+			Compression: CompressionGzip,
+		}
+		if err := DecodeDirectory(ctx, cfg); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(restoreDir, "data.txt"))
+		if err != nil || string(data) != testContent {
+			t.Errorf("Restored data does not match the original (%v)", err)
+		}
+		return nil
+	}
+
+	// Two of the board and both engineers suffice
+	if err := os.RemoveAll(filepath.Join(outputDir, "board", "2B3")); err != nil {
+		t.Fatalf("Failed to remove collection: %v", err)
+	}
+	if err := decode("restore"); err != nil {
+		t.Fatalf("Failed to decode with every group at its threshold: %v", err)
+	}
+
+	// One engineer short of the engineers' threshold fails
+	if err := os.RemoveAll(filepath.Join(outputDir, "engineers", "2A2")); err != nil {
+		t.Fatalf("Failed to remove collection: %v", err)
+	}
+	if err := decode("restore-short"); err == nil {
+		t.Errorf("Expected decode to fail with a group below its threshold")
+	}
+
+	// A missing group fails
+	if err := os.RemoveAll(filepath.Join(outputDir, "engineers")); err != nil {
+		t.Fatalf("Failed to remove group: %v", err)
+	}
+	if err := decode("restore-missing"); err == nil || !strings.Contains(err.Error(), "engineers") {
+		t.Errorf("Expected decode to report the missing engineers group, got %v", err)
+	}
+}
+
+// countingRNG counts the bytes read from an RNG
+type countingRNG struct {
+	rng   pad.RNG
+	bytes atomic.Int64
+}
+
+func (r *countingRNG) Name() string {
+	return r.rng.Name()
+}
+
+func (r *countingRNG) Read(ctx context.Context, p []byte) error {
+	r.bytes.Add(int64(len(p)))
+	return r.rng.Read(ctx, p)
+}
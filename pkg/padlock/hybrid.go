@@ -0,0 +1,392 @@
+package padlock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/paddecode"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// HybridMode selects hybrid encryption, for inputs too large for every
+// collection to hold a pad of their size: the input is encrypted once with a
+// random key, and the K-of-N scheme splits only the key
+type HybridMode string
+
+const (
+	// HybridNone splits the input itself, as a one-time pad
+	HybridNone HybridMode = ""
+	// HybridShared keeps one copy of the encrypted data, next to the collections
+	HybridShared HybridMode = "shared"
+	// HybridReplicated keeps a copy of the encrypted data in each collection
+	HybridReplicated HybridMode = "replicated"
+)
+
+// hybridDataFile is the name of the file holding the encrypted data of a
+// hybrid encode
+const hybridDataFile = "padlock-data.enc"
+
+// hybridCipher names the cipher the data of a hybrid encode is encrypted with
+const hybridCipher = "XChaCha20-Poly1305"
+
+// hybridTradeoff states, in the data file and in the key record, what hybrid
+// encryption gives up
+const hybridTradeoff = "Only the key is split by the K-of-N scheme. The data is encrypted with " + hybridCipher +
+	", so it is as secure as that cipher and no more: unlike the collections, it does not have the" +
+	" information-theoretic security of a one-time pad."
+
+// hybridSegmentSize is the size of the segments the data is encrypted in,
+// each authenticated on its own so that decryption streams
+const hybridSegmentSize = 64 * 1024
+
+// hybridNoncePrefixSize is the size of the random prefix of the nonce of every
+// segment, which is followed by the segment number and a byte marking the last
+const hybridNoncePrefixSize = chacha20poly1305.NonceSizeX - 8 - 1
+
+// hybridMagic begins the stream that the collections of a hybrid encode hold
+// in place of the archive, followed by the key record. The data file begins
+// with hybridDataMagic, the nonce prefix and the trade-off, all of which are
+// authenticated along with each segment.
+var (
+	hybridMagic     = paddecode.HybridMagic
+	hybridDataMagic = []byte("padlock-hybrid-data\x00\x01")
+)
+
+// hybridRecord is the key record split by the K-of-N scheme
+type hybridRecord struct {
+	Cipher   string `json:"cipher"`
+	Key      []byte `json:"key"`
+	Nonce    []byte `json:"nonce"`
+	File     string `json:"file"`
+	Security string `json:"security"`
+}
+
+// encodeHybrid encrypts the stream returned by openInput into the data file in
+// cfg.OutputDir, and encodes its key into the collections in its place. The
+// data is encrypted once the output is ready, and copied into the collections
+// for HybridReplicated.
+func encodeHybrid(ctx context.Context, cfg EncodeConfig, openInput func() (io.ReadCloser, error)) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	log.Infof("Warning: hybrid encryption: %s", hybridTradeoff)
+
+	var recordStream []byte
+	defer func() { pad.Zeroize(recordStream) }()
+	openRecord := func() (io.ReadCloser, error) {
+		in, err := openInput()
+		if err != nil {
+			return nil, err
+		}
+		defer in.Close()
+		record, err := encryptHybridData(ctx, cfg.RNG, in, filepath.Join(cfg.OutputDir, hybridDataFile))
+		if err != nil {
+			return nil, err
+		}
+		defer pad.Zeroize(record.Key)
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		recordStream = append(append([]byte{}, hybridMagic...), encoded...)
+		pad.Zeroize(encoded)
+		return io.NopCloser(bytes.NewReader(recordStream)), nil
+	}
+
+	// The collections hold only the key, so the output limits are not checked
+	// against the size of the input
+	cfg.InputSize = 0
+	return encodeStream(ctx, cfg, openRecord)
+}
+
+// replicateHybridData moves the data file of a hybrid encode from outputDir
+// into each of the collections
+func replicateHybridData(ctx context.Context, outputDir string, collections []file.Collection) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	src := filepath.Join(outputDir, hybridDataFile)
+	for _, coll := range collections {
+		if err := copyHybridData(src, filepath.Join(coll.Path, hybridDataFile)); err != nil {
+			log.Error(fmt.Errorf("failed to copy encrypted data into collection %s: %w", coll.Name, err))
+			return fmt.Errorf("failed to copy encrypted data into collection %s: %w", coll.Name, err)
+		}
+	}
+	if err := os.Remove(src); err != nil {
+		log.Error(fmt.Errorf("failed to remove %s: %w", src, err))
+		return fmt.Errorf("failed to remove %s: %w", src, err)
+	}
+	log.Infof("Copied the encrypted data into each of %d collections", len(collections))
+	return nil
+}
+
+// copyHybridData copies the data file src to dst
+func copyHybridData(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// encryptHybridData encrypts r under a new random key into the data file at
+// path, and returns the record of the key
+func encryptHybridData(ctx context.Context, rng pad.RNG, r io.Reader, path string) (*hybridRecord, error) {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	record := &hybridRecord{
+		Cipher:   hybridCipher,
+		Key:      make([]byte, chacha20poly1305.KeySize),
+		Nonce:    make([]byte, hybridNoncePrefixSize),
+		File:     hybridDataFile,
+		Security: hybridTradeoff,
+	}
+	if err := rng.Read(ctx, record.Key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	if err := rng.Read(ctx, record.Nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(record.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create encrypted data file: %w", err))
+		return nil, fmt.Errorf("failed to create encrypted data file: %w", err)
+	}
+	defer f.Close()
+	w := &hybridWriter{aead: aead, w: bufio.NewWriter(f), header: hybridDataHeader(record.Nonce), prefix: record.Nonce}
+	if _, err := w.w.Write(w.header); err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(w, r)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		log.Error(fmt.Errorf("failed to write encrypted data: %w", err))
+		return nil, fmt.Errorf("failed to write encrypted data: %w", err)
+	}
+	log.Infof("Encrypted %s of data into %s", formatBytes(n), path)
+	return record, nil
+}
+
+// hybridDataHeader returns the header of a data file, holding the nonce prefix
+// and, readably, the trade-off of hybrid encryption
+func hybridDataHeader(prefix []byte) []byte {
+	header := append(append([]byte{}, hybridDataMagic...), prefix...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(hybridTradeoff)))
+	return append(header, hybridTradeoff...)
+}
+
+// hybridNonce returns the nonce of a segment
+func hybridNonce(prefix []byte, segment uint64, last bool) []byte {
+	nonce := binary.BigEndian.AppendUint64(append(make([]byte, 0, chacha20poly1305.NonceSizeX), prefix...), segment)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// hybridWriter encrypts what is written to it in segments. Close seals the
+// last segment, which may be empty, so that truncation is detected.
+type hybridWriter struct {
+	aead    cipher.AEAD
+	w       *bufio.Writer
+	header  []byte
+	prefix  []byte
+	segment uint64
+	buf     []byte
+}
+
+// Write implements io.Writer
+func (hw *hybridWriter) Write(p []byte) (int, error) {
+	hw.buf = append(hw.buf, p...)
+	for len(hw.buf) > hybridSegmentSize {
+		if err := hw.seal(hw.buf[:hybridSegmentSize], false); err != nil {
+			return 0, err
+		}
+		hw.buf = append(hw.buf[:0], hw.buf[hybridSegmentSize:]...)
+	}
+	return len(p), nil
+}
+
+// Close seals the last segment and flushes the file
+func (hw *hybridWriter) Close() error {
+	if err := hw.seal(hw.buf, true); err != nil {
+		return err
+	}
+	return hw.w.Flush()
+}
+
+// seal encrypts and writes one segment
+func (hw *hybridWriter) seal(plaintext []byte, last bool) error {
+	sealed := hw.aead.Seal(nil, hybridNonce(hw.prefix, hw.segment, last), plaintext, hw.header)
+	hw.segment++
+	pad.Zeroize(plaintext)
+	_, err := hw.w.Write(sealed)
+	return err
+}
+
+// isHybridStream reports whether a decoded stream is the key record of a hybrid encode
+func isHybridStream(br *bufio.Reader) bool {
+	magic, _ := br.Peek(len(hybridMagic))
+	return bytes.Equal(magic, hybridMagic)
+}
+
+// hybridDataKey is the context key of the places the data of a hybrid encode may be
+type hybridDataKey struct{}
+
+// withHybridData returns a context whose decodes of a hybrid encode look for
+// its data file in the collections being decoded and the directories holding them
+func withHybridData(ctx context.Context, collections []file.Collection) context.Context {
+	var opens []func(name string) (io.ReadCloser, error)
+	dirs := make(map[string]bool)
+	for _, coll := range collections {
+		opens = append(opens, coll.OpenFile)
+	}
+	for _, coll := range collections {
+		dir := filepath.Dir(coll.Path)
+		if !dirs[dir] {
+			dirs[dir] = true
+			opens = append(opens, func(name string) (io.ReadCloser, error) { return os.Open(filepath.Join(dir, name)) })
+		}
+	}
+	return context.WithValue(ctx, hybridDataKey{}, opens)
+}
+
+// openHybridStream reads the key record from a decoded stream and returns the
+// data it is the key of, decrypted. The data is read from the first of the
+// places recorded in the context that holds the data file of the same encode.
+func openHybridStream(ctx context.Context, br *bufio.Reader) (io.ReadCloser, error) {
+	log := trace.FromContext(ctx)
+
+	if _, err := br.Discard(len(hybridMagic)); err != nil {
+		return nil, err
+	}
+	var record hybridRecord
+	if err := json.NewDecoder(br).Decode(&record); err != nil {
+		return nil, fmt.Errorf("%w: invalid key record of hybrid-encrypted data: %w", ErrNotArchive, err)
+	}
+	defer pad.Zeroize(record.Key)
+	if record.Cipher != hybridCipher || len(record.Nonce) != hybridNoncePrefixSize || record.File != filepath.Base(record.File) {
+		return nil, fmt.Errorf("%w: key record of hybrid-encrypted data is for %s, which is not supported", ErrNotArchive, record.Cipher)
+	}
+	aead, err := chacha20poly1305.NewX(record.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotArchive, err)
+	}
+	log.Debugf("Collections hold the key of %s", record.File)
+
+	opens, _ := ctx.Value(hybridDataKey{}).([]func(name string) (io.ReadCloser, error))
+	header := hybridDataHeader(record.Nonce)
+	var problems []error
+	for _, open := range opens {
+		f, err := open(record.File)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				problems = append(problems, err)
+			}
+			continue
+		}
+		r := bufio.NewReaderSize(f, hybridSegmentSize+chacha20poly1305.Overhead)
+		if got, _ := r.Peek(len(header)); !bytes.Equal(got, header) {
+			f.Close()
+			problems = append(problems, fmt.Errorf("a %s is from a different encode", record.File))
+			continue
+		}
+		r.Discard(len(header))
+		return &hybridReader{aead: aead, r: r, f: f, name: record.File, header: header, prefix: record.Nonce}, nil
+	}
+	if err := errors.Join(problems...); err != nil {
+		return nil, fmt.Errorf("%w: %s, holding the data encrypted under the key in the collections, was not found with them (%w)", ErrChunkCorrupt, record.File, err)
+	}
+	return nil, fmt.Errorf("%w: %s, holding the data encrypted under the key in the collections, was not found with them", ErrChunkCorrupt, record.File)
+}
+
+// hybridReader decrypts the segments of a data file
+type hybridReader struct {
+	aead    cipher.AEAD
+	r       *bufio.Reader
+	f       io.Closer
+	name    string
+	header  []byte
+	prefix  []byte
+	segment uint64
+	buf     []byte
+	done    bool
+}
+
+// Read implements io.Reader
+func (hr *hybridReader) Read(p []byte) (int, error) {
+	for len(hr.buf) == 0 {
+		if hr.done {
+			return 0, io.EOF
+		}
+		if err := hr.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, hr.buf)
+	hr.buf = hr.buf[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next segment. A segment shorter than a full
+// one, or followed by nothing, is the last.
+func (hr *hybridReader) open() error {
+	sealed := make([]byte, hybridSegmentSize+chacha20poly1305.Overhead)
+	n, err := io.ReadFull(hr.r, sealed)
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		hr.done = true
+	case err == io.EOF:
+		return fmt.Errorf("%w: %s is truncated after segment %d", ErrChunkCorrupt, hr.name, hr.segment)
+	case err != nil:
+		return err
+	default:
+		if _, err := hr.r.Peek(1); err == io.EOF {
+			hr.done = true
+		}
+	}
+	plaintext, err := hr.aead.Open(sealed[:0], hybridNonce(hr.prefix, hr.segment, hr.done), sealed[:n], hr.header)
+	if err != nil {
+		if hr.done {
+			return fmt.Errorf("%w: %s is damaged or truncated at segment %d", ErrChunkCorrupt, hr.name, hr.segment)
+		}
+		return fmt.Errorf("%w: %s is damaged at segment %d", ErrChunkCorrupt, hr.name, hr.segment)
+	}
+	hr.segment++
+	hr.buf = plaintext
+	return nil
+}
+
+// Close closes the data file
+func (hr *hybridReader) Close() error {
+	return hr.f.Close()
+}
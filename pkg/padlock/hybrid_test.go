@@ -0,0 +1,181 @@
+package padlock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestHybrid(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	content := make([]byte, 3*hybridSegmentSize+100)
+	if err := pad.NewDefaultRand(ctx).Read(ctx, content); err != nil {
+		t.Fatalf("Failed to generate content: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "data.bin"), content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// encode encodes the input in the given mode, and returns the output directory
+	encode := func(t *testing.T, mode HybridMode, zip bool) string {
+		outputDir := filepath.Join(t.TempDir(), "encoded")
+		err := EncodeDirectory(ctx, EncodeConfig{
+			InputDir:       inputDir,
+			OutputDir:      outputDir,
+			N:              3,
+			K:              2,
+			Format:         FormatBin,
+			ChunkSize:      1024,
+			RNG:            pad.NewDefaultRand(ctx),
+			Compression:    CompressionGzip,
+			ZipCollections: zip,
+			Hybrid:         mode,
+		})
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		return outputDir
+	}
+	decode := func(t *testing.T, inputDir string) error {
+		outputDir := filepath.Join(t.TempDir(), "decoded")
+		err := DecodeDirectory(ctx, DecodeConfig{InputDir: inputDir, OutputDir: outputDir, Strict: true})
+		if err != nil {
+			return err
+		}
+		got, err := os.ReadFile(filepath.Join(outputDir, "data.bin"))
+		if err != nil || !bytes.Equal(got, content) {
+			t.Errorf("Decoded data does not match the input (%v)", err)
+		}
+		return nil
+	}
+
+	t.Run("Shared", func(t *testing.T) {
+		outputDir := encode(t, HybridShared, false)
+
+		// The collections hold only the key; the data is kept once, next to them
+		chunks, _ := filepath.Glob(filepath.Join(outputDir, "2A3", "*.bin"))
+		if len(chunks) != 1 {
+			t.Errorf("Expected the collection to hold a single chunk, got %v", chunks)
+		}
+		if err := decode(t, outputDir); err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+	})
+
+	t.Run("Replicated", func(t *testing.T) {
+		outputDir := encode(t, HybridReplicated, true)
+		if _, err := os.Stat(filepath.Join(outputDir, hybridDataFile)); !os.IsNotExist(err) {
+			t.Errorf("Expected the data to be kept only in the collections")
+		}
+
+		// Any two collections hold all that is needed
+		subsetDir := t.TempDir()
+		for _, name := range []string{"2A3.zip", "2C3.zip"} {
+			if err := os.Rename(filepath.Join(outputDir, name), filepath.Join(subsetDir, name)); err != nil {
+				t.Fatalf("Failed to move collection: %v", err)
+			}
+		}
+		if err := decode(t, subsetDir); err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+	})
+
+	t.Run("Damaged", func(t *testing.T) {
+		outputDir := encode(t, HybridShared, false)
+		dataPath := filepath.Join(outputDir, hybridDataFile)
+		data, err := os.ReadFile(dataPath)
+		if err != nil {
+			t.Fatalf("Failed to read data: %v", err)
+		}
+		data[len(data)-hybridSegmentSize] ^= 1
+		if err := os.WriteFile(dataPath, data, 0644); err != nil {
+			t.Fatalf("Failed to write data: %v", err)
+		}
+		if err := decode(t, outputDir); !errors.Is(err, ErrChunkCorrupt) {
+			t.Errorf("Expected altered data to fail, got %v", err)
+		}
+		if err := os.Remove(dataPath); err != nil {
+			t.Fatalf("Failed to remove data: %v", err)
+		}
+		if err := decode(t, outputDir); !errors.Is(err, ErrChunkCorrupt) {
+			t.Errorf("Expected missing data to fail, got %v", err)
+		}
+	})
+
+	t.Run("Segments", func(t *testing.T) {
+		for _, size := range []int{0, 1, hybridSegmentSize, hybridSegmentSize + 1, 2 * hybridSegmentSize} {
+			path := filepath.Join(t.TempDir(), hybridDataFile)
+			record, err := encryptHybridData(ctx, pad.NewDefaultRand(ctx), bytes.NewReader(content[:size]), path)
+			if err != nil {
+				t.Fatalf("%d bytes: failed to encrypt: %v", size, err)
+			}
+			encoded, err := json.Marshal(record)
+			if err != nil {
+				t.Fatalf("Failed to encode key record: %v", err)
+			}
+
+			// readBack decrypts the data file, cut to length
+			readBack := func(length int) ([]byte, error) {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("Failed to read data: %v", err)
+				}
+				stream := bytes.NewReader(append(append([]byte{}, hybridMagic...), encoded...))
+				ctx := context.WithValue(ctx, hybridDataKey{}, []func(string) (io.ReadCloser, error){
+					func(string) (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data[:length])), nil },
+				})
+				r, err := openHybridStream(ctx, bufio.NewReader(stream))
+				if err != nil {
+					return nil, err
+				}
+				defer r.Close()
+				return io.ReadAll(r)
+			}
+			info, _ := os.Stat(path)
+			got, err := readBack(int(info.Size()))
+			if err != nil || !bytes.Equal(got, content[:size]) {
+				t.Errorf("%d bytes: decrypted %d bytes, %v", size, len(got), err)
+			}
+
+			// Dropping the last segment, or part of it, is detected
+			if _, err := readBack(int(info.Size()) - 1); !errors.Is(err, ErrChunkCorrupt) {
+				t.Errorf("%d bytes: expected truncated data to fail, got %v", size, err)
+			}
+			if size >= hybridSegmentSize {
+				if _, err := readBack(int(info.Size()) - (size%hybridSegmentSize + 16)); !errors.Is(err, ErrChunkCorrupt) {
+					t.Errorf("%d bytes: expected data without its last segment to fail, got %v", size, err)
+				}
+			}
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, cfg := range map[string]EncodeConfig{
+			"mode":      {Hybrid: "split"},
+			"obfuscate": {Hybrid: HybridShared, ObfuscateNames: true},
+			"volumes":   {Hybrid: HybridReplicated, VolumeSize: 1 << 30},
+		} {
+			cfg.InputDir, cfg.OutputDir, cfg.N, cfg.K, cfg.Format, cfg.ChunkSize = inputDir, t.TempDir(), 3, 2, FormatBin, 1024
+			if err := cfg.Validate(ctx); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("%s: expected ErrInvalidConfig, got %v", name, err)
+			}
+		}
+	})
+}
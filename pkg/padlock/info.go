@@ -0,0 +1,109 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// InfoConfig holds configuration parameters for listing the collections found
+// under a set of directories. This structure is created by the command-line
+// interface and passed to CollectionInfo.
+type InfoConfig struct {
+	Roots   []string  // Directories to search for collections (default: DefaultRecoverRoots)
+	Verbose bool      // Enable verbose logging
+	Output  io.Writer // Where the listing is written
+}
+
+// CollectionInfo searches cfg.Roots for collections, reading only their chunk
+// headers, and lists them grouped by the encode they appear to come from, with
+// whether each group holds enough collections to decode and the metadata,
+// policy and labels recorded in them. Copies of a collection and lookalikes that aren't
+// collections are listed separately.
+func CollectionInfo(ctx context.Context, cfg InfoConfig) error {
+	log := trace.FromContext(ctx).WithPrefix("INFO")
+
+	roots := cfg.Roots
+	if len(roots) == 0 {
+		roots = DefaultRecoverRoots()
+	}
+	scan, err := file.ScanForCollections(ctx, roots)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer scan.Close()
+
+	out := cfg.Output
+	if len(scan.Candidates) == 0 {
+		fmt.Fprintf(out, "No collections found in %s\n", strings.Join(roots, ", "))
+	}
+
+	// Group the candidates by session, in the order first found
+	var sessions []string
+	bySession := make(map[string][]file.Candidate)
+	for _, c := range scan.Candidates {
+		if bySession[c.Session] == nil {
+			sessions = append(sessions, c.Session)
+		}
+		bySession[c.Session] = append(bySession[c.Session], c)
+	}
+	for i, session := range sessions {
+		group := bySession[session]
+		status := "can be decoded"
+		if len(group) < group[0].Required {
+			status = fmt.Sprintf("%d more needed to decode", group[0].Required-len(group))
+		}
+		fmt.Fprintf(out, "Set %d: %d-of-%d, %d chunks, %d of %d collections found, %s\n",
+			i+1, group[0].Required, group[0].Total, group[0].Chunks, len(group), group[0].Total, status)
+		colls := make([]file.Collection, len(group))
+		for i, c := range group {
+			colls[i] = c.Collection
+		}
+		if metadata := readMetadata(ctx, colls); metadata != nil {
+			fmt.Fprintf(out, "  Metadata: %s\n", formatMetadata(metadata))
+		}
+		if policy := readPolicy(ctx, colls); policy != nil {
+			fmt.Fprintf(out, "  Policy: %s\n", formatPolicy(policy, time.Now()))
+		}
+		labels := readLabels(ctx, colls)
+		found := make(map[string]bool)
+		for _, c := range group {
+			found[c.Name] = true
+			if label, ok := labels[c.Name]; ok {
+				fmt.Fprintf(out, "  %-6s %-4s %s (labeled %s)\n", c.Name, c.Format, c.Source, label)
+			} else {
+				fmt.Fprintf(out, "  %-6s %-4s %s\n", c.Name, c.Format, c.Source)
+			}
+			for _, dup := range c.Duplicates {
+				fmt.Fprintf(out, "         copy %s\n", dup)
+			}
+		}
+
+		// Name the shares to look for by their labels
+		var missing []string
+		for collName, label := range labels {
+			if !found[collName] {
+				missing = append(missing, fmt.Sprintf("%s (labeled %s)", collName, label))
+			}
+		}
+		sort.Strings(missing)
+		for _, m := range missing {
+			fmt.Fprintf(out, "  %s not found\n", m)
+		}
+	}
+	for _, s := range scan.Skipped {
+		fmt.Fprintf(out, "Skipped %s\n", s)
+	}
+
+	if len(scan.Candidates) == 0 {
+		return fmt.Errorf("%w in %s", ErrNoCollections, strings.Join(roots, ", "))
+	}
+	return nil
+}
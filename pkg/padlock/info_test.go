@@ -0,0 +1,71 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestCollectionInfo(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-info-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a context for this test
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	inputDir := filepath.Join(tempDir, "input")
+	encodeOutputDir := filepath.Join(tempDir, "encoded")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(strings.Repeat("listed\n", 300)), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	err = EncodeDirectory(ctx, EncodeConfig{
+		InputDir:       inputDir,
+		OutputDir:      encodeOutputDir,
+		N:              3,
+		K:              2,
+		Format:         FormatBin,
+		ChunkSize:      1024,
+		RNG:            pad.NewDefaultRand(ctx),
+		Compression:    CompressionGzip,
+		ZipCollections: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	t.Run("Collections found", func(t *testing.T) {
+		var output bytes.Buffer
+		if err := CollectionInfo(ctx, InfoConfig{Roots: []string{tempDir}, Output: &output}); err != nil {
+			t.Fatalf("CollectionInfo failed: %v", err)
+		}
+		report := output.String()
+		for _, want := range []string{"Set 1: 2-of-3", "3 of 3 collections found, can be decoded", filepath.Join(encodeOutputDir, "2B3.zip")} {
+			if !strings.Contains(report, want) {
+				t.Errorf("Expected the listing to contain %q, got:\n%s", want, report)
+			}
+		}
+	})
+
+	t.Run("No collections", func(t *testing.T) {
+		var output bytes.Buffer
+		err := CollectionInfo(ctx, InfoConfig{Roots: []string{inputDir}, Output: &output})
+		if !errors.Is(err, ErrNoCollections) {
+			t.Errorf("Expected ErrNoCollections, got %v", err)
+		}
+	})
+}
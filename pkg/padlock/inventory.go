@@ -0,0 +1,294 @@
+package padlock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// Statuses of the collections of an inventory check
+const (
+	InventoryOK       = "ok"       // Present, with every chunk as the manifest records it
+	InventoryModified = "modified" // Present, but with chunks changed, damaged, missing or added
+	InventoryMissing  = "missing"  // Not found at the location
+)
+
+// InventoryConfig holds configuration parameters for checking the collections
+// at a location against the manifest of their set. This structure is created
+// by the command-line interface and passed to CheckInventory.
+type InventoryConfig struct {
+	Manifest *SetManifest // The expected set, as exported by ExportManifest
+	Path     string       // The location to check: a collection, a bundle or a directory holding them
+	Partial  bool         // The location holds only some of the collections, so missing ones are not a failure
+	Verbose  bool         // Enable verbose logging
+	Output   io.Writer    // Where the report is written
+	JSON     bool         // Write the report as JSON rather than text
+}
+
+// InventoryReport is the result of checking a location against the manifest of a set
+type InventoryReport struct {
+	Checked     time.Time         `json:"checked"`              // When the location was checked
+	Path        string            `json:"path"`                 // The location checked
+	Collections []InventoryStatus `json:"collections"`          // Each collection of the manifest, in order
+	Unexpected  []string          `json:"unexpected,omitempty"` // Collections found that the manifest does not list, or from other encodes
+	Decodable   bool              `json:"decodable"`            // Whether the intact collections found are enough to decode
+	Partial     bool              `json:"partial,omitempty"`    // Whether missing collections were expected
+}
+
+// InventoryStatus is the state of one collection of the manifest at the location checked
+type InventoryStatus struct {
+	Group    string `json:"group,omitempty"`          // Group of a hierarchical set the collection belongs to
+	Name     string `json:"name"`                     // Collection name (e.g., "3A5")
+	Status   string `json:"status"`                   // InventoryOK, InventoryModified or InventoryMissing
+	Path     string `json:"path,omitempty"`           // Where the collection was found
+	Copies   int    `json:"copies,omitempty"`         // Copies of the collection found, if more than one
+	Missing  []int  `json:"missingChunks,omitempty"`  // Chunks of the manifest absent or unreadable
+	Modified []int  `json:"modifiedChunks,omitempty"` // Chunks whose hash differs from the manifest's
+	Extra    []int  `json:"extraChunks,omitempty"`    // Chunks the manifest does not list
+}
+
+// Healthy reports whether every collection expected is intact, or with a
+// partial check, whether every collection found is. Unexpected collections,
+// such as those of an older set kept alongside, are reported but not a failure.
+func (r *InventoryReport) Healthy() bool {
+	for _, c := range r.Collections {
+		if c.Status == InventoryModified || (c.Status == InventoryMissing && !r.Partial) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadSetManifest reads a set manifest exported by ExportManifest
+func ReadSetManifest(path string) (*SetManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m SetManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w: invalid set manifest %s: %w", ErrInvalidConfig, path, err)
+	}
+	if m.Version < 1 || m.Version > SetManifestVersion {
+		return nil, fmt.Errorf("%w: set manifest %s has unsupported version %d", ErrInvalidConfig, path, m.Version)
+	}
+	return &m, nil
+}
+
+// CheckInventory finds the collections at cfg.Path, reads every chunk, and
+// reports which collections of the manifest are present and intact, which are
+// modified and which are missing, for scheduled checks of the health of the
+// shares. It fails with ErrInventoryMismatch unless the location is healthy.
+func CheckInventory(ctx context.Context, cfg InventoryConfig) error {
+	log := trace.FromContext(ctx).WithPrefix("INVENTORY")
+
+	report, err := inventory(ctx, cfg.Manifest, cfg.Path)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	report.Partial = cfg.Partial
+	if cfg.JSON {
+		enc := json.NewEncoder(cfg.Output)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		report.write(cfg.Output)
+	}
+	if !report.Healthy() {
+		return fmt.Errorf("%w at %s", ErrInventoryMismatch, cfg.Path)
+	}
+	return nil
+}
+
+// inventorySet is one set of a manifest: the whole set, or one group of a hierarchical set
+type inventorySet struct {
+	group    string
+	session  string
+	required int
+	expected []CollectionManifest
+}
+
+// inventory checks the collections at path against the manifest m
+func inventory(ctx context.Context, m *SetManifest, path string) (*InventoryReport, error) {
+	log := trace.FromContext(ctx).WithPrefix("INVENTORY")
+
+	sets := []inventorySet{{session: m.Session, required: m.Required, expected: m.Collections}}
+	if len(m.Groups) > 0 {
+		sets = nil
+		for _, g := range m.Groups {
+			sets = append(sets, inventorySet{group: g.Group, session: g.Session, required: g.Required, expected: g.Collections})
+		}
+	}
+
+	// Find the collections at the location, in the group directories of a
+	// hierarchical set or else in any packaging
+	var found []file.Collection
+	groupDirs, _ := file.FindGroups(ctx, path)
+	for _, dir := range groupDirs {
+		collections, tempDir, err := file.FindCollections(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		defer file.RemoveTemp(ctx, tempDir)
+		defer file.CloseCollections(collections)
+		found = append(found, collections...)
+	}
+	if len(groupDirs) == 0 {
+		collections, tempDir, err := file.FindCollectionsAt(ctx, path)
+		if err != nil && !errors.Is(err, ErrNoCollections) {
+			return nil, err
+		}
+		defer file.RemoveTemp(ctx, tempDir)
+		defer file.CloseCollections(collections)
+		found = collections
+	}
+	log.Debugf("Found %d collections at %s", len(found), path)
+
+	report := &InventoryReport{Checked: time.Now().UTC(), Path: path}
+	statuses := make([][]InventoryStatus, len(sets))
+	for i, set := range sets {
+		for _, c := range set.expected {
+			statuses[i] = append(statuses[i], InventoryStatus{Group: set.group, Name: c.Name, Status: InventoryMissing})
+		}
+	}
+
+	// Compare each collection found with the one of its session and name
+	for _, coll := range found {
+		actual, _, s := collectionManifest(ctx, coll)
+		session := ""
+		if s != nil && *s != 0 {
+			session = fmt.Sprintf("%016x", *s)
+		}
+		matched, named := false, false
+		for i, set := range sets {
+			for j, expected := range set.expected {
+				if expected.Name != coll.Name {
+					continue
+				}
+				named = true
+				if set.session != "" && session != "" && set.session != session {
+					continue
+				}
+				matched = true
+				statuses[i][j] = betterStatus(statuses[i][j], compareCollection(statuses[i][j], expected, actual))
+			}
+		}
+		switch {
+		case !matched && named:
+			report.Unexpected = append(report.Unexpected, fmt.Sprintf("%s at %s is from another encode", coll.Name, coll.Path))
+		case !matched:
+			report.Unexpected = append(report.Unexpected, fmt.Sprintf("%s at %s is not in the manifest", coll.Name, coll.Path))
+		}
+	}
+	sort.Strings(report.Unexpected)
+
+	report.Decodable = true
+	for i, set := range sets {
+		intact := 0
+		for _, status := range statuses[i] {
+			if status.Status == InventoryOK {
+				intact++
+			}
+		}
+		if intact < set.required {
+			report.Decodable = false
+		}
+		report.Collections = append(report.Collections, statuses[i]...)
+	}
+	return report, nil
+}
+
+// compareCollection returns the status of a collection found, given its entry
+// in the manifest and the collection's status so far
+func compareCollection(prev InventoryStatus, expected, actual CollectionManifest) InventoryStatus {
+	status := InventoryStatus{Group: prev.Group, Name: expected.Name, Status: InventoryOK, Path: actual.Path, Copies: prev.Copies + 1}
+	hashes := make(map[int]string)
+	for _, chunk := range actual.Chunks {
+		if chunk.Error == "" {
+			hashes[chunk.Number] = chunk.SHA256
+		}
+	}
+	listed := make(map[int]bool)
+	for _, chunk := range expected.Chunks {
+		listed[chunk.Number] = true
+		switch sum, ok := hashes[chunk.Number]; {
+		case !ok:
+			status.Missing = append(status.Missing, chunk.Number)
+		case sum != chunk.SHA256:
+			status.Modified = append(status.Modified, chunk.Number)
+		}
+	}
+	for _, chunk := range actual.Chunks {
+		if !listed[chunk.Number] && chunk.Number > 0 {
+			status.Extra = append(status.Extra, chunk.Number)
+		}
+	}
+	if len(status.Missing) > 0 || len(status.Modified) > 0 || len(status.Extra) > 0 {
+		status.Status = InventoryModified
+	}
+	return status
+}
+
+// betterStatus returns the better of two copies of a collection: an intact
+// copy over a modified one, and of two modified copies the one with fewer
+// damaged chunks
+func betterStatus(prev, next InventoryStatus) InventoryStatus {
+	damaged := func(s InventoryStatus) int { return len(s.Missing) + len(s.Modified) + len(s.Extra) }
+	if prev.Status == InventoryMissing || damaged(next) < damaged(prev) {
+		return next
+	}
+	prev.Copies = next.Copies
+	return prev
+}
+
+// write writes the report as text
+func (r *InventoryReport) write(w io.Writer) {
+	fmt.Fprintf(w, "Inventory of %s, checked %s\n", r.Path, r.Checked.Format(time.RFC3339))
+	counts := make(map[string]int)
+	for _, c := range r.Collections {
+		counts[c.Status]++
+		name := c.Name
+		if c.Group != "" {
+			name = c.Group + "/" + c.Name
+		}
+		var details []string
+		if c.Path != "" {
+			details = append(details, c.Path)
+		}
+		if c.Copies > 1 {
+			details = append(details, fmt.Sprintf("%d copies, best shown", c.Copies))
+		}
+		if len(c.Modified) > 0 {
+			details = append(details, "changed "+chunkList(c.Modified))
+		}
+		if len(c.Missing) > 0 {
+			details = append(details, "missing "+chunkList(c.Missing))
+		}
+		if len(c.Extra) > 0 {
+			details = append(details, "unexpected "+chunkList(c.Extra))
+		}
+		fmt.Fprintf(w, "  %-12s %-9s %s\n", name, c.Status, strings.Join(details, "; "))
+	}
+	for _, u := range r.Unexpected {
+		fmt.Fprintf(w, "  Unexpected: %s\n", u)
+	}
+
+	decodable := "can be decoded"
+	if !r.Decodable {
+		decodable = "cannot be decoded"
+	}
+	fmt.Fprintf(w, "%d of %d collections intact, %d modified, %d missing; those intact %s\n",
+		counts[InventoryOK], len(r.Collections), counts[InventoryModified], counts[InventoryMissing], decodable)
+}
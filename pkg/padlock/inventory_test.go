@@ -0,0 +1,198 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestCheckInventory(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "seed.txt"), []byte(strings.Repeat("wallet seed ", 500)), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	encode := func(t *testing.T, outputDir string, modify func(cfg *EncodeConfig)) *SetManifest {
+		cfg := EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   outputDir,
+			N:           4,
+			K:           2,
+			Format:      FormatBin,
+			ChunkSize:   1024,
+			RNG:         pad.NewDefaultRand(ctx),
+			Compression: CompressionNone,
+		}
+		modify(&cfg)
+		if err := EncodeDirectory(ctx, cfg); err != nil {
+			t.Fatalf("EncodeDirectory failed: %v", err)
+		}
+		m, err := BuildManifest(ctx, outputDir)
+		if err != nil {
+			t.Fatalf("BuildManifest failed: %v", err)
+		}
+		return m
+	}
+	check := func(t *testing.T, m *SetManifest, path string, partial bool) (*InventoryReport, error) {
+		var out bytes.Buffer
+		err := CheckInventory(ctx, InventoryConfig{Manifest: m, Path: path, Partial: partial, Output: &out, JSON: true})
+		var report InventoryReport
+		if jerr := json.Unmarshal(out.Bytes(), &report); jerr != nil {
+			t.Fatalf("The report is not valid JSON: %v", jerr)
+		}
+		return &report, err
+	}
+	statuses := func(r *InventoryReport) string {
+		var s []string
+		for _, c := range r.Collections {
+			s = append(s, c.Name+"="+c.Status)
+		}
+		return strings.Join(s, ",")
+	}
+
+	t.Run("Healthy", func(t *testing.T) {
+		outputDir := filepath.Join(tempDir, "healthy")
+		m := encode(t, outputDir, func(cfg *EncodeConfig) {})
+		report, err := check(t, m, outputDir, false)
+		if err != nil {
+			t.Fatalf("CheckInventory failed: %v", err)
+		}
+		if statuses(report) != "2A4=ok,2B4=ok,2C4=ok,2D4=ok" || !report.Decodable || len(report.Unexpected) != 0 {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+
+		// The manifest survives a round trip through its file
+		var out bytes.Buffer
+		if err := ExportManifest(ctx, ManifestConfig{InputDir: outputDir, Output: &out}); err != nil {
+			t.Fatalf("ExportManifest failed: %v", err)
+		}
+		path := filepath.Join(tempDir, "healthy.json")
+		if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+		read, err := ReadSetManifest(path)
+		if err != nil {
+			t.Fatalf("ReadSetManifest failed: %v", err)
+		}
+		if _, err := check(t, read, outputDir, false); err != nil {
+			t.Errorf("CheckInventory failed with the manifest read back: %v", err)
+		}
+	})
+
+	t.Run("Damaged", func(t *testing.T) {
+		outputDir := filepath.Join(tempDir, "damaged")
+		m := encode(t, outputDir, func(cfg *EncodeConfig) {})
+
+		// Flip a byte of a chunk of B, and remove collection D
+		chunkPath := filepath.Join(outputDir, "2B4", file.ChunkFileName(file.FormatBin, "2B4", 1))
+		data, err := os.ReadFile(chunkPath)
+		if err != nil {
+			t.Fatalf("Failed to read chunk: %v", err)
+		}
+		data[len(data)-1] ^= 0xff
+		if err := os.WriteFile(chunkPath, data, 0644); err != nil {
+			t.Fatalf("Failed to write chunk: %v", err)
+		}
+		if err := os.RemoveAll(filepath.Join(outputDir, "2D4")); err != nil {
+			t.Fatalf("Failed to remove collection: %v", err)
+		}
+
+		report, err := check(t, m, outputDir, false)
+		if !errors.Is(err, ErrInventoryMismatch) {
+			t.Errorf("Expected ErrInventoryMismatch, got %v", err)
+		}
+		if statuses(report) != "2A4=ok,2B4=modified,2C4=ok,2D4=missing" || !report.Decodable {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+		if modified := report.Collections[1].Modified; len(modified) != 1 || modified[0] != 1 {
+			t.Errorf("Expected chunk 1 of 2B4 to be reported changed, got %v", modified)
+		}
+	})
+
+	t.Run("Partial", func(t *testing.T) {
+		outputDir := filepath.Join(tempDir, "partial")
+		m := encode(t, outputDir, func(cfg *EncodeConfig) {})
+
+		// A custodian's drive holding only collection A
+		report, err := check(t, m, filepath.Join(outputDir, "2A4"), true)
+		if err != nil {
+			t.Fatalf("CheckInventory failed: %v", err)
+		}
+		if statuses(report) != "2A4=ok,2B4=missing,2C4=missing,2D4=missing" || report.Decodable {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+		if _, err := check(t, m, filepath.Join(outputDir, "2A4"), false); !errors.Is(err, ErrInventoryMismatch) {
+			t.Errorf("Expected ErrInventoryMismatch without -partial, got %v", err)
+		}
+	})
+
+	t.Run("Foreign", func(t *testing.T) {
+		first, second := filepath.Join(tempDir, "first"), filepath.Join(tempDir, "second")
+		m := encode(t, first, func(cfg *EncodeConfig) {})
+		encode(t, second, func(cfg *EncodeConfig) {})
+
+		// Replace collection C with that of another encode of the same name
+		if err := os.RemoveAll(filepath.Join(first, "2C4")); err != nil {
+			t.Fatalf("Failed to remove collection: %v", err)
+		}
+		if err := os.Rename(filepath.Join(second, "2C4"), filepath.Join(first, "2C4")); err != nil {
+			t.Fatalf("Failed to move collection: %v", err)
+		}
+		report, err := check(t, m, first, false)
+		if !errors.Is(err, ErrInventoryMismatch) {
+			t.Errorf("Expected ErrInventoryMismatch, got %v", err)
+		}
+		if statuses(report) != "2A4=ok,2B4=ok,2C4=missing,2D4=ok" || len(report.Unexpected) != 1 || !strings.Contains(report.Unexpected[0], "another encode") {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+	})
+
+	t.Run("Groups", func(t *testing.T) {
+		outputDir := filepath.Join(tempDir, "groups")
+		groups, err := ParseGroupPolicies("board:2of3,engineers:2of2")
+		if err != nil {
+			t.Fatalf("ParseGroupPolicies failed: %v", err)
+		}
+		m := encode(t, outputDir, func(cfg *EncodeConfig) { cfg.Groups = groups })
+		if err := os.RemoveAll(filepath.Join(outputDir, "engineers", "2B2")); err != nil {
+			t.Fatalf("Failed to remove collection: %v", err)
+		}
+		report, err := check(t, m, outputDir, false)
+		if !errors.Is(err, ErrInventoryMismatch) {
+			t.Errorf("Expected ErrInventoryMismatch, got %v", err)
+		}
+		if len(report.Collections) != 5 || report.Decodable {
+			t.Fatalf("Unexpected report: %+v", report)
+		}
+		for _, c := range report.Collections {
+			if want := map[bool]string{true: InventoryMissing, false: InventoryOK}[c.Group == "engineers" && c.Name == "2B2"]; c.Status != want {
+				t.Errorf("Expected %s/%s to be %s, got %s", c.Group, c.Name, want, c.Status)
+			}
+		}
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		outputDir := filepath.Join(tempDir, "text")
+		m := encode(t, outputDir, func(cfg *EncodeConfig) {})
+		var out bytes.Buffer
+		if err := CheckInventory(ctx, InventoryConfig{Manifest: m, Path: outputDir, Output: &out}); err != nil {
+			t.Fatalf("CheckInventory failed: %v", err)
+		}
+		if !strings.Contains(out.String(), "4 of 4 collections intact, 0 modified, 0 missing; those intact can be decoded") {
+			t.Errorf("Unexpected report:\n%s", out.String())
+		}
+	})
+}
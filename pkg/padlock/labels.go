@@ -0,0 +1,130 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// FriendlyLabelAlphabet holds the characters of friendly collection labels:
+// the letters and digits, less those easily confused when written by hand,
+// I and 1, O and 0
+const FriendlyLabelAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// LabelsFriendly is the label spec giving the collections of a set the first
+// characters of FriendlyLabelAlphabet
+const LabelsFriendly = "friendly"
+
+// labelPattern matches one custom label, e.g. "ALPHA" or "vault-2"
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,31}$`)
+
+// ParseLabels parses a label spec for the n collections of a set: either
+// LabelsFriendly, or a comma-separated list of a label for each collection in
+// order, e.g. "ALPHA,BRAVO,CHARLIE". Labels name the physical shares a set is
+// written to; they are recorded in each collection, and the collections keep
+// the names (e.g. "3A5") that the decode needs.
+func ParseLabels(spec string, n int) ([]string, error) {
+	if strings.EqualFold(strings.TrimSpace(spec), LabelsFriendly) {
+		return FriendlyLabels(n)
+	}
+	var labels []string
+	for _, item := range strings.Split(spec, ",") {
+		labels = append(labels, strings.TrimSpace(item))
+	}
+	return labels, validateLabels(labels, n)
+}
+
+// FriendlyLabels returns the labels of the n collections of a set drawn from
+// FriendlyLabelAlphabet, e.g. A, B, ... H, J, K for the collections A to J
+func FriendlyLabels(n int) ([]string, error) {
+	if n < 1 || n > len(FriendlyLabelAlphabet) {
+		return nil, fmt.Errorf("%w: friendly labels are available for up to %d collections, not %d", ErrInvalidConfig, len(FriendlyLabelAlphabet), n)
+	}
+	labels := make([]string, n)
+	for i := range labels {
+		labels[i] = FriendlyLabelAlphabet[i : i+1]
+	}
+	return labels, nil
+}
+
+// validateLabels checks that there is a valid label for each of the n
+// collections of a set, and that no two of them could be mistaken for each
+// other, differing only in case
+func validateLabels(labels []string, n int) error {
+	if len(labels) != n {
+		return fmt.Errorf("%w: %d labels given for %d collections", ErrInvalidConfig, len(labels), n)
+	}
+	seen := make(map[string]bool)
+	for _, label := range labels {
+		if !labelPattern.MatchString(label) {
+			return fmt.Errorf("%w: invalid label %q: expected up to 32 letters, digits, '_', '.' or '-'", ErrInvalidConfig, label)
+		}
+		if seen[strings.ToUpper(label)] {
+			return fmt.Errorf("%w: label %q is given to more than one collection", ErrInvalidConfig, label)
+		}
+		seen[strings.ToUpper(label)] = true
+	}
+	return nil
+}
+
+// collectionLabels returns the label of each collection of a set, by name
+func collectionLabels(collNames []string, labels []string) map[string]string {
+	byName := make(map[string]string, len(collNames))
+	for i, collName := range collNames {
+		byName[collName] = labels[i]
+	}
+	return byName
+}
+
+// readLabels returns the labels recorded in the first of the collections that
+// holds them, or nil if none does
+func readLabels(ctx context.Context, collections []file.Collection) map[string]string {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	for _, coll := range collections {
+		labels, err := file.ReadLabels(ctx, coll)
+		if err != nil {
+			log.Debugf("Ignoring the labels of collection %s: %v", coll.Name, err)
+			continue
+		}
+		if labels != nil {
+			return labels
+		}
+	}
+	return nil
+}
+
+// logLabels logs the labels of the collections being decoded, and of those of
+// their set that are not, so that the shares holding them can be found by the
+// labels written on them
+func logLabels(ctx context.Context, collections []file.Collection) {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	labels := readLabels(ctx, collections)
+	if labels == nil {
+		return
+	}
+	supplied := make(map[string]bool)
+	var found, missing []string
+	for _, coll := range collections {
+		if label, ok := labels[coll.Name]; ok && !supplied[coll.Name] {
+			found = append(found, fmt.Sprintf("%s (%s)", coll.Name, label))
+		}
+		supplied[coll.Name] = true
+	}
+	for collName, label := range labels {
+		if !supplied[collName] {
+			missing = append(missing, fmt.Sprintf("%s (%s)", collName, label))
+		}
+	}
+	sort.Strings(missing)
+	log.Infof("Labeled collections: %s", strings.Join(found, ", "))
+	if len(missing) > 0 {
+		log.Infof("Labeled collections not supplied: %s", strings.Join(missing, ", "))
+	}
+}
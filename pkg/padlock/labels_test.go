@@ -0,0 +1,152 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestParseLabels(t *testing.T) {
+	t.Run("Friendly", func(t *testing.T) {
+		labels, err := ParseLabels("friendly", 10)
+		if err != nil {
+			t.Fatalf("ParseLabels failed: %v", err)
+		}
+		if got := strings.Join(labels, ""); got != "ABCDEFGHJK" {
+			t.Errorf("Expected friendly labels ABCDEFGHJK, got %s", got)
+		}
+		for _, label := range labels {
+			if strings.ContainsAny(label, "IO01") {
+				t.Errorf("Friendly label %q is easily confused", label)
+			}
+		}
+	})
+
+	t.Run("Too many friendly", func(t *testing.T) {
+		if _, err := FriendlyLabels(len(FriendlyLabelAlphabet)); err != nil {
+			t.Errorf("Expected friendly labels for %d collections: %v", len(FriendlyLabelAlphabet), err)
+		}
+		if _, err := FriendlyLabels(len(FriendlyLabelAlphabet) + 1); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig, got %v", err)
+		}
+	})
+
+	t.Run("Custom", func(t *testing.T) {
+		labels, err := ParseLabels("ALPHA, bravo ,vault-3", 3)
+		if err != nil {
+			t.Fatalf("ParseLabels failed: %v", err)
+		}
+		if got := strings.Join(labels, ","); got != "ALPHA,bravo,vault-3" {
+			t.Errorf("Unexpected labels: %s", got)
+		}
+	})
+
+	for name, spec := range map[string]string{
+		"Too few":   "ALPHA,BRAVO",
+		"Too many":  "A,B,C,D",
+		"Empty":     "ALPHA,,CHARLIE",
+		"Invalid":   "ALPHA,BRA VO,CHARLIE",
+		"Duplicate": "ALPHA,BRAVO,alpha",
+		"Too long":  "ALPHA,BRAVO," + strings.Repeat("C", 33),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseLabels(spec, 3); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("Expected ErrInvalidConfig for %q, got %v", spec, err)
+			}
+		})
+	}
+}
+
+func TestEncodeLabels(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "padlock-labels-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input directory: %v", err)
+	}
+	testContent := strings.Repeat("labeled content\n", 100)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	encodeConfig := func(outputDir string, labels []string) EncodeConfig {
+		return EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   filepath.Join(tempDir, outputDir),
+			N:           3,
+			K:           2,
+			Format:      FormatBin,
+			ChunkSize:   1024,
+			RNG:         pad.NewDefaultRand(ctx),
+			Compression: CompressionNone,
+			Labels:      labels,
+		}
+	}
+
+	for name, zip := range map[string]bool{"Directories": false, "Zip": true} {
+		t.Run(name, func(t *testing.T) {
+			outputDir := strings.ToLower(name)
+			cfg := encodeConfig(outputDir, []string{"ALPHA", "BRAVO", "CHARLIE"})
+			cfg.ZipCollections = zip
+			if err := EncodeDirectory(ctx, cfg); err != nil {
+				t.Fatalf("Failed to encode: %v", err)
+			}
+
+			collections, tempPath, err := file.FindCollections(ctx, cfg.OutputDir)
+			if err != nil || len(collections) != 3 {
+				t.Fatalf("Expected 3 collections, got %d (%v)", len(collections), err)
+			}
+			defer file.CloseCollections(collections)
+			defer file.RemoveTemp(ctx, tempPath)
+			want := map[string]string{"2A3": "ALPHA", "2B3": "BRAVO", "2C3": "CHARLIE"}
+			for _, coll := range collections {
+				labels, err := file.ReadLabels(ctx, coll)
+				if err != nil {
+					t.Fatalf("Failed to read labels of %s: %v", coll.Name, err)
+				}
+				for collName, label := range want {
+					if labels[collName] != label {
+						t.Errorf("Expected label %q for %s in %s, got %q", label, collName, coll.Name, labels[collName])
+					}
+				}
+			}
+
+			// Labels do not get in the way of the decode
+			restoreDir := filepath.Join(tempDir, outputDir+"-restore")
+			if err := DecodeDirectory(ctx, DecodeConfig{InputDir: cfg.OutputDir, OutputDir: restoreDir}); err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			data, err := os.ReadFile(filepath.Join(restoreDir, "data.txt"))
+			if err != nil || string(data) != testContent {
+				t.Errorf("Restored data does not match the original (%v)", err)
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		cfg := encodeConfig("wrong-count", []string{"ALPHA", "BRAVO"})
+		if err := cfg.Validate(ctx); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig for too few labels, got %v", err)
+		}
+		cfg = encodeConfig("snapshot", []string{"ALPHA", "BRAVO", "CHARLIE"})
+		cfg.Snapshot = "monday"
+		if err := cfg.Validate(ctx); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig for labels of a snapshot, got %v", err)
+		}
+	})
+}
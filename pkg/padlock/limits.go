@@ -0,0 +1,234 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// collectionExtraFiles is the number of files, besides chunks, parity and
+// decoders, that a collection may hold: its directory, recovery instructions,
+// manifests and the like
+const collectionExtraFiles = 8
+
+// openFilesReserve is the number of open files left for the input, logs and
+// everything else when zips are streamed, each holding a file open throughout
+const openFilesReserve = 64
+
+// minPieceBytes is the least input that each piece of a chunk carries, so that
+// a set with many pieces in each chunk doesn't take a file for every few bytes
+// of input, or fail for want of room for even one
+const minPieceBytes = 64
+
+// MinChunkSize returns the smallest chunk size an encode of a K-of-N set uses:
+// room in each of the C(N-1, K-1) pieces of a chunk for 64 bytes of input,
+// after the marker and count that lead each piece of a padded chunk.
+// EncodeDirectory raises a smaller chunk size to it, with a warning.
+func MinChunkSize(n, k int, padded bool) int {
+	return pad.MinChunkSize(n, k, padded) + pad.PiecesPerCollection(k, n)*(minPieceBytes-1)
+}
+
+// LowMemoryChunkSize is the largest chunk size of an encode with LowMemory,
+// which bounds the buffers of each chunk: the input it encodes, its pads and
+// the chunk of each collection, all held at once
+const LowMemoryChunkSize = 256 * 1024
+
+// lowMemoryGCPercent is the garbage collector's target under ConfigureLowMemory,
+// collecting once the heap grows by a quarter rather than doubling
+const lowMemoryGCPercent = 25
+
+// ConfigureLowMemory sets up the process for devices with little memory, such
+// as Raspberry Pi-class single-board computers: Go code runs on one thread,
+// so nothing is done in parallel, and garbage is collected sooner, keeping the
+// heap close to what is in use. Together with LowMemory's smaller chunks, and
+// the formatters writing each chunk straight to its file, an encode or decode
+// then needs little more memory than a few chunks.
+func ConfigureLowMemory(ctx context.Context) {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	runtime.GOMAXPROCS(1)
+	debug.SetGCPercent(lowMemoryGCPercent)
+	log.Debugf("Low-memory mode: one thread, garbage collected at %d%% heap growth, chunks of at most %d bytes",
+		lowMemoryGCPercent, LowMemoryChunkSize)
+}
+
+// raiseChunkSize returns the chunk size of an encode, lowered to
+// LowMemoryChunkSize with LowMemory and raised to the MinChunkSize of each of
+// its sets that it falls short of, each with a warning. The minimum wins, as
+// a set cannot be encoded in smaller chunks.
+func raiseChunkSize(ctx context.Context, cfg EncodeConfig) int {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	size := cfg.ChunkSize
+	if cfg.LowMemory && size > LowMemoryChunkSize {
+		log.Infof("Warning: chunk size %d is too large for low-memory mode; lowering it to %d", size, LowMemoryChunkSize)
+		size = LowMemoryChunkSize
+	}
+	padded := cfg.PadLength || cfg.DecoyDir != ""
+	for _, set := range cfg.sets() {
+		if pad.CheckParameters(set.N, set.K) != nil {
+			continue
+		}
+		if minSize := MinChunkSize(set.N, set.K, padded); size < minSize {
+			log.Infof("Warning: chunk size %d is too small for the %d pieces in each chunk of a %d-of-%d set; raising it to %d",
+				size, pad.PiecesPerCollection(set.K, set.N), set.K, set.N, minSize)
+			size = minSize
+		}
+	}
+	return size
+}
+
+// outputNeeds is an estimate of what the collections of an encode take
+type outputNeeds struct {
+	chunks int64 // Chunk files per collection
+	files  int64 // Files per collection, written as a directory
+	bytes  int64 // Bytes per collection
+}
+
+// estimateOutputNeeds estimates the files and bytes that each collection of
+// an encode of inputSize bytes takes, given the number of permutations whose
+// ciphers share each chunk
+func estimateOutputNeeds(cfg EncodeConfig, inputSize int64, permutations int) outputNeeds {
+	var needs outputNeeds
+	inputChunkBytes := int64(cfg.ChunkSize / permutations)
+	if inputChunkBytes <= 0 {
+		return needs
+	}
+	needs.chunks = max(1, (inputSize+inputChunkBytes-1)/inputChunkBytes) + int64(cfg.PadChunks)
+	needs.files = needs.chunks + collectionExtraFiles
+	needs.bytes = estimateCollectionSize(inputSize, cfg.N, cfg.K)
+	if cfg.PadLength {
+		needs.bytes = needs.chunks * int64(cfg.ChunkSize)
+	}
+	if cfg.ParityPercent > 0 {
+		needs.files += file.ParityFiles(needs.chunks, cfg.ParityPercent)
+		needs.bytes += needs.bytes * int64(cfg.ParityPercent) / 100
+	}
+	if len(cfg.Decoders) > 0 {
+		needs.files += int64(len(cfg.Decoders)) + 1
+		for _, d := range cfg.Decoders {
+			needs.bytes += int64(len(d.Data))
+		}
+	}
+	return needs
+}
+
+// checkOutputLimits compares what the collections of an encode will take with
+// what the filesystems they are written to have left, so that an encode that
+// cannot fit fails before writing anything rather than midway. It returns
+// whether zips can still be streamed: each streamed zip holds a file open
+// throughout, so when too few files may be open at once, the collections are
+// instead written as directories and zipped one at a time.
+func checkOutputLimits(ctx context.Context, cfg EncodeConfig, permutations int, streamZips bool) (bool, error) {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	if streamZips {
+		limits := file.GetFilesystemLimits(cfg.collectionDirs()[0])
+		if limits.OpenFiles >= 0 && limits.OpenFiles < int64(cfg.N)+openFilesReserve {
+			log.Infof("Warning: at most %d files may be open at once, too few to stream %d collections into single files; writing them as directories and archiving them one at a time", limits.OpenFiles, cfg.N)
+			streamZips = false
+		}
+	}
+	if cfg.InputSize <= 0 {
+		return streamZips, nil
+	}
+	needs := estimateOutputNeeds(cfg, cfg.InputSize, permutations)
+	if needs.chunks == 0 {
+		return streamZips, nil
+	}
+	log.Debugf("Each collection takes about %d files (%d chunks) and %s", needs.files, needs.chunks, formatBytes(needs.bytes))
+
+	// Collections written to targets each take one filesystem to themselves
+	if len(cfg.Targets) > 0 {
+		for _, target := range cfg.Targets {
+			if err := checkFilesystem(ctx, cfg, permutations, target, 1, needs, needs.files, needs.bytes); err != nil {
+				return streamZips, err
+			}
+		}
+		return streamZips, nil
+	}
+
+	// A streamed zip is a single file. Collections archived afterward are
+	// archived one at a time, each alongside the others, so the last takes
+	// room for both its directory and its archive. Collections with
+	// destinations are counted against the directory they are written to.
+	counts := make(map[string]int)
+	var dirs []string
+	for _, dir := range cfg.collectionDirs() {
+		if counts[dir] == 0 {
+			dirs = append(dirs, dir)
+		}
+		counts[dir]++
+	}
+	for _, dir := range dirs {
+		n := int64(counts[dir])
+		files, bytes := n*needs.files, n*needs.bytes
+		if streamZips {
+			files = n
+		} else if cfg.ZipCollections || cfg.TarCollections || cfg.Format == FormatPacked {
+			files += 1
+			bytes += needs.bytes
+		}
+		if err := checkFilesystem(ctx, cfg, permutations, dir, counts[dir], needs, files, bytes); err != nil {
+			return streamZips, err
+		}
+	}
+	return streamZips, nil
+}
+
+// checkFilesystem checks that the filesystem holding dir has room for the
+// given number of files and bytes, taken by collections collections
+func checkFilesystem(ctx context.Context, cfg EncodeConfig, permutations int, dir string, collections int, needs outputNeeds, files int64, bytes int64) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	limits := file.GetFilesystemLimits(dir)
+	if limits.FreeFiles >= 0 && files > limits.FreeFiles {
+		advice := "stream the collections into zips (-zip), or free up files on it"
+		if size := suggestChunkSize(cfg, permutations, limits.FreeFiles/int64(collections)-(needs.files-needs.chunks)); size > 0 {
+			advice = fmt.Sprintf("use larger chunks (-chunk %d or more), %s", size, advice)
+		}
+		log.Error(fmt.Errorf("%w: %s has room for %d more files, but the collections take about %d; %s", ErrOutputFull, dir, limits.FreeFiles, files, advice))
+		return fmt.Errorf("%w: %s has room for %d more files, but the collections take about %d; %s", ErrOutputFull, dir, limits.FreeFiles, files, advice)
+	}
+	if limits.FreeBytes >= 0 && bytes > limits.FreeBytes {
+		// The estimate is of the input before compression, which shrinks most
+		// documents, so a compressed encode may well fit
+		if cfg.Compression != CompressionNone {
+			log.Infof("Warning: %s has %s free, and the collections take up to %s before compression; the encode fails if the data doesn't compress enough", dir, formatBytes(limits.FreeBytes), formatBytes(bytes))
+			return nil
+		}
+		log.Error(fmt.Errorf("%w: %s has %s free, but the collections take about %s", ErrOutputFull, dir, formatBytes(limits.FreeBytes), formatBytes(bytes)))
+		return fmt.Errorf("%w: %s has %s free, but the collections take about %s", ErrOutputFull, dir, formatBytes(limits.FreeBytes), formatBytes(bytes))
+	}
+	return nil
+}
+
+// suggestChunkSize returns a chunk size, rounded up to a whole megabyte, at
+// which each collection takes at most the given number of chunk files, or 0 if
+// there is none
+func suggestChunkSize(cfg EncodeConfig, permutations int, chunks int64) int64 {
+	const megabyte = 1024 * 1024
+	dataChunks := chunks - int64(cfg.PadChunks)
+	if dataChunks <= 0 {
+		return 0
+	}
+	size := (cfg.InputSize + dataChunks - 1) / dataChunks * int64(permutations)
+	return (size + megabyte - 1) / megabyte * megabyte
+}
+
+// outputFullError reports running out of space, files or open files midway
+// through writing the collections as such, with what to do about it
+func outputFullError(err error) error {
+	switch {
+	case file.IsNoSpace(err):
+		return fmt.Errorf("%w: %w; free up space or files on the output, or use larger chunks (-chunk) or zips (-zip)", ErrOutputFull, err)
+	case file.IsTooManyOpenFiles(err):
+		return fmt.Errorf("%w; raise the limit of open files (ulimit -n)", err)
+	}
+	return err
+}
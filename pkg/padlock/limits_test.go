@@ -0,0 +1,167 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestEstimateOutputNeeds(t *testing.T) {
+	// 2 of 3 puts 2 permutations in each chunk, so 500 bytes of input per 1000-byte chunk
+	base := EncodeConfig{N: 3, K: 2, ChunkSize: 1000}
+	withParity := base
+	withParity.ParityPercent = 10
+	withDecoders := base
+	withDecoders.Decoders = []file.Decoder{{OS: "linux", Arch: "amd64", Data: make([]byte, 100)}}
+	padded := base
+	padded.PadLength, padded.PadChunks = true, 5
+
+	tests := []struct {
+		name   string
+		cfg    EncodeConfig
+		expect outputNeeds
+	}{
+		{"Chunks", base, outputNeeds{chunks: 20, files: 28, bytes: 20000}},
+		{"Parity", withParity, outputNeeds{chunks: 20, files: 30, bytes: 22000}},
+		{"Decoders", withDecoders, outputNeeds{chunks: 20, files: 30, bytes: 20100}},
+		{"Padded", padded, outputNeeds{chunks: 25, files: 33, bytes: 25000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateOutputNeeds(tt.cfg, 10000, 2); got != tt.expect {
+				t.Errorf("estimateOutputNeeds = %+v, want %+v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestMinChunkSize(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// 13 of 26 puts C(25, 12) pieces in each chunk, so the default 2 MB chunk
+	// would leave each piece empty
+	tests := []struct {
+		name   string
+		cfg    EncodeConfig
+		expect int
+	}{
+		{"Large enough", EncodeConfig{N: 3, K: 2, ChunkSize: 1024}, 1024},
+		{"Raised", EncodeConfig{N: 3, K: 2, ChunkSize: 64}, 2 * 64},
+		{"Raised when padded", EncodeConfig{N: 3, K: 2, ChunkSize: 64, PadLength: true}, 2 * (64 + 24)},
+		{"Raised for a decoy", EncodeConfig{N: 3, K: 2, ChunkSize: 64, DecoyDir: "decoy"}, 2 * (64 + 24)},
+		{"13 of 26", EncodeConfig{N: 26, K: 13, ChunkSize: 2 << 20}, 5200300 * 64},
+		{"13 of 26 padded", EncodeConfig{N: 26, K: 13, ChunkSize: 2 << 20, PadLength: true}, 5200300 * (64 + 24)},
+		{"Largest group", EncodeConfig{Groups: []GroupPolicy{{Name: "a", N: 2, K: 2}, {Name: "b", N: 10, K: 5}}, ChunkSize: 1024}, 126 * 64},
+		{"Custodians", EncodeConfig{Custodians: []Custodian{{Name: "a", Weight: 3}, {Name: "b", Weight: 3}}, K: 3, ChunkSize: 0}, 10 * 64},
+		{"Invalid set left alone", EncodeConfig{N: 30, K: 2, ChunkSize: 1}, 1},
+		{"Lowered for low memory", EncodeConfig{N: 3, K: 2, ChunkSize: 2 << 20, LowMemory: true}, LowMemoryChunkSize},
+		{"Small enough for low memory", EncodeConfig{N: 3, K: 2, ChunkSize: 1024, LowMemory: true}, 1024},
+		{"Raised above low memory", EncodeConfig{N: 26, K: 13, ChunkSize: 2 << 20, LowMemory: true}, 5200300 * 64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := raiseChunkSize(ctx, tt.cfg); got != tt.expect {
+				t.Errorf("raiseChunkSize = %d, want %d", got, tt.expect)
+			}
+		})
+	}
+
+	// A chunk size at the minimum leaves every piece its share of the input
+	for n := 2; n <= 26; n++ {
+		for k := 2; k <= n; k++ {
+			size := MinChunkSize(n, k, false)
+			if size <= 0 || size/pad.PiecesPerCollection(k, n) != minPieceBytes {
+				t.Fatalf("MinChunkSize(%d, %d) = %d leaves pieces of the wrong size", n, k, size)
+			}
+			if needs := estimateOutputNeeds(EncodeConfig{N: n, K: k, ChunkSize: size}, 1000, pad.PiecesPerCollection(k, n)); needs.chunks != (1000+minPieceBytes-1)/minPieceBytes {
+				t.Fatalf("%d-of-%d at the minimum chunk size takes %d chunks", k, n, needs.chunks)
+			}
+		}
+	}
+}
+
+func TestSuggestChunkSize(t *testing.T) {
+	cfg := EncodeConfig{N: 3, K: 2, InputSize: 1 << 30}
+	if got := suggestChunkSize(cfg, 2, 512); got != 4<<20 {
+		t.Errorf("Expected 4 MB chunks for 1 GB in 512 chunks, got %d", got)
+	}
+	if got := suggestChunkSize(cfg, 2, 1000); got != 3<<20 {
+		t.Errorf("Expected chunks rounded up to 3 MB, got %d", got)
+	}
+	cfg.PadChunks = 10
+	if got := suggestChunkSize(cfg, 2, 10); got != 0 {
+		t.Errorf("Expected no suggestion when only padding chunks fit, got %d", got)
+	}
+}
+
+func TestCheckOutputLimits(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-limits-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte("small enough to fit anywhere\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// The input claims to be far larger than any filesystem has room for
+	limits := file.GetFilesystemLimits(tempDir)
+	tests := []struct {
+		name    string
+		known   bool
+		cfg     EncodeConfig
+		wantErr string
+	}{
+		{"Too many files", limits.FreeFiles >= 0, EncodeConfig{ChunkSize: 1024, Compression: CompressionGzip}, "-chunk"},
+		{"Too little space", limits.FreeBytes >= 0, EncodeConfig{ChunkSize: 1 << 30, Compression: CompressionNone, ZipCollections: true}, "free"},
+		{"Compression may fit", true, EncodeConfig{ChunkSize: 1 << 30, Compression: CompressionGzip, ZipCollections: true}, ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.known {
+				t.Skip("The filesystem does not report this limit")
+			}
+			cfg := tt.cfg
+			cfg.InputDir = inputDir
+			cfg.OutputDir = filepath.Join(tempDir, "output", string(rune('a'+i)))
+			cfg.N, cfg.K = 3, 2
+			cfg.Format = FormatBin
+			cfg.RNG = pad.NewDefaultRand(ctx)
+			cfg.InputSize = 1 << 50
+
+			err := EncodeDirectory(ctx, cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Expected the encode to proceed, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrOutputFull) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected ErrOutputFull mentioning %q, got %v", tt.wantErr, err)
+			}
+			if entries, _ := os.ReadDir(cfg.OutputDir); len(entries) != 0 {
+				t.Errorf("Expected nothing to be written before failing, found %d entries", len(entries))
+			}
+		})
+	}
+}
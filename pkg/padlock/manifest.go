@@ -0,0 +1,224 @@
+package padlock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// SetManifestVersion is the current version of the set manifest format
+const SetManifestVersion = 1
+
+// ManifestConfig holds configuration parameters for exporting the manifest of
+// a collection set. This structure is created by the command-line interface
+// and passed to ExportManifest.
+type ManifestConfig struct {
+	InputDir string    // Path to the directory containing the collections of the set
+	Verbose  bool      // Enable verbose logging
+	Output   io.Writer // Where the JSON manifest is written
+}
+
+// SetManifest is a machine-readable inventory of a collection set, for
+// inventory and monitoring systems tracking its shares. Like the manifests in
+// the collections, it holds no key material: only the layout of the set and
+// the hashes of its chunks, which reveal nothing about the data.
+type SetManifest struct {
+	Version     int                  `json:"version"`              // Set manifest format version
+	Exported    time.Time            `json:"exported"`             // When the manifest was exported
+	Group       string               `json:"group,omitempty"`      // The group of a hierarchical set these collections belong to
+	Session     string               `json:"session,omitempty"`    // Session ID of the encode, in hex, absent for legacy chunk headers
+	Required    int                  `json:"required,omitempty"`   // Collections required for reconstruction (K)
+	Copies      int                  `json:"copies,omitempty"`     // Collections in the set (N)
+	Chunks      int                  `json:"chunks,omitempty"`     // Chunks in each collection
+	DataBytes   int64                `json:"dataBytes,omitempty"`  // Bytes of the encoded archive
+	Metadata    map[string]string    `json:"metadata,omitempty"`   // Key/value pairs recorded in the collections
+	Policy      *file.Policy         `json:"policy,omitempty"`     // Expiry and notes on use recorded in the collections
+	Custodians  map[string][]string  `json:"custodians,omitempty"` // Collections held by each custodian, for custodian bundles
+	Collections []CollectionManifest `json:"collections,omitempty"`
+	Groups      []SetManifest        `json:"groups,omitempty"` // The sets of each group of a hierarchical set, every one of which is required
+}
+
+// CollectionManifest is the entry of one collection found in a set manifest
+type CollectionManifest struct {
+	Name      string          `json:"name"`                // Collection name (e.g., "3A5")
+	Path      string          `json:"path"`                // Where the collection was found
+	Format    Format          `json:"format"`              // Format of its chunks
+	Label     string          `json:"label,omitempty"`     // Label recorded for it, written on the share
+	Custodian string          `json:"custodian,omitempty"` // Custodian whose bundle holds it
+	Bytes     int64           `json:"bytes"`               // Total size of its chunks
+	SHA256    string          `json:"sha256"`              // SHA-256 of its chunks, concatenated in order
+	Chunks    []ChunkManifest `json:"chunks"`
+}
+
+// ChunkManifest is the entry of one chunk of a collection in a set manifest.
+// The hash is of the chunk as encoded, independent of the format it is
+// stored in, so it survives converting the collection.
+type ChunkManifest struct {
+	Number    int    `json:"number"`           // 1-based chunk number
+	Bytes     int    `json:"bytes"`            // Size of the chunk, header included
+	DataBytes int    `json:"dataBytes"`        // Bytes of the archive it encodes
+	SHA256    string `json:"sha256,omitempty"` // SHA-256 of the chunk
+	Error     string `json:"error,omitempty"`  // Why the chunk could not be read, if it is damaged
+}
+
+// ExportManifest writes the manifest of the collection set in cfg.InputDir as
+// JSON to cfg.Output
+func ExportManifest(ctx context.Context, cfg ManifestConfig) error {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	m, err := BuildManifest(ctx, cfg.InputDir)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(cfg.Output)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		log.Error(fmt.Errorf("failed to write manifest: %w", err))
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// BuildManifest reads every chunk of the collections of the set in inputDir,
+// or of each group of a hierarchical set, and returns the manifest of the set.
+// Damaged chunks are recorded with the reason, but collections of more than
+// one encode are an error, as they are not one set.
+func BuildManifest(ctx context.Context, inputDir string) (*SetManifest, error) {
+	groupDirs, err := file.FindGroups(ctx, inputDir)
+	if err != nil || len(groupDirs) == 0 {
+		m, err := buildSetManifest(ctx, inputDir)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	m := &SetManifest{Version: SetManifestVersion, Exported: time.Now().UTC()}
+	for _, dir := range groupDirs {
+		g, err := buildSetManifest(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		g.Version, g.Exported = 0, time.Time{}
+		g.Group = filepath.Base(dir)
+		if gm, err := file.ReadGroupManifest(ctx, dir); err == nil && gm != nil {
+			g.Group = gm.Group
+		}
+		m.Groups = append(m.Groups, *g)
+	}
+	return m, nil
+}
+
+// buildSetManifest returns the manifest of the collections in one directory
+func buildSetManifest(ctx context.Context, dir string) (*SetManifest, error) {
+	log := trace.FromContext(ctx).WithPrefix("MANIFEST")
+
+	collections, tempDir, err := file.FindCollections(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer file.CloseCollections(collections)
+	defer file.RemoveTemp(ctx, tempDir)
+	if len(collections) == 0 {
+		log.Error(fmt.Errorf("%w in %s", ErrNoCollections, dir))
+		return nil, fmt.Errorf("%w in %s", ErrNoCollections, dir)
+	}
+	sort.Slice(collections, func(i, j int) bool { return collections[i].Name < collections[j].Name })
+
+	m := &SetManifest{
+		Version:  SetManifestVersion,
+		Exported: time.Now().UTC(),
+		Metadata: readMetadata(ctx, collections),
+		Policy:   readPolicy(ctx, collections),
+	}
+	labels := readLabels(ctx, collections)
+	var session *uint64
+	for i, coll := range collections {
+		k, n, _, err := pad.ParseCollectionName(coll.Name)
+		if err != nil {
+			log.Error(fmt.Errorf("invalid collection name %s: %w", coll.Name, err))
+			return nil, fmt.Errorf("invalid collection name %s: %w", coll.Name, err)
+		}
+		if i == 0 {
+			m.Required, m.Copies = k, n
+		} else if k != m.Required || n != m.Copies {
+			log.Error(fmt.Errorf("%w: %s holds collections of %d-of-%d and %d-of-%d sets", ErrSessionMismatch, dir, m.Required, m.Copies, k, n))
+			return nil, fmt.Errorf("%w: %s holds collections of %d-of-%d and %d-of-%d sets", ErrSessionMismatch, dir, m.Required, m.Copies, k, n)
+		}
+
+		c, dataBytes, s := collectionManifest(ctx, coll)
+		c.Label = labels[coll.Name]
+		if custodians, err := file.CollectionCustodians(ctx, coll); err != nil {
+			log.Debugf("Ignoring the custodians of collection %s: %v", coll.Name, err)
+		} else if custodians != nil {
+			c.Custodian = custodians.Custodian
+			m.Custodians = custodians.Custodians
+		}
+		if s != nil {
+			if session != nil && *s != *session {
+				log.Error(fmt.Errorf("%w: %s holds collections of more than one encode; padlock diagnose tells them apart", ErrSessionMismatch, dir))
+				return nil, fmt.Errorf("%w: %s holds collections of more than one encode; padlock diagnose tells them apart", ErrSessionMismatch, dir)
+			}
+			session = s
+		}
+		if len(c.Chunks) > m.Chunks {
+			m.Chunks, m.DataBytes = len(c.Chunks), dataBytes
+		}
+		m.Collections = append(m.Collections, c)
+	}
+	if session != nil && *session != 0 {
+		m.Session = fmt.Sprintf("%016x", *session)
+	}
+	return m, nil
+}
+
+// collectionManifest reads and hashes every chunk of a collection, returning
+// its entry, the archive bytes its chunks encode and the session ID of their
+// headers, if any chunk could be read
+func collectionManifest(ctx context.Context, coll file.Collection) (CollectionManifest, int64, *uint64) {
+	c := CollectionManifest{Name: coll.Name, Path: coll.Path, Format: coll.Format, Chunks: []ChunkManifest{}}
+	numbers, err := file.ChunkNumbers(coll)
+	if err != nil {
+		c.Chunks = append(c.Chunks, ChunkManifest{Error: err.Error()})
+		return c, 0, nil
+	}
+
+	h := sha256.New()
+	var dataBytes int64
+	var session *uint64
+	for _, number := range numbers {
+		entry := ChunkManifest{Number: number}
+		data, err := file.ReadChunk(ctx, coll, number)
+		if err != nil {
+			entry.Error = err.Error()
+			c.Chunks = append(c.Chunks, entry)
+			continue
+		}
+		sum := sha256.Sum256(data)
+		h.Write(data)
+		entry.Bytes, entry.SHA256 = len(data), hex.EncodeToString(sum[:])
+		c.Bytes += int64(len(data))
+		if info, err := pad.InspectChunk(data); err != nil {
+			entry.Error = fmt.Sprintf("invalid header: %v", err)
+		} else {
+			entry.DataBytes = info.DataBytes
+			dataBytes += int64(info.DataBytes)
+			if session == nil {
+				session = &info.Session
+			}
+		}
+		c.Chunks = append(c.Chunks, entry)
+	}
+	c.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return c, dataBytes, session
+}
@@ -0,0 +1,122 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestExportManifest(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "seed.txt"), []byte(strings.Repeat("wallet seed ", 500)), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	custodians, err := ParseCustodians("ceo:2,cfo,cto")
+	if err != nil {
+		t.Fatalf("ParseCustodians failed: %v", err)
+	}
+	groups, err := ParseGroupPolicies("board:2of3,engineers:2of2")
+	if err != nil {
+		t.Fatalf("ParseGroupPolicies failed: %v", err)
+	}
+	encode := func(t *testing.T, outputDir string, modify func(cfg *EncodeConfig)) {
+		cfg := EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   outputDir,
+			N:           4,
+			K:           2,
+			Format:      FormatPNG,
+			ChunkSize:   1024,
+			RNG:         pad.NewDefaultRand(ctx),
+			Compression: CompressionNone,
+		}
+		modify(&cfg)
+		if err := EncodeDirectory(ctx, cfg); err != nil {
+			t.Fatalf("EncodeDirectory failed: %v", err)
+		}
+	}
+
+	t.Run("Custodians", func(t *testing.T) {
+		outputDir := filepath.Join(tempDir, "custodians")
+		encode(t, outputDir, func(cfg *EncodeConfig) {
+			cfg.Custodians = custodians
+			cfg.Metadata = map[string]string{"owner": "treasury"}
+			cfg.ZipCollections = true
+		})
+		var out bytes.Buffer
+		if err := ExportManifest(ctx, ManifestConfig{InputDir: outputDir, Output: &out}); err != nil {
+			t.Fatalf("ExportManifest failed: %v", err)
+		}
+		var m SetManifest
+		if err := json.Unmarshal(out.Bytes(), &m); err != nil {
+			t.Fatalf("The manifest is not valid JSON: %v", err)
+		}
+		if m.Version != SetManifestVersion || m.Required != 2 || m.Copies != 4 || len(m.Session) != 16 || m.Chunks == 0 || m.DataBytes == 0 {
+			t.Errorf("Unexpected set: %s", out.String())
+		}
+		if m.Metadata["owner"] != "treasury" || strings.Join(m.Custodians["ceo"], ",") != "2A4,2B4" {
+			t.Errorf("Expected the metadata and custodians of the set: %s", out.String())
+		}
+		if len(m.Collections) != 4 {
+			t.Fatalf("Expected 4 collections, got %d", len(m.Collections))
+		}
+		for i, c := range m.Collections {
+			want := map[int]string{0: "ceo", 1: "ceo", 2: "cfo", 3: "cto"}[i]
+			if c.Custodian != want || c.Format != FormatPNG || len(c.Chunks) != m.Chunks || c.SHA256 == "" {
+				t.Errorf("Unexpected collection %s: %+v", c.Name, c)
+			}
+			for _, chunk := range c.Chunks {
+				if chunk.Error != "" || len(chunk.SHA256) != 64 || chunk.Bytes <= chunk.DataBytes {
+					t.Errorf("Unexpected chunk %d of %s: %+v", chunk.Number, c.Name, chunk)
+				}
+			}
+		}
+	})
+
+	t.Run("Groups", func(t *testing.T) {
+		outputDir := filepath.Join(tempDir, "groups")
+		encode(t, outputDir, func(cfg *EncodeConfig) { cfg.Groups = groups })
+		m, err := BuildManifest(ctx, outputDir)
+		if err != nil {
+			t.Fatalf("BuildManifest failed: %v", err)
+		}
+		if len(m.Groups) != 2 || len(m.Collections) != 0 {
+			t.Fatalf("Expected a manifest of 2 groups, got %+v", m)
+		}
+		for _, g := range m.Groups {
+			if (g.Group != "board" || g.Copies != 3) && (g.Group != "engineers" || g.Copies != 2) || len(g.Collections) != g.Copies {
+				t.Errorf("Unexpected group: %+v", g)
+			}
+		}
+	})
+
+	t.Run("Mixed", func(t *testing.T) {
+		first, second := filepath.Join(tempDir, "first"), filepath.Join(tempDir, "second")
+		encode(t, first, func(cfg *EncodeConfig) {})
+		encode(t, second, func(cfg *EncodeConfig) {})
+
+		// Replace the first set's collection D with that of another encode
+		if err := os.RemoveAll(filepath.Join(first, "2D4")); err != nil {
+			t.Fatalf("Failed to remove collection: %v", err)
+		}
+		if err := os.Rename(filepath.Join(second, "2D4"), filepath.Join(first, "2D4")); err != nil {
+			t.Fatalf("Failed to move collection: %v", err)
+		}
+		if _, err := BuildManifest(ctx, first); !errors.Is(err, ErrSessionMismatch) {
+			t.Errorf("Expected ErrSessionMismatch, got %v", err)
+		}
+	})
+}
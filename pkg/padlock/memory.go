@@ -0,0 +1,117 @@
+package padlock
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// MemoryFile is a file held in memory: a collection zip to decode, or a file
+// restored from the collections
+type MemoryFile struct {
+	Name    string      // Name of a zip (e.g. "3A5.zip"), or path of a restored file within the archive
+	Data    []byte      // Contents of the file
+	Mode    fs.FileMode // Permissions of a restored file
+	ModTime time.Time   // Modification time of a restored file
+}
+
+// MemoryDecodeConfig holds configuration parameters for decoding collections
+// held in memory. It is created by the WebAssembly recovery page, or by any
+// application without a filesystem to decode to, and passed to DecodeMemory.
+type MemoryDecodeConfig struct {
+	Zips        []MemoryFile // Collection zips, or custodian bundles, of which at least K collections are needed
+	Compression Compression  // Compression assumed for data encoded before its compression was recorded in the stream
+	Strict      bool         // Fail rather than fall back when collections or the decoded stream are not exactly as expected
+	Password    string       // Password of encrypted zips, if any
+}
+
+// DecodeMemory reconstructs the files protected by collection zips held in
+// memory, returning the restored files without touching the filesystem, so
+// that data can be recovered where there is none, such as in a browser.
+// Directories, symlinks and other special entries of the archive are skipped,
+// as there is nowhere to restore them to.
+func DecodeMemory(ctx context.Context, cfg MemoryDecodeConfig) ([]MemoryFile, error) {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	start := time.Now()
+	log.Infof("Starting decode of %d zips in memory", len(cfg.Zips))
+
+	if cfg.Password != "" {
+		ctx = file.WithZipPasswords(ctx, func(name string) (string, error) {
+			return cfg.Password, nil
+		})
+	}
+
+	var collections []file.Collection
+	defer func() { file.CloseCollections(collections) }()
+	for _, z := range cfg.Zips {
+		found, err := file.OpenZipReader(ctx, z.Name, bytes.NewReader(z.Data), int64(len(z.Data)))
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, found...)
+	}
+	if len(collections) == 0 {
+		log.Error(fmt.Errorf("%w in the zips", ErrNoCollections))
+		return nil, fmt.Errorf("%w in the zips", ErrNoCollections)
+	}
+
+	// Of entries of the same name, the last is kept, as restoring the archive would
+	var files []MemoryFile
+	index := make(map[string]int)
+	err := decodeFound(ctx, collections, cfg.Compression, cfg.Strict, func(ctx context.Context, r io.Reader) error {
+		return file.ReadArchiveFiles(ctx, r, func(header *tar.Header, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return fmt.Errorf("failed to read %s from the archive: %w", header.Name, err)
+			}
+			if header.Typeflag == tar.TypeLink {
+				i, ok := index[header.Linkname]
+				if !ok {
+					log.Infof("Warning: skipping %s, a hard link to %s, which is not in the archive", header.Name, header.Linkname)
+					return nil
+				}
+				data = files[i].Data
+			}
+			f := MemoryFile{Name: header.Name, Data: data, Mode: header.FileInfo().Mode().Perm(), ModTime: header.ModTime}
+			if i, ok := index[f.Name]; ok {
+				files[i] = f
+				return nil
+			}
+			index[f.Name] = len(files)
+			files = append(files, f)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Decode complete (%s): %d files", time.Since(start), len(files))
+	return files, nil
+}
+
+// ZipFiles writes files held in memory, such as those restored by
+// DecodeMemory, to w as a zip, so that they can be saved together
+func ZipFiles(w io.Writer, files []MemoryFile) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		header := &zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: f.ModTime}
+		header.SetMode(f.Mode)
+		entry, err := zw.CreateHeader(header)
+		if err == nil {
+			_, err = entry.Write(f.Data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add %s to zip: %w", f.Name, err)
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,157 @@
+package padlock
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestDecodeMemory(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-memory-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(filepath.Join(inputDir, "docs"), 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testFiles := map[string]string{
+		"notes.txt":     "recovered in a browser\n",
+		"docs/plan.txt": "the plan\n",
+	}
+	for name, content := range testFiles {
+		if err := os.WriteFile(filepath.Join(inputDir, filepath.FromSlash(name)), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// encode encodes the input into zips, returning them as uploaded
+	encode := func(ctx context.Context, outputDir string) []MemoryFile {
+		cfg := EncodeConfig{
+			InputDir:       inputDir,
+			OutputDir:      outputDir,
+			N:              3,
+			K:              2,
+			Format:         FormatBin,
+			ChunkSize:      1 << 20,
+			RNG:            pad.NewDefaultRand(ctx),
+			Compression:    CompressionGzip,
+			ZipCollections: true,
+		}
+		if err := EncodeDirectory(ctx, cfg); err != nil {
+			t.Fatalf("Failed to encode directory: %v", err)
+		}
+		var zips []MemoryFile
+		for _, name := range []string{"2A3.zip", "2C3.zip"} {
+			data, err := os.ReadFile(filepath.Join(outputDir, name))
+			if err != nil {
+				t.Fatalf("Failed to read zip: %v", err)
+			}
+			zips = append(zips, MemoryFile{Name: name, Data: data})
+		}
+		return zips
+	}
+
+	// check verifies that the restored files are those of the input
+	check := func(t *testing.T, files []MemoryFile) {
+		if len(files) != len(testFiles) {
+			t.Fatalf("Expected %d files, got %d", len(testFiles), len(files))
+		}
+		for _, f := range files {
+			if string(f.Data) != testFiles[f.Name] {
+				t.Errorf("File %s: expected %q, got %q", f.Name, testFiles[f.Name], f.Data)
+			}
+			if f.Mode != 0600 {
+				t.Errorf("File %s: expected mode 0600, got %o", f.Name, f.Mode)
+			}
+		}
+	}
+
+	zips := encode(ctx, filepath.Join(tempDir, "plain"))
+
+	t.Run("Renamed zips", func(t *testing.T) {
+		renamed := append([]MemoryFile(nil), zips...)
+		renamed[0].Name = "2A3 (1).zip"
+		files, err := DecodeMemory(ctx, MemoryDecodeConfig{Zips: renamed, Compression: CompressionGzip})
+		if err != nil {
+			t.Fatalf("DecodeMemory failed: %v", err)
+		}
+		check(t, files)
+	})
+
+	t.Run("Too few zips", func(t *testing.T) {
+		_, err := DecodeMemory(ctx, MemoryDecodeConfig{Zips: zips[:1], Compression: CompressionGzip})
+		if !errors.Is(err, ErrInsufficientCollections) {
+			t.Errorf("Expected ErrInsufficientCollections, got %v", err)
+		}
+	})
+
+	t.Run("Not collections", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := ZipFiles(&buf, []MemoryFile{{Name: "holiday.jpg", Data: []byte("photo"), Mode: 0644}}); err != nil {
+			t.Fatalf("ZipFiles failed: %v", err)
+		}
+		_, err := DecodeMemory(ctx, MemoryDecodeConfig{Zips: []MemoryFile{{Name: "photos.zip", Data: buf.Bytes()}}, Compression: CompressionGzip})
+		if !errors.Is(err, ErrNoCollections) {
+			t.Errorf("Expected ErrNoCollections, got %v", err)
+		}
+	})
+
+	t.Run("Encrypted zips", func(t *testing.T) {
+		password := func(name string) (string, error) { return "correct horse", nil }
+		encrypted := encode(file.WithZipEncryption(ctx, password), filepath.Join(tempDir, "encrypted"))
+		files, err := DecodeMemory(ctx, MemoryDecodeConfig{Zips: encrypted, Compression: CompressionGzip, Password: "correct horse"})
+		if err != nil {
+			t.Fatalf("DecodeMemory failed: %v", err)
+		}
+		check(t, files)
+
+		if _, err := DecodeMemory(ctx, MemoryDecodeConfig{Zips: encrypted, Compression: CompressionGzip, Password: "wrong"}); err == nil {
+			t.Errorf("Expected decoding with the wrong password to fail")
+		}
+	})
+
+	t.Run("Zip of the restored files", func(t *testing.T) {
+		files, err := DecodeMemory(ctx, MemoryDecodeConfig{Zips: zips, Compression: CompressionGzip})
+		if err != nil {
+			t.Fatalf("DecodeMemory failed: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := ZipFiles(&buf, files); err != nil {
+			t.Fatalf("ZipFiles failed: %v", err)
+		}
+		r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("Failed to read zip: %v", err)
+		}
+		var zipped []MemoryFile
+		for _, f := range r.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("Failed to open %s: %v", f.Name, err)
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", f.Name, err)
+			}
+			zipped = append(zipped, MemoryFile{Name: f.Name, Data: data, Mode: f.Mode()})
+		}
+		check(t, zipped)
+	})
+}
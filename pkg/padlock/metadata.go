@@ -0,0 +1,141 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// maxMetadataValue is the longest metadata value, in bytes
+const maxMetadataValue = 256
+
+// metadataKeyPattern matches one metadata key, e.g. "owner" or "expires-on"
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// ParseMetadata parses a comma-separated list of key=value pairs describing a
+// set, e.g. "owner=alice,purpose=tax records,expires=2030-01-01". Metadata is
+// recorded in the manifest of each collection in the clear, next to its
+// chunks, so it must never hold anything secret.
+func ParseMetadata(spec string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(item, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid metadata %q: expected key=value", ErrInvalidConfig, strings.TrimSpace(item))
+		}
+		if _, dup := metadata[key]; dup {
+			return nil, fmt.Errorf("%w: metadata %q is given more than once", ErrInvalidConfig, key)
+		}
+		metadata[key] = value
+	}
+	return metadata, validateMetadata(metadata)
+}
+
+// validateMetadata checks that each key of the metadata of a set is a simple
+// name and each value a short line of printable text
+func validateMetadata(metadata map[string]string) error {
+	for key, value := range metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: invalid metadata key %q: expected up to 64 letters, digits, '_', '.' or '-'", ErrInvalidConfig, key)
+		}
+		if value == "" || len(value) > maxMetadataValue || strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return fmt.Errorf("%w: invalid value for metadata %s: expected up to %d bytes of printable text", ErrInvalidConfig, key, maxMetadataValue)
+		}
+	}
+	return nil
+}
+
+// formatMetadata formats metadata as its key=value pairs in order of key
+func formatMetadata(metadata map[string]string) string {
+	keys := sortedKeys(metadata)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + metadata[key]
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// readMetadata returns the metadata recorded in the first of the collections
+// that holds it, or nil if none does
+func readMetadata(ctx context.Context, collections []file.Collection) map[string]string {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	for _, coll := range collections {
+		metadata, err := file.ReadMetadata(ctx, coll)
+		if err != nil {
+			log.Debugf("Ignoring the metadata of collection %s: %v", coll.Name, err)
+			continue
+		}
+		if metadata != nil {
+			return metadata
+		}
+	}
+	return nil
+}
+
+// requiredMetadataKey is the context key of the metadata the collections of a
+// decode must record
+type requiredMetadataKey struct{}
+
+// withRequiredMetadata returns a context under which the collections decoded
+// must each record the given metadata
+func withRequiredMetadata(ctx context.Context, required map[string]string) context.Context {
+	return context.WithValue(ctx, requiredMetadataKey{}, required)
+}
+
+// checkMetadata logs the metadata recorded in the collections being decoded,
+// and checks that each of them records the metadata required of the decode,
+// if any, so that collections of the wrong set are refused before anything
+// is restored from them
+func checkMetadata(ctx context.Context, collections []file.Collection) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	if metadata := readMetadata(ctx, collections); metadata != nil {
+		log.Infof("Metadata: %s", formatMetadata(metadata))
+	}
+	required, _ := ctx.Value(requiredMetadataKey{}).(map[string]string)
+	if len(required) == 0 {
+		return nil
+	}
+
+	var problems []string
+	for _, coll := range collections {
+		metadata, err := file.ReadMetadata(ctx, coll)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("collection %s: %v", coll.Name, err))
+			continue
+		}
+		for _, key := range sortedKeys(required) {
+			value, ok := metadata[key]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("collection %s records no %s", coll.Name, key))
+			case value != required[key]:
+				problems = append(problems, fmt.Sprintf("collection %s records %s=%s, not %s", coll.Name, key, value, required[key]))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		log.Error(fmt.Errorf("%w: %s", ErrMetadataMismatch, strings.Join(problems, "; ")))
+		return fmt.Errorf("%w: %s", ErrMetadataMismatch, strings.Join(problems, "; "))
+	}
+	log.Infof("All %d collections record the required metadata", len(collections))
+	return nil
+}
+
+// sortedKeys returns the keys of metadata in order
+func sortedKeys(metadata map[string]string) []string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
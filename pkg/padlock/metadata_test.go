@@ -0,0 +1,127 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestParseMetadata(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		metadata, err := ParseMetadata("owner=alice, purpose=tax records ,expires=2030-01-01,query=a=b")
+		if err != nil {
+			t.Fatalf("ParseMetadata failed: %v", err)
+		}
+		if got := formatMetadata(metadata); got != "expires=2030-01-01, owner=alice, purpose=tax records, query=a=b" {
+			t.Errorf("Unexpected metadata: %s", got)
+		}
+	})
+
+	for name, spec := range map[string]string{
+		"No value":      "owner",
+		"Empty value":   "owner=",
+		"Empty key":     "=alice",
+		"Invalid key":   "the owner=alice",
+		"Duplicate":     "owner=alice,owner=bob",
+		"Long value":    "owner=" + strings.Repeat("a", maxMetadataValue+1),
+		"Control value": "owner=ali\tce",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseMetadata(spec); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("Expected ErrInvalidConfig for %q, got %v", spec, err)
+			}
+		})
+	}
+}
+
+func TestEncodeMetadata(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "padlock-metadata-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input directory: %v", err)
+	}
+	testContent := strings.Repeat("described content\n", 100)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	metadata := map[string]string{"owner": "alice", "purpose": "estate"}
+
+	for name, zip := range map[string]bool{"Directories": false, "Zip": true} {
+		t.Run(name, func(t *testing.T) {
+			outputDir := filepath.Join(tempDir, strings.ToLower(name))
+			cfg := EncodeConfig{
+				InputDir:       inputDir,
+				OutputDir:      outputDir,
+				N:              3,
+				K:              2,
+				Format:         FormatBin,
+				ChunkSize:      1024,
+				RNG:            pad.NewDefaultRand(ctx),
+				Compression:    CompressionNone,
+				ZipCollections: zip,
+				Metadata:       metadata,
+			}
+			if err := EncodeDirectory(ctx, cfg); err != nil {
+				t.Fatalf("Failed to encode: %v", err)
+			}
+
+			collections, tempPath, err := file.FindCollections(ctx, outputDir)
+			if err != nil || len(collections) != 3 {
+				t.Fatalf("Expected 3 collections, got %d (%v)", len(collections), err)
+			}
+			for _, coll := range collections {
+				got, err := file.ReadMetadata(ctx, coll)
+				if err != nil || formatMetadata(got) != formatMetadata(metadata) {
+					t.Errorf("Expected metadata %v in %s, got %v (%v)", metadata, coll.Name, got, err)
+				}
+			}
+			file.CloseCollections(collections)
+			file.RemoveTemp(ctx, tempPath)
+
+			// Collections recording the required metadata are decoded
+			restoreDir := filepath.Join(tempDir, strings.ToLower(name)+"-restore")
+			decodeCfg := DecodeConfig{InputDir: outputDir, OutputDir: restoreDir, RequireMetadata: map[string]string{"owner": "alice"}}
+			if err := DecodeDirectory(ctx, decodeCfg); err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			data, err := os.ReadFile(filepath.Join(restoreDir, "data.txt"))
+			if err != nil || string(data) != testContent {
+				t.Errorf("Restored data does not match the original (%v)", err)
+			}
+
+			// Collections that don't are refused, before anything is restored
+			for _, required := range []map[string]string{{"owner": "bob"}, {"ticket": "OPS-1234"}} {
+				decodeCfg := DecodeConfig{InputDir: outputDir, OutputDir: restoreDir, ClearIfNotEmpty: true, RequireMetadata: required}
+				if err := DecodeDirectory(ctx, decodeCfg); !errors.Is(err, ErrMetadataMismatch) {
+					t.Errorf("Expected ErrMetadataMismatch requiring %v, got %v", required, err)
+				}
+				if entries, err := os.ReadDir(restoreDir); err != nil || len(entries) != 0 {
+					t.Errorf("Expected nothing restored requiring %v, got %d entries (%v)", required, len(entries), err)
+				}
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		cfg := EncodeConfig{InputDir: inputDir, OutputDir: filepath.Join(tempDir, "invalid"), N: 3, K: 2, Format: FormatBin, ChunkSize: 1024, RNG: pad.NewDefaultRand(ctx), Metadata: map[string]string{"owner": ""}}
+		if err := cfg.Validate(ctx); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig for an empty metadata value, got %v", err)
+		}
+	})
+}
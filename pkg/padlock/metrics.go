@@ -0,0 +1,61 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// LogMetrics logs a line of the encode and decode metrics of the process (see
+// pad.ReadMetrics) at every interval, with the throughput since the line
+// before, so that the progress of long-running commands and services can be
+// followed in their logs. It runs until the returned function is called.
+func LogMetrics(ctx context.Context, interval time.Duration) (stop func()) {
+	log := trace.FromContext(ctx).WithPrefix("STATS")
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		prev, prevTime := pad.ReadMetrics(), time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				m := pad.ReadMetrics()
+				log.Infof("%s", metricsLine(m, prev, now.Sub(prevTime)))
+				prev, prevTime = m, now
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// metricsLine describes the metrics, with the throughput since the previous
+// metrics, read elapsed before
+func metricsLine(m, prev pad.Metrics, elapsed time.Duration) string {
+	rate := func(n int64) string {
+		if elapsed <= 0 {
+			return "0 bytes/s"
+		}
+		return formatBytes(int64(float64(n)/elapsed.Seconds())) + "/s"
+	}
+	return fmt.Sprintf("Decoded %s in %d chunks (%s), encoded %s in %d chunks (%s); %d collections being decoded, %s random, %d errors",
+		formatBytes(m.BytesDecoded), m.ChunksDecoded, rate(m.BytesDecoded-prev.BytesDecoded),
+		formatBytes(m.BytesEncoded), m.ChunksEncoded, rate(m.BytesEncoded-prev.BytesEncoded),
+		m.ActiveCollections, formatBytes(m.RNGBytes), m.Errors)
+}
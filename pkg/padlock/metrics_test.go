@@ -0,0 +1,45 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestLogMetrics(t *testing.T) {
+	t.Run("Line", func(t *testing.T) {
+		prev := pad.Metrics{BytesDecoded: 1000000, BytesEncoded: 500}
+		m := pad.Metrics{BytesDecoded: 3000000, ChunksDecoded: 12, BytesEncoded: 500, ActiveCollections: 3, RNGBytes: 2500000, Errors: 1}
+		want := "Decoded 3.0 MB in 12 chunks (1.0 MB/s), encoded 500 bytes in 0 chunks (0 bytes/s); 3 collections being decoded, 2.5 MB random, 1 errors"
+		if got := metricsLine(m, prev, 2*time.Second); got != want {
+			t.Errorf("metricsLine = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Periodic", func(t *testing.T) {
+		var buf bytes.Buffer
+		tracer := trace.NewTracer("TEST", trace.LogLevelNormal)
+		tracer.SetSinks(trace.TextSink(&buf))
+		ctx := trace.WithContext(context.Background(), tracer)
+
+		stop := LogMetrics(ctx, 10*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		stop()
+		stop()
+		lines := strings.Count(buf.String(), "STATS: Decoded ")
+		if lines == 0 {
+			t.Fatalf("Expected periodic metrics lines, got %q", buf.String())
+		}
+
+		// Nothing is logged once stopped
+		time.Sleep(30 * time.Millisecond)
+		if got := strings.Count(buf.String(), "STATS: Decoded "); got != lines {
+			t.Errorf("Expected no lines after stopping, got %d more", got-lines)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package padlock
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// mnemonicWordlist is the English wordlist of BIP-39, whose 2048 words are
+// each identified by their first four letters
+//
+//go:embed wordlists/english.txt
+var mnemonicWordlist string
+
+// mnemonicWords is the wordlist, in order, and mnemonicIndex maps each word,
+// and the first four letters of each, to its index
+var (
+	mnemonicWords = strings.Fields(mnemonicWordlist)
+	mnemonicIndex = func() map[string]int {
+		index := make(map[string]int, 2*len(mnemonicWords))
+		for i, word := range mnemonicWords {
+			index[word] = i
+			if len(word) > 4 {
+				index[word[:4]] = i
+			}
+		}
+		return index
+	}()
+)
+
+// mnemonicWordBits is the number of bits each word carries, and
+// mnemonicChecksumBits the number of bits of checksum after the data
+const (
+	mnemonicWordBits     = 11
+	mnemonicChecksumBits = 16
+)
+
+// encodeMnemonic returns the words that hold data, as BIP-39 does: the bits of
+// the data are followed by the first bits of its SHA-256 hash, and zeros up to
+// a whole word, and each 11 bits pick a word. Unlike BIP-39, data of any length
+// is encoded, with a checksum of a fixed length.
+func encodeMnemonic(data []byte) []string {
+	sum := sha256.Sum256(data)
+	bits := append(append([]byte{}, data...), sum[:mnemonicChecksumBits/8]...)
+	count := (len(bits)*8 + mnemonicWordBits - 1) / mnemonicWordBits
+	words := make([]string, count)
+	for i := range words {
+		index := 0
+		for b := i * mnemonicWordBits; b < (i+1)*mnemonicWordBits; b++ {
+			index <<= 1
+			if b < len(bits)*8 && bits[b/8]&(0x80>>(b%8)) != 0 {
+				index |= 1
+			}
+		}
+		words[i] = mnemonicWords[index]
+	}
+	return words
+}
+
+// decodeMnemonic returns the data held by words written by encodeMnemonic.
+// Words may be abbreviated to their first four letters, in any case. A word
+// not in the wordlist is named, and a mnemonic whose checksum does not match
+// is reported as mistyped.
+func decodeMnemonic(words []string) ([]byte, error) {
+	var bits []byte
+	for i, word := range words {
+		index, ok := mnemonicIndex[strings.ToLower(word)]
+		if !ok {
+			return nil, fmt.Errorf("word %d, %q, is not a mnemonic word", i+1, word)
+		}
+		for b := mnemonicWordBits - 1; b >= 0; b-- {
+			bits = append(bits, byte(index>>b)&1)
+		}
+	}
+
+	// The data is followed by the checksum and fewer than a word of zeros,
+	// which leaves at most two possible lengths of data
+	for length := (len(bits) - mnemonicChecksumBits) / 8; length > 0 && len(bits)-length*8-mnemonicChecksumBits < mnemonicWordBits; length-- {
+		packed := make([]byte, length+mnemonicChecksumBits/8)
+		for b := range packed {
+			for _, bit := range bits[b*8 : b*8+8] {
+				packed[b] = packed[b]<<1 | bit
+			}
+		}
+		if bytes.IndexByte(bits[len(packed)*8:], 1) >= 0 {
+			continue
+		}
+		sum := sha256.Sum256(packed[:length])
+		if bytes.Equal(packed[length:], sum[:mnemonicChecksumBits/8]) {
+			return packed[:length], nil
+		}
+	}
+	return nil, fmt.Errorf("mnemonic of %d words is mistyped or incomplete: its checksum does not match", len(words))
+}
+
+// mnemonicFields returns the words of a mnemonic as written or typed, leaving
+// out the numbers written before them, or nil if the text holds anything else
+func mnemonicFields(text string) []string {
+	var words []string
+	for _, field := range strings.Fields(text) {
+		field = strings.TrimRight(field, ".):")
+		switch {
+		case strings.IndexFunc(field, func(r rune) bool { return !unicode.IsDigit(r) }) < 0:
+			continue
+		case strings.IndexFunc(field, func(r rune) bool { return r > unicode.MaxASCII || !unicode.IsLetter(r) }) >= 0:
+			return nil
+		}
+		words = append(words, field)
+	}
+	return words
+}
@@ -0,0 +1,71 @@
+package padlock
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMnemonic(t *testing.T) {
+	t.Run("Wordlist", func(t *testing.T) {
+		// The SHA-256 of bip-0039/english.txt
+		sum := fmt.Sprintf("%x", sha256.Sum256([]byte(mnemonicWordlist)))
+		if sum != "2f5eed53a4727b4bf8880d8f3f199efc90e58503646d9ff8eff3a2ed3b24dbda" || len(mnemonicWords) != 2048 {
+			t.Fatalf("Wordlist is not the BIP-39 English wordlist: %d words, SHA-256 %s", len(mnemonicWords), sum)
+		}
+	})
+
+	t.Run("Round trip", func(t *testing.T) {
+		for length := 1; length <= 100; length++ {
+			data := make([]byte, length)
+			for i := range data {
+				data[i] = byte(i*37 + length)
+			}
+			words := encodeMnemonic(data)
+			if want := (length*8 + mnemonicChecksumBits + mnemonicWordBits - 1) / mnemonicWordBits; len(words) != want {
+				t.Errorf("%d bytes: expected %d words, got %d", length, want, len(words))
+			}
+			decoded, err := decodeMnemonic(words)
+			if err != nil || !bytes.Equal(decoded, data) {
+				t.Errorf("%d bytes: decoded %x, %v", length, decoded, err)
+			}
+		}
+	})
+
+	t.Run("Typed", func(t *testing.T) {
+		data := []byte("typed back in")
+		words := encodeMnemonic(data)
+		var typed []string
+		for i, word := range words {
+			typed = append(typed, fmt.Sprintf("%d.", i+1), strings.ToUpper(word[:min(4, len(word))]))
+		}
+		decoded, err := decodeMnemonic(mnemonicFields(strings.Join(typed, " ")))
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("Decoding numbered, abbreviated words gave %q, %v", decoded, err)
+		}
+		if mnemonicFields("0a1b 2c3d") != nil {
+			t.Errorf("Expected text that is not words to give no mnemonic")
+		}
+	})
+
+	t.Run("Mistyped", func(t *testing.T) {
+		words := encodeMnemonic([]byte("mistyped"))
+		swapped := append([]string{}, words...)
+		swapped[1], swapped[2] = swapped[2], swapped[1]
+		if swapped[1] == swapped[2] {
+			swapped[1] = mnemonicWords[(mnemonicIndex[swapped[1]]+1)%len(mnemonicWords)]
+		}
+		if _, err := decodeMnemonic(swapped); err == nil || !strings.Contains(err.Error(), "checksum") {
+			t.Errorf("Expected a mistyped mnemonic to fail its checksum, got %v", err)
+		}
+		if _, err := decodeMnemonic(words[:len(words)-1]); err == nil {
+			t.Errorf("Expected an incomplete mnemonic to fail")
+		}
+		words[3] = "padlock"
+		if _, err := decodeMnemonic(words); err == nil || !strings.Contains(err.Error(), `word 4, "padlock"`) {
+			t.Errorf("Expected the unknown word to be named, got %v", err)
+		}
+	})
+}
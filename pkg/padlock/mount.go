@@ -0,0 +1,186 @@
+package padlock
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// MountConfig holds configuration parameters for mounting the reconstructed
+// archive of a set of collections as a read-only filesystem
+type MountConfig struct {
+	InputDir    string      // Path to the directory containing collections to mount
+	Mountpoint  string      // Existing empty directory at which the archive is mounted
+	Verbose     bool        // Enable verbose logging
+	Compression Compression // Compression assumed for data encoded before its compression was recorded in the stream
+}
+
+// errMountReadStopped is the error seen by the decoder when a mounted file has
+// been read and the rest of the archive is not needed
+var errMountReadStopped = errors.New("mount read stopped")
+
+// mountEntry is one entry of a mounted archive
+type mountEntry struct {
+	name   string // Cleaned path within the archive, without a leading or trailing slash
+	index  int    // Position of the entry in the archive, to read its contents
+	header *tar.Header
+}
+
+// mountView is the reconstructed archive of a set of collections, indexed once
+// when it is mounted. The archive is a compressed stream, so the contents of a
+// file are decoded when it is opened, by decoding the collections as far as
+// the file. The most recently read file is kept, as files tend to be opened
+// several times in a row (e.g. to be inspected and then copied).
+type mountView struct {
+	ctx     context.Context
+	entries []*mountEntry
+	decode  func(ctx context.Context, consume func(ctx context.Context, r io.Reader) error) error
+	close   func()
+
+	mu       sync.Mutex
+	lastName string
+	lastData []byte
+}
+
+// openMountView locates the collections in inputDir and indexes the archive
+// they reconstruct
+func openMountView(ctx context.Context, inputDir string, compression Compression) (*mountView, error) {
+	log := trace.FromContext(ctx).WithPrefix("MOUNT")
+
+	if err := file.ValidateInputDirectory(ctx, inputDir); err != nil {
+		return nil, err
+	}
+
+	// Collections are located once, except those of hierarchical sets, which
+	// are located group by group on each decode
+	v := &mountView{ctx: ctx, close: func() {}}
+	if groupDirs, err := file.FindGroups(ctx, inputDir); err == nil && len(groupDirs) > 0 {
+		v.decode = func(ctx context.Context, consume func(ctx context.Context, r io.Reader) error) error {
+			return decodeCollections(ctx, inputDir, compression, false, consume)
+		}
+	} else {
+		collections, tempDir, err := file.FindCollections(ctx, inputDir)
+		if err != nil {
+			return nil, err
+		}
+		v.close = func() {
+			file.CloseCollections(collections)
+			file.RemoveTemp(ctx, tempDir)
+		}
+		if len(collections) == 0 {
+			v.close()
+			log.Error(fmt.Errorf("%w in input directory", ErrNoCollections))
+			return nil, fmt.Errorf("%w in input directory", ErrNoCollections)
+		}
+		v.decode = func(ctx context.Context, consume func(ctx context.Context, r io.Reader) error) error {
+			return decodeFound(ctx, collections, compression, false, consume)
+		}
+	}
+
+	// Index the archive, keeping the last of entries of the same name as
+	// restoring the archive would
+	byName := make(map[string]*mountEntry)
+	err := v.decode(ctx, func(ctx context.Context, r io.Reader) error {
+		tr := tar.NewReader(r)
+		for i := 0; ; i++ {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%w: tar header read error: %w", ErrNotArchive, err)
+			}
+			// The root of the archive is the mountpoint itself, and the hash
+			// manifest is not a file of the archive
+			name, ok := mountEntryName(header.Name)
+			if !ok || file.IsHashManifest(header) {
+				continue
+			}
+			switch header.Typeflag {
+			case tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+			default:
+				log.Debugf("Skipping %s of unsupported type %c", header.Name, header.Typeflag)
+				continue
+			}
+			e := &mountEntry{name: name, index: i, header: header}
+
+			// A hard link reads the contents of the file it links to
+			if header.Typeflag == tar.TypeLink {
+				linkName, _ := mountEntryName(header.Linkname)
+				target := byName[linkName]
+				if target == nil || target.header.Typeflag != tar.TypeReg {
+					log.Debugf("Skipping %s, a hard link to %s, which is not a file of the archive", header.Name, header.Linkname)
+					continue
+				}
+				linked := *header
+				linked.Typeflag, linked.Size = tar.TypeReg, target.header.Size
+				e.index, e.header = target.index, &linked
+			}
+			if prev := byName[name]; prev != nil {
+				*prev = *e
+				continue
+			}
+			byName[name] = e
+			v.entries = append(v.entries, e)
+		}
+		io.Copy(io.Discard, r)
+		return nil
+	})
+	if err != nil {
+		v.close()
+		return nil, err
+	}
+	log.Infof("Indexed %d entries", len(v.entries))
+	return v, nil
+}
+
+// mountEntryName cleans the name of an archive entry, confining it to the
+// archive, and reports whether it names anything other than its root
+func mountEntryName(name string) (string, bool) {
+	name = path.Clean("/" + strings.TrimPrefix(name, "./"))
+	if name == "/" {
+		return "", false
+	}
+	return strings.TrimPrefix(name, "/"), true
+}
+
+// readFile decodes the contents of a regular file of the archive
+func (v *mountView) readFile(ctx context.Context, e *mountEntry) ([]byte, error) {
+	log := trace.FromContext(ctx).WithPrefix("MOUNT")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.lastName == e.name {
+		return v.lastData, nil
+	}
+
+	log.Debugf("Decoding %s", e.name)
+	var data []byte
+	err := v.decode(ctx, func(ctx context.Context, r io.Reader) error {
+		tr := tar.NewReader(r)
+		for i := 0; i <= e.index; i++ {
+			if _, err := tr.Next(); err != nil {
+				return fmt.Errorf("failed to find %s in the archive: %w", e.name, err)
+			}
+		}
+		var err error
+		if data, err = io.ReadAll(tr); err != nil {
+			return fmt.Errorf("failed to read %s from the archive: %w", e.name, err)
+		}
+		return errMountReadStopped
+	})
+	if err != nil && !errors.Is(err, errMountReadStopped) {
+		log.Error(fmt.Errorf("failed to decode %s: %w", e.name, err))
+		return nil, fmt.Errorf("failed to decode %s: %w", e.name, err)
+	}
+	v.lastName, v.lastData = e.name, data
+	return data, nil
+}
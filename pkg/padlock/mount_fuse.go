@@ -0,0 +1,194 @@
+//go:build linux || darwin
+
+package padlock
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// MountCollections mounts the archive reconstructed from the collections in
+// cfg.InputDir as a read-only FUSE filesystem at cfg.Mountpoint, so that its
+// files can be browsed and copied without restoring everything. The archive is
+// indexed when it is mounted, and each file is decoded when it is opened. It
+// serves the filesystem until ctx is done or it is unmounted.
+func MountCollections(ctx context.Context, cfg MountConfig) error {
+	log := trace.FromContext(ctx).WithPrefix("MOUNT")
+	start := time.Now()
+	log.Infof("Starting mount: InputDir=%s Mountpoint=%s", cfg.InputDir, cfg.Mountpoint)
+
+	if info, err := os.Stat(cfg.Mountpoint); err != nil || !info.IsDir() {
+		log.Error(fmt.Errorf("%w: mountpoint %s is not a directory", ErrInvalidConfig, cfg.Mountpoint))
+		return fmt.Errorf("%w: mountpoint %s is not a directory", ErrInvalidConfig, cfg.Mountpoint)
+	}
+
+	view, err := openMountView(ctx, cfg.InputDir, cfg.Compression)
+	if err != nil {
+		return err
+	}
+	defer view.close()
+
+	root := &mountDir{view: view, modTime: start}
+	server, err := fs.Mount(cfg.Mountpoint, root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      "padlock",
+			Name:        "padlock",
+			Options:     []string{"ro"},
+			DirectMount: true,
+		},
+	})
+	if err != nil {
+		log.Error(fmt.Errorf("failed to mount %s: %w", cfg.Mountpoint, err))
+		return fmt.Errorf("failed to mount %s: %w", cfg.Mountpoint, err)
+	}
+	log.Infof("Mounted at %s (%s); interrupt or unmount it to stop", cfg.Mountpoint, time.Since(start))
+
+	go func() {
+		<-ctx.Done()
+		if err := server.Unmount(); err != nil {
+			log.Infof("Warning: failed to unmount %s, which may be in use: %v", cfg.Mountpoint, err)
+		}
+	}()
+	server.Wait()
+	log.Infof("Unmounted %s", cfg.Mountpoint)
+	return nil
+}
+
+// mountDir is a directory of a mounted archive. The root, and directories that
+// the archive holds files in without recording them, have no entry.
+type mountDir struct {
+	fs.Inode
+	view    *mountView
+	entry   *mountEntry
+	modTime time.Time
+}
+
+// OnAdd builds the tree of the archive's entries below the root
+func (d *mountDir) OnAdd(ctx context.Context) {
+	if d.entry != nil || !d.IsRoot() {
+		return
+	}
+	dirs := map[string]*fs.Inode{"": &d.Inode}
+	var parent func(name string) *fs.Inode
+	parent = func(name string) *fs.Inode {
+		dir := path.Dir(name)
+		if dir == "." {
+			dir = ""
+		}
+		if inode, ok := dirs[dir]; ok {
+			return inode
+		}
+		inode := parent(dir).NewPersistentInode(ctx, &mountDir{view: d.view, modTime: d.modTime}, fs.StableAttr{Mode: fuse.S_IFDIR})
+		parent(dir).AddChild(path.Base(dir), inode, true)
+		dirs[dir] = inode
+		return inode
+	}
+
+	for _, e := range d.view.entries {
+		var node fs.InodeEmbedder
+		var mode uint32
+		switch e.header.Typeflag {
+		case tar.TypeDir:
+			if dirs[e.name] != nil {
+				dirs[e.name].Operations().(*mountDir).entry = e
+				continue
+			}
+			node, mode = &mountDir{view: d.view, entry: e, modTime: e.header.ModTime}, fuse.S_IFDIR
+		case tar.TypeSymlink:
+			node, mode = &mountSymlink{entry: e}, fuse.S_IFLNK
+		default:
+			node, mode = &mountFile{view: d.view, entry: e}, fuse.S_IFREG
+		}
+		p := parent(e.name)
+		inode := p.NewPersistentInode(ctx, node, fs.StableAttr{Mode: mode})
+		p.AddChild(path.Base(e.name), inode, true)
+		if mode == fuse.S_IFDIR {
+			dirs[e.name] = inode
+		}
+	}
+}
+
+// Getattr reports the directory's permissions and modification time
+func (d *mountDir) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	perm, modTime := uint32(0755), d.modTime
+	if d.entry != nil {
+		perm, modTime = uint32(d.entry.header.Mode)&07777, d.entry.header.ModTime
+	}
+	setMountAttr(out, fuse.S_IFDIR|perm, 0, modTime)
+	return 0
+}
+
+// mountFile is a regular file of a mounted archive
+type mountFile struct {
+	fs.Inode
+	view  *mountView
+	entry *mountEntry
+}
+
+// Getattr reports the file's permissions, size and modification time
+func (f *mountFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setMountAttr(out, fuse.S_IFREG|uint32(f.entry.header.Mode)&07777, uint64(f.entry.header.Size), f.entry.header.ModTime)
+	return 0
+}
+
+// Open decodes the file's contents, which are held until it is closed
+func (f *mountFile) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	// Decoding is logged under the mount's context rather than the request's
+	data, err := f.view.readFile(f.view.ctx, f.entry)
+	if err != nil {
+		return nil, 0, syscall.EIO
+	}
+	return &mountHandle{data: data}, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+// mountHandle is an open file of a mounted archive
+type mountHandle struct {
+	data []byte
+}
+
+// Read reads from the decoded contents of the file
+func (h *mountHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if off >= int64(len(h.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), int64(len(h.data)))
+	return fuse.ReadResultData(h.data[off:end]), 0
+}
+
+// mountSymlink is a symbolic link of a mounted archive
+type mountSymlink struct {
+	fs.Inode
+	entry *mountEntry
+}
+
+// Readlink returns the target of the link as archived
+func (s *mountSymlink) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	return []byte(s.entry.header.Linkname), 0
+}
+
+// Getattr reports the link's modification time
+func (s *mountSymlink) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setMountAttr(out, fuse.S_IFLNK|0777, uint64(len(s.entry.header.Linkname)), s.entry.header.ModTime)
+	return 0
+}
+
+// setMountAttr fills in the attributes of a node of a mounted archive, which
+// belongs to the user who mounted it
+func setMountAttr(out *fuse.AttrOut, mode uint32, size uint64, modTime time.Time) {
+	out.Mode = mode
+	out.Size = size
+	out.Owner = fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	out.SetTimes(nil, &modTime, &modTime)
+}
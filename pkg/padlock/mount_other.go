@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package padlock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// MountCollections mounts the archive reconstructed from a set of collections
+// as a read-only FUSE filesystem, which is not supported on this platform
+func MountCollections(ctx context.Context, cfg MountConfig) error {
+	log := trace.FromContext(ctx).WithPrefix("MOUNT")
+	log.Error(fmt.Errorf("%w: mounting collections is only supported on Linux and macOS", ErrInvalidConfig))
+	return fmt.Errorf("%w: mounting collections is only supported on Linux and macOS", ErrInvalidConfig)
+}
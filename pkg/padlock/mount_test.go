@@ -0,0 +1,119 @@
+package padlock
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestMountView(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-mount-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(filepath.Join(inputDir, "docs"), 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	contents := map[string]string{
+		"top.txt":       strings.Repeat("top level file\n", 200),
+		"docs/note.txt": strings.Repeat("nested file\n", 300),
+	}
+	for name, content := range contents {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	if err := os.Symlink("docs/note.txt", filepath.Join(inputDir, "link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	encodeOutputDir := filepath.Join(tempDir, "encoded")
+	err = EncodeDirectory(ctx, EncodeConfig{
+		InputDir:    inputDir,
+		OutputDir:   encodeOutputDir,
+		N:           3,
+		K:           2,
+		Format:      FormatBin,
+		ChunkSize:   1024,
+		RNG:         pad.NewDefaultRand(ctx),
+		Compression: CompressionGzip,
+		Serialize:   SerializeOptions{PreserveSymlinks: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	view, err := openMountView(ctx, encodeOutputDir, CompressionGzip)
+	if err != nil {
+		t.Fatalf("openMountView failed: %v", err)
+	}
+	defer view.close()
+
+	entries := make(map[string]*mountEntry)
+	for _, e := range view.entries {
+		entries[e.name] = e
+	}
+
+	t.Run("Entries", func(t *testing.T) {
+		if e := entries["docs"]; e == nil || e.header.Typeflag != tar.TypeDir {
+			t.Errorf("Expected the docs directory, got %+v", e)
+		}
+		if e := entries["link"]; e == nil || e.header.Typeflag != tar.TypeSymlink || e.header.Linkname != "docs/note.txt" {
+			t.Errorf("Expected the link to docs/note.txt, got %+v", e)
+		}
+		for name, content := range contents {
+			e := entries[name]
+			if e == nil || e.header.Typeflag != tar.TypeReg || e.header.Size != int64(len(content)) {
+				t.Errorf("Expected the file %s of %d bytes, got %+v", name, len(content), e)
+			}
+		}
+	})
+
+	t.Run("Read files", func(t *testing.T) {
+		// Read each twice, the second from the last file kept
+		for _, name := range []string{"docs/note.txt", "top.txt", "top.txt", "docs/note.txt"} {
+			data, err := view.readFile(ctx, entries[name])
+			if err != nil || string(data) != contents[name] {
+				t.Errorf("Expected to read %s, got %d bytes (%v)", name, len(data), err)
+			}
+		}
+	})
+
+	t.Run("No collections", func(t *testing.T) {
+		emptyDir := filepath.Join(tempDir, "empty")
+		os.MkdirAll(emptyDir, 0755)
+		if _, err := openMountView(ctx, emptyDir, CompressionGzip); !errors.Is(err, ErrNoCollections) {
+			t.Errorf("Expected ErrNoCollections, got %v", err)
+		}
+	})
+}
+
+func TestMountEntryName(t *testing.T) {
+	tests := map[string]string{
+		"./":             "",
+		"a/b/":           "a/b",
+		"./a/../b.txt":   "b.txt",
+		"../../etc/pass": "etc/pass",
+		"/abs/file":      "abs/file",
+	}
+	for name, want := range tests {
+		got, ok := mountEntryName(name)
+		if got != want || ok != (want != "") {
+			t.Errorf("mountEntryName(%q) = %q, %v; expected %q", name, got, ok, want)
+		}
+	}
+}
@@ -29,34 +29,67 @@
 //   - Sets up a pipeline for decoding and decompression
 //   - Deserializes the decoded stream to output directory
 //
+// 3. ListCollections: Lists the archived entries without restoring them
+//
+// 4. VerifyRestore: Checks a restored directory against the hashes recorded at encode
+//
 // Security considerations:
 // - Security depends entirely on the quality of randomness
 // - Collections should be stored in separate locations
 // - Same collections should never be reused for different data
+//
+// Concurrency:
+// - Operations may run at once, each with its own configuration and directories
+// - An RNG shared between them must be safe for concurrent use, as those of pkg/pad are
 package padlock
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/rayozzie/padlock/pkg/audit"
 	"github.com/rayozzie/padlock/pkg/file"
 	"github.com/rayozzie/padlock/pkg/pad"
 	"github.com/rayozzie/padlock/pkg/trace"
+	"golang.org/x/sync/errgroup"
 )
 
 // Format is a type alias for file.Format, representing the output format for collections.
 // A Format determines how data chunks are written to and read from the filesystem.
 type Format = file.Format
 
+// SerializeOptions is a type alias for file.SerializeOptions, controlling which
+// file system attributes are captured when the input directory is archived.
+type SerializeOptions = file.SerializeOptions
+
+// DeserializeOptions is a type alias for file.DeserializeOptions, controlling which
+// recorded file system attributes are applied when the archive is restored.
+type DeserializeOptions = file.DeserializeOptions
+
+// ConflictPolicy is a type alias for file.ConflictPolicy, determining how decode
+// handles files that already exist in the output directory.
+type ConflictPolicy = file.ConflictPolicy
+
 // Compression represents the compression mode used when serializing directories.
 // This allows for space-efficient storage while maintaining the security properties
 // of the threshold scheme.
 type Compression int
 
+// FormatPacked is the binary format with the chunks of each collection packed
+// into a single file, which is far faster to write and copy than thousands
+// of small files.
+const FormatPacked = file.FormatPacked
+
 const (
 	// FormatBin is a binary format that stores data chunks directly as binary files.
 	// This format is more efficient but less portable across different systems.
@@ -79,28 +112,96 @@ const (
 // EncodeConfig holds configuration parameters for the encoding operation.
 // This structure is created by the command-line interface and passed to EncodeDirectory.
 type EncodeConfig struct {
-	InputDir        string      // Path to the directory containing data to encode
-	OutputDir       string      // Path where the encoded collections will be created
-	N               int         // Total number of collections to create (N value)
-	K               int         // Minimum collections required for reconstruction (K value)
-	Format          Format      // Output format (binary or PNG)
-	ChunkSize       int         // Maximum size for data chunks in bytes
-	RNG             pad.RNG     // Random number generator for one-time pad creation
-	ClearIfNotEmpty bool        // Whether to clear the output directory if not empty
-	Verbose         bool        // Enable verbose logging
-	Compression     Compression // Compression mode for the serialized data
-	ZipCollections  bool        // Whether to create ZIP archives for collections
+	InputDir        string            // Path to the directory containing data to encode
+	Inputs          []string          // If set instead of InputDir, directories and files encoded together, each under a top-level entry of its base name
+	OutputDir       string            // Path where the encoded collections will be created
+	N               int               // Total number of collections to create (N value)
+	K               int               // Minimum collections required for reconstruction (K value)
+	Format          Format            // Output format (binary or PNG)
+	ChunkNaming     file.ChunkNaming  // How chunk files are named, or the zero value for the format's default (e.g. "3A5_0001.bin")
+	ChunkSize       int               // Maximum size for data chunks in bytes
+	RNG             pad.RNG           // Random number generator for one-time pad creation
+	ClearIfNotEmpty bool              // Whether to clear the output directory if not empty
+	Verbose         bool              // Enable verbose logging
+	Compression     Compression       // Compression mode for the serialized data
+	ZipCollections  bool              // Whether to create ZIP archives for collections
+	TarCollections  bool              // Whether to create gzipped tar archives for collections, instead of ZIP archives
+	ObfuscateNames  bool              // Give collections and their files innocuous names that don't reveal K, N or the scheme
+	StoreDir        string            // If set, keep the files of the collections by content hash in this shared chunk store, with a manifest per collection in OutputDir
+	Serialize       SerializeOptions  // File attributes to preserve when archiving the input
+	VolumeSize      int64             // If nonzero, split each collection into volumes of at most this many bytes
+	Targets         []string          // If set, one directory per collection (e.g. removable media) to write and verify it on
+	Destinations    map[string]string // If set, the directory some or all collections are written to in place of OutputDir, by letter (see ParseDestinations)
+	Groups          []GroupPolicy     // If set, encode a hierarchical set requiring every group to reach its own threshold; N and K are unused
+	Custodians      []Custodian       // If set, bundle each custodian's collections into one artifact; N is the sum of their weights
+	Instructions    string            // Recovery instructions recorded in each collection along with the custodians
+	ParityPercent   int               // If nonzero, add Reed-Solomon parity of this percentage to each collection so damaged chunks can be rebuilt
+	Audit           *audit.Log        // If set, the encode, its parameters and the hashes of its files are recorded here
+	PadLength       bool              // Pad every chunk to the full chunk size so that collection sizes don't reveal the input length
+	PadChunks       int               // With PadLength, add a random number, up to this many, of empty chunks to hide the chunk count
+	DecoyDir        string            // If set, a directory encoded into the same set, revealed by any K collections including a decoy collection
+	DecoyLetters    string            // With DecoyDir, the letters of the decoy collections (e.g. "C"); the others reveal InputDir
+	Progress        func(n int64)     // If set, called as the input is archived with the number of bytes archived so far
+	Decoders        []file.Decoder    // If set, padlock executables embedded with instructions in each collection, so it can be decoded without finding padlock
+	InputSize       int64             // Estimated size of the input archive, to check that the output has room for the collections; measured from InputDir if zero
+	Snapshot        string            // If set, append the input as a snapshot of this name to the set already in OutputDir, decodable on its own
+	Hybrid          HybridMode        // If set, encrypt the input once with a random key and split only the key, trading the one-time pad's security for size
+	Serializer      file.Serializer   // If set, serializes InputDir (and DecoyDir) in place of tar; it must be given to the decode too
+	LowMemory       bool              // Limit the chunk size to LowMemoryChunkSize, for devices with little memory (see ConfigureLowMemory)
+	PadsDir         string            // If set, encode with the pads generated here by GeneratePads rather than drawing random numbers; the chunk size is theirs
+	Labels          []string          // If set, a label for each collection in order (see ParseLabels), recorded in every collection for matching hand-labeled shares
+	Metadata        map[string]string // If set, key/value pairs describing the set (see ParseMetadata), recorded in the clear in the manifest of every collection
+	NotAfter        time.Time         // If set, when the collections expire and are to be rotated; decode warns after it, or refuses with EnforcePolicy
+	PolicyNotes     string            // If set, notes on the use of the collections, such as their rotation schedule, reported by decode and info
+	EntropyReport   string            // If set, where to write a report of the random sources of the encode, their health and what each contributed
+	Randomness      RandomnessPolicy  // What to do about pads or chunks that fail the randomness checks: off, warn (the default) or fail
+	Verify          bool              // After writing, decode K of the collections and check that they reconstruct the input exactly
+	ShredInput      bool              // Once the collections are verified to restore it, overwrite and remove each file archived from InputDir
 }
 
 // DecodeConfig holds configuration parameters for the decoding operation.
 // This structure is created by the command-line interface and passed to DecodeDirectory.
 type DecodeConfig struct {
-	InputDir        string      // Path to the directory containing collections to decode
-	OutputDir       string      // Path where the decoded data will be written
-	RNG             pad.RNG     // Random number generator (unused for decoding, but maintained for consistency)
-	Verbose         bool        // Enable verbose logging
-	Compression     Compression // Compression mode used when the data was encoded
-	ClearIfNotEmpty bool        // Whether to clear the output directory if not empty
+	InputDir        string             // Path to the directory containing collections to decode
+	OutputDir       string             // Path where the decoded data will be written
+	RNG             pad.RNG            // Random number generator (unused for decoding, but maintained for consistency)
+	Verbose         bool               // Enable verbose logging
+	Compression     Compression        // Compression assumed for data encoded before its compression was recorded in the stream
+	ClearIfNotEmpty bool               // Whether to clear the output directory if not empty
+	Deserialize     DeserializeOptions // File attributes to restore and files to select from the archive
+	OutputWriter    io.Writer          // If set, selected file contents are streamed here instead of restored to OutputDir
+	Strict          bool               // Fail rather than fall back when collections or the decoded stream are not exactly as expected
+	Audit           *audit.Log         // If set, the decode, its parameters and the hashes of its files are recorded here
+	Collections     []file.Collection  // If set, decode these collections, wherever they are, instead of those in InputDir
+	Progress        func(n int64)      // If set, called as the data is restored with the number of archive bytes restored so far
+	Snapshot        string             // If set, decode this snapshot of a set holding snapshots rather than the latest
+	Serializer      file.Serializer    // If set, restores the decoded stream to OutputDir in place of tar, as serialized by the encode
+	TempDir         string             // If set, archives that must be extracted are extracted within this directory rather than the system's
+	KeepTemp        bool               // Keep the temporary directories archives are extracted into, for debugging, rather than removing them
+	RequireMetadata map[string]string  // If set, fail before restoring anything unless every collection records these metadata values
+	EnforcePolicy   bool               // Refuse collections used after the expiry recorded in them, rather than warning
+}
+
+// ListConfig holds configuration parameters for listing the contents of a set of collections.
+// This structure is created by the command-line interface and passed to ListCollections.
+type ListConfig struct {
+	InputDir    string      // Path to the directory containing collections to list
+	Verbose     bool        // Enable verbose logging
+	Compression Compression // Compression assumed for data encoded before its compression was recorded in the stream
+	Output      io.Writer   // Where the listing is written
+	Snapshot    string      // If set, list this snapshot of a set holding snapshots rather than the latest
+}
+
+// VerifyRestoreConfig holds the configuration for checking a restored directory
+// against the hashes recorded when its collections were encoded with RecordHashes.
+// This structure is created by the command-line interface and passed to VerifyRestore.
+type VerifyRestoreConfig struct {
+	InputDir    string      // Path to the directory containing the collections the files were restored from
+	OutputDir   string      // Path to the directory the files were restored into
+	Verbose     bool        // Enable verbose logging
+	Compression Compression // Compression assumed for data encoded before its compression was recorded in the stream
+	Files       []string    // If set, verify only these files or directories, as restored with DecodeConfig.Files
+	Snapshot    string      // If set, verify against this snapshot of a set holding snapshots rather than the latest
 }
 
 // EncodeDirectory encodes a directory using the padlock K-of-N threshold scheme.
@@ -125,21 +226,232 @@ type DecodeConfig struct {
 // The encoding process ensures that the resulting collections have the following property:
 // Any K or more collections can be used to reconstruct the original data, while
 // K-1 or fewer collections reveal absolutely nothing about the original data.
-func EncodeDirectory(ctx context.Context, cfg EncodeConfig) error {
+func EncodeDirectory(ctx context.Context, cfg EncodeConfig) (err error) {
+	ctx, op := trace.StartOperation(ctx, "encode directory")
+	defer op.End()
 	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	if cfg.Audit != nil {
+		defer func() { err = auditEncode(ctx, cfg, err) }()
+	}
 	start := time.Now()
-	log.Infof("Starting encode: InputDir=%s OutputDir=%s", cfg.InputDir, cfg.OutputDir)
+	if len(cfg.Inputs) > 0 {
+		log.Infof("Starting encode: Inputs=%s OutputDir=%s", strings.Join(cfg.Inputs, ","), cfg.OutputDir)
+	} else {
+		log.Infof("Starting encode: InputDir=%s OutputDir=%s", cfg.InputDir, cfg.OutputDir)
+	}
 	log.Debugf("Encode parameters: copies=%d, required=%d, Format=%s, ChunkSize=%d", cfg.N, cfg.K, cfg.Format, cfg.ChunkSize)
 
-	// Validate input directory to ensure it exists and is accessible
-	if err := file.ValidateInputDirectory(ctx, cfg.InputDir); err != nil {
+	// Validate the options, and the input directory to ensure it exists and is accessible
+	if err := cfg.Validate(ctx); err != nil {
 		return err
 	}
+	if cfg.ShredInput {
+		cfg.Serialize.RecordHashes = true
+	}
+	if cfg.PadsDir != "" {
+		m, err := readPads(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		cfg.ChunkSize = m.ChunkSize
+	} else {
+		cfg.ChunkSize = raiseChunkSize(ctx, cfg)
+	}
+	policy := cfg.Randomness
+	if policy == "" {
+		policy = pad.RandomnessWarn
+	}
+	checker := pad.NewRandomnessChecker(policy)
+	ctx = pad.WithRandomnessChecker(ctx, checker)
+	defer summarizeRandomness(ctx, checker)
+	if cfg.EntropyReport != "" {
+		var report func() error
+		cfg.RNG, report = recordEntropy(ctx, cfg.RNG, cfg.EntropyReport)
+		defer func() {
+			if err == nil {
+				err = report()
+			}
+		}()
+	}
+	if len(cfg.Inputs) > 0 {
+		if err := file.ValidateInputs(ctx, cfg.Inputs); err != nil {
+			return err
+		}
+	} else if err := file.ValidateInputDirectory(ctx, cfg.InputDir); err != nil {
+		return err
+	}
+	if cfg.DecoyDir != "" {
+		if err := file.ValidateInputDirectory(ctx, cfg.DecoyDir); err != nil {
+			return err
+		}
+	}
+
+	// Encode the serialized input directory into the collections
+	var inputHash hash.Hash
+	openInput := func() (io.ReadCloser, error) {
+		if cfg.Verify {
+			inputHash = sha256.New()
+		}
+		r, err := serializeDirectory(ctx, cfg, cfg.InputDir, cfg.Inputs, inputHash)
+		if err != nil || cfg.Progress == nil {
+			return r, err
+		}
+		return readCloser{&progressReader{r: r, progress: cfg.Progress}, r}, nil
+	}
+
+	// Measure the input, so that the output can be checked to have room for it
+	if cfg.InputSize == 0 {
+		if size, entries, err := inputsSize(cfg); err == nil {
+			cfg.InputSize = estimateArchiveSize(size, entries)
+		} else {
+			log.Debugf("Not checking the output has room for the input: %v", err)
+		}
+	}
+	if len(cfg.Groups) > 0 {
+		if err := encodeDirectoryGroups(ctx, cfg, openInput); err != nil {
+			return outputFullError(err)
+		}
+	} else if len(cfg.Custodians) > 0 {
+		if err := encodeDirectoryCustodians(ctx, cfg, openInput); err != nil {
+			return outputFullError(err)
+		}
+	} else if cfg.Snapshot != "" {
+		if err := encodeSnapshot(ctx, cfg, openInput); err != nil {
+			return outputFullError(err)
+		}
+	} else if cfg.Hybrid != HybridNone {
+		if err := encodeHybrid(ctx, cfg, openInput); err != nil {
+			return outputFullError(err)
+		}
+	} else if err := encodeStream(ctx, cfg, openInput); err != nil {
+		return outputFullError(err)
+	}
+
+	// Check that K of the collections just written decode to the input
+	if cfg.Verify {
+		if err := verifyEncode(ctx, cfg, inputHash.Sum(nil)); err != nil {
+			return err
+		}
+	}
+
+	// Replace the plaintext with the collections, once they are known to restore it
+	if cfg.ShredInput {
+		if err := shredInput(ctx, cfg); err != nil {
+			return err
+		}
+	}
+
+	// Log completion information including elapsed time
+	elapsed := time.Since(start)
+	if len(cfg.Groups) > 0 {
+		log.Infof("Encode complete (%s) -groups %s -format %s", elapsed, groupPolicySpec(cfg.Groups), cfg.Format)
+		return nil
+	}
+	if len(cfg.Custodians) > 0 {
+		log.Infof("Encode complete (%s) -custodians %s -required %d -format %s", elapsed, custodianSpec(cfg.Custodians), cfg.K, cfg.Format)
+		return nil
+	}
+	log.Infof("Encode complete (%s) -copies %d -required %d -format %s", elapsed, cfg.N, cfg.K, cfg.Format)
+	return nil
+}
+
+// serializeDirectory opens a tar stream of dir or, if inputs are given, of
+// each of them under its name, compressed as configured and led by a header
+// recording the compression. If digest is set, the tar stream is hashed into
+// it as it is read.
+func serializeDirectory(ctx context.Context, cfg EncodeConfig, dir string, inputs []string, digest hash.Hash) (io.ReadCloser, error) {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	// Create a tar stream from the input directory, or the stream of the
+	// configured serializer. This serializes all files and directories into a
+	// single stream for processing.
+	var tarStream io.ReadCloser
+	var err error
+	if cfg.Serializer != nil {
+		log.Debugf("Creating stream from input directory with %T: %s", cfg.Serializer, dir)
+		tarStream, err = cfg.Serializer.SerializeToStream(ctx, dir, cfg.Serialize)
+	} else if len(inputs) > 0 {
+		log.Debugf("Creating tar stream from inputs: %s", strings.Join(inputs, ", "))
+		tarStream, err = file.SerializeInputsToStream(ctx, inputs, cfg.Serialize)
+	} else {
+		log.Debugf("Creating tar stream from input directory: %s", dir)
+		tarStream, err = file.SerializeDirectoryToStream(ctx, dir, cfg.Serialize)
+	}
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create tar stream: %w", err))
+		return nil, fmt.Errorf("failed to create tar stream: %w", err)
+	}
+	if digest != nil {
+		tarStream = readCloser{io.TeeReader(tarStream, digest), tarStream}
+	}
+
+	// Add compression if configured (typically GZIP)
+	// This reduces storage requirements without affecting security
+	if cfg.Compression == CompressionGzip {
+		log.Debugf("Adding gzip compression to stream")
+		return withStreamHeader(readCloser{file.CompressStreamToStream(ctx, tarStream), tarStream}, cfg.Compression), nil
+	}
+	return withStreamHeader(tarStream, cfg.Compression), nil
+}
+
+// encodeStream prepares the output of an encode, runs the stream returned by
+// openInput through the pad encoder into the collections, and then finishes
+// them (verifying targets, recording volumes and zipping as configured). The
+// input is opened only once the output is ready.
+func encodeStream(ctx context.Context, cfg EncodeConfig, openInput func() (io.ReadCloser, error)) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	ctx = file.WithChunkNaming(ctx, cfg.ChunkNaming)
 
-	// Prepare the output directory, clearing it if requested and it's not empty
-	if err := file.PrepareOutputDirectory(ctx, cfg.OutputDir, cfg.ClearIfNotEmpty); err != nil {
+	// Prepare the output directory, clearing it if requested and it's not empty.
+	// Collections written to targets need no output directory.
+	if len(cfg.Targets) > 0 {
+		if len(cfg.Targets) != cfg.N {
+			log.Error(fmt.Errorf("%w: %d targets given for %d collections", ErrInvalidConfig, len(cfg.Targets), cfg.N))
+			return fmt.Errorf("%w: %d targets given for %d collections", ErrInvalidConfig, len(cfg.Targets), cfg.N)
+		}
+		if cfg.VolumeSize > 0 {
+			log.Error(fmt.Errorf("%w: targets cannot be combined with multi-volume collections", ErrInvalidConfig))
+			return fmt.Errorf("%w: targets cannot be combined with multi-volume collections", ErrInvalidConfig)
+		}
+		if err := file.ValidateTargets(ctx, cfg.Targets); err != nil {
+			return err
+		}
+	} else if len(cfg.Destinations) > 0 {
+		if err := validateDestinations(cfg.Destinations, cfg.N, cfg.OutputDir); err != nil {
+			log.Error(err)
+			return err
+		}
+	} else if err := file.PrepareOutputDirectory(ctx, cfg.OutputDir, cfg.ClearIfNotEmpty); err != nil {
 		return err
 	}
+	if cfg.ParityPercent < 0 || cfg.ParityPercent > 100 {
+		log.Error(fmt.Errorf("%w: parity must be between 0 and 100 percent, got %d", ErrInvalidConfig, cfg.ParityPercent))
+		return fmt.Errorf("%w: parity must be between 0 and 100 percent, got %d", ErrInvalidConfig, cfg.ParityPercent)
+	}
+	if cfg.ParityPercent > 0 && cfg.VolumeSize > 0 {
+		log.Error(fmt.Errorf("%w: parity cannot be combined with multi-volume collections", ErrInvalidConfig))
+		return fmt.Errorf("%w: parity cannot be combined with multi-volume collections", ErrInvalidConfig)
+	}
+	if err := cfg.ChunkNaming.CheckFormat(cfg.Format); err != nil {
+		log.Error(fmt.Errorf("%w: %w", ErrInvalidConfig, err))
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if len(cfg.Decoders) > 0 && cfg.VolumeSize > 0 {
+		log.Error(fmt.Errorf("%w: decoders cannot be embedded in multi-volume collections", ErrInvalidConfig))
+		return fmt.Errorf("%w: decoders cannot be embedded in multi-volume collections", ErrInvalidConfig)
+	}
+	platforms := make(map[string]bool)
+	for _, d := range cfg.Decoders {
+		if platforms[d.Platform()] {
+			log.Error(fmt.Errorf("%w: more than one decoder for %s", ErrInvalidConfig, d.Platform()))
+			return fmt.Errorf("%w: more than one decoder for %s", ErrInvalidConfig, d.Platform())
+		}
+		platforms[d.Platform()] = true
+	}
+	if cfg.PadChunks < 0 || (cfg.PadChunks > 0 && !cfg.PadLength) {
+		log.Error(fmt.Errorf("%w: extra padding chunks must be zero or more and require length padding, got %d", ErrInvalidConfig, cfg.PadChunks))
+		return fmt.Errorf("%w: extra padding chunks must be zero or more and require length padding, got %d", ErrInvalidConfig, cfg.PadChunks)
+	}
 
 	// Create a new pad instance with the specified N and K parameters
 	// This is the core cryptographic component that implements the threshold scheme
@@ -149,39 +461,131 @@ func EncodeDirectory(ctx context.Context, cfg EncodeConfig) error {
 		log.Error(fmt.Errorf("failed to create pad instance: %w", err))
 		return err
 	}
+	p.PadToChunk, p.ExtraChunks = cfg.PadLength, cfg.PadChunks
+	var labels map[string]string
+	if len(cfg.Labels) > 0 {
+		if err := validateLabels(cfg.Labels, cfg.N); err != nil {
+			log.Error(err)
+			return err
+		}
+		labels = collectionLabels(p.Collections, cfg.Labels)
+	}
+	policy := cfg.policy()
+	describe := len(cfg.Metadata) > 0 || policy != nil
+
+	// Each collection is written to its destination, if it has one, or else
+	// to the output directory
+	collDirs := cfg.collectionDirs()
+	if len(cfg.Destinations) > 0 {
+		if err := prepareDestinations(ctx, cfg); err != nil {
+			return err
+		}
+	}
+
+	// Check that the output has room for the collections before writing any.
+	// Chunks are streamed into zips or packed files unless parity, obfuscated
+	// names or replicated hybrid data need them all written first.
+	packed := cfg.Format == FormatPacked
+	streamZips := (cfg.ZipCollections || packed) && cfg.ParityPercent == 0 && !cfg.ObfuscateNames && len(cfg.Targets) == 0 && cfg.VolumeSize == 0 && cfg.Hybrid != HybridReplicated
+	if streamZips, err = checkOutputLimits(ctx, cfg, p.PermutationCount, streamZips); err != nil {
+		return err
+	}
 
 	// Create collection directories where encoded chunks will be stored
 	// Collections are named according to the K-of-N scheme (e.g., "3A5", "3B5", etc.)
-	collections, err := file.CreateCollections(ctx, cfg.OutputDir, p.Collections)
-	if err != nil {
-		return err
+	// Multi-volume collections instead create their volume directories as chunks are written.
+	var collections []file.Collection
+	volumeWriters := make(map[string]*file.VolumeWriter)
+	targetWriters := make(map[string]*file.TargetWriter)
+	zipWriters := make(map[string]*file.ZipWriter)
+	packedWriters := make(map[string]*file.PackedWriter)
+	defer func() {
+		for _, zw := range zipWriters {
+			zw.Finish()
+		}
+		for _, pw := range packedWriters {
+			pw.Finish()
+		}
+	}()
+	if len(cfg.Targets) > 0 {
+		for i, collName := range p.Collections {
+			tw, err := file.NewTargetWriter(ctx, cfg.Targets[i], collName, cfg.Format, cfg.ClearIfNotEmpty)
+			if err != nil {
+				return err
+			}
+			targetWriters[collName] = tw
+			collections = append(collections, tw.Collection())
+		}
+	} else if cfg.VolumeSize > 0 {
+		if cfg.VolumeSize <= int64(cfg.ChunkSize) {
+			log.Error(fmt.Errorf("%w: volume size %d must be larger than the chunk size %d", ErrInvalidConfig, cfg.VolumeSize, cfg.ChunkSize))
+			return fmt.Errorf("%w: volume size %d must be larger than the chunk size %d", ErrInvalidConfig, cfg.VolumeSize, cfg.ChunkSize)
+		}
+		for i, collName := range p.Collections {
+			volumeWriters[collName] = file.NewVolumeWriter(ctx, collDirs[i], collName, cfg.Format, cfg.VolumeSize)
+		}
+	} else if streamZips && packed {
+		// Chunks are appended to each collection's packed file as they are written
+		for i, collName := range p.Collections {
+			pw, err := file.NewPackedWriter(ctx, collDirs[i], collName)
+			if err != nil {
+				return err
+			}
+			packedWriters[collName] = pw
+		}
+	} else if streamZips {
+		// Chunks are streamed straight into each collection's zip. Parity is
+		// computed from the finished chunks, and names are obfuscated once they
+		// are all written, so such collections are written as directories and
+		// zipped afterward.
+		for i, collName := range p.Collections {
+			zw, err := file.NewZipWriter(ctx, collDirs[i], collName, cfg.Format)
+			if err != nil {
+				return err
+			}
+			zipWriters[collName] = zw
+		}
+	} else {
+		collections, err = file.CreateCollectionsAt(ctx, collDirs, p.Collections)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Get the formatter for the specified format (binary or PNG)
 	// This determines how data chunks are written to and read from disk
-	formatter := file.GetFormatter(cfg.Format)
+	formatter := file.GetNamedFormatter(cfg.Format, cfg.ChunkNaming)
 
-	// Create a tar stream from the input directory
-	// This serializes all files and directories into a single stream for processing
-	log.Debugf("Creating tar stream from input directory: %s", cfg.InputDir)
-	tarStream, err := file.SerializeDirectoryToStream(ctx, cfg.InputDir)
+	// Open the input stream
+	inputStream, err := openInput()
 	if err != nil {
-		log.Error(fmt.Errorf("failed to create tar stream: %w", err))
-		return fmt.Errorf("failed to create tar stream: %w", err)
-	}
-	defer tarStream.Close()
-
-	// Add compression if configured (typically GZIP)
-	// This reduces storage requirements without affecting security
-	var inputStream io.Reader = tarStream
-	if cfg.Compression == CompressionGzip {
-		log.Debugf("Adding gzip compression to stream")
-		inputStream = file.CompressStreamToStream(ctx, tarStream)
+		return err
 	}
+	defer inputStream.Close()
 
 	// Define a callback function that creates chunk writers for the encoding process
 	// Each time the pad encoder needs to write a chunk, this function is called
 	newChunkFunc := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		// Multi-volume collections place each chunk in the volume with room for it
+		if vw, ok := volumeWriters[collectionName]; ok {
+			return vw.NewChunkWriter(chunkNumber)
+		}
+
+		// Collections written to targets are verified as each chunk is written
+		if tw, ok := targetWriters[collectionName]; ok {
+			return tw.NewChunkWriter(chunkNumber), nil
+		}
+
+		// Zipped collections receive each chunk as it is written
+		if zw, ok := zipWriters[collectionName]; ok {
+			return zw.NewChunkWriter(chunkNumber), nil
+		}
+
+		// Packed collections receive each chunk as it is written
+		if pw, ok := packedWriters[collectionName]; ok {
+			return pw.NewChunkWriter(chunkNumber), nil
+		}
+
 		// Find the collection path for the given collection name
 		var collPath string
 		for _, coll := range collections {
@@ -199,39 +603,294 @@ func EncodeDirectory(ctx context.Context, cfg EncodeConfig) error {
 		return file.NewChunkWriter(ctx, formatter, collPath, 0, chunkNumber), nil
 	}
 
+	// Digest the chunks of each collection as they are written, for the
+	// verification codes issued with them
+	digests := make(map[string]*codeDigest)
+	for _, collName := range p.Collections {
+		digests[collName] = newCodeDigest(collName)
+	}
+	writeChunk := newChunkFunc
+	newChunkFunc = func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		w, err := writeChunk(collectionName, chunkNumber, chunkFormat)
+		if err != nil {
+			return nil, err
+		}
+		return &codeWriter{WriteCloser: w, digest: digests[collectionName], chunkNumber: chunkNumber}, nil
+	}
+
 	// Run the actual encoding process, which:
 	// 1. Reads data from the input stream in chunks
 	// 2. Generates random one-time pads for each chunk
 	// 3. XORs input data with pads to create ciphertext
 	// 4. Distributes the results across collections according to the threshold scheme
 	log.Debugf("Starting encode process with chunk size: %d", cfg.ChunkSize)
-	err = p.Encode(
-		ctx,
-		cfg.ChunkSize,
-		inputStream,
-		cfg.RNG,
-		newChunkFunc,
-		string(cfg.Format),
-	)
+	if cfg.DecoyDir != "" {
+		decoyStream, decoyErr := serializeDirectory(ctx, cfg, cfg.DecoyDir, nil, nil)
+		if decoyErr != nil {
+			return decoyErr
+		}
+		defer decoyStream.Close()
+		err = p.EncodeDual(ctx, cfg.ChunkSize, inputStream, decoyStream, cfg.DecoyLetters, cfg.RNG, newChunkFunc, string(cfg.Format))
+	} else if cfg.PadsDir != "" {
+		err = applyPads(ctx, cfg, p, inputStream, newChunkFunc)
+	} else {
+		err = p.Encode(
+			ctx,
+			cfg.ChunkSize,
+			inputStream,
+			cfg.RNG,
+			newChunkFunc,
+			string(cfg.Format),
+		)
+	}
 	if err != nil {
 		log.Error(fmt.Errorf("encoding failed: %w", err))
 		return fmt.Errorf("encoding failed: %w", err)
 	}
 
-	// Create ZIP archives for each collection if requested
+	// Complete the zips that collections were streamed into, with the decoders
+	for _, collName := range p.Collections {
+		if zw, ok := zipWriters[collName]; ok {
+			if err := file.AddDecoders(cfg.Decoders, cfg.K, zw.AddFileMode); err != nil {
+				return err
+			}
+			if len(cfg.Labels) > 0 {
+				if err := file.AddLabels(labels, zw.AddFileMode); err != nil {
+					return err
+				}
+			}
+			if describe {
+				if err := file.AddMetadata(collName, cfg.Metadata, policy, zw.AddFileMode); err != nil {
+					return err
+				}
+			}
+			zipPath, err := zw.Finish()
+			if err != nil {
+				return err
+			}
+			log.Infof("Created zip archive for collection %s: %s", collName, zipPath)
+		}
+		if pw, ok := packedWriters[collName]; ok {
+			if err := file.AddDecoders(cfg.Decoders, cfg.K, pw.AddFileMode); err != nil {
+				return err
+			}
+			if len(cfg.Labels) > 0 {
+				if err := file.AddLabels(labels, pw.AddFileMode); err != nil {
+					return err
+				}
+			}
+			if describe {
+				if err := file.AddMetadata(collName, cfg.Metadata, policy, pw.AddFileMode); err != nil {
+					return err
+				}
+			}
+			packedPath, err := pw.Finish()
+			if err != nil {
+				return err
+			}
+			log.Infof("Created packed file for collection %s: %s", collName, packedPath)
+		}
+	}
+
+	// Flush each target and write its verification report
+	var verifyErr error
+	for _, collName := range p.Collections {
+		if tw, ok := targetWriters[collName]; ok {
+			reportPath, err := tw.Finish()
+			if err != nil && verifyErr == nil {
+				verifyErr = err
+			}
+			if reportPath != "" {
+				log.Infof("Verification report: %s", reportPath)
+			}
+		}
+	}
+	if verifyErr != nil {
+		return verifyErr
+	}
+
+	// Record the volume layout of multi-volume collections. Each volume directory
+	// is then treated as a unit of its own, so that it is zipped separately.
+	for _, collName := range p.Collections {
+		vw, ok := volumeWriters[collName]
+		if !ok {
+			continue
+		}
+		volumeDirs, err := vw.Finish()
+		if err != nil {
+			return err
+		}
+		if !cfg.ObfuscateNames && cfg.StoreDir == "" {
+			volumes := file.Collection{Name: collName, Format: cfg.Format, Naming: cfg.ChunkNaming}
+			for _, dir := range volumeDirs {
+				volumes.Volumes = append(volumes.Volumes, filepath.Join(dir, collName))
+			}
+			volumes.Path = volumes.Volumes[0]
+			if err := file.WriteIndex(ctx, volumes); err != nil {
+				return err
+			}
+		}
+		for _, dir := range volumeDirs {
+			collections = append(collections, file.Collection{Name: collName, Path: dir})
+			if len(cfg.Labels) > 0 {
+				if err := file.WriteLabels(ctx, filepath.Join(dir, collName), labels); err != nil {
+					return err
+				}
+			}
+			if describe {
+				if err := file.WriteMetadata(ctx, filepath.Join(dir, collName), collName, cfg.Metadata, policy); err != nil {
+					return err
+				}
+			}
+		}
+		log.Infof("Collection %s: %d volumes", collName, len(volumeDirs))
+	}
+
+	// Give each collection its own copy of the hybrid-encrypted data
+	if cfg.Hybrid == HybridReplicated {
+		if err := replicateHybridData(ctx, cfg.OutputDir, collections); err != nil {
+			return err
+		}
+	}
+
+	// Add parity to each collection, so that chunks damaged in storage can be rebuilt
+	if cfg.ParityPercent > 0 {
+		for _, coll := range collections {
+			coll.Format, coll.Naming = cfg.Format, cfg.ChunkNaming
+			if err := file.WriteParity(ctx, coll, cfg.ParityPercent); err != nil {
+				return err
+			}
+		}
+		log.Infof("Added %d%% parity to each collection", cfg.ParityPercent)
+	}
+
+	// Embed the decoders in each collection, before it is stored or archived
+	if len(cfg.Decoders) > 0 {
+		for _, coll := range collections {
+			if err := file.WriteDecoders(ctx, coll.Path, cfg.Decoders, cfg.K); err != nil {
+				return err
+			}
+		}
+	}
+	if len(cfg.Decoders) > 0 {
+		var platforms []string
+		for _, d := range cfg.Decoders {
+			platforms = append(platforms, d.Platform())
+		}
+		log.Infof("Embedded decoders in each collection for %s", strings.Join(platforms, ", "))
+	}
+
+	// Record the labels of the collections in each, before it is stored or
+	// archived; each volume of a multi-volume collection already holds them
+	if len(cfg.Labels) > 0 && cfg.VolumeSize == 0 {
+		for _, coll := range collections {
+			if err := file.WriteLabels(ctx, coll.Path, labels); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Record the metadata and usage policy of the set in the manifest of each
+	// collection, which for a multi-volume collection is the manifest of each
+	// of its volumes
+	if describe && cfg.VolumeSize == 0 {
+		for _, coll := range collections {
+			if err := file.WriteMetadata(ctx, coll.Path, coll.Name, cfg.Metadata, policy); err != nil {
+				return err
+			}
+		}
+	}
+	if len(cfg.Metadata) > 0 {
+		log.Infof("Recorded metadata in each collection: %s", formatMetadata(cfg.Metadata))
+	}
+	if policy != nil {
+		log.Infof("Recorded policy in each collection: %s", formatPolicy(policy, time.Now()))
+		if policy.Expired(time.Now()) {
+			log.Infof("Warning: the collections are already past their expiry, %s", policy.NotAfter.Format(time.RFC3339))
+		}
+	}
+
+	// Index the chunks of each collection directory, so that they are found
+	// without scanning when decoding. Obfuscated and stored collections have
+	// manifests of their own that map their files, and packed ones an index
+	// of their own.
+	if !cfg.ObfuscateNames && cfg.StoreDir == "" && cfg.VolumeSize == 0 && !packed {
+		for _, coll := range collections {
+			coll.Format, coll.Naming = cfg.Format, cfg.ChunkNaming
+			if err := file.WriteIndex(ctx, coll); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Rename the collections before they are archived, so that the archives
+	// are named innocuously too
+	if cfg.ObfuscateNames {
+		var err error
+		if collections, err = file.ObfuscateCollections(ctx, collections); err != nil {
+			return err
+		}
+	}
+
+	// Move the files of the collections into the chunk store, leaving their manifests
+	if cfg.StoreDir != "" {
+		var err error
+		if collections, err = file.StoreCollections(ctx, collections, cfg.OutputDir, cfg.StoreDir); err != nil {
+			return err
+		}
+	}
+
+	// Create ZIP archives for each collection if requested and not already streamed
 	// This makes it easier to distribute collections to different locations
-	if cfg.ZipCollections {
+	if cfg.ZipCollections && len(zipWriters) == 0 {
 		if _, err := file.ZipCollections(ctx, collections); err != nil {
 			return err
 		}
 	}
+	if cfg.TarCollections {
+		if _, err := file.TarCollections(ctx, collections); err != nil {
+			return err
+		}
+	}
+	if packed && len(packedWriters) == 0 {
+		if _, err := file.PackCollections(ctx, collections); err != nil {
+			return err
+		}
+	}
+
+	// Issue the verification code of each collection, with which its
+	// custodian can later check it with "padlock checkcode"
+	for _, collName := range p.Collections {
+		log.Infof("Verification code for collection %s: %s", collName, digests[collName].code())
+	}
 
-	// Log completion information including elapsed time
-	elapsed := time.Since(start)
-	log.Infof("Encode complete (%s) -copies %d -required %d -format %s", elapsed, cfg.N, cfg.K, cfg.Format)
 	return nil
 }
 
+// readCloser reads from one stream and closes another, such as a compressor
+// and the stream it compresses
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// progressReader reports the number of bytes read through it so far
+type progressReader struct {
+	r        io.Reader
+	n        int64
+	progress func(n int64)
+}
+
+// Read implements io.Reader
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.n += int64(n)
+		p.progress(p.n)
+	}
+	return n, err
+}
+
 // DecodeDirectory reconstructs original data from K or more collections using the padlock scheme.
 //
 // This function orchestrates the entire decoding process:
@@ -254,166 +913,429 @@ func EncodeDirectory(ctx context.Context, cfg EncodeConfig) error {
 // N collections are provided. With fewer than K collections, the function will fail
 // and no information about the original data can be recovered due to the information-theoretic
 // security properties of the threshold scheme.
-func DecodeDirectory(ctx context.Context, cfg DecodeConfig) error {
+func DecodeDirectory(ctx context.Context, cfg DecodeConfig) (err error) {
+	ctx, op := trace.StartOperation(ctx, "decode directory")
+	defer op.End()
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	if cfg.Audit != nil {
+		defer func() { err = auditDecode(ctx, cfg, err) }()
+	}
+	start := time.Now()
+	if len(cfg.Collections) > 0 {
+		log.Infof("Starting decode: %d collections OutputDir=%s", len(cfg.Collections), cfg.OutputDir)
+	} else {
+		log.Infof("Starting decode: InputDir=%s OutputDir=%s", cfg.InputDir, cfg.OutputDir)
+	}
+
+	// Validate the options, and the input directory to ensure it exists and is accessible
+	if err := cfg.Validate(ctx); err != nil {
+		return err
+	}
+	if len(cfg.Collections) == 0 {
+		if err := file.ValidateInputDirectory(ctx, cfg.InputDir); err != nil {
+			return err
+		}
+	}
+
+	// Prepare the output directory, clearing it if requested and it's not empty.
+	// With a conflict policy, a non-empty directory is restored into file by file,
+	// and when streaming to a writer no directory is needed at all.
+	if cfg.OutputWriter != nil {
+		log.Debugf("Streaming decoded files to writer instead of %s", cfg.OutputDir)
+	} else if cfg.Deserialize.OnConflict != file.ConflictNone && !cfg.ClearIfNotEmpty {
+		if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
+			log.Error(fmt.Errorf("failed to create output directory: %w", err))
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	} else if err := file.PrepareOutputDirectory(ctx, cfg.OutputDir, cfg.ClearIfNotEmpty); err != nil {
+		return err
+	}
+
+	// Archives that cannot be read in place are extracted where configured
+	if cfg.TempDir != "" || cfg.KeepTemp {
+		ctx = file.WithTempDir(ctx, cfg.TempDir, cfg.KeepTemp)
+	}
+
+	// Sets holding snapshots are decoded from the one requested, or else the latest
+	if cfg.Snapshot != "" {
+		log.Infof("Decoding snapshot %s", cfg.Snapshot)
+		ctx = file.WithSnapshot(ctx, cfg.Snapshot)
+	}
+
+	// Collections that don't record the required metadata, or that have
+	// expired when their policy is enforced, are refused
+	if len(cfg.RequireMetadata) > 0 {
+		ctx = withRequiredMetadata(ctx, cfg.RequireMetadata)
+	}
+	if cfg.EnforcePolicy {
+		ctx = withEnforcedPolicy(ctx)
+	}
+
+	// Decode the collections and deserialize the resulting stream
+	cfg.Deserialize.Strict = cfg.Strict
+	consume := func(deserializeCtx context.Context, outputStream io.Reader) error {
+		if cfg.Progress != nil {
+			outputStream = &progressReader{r: outputStream, progress: cfg.Progress}
+		}
+
+		// Stream just the selected file contents when writing to a writer
+		if cfg.OutputWriter != nil {
+			return file.ExtractFilesToWriter(deserializeCtx, outputStream, cfg.OutputWriter, cfg.Deserialize.Files)
+		}
+
+		// Restore the stream of a custom serializer with the same serializer
+		if cfg.Serializer != nil {
+			log.Debugf("Deserializing to output directory with %T: %s", cfg.Serializer, cfg.OutputDir)
+			return cfg.Serializer.DeserializeFromStream(deserializeCtx, cfg.OutputDir, outputStream, cfg.ClearIfNotEmpty, cfg.Deserialize)
+		}
+
+		// Deserialize the tar stream to the output directory
+		// This reconstructs the original directory structure and files
+		log.Debugf("Deserializing to output directory: %s", cfg.OutputDir)
+		err := file.DeserializeDirectoryFromStream(deserializeCtx, cfg.OutputDir, outputStream, cfg.ClearIfNotEmpty, cfg.Deserialize)
+		if err != nil {
+			// Special case: Don't treat "too small" tar file as an error for small inputs
+			if !cfg.Strict && strings.Contains(err.Error(), "too small to be a valid tar file") {
+				log.Infof("Input data appears to be a small raw file rather than a tar archive")
+				return nil
+			}
+			log.Error(fmt.Errorf("failed to deserialize directory: %w", err))
+			return err
+		}
+		return nil
+	}
+	if len(cfg.Collections) > 0 {
+		err = decodeFound(ctx, cfg.Collections, cfg.Compression, cfg.Strict, consume)
+	} else {
+		err = decodeCollections(ctx, cfg.InputDir, cfg.Compression, cfg.Strict, consume)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Log completion information including elapsed time
+	elapsed := time.Since(start)
+	log.Infof("Decode complete (%s)", elapsed)
+	return nil
+}
+
+// ListCollections reconstructs the archive held by K or more collections and
+// writes a listing of its entries, with sizes and modification times, to
+// cfg.Output. Nothing is written to disk: file bodies are decoded but discarded,
+// which lets users confirm what a set of collections contains before restoring it.
+// Only tar archives are listed, not the streams of a custom Serializer.
+func ListCollections(ctx context.Context, cfg ListConfig) error {
 	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
 	start := time.Now()
-	log.Infof("Starting decode: InputDir=%s OutputDir=%s", cfg.InputDir, cfg.OutputDir)
+	log.Infof("Starting list: InputDir=%s", cfg.InputDir)
 
 	// Validate input directory to ensure it exists and is accessible
 	if err := file.ValidateInputDirectory(ctx, cfg.InputDir); err != nil {
 		return err
 	}
 
-	// Prepare the output directory, clearing it if requested and it's not empty
-	if err := file.PrepareOutputDirectory(ctx, cfg.OutputDir, cfg.ClearIfNotEmpty); err != nil {
+	if cfg.Snapshot != "" {
+		ctx = file.WithSnapshot(ctx, cfg.Snapshot)
+	}
+	err := decodeCollections(ctx, cfg.InputDir, cfg.Compression, false, func(listCtx context.Context, r io.Reader) error {
+		return file.ListArchive(listCtx, r, cfg.Output)
+	})
+	if err != nil {
 		return err
 	}
 
+	log.Infof("List complete (%s)", time.Since(start))
+	return nil
+}
+
+// VerifyRestore reconstructs the archive held by K or more collections, reads
+// the hashes recorded in it when it was encoded, and checks the files restored
+// into cfg.OutputDir against them. As with ListCollections, file bodies are
+// decoded but discarded. Files that are missing or differ are logged and make
+// the result ErrHashMismatch.
+func VerifyRestore(ctx context.Context, cfg VerifyRestoreConfig) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	start := time.Now()
+	log.Infof("Starting verify-restore: InputDir=%s, OutputDir=%s", cfg.InputDir, cfg.OutputDir)
+
+	if err := file.ValidateInputDirectory(ctx, cfg.InputDir); err != nil {
+		return err
+	}
+	if err := file.ValidateInputDirectory(ctx, cfg.OutputDir); err != nil {
+		return err
+	}
+	if err := file.ValidatePatterns(cfg.Files); err != nil {
+		log.Error(fmt.Errorf("%w: %w", ErrInvalidConfig, err))
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	if cfg.Snapshot != "" {
+		ctx = file.WithSnapshot(ctx, cfg.Snapshot)
+	}
+	var manifest *file.HashManifest
+	err := decodeCollections(ctx, cfg.InputDir, cfg.Compression, false, func(readCtx context.Context, r io.Reader) error {
+		var err error
+		manifest, err = file.ReadHashManifest(readCtx, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		log.Error(fmt.Errorf("%w: the collections hold no file hashes; encode with -hashes to record them", ErrInvalidConfig))
+		return fmt.Errorf("%w: the collections hold no file hashes; encode with -hashes to record them", ErrInvalidConfig)
+	}
+	if err := file.VerifyRestored(ctx, manifest, cfg.OutputDir, cfg.Files); err != nil {
+		return err
+	}
+
+	log.Infof("Verify-restore complete (%s)", time.Since(start))
+	return nil
+}
+
+// decodeCollections locates the collections in inputDir, runs them through the
+// pad decoder and hands the reconstructed (decompressed) stream to consume,
+// which runs in its own goroutine concurrently with decoding. It returns the
+// first error from either side. In strict mode, collections that would be set
+// aside and chunks that cannot be recovered stop the decode.
+func decodeCollections(ctx context.Context, inputDir string, compression Compression, strict bool, consume func(ctx context.Context, r io.Reader) error) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	// A hierarchical set holds a subdirectory of collections per group
+	if groupDirs, err := file.FindGroups(ctx, inputDir); err == nil && len(groupDirs) > 0 {
+		return decodeGroups(ctx, groupDirs, compression, strict, consume)
+	}
+
 	// Find collections (directories or zips) in the input directory
-	// This identifies all available collections, extracting ZIP files if necessary
-	collections, tempDir, err := file.FindCollections(ctx, cfg.InputDir)
+	// This identifies all available collections, opening ZIP files to be read in
+	// place or extracting those holding volumes or bundles
+	collections, tempDir, err := file.FindCollections(ctx, inputDir)
 	if err != nil {
 		return err
 	}
+	defer file.CloseCollections(collections)
 
 	// If we extracted zip files, clean up the temporary directory when done
-	if tempDir != "" {
-		defer func() {
-			log.Debugf("Cleaning up temporary directory: %s", tempDir)
-			os.RemoveAll(tempDir)
-		}()
-	}
+	defer file.RemoveTemp(ctx, tempDir)
 
 	// Ensure we found at least some collections
 	if len(collections) == 0 {
-		log.Error(fmt.Errorf("no collections found in input directory"))
-		return fmt.Errorf("no collections found in input directory")
+		log.Error(fmt.Errorf("%w in input directory", ErrNoCollections))
+		return fmt.Errorf("%w in input directory", ErrNoCollections)
 	}
 	log.Debugf("Found %d collections", len(collections))
+	return decodeFound(ctx, collections, compression, strict, consume)
+}
 
-	// Create collection readers for each collection
-	// These readers handle the format-specific details of reading chunks
-	readers := make([]io.Reader, len(collections))
-	collReaders := make([]*file.CollectionReader, len(collections))
+// decodeFound decodes collections that have already been located, as for
+// decodeCollections
+func decodeFound(ctx context.Context, collections []file.Collection, compression Compression, strict bool, consume func(ctx context.Context, r io.Reader) error) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
 
+	// Read the chunks of each collection by number, so that a chunk missing or
+	// damaged in one collection can be taken from others
+	sources := make([]pad.ChunkSource, len(collections))
 	for i, coll := range collections {
-		collReader := file.NewCollectionReader(coll)
-		collReaders[i] = collReader
-
-		// Create an adapter that converts the CollectionReader to an io.Reader
-		// This adapter handles the details of reading chunks sequentially
-		readers[i] = file.NewChunkReaderAdapter(ctx, collReader)
+		source, err := file.NewChunkSource(ctx, coll)
+		if err != nil {
+			log.Error(fmt.Errorf("failed to read collection %s: %w", coll.Name, err))
+			return fmt.Errorf("failed to read collection %s: %w", coll.Name, err)
+		}
+		sources[i] = source
 	}
 
 	// Get the number of available collections (important for pad initialization)
-	n := len(collections)
-	log.Infof("Collections: %d", n)
+	log.Infof("Collections: %d", len(collections))
+	logLabels(ctx, collections)
+	if err := checkMetadata(ctx, collections); err != nil {
+		return err
+	}
+	if err := checkPolicy(ctx, collections); err != nil {
+		return err
+	}
+
+	// The data of a hybrid encode is kept with the collections or next to them
+	ctx = withHybridData(ctx, collections)
+
+	var decodeReport *pad.DecodeReport
+	err := decodeStream(ctx, compression, strict, consume, func(w io.Writer) error {
+		var err error
+		decodeReport, err = new(pad.Pad).DecodeTolerant(ctx, sources, w, strict)
+		return err
+	})
+	if decodeReport != nil {
+		logDecodeReport(ctx, decodeReport)
+		// Lost chunks explain any failure of the consumer that follows from them
+		if lossErr := decodeReport.Err(); lossErr != nil && !decodeStopped(err) {
+			err = lossErr
+		}
+	}
+	if err == nil || decodeStopped(err) {
+		return err
+	}
+
+	// Explain the failure in terms of the collections that were supplied
+	d := diagnose(ctx, collections)
+	if len(d.Problems) == 0 {
+		return err
+	}
+	var report strings.Builder
+	d.WriteReport(&report)
+	for _, line := range strings.Split(strings.TrimRight(report.String(), "\n"), "\n") {
+		log.Infof("%s", line)
+	}
+	return fmt.Errorf("%w (%w)", err, d.Err())
+}
+
+// decodeStopped reports whether a decode ended because its consumer had read
+// all that it needed, rather than because it failed
+func decodeStopped(err error) bool {
+	return errors.Is(err, errGroupDecodeStopped) || errors.Is(err, errReshareStopped) || errors.Is(err, errMountReadStopped)
+}
+
+// logDecodeReport warns about the chunks a tolerant decode had to work around
+func logDecodeReport(ctx context.Context, report *pad.DecodeReport) {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	for _, name := range report.Ignored {
+		log.Infof("Warning: collection %s is not part of the set being decoded and was ignored", name)
+	}
+	for _, name := range report.Duplicates {
+		log.Infof("Warning: collection %s was supplied more than once; the copies are identical, so only one is needed", name)
+	}
+	names := make([]string, 0, len(report.Damaged))
+	for name := range report.Damaged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Infof("Warning: collection %s is missing or has damaged chunks %s", name, chunkList(report.Damaged[name]))
+	}
+	if len(report.Fallbacks) > 0 {
+		log.Infof("Warning: %d chunk(s) were recovered from other collections", len(report.Fallbacks))
+	}
+	if len(report.Unrecoverable) > 0 {
+		log.Infof("Warning: chunks %s could not be recovered and were restored as zeros", chunkList(report.Unrecoverable))
+	}
+}
+
+// decodeReaders runs collection streams through the pad decoder and hands the
+// reconstructed (decompressed) stream to consume, as for decodeCollections
+func decodeReaders(ctx context.Context, readers []io.Reader, compression Compression, strict bool, consume func(ctx context.Context, r io.Reader) error) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	return decodeStream(ctx, compression, strict, consume, func(w io.Writer) error {
+		// The pad is initialized with the number of available collections, and
+		// its K value is extracted from the collection metadata during decoding
+		log.Debugf("Creating pad instance with N=%d", len(readers))
+		p, err := pad.NewPadForDecode(ctx, len(readers))
+		if err != nil {
+			log.Error(fmt.Errorf("failed to create pad instance: %w", err))
+			return err
+		}
+		return p.Decode(ctx, readers, w)
+	})
+}
+
+// decodeStream runs decode, which writes the reconstructed stream, and hands
+// the stream (decompressed) to consume in its own goroutine. The end of the
+// decode closes the stream, and decodeStream then waits for consume to finish,
+// however long it takes. The stream is decompressed as its header records, or,
+// for a stream encoded before the compression was recorded, as compression
+// gives; with compressionRaw it is passed on as is. In strict mode, a stream
+// expected to be compressed that is not is an error rather than passed on as is.
+func decodeStream(ctx context.Context, compression Compression, strict bool, consume func(ctx context.Context, r io.Reader) error, decode func(w io.Writer) error) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
 
 	// Create a pipe for transferring decoded data between goroutines
 	// This allows parallel processing of decoding and deserialization
 	log.Debugf("Creating pipe for decoded data")
 	pr, pw := io.Pipe()
 
-	// Channel to signal completion of the deserialization goroutine
-	done := make(chan struct{})
-
 	// Start the deserialization process in a separate goroutine
-	// This goroutine reads from the pipe and writes to the output directory
-	var deserializeErr error
-	go func() {
-		defer close(done) // Signal completion via the done channel
-		defer pr.Close()  // Ensure pipe reader is closed when this goroutine exits
+	// This goroutine reads from the pipe and passes the stream to the consumer
+	var deserializer errgroup.Group
+	deserializer.Go(func() error {
+		defer pr.Close() // Ensure pipe reader is closed when this goroutine exits
 
 		deserializeCtx := trace.WithContext(ctx, log.WithPrefix("DESERIALIZE"))
 
-		// Create decompression stream if needed
-		// This reverses any compression applied during encoding
-		var outputStream io.Reader = pr
-		if cfg.Compression == CompressionGzip {
-			log.Debugf("Creating decompression stream")
-			var err error
-			outputStream, err = file.DecompressStreamToStream(deserializeCtx, pr)
+		if compression == compressionRaw {
+			return consume(deserializeCtx, pr)
+		}
+
+		// The collections of a hybrid encode hold the key of the data, which
+		// is decrypted in its place
+		br := bufio.NewReader(pr)
+		if isHybridStream(br) {
+			data, err := openHybridStream(deserializeCtx, br)
 			if err != nil {
-				log.Error(fmt.Errorf("failed to create decompression stream: %w", err))
-				deserializeErr = err
-				return
+				log.Error(err)
+				return err
 			}
+			defer data.Close()
+			br = bufio.NewReader(data)
 		}
 
-		// Deserialize the tar stream to the output directory
-		// This reconstructs the original directory structure and files
-		log.Debugf("Deserializing to output directory: %s", cfg.OutputDir)
-		err := file.DeserializeDirectoryFromStream(deserializeCtx, cfg.OutputDir, outputStream, cfg.ClearIfNotEmpty)
+		// Use the compression recorded in the stream, if it has a header
+		recorded, ok, err := readStreamHeader(br)
 		if err != nil {
-			// Special case: Don't treat "too small" tar file as an error for small inputs
-			if strings.Contains(err.Error(), "too small to be a valid tar file") {
-				log.Infof("Input data appears to be a small raw file rather than a tar archive")
-				deserializeErr = nil
-			} else {
-				log.Error(fmt.Errorf("failed to deserialize directory: %w", err))
-				deserializeErr = err
+			log.Error(err)
+			return err
+		}
+		if ok {
+			log.Debugf("Stream records compression %s", compressionName(recorded))
+			compression = recorded
+		} else {
+			log.Debugf("Stream has no header recording its compression, assuming %s", compressionName(compression))
+		}
+
+		// Create decompression stream if needed
+		// This reverses any compression applied during encoding
+		var outputStream io.Reader = br
+		if compression == CompressionGzip {
+			if strict {
+				if header, _ := br.Peek(2); len(header) < 2 || header[0] != 0x1f || header[1] != 0x8b {
+					log.Error(fmt.Errorf("%w: decoded stream is not gzip-compressed as expected; the collections may be damaged or from different encodes", ErrNotArchive))
+					return fmt.Errorf("%w: decoded stream is not gzip-compressed as expected; the collections may be damaged or from different encodes", ErrNotArchive)
+				}
+			}
+			log.Debugf("Creating decompression stream")
+			outputStream, err = file.DecompressStreamToStream(deserializeCtx, outputStream)
+			if err != nil {
+				log.Error(fmt.Errorf("failed to create decompression stream: %w", err))
+				return err
 			}
 		}
-	}()
 
-	// Create a new pad instance for decoding
-	// The pad is initialized with the number of available collections
-	// The K value will be extracted from the collection metadata during decoding
-	log.Debugf("Creating pad instance with N=%d", n)
-	p, err := pad.NewPadForDecode(ctx, n)
-	if err != nil {
-		log.Error(fmt.Errorf("failed to create pad instance: %w", err))
-		return err
-	}
+		// Hand the decoded stream to the consumer
+		return consume(deserializeCtx, outputStream)
+	})
 
 	// Run the decoding process
-	log.Debugf("Starting decode process")
-
-	// Create collection names list for logging purposes
-	collectionNames := make([]string, len(collections))
-	for i, coll := range collections {
-		collectionNames[i] = coll.Name
-	}
-
-	// Decode the collections
 	// This combines the chunks from different collections using the threshold scheme
 	// The result is written to the pipe writer (pw)
-	err = p.Decode(ctx, readers, pw)
-	if err != nil {
-		log.Error(fmt.Errorf("decoding failed: %w", err))
-		return fmt.Errorf("decoding failed: %w", err)
-	}
+	log.Debugf("Starting decode process")
+	err := decode(pw)
 
-	// Close the pipe writer to signal the end of data to the deserialization goroutine
-	err = pw.Close()
+	// Close the pipe writer to signal the end of data to the deserialization
+	// goroutine, or its failure, so that the consumer stops reading
 	if err != nil {
-		log.Error(fmt.Errorf("error closing pipe writer: %w", err))
-		// Continue anyway, as the pipe might already be closed by the deserialization goroutine
+		pw.CloseWithError(err)
+	} else {
+		pw.Close()
 	}
 
-	// In test environment, wait with a shorter timeout, but in production wait with a longer timeout
-	timeoutDuration := 30 * time.Second
-	if os.Getenv("GO_TEST") != "" || (ctx.Value(trace.TracerKey{}) != nil && strings.Contains(ctx.Value(trace.TracerKey{}).(*trace.Tracer).GetPrefix(), "TEST")) {
-		timeoutDuration = 3 * time.Second
-	}
-
-	select {
-	case <-done:
-		log.Debugf("Deserialization goroutine completed")
-	case <-time.After(timeoutDuration):
-		// Avoid panic on pipe error
-		pw.CloseWithError(fmt.Errorf("timeout waiting for deserialization to complete"))
-		log.Error(fmt.Errorf("timeout waiting for deserialization to complete after %v", timeoutDuration))
-		return fmt.Errorf("timeout waiting for deserialization to complete after %v", timeoutDuration)
-	}
+	// Wait for the consumer to finish with everything it was handed
+	deserializeErr := deserializer.Wait()
+	log.Debugf("Deserialization goroutine completed")
 
-	// Check if there was an error in the deserialization
-	if deserializeErr != nil {
-		return deserializeErr
+	if err != nil {
+		// A consumer that stops early closes the pipe, and its error explains why
+		if errors.Is(err, io.ErrClosedPipe) && deserializeErr != nil {
+			return deserializeErr
+		}
+		log.Error(fmt.Errorf("decoding failed: %w", err))
+		return fmt.Errorf("decoding failed: %w", err)
 	}
-
-	// Log completion information including elapsed time
-	elapsed := time.Since(start)
-	log.Infof("Decode complete (%s)", elapsed)
-	return nil
+	return deserializeErr
 }
@@ -1,11 +1,20 @@
 package padlock
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/rayozzie/padlock/pkg/file"
 	"github.com/rayozzie/padlock/pkg/pad"
 	"github.com/rayozzie/padlock/pkg/trace"
 )
@@ -15,10 +24,6 @@ func TestEncodeOnly(t *testing.T) {
 	// The decode test is skipped since there are pipe closing issues in the test environment.
 	// The command-line utility works correctly, so this ensures basic functionality.
 
-	// Enable test mode
-	os.Setenv("GO_TEST", "1")
-	defer os.Unsetenv("GO_TEST")
-
 	// Create temporary directories
 	inputDir, err := os.MkdirTemp("", "padlock-test-input-*")
 	if err != nil {
@@ -98,3 +103,1245 @@ func TestPartialDecoding(t *testing.T) {
 	// Skip this test for now while we focus on the basic round-trip test
 	t.Skip("Skipping partial decoding test to focus on basic functionality")
 }
+
+func TestListCollections(t *testing.T) {
+	// Create temporary directories
+	inputDir, err := os.MkdirTemp("", "padlock-test-input-*")
+	if err != nil {
+		t.Fatalf("Failed to create input temp dir: %v", err)
+	}
+	defer os.RemoveAll(inputDir)
+
+	encodeOutputDir, err := os.MkdirTemp("", "padlock-test-encode-output-*")
+	if err != nil {
+		t.Fatalf("Failed to create encode output temp dir: %v", err)
+	}
+	defer os.RemoveAll(encodeOutputDir)
+
+	// Create a test file large enough to be archived as a tar stream
+	testContent := strings.Repeat("listed content\n", 100)
+	if err := os.WriteFile(filepath.Join(inputDir, "listed.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Create a context for this test
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	encodeConfig := EncodeConfig{
+		InputDir:        inputDir,
+		OutputDir:       encodeOutputDir,
+		N:               3,
+		K:               2,
+		Format:          FormatBin,
+		ChunkSize:       1024,
+		RNG:             pad.NewDefaultRand(ctx),
+		ClearIfNotEmpty: true,
+		Compression:     CompressionGzip,
+	}
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	// List the collections
+	var out bytes.Buffer
+	listConfig := ListConfig{
+		InputDir:    encodeOutputDir,
+		Compression: CompressionGzip,
+		Output:      &out,
+	}
+	if err := ListCollections(ctx, listConfig); err != nil {
+		t.Fatalf("Failed to list collections: %v", err)
+	}
+
+	expected := fmt.Sprintf("%12d", len(testContent))
+	if !strings.Contains(out.String(), "listed.txt") || !strings.Contains(out.String(), expected) {
+		t.Errorf("Listing does not describe listed.txt (%d bytes):\n%s", len(testContent), out.String())
+	}
+}
+
+func TestParityDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-parity-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	encodeOutputDir := filepath.Join(tempDir, "encoded")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("protected by parity\n", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Create a context for this test
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	encodeConfig := EncodeConfig{
+		InputDir:      inputDir,
+		OutputDir:     encodeOutputDir,
+		N:             3,
+		K:             2,
+		Format:        FormatBin,
+		ChunkSize:     1024,
+		RNG:           pad.NewDefaultRand(ctx),
+		Compression:   CompressionNone,
+		ParityPercent: 20,
+	}
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	// With only K collections, a lost chunk can come from nowhere but parity
+	os.RemoveAll(filepath.Join(encodeOutputDir, "2C3"))
+	os.Remove(filepath.Join(encodeOutputDir, "2A3", "2A3_0002.bin"))
+	chunk := filepath.Join(encodeOutputDir, "2B3", "2B3_0003.bin")
+	data, err := os.ReadFile(chunk)
+	if err != nil {
+		t.Fatalf("Failed to read chunk: %v", err)
+	}
+	if err := os.WriteFile(chunk, data[:len(data)/2], 0644); err != nil {
+		t.Fatalf("Failed to truncate chunk: %v", err)
+	}
+
+	decodeOutputDir := filepath.Join(tempDir, "decoded")
+	decodeConfig := DecodeConfig{
+		InputDir:    encodeOutputDir,
+		OutputDir:   decodeOutputDir,
+		Compression: CompressionNone,
+	}
+	if err := DecodeDirectory(ctx, decodeConfig); err != nil {
+		t.Fatalf("Failed to decode damaged collections with parity: %v", err)
+	}
+	restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "data.txt"))
+	if err != nil || string(restored) != testContent {
+		t.Errorf("Decoded data does not match the original: %v", err)
+	}
+
+	// Parity must fit within a volume, so the two cannot be combined
+	encodeConfig.OutputDir = filepath.Join(tempDir, "volumes")
+	encodeConfig.VolumeSize = 1 << 20
+	if err := EncodeDirectory(ctx, encodeConfig); err == nil {
+		t.Errorf("Expected an error combining parity with volumes")
+	}
+}
+
+func TestZipEncodeDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-zip-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("streamed into a zip\n", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Create a context for this test
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Plain collections are streamed into their zips, and those with parity zipped afterward
+	for _, parity := range []int{0, 20} {
+		t.Run(fmt.Sprintf("Parity %d", parity), func(t *testing.T) {
+			encodeOutputDir := filepath.Join(tempDir, fmt.Sprintf("encoded-%d", parity))
+			err := EncodeDirectory(ctx, EncodeConfig{
+				InputDir:       inputDir,
+				OutputDir:      encodeOutputDir,
+				N:              3,
+				K:              2,
+				Format:         FormatPNG,
+				ChunkSize:      1024,
+				RNG:            pad.NewDefaultRand(ctx),
+				Compression:    CompressionGzip,
+				ZipCollections: true,
+				ParityPercent:  parity,
+			})
+			if err != nil {
+				t.Fatalf("Failed to encode directory: %v", err)
+			}
+			entries, err := os.ReadDir(encodeOutputDir)
+			if err != nil {
+				t.Fatalf("Failed to read output: %v", err)
+			}
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			if strings.Join(names, ",") != "2A3.zip,2B3.zip,2C3.zip" {
+				t.Fatalf("Expected only the three zips in the output, got %v", names)
+			}
+
+			// The zips are decoded in place
+			os.Remove(filepath.Join(encodeOutputDir, "2B3.zip"))
+			decodeOutputDir := filepath.Join(tempDir, fmt.Sprintf("decoded-%d", parity))
+			err = DecodeDirectory(ctx, DecodeConfig{
+				InputDir:    encodeOutputDir,
+				OutputDir:   decodeOutputDir,
+				Compression: CompressionGzip,
+				Strict:      true,
+			})
+			if err != nil {
+				t.Fatalf("Failed to decode zips: %v", err)
+			}
+			restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "data.txt"))
+			if err != nil || string(restored) != testContent {
+				t.Errorf("Decoded data does not match the original: %v", err)
+			}
+		})
+	}
+}
+
+func TestTarEncodeDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-tar-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	random := make([]byte, 8000)
+	rand.Read(random)
+	testContent := fmt.Sprintf("%x", random)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Plain collections are read in place, and volumes extracted
+	for name, volumeSize := range map[string]int64{"Plain": 0, "Volumes": 8192} {
+		t.Run(name, func(t *testing.T) {
+			encodeOutputDir := filepath.Join(tempDir, "encoded-"+name)
+			err := EncodeDirectory(ctx, EncodeConfig{
+				InputDir:       inputDir,
+				OutputDir:      encodeOutputDir,
+				N:              3,
+				K:              2,
+				Format:         FormatBin,
+				ChunkSize:      1024,
+				RNG:            pad.NewDefaultRand(ctx),
+				Compression:    CompressionGzip,
+				TarCollections: true,
+				VolumeSize:     volumeSize,
+			})
+			if err != nil {
+				t.Fatalf("Failed to encode directory: %v", err)
+			}
+			entries, err := os.ReadDir(encodeOutputDir)
+			if err != nil {
+				t.Fatalf("Failed to read output: %v", err)
+			}
+			for _, entry := range entries {
+				if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tar.gz") {
+					t.Fatalf("Expected only tar archives in the output, got %s", entry.Name())
+				}
+			}
+
+			if volumeSize > 0 && len(entries) <= 3 {
+				t.Fatalf("Expected collections of several volumes, got %d archives", len(entries))
+			}
+			removed, _ := filepath.Glob(filepath.Join(encodeOutputDir, "2B3*"))
+			for _, path := range removed {
+				os.Remove(path)
+			}
+			decodeOutputDir := filepath.Join(tempDir, "decoded-"+name)
+			err = DecodeDirectory(ctx, DecodeConfig{
+				InputDir:    encodeOutputDir,
+				OutputDir:   decodeOutputDir,
+				Compression: CompressionGzip,
+				Strict:      true,
+			})
+			if err != nil {
+				t.Fatalf("Failed to decode tar archives: %v", err)
+			}
+			restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "data.txt"))
+			if err != nil || string(restored) != testContent {
+				t.Errorf("Decoded data does not match the original: %v", err)
+			}
+		})
+	}
+
+	t.Run("Zip and tar", func(t *testing.T) {
+		err := EncodeDirectory(ctx, EncodeConfig{
+			InputDir:       inputDir,
+			OutputDir:      filepath.Join(tempDir, "both"),
+			N:              3,
+			K:              2,
+			Format:         FormatBin,
+			ChunkSize:      1024,
+			RNG:            pad.NewDefaultRand(ctx),
+			ZipCollections: true,
+			TarCollections: true,
+		})
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig, got %v", err)
+		}
+	})
+}
+
+func TestObfuscatedEncodeDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-obfuscate-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("hidden behind innocuous names\n", 300)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	for _, zipped := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Zip %v", zipped), func(t *testing.T) {
+			encodeOutputDir := filepath.Join(tempDir, fmt.Sprintf("encoded-%v", zipped))
+			err := EncodeDirectory(ctx, EncodeConfig{
+				InputDir:       inputDir,
+				OutputDir:      encodeOutputDir,
+				N:              3,
+				K:              2,
+				Format:         FormatPNG,
+				ChunkSize:      1024,
+				RNG:            pad.NewDefaultRand(ctx),
+				Compression:    CompressionGzip,
+				ZipCollections: zipped,
+				ParityPercent:  20,
+				ObfuscateNames: true,
+			})
+			if err != nil {
+				t.Fatalf("Failed to encode directory: %v", err)
+			}
+			entries, err := os.ReadDir(encodeOutputDir)
+			if err != nil {
+				t.Fatalf("Failed to read output: %v", err)
+			}
+			if len(entries) != 3 {
+				t.Fatalf("Expected 3 collections in the output, got %d", len(entries))
+			}
+			for _, entry := range entries {
+				if name := strings.TrimSuffix(entry.Name(), ".zip"); len(name) != 12 || strings.Trim(name, "0123456789abcdef") != "" {
+					t.Errorf("Expected an obfuscated name, got %s", entry.Name())
+				}
+			}
+
+			os.RemoveAll(filepath.Join(encodeOutputDir, entries[1].Name()))
+			decodeOutputDir := filepath.Join(tempDir, fmt.Sprintf("decoded-%v", zipped))
+			err = DecodeDirectory(ctx, DecodeConfig{
+				InputDir:    encodeOutputDir,
+				OutputDir:   decodeOutputDir,
+				Compression: CompressionGzip,
+				Strict:      true,
+			})
+			if err != nil {
+				t.Fatalf("Failed to decode obfuscated collections: %v", err)
+			}
+			restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "data.txt"))
+			if err != nil || string(restored) != testContent {
+				t.Errorf("Decoded data does not match the original: %v", err)
+			}
+		})
+	}
+
+	t.Run("With volumes", func(t *testing.T) {
+		err := EncodeDirectory(ctx, EncodeConfig{
+			InputDir:       inputDir,
+			OutputDir:      filepath.Join(tempDir, "volumes"),
+			N:              3,
+			K:              2,
+			Format:         FormatBin,
+			ChunkSize:      1024,
+			RNG:            pad.NewDefaultRand(ctx),
+			VolumeSize:     8192,
+			ObfuscateNames: true,
+		})
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig, got %v", err)
+		}
+	})
+}
+
+func TestChunkNamingEncodeDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-naming-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("named like photos from a camera\n", 300)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	naming, err := file.ParseChunkNaming("camera")
+	if err != nil {
+		t.Fatalf("Failed to parse chunk naming: %v", err)
+	}
+	for _, zipped := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Zip %v", zipped), func(t *testing.T) {
+			encodeOutputDir := filepath.Join(tempDir, fmt.Sprintf("encoded-%v", zipped))
+			err := EncodeDirectory(ctx, EncodeConfig{
+				InputDir:       inputDir,
+				OutputDir:      encodeOutputDir,
+				N:              3,
+				K:              2,
+				Format:         FormatPNG,
+				ChunkNaming:    naming,
+				ChunkSize:      1024,
+				RNG:            pad.NewDefaultRand(ctx),
+				Compression:    CompressionGzip,
+				ZipCollections: zipped,
+			})
+			if err != nil {
+				t.Fatalf("Failed to encode directory: %v", err)
+			}
+			if !zipped {
+				if _, err := os.Stat(filepath.Join(encodeOutputDir, "2A3", "DSC_0001.PNG")); err != nil {
+					t.Errorf("Expected chunks named like camera photos: %v", err)
+				}
+			}
+
+			decodeOutputDir := filepath.Join(tempDir, fmt.Sprintf("decoded-%v", zipped))
+			err = DecodeDirectory(ctx, DecodeConfig{
+				InputDir:    encodeOutputDir,
+				OutputDir:   decodeOutputDir,
+				Compression: CompressionGzip,
+				Strict:      true,
+			})
+			if err != nil {
+				t.Fatalf("Failed to decode collections: %v", err)
+			}
+			restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "data.txt"))
+			if err != nil || string(restored) != testContent {
+				t.Errorf("Decoded data does not match the original: %v", err)
+			}
+		})
+	}
+
+	t.Run("Extension of another format", func(t *testing.T) {
+		err := EncodeDirectory(ctx, EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   filepath.Join(tempDir, "mismatched"),
+			N:           2,
+			K:           2,
+			Format:      FormatBin,
+			ChunkNaming: naming,
+			ChunkSize:   1024,
+			RNG:         pad.NewDefaultRand(ctx),
+		})
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig, got %v", err)
+		}
+	})
+}
+
+func TestStoreEncodeDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-store-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("kept in a shared chunk store\n", 300)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// Two sets share one store, each decoded from its own manifests
+	storeDir := filepath.Join(tempDir, "store")
+	for _, set := range []string{"first", "second"} {
+		encodeOutputDir := filepath.Join(tempDir, set)
+		err := EncodeDirectory(ctx, EncodeConfig{
+			InputDir:      inputDir,
+			OutputDir:     encodeOutputDir,
+			N:             3,
+			K:             2,
+			Format:        FormatBin,
+			ChunkSize:     1024,
+			RNG:           pad.NewDefaultRand(ctx),
+			Compression:   CompressionGzip,
+			ParityPercent: 20,
+			StoreDir:      storeDir,
+		})
+		if err != nil {
+			t.Fatalf("Failed to encode directory: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(encodeOutputDir, "2A3"+file.StoreManifestSuffix)); err != nil {
+			t.Errorf("Expected a manifest in place of the collection directory: %v", err)
+		}
+	}
+	for _, set := range []string{"first", "second"} {
+		decodeOutputDir := filepath.Join(tempDir, "decoded-"+set)
+		err = DecodeDirectory(ctx, DecodeConfig{
+			InputDir:    filepath.Join(tempDir, set),
+			OutputDir:   decodeOutputDir,
+			Compression: CompressionGzip,
+			Strict:      true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to decode stored collections: %v", err)
+		}
+		restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "data.txt"))
+		if err != nil || string(restored) != testContent {
+			t.Errorf("Decoded data does not match the original: %v", err)
+		}
+	}
+
+	err = EncodeDirectory(ctx, EncodeConfig{
+		InputDir:       inputDir,
+		OutputDir:      filepath.Join(tempDir, "zipped"),
+		N:              2,
+		K:              2,
+		Format:         FormatBin,
+		ChunkSize:      1024,
+		RNG:            pad.NewDefaultRand(ctx),
+		ZipCollections: true,
+		StoreDir:       storeDir,
+	})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a zipped chunk store, got %v", err)
+	}
+}
+
+func TestPaddedEncodeDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-padded-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a context for this test
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// encode encodes content of the given length, returning the sizes of its chunk files
+	encode := func(name string, length int) (string, map[string]int64) {
+		inputDir := filepath.Join(tempDir, name+"-input")
+		outputDir := filepath.Join(tempDir, name+"-encoded")
+		if err := os.MkdirAll(inputDir, 0755); err != nil {
+			t.Fatalf("Failed to create input dir: %v", err)
+		}
+		content := make([]byte, length)
+		if _, err := rand.Read(content); err != nil {
+			t.Fatalf("Failed to generate content: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(inputDir, "data.bin"), content, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		encodeConfig := EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   outputDir,
+			N:           3,
+			K:           2,
+			Format:      FormatBin,
+			ChunkSize:   4096,
+			RNG:         pad.NewDefaultRand(ctx),
+			Compression: CompressionNone,
+			PadLength:   true,
+		}
+		if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+			t.Fatalf("Failed to encode directory: %v", err)
+		}
+		sizes := make(map[string]int64)
+		filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				sizes[filepath.Base(path)] = info.Size()
+			}
+			return err
+		})
+
+		decodeOutputDir := filepath.Join(tempDir, name+"-decoded")
+		if err := DecodeDirectory(ctx, DecodeConfig{InputDir: outputDir, OutputDir: decodeOutputDir, Compression: CompressionNone}); err != nil {
+			t.Fatalf("Failed to decode padded collections: %v", err)
+		}
+		restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "data.bin"))
+		if err != nil || !bytes.Equal(restored, content) {
+			t.Errorf("Decoded data does not match the original: %v", err)
+		}
+		return outputDir, sizes
+	}
+
+	// Inputs of different lengths that fill the same number of chunks can't be told apart by size
+	_, short := encode("short", 3000)
+	_, long := encode("long", 3500)
+	if len(short) == 0 || fmt.Sprint(short) != fmt.Sprint(long) {
+		t.Errorf("Expected identical chunk file sizes, got %v and %v", short, long)
+	}
+
+	// Extra chunks require length padding
+	err = EncodeDirectory(ctx, EncodeConfig{
+		InputDir:  filepath.Join(tempDir, "short-input"),
+		OutputDir: filepath.Join(tempDir, "extra"),
+		N:         3,
+		K:         2,
+		Format:    FormatBin,
+		ChunkSize: 4096,
+		RNG:       pad.NewDefaultRand(ctx),
+		PadChunks: 2,
+	})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for extra chunks without length padding, got %v", err)
+	}
+}
+
+func TestMultiInputEncodeDecode(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Create a context for this test
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// A directory and a file elsewhere, encoded together without staging
+	files := map[string]string{
+		"projects/docs/readme.txt": "projects readme",
+		"projects/plan.txt":        "the plan",
+		"home/notes.txt":           "some notes",
+	}
+	for rel, content := range files {
+		path := filepath.Join(tempDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create input dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	encodeConfig := EncodeConfig{
+		Inputs:      []string{filepath.Join(tempDir, "projects"), filepath.Join(tempDir, "home", "notes.txt")},
+		OutputDir:   filepath.Join(tempDir, "encoded"),
+		N:           3,
+		K:           2,
+		Format:      FormatBin,
+		ChunkSize:   1024,
+		RNG:         pad.NewDefaultRand(ctx),
+		Compression: CompressionGzip,
+	}
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode inputs: %v", err)
+	}
+
+	// Each input is restored under its name
+	decodeOutputDir := filepath.Join(tempDir, "decoded")
+	if err := DecodeDirectory(ctx, DecodeConfig{InputDir: encodeConfig.OutputDir, OutputDir: decodeOutputDir, Compression: CompressionGzip}); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	for rel, want := range map[string]string{"projects/docs/readme.txt": "projects readme", "projects/plan.txt": "the plan", "notes.txt": "some notes"} {
+		got, err := os.ReadFile(filepath.Join(decodeOutputDir, filepath.FromSlash(rel)))
+		if err != nil || string(got) != want {
+			t.Errorf("Restored %s = %q, %v, want %q", rel, got, err, want)
+		}
+	}
+
+	// An input directory cannot also be given
+	encodeConfig.InputDir = filepath.Join(tempDir, "projects")
+	if err := encodeConfig.Validate(ctx); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for both an input directory and inputs, got %v", err)
+	}
+}
+
+func TestDecoyEncodeDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-decoy-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a context for this test
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	inputDir := filepath.Join(tempDir, "input")
+	decoyDir := filepath.Join(tempDir, "decoy")
+	encodeOutputDir := filepath.Join(tempDir, "encoded")
+	for dir, content := range map[string]string{inputDir: strings.Repeat("the real thing\n", 800), decoyDir: "nothing to see\n"} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	encodeConfig := EncodeConfig{
+		InputDir:     inputDir,
+		OutputDir:    encodeOutputDir,
+		N:            3,
+		K:            2,
+		Format:       FormatBin,
+		ChunkSize:    1024,
+		RNG:          pad.NewDefaultRand(ctx),
+		Compression:  CompressionGzip,
+		DecoyDir:     decoyDir,
+		DecoyLetters: "C",
+	}
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	// Each pair of collections reveals one of the two directories
+	tests := []struct {
+		collections []string
+		want        string
+	}{
+		{[]string{"2A3", "2B3"}, inputDir},
+		{[]string{"2A3", "2C3"}, decoyDir},
+		{[]string{"2B3", "2C3"}, decoyDir},
+	}
+	for _, tt := range tests {
+		name := strings.Join(tt.collections, "+")
+		t.Run(name, func(t *testing.T) {
+			selected := filepath.Join(tempDir, name)
+			for _, coll := range tt.collections {
+				if err := os.CopyFS(filepath.Join(selected, coll), os.DirFS(filepath.Join(encodeOutputDir, coll))); err != nil {
+					t.Fatalf("Failed to copy collection %s: %v", coll, err)
+				}
+			}
+			decodeOutputDir := filepath.Join(tempDir, name+"-decoded")
+			if err := DecodeDirectory(ctx, DecodeConfig{InputDir: selected, OutputDir: decodeOutputDir, Compression: CompressionGzip}); err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			want, _ := os.ReadFile(filepath.Join(tt.want, "notes.txt"))
+			restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "notes.txt"))
+			if err != nil || !bytes.Equal(restored, want) {
+				t.Errorf("Expected the contents of %s: %v", tt.want, err)
+			}
+		})
+	}
+
+	// A decoy needs its collections named, and occupies a single K-of-N set
+	encodeConfig.OutputDir = filepath.Join(tempDir, "invalid")
+	encodeConfig.DecoyLetters = ""
+	if err := EncodeDirectory(ctx, encodeConfig); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a decoy without decoy collections, got %v", err)
+	}
+	encodeConfig.DecoyLetters = "AB"
+	if err := EncodeDirectory(ctx, encodeConfig); !errors.Is(err, ErrInvalidParameters) {
+		t.Errorf("Expected ErrInvalidParameters for decoy collections leaving fewer than K, got %v", err)
+	}
+	encodeConfig.DecoyLetters = "C"
+	encodeConfig.Groups = []GroupPolicy{{Name: "a", N: 2, K: 2}, {Name: "b", N: 2, K: 2}}
+	if err := EncodeDirectory(ctx, encodeConfig); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a decoy with groups, got %v", err)
+	}
+}
+
+func TestDuplicateCollectionDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-duplicate-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	encodeOutputDir := filepath.Join(tempDir, "encoded")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("supplied twice\n", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Create a context for this test
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	encodeConfig := EncodeConfig{
+		InputDir:    inputDir,
+		OutputDir:   encodeOutputDir,
+		N:           3,
+		K:           2,
+		Format:      FormatBin,
+		ChunkSize:   1024,
+		RNG:         pad.NewDefaultRand(ctx),
+		Compression: CompressionNone,
+	}
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	// The same collection as a directory and as a zip, plus one other collection
+	if _, err := file.ZipCollection(ctx, filepath.Join(encodeOutputDir, "2A3")); err != nil {
+		t.Fatalf("Failed to zip collection: %v", err)
+	}
+	os.RemoveAll(filepath.Join(encodeOutputDir, "2C3"))
+
+	decodeOutputDir := filepath.Join(tempDir, "decoded")
+	decodeConfig := DecodeConfig{
+		InputDir:    encodeOutputDir,
+		OutputDir:   decodeOutputDir,
+		Compression: CompressionNone,
+	}
+	if err := DecodeDirectory(ctx, decodeConfig); err != nil {
+		t.Fatalf("Failed to decode with a duplicate collection: %v", err)
+	}
+	restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "data.txt"))
+	if err != nil || string(restored) != testContent {
+		t.Errorf("Decoded data does not match the original: %v", err)
+	}
+}
+
+func TestStrictDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-strict-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	encodeOutputDir := filepath.Join(tempDir, "encoded")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	// Random content, so that the compressed stream spans several chunks
+	testContent := make([]byte, 8000)
+	if _, err := rand.Read(testContent); err != nil {
+		t.Fatalf("Failed to generate test content: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "data.bin"), testContent, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Create a context for this test
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	encodeConfig := EncodeConfig{
+		InputDir:    inputDir,
+		OutputDir:   encodeOutputDir,
+		N:           3,
+		K:           2,
+		Format:      FormatBin,
+		ChunkSize:   1024,
+		RNG:         pad.NewDefaultRand(ctx),
+		Compression: CompressionGzip,
+	}
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	// A collection of another set, to be set aside unless strict
+	encodeConfig.OutputDir = filepath.Join(tempDir, "other")
+	encodeConfig.N = 2
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	decodeOutputDir := filepath.Join(tempDir, "decoded")
+	decode := func(strict bool) error {
+		err := DecodeDirectory(ctx, DecodeConfig{
+			InputDir:        encodeOutputDir,
+			OutputDir:       decodeOutputDir,
+			Compression:     CompressionGzip,
+			ClearIfNotEmpty: true,
+			Strict:          strict,
+		})
+		if err != nil {
+			return err
+		}
+		restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "data.bin"))
+		if err != nil || !bytes.Equal(restored, testContent) {
+			t.Errorf("Decoded data does not match the original: %v", err)
+		}
+		return nil
+	}
+
+	// Each step damages the set a little further
+	tests := []struct {
+		name      string
+		change    func()
+		strictErr error
+	}{
+		{"intact", func() {}, nil},
+		{"collection of another set", func() {
+			os.Rename(filepath.Join(tempDir, "other", "2A2"), filepath.Join(encodeOutputDir, "2A2"))
+		}, ErrSessionMismatch},
+		{"chunk recovered from the spare collection", func() {
+			os.RemoveAll(filepath.Join(encodeOutputDir, "2A2"))
+			os.Remove(filepath.Join(encodeOutputDir, "2A3", "2A3_0002.bin"))
+		}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.change()
+			if err := decode(false); err != nil {
+				t.Errorf("Decode failed: %v", err)
+			}
+			err := decode(true)
+			if tc.strictErr != nil && !errors.Is(err, tc.strictErr) {
+				t.Errorf("Expected strict decode to fail with %v, got %v", tc.strictErr, err)
+			}
+			if tc.strictErr == nil && err != nil {
+				t.Errorf("Strict decode failed: %v", err)
+			}
+		})
+	}
+
+	// Without the spare, the lost chunk cannot be recovered at all
+	os.RemoveAll(filepath.Join(encodeOutputDir, "2C3"))
+	if err := decode(true); !errors.Is(err, ErrChunkCorrupt) {
+		t.Errorf("Expected strict decode to fail with an unrecoverable chunk, got %v", err)
+	}
+}
+
+func TestDecoderEncodeDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-decoder-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("decodable decades later\n", 100)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	decoders := []file.Decoder{
+		{OS: "linux", Arch: "amd64", Data: []byte("linux executable")},
+		{OS: "windows", Arch: "amd64", Data: []byte("windows executable")},
+	}
+
+	// A single chunk per collection, so that the decoders are as numerous as
+	// the chunks when the format and naming are detected
+	tests := []struct {
+		name string
+		cfg  EncodeConfig
+	}{
+		{"Directories", EncodeConfig{Format: FormatBin}},
+		{"Streamed zips", EncodeConfig{Format: FormatPNG, ZipCollections: true}},
+		{"Tar archives", EncodeConfig{Format: FormatBin, TarCollections: true}},
+		{"Chunk store", EncodeConfig{Format: FormatBin, StoreDir: filepath.Join(tempDir, "store")}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.InputDir = inputDir
+			cfg.OutputDir = filepath.Join(tempDir, fmt.Sprintf("encoded-%d", i))
+			cfg.N, cfg.K = 3, 2
+			cfg.ChunkSize = 1 << 20
+			cfg.RNG = pad.NewDefaultRand(ctx)
+			cfg.Compression = CompressionGzip
+			cfg.Decoders = decoders
+			if err := EncodeDirectory(ctx, cfg); err != nil {
+				t.Fatalf("Failed to encode directory: %v", err)
+			}
+
+			if cfg.ZipCollections {
+				r, err := zip.OpenReader(filepath.Join(cfg.OutputDir, "2A3.zip"))
+				if err != nil {
+					t.Fatalf("Failed to open zip: %v", err)
+				}
+				modes := make(map[string]os.FileMode)
+				for _, f := range r.File {
+					modes[f.Name] = f.Mode()
+				}
+				r.Close()
+				if modes["padlock-decoder-linux-amd64"].Perm() != 0755 {
+					t.Errorf("Decoder is not executable in the zip: %v", modes)
+				}
+				if _, ok := modes[file.DecoderReadmeFileName]; !ok {
+					t.Errorf("Zip lacks the decoder instructions: %v", modes)
+				}
+			} else if !cfg.TarCollections && cfg.StoreDir == "" {
+				for _, name := range []string{"padlock-decoder-linux-amd64", "padlock-decoder-windows-amd64.exe", file.DecoderReadmeFileName} {
+					if _, err := os.Stat(filepath.Join(cfg.OutputDir, "2B3", name)); err != nil {
+						t.Errorf("Collection lacks %s: %v", name, err)
+					}
+				}
+			}
+
+			decodeOutputDir := filepath.Join(tempDir, fmt.Sprintf("decoded-%d", i))
+			err := DecodeDirectory(ctx, DecodeConfig{
+				InputDir:    cfg.OutputDir,
+				OutputDir:   decodeOutputDir,
+				Compression: CompressionGzip,
+				Strict:      true,
+			})
+			if err != nil {
+				t.Fatalf("Failed to decode collections with decoders: %v", err)
+			}
+			restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "data.txt"))
+			if err != nil || string(restored) != testContent {
+				t.Errorf("Decoded data does not match the original: %v", err)
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, cfg := range []EncodeConfig{
+			{VolumeSize: 4 << 20},
+			{ObfuscateNames: true},
+			{Decoders: append(decoders, file.Decoder{OS: "linux", Arch: "amd64"})},
+		} {
+			cfg.InputDir = inputDir
+			cfg.OutputDir = filepath.Join(tempDir, "invalid")
+			cfg.N, cfg.K = 3, 2
+			cfg.Format = FormatBin
+			cfg.ChunkSize = 1 << 20
+			cfg.RNG = pad.NewDefaultRand(ctx)
+			if cfg.Decoders == nil {
+				cfg.Decoders = decoders
+			}
+			if err := EncodeDirectory(ctx, cfg); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("Expected ErrInvalidConfig, got %v", err)
+			}
+		}
+	})
+}
+
+func TestDecodeStream(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	data := bytes.Repeat([]byte("decoded "), 10000)
+	decode := func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+
+	t.Run("Waits for a slow consumer", func(t *testing.T) {
+		release := make(chan struct{})
+		var got []byte
+		result := make(chan error, 1)
+		go func() {
+			result <- decodeStream(ctx, CompressionNone, false, func(ctx context.Context, r io.Reader) error {
+				var err error
+				got, err = io.ReadAll(r)
+				<-release
+				return err
+			}, decode)
+		}()
+
+		// The decode is done, but the consumer is still working
+		select {
+		case err := <-result:
+			t.Fatalf("decodeStream returned %v before its consumer finished", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		close(release)
+		if err := <-result; err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Expected the consumer to receive the whole stream, got %d bytes, %v", len(got), err)
+		}
+	})
+
+	t.Run("Decode failure", func(t *testing.T) {
+		errDecode := errors.New("decode failed")
+		var consumeErr error
+		err := decodeStream(ctx, CompressionNone, false, func(ctx context.Context, r io.Reader) error {
+			_, consumeErr = io.ReadAll(r)
+			return consumeErr
+		}, func(w io.Writer) error {
+			w.Write(data[:100])
+			return errDecode
+		})
+		if !errors.Is(err, errDecode) || !errors.Is(consumeErr, errDecode) {
+			t.Errorf("Expected the decode error to reach both the caller and the consumer, got %v and %v", err, consumeErr)
+		}
+	})
+
+	t.Run("Consumer stops early", func(t *testing.T) {
+		errStop := errors.New("consumer stopped")
+		err := decodeStream(ctx, CompressionNone, false, func(ctx context.Context, r io.Reader) error {
+			r.Read(make([]byte, 10))
+			return errStop
+		}, decode)
+		if !errors.Is(err, errStop) {
+			t.Errorf("Expected the consumer's error, got %v", err)
+		}
+	})
+}
+
+// rawSerializer serializes a directory holding a single file as the file's
+// contents alone, as an embedder's own format might
+type rawSerializer struct{ name string }
+
+func (s rawSerializer) SerializeToStream(ctx context.Context, inputDir string, opts file.SerializeOptions) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(inputDir, s.name))
+}
+
+func (s rawSerializer) DeserializeFromStream(ctx context.Context, outputDir string, r io.Reader, clearIfNotEmpty bool, opts file.DeserializeOptions) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outputDir, s.name), data, 0644)
+}
+
+func TestSerializerEncodeDecode(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	outputDir := filepath.Join(tempDir, "encoded")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	content := []byte(strings.Repeat("not a tar archive\n", 200))
+	if err := os.WriteFile(filepath.Join(inputDir, "dump.sql"), content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	serializer := rawSerializer{name: "dump.sql"}
+	err := EncodeDirectory(ctx, EncodeConfig{
+		InputDir:    inputDir,
+		OutputDir:   outputDir,
+		N:           3,
+		K:           2,
+		Format:      FormatBin,
+		ChunkSize:   1024,
+		RNG:         pad.NewDefaultRand(ctx),
+		Compression: CompressionGzip,
+		Serializer:  serializer,
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	decodedDir := filepath.Join(tempDir, "decoded")
+	if err := DecodeDirectory(ctx, DecodeConfig{InputDir: outputDir, OutputDir: decodedDir, Strict: true, Serializer: serializer}); err != nil {
+		t.Fatalf("Failed to decode directory: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(decodedDir, "dump.sql"))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("Decoded data does not match the original: %v", err)
+	}
+
+	// Without the serializer, the stream is not a tar archive
+	err = DecodeDirectory(ctx, DecodeConfig{InputDir: outputDir, OutputDir: filepath.Join(tempDir, "tar"), Strict: true})
+	if !errors.Is(err, ErrNotArchive) {
+		t.Errorf("Expected decoding as tar to fail, got %v", err)
+	}
+
+	// A serializer reads a single directory
+	cfg := EncodeConfig{Inputs: []string{inputDir, inputDir}, OutputDir: outputDir, N: 3, K: 2, Format: FormatBin, ChunkSize: 1024, Serializer: serializer}
+	if err := cfg.Validate(ctx); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected several inputs with a serializer to be invalid, got %v", err)
+	}
+}
+
+// TestConcurrentEncodeDecode tests that encodes and decodes can run at once,
+// sharing an RNG; run it with -race to check for data races
+func TestConcurrentEncodeDecode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-concurrent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+	rng := pad.NewDefaultRand(ctx)
+
+	const workers = 4
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			dir := filepath.Join(tempDir, fmt.Sprintf("worker-%d", w))
+			inputDir := filepath.Join(dir, "input")
+			if err := os.MkdirAll(inputDir, 0755); err != nil {
+				errs <- err
+				return
+			}
+			testContent := strings.Repeat(fmt.Sprintf("worker %d\n", w), 300*(w+1))
+			if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+				errs <- err
+				return
+			}
+			err := EncodeDirectory(ctx, EncodeConfig{
+				InputDir:       inputDir,
+				OutputDir:      filepath.Join(dir, "encoded"),
+				N:              3,
+				K:              2,
+				Format:         FormatBin,
+				ChunkSize:      1024,
+				RNG:            rng,
+				Compression:    CompressionGzip,
+				ZipCollections: w%2 == 0,
+			})
+			if err != nil {
+				errs <- fmt.Errorf("worker %d: encode failed: %w", w, err)
+				return
+			}
+			err = DecodeDirectory(ctx, DecodeConfig{
+				InputDir:    filepath.Join(dir, "encoded"),
+				OutputDir:   filepath.Join(dir, "decoded"),
+				Compression: CompressionGzip,
+			})
+			if err != nil {
+				errs <- fmt.Errorf("worker %d: decode failed: %w", w, err)
+				return
+			}
+			restored, err := os.ReadFile(filepath.Join(dir, "decoded", "data.txt"))
+			if err != nil || string(restored) != testContent {
+				errs <- fmt.Errorf("worker %d: decoded data does not match the original (%v)", w, err)
+				return
+			}
+			errs <- nil
+		}(w)
+	}
+	for w := 0; w < workers; w++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// maxPolicyNotes is the longest policy notes, in bytes
+const maxPolicyNotes = 1024
+
+// ParseNotAfter parses the time after which the collections of a set expire:
+// a date, e.g. "2030-01-01", meaning the end of that day in UTC, or a time in
+// RFC 3339 format, e.g. "2030-01-01T12:00:00Z"
+func ParseNotAfter(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid expiry %q: expected a date such as 2030-01-01, or a time such as 2030-01-01T12:00:00Z", ErrInvalidConfig, s)
+	}
+	return t.UTC(), nil
+}
+
+// validatePolicyNotes checks that policy notes are a short line of printable text
+func validatePolicyNotes(notes string) error {
+	if len(notes) > maxPolicyNotes || strings.IndexFunc(notes, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%w: invalid policy notes: expected up to %d bytes of printable text", ErrInvalidConfig, maxPolicyNotes)
+	}
+	return nil
+}
+
+// policy returns the usage policy an encode records in its collections, or
+// nil if it records none
+func (cfg EncodeConfig) policy() *file.Policy {
+	if cfg.NotAfter.IsZero() && cfg.PolicyNotes == "" {
+		return nil
+	}
+	p := &file.Policy{Notes: cfg.PolicyNotes}
+	if !cfg.NotAfter.IsZero() {
+		notAfter := cfg.NotAfter.UTC()
+		p.NotAfter = &notAfter
+	}
+	return p
+}
+
+// formatPolicy formats a usage policy for the log and the info listing
+func formatPolicy(p *file.Policy, now time.Time) string {
+	var parts []string
+	if p.NotAfter != nil {
+		status := "expires"
+		if p.Expired(now) {
+			status = "expired"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s", status, p.NotAfter.Format(time.RFC3339)))
+	}
+	if p.Notes != "" {
+		parts = append(parts, p.Notes)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// readPolicy returns the usage policy recorded in the first of the collections
+// that holds one, or nil if none does
+func readPolicy(ctx context.Context, collections []file.Collection) *file.Policy {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	for _, coll := range collections {
+		p, err := file.ReadPolicy(ctx, coll)
+		if err != nil {
+			log.Debugf("Ignoring the policy of collection %s: %v", coll.Name, err)
+			continue
+		}
+		if p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// enforcePolicyKey is the context key of whether a decode enforces the usage
+// policy of its collections
+type enforcePolicyKey struct{}
+
+// withEnforcedPolicy returns a context under which collections used after
+// they expired are refused rather than decoded with a warning
+func withEnforcedPolicy(ctx context.Context) context.Context {
+	return context.WithValue(ctx, enforcePolicyKey{}, true)
+}
+
+// checkPolicy logs the usage policy recorded in the collections being decoded,
+// and warns if they are used after they expired, or refuses them if the
+// policy is enforced
+func checkPolicy(ctx context.Context, collections []file.Collection) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	p := readPolicy(ctx, collections)
+	if p == nil {
+		return nil
+	}
+	now := time.Now()
+	log.Infof("Policy: %s", formatPolicy(p, now))
+	if !p.Expired(now) {
+		return nil
+	}
+	if enforce, _ := ctx.Value(enforcePolicyKey{}).(bool); enforce {
+		log.Error(fmt.Errorf("%w: the collections expired at %s, and are due to be rotated", ErrExpired, p.NotAfter.Format(time.RFC3339)))
+		return fmt.Errorf("%w: the collections expired at %s, and are due to be rotated", ErrExpired, p.NotAfter.Format(time.RFC3339))
+	}
+	log.Infof("Warning: the collections expired at %s, and are due to be rotated; re-encode the data into a new set with reshare, and destroy these", p.NotAfter.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,133 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestParseNotAfter(t *testing.T) {
+	got, err := ParseNotAfter("2030-01-01")
+	if err != nil {
+		t.Fatalf("ParseNotAfter failed: %v", err)
+	}
+	if want := time.Date(2030, 1, 1, 23, 59, 59, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected a date to expire at the end of the day, %s, got %s", want, got)
+	}
+
+	got, err = ParseNotAfter("2030-01-01T12:00:00+02:00")
+	if err != nil {
+		t.Fatalf("ParseNotAfter failed: %v", err)
+	}
+	if want := time.Date(2030, 1, 1, 10, 0, 0, 0, time.UTC); !got.Equal(want) || got.Location() != time.UTC {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	for _, s := range []string{"", "tomorrow", "2030-13-01", "01/01/2030"} {
+		if _, err := ParseNotAfter(s); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig for %q, got %v", s, err)
+		}
+	}
+}
+
+func TestDecodePolicy(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "padlock-policy-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input directory: %v", err)
+	}
+	testContent := strings.Repeat("expiring content\n", 100)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// encode encodes the input into collections expiring at notAfter
+	encode := func(name string, notAfter time.Time) string {
+		cfg := EncodeConfig{
+			InputDir:       inputDir,
+			OutputDir:      filepath.Join(tempDir, name),
+			N:              3,
+			K:              2,
+			Format:         FormatBin,
+			ChunkSize:      1024,
+			RNG:            pad.NewDefaultRand(ctx),
+			Compression:    CompressionNone,
+			ZipCollections: true,
+			NotAfter:       notAfter,
+			PolicyNotes:    "rotate yearly",
+		}
+		if err := EncodeDirectory(ctx, cfg); err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		return cfg.OutputDir
+	}
+
+	// decode decodes the collections in dir, enforcing their policy or not
+	decode := func(dir string, enforce bool) (string, error) {
+		restoreDir := dir + "-restore"
+		err := DecodeDirectory(ctx, DecodeConfig{InputDir: dir, OutputDir: restoreDir, ClearIfNotEmpty: true, EnforcePolicy: enforce})
+		data, _ := os.ReadFile(filepath.Join(restoreDir, "data.txt"))
+		return string(data), err
+	}
+
+	t.Run("Recorded", func(t *testing.T) {
+		dir := encode("recorded", time.Now().Add(24*time.Hour))
+		collections, tempPath, err := file.FindCollections(ctx, dir)
+		if err != nil {
+			t.Fatalf("Failed to find collections: %v", err)
+		}
+		defer file.CloseCollections(collections)
+		defer file.RemoveTemp(ctx, tempPath)
+		for _, coll := range collections {
+			p, err := file.ReadPolicy(ctx, coll)
+			if err != nil || p == nil || p.NotAfter == nil || p.Notes != "rotate yearly" {
+				t.Errorf("Expected the policy in %s, got %+v (%v)", coll.Name, p, err)
+			}
+		}
+	})
+
+	t.Run("Not expired", func(t *testing.T) {
+		dir := encode("current", time.Now().Add(24*time.Hour))
+		if data, err := decode(dir, true); err != nil || data != testContent {
+			t.Errorf("Expected collections that have not expired to decode (%v)", err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		dir := encode("expired", time.Now().Add(-24*time.Hour))
+
+		// Expired collections are decoded with a warning
+		if data, err := decode(dir, false); err != nil || data != testContent {
+			t.Errorf("Expected expired collections to decode with a warning (%v)", err)
+		}
+
+		// or refused when their policy is enforced
+		if data, err := decode(dir, true); !errors.Is(err, ErrExpired) || data != "" {
+			t.Errorf("Expected ErrExpired and nothing restored, got %v", err)
+		}
+	})
+
+	t.Run("Invalid notes", func(t *testing.T) {
+		cfg := EncodeConfig{InputDir: inputDir, OutputDir: filepath.Join(tempDir, "invalid"), N: 3, K: 2, Format: FormatBin, ChunkSize: 1024, RNG: pad.NewDefaultRand(ctx), PolicyNotes: "rotate\nyearly"}
+		if err := cfg.Validate(ctx); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig for notes of several lines, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package padlock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// RandomnessPolicy decides what an encode does about pads or collection
+// chunks that fail the randomness checks (see pad.RandomnessPolicy)
+type RandomnessPolicy = pad.RandomnessPolicy
+
+// Randomness policies
+const (
+	RandomnessOff  = pad.RandomnessOff
+	RandomnessWarn = pad.RandomnessWarn
+	RandomnessFail = pad.RandomnessFail
+)
+
+// recordEntropy runs the health tests on rng and returns an RNG that records
+// what each of its sources contributes, and a function that writes the
+// report of it to path once the encode is complete. A source that fails its
+// health tests is reported and warned about, but does not stop the encode,
+// as the mix is as strong as its strongest source; the ceremony is where an
+// unhealthy source is refused.
+func recordEntropy(ctx context.Context, rng pad.RNG, path string) (pad.RNG, func() error) {
+	log := trace.FromContext(ctx).WithPrefix("ENTROPY")
+
+	health, err := pad.CheckHealth(ctx, rng, 0)
+	for _, r := range health {
+		log.Debugf("Health: %s", r)
+	}
+	if err != nil {
+		log.Infof("Warning: %v", err)
+	}
+
+	recorded, recorder := pad.RecordEntropy(rng)
+	report := func() error {
+		data, err := json.MarshalIndent(recorder.Report(health), "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			err = fmt.Errorf("failed to write the entropy report: %w", err)
+			log.Error(err)
+			return err
+		}
+		log.Infof("Wrote entropy report to %s", path)
+		return nil
+	}
+	return recorded, report
+}
+
+// summarizeRandomness reports the findings of the randomness checks of an
+// encode once it is complete, or has failed
+func summarizeRandomness(ctx context.Context, checker *pad.RandomnessChecker) {
+	log := trace.FromContext(ctx).WithPrefix("RANDOMNESS-CHECK")
+
+	checked, findings := checker.Summary()
+	if len(findings) == 0 {
+		log.Debugf("Randomness check: all %d chunks and pads checked passed", checked)
+		return
+	}
+	log.Infof("Randomness check: %d of %d chunks and pads checked failed:", len(findings), checked)
+	for _, f := range findings {
+		log.Infof("  %s", f)
+	}
+}
@@ -0,0 +1,129 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestEntropyReport(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "seed.txt"), []byte(strings.Repeat("wallet seed ", 500)), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	reportPath := filepath.Join(tempDir, "entropy.json")
+	err := EncodeDirectory(ctx, EncodeConfig{
+		InputDir:      inputDir,
+		OutputDir:     filepath.Join(tempDir, "output"),
+		N:             3,
+		K:             2,
+		Format:        FormatBin,
+		ChunkSize:     1024,
+		RNG:           pad.NewDefaultRand(ctx),
+		Compression:   CompressionNone,
+		EntropyReport: reportPath,
+	})
+	if err != nil {
+		t.Fatalf("EncodeDirectory failed: %v", err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("Failed to read the entropy report: %v", err)
+	}
+	var report pad.EntropyReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("The entropy report is not valid JSON: %v", err)
+	}
+	if len(report.Sources) != 5 || report.Bytes == 0 {
+		t.Fatalf("Expected the 5 default sources and the bytes drawn, got %s", data)
+	}
+	for _, s := range report.Sources {
+		if s.Bytes != report.Bytes || s.OutputSHA256 == "" {
+			t.Errorf("Expected source %s to have contributed every byte, and its hash: %+v", s.Source, s)
+		}
+	}
+	if len(report.Health) != 6 || !report.Health[5].Passed() {
+		t.Errorf("Expected health results for each source and the mix: %+v", report.Health)
+	}
+}
+
+// zeroRNG is a broken generator, whose pads are all zeros
+type zeroRNG struct{}
+
+func (zeroRNG) Name() string { return "zero" }
+
+func (zeroRNG) Read(ctx context.Context, p []byte) error {
+	clear(p)
+	return nil
+}
+
+func TestRandomnessPolicy(t *testing.T) {
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "seed.txt"), []byte(strings.Repeat("wallet seed ", 500)), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for _, tc := range []struct {
+		policy RandomnessPolicy
+		rng    pad.RNG
+		fails  bool
+		warned bool
+	}{
+		{RandomnessWarn, pad.NewDefaultRand(context.Background()), false, false},
+		{RandomnessFail, pad.NewDefaultRand(context.Background()), false, false},
+		{RandomnessOff, zeroRNG{}, false, false},
+		{RandomnessWarn, zeroRNG{}, false, true},
+		{RandomnessFail, zeroRNG{}, true, false},
+	} {
+		t.Run(string(tc.policy)+" "+tc.rng.Name(), func(t *testing.T) {
+			var logged bytes.Buffer
+			tracer := trace.NewTracer("TEST", trace.LogLevelNormal)
+			tracer.SetSinks(trace.TextSink(&logged))
+			ctx := trace.WithContext(context.Background(), tracer)
+
+			err := EncodeDirectory(ctx, EncodeConfig{
+				InputDir:    inputDir,
+				OutputDir:   filepath.Join(tempDir, string(tc.policy)+"-"+tc.rng.Name()),
+				N:           3,
+				K:           2,
+				Format:      FormatBin,
+				ChunkSize:   1024,
+				RNG:         tc.rng,
+				Compression: CompressionNone,
+				Randomness:  tc.policy,
+			})
+			if tc.fails != errors.Is(err, ErrNotRandom) {
+				t.Fatalf("Expected failure %v, got %v", tc.fails, err)
+			}
+			if !tc.fails && err != nil {
+				t.Fatalf("EncodeDirectory failed: %v", err)
+			}
+			summarized := strings.Contains(logged.String(), "chunks and pads checked failed")
+			if summarized != (tc.warned || tc.fails) {
+				t.Errorf("Unexpected summary of the randomness checks:\n%s", logged.String())
+			}
+			if tc.warned && !strings.Contains(logged.String(), "pad of chunk 1: ") {
+				t.Errorf("Expected the pads to be reported:\n%s", logged.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package padlock
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// RecoverConfig holds configuration parameters for the interactive recovery wizard.
+// This structure is created by the command-line interface and passed to RecoverInteractive.
+type RecoverConfig struct {
+	Roots           []string           // Directories to search for collections (default: DefaultRecoverRoots)
+	OutputDir       string             // Where to restore the data; asked for if empty
+	Compression     Compression        // Compression assumed for data encoded before its compression was recorded in the stream
+	ClearIfNotEmpty bool               // Whether to clear the output directory if not empty
+	Deserialize     DeserializeOptions // File attributes to restore and files to select from the archive
+	Verbose         bool               // Enable verbose logging
+	Input           io.Reader          // Where the user's answers are read
+	Output          io.Writer          // Where findings and questions are written
+	Progress        func(n int64)      // If set, called as the data is restored with the number of archive bytes restored so far
+}
+
+// DefaultRecoverRoots returns the directories under which removable media and
+// other file systems are usually mounted, of those that exist
+func DefaultRecoverRoots() []string {
+	var roots []string
+	for _, dir := range []string{"/Volumes", "/media", "/run/media", "/mnt"} {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			roots = append(roots, dir)
+		}
+	}
+	return roots
+}
+
+// RecoverInteractive guides a user through restoring data from collections
+// scattered across drives and directories. It searches cfg.Roots for anything
+// that looks like a collection, confirms each by reading its chunk headers,
+// and explains what is still missing to reach K. Until enough collections are
+// found, the user can attach more media or name more directories to search.
+// Once they are, it asks where to restore the data and restores it.
+func RecoverInteractive(ctx context.Context, cfg RecoverConfig) error {
+	log := trace.FromContext(ctx).WithPrefix("RECOVER")
+	in := bufio.NewScanner(cfg.Input)
+	out := cfg.Output
+
+	// ask writes a question and returns the trimmed answer, or false at end of input
+	ask := func(format string, args ...interface{}) (string, bool) {
+		fmt.Fprintf(out, format, args...)
+		if !in.Scan() {
+			fmt.Fprintln(out)
+			return "", false
+		}
+		return strings.TrimSpace(in.Text()), true
+	}
+
+	roots := cfg.Roots
+	if len(roots) == 0 {
+		roots = DefaultRecoverRoots()
+	}
+	var scans []*file.ScanResult
+	defer func() {
+		for _, scan := range scans {
+			scan.Close()
+		}
+	}()
+
+	var d *Diagnosis
+	var collections []file.Collection
+	for {
+		if len(roots) > 0 {
+			fmt.Fprintf(out, "Searching for collections in %s...\n", strings.Join(roots, ", "))
+			scan, err := file.ScanForCollections(ctx, roots)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+			scans = append(scans, scan)
+			collections = scan.Collections()
+			for _, s := range scan.Skipped {
+				fmt.Fprintf(out, "  Skipped %s\n", s)
+			}
+			for _, c := range scan.Candidates {
+				for _, dup := range c.Duplicates {
+					fmt.Fprintf(out, "  Skipped %s: a copy of %s\n", dup, c.Source)
+				}
+			}
+		}
+
+		var err error
+		if len(collections) == 0 {
+			fmt.Fprintf(out, "No collections found.\n")
+			err = fmt.Errorf("%w in %s", ErrNoCollections, strings.Join(roots, ", "))
+		} else {
+			d = diagnose(ctx, collections)
+			d.WriteReport(out)
+			if d.Decodable() {
+				break
+			}
+			err = d.Err()
+		}
+
+		// Give the user the chance to supply what is missing
+		answer, ok := ask("Attach more media and press Enter to search again, type another directory to search, or q to quit: ")
+		if !ok || strings.EqualFold(answer, "q") {
+			log.Error(fmt.Errorf("recovery abandoned: %w", err))
+			return fmt.Errorf("recovery abandoned: %w", err)
+		}
+		if answer != "" {
+			if info, statErr := os.Stat(answer); statErr != nil || !info.IsDir() {
+				fmt.Fprintf(out, "%s is not a directory.\n", answer)
+			} else {
+				roots = append(roots, answer)
+			}
+		}
+	}
+
+	// Decode the usable collections where they were found
+	var selected []file.Collection
+	for i, c := range d.Collections {
+		if c.Session == d.Session && !c.Duplicate {
+			selected = append(selected, collections[i])
+		}
+	}
+	outputDir := cfg.OutputDir
+	for outputDir == "" {
+		answer, ok := ask("Restore the data to which directory? ")
+		if !ok {
+			return fmt.Errorf("recovery abandoned: %w: no output directory given", ErrInvalidConfig)
+		}
+		outputDir = answer
+	}
+	answer, ok := ask("Restore from %d collections to %s? [y/N] ", len(selected), outputDir)
+	if !ok || !strings.EqualFold(answer, "y") && !strings.EqualFold(answer, "yes") {
+		fmt.Fprintf(out, "Nothing was restored.\n")
+		return nil
+	}
+
+	err := DecodeDirectory(ctx, DecodeConfig{
+		OutputDir:       outputDir,
+		Verbose:         cfg.Verbose,
+		Compression:     cfg.Compression,
+		ClearIfNotEmpty: cfg.ClearIfNotEmpty,
+		Deserialize:     cfg.Deserialize,
+		Collections:     selected,
+		Progress:        cfg.Progress,
+	})
+	if err != nil {
+		fmt.Fprintf(out, "Restore failed: %v\n", err)
+		return err
+	}
+	fmt.Fprintf(out, "Restored to %s\n", outputDir)
+	return nil
+}
@@ -0,0 +1,128 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestRecoverInteractive(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-recover-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a context for this test
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	inputDir := filepath.Join(tempDir, "input")
+	encodeOutputDir := filepath.Join(tempDir, "encoded")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("scattered across drives\n", 200)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	err = EncodeDirectory(ctx, EncodeConfig{
+		InputDir:    inputDir,
+		OutputDir:   encodeOutputDir,
+		N:           3,
+		K:           2,
+		Format:      FormatBin,
+		ChunkSize:   1024,
+		RNG:         pad.NewDefaultRand(ctx),
+		Compression: CompressionGzip,
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	// One collection on each of two drives, one of them zipped and buried,
+	// and on the first drive a directory that is only named like a collection
+	drive1 := filepath.Join(tempDir, "drive1")
+	drive2 := filepath.Join(tempDir, "drive2")
+	for _, dir := range []string{filepath.Join(drive1, "backup"), filepath.Join(drive2, "a", "b"), filepath.Join(drive1, "2A9")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(drive1, "2A9", "2A9_0001.bin"), []byte("not a chunk"), 0644); err != nil {
+		t.Fatalf("Failed to create decoy file: %v", err)
+	}
+	if err := os.Rename(filepath.Join(encodeOutputDir, "2A3"), filepath.Join(drive1, "backup", "2A3")); err != nil {
+		t.Fatalf("Failed to move collection: %v", err)
+	}
+	zipPath, err := file.ZipCollection(ctx, filepath.Join(encodeOutputDir, "2C3"))
+	if err != nil {
+		t.Fatalf("Failed to zip collection: %v", err)
+	}
+	if err := os.Rename(zipPath, filepath.Join(drive2, "a", "b", "2C3.zip")); err != nil {
+		t.Fatalf("Failed to move collection: %v", err)
+	}
+
+	t.Run("Search again with another directory", func(t *testing.T) {
+		var output bytes.Buffer
+		outputDir := filepath.Join(tempDir, "restored")
+		err := RecoverInteractive(ctx, RecoverConfig{
+			Roots:       []string{drive1},
+			Compression: CompressionGzip,
+			Input:       strings.NewReader(drive2 + "\n" + outputDir + "\ny\n"),
+			Output:      &output,
+		})
+		if err != nil {
+			t.Fatalf("Recover failed: %v\n%s", err, output.String())
+		}
+		for _, want := range []string{"Skipped " + filepath.Join(drive1, "2A9"), "any of: 2B3, 2C3", "Restored to " + outputDir} {
+			if !strings.Contains(output.String(), want) {
+				t.Errorf("Expected output to contain %q, got:\n%s", want, output.String())
+			}
+		}
+		restored, err := os.ReadFile(filepath.Join(outputDir, "data.txt"))
+		if err != nil || string(restored) != testContent {
+			t.Errorf("Restored data does not match the original: %v", err)
+		}
+	})
+
+	t.Run("Declined", func(t *testing.T) {
+		var output bytes.Buffer
+		outputDir := filepath.Join(tempDir, "declined")
+		err := RecoverInteractive(ctx, RecoverConfig{
+			Roots:       []string{drive1, drive2},
+			OutputDir:   outputDir,
+			Compression: CompressionGzip,
+			Input:       strings.NewReader("n\n"),
+			Output:      &output,
+		})
+		if err != nil {
+			t.Fatalf("Recover failed: %v", err)
+		}
+		if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+			t.Errorf("Expected nothing to be restored")
+		}
+	})
+
+	t.Run("Abandoned", func(t *testing.T) {
+		var output bytes.Buffer
+		err := RecoverInteractive(ctx, RecoverConfig{
+			Roots:       []string{drive1},
+			Compression: CompressionGzip,
+			Input:       strings.NewReader("q\n"),
+			Output:      &output,
+		})
+		if !errors.Is(err, ErrInsufficientCollections) {
+			t.Errorf("Expected ErrInsufficientCollections, got %v", err)
+		}
+	})
+}
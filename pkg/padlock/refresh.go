@@ -0,0 +1,163 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// RefreshConfig holds configuration parameters for refreshing a set of collections.
+// This structure is created by the command-line interface and passed to RefreshCollections.
+type RefreshConfig struct {
+	InputDir        string  // Path to the directory containing all N collections
+	OutputDir       string  // Path where the refreshed collections will be created
+	RNG             pad.RNG // Random number generator for the refresh masks
+	ClearIfNotEmpty bool    // Whether to clear the output directory if not empty
+	Verbose         bool    // Enable verbose logging
+	ZipCollections  bool    // Whether to create ZIP archives for the refreshed collections
+	TarCollections  bool    // Whether to create gzipped tar archives for the refreshed collections
+}
+
+// RefreshCollections writes a refreshed copy of a complete set of N collections
+// to the output directory. The refreshed collections hold the same data with the
+// same K-of-N threshold, names, format and chunking, but every share is new, so
+// a collection that leaked before the refresh cannot be combined with any
+// refreshed one. Unlike a reshare, the data is never reconstructed, not even in
+// memory; the price is that all N collections are needed.
+//
+// Once the refreshed set has been distributed, every copy of the old set should
+// be destroyed.
+func RefreshCollections(ctx context.Context, cfg RefreshConfig) error {
+	ctx, op := trace.StartOperation(ctx, "refresh")
+	defer op.End()
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	start := time.Now()
+	log.Infof("Starting refresh: InputDir=%s OutputDir=%s", cfg.InputDir, cfg.OutputDir)
+
+	// Validate input directory to ensure it exists and is accessible
+	if err := file.ValidateInputDirectory(ctx, cfg.InputDir); err != nil {
+		return err
+	}
+	if err := pad.CheckOffline(ctx, cfg.RNG); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if cfg.ZipCollections && cfg.TarCollections {
+		log.Error(fmt.Errorf("%w: collections cannot be archived as both zip and tar", ErrInvalidConfig))
+		return fmt.Errorf("%w: collections cannot be archived as both zip and tar", ErrInvalidConfig)
+	}
+
+	// Preparing the output would destroy the collections being read
+	inputAbs, err1 := filepath.Abs(cfg.InputDir)
+	outputAbs, err2 := filepath.Abs(cfg.OutputDir)
+	if err1 == nil && err2 == nil && inputAbs == outputAbs {
+		log.Error(fmt.Errorf("%w: output directory must differ from the input directory", ErrInvalidConfig))
+		return fmt.Errorf("%w: output directory must differ from the input directory", ErrInvalidConfig)
+	}
+
+	// Find collections (directories or archives) in the input directory
+	collections, tempDir, err := file.FindCollections(ctx, cfg.InputDir)
+	if err != nil {
+		return err
+	}
+	defer file.CloseCollections(collections)
+	defer file.RemoveTemp(ctx, tempDir)
+	if len(collections) == 0 {
+		log.Error(fmt.Errorf("%w in input directory", ErrNoCollections))
+		return fmt.Errorf("%w in input directory", ErrNoCollections)
+	}
+	if err := checkNoSnapshots(ctx, collections, "refreshed"); err != nil {
+		return err
+	}
+
+	if err := file.PrepareOutputDirectory(ctx, cfg.OutputDir, cfg.ClearIfNotEmpty); err != nil {
+		return err
+	}
+
+	// Each refreshed collection keeps the name and format of the original, and
+	// is streamed straight into its zip if zipped
+	var refreshed []file.Collection
+	zipWriters := make(map[string]*file.ZipWriter)
+	defer func() {
+		for _, zw := range zipWriters {
+			zw.Finish()
+		}
+	}()
+	if cfg.ZipCollections {
+		for _, coll := range collections {
+			zw, err := file.NewZipWriter(file.WithChunkNaming(ctx, coll.Naming), cfg.OutputDir, coll.Name, coll.Format)
+			if err != nil {
+				return err
+			}
+			zipWriters[coll.Name] = zw
+			refreshed = append(refreshed, zw.Collection())
+		}
+	} else {
+		names := make([]string, len(collections))
+		for i, coll := range collections {
+			names[i] = coll.Name
+		}
+		refreshed, err = file.CreateCollections(ctx, cfg.OutputDir, names)
+		if err != nil {
+			return err
+		}
+		for i := range refreshed {
+			refreshed[i].Format, refreshed[i].Naming = collections[i].Format, collections[i].Naming
+		}
+	}
+	outputs := make(map[string]file.Collection, len(refreshed))
+	for _, coll := range refreshed {
+		outputs[coll.Name] = coll
+	}
+
+	readers := make([]io.Reader, len(collections))
+	for i, coll := range collections {
+		readers[i] = file.NewChunkReaderAdapter(ctx, file.NewCollectionReader(coll))
+	}
+
+	newChunkFunc := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		coll, ok := outputs[collectionName]
+		if !ok {
+			return nil, fmt.Errorf("collection not found: %s", collectionName)
+		}
+		if zw, ok := zipWriters[collectionName]; ok {
+			return zw.NewChunkWriter(chunkNumber), nil
+		}
+		return file.NewChunkWriter(ctx, file.GetNamedFormatter(coll.Format, coll.Naming), coll.Path, 0, chunkNumber), nil
+	}
+
+	p, err := pad.NewPadForDecode(ctx, len(collections))
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create pad instance: %w", err))
+		return err
+	}
+	if err := p.Refresh(ctx, readers, cfg.RNG, newChunkFunc, ""); err != nil {
+		log.Error(fmt.Errorf("refresh failed: %w", err))
+		return fmt.Errorf("refresh failed: %w", err)
+	}
+
+	for _, coll := range collections {
+		if zw, ok := zipWriters[coll.Name]; ok {
+			zipPath, err := zw.Finish()
+			if err != nil {
+				return err
+			}
+			log.Infof("Created zip archive for collection %s: %s", coll.Name, zipPath)
+		}
+	}
+	if cfg.TarCollections {
+		if _, err := file.TarCollections(ctx, refreshed); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Refresh complete (%s): %d collections", time.Since(start), len(refreshed))
+	return nil
+}
@@ -0,0 +1,102 @@
+package padlock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestRefreshCollections(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "padlock-refresh-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	oldDir := filepath.Join(tempDir, "old")
+	newDir := filepath.Join(tempDir, "new")
+	subsetDir := filepath.Join(tempDir, "subset")
+	restoreDir := filepath.Join(tempDir, "restore")
+	for _, dir := range []string{inputDir, subsetDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+
+	testContent := strings.Repeat("refreshed content\n", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	encodeConfig := EncodeConfig{
+		InputDir:    inputDir,
+		OutputDir:   oldDir,
+		N:           3,
+		K:           2,
+		Format:      FormatPNG,
+		ChunkSize:   1024,
+		RNG:         pad.NewDefaultRand(ctx),
+		Compression: CompressionGzip,
+	}
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	refreshConfig := RefreshConfig{
+		InputDir:  oldDir,
+		OutputDir: newDir,
+		RNG:       pad.NewDefaultRand(ctx),
+	}
+	if err := RefreshCollections(ctx, refreshConfig); err != nil {
+		t.Fatalf("Failed to refresh collections: %v", err)
+	}
+
+	// The refreshed chunks keep their names but not their contents
+	oldChunk, err := os.ReadFile(filepath.Join(oldDir, "2B3", "IMG2B3_0001.PNG"))
+	if err != nil {
+		t.Fatalf("Failed to read original chunk: %v", err)
+	}
+	newChunk, err := os.ReadFile(filepath.Join(newDir, "2B3", "IMG2B3_0001.PNG"))
+	if err != nil {
+		t.Fatalf("Failed to read refreshed chunk: %v", err)
+	}
+	if string(oldChunk) == string(newChunk) {
+		t.Errorf("Refreshed chunk is identical to the original")
+	}
+
+	// Any two refreshed collections restore the original data
+	for _, coll := range []string{"2A3", "2B3"} {
+		if err := os.Rename(filepath.Join(newDir, coll), filepath.Join(subsetDir, coll)); err != nil {
+			t.Fatalf("Failed to move collection %s: %v", coll, err)
+		}
+	}
+	decodeConfig := DecodeConfig{
+		InputDir:    subsetDir,
+		OutputDir:   restoreDir,
+		Compression: CompressionGzip,
+	}
+	if err := DecodeDirectory(ctx, decodeConfig); err != nil {
+		t.Fatalf("Failed to decode refreshed collections: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(restoreDir, "data.txt"))
+	if err != nil || string(data) != testContent {
+		t.Errorf("Restored data does not match the original (%v)", err)
+	}
+
+	// A refresh needs every collection of the set
+	refreshConfig.InputDir = subsetDir
+	refreshConfig.OutputDir = filepath.Join(tempDir, "partial")
+	if err := RefreshCollections(ctx, refreshConfig); err == nil {
+		t.Errorf("Expected an error refreshing an incomplete set")
+	}
+}
@@ -0,0 +1,149 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// RepairConfig holds configuration parameters for repairing a collection.
+// This structure is created by the command-line interface and passed to RepairCollection.
+type RepairConfig struct {
+	InputDir        string // Path to the directory containing the damaged collection and the others of its set
+	OutputDir       string // Path where the repaired collection will be created
+	Collection      string // Name of the damaged collection (e.g., "2B3")
+	ClearIfNotEmpty bool   // Whether to clear the output directory if not empty
+	Verbose         bool   // Enable verbose logging
+	ZipCollections  bool   // Whether to create a ZIP archive of the repaired collection
+	TarCollections  bool   // Whether to create a gzipped tar archive of the repaired collection
+}
+
+// RepairCollection writes a repaired copy of a damaged collection to the output
+// directory, its missing and damaged chunks regenerated from the other
+// collections of its set in the input directory. A regenerated chunk is the
+// very chunk that was lost, so the repaired collection can replace the damaged
+// one in its custodian's hands without a reshare; the other collections are
+// left as they are. Chunks can only be regenerated where every other
+// collection of the set holds them intact; the collection is written without
+// those that cannot, and an error lists them.
+func RepairCollection(ctx context.Context, cfg RepairConfig) error {
+	ctx, op := trace.StartOperation(ctx, "repair")
+	defer op.End()
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	start := time.Now()
+	log.Infof("Starting repair: InputDir=%s OutputDir=%s Collection=%s", cfg.InputDir, cfg.OutputDir, cfg.Collection)
+
+	// Validate input directory to ensure it exists and is accessible
+	if err := file.ValidateInputDirectory(ctx, cfg.InputDir); err != nil {
+		return err
+	}
+
+	if cfg.ZipCollections && cfg.TarCollections {
+		log.Error(fmt.Errorf("%w: collections cannot be archived as both zip and tar", ErrInvalidConfig))
+		return fmt.Errorf("%w: collections cannot be archived as both zip and tar", ErrInvalidConfig)
+	}
+
+	// Preparing the output would destroy the collections being read
+	inputAbs, err1 := filepath.Abs(cfg.InputDir)
+	outputAbs, err2 := filepath.Abs(cfg.OutputDir)
+	if err1 == nil && err2 == nil && inputAbs == outputAbs {
+		log.Error(fmt.Errorf("%w: output directory must differ from the input directory", ErrInvalidConfig))
+		return fmt.Errorf("%w: output directory must differ from the input directory", ErrInvalidConfig)
+	}
+
+	// Find collections (directories or archives) in the input directory
+	collections, tempDir, err := file.FindCollections(ctx, cfg.InputDir)
+	if err != nil {
+		return err
+	}
+	defer file.CloseCollections(collections)
+	defer file.RemoveTemp(ctx, tempDir)
+	if len(collections) == 0 {
+		log.Error(fmt.Errorf("%w in input directory", ErrNoCollections))
+		return fmt.Errorf("%w in input directory", ErrNoCollections)
+	}
+	if err := checkNoSnapshots(ctx, collections, "repaired"); err != nil {
+		return err
+	}
+
+	// Separate the damaged collection from the others of its set
+	var damaged *file.Collection
+	var target *file.ChunkSource
+	var others []pad.ChunkSource
+	for i, coll := range collections {
+		source, err := file.NewChunkSource(ctx, coll)
+		if err != nil {
+			return err
+		}
+		if coll.Name == cfg.Collection && damaged == nil {
+			damaged, target = &collections[i], source
+			continue
+		}
+		others = append(others, source)
+	}
+	if damaged == nil {
+		log.Error(fmt.Errorf("%w: collection %s is not in the input directory", ErrNoCollections, cfg.Collection))
+		return fmt.Errorf("%w: collection %s is not in the input directory", ErrNoCollections, cfg.Collection)
+	}
+
+	if err := file.PrepareOutputDirectory(ctx, cfg.OutputDir, cfg.ClearIfNotEmpty); err != nil {
+		return err
+	}
+	repaired, err := file.CreateCollections(ctx, cfg.OutputDir, []string{damaged.Name})
+	if err != nil {
+		return err
+	}
+	repaired[0].Format, repaired[0].Naming = damaged.Format, damaged.Naming
+	formatter := file.GetNamedFormatter(damaged.Format, damaged.Naming)
+	newChunkFunc := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		return file.NewChunkWriter(ctx, formatter, repaired[0].Path, 0, chunkNumber), nil
+	}
+
+	p, err := pad.NewPadForDecode(ctx, len(collections))
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create pad instance: %w", err))
+		return err
+	}
+	report, err := p.Repair(ctx, target, others, newChunkFunc, string(damaged.Format))
+	if err != nil {
+		log.Error(fmt.Errorf("repair failed: %w", err))
+		return fmt.Errorf("repair failed: %w", err)
+	}
+
+	// The regenerated chunks are the original ones, so the parity, recovery
+	// instructions and decoders of the collection still apply
+	if _, err := file.CopyCollectionFiles(ctx, *damaged, repaired[0].Path); err != nil {
+		return err
+	}
+	if err := file.WriteIndex(ctx, repaired[0]); err != nil {
+		return err
+	}
+	if cfg.ZipCollections {
+		if _, err := file.ZipCollections(ctx, repaired); err != nil {
+			return err
+		}
+	}
+	if cfg.TarCollections {
+		if _, err := file.TarCollections(ctx, repaired); err != nil {
+			return err
+		}
+	}
+
+	if len(report.Repaired) > 0 {
+		log.Infof("Repaired %d of %d chunks of collection %s: %v", len(report.Repaired), report.Chunks, damaged.Name, report.Repaired)
+	} else {
+		log.Infof("Collection %s has no missing or damaged chunks", damaged.Name)
+	}
+	if err := report.Err(); err != nil {
+		log.Error(err)
+		return err
+	}
+	log.Infof("Repair complete (%s)", time.Since(start))
+	return nil
+}
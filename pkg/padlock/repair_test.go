@@ -0,0 +1,115 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestRepairCollection(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "padlock-repair-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	encodedDir := filepath.Join(tempDir, "encoded")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("repaired content\n", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	encodeConfig := EncodeConfig{
+		InputDir:    inputDir,
+		OutputDir:   encodedDir,
+		N:           3,
+		K:           2,
+		Format:      FormatPNG,
+		ChunkSize:   1024,
+		RNG:         pad.NewDefaultRand(ctx),
+		Compression: CompressionNone,
+	}
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+
+	// Lose one chunk of 2B3 and truncate another, keeping the originals
+	original := make(map[string][]byte)
+	for _, n := range []int{2, 3} {
+		name := file.ChunkFileName(FormatPNG, "2B3", n)
+		data, err := os.ReadFile(filepath.Join(encodedDir, "2B3", name))
+		if err != nil {
+			t.Fatalf("Failed to read chunk: %v", err)
+		}
+		original[name] = data
+	}
+	os.Remove(filepath.Join(encodedDir, "2B3", file.ChunkFileName(FormatPNG, "2B3", 2)))
+	chunk3 := filepath.Join(encodedDir, "2B3", file.ChunkFileName(FormatPNG, "2B3", 3))
+	if err := os.WriteFile(chunk3, original[filepath.Base(chunk3)][:100], 0644); err != nil {
+		t.Fatalf("Failed to truncate chunk: %v", err)
+	}
+
+	repairConfig := RepairConfig{
+		InputDir:   encodedDir,
+		OutputDir:  filepath.Join(tempDir, "repaired"),
+		Collection: "2B3",
+	}
+	if err := RepairCollection(ctx, repairConfig); err != nil {
+		t.Fatalf("Failed to repair collection: %v", err)
+	}
+	for name, data := range original {
+		repaired, err := os.ReadFile(filepath.Join(repairConfig.OutputDir, "2B3", name))
+		if err != nil || !bytes.Equal(repaired, data) {
+			t.Errorf("Expected %s to be regenerated as it was (%v)", name, err)
+		}
+	}
+
+	// The repaired collection decodes with another
+	subsetDir := filepath.Join(tempDir, "subset")
+	if err := os.MkdirAll(subsetDir, 0755); err != nil {
+		t.Fatalf("Failed to create subset dir: %v", err)
+	}
+	os.Rename(filepath.Join(repairConfig.OutputDir, "2B3"), filepath.Join(subsetDir, "2B3"))
+	os.Rename(filepath.Join(encodedDir, "2C3"), filepath.Join(subsetDir, "2C3"))
+	decodeConfig := DecodeConfig{
+		InputDir:    subsetDir,
+		OutputDir:   filepath.Join(tempDir, "restore"),
+		Compression: CompressionNone,
+	}
+	if err := DecodeDirectory(ctx, decodeConfig); err != nil {
+		t.Fatalf("Failed to decode repaired collection: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(decodeConfig.OutputDir, "data.txt"))
+	if err != nil || string(data) != testContent {
+		t.Errorf("Restored data does not match the original (%v)", err)
+	}
+
+	// Without 2C3, too few collections are left to take the data from
+	repairConfig.OutputDir = filepath.Join(tempDir, "partial")
+	if err := RepairCollection(ctx, repairConfig); !errors.Is(err, ErrInsufficientCollections) {
+		t.Errorf("Expected ErrInsufficientCollections, got %v", err)
+	}
+
+	// The damaged collection must be among those supplied
+	repairConfig.OutputDir = filepath.Join(tempDir, "absent")
+	repairConfig.Collection = "2C3"
+	if err := RepairCollection(ctx, repairConfig); !errors.Is(err, ErrNoCollections) {
+		t.Errorf("Expected ErrNoCollections for a collection not supplied, got %v", err)
+	}
+}
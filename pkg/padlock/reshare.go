@@ -0,0 +1,130 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// errReshareStopped is the error seen by the decoder when the encoder stops reading
+var errReshareStopped = errors.New("reshare stopped")
+
+// ReshareConfig holds configuration parameters for re-sharing a set of collections.
+// This structure is created by the command-line interface and passed to ReshareCollections.
+type ReshareConfig struct {
+	InputDir        string         // Path to the directory containing K or more existing collections
+	OutputDir       string         // Path where the new collections will be created
+	N               int            // Total number of new collections to create
+	K               int            // Minimum new collections required for reconstruction
+	Format          Format         // Output format (binary or PNG)
+	ChunkSize       int            // Maximum size for data chunks in bytes
+	RNG             pad.RNG        // Random number generator for the new one-time pads
+	ClearIfNotEmpty bool           // Whether to clear the output directory if not empty
+	Verbose         bool           // Enable verbose logging
+	ZipCollections  bool           // Whether to create ZIP archives for the new collections
+	TarCollections  bool           // Whether to create gzipped tar archives for the new collections
+	VolumeSize      int64          // If nonzero, split each new collection into volumes of at most this many bytes
+	Targets         []string       // If set, one directory per new collection to write and verify it on
+	Decoders        []file.Decoder // If set, padlock executables embedded with instructions in each new collection
+}
+
+// ReshareCollections decodes K or more existing collections and re-encodes the
+// data into a fresh set of N collections, any K of which can reconstruct it,
+// using brand-new randomness. This rotates shares, changes the threshold, or
+// replaces a lost share without restoring the original files.
+//
+// Decoding and encoding run as a single streaming pass: the reconstructed
+// stream is passed from the decoder to the encoder in memory, one chunk at a
+// time, and is never written to disk. The stream is carried over as is, still
+// serialized and compressed, so the new set decodes exactly like the old one.
+// None of the new collections is compatible with the old ones.
+func ReshareCollections(ctx context.Context, cfg ReshareConfig) error {
+	ctx, op := trace.StartOperation(ctx, "reshare")
+	defer op.End()
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	start := time.Now()
+	log.Infof("Starting reshare: InputDir=%s OutputDir=%s", cfg.InputDir, cfg.OutputDir)
+
+	// Validate input directory to ensure it exists and is accessible
+	if err := file.ValidateInputDirectory(ctx, cfg.InputDir); err != nil {
+		return err
+	}
+	if err := pad.CheckOffline(ctx, cfg.RNG); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	// Preparing the output would destroy the collections being read if either
+	// directory lies within the other
+	if len(cfg.Targets) == 0 {
+		inputAbs, err1 := filepath.Abs(cfg.InputDir)
+		outputAbs, err2 := filepath.Abs(cfg.OutputDir)
+		if err1 == nil && err2 == nil && (isWithinDir(inputAbs, outputAbs) || isWithinDir(outputAbs, inputAbs)) {
+			log.Error(fmt.Errorf("%w: the output directory and the input directory must not be the same or lie within one another", ErrInvalidConfig))
+			return fmt.Errorf("%w: the output directory and the input directory must not be the same or lie within one another", ErrInvalidConfig)
+		}
+	}
+
+	encodeCfg := EncodeConfig{
+		InputDir:        cfg.InputDir,
+		OutputDir:       cfg.OutputDir,
+		N:               cfg.N,
+		K:               cfg.K,
+		Format:          cfg.Format,
+		ChunkSize:       cfg.ChunkSize,
+		RNG:             cfg.RNG,
+		ClearIfNotEmpty: cfg.ClearIfNotEmpty,
+		Verbose:         cfg.Verbose,
+		ZipCollections:  cfg.ZipCollections,
+		TarCollections:  cfg.TarCollections,
+		VolumeSize:      cfg.VolumeSize,
+		Targets:         cfg.Targets,
+		Decoders:        cfg.Decoders,
+	}
+
+	// The decoder feeds the encoder through a pipe. Decoding starts only once the
+	// encoder has prepared its output and opens its input.
+	pr, pw := io.Pipe()
+	decodeDone := make(chan error, 1)
+	opened := false
+	err := encodeStream(ctx, encodeCfg, func() (io.ReadCloser, error) {
+		opened = true
+		go func() {
+			err := decodeCollections(ctx, cfg.InputDir, compressionRaw, false, func(ctx context.Context, r io.Reader) error {
+				_, err := io.Copy(pw, r)
+				return err
+			})
+			pw.CloseWithError(err)
+			decodeDone <- err
+		}()
+		return pr, nil
+	})
+
+	// Stop the decoder if the encoder gave up early, and report a decode failure
+	// in preference to the read error it caused in the encoder
+	pr.CloseWithError(errReshareStopped)
+	if opened {
+		if decodeErr := <-decodeDone; decodeErr != nil && !errors.Is(decodeErr, errReshareStopped) {
+			return decodeErr
+		}
+	}
+	if err != nil {
+		return outputFullError(err)
+	}
+
+	log.Infof("Reshare complete (%s) -copies %d -required %d -format %s", time.Since(start), cfg.N, cfg.K, cfg.Format)
+	return nil
+}
+
+// isWithinDir reports whether a cleaned absolute path is dir or lies beneath it
+func isWithinDir(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
@@ -0,0 +1,111 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestReshareCollections(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir, err := os.MkdirTemp("", "padlock-reshare-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputDir := filepath.Join(tempDir, "input")
+	oldDir := filepath.Join(tempDir, "old")
+	subsetDir := filepath.Join(tempDir, "subset")
+	newDir := filepath.Join(tempDir, "new")
+	newSubsetDir := filepath.Join(tempDir, "new-subset")
+	restoreDir := filepath.Join(tempDir, "restore")
+	for _, dir := range []string{inputDir, subsetDir, newSubsetDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+
+	// Spread the content over several chunks
+	testContent := strings.Repeat("reshared content\n", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Encode a 2-of-3 set and keep just two of its collections
+	encodeConfig := EncodeConfig{
+		InputDir:    inputDir,
+		OutputDir:   oldDir,
+		N:           3,
+		K:           2,
+		Format:      FormatBin,
+		ChunkSize:   1024,
+		RNG:         pad.NewDefaultRand(ctx),
+		Compression: CompressionGzip,
+	}
+	if err := EncodeDirectory(ctx, encodeConfig); err != nil {
+		t.Fatalf("Failed to encode directory: %v", err)
+	}
+	for _, coll := range []string{"2A3", "2C3"} {
+		if err := os.Rename(filepath.Join(oldDir, coll), filepath.Join(subsetDir, coll)); err != nil {
+			t.Fatalf("Failed to move collection %s: %v", coll, err)
+		}
+	}
+
+	// Reshare them into a 3-of-4 set
+	reshareConfig := ReshareConfig{
+		InputDir:  subsetDir,
+		OutputDir: newDir,
+		N:         4,
+		K:         3,
+		Format:    FormatPNG,
+		ChunkSize: 2048,
+		RNG:       pad.NewDefaultRand(ctx),
+	}
+	if err := ReshareCollections(ctx, reshareConfig); err != nil {
+		t.Fatalf("Failed to reshare collections: %v", err)
+	}
+
+	// Any three of the new collections restore the original data
+	for _, coll := range []string{"3B4", "3C4", "3D4"} {
+		if err := os.Rename(filepath.Join(newDir, coll), filepath.Join(newSubsetDir, coll)); err != nil {
+			t.Fatalf("Failed to move collection %s: %v", coll, err)
+		}
+	}
+	decodeConfig := DecodeConfig{
+		InputDir:    newSubsetDir,
+		OutputDir:   restoreDir,
+		Compression: CompressionGzip,
+	}
+	if err := DecodeDirectory(ctx, decodeConfig); err != nil {
+		t.Fatalf("Failed to decode reshared collections: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(restoreDir, "data.txt"))
+	if err != nil || string(data) != testContent {
+		t.Errorf("Restored data does not match the original (%v)", err)
+	}
+
+	// Resharing into the input directory, or into a directory holding it or
+	// within it, would destroy the collections being read
+	reshareConfig.ClearIfNotEmpty = true
+	for _, outputDir := range []string{subsetDir, filepath.Join(subsetDir, "new"), tempDir} {
+		reshareConfig.OutputDir = outputDir
+		if err := ReshareCollections(ctx, reshareConfig); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected an error resharing %s into %s, got %v", subsetDir, outputDir, err)
+		}
+	}
+	for _, coll := range []string{"2A3", "2C3"} {
+		if _, err := os.Stat(filepath.Join(subsetDir, coll)); err != nil {
+			t.Errorf("Expected collection %s to be left in place: %v", coll, err)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package padlock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// MaxSecretBytes is the largest secret SplitSecret accepts. Larger data is
+// encoded as a directory.
+const MaxSecretBytes = 16 * 1024
+
+// SecretEncoding is how the shares of a secret are written
+type SecretEncoding string
+
+// The encodings of secret shares
+const (
+	SecretBase64 SecretEncoding = "base64" // Text holding the share in base64, to paste or keep in a password manager
+	SecretPaper  SecretEncoding = "paper"  // Numbered lines of hex with a checksum each, to print and type back in
+	SecretBinary SecretEncoding = "bin"    // The share as is
+
+	// Words of the BIP-39 English wordlist, with a checksum, to write down or memorize
+	SecretMnemonic SecretEncoding = "mnemonic"
+)
+
+// secretShareTitle begins the first line of every share written as text
+const secretShareTitle = "padlock secret share"
+
+// paperLineBytes is the number of bytes of a share on each line of paper
+const paperLineBytes = 16
+
+// mnemonicNote follows the title of a share written as a mnemonic
+const mnemonicNote = "Write down the words in order; the first four letters of each are enough."
+
+// SplitSecretConfig holds configuration parameters for splitting a secret.
+// This structure is created by the command-line interface and passed to SplitSecret.
+type SplitSecretConfig struct {
+	Secret          []byte         // The secret to split, of at most MaxSecretBytes
+	OutputDir       string         // Path where one share file per collection is written
+	N               int            // Total number of shares to create (N value)
+	K               int            // Minimum shares required to join the secret (K value)
+	Encoding        SecretEncoding // How the shares are written (default: base64)
+	RNG             pad.RNG        // Random number generator for the pads of the shares
+	ClearIfNotEmpty bool           // Whether to clear the output directory if not empty
+}
+
+// JoinSecretConfig holds configuration parameters for joining a secret from its shares.
+// This structure is created by the command-line interface and passed to JoinSecret.
+type JoinSecretConfig struct {
+	Shares []string  // Paths of the share files, in any encoding and order, or "-" for a share read from Input
+	Input  io.Reader // Where a share given as "-" is read, such as a mnemonic typed in
+	Output io.Writer // Where the joined secret is written
+}
+
+// SplitSecret splits a short secret into N shares, any K of which join it
+// again with JoinSecret. Unlike EncodeDirectory, the secret is neither
+// archived nor compressed: each share is the single chunk of one collection,
+// written in its own file as padlock-secret-<collection>.txt (or .bin).
+func SplitSecret(ctx context.Context, cfg SplitSecretConfig) error {
+	ctx, op := trace.StartOperation(ctx, "split secret")
+	defer op.End()
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	log.Infof("Starting split: OutputDir=%s", cfg.OutputDir)
+
+	if cfg.Encoding == "" {
+		cfg.Encoding = SecretBase64
+	}
+	switch {
+	case len(cfg.Secret) == 0:
+		log.Error(fmt.Errorf("%w: the secret is empty", ErrInvalidConfig))
+		return fmt.Errorf("%w: the secret is empty", ErrInvalidConfig)
+	case len(cfg.Secret) > MaxSecretBytes:
+		log.Error(fmt.Errorf("%w: the secret is %d bytes, more than the %d of a secret; encode it as a directory instead", ErrInvalidConfig, len(cfg.Secret), MaxSecretBytes))
+		return fmt.Errorf("%w: the secret is %d bytes, more than the %d of a secret; encode it as a directory instead", ErrInvalidConfig, len(cfg.Secret), MaxSecretBytes)
+	case cfg.Encoding != SecretBase64 && cfg.Encoding != SecretPaper && cfg.Encoding != SecretMnemonic && cfg.Encoding != SecretBinary:
+		log.Error(fmt.Errorf("%w: share encoding must be %s, %s, %s or %s, got %q", ErrInvalidConfig, SecretBase64, SecretPaper, SecretMnemonic, SecretBinary, cfg.Encoding))
+		return fmt.Errorf("%w: share encoding must be %s, %s, %s or %s, got %q", ErrInvalidConfig, SecretBase64, SecretPaper, SecretMnemonic, SecretBinary, cfg.Encoding)
+	case cfg.RNG == nil:
+		log.Error(fmt.Errorf("%w: no random number generator", ErrInvalidConfig))
+		return fmt.Errorf("%w: no random number generator", ErrInvalidConfig)
+	}
+	if err := pad.CheckParameters(cfg.N, cfg.K); err != nil {
+		log.Error(err)
+		return err
+	}
+	if err := pad.CheckOffline(ctx, cfg.RNG); err != nil {
+		log.Error(err)
+		return err
+	}
+	if err := file.PrepareOutputDirectory(ctx, cfg.OutputDir, cfg.ClearIfNotEmpty); err != nil {
+		return err
+	}
+
+	// The secret fits in a single chunk, so each collection is one chunk
+	shares := make([]bytes.Buffer, cfg.N)
+	writers := make([]io.Writer, cfg.N)
+	for i := range shares {
+		writers[i] = &shares[i]
+	}
+	if err := pad.EncodeToWritersWithRNG(ctx, bytes.NewReader(cfg.Secret), writers, cfg.K, pad.DefaultChunkSize, cfg.RNG); err != nil {
+		log.Error(fmt.Errorf("failed to split secret: %w", err))
+		return fmt.Errorf("failed to split secret: %w", err)
+	}
+
+	for i := range shares {
+		collName := pad.CollectionName(cfg.K, cfg.N, i)
+		name, contents := "padlock-secret-"+collName+".txt", formatSecretShare(collName, cfg.K, cfg.N, shares[i].Bytes(), cfg.Encoding)
+		if cfg.Encoding == SecretBinary {
+			name = "padlock-secret-" + collName + ".bin"
+		}
+		path := filepath.Join(cfg.OutputDir, name)
+		if err := os.WriteFile(path, contents, 0600); err != nil {
+			log.Error(fmt.Errorf("failed to write share %s: %w", path, err))
+			return fmt.Errorf("failed to write share %s: %w", path, err)
+		}
+		log.Infof("Wrote share %s", path)
+	}
+	log.Infof("Split complete: any %d of the %d shares join the secret", cfg.K, cfg.N)
+	return nil
+}
+
+// JoinSecret joins the secret split by SplitSecret from K or more of its
+// shares, and writes it to cfg.Output
+func JoinSecret(ctx context.Context, cfg JoinSecretConfig) error {
+	ctx, op := trace.StartOperation(ctx, "join secret")
+	defer op.End()
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	log.Infof("Starting join: %d shares", len(cfg.Shares))
+
+	// Every share must be a different collection of the same split
+	var readers []io.Reader
+	var first pad.ChunkInfo
+	seen := make(map[string]string)
+	for i, path := range cfg.Shares {
+		share, err := readSecretShare(path, cfg.Input)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		info, err := pad.InspectChunk(share)
+		if err != nil {
+			log.Error(fmt.Errorf("%w: %s is not a secret share: %w", ErrInvalidConfig, path, err))
+			return fmt.Errorf("%w: %s is not a secret share: %w", ErrInvalidConfig, path, err)
+		}
+		if other, ok := seen[info.Collection]; ok {
+			log.Error(fmt.Errorf("%w: %s and %s are both share %s", ErrInvalidConfig, other, path, info.Collection))
+			return fmt.Errorf("%w: %s and %s are both share %s", ErrInvalidConfig, other, path, info.Collection)
+		}
+		seen[info.Collection] = path
+		if i == 0 {
+			first = info
+		} else if info.Session != first.Session || !sameSet(info.Collection, first.Collection) {
+			log.Error(fmt.Errorf("%w: %s is a share of a different secret than %s", ErrInvalidConfig, path, cfg.Shares[0]))
+			return fmt.Errorf("%w: %s is a share of a different secret than %s", ErrInvalidConfig, path, cfg.Shares[0])
+		}
+		readers = append(readers, bytes.NewReader(share))
+	}
+	if len(readers) == 0 {
+		log.Error(fmt.Errorf("%w: no shares", ErrInvalidConfig))
+		return fmt.Errorf("%w: no shares", ErrInvalidConfig)
+	}
+	k, n, _, err := pad.ParseCollectionName(first.Collection)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if len(readers) < k {
+		log.Error(fmt.Errorf("%w: %d shares given, but any %d of the %d are required", ErrInsufficientCollections, len(readers), k, n))
+		return fmt.Errorf("%w: %d shares given, but any %d of the %d are required", ErrInsufficientCollections, len(readers), k, n)
+	}
+
+	var secret bytes.Buffer
+	if err := pad.DecodeFromReaders(ctx, readers, &secret); err != nil {
+		log.Error(fmt.Errorf("failed to join secret: %w", err))
+		return fmt.Errorf("failed to join secret: %w", err)
+	}
+	defer pad.Zeroize(secret.Bytes())
+	if _, err := cfg.Output.Write(secret.Bytes()); err != nil {
+		log.Error(fmt.Errorf("failed to write secret: %w", err))
+		return fmt.Errorf("failed to write secret: %w", err)
+	}
+	log.Infof("Join complete: %d bytes", secret.Len())
+	return nil
+}
+
+// sameSet reports whether two collection names are of the same K-of-N set
+func sameSet(a, b string) bool {
+	ka, na, _, errA := pad.ParseCollectionName(a)
+	kb, nb, _, errB := pad.ParseCollectionName(b)
+	return errA == nil && errB == nil && ka == kb && na == nb
+}
+
+// formatSecretShare returns the contents of the file holding a share
+func formatSecretShare(collName string, k, n int, share []byte, encoding SecretEncoding) []byte {
+	if encoding == SecretBinary {
+		return share
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s (any %d of the %d shares join the secret)\n", secretShareTitle, collName, k, n)
+	if encoding == SecretBase64 {
+		text := base64.StdEncoding.EncodeToString(share)
+		for len(text) > 64 {
+			b.WriteString(text[:64] + "\n")
+			text = text[64:]
+		}
+		b.WriteString(text + "\n")
+		return []byte(b.String())
+	}
+
+	// A mnemonic is numbered, six words to a line
+	if encoding == SecretMnemonic {
+		b.WriteString(mnemonicNote + "\n")
+		words := encodeMnemonic(share)
+		for i, word := range words {
+			fmt.Fprintf(&b, "%3d %-9s", i+1, word)
+			if i%6 == 5 || i == len(words)-1 {
+				b.WriteString("\n")
+			}
+		}
+		lines := strings.Split(b.String(), "\n")
+		for i := range lines {
+			lines[i] = strings.TrimRight(lines[i], " ")
+		}
+		return []byte(strings.Join(lines, "\n"))
+	}
+
+	// On paper, each line is numbered and ends with a checksum of its number
+	// and bytes, so that a line typed back in wrongly is pointed out
+	b.WriteString("Type each line back in as is; the last group of each line is its checksum.\n")
+	for line := 0; line*paperLineBytes < len(share); line++ {
+		data := share[line*paperLineBytes : min((line+1)*paperLineBytes, len(share))]
+		digits := hex.EncodeToString(data)
+		var groups []string
+		for len(digits) > 4 {
+			groups = append(groups, digits[:4])
+			digits = digits[4:]
+		}
+		groups = append(groups, digits)
+		fmt.Fprintf(&b, "%03d  %s  %02x\n", line+1, strings.Join(groups, " "), paperChecksum(line+1, data))
+	}
+	return []byte(b.String())
+}
+
+// paperChecksum returns the checksum of one line of a share on paper
+func paperChecksum(line int, data []byte) byte {
+	return byte(crc32.ChecksumIEEE(append([]byte(strconv.Itoa(line)+":"), data...)))
+}
+
+// readSecretShare reads a share file, or a share from input if path is "-",
+// in any of the encodings SplitSecret writes, returning the share itself. A
+// mnemonic may also be typed in as its words alone.
+func readSecretShare(path string, input io.Reader) ([]byte, error) {
+	var contents []byte
+	var err error
+	if path == "-" && input != nil {
+		contents, err = io.ReadAll(input)
+	} else {
+		contents, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read share %s: %w", path, err)
+	}
+	if !bytes.HasPrefix(contents, []byte(secretShareTitle)) {
+		if words := mnemonicFields(string(contents)); len(words) > 0 {
+			return readMnemonicShare(path, words)
+		}
+		return contents, nil
+	}
+	if _, rest, _ := bytes.Cut(contents, []byte("\n")); bytes.HasPrefix(rest, []byte(mnemonicNote)) {
+		return readMnemonicShare(path, mnemonicFields(string(rest[len(mnemonicNote):])))
+	}
+
+	var base64Text strings.Builder
+	var share []byte
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Scan() // The title
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 0 || strings.HasPrefix(fields[0], "Type"):
+			continue
+		case len(fields) == 1:
+			base64Text.WriteString(fields[0])
+			continue
+		}
+
+		// A line of paper: its number, groups of hex and its checksum
+		line, err := strconv.Atoi(fields[0])
+		if err != nil || line != len(share)/paperLineBytes+1 {
+			return nil, fmt.Errorf("%w: share %s: line %q is out of order or mistyped", ErrInvalidConfig, path, scanner.Text())
+		}
+		data, err := hex.DecodeString(strings.Join(fields[1:len(fields)-1], ""))
+		checksum, checksumErr := hex.DecodeString(fields[len(fields)-1])
+		if err != nil || checksumErr != nil || len(checksum) != 1 || checksum[0] != paperChecksum(line, data) {
+			return nil, fmt.Errorf("%w: share %s: line %d is mistyped", ErrInvalidConfig, path, line)
+		}
+		share = append(share, data...)
+	}
+	if base64Text.Len() > 0 {
+		decoded, err := base64.StdEncoding.DecodeString(base64Text.String())
+		if err != nil {
+			return nil, fmt.Errorf("%w: share %s is not valid base64: %w", ErrInvalidConfig, path, err)
+		}
+		return decoded, nil
+	}
+	return share, nil
+}
+
+// readMnemonicShare returns the share held by the words of a mnemonic
+func readMnemonicShare(path string, words []string) ([]byte, error) {
+	share, err := decodeMnemonic(words)
+	if err != nil {
+		return nil, fmt.Errorf("%w: share %s: %w", ErrInvalidConfig, path, err)
+	}
+	return share, nil
+}
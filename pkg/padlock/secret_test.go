@@ -0,0 +1,138 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestSecret(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	secret := []byte("correct horse battery staple\n")
+
+	// split splits the secret into 3-of-5 shares and returns their paths
+	split := func(t *testing.T, encoding SecretEncoding) []string {
+		outputDir := t.TempDir()
+		err := SplitSecret(ctx, SplitSecretConfig{Secret: secret, OutputDir: outputDir, N: 5, K: 3, Encoding: encoding, RNG: pad.NewDefaultRand(ctx)})
+		if err != nil {
+			t.Fatalf("Failed to split secret: %v", err)
+		}
+		shares, err := filepath.Glob(filepath.Join(outputDir, "padlock-secret-*"))
+		if err != nil || len(shares) != 5 {
+			t.Fatalf("Expected 5 shares, got %v (%v)", shares, err)
+		}
+		return shares
+	}
+	join := func(shares ...string) ([]byte, error) {
+		var out bytes.Buffer
+		err := JoinSecret(ctx, JoinSecretConfig{Shares: shares, Input: strings.NewReader(""), Output: &out})
+		return out.Bytes(), err
+	}
+
+	t.Run("Encodings", func(t *testing.T) {
+		for _, encoding := range []SecretEncoding{SecretBase64, SecretPaper, SecretMnemonic, SecretBinary} {
+			shares := split(t, encoding)
+			for _, subset := range [][]string{shares[:3], {shares[4], shares[1], shares[3]}, shares} {
+				got, err := join(subset...)
+				if err != nil || !bytes.Equal(got, secret) {
+					t.Errorf("%s: joining %d shares gave %q, %v", encoding, len(subset), got, err)
+				}
+			}
+			if _, err := join(shares[:2]...); !errors.Is(err, ErrInsufficientCollections) {
+				t.Errorf("%s: expected two shares of 3-of-5 to be too few, got %v", encoding, err)
+			}
+		}
+	})
+
+	t.Run("Mistyped paper", func(t *testing.T) {
+		shares := split(t, SecretPaper)
+		contents, err := os.ReadFile(shares[0])
+		if err != nil {
+			t.Fatalf("Failed to read share: %v", err)
+		}
+		lines := strings.Split(string(contents), "\n")
+		typo := "ffff"
+		if lines[3][5:9] == typo {
+			typo = "0000"
+		}
+		lines[3] = lines[3][:5] + typo + lines[3][9:]
+		if err := os.WriteFile(shares[0], []byte(strings.Join(lines, "\n")), 0600); err != nil {
+			t.Fatalf("Failed to write share: %v", err)
+		}
+		if _, err := join(shares[:3]...); err == nil || !strings.Contains(err.Error(), "line 2 is mistyped") {
+			t.Errorf("Expected the mistyped line to be pointed out, got %v", err)
+		}
+	})
+
+	t.Run("Typed mnemonic", func(t *testing.T) {
+		shares := split(t, SecretMnemonic)
+		contents, err := os.ReadFile(shares[2])
+		if err != nil {
+			t.Fatalf("Failed to read share: %v", err)
+		}
+
+		// Only the numbered words are typed in, without the title
+		_, words, _ := strings.Cut(string(contents), mnemonicNote)
+		var out bytes.Buffer
+		err = JoinSecret(ctx, JoinSecretConfig{Shares: []string{shares[0], "-", shares[4]}, Input: strings.NewReader(words), Output: &out})
+		if err != nil || !bytes.Equal(out.Bytes(), secret) {
+			t.Errorf("Joining with a typed mnemonic gave %q, %v", out.Bytes(), err)
+		}
+	})
+
+	t.Run("Mixed splits", func(t *testing.T) {
+		first, second := split(t, SecretBase64), split(t, SecretBase64)
+		if _, err := join(first[0], first[1], second[2]); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected shares of different splits to be rejected, got %v", err)
+		}
+		if _, err := join(first[0], first[0], first[1]); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected a share given twice to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("Seeded", func(t *testing.T) {
+		// The pads of the shares come from the RNG given, so a seeded one
+		// reproduces them
+		splitSeeded := func() []byte {
+			outputDir := t.TempDir()
+			if err := SplitSecret(ctx, SplitSecretConfig{Secret: secret, OutputDir: outputDir, N: 3, K: 2, RNG: pad.NewTestRNG(7)}); err != nil {
+				t.Fatalf("Failed to split secret: %v", err)
+			}
+			data, err := os.ReadFile(filepath.Join(outputDir, "padlock-secret-2A3.txt"))
+			if err != nil {
+				t.Fatalf("Failed to read share: %v", err)
+			}
+			return data
+		}
+		if first, second := splitSeeded(), splitSeeded(); !bytes.Equal(first, second) {
+			t.Errorf("Expected the same seed to reproduce the share")
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, cfg := range map[string]SplitSecretConfig{
+			"empty":    {N: 3, K: 2},
+			"too long": {Secret: make([]byte, MaxSecretBytes+1), N: 3, K: 2},
+			"encoding": {Secret: secret, N: 3, K: 2, Encoding: "qr"},
+			"no RNG":   {Secret: secret, N: 3, K: 2},
+		} {
+			cfg.OutputDir = t.TempDir()
+			if name != "no RNG" {
+				cfg.RNG = pad.NewDefaultRand(ctx)
+			}
+			if err := SplitSecret(ctx, cfg); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("%s: expected ErrInvalidConfig, got %v", name, err)
+			}
+		}
+	})
+}
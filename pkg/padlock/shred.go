@@ -0,0 +1,49 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// shredInput replaces the plaintext input of an encode with its collections.
+// The collections just written are decoded, and every file archived from the
+// input is checked against the hash recorded for it as it was read; only if
+// all of them match are they overwritten and removed. Anything the encode did
+// not archive, such as excluded files and symlinks, is left in place.
+func shredInput(ctx context.Context, cfg EncodeConfig) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	start := time.Now()
+	log.Infof("Verifying that the collections restore %s before shredding it", cfg.InputDir)
+
+	var manifest *file.HashManifest
+	err := decodeWritten(ctx, cfg, func(readCtx context.Context, r io.Reader) error {
+		var err error
+		manifest, err = file.ReadHashManifest(readCtx, r)
+		return err
+	})
+	if err != nil {
+		log.Error(fmt.Errorf("not shredding the input: the collections could not be verified: %w", err))
+		return fmt.Errorf("not shredding the input: the collections could not be verified: %w", err)
+	}
+	if manifest == nil {
+		log.Error(fmt.Errorf("%w: not shredding the input: the collections hold no file hashes", ErrInvalidConfig))
+		return fmt.Errorf("%w: not shredding the input: the collections hold no file hashes", ErrInvalidConfig)
+	}
+	if err := file.VerifyRestored(ctx, manifest, cfg.InputDir, nil); err != nil {
+		log.Error(fmt.Errorf("not shredding the input, which differs from the collections: %w", err))
+		return fmt.Errorf("not shredding the input, which differs from the collections: %w", err)
+	}
+
+	log.Infof("Warning: shredding overwrites each input file before removing it, but SSDs, flash media, copy-on-write and journaling filesystems, snapshots and backups may keep copies of the plaintext that overwriting cannot reach")
+	n, err := file.ShredFiles(ctx, cfg.InputDir, manifest)
+	if err != nil {
+		return err
+	}
+	log.Infof("Shredded %d input files (%s)", n, time.Since(start))
+	return nil
+}
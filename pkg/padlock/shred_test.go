@@ -0,0 +1,108 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestShredInput(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+	content := strings.Repeat("wallet seed ", 500)
+	makeInput := func(t *testing.T, root string) string {
+		inputDir := filepath.Join(root, "input")
+		if err := os.MkdirAll(filepath.Join(inputDir, "keys"), 0755); err != nil {
+			t.Fatalf("Failed to create input dir: %v", err)
+		}
+		for _, name := range []string{"seed.txt", "keys/seed.txt", "notes.tmp"} {
+			if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to create test file: %v", err)
+			}
+		}
+		return inputDir
+	}
+	encodeConfig := func(inputDir, outputDir string) EncodeConfig {
+		return EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   outputDir,
+			N:           3,
+			K:           2,
+			Format:      FormatBin,
+			ChunkSize:   1024,
+			RNG:         pad.NewDefaultRand(ctx),
+			Compression: CompressionNone,
+			Serialize:   SerializeOptions{Exclude: []string{"*.tmp"}},
+			ShredInput:  true,
+		}
+	}
+
+	t.Run("Shredded", func(t *testing.T) {
+		root := t.TempDir()
+		inputDir := makeInput(t, root)
+		cfg := encodeConfig(inputDir, filepath.Join(root, "output"))
+		cfg.Destinations = map[string]string{"A": filepath.Join(root, "usb1")}
+		cfg.ZipCollections = true
+		if err := EncodeDirectory(ctx, cfg); err != nil {
+			t.Fatalf("EncodeDirectory failed: %v", err)
+		}
+
+		// Only the excluded file remains
+		entries, err := os.ReadDir(inputDir)
+		if err != nil {
+			t.Fatalf("Failed to read the input dir: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "notes.tmp" {
+			t.Errorf("Expected only the excluded file to remain, got %v", entries)
+		}
+
+		// The collections restore what was shredded
+		restored := filepath.Join(root, "restored")
+		if err := DecodeDirectory(ctx, DecodeConfig{InputDir: filepath.Join(root, "output"), OutputDir: restored, Compression: CompressionNone}); err != nil {
+			t.Fatalf("DecodeDirectory failed: %v", err)
+		}
+		for _, name := range []string{"seed.txt", "keys/seed.txt"} {
+			data, err := os.ReadFile(filepath.Join(restored, name))
+			if err != nil || string(data) != content {
+				t.Errorf("Restored %s differs: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		root := t.TempDir()
+		inputDir := makeInput(t, root)
+		cfg := encodeConfig(inputDir, filepath.Join(root, "output"))
+		cfg.ShredInput = false
+		cfg.Serialize.RecordHashes = true
+		if err := EncodeDirectory(ctx, cfg); err != nil {
+			t.Fatalf("EncodeDirectory failed: %v", err)
+		}
+
+		// An input that no longer matches its collections is not shredded
+		if err := os.WriteFile(filepath.Join(inputDir, "seed.txt"), []byte("changed"), 0644); err != nil {
+			t.Fatalf("Failed to change the input: %v", err)
+		}
+		if err := shredInput(ctx, cfg); !errors.Is(err, file.ErrHashMismatch) {
+			t.Fatalf("Expected ErrHashMismatch, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(inputDir, "keys", "seed.txt")); err != nil {
+			t.Errorf("Expected the input to be left in place, got %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		root := t.TempDir()
+		cfg := encodeConfig("", filepath.Join(root, "output"))
+		cfg.Inputs = []string{makeInput(t, root)}
+		if err := cfg.Validate(ctx); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected shredding several inputs to be refused, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// initialSnapshotName names the encode that created a set, once snapshots are
+// appended to it
+const initialSnapshotName = "initial"
+
+// encodeSnapshot encodes the stream returned by openInput as a snapshot named
+// cfg.Snapshot, appended to the chunks of the set of collections in
+// cfg.OutputDir. The snapshot is a separate encode, with pads of its own, that
+// is decoded on its own; the manifest of each collection records the chunks
+// that it and the snapshots before it occupy.
+func encodeSnapshot(ctx context.Context, cfg EncodeConfig, openInput func() (io.ReadCloser, error)) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	p, err := pad.NewPadForEncode(ctx, cfg.N, cfg.K)
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create pad instance: %w", err))
+		return err
+	}
+	p.PadToChunk, p.ExtraChunks = cfg.PadLength, cfg.PadChunks
+
+	// Every collection of the set is extended, so each must be present and end
+	// at the same chunk
+	found, tempDir, err := file.FindCollections(ctx, cfg.OutputDir)
+	if err != nil {
+		return err
+	}
+	defer file.CloseCollections(found)
+	defer file.RemoveTemp(ctx, tempDir)
+	collections := make(map[string]file.Collection)
+	for _, coll := range found {
+		collections[coll.Name] = coll
+	}
+	last := -1
+	for _, collName := range p.Collections {
+		coll, ok := collections[collName]
+		if !ok {
+			log.Error(fmt.Errorf("%w: collection %s of the set is not in %s", ErrInvalidConfig, collName, cfg.OutputDir))
+			return fmt.Errorf("%w: collection %s of the set is not in %s", ErrInvalidConfig, collName, cfg.OutputDir)
+		}
+		if coll.Zip || coll.Tar || coll.Store || coll.Format == file.FormatPacked || len(coll.Volumes) > 0 || file.HasParity(coll) {
+			log.Error(fmt.Errorf("%w: snapshots can only be appended to plain collection directories, not %s", ErrInvalidConfig, coll.Path))
+			return fmt.Errorf("%w: snapshots can only be appended to plain collection directories, not %s", ErrInvalidConfig, coll.Path)
+		}
+		numbers, err := file.ChunkNumbers(coll)
+		if err != nil {
+			return err
+		}
+		end := 0
+		if len(numbers) > 0 {
+			end = numbers[len(numbers)-1]
+		}
+		if last >= 0 && end != last {
+			log.Error(fmt.Errorf("%w: collection %s ends at chunk %d rather than %d; repair the set first", ErrInvalidConfig, collName, end, last))
+			return fmt.Errorf("%w: collection %s ends at chunk %d rather than %d; repair the set first", ErrInvalidConfig, collName, end, last)
+		}
+		last = end
+	}
+	if last == 0 {
+		log.Error(fmt.Errorf("%w: the collections in %s hold no chunks", ErrInvalidConfig, cfg.OutputDir))
+		return fmt.Errorf("%w: the collections in %s hold no chunks", ErrInvalidConfig, cfg.OutputDir)
+	}
+	first := collections[p.Collections[0]]
+
+	// The encode that created the set becomes its first snapshot
+	snapshots, err := file.ReadSnapshots(ctx, first)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		created := time.Now()
+		if info, err := os.Stat(first.Path); err == nil {
+			created = info.ModTime()
+		}
+		snapshots = []file.Snapshot{{Name: initialSnapshotName, Created: created.UTC(), FirstChunk: 1, LastChunk: last}}
+	}
+	for _, s := range snapshots {
+		if s.Name == cfg.Snapshot {
+			log.Error(fmt.Errorf("%w: the set already holds a snapshot named %s", ErrInvalidConfig, cfg.Snapshot))
+			return fmt.Errorf("%w: the set already holds a snapshot named %s", ErrInvalidConfig, cfg.Snapshot)
+		}
+	}
+
+	// The snapshot is written in the format and naming of the set
+	inputStream, err := openInput()
+	if err != nil {
+		return err
+	}
+	defer inputStream.Close()
+	written := 0
+	newChunkFunc := func(collectionName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+		coll, ok := collections[collectionName]
+		if !ok {
+			return nil, fmt.Errorf("collection not found: %s", collectionName)
+		}
+		written = max(written, chunkNumber)
+		return file.NewChunkWriter(ctx, file.GetNamedFormatter(coll.Format, coll.Naming), coll.Path, 0, last+chunkNumber), nil
+	}
+	log.Debugf("Starting snapshot encode after chunk %d with chunk size: %d", last, cfg.ChunkSize)
+	if err := p.Encode(ctx, cfg.ChunkSize, inputStream, cfg.RNG, newChunkFunc, string(first.Format)); err != nil {
+		log.Error(fmt.Errorf("encoding failed: %w", err))
+		return fmt.Errorf("encoding failed: %w", err)
+	}
+
+	// Record the snapshot in the manifest and index of each collection
+	snapshot := file.Snapshot{Name: cfg.Snapshot, Created: time.Now().UTC(), FirstChunk: last + 1, LastChunk: last + written}
+	snapshots = append(snapshots, snapshot)
+	for _, collName := range p.Collections {
+		coll := collections[collName]
+		m, err := file.ReadManifest(ctx, coll.Path)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			m = &file.Manifest{Collection: collName}
+		}
+		m.Snapshots = snapshots
+		if len(cfg.Metadata) > 0 {
+			m.Metadata = cfg.Metadata
+		}
+		if policy := cfg.policy(); policy != nil {
+			m.Policy = policy
+		}
+		if err := file.WriteManifest(ctx, coll.Path, *m); err != nil {
+			return err
+		}
+		if err := file.WriteIndex(ctx, coll); err != nil {
+			return err
+		}
+	}
+	log.Infof("Appended snapshot %s to %s as chunks %d-%d", cfg.Snapshot, filepath.Clean(cfg.OutputDir), snapshot.FirstChunk, snapshot.LastChunk)
+	return nil
+}
+
+// ListSnapshots returns the snapshots recorded in the set of collections in
+// inputDir, oldest first, or nil if it holds a single encode
+func ListSnapshots(ctx context.Context, inputDir string) ([]file.Snapshot, error) {
+	collections, tempDir, err := file.FindCollections(ctx, inputDir)
+	if err != nil {
+		return nil, err
+	}
+	defer file.CloseCollections(collections)
+	defer file.RemoveTemp(ctx, tempDir)
+	for _, coll := range collections {
+		snapshots, err := file.ReadSnapshots(ctx, coll)
+		if err != nil || len(snapshots) > 0 {
+			return snapshots, err
+		}
+	}
+	return nil, nil
+}
+
+// checkNoSnapshots fails if any of collections holds snapshots, for operations
+// that rewrite every chunk of a collection as a single encode
+func checkNoSnapshots(ctx context.Context, collections []file.Collection, operation string) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	for _, coll := range collections {
+		snapshots, err := file.ReadSnapshots(ctx, coll)
+		if err != nil {
+			return err
+		}
+		if len(snapshots) > 0 {
+			log.Error(fmt.Errorf("%w: collection %s holds snapshots, which cannot be %s; decode each snapshot instead", ErrInvalidConfig, coll.Name, operation))
+			return fmt.Errorf("%w: collection %s holds snapshots, which cannot be %s; decode each snapshot instead", ErrInvalidConfig, coll.Name, operation)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	encodedDir := filepath.Join(tempDir, "encoded")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+
+	// encodeVersion writes a version of the input and encodes it, as the set's
+	// first encode or as a snapshot appended to it
+	versions := map[string]string{}
+	encodeVersion := func(snapshot, content string) error {
+		if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		return EncodeDirectory(ctx, EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   encodedDir,
+			N:           3,
+			K:           2,
+			Format:      FormatBin,
+			ChunkSize:   1024,
+			RNG:         pad.NewDefaultRand(ctx),
+			Compression: CompressionGzip,
+			Snapshot:    snapshot,
+		})
+	}
+	for i, name := range []string{"", "monday", "tuesday"} {
+		content := strings.Repeat(name+" version\n", 400*(i+1))
+		if err := encodeVersion(name, content); err != nil {
+			t.Fatalf("Failed to encode snapshot %q: %v", name, err)
+		}
+		if name == "" {
+			name = initialSnapshotName
+		}
+		versions[name] = content
+	}
+
+	// decodeSnapshot decodes a snapshot from two of the three collections
+	decodeSnapshot := func(name string) (string, error) {
+		found, _, err := file.FindCollections(ctx, encodedDir)
+		if err != nil {
+			t.Fatalf("Failed to find collections: %v", err)
+		}
+		defer file.CloseCollections(found)
+		var subset []file.Collection
+		for _, coll := range found {
+			if coll.Name != "2B3" {
+				subset = append(subset, coll)
+			}
+		}
+		outputDir := filepath.Join(tempDir, "decoded")
+		err = DecodeDirectory(ctx, DecodeConfig{
+			Collections:     subset,
+			OutputDir:       outputDir,
+			Compression:     CompressionGzip,
+			ClearIfNotEmpty: true,
+			Strict:          true,
+			Snapshot:        name,
+		})
+		if err != nil {
+			return "", err
+		}
+		restored, err := os.ReadFile(filepath.Join(outputDir, "data.txt"))
+		return string(restored), err
+	}
+
+	t.Run("Recorded", func(t *testing.T) {
+		snapshots, err := ListSnapshots(ctx, encodedDir)
+		if err != nil {
+			t.Fatalf("Failed to list snapshots: %v", err)
+		}
+		var names []string
+		for i, s := range snapshots {
+			names = append(names, s.Name)
+			if i > 0 && s.FirstChunk != snapshots[i-1].LastChunk+1 {
+				t.Errorf("Snapshot %s starts at chunk %d, expected %d", s.Name, s.FirstChunk, snapshots[i-1].LastChunk+1)
+			}
+		}
+		if got := strings.Join(names, ","); got != "initial,monday,tuesday" {
+			t.Errorf("Expected snapshots initial,monday,tuesday, got %s", got)
+		}
+	})
+
+	t.Run("Decode each", func(t *testing.T) {
+		for name, content := range versions {
+			restored, err := decodeSnapshot(name)
+			if err != nil {
+				t.Fatalf("Failed to decode snapshot %s: %v", name, err)
+			}
+			if restored != content {
+				t.Errorf("Snapshot %s decoded to the wrong version", name)
+			}
+		}
+		restored, err := decodeSnapshot("")
+		if err != nil || restored != versions["tuesday"] {
+			t.Errorf("Expected the latest snapshot to be decoded by default, got %v", err)
+		}
+		if _, err := decodeSnapshot("sunday"); !errors.Is(err, ErrSnapshotNotFound) {
+			t.Errorf("Expected an unknown snapshot to fail with ErrSnapshotNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Duplicate name", func(t *testing.T) {
+		if err := encodeVersion("monday", "again"); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected a duplicate snapshot name to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("Incompatible", func(t *testing.T) {
+		err := EncodeDirectory(ctx, EncodeConfig{
+			InputDir:       inputDir,
+			OutputDir:      encodedDir,
+			N:              3,
+			K:              2,
+			Format:         FormatBin,
+			ChunkSize:      1024,
+			RNG:            pad.NewDefaultRand(ctx),
+			ZipCollections: true,
+			Snapshot:       "zipped",
+		})
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected a snapshot with zip to be rejected, got %v", err)
+		}
+		err = RefreshCollections(ctx, RefreshConfig{
+			InputDir:  encodedDir,
+			OutputDir: filepath.Join(tempDir, "refreshed"),
+			RNG:       pad.NewDefaultRand(ctx),
+		})
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected refreshing a set holding snapshots to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("Diagnose", func(t *testing.T) {
+		collections, _, err := file.FindCollections(ctx, encodedDir)
+		if err != nil {
+			t.Fatalf("Failed to find collections: %v", err)
+		}
+		defer file.CloseCollections(collections)
+		if d := diagnose(file.WithSnapshot(ctx, "monday"), collections); len(d.Problems) > 0 {
+			t.Errorf("Expected a snapshot to diagnose cleanly, got %v", d.Problems)
+		}
+	})
+}
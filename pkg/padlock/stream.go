@@ -0,0 +1,65 @@
+package padlock
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/rayozzie/padlock/pkg/paddecode"
+)
+
+// streamMagic begins the stream that padlock encodes from a directory, and is
+// followed by a byte recording how the archive after it is compressed, so that
+// decoding needs no encode-time options. Like the rest of the stream it is
+// encrypted, so the collections reveal nothing of it. Streams encoded before
+// it was recorded begin directly with the archive, and are decoded with the
+// compression configured for the decode.
+var streamMagic = paddecode.StreamMagic
+
+// The compression recorded in the stream header
+const (
+	streamCompressionNone = paddecode.StreamCompressionNone
+	streamCompressionGzip = paddecode.StreamCompressionGzip
+)
+
+// compressionRaw passes a decoded stream on as is, header and all, for
+// decoding that feeds another encode or decode rather than an archive reader
+const compressionRaw Compression = -1
+
+// streamHeader returns the header recording the compression of a stream
+func streamHeader(compression Compression) []byte {
+	code := byte(streamCompressionNone)
+	if compression == CompressionGzip {
+		code = streamCompressionGzip
+	}
+	return append(append([]byte{}, streamMagic...), code)
+}
+
+// readStreamHeader reads the header at the start of a decoded stream, if it
+// has one, and returns the compression it records and whether it had one.
+// The header is consumed, leaving br at the start of the archive.
+func readStreamHeader(br *bufio.Reader) (Compression, bool, error) {
+	header, _ := br.Peek(len(streamMagic) + 1)
+	if len(header) <= len(streamMagic) || !bytes.Equal(header[:len(streamMagic)], streamMagic) {
+		return 0, false, nil
+	}
+	var compression Compression
+	switch header[len(streamMagic)] {
+	case streamCompressionNone:
+		compression = CompressionNone
+	case streamCompressionGzip:
+		compression = CompressionGzip
+	default:
+		return 0, false, fmt.Errorf("%w: decoded stream records unknown compression %d", ErrNotArchive, header[len(streamMagic)])
+	}
+	if _, err := br.Discard(len(header)); err != nil {
+		return 0, false, err
+	}
+	return compression, true, nil
+}
+
+// withStreamHeader prefixes a stream with the header recording its compression
+func withStreamHeader(r io.ReadCloser, compression Compression) io.ReadCloser {
+	return readCloser{io.MultiReader(bytes.NewReader(streamHeader(compression)), r), r}
+}
@@ -0,0 +1,135 @@
+package padlock
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestStreamHeader(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	var compressed bytes.Buffer
+	gzw := gzip.NewWriter(&compressed)
+	gzw.Write([]byte("archive"))
+	gzw.Close()
+
+	// decodeAs hands stream to decodeStream and returns what the consumer reads
+	decodeAs := func(compression Compression, stream []byte) ([]byte, error) {
+		var got []byte
+		err := decodeStream(ctx, compression, true, func(ctx context.Context, r io.Reader) error {
+			var err error
+			got, err = io.ReadAll(r)
+			return err
+		}, func(w io.Writer) error {
+			_, err := w.Write(stream)
+			return err
+		})
+		return got, err
+	}
+
+	t.Run("Recorded", func(t *testing.T) {
+		for _, tc := range []struct {
+			name     string
+			recorded Compression
+			stream   []byte
+		}{
+			{"gzip", CompressionGzip, compressed.Bytes()},
+			{"none", CompressionNone, []byte("archive")},
+		} {
+			stream := append(streamHeader(tc.recorded), tc.stream...)
+			br := bufio.NewReader(bytes.NewReader(stream))
+			compression, ok, err := readStreamHeader(br)
+			if err != nil || !ok || compression != tc.recorded {
+				t.Errorf("%s: readStreamHeader = %v, %v, %v", tc.name, compression, ok, err)
+			}
+
+			// The recorded compression is used whatever the decode was configured with
+			for _, configured := range []Compression{CompressionNone, CompressionGzip} {
+				got, err := decodeAs(configured, stream)
+				if err != nil || string(got) != "archive" {
+					t.Errorf("%s: decoding as %s gave %q, %v", tc.name, compressionName(configured), got, err)
+				}
+			}
+		}
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		got, err := decodeAs(CompressionGzip, compressed.Bytes())
+		if err != nil || string(got) != "archive" {
+			t.Errorf("Decoding a legacy compressed stream gave %q, %v", got, err)
+		}
+		if _, err := decodeAs(CompressionGzip, []byte("archive")); !errors.Is(err, ErrNotArchive) {
+			t.Errorf("Expected a legacy stream not compressed as configured to fail strictly, got %v", err)
+		}
+	})
+
+	t.Run("Raw", func(t *testing.T) {
+		stream := append(streamHeader(CompressionGzip), compressed.Bytes()...)
+		got, err := decodeAs(compressionRaw, stream)
+		if err != nil || !bytes.Equal(got, stream) {
+			t.Errorf("Expected the raw stream to be passed on as is, got %q, %v", got, err)
+		}
+	})
+
+	t.Run("Unknown compression", func(t *testing.T) {
+		stream := append(streamHeader(CompressionNone), "archive"...)
+		stream[len(streamMagic)] = 9
+		if _, err := decodeAs(CompressionNone, stream); !errors.Is(err, ErrNotArchive) {
+			t.Errorf("Expected an unknown recorded compression to fail, got %v", err)
+		}
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		tempDir := t.TempDir()
+		inputDir := filepath.Join(tempDir, "input")
+		if err := os.MkdirAll(inputDir, 0755); err != nil {
+			t.Fatalf("Failed to create input dir: %v", err)
+		}
+		testContent := bytes.Repeat([]byte("recorded compression\n"), 200)
+		if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), testContent, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+
+		// A set encoded without compression decodes strictly with the default
+		encodeOutputDir := filepath.Join(tempDir, "encoded")
+		err := EncodeDirectory(ctx, EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   encodeOutputDir,
+			N:           3,
+			K:           2,
+			Format:      FormatBin,
+			ChunkSize:   1024,
+			RNG:         pad.NewDefaultRand(ctx),
+			Compression: CompressionNone,
+		})
+		if err != nil {
+			t.Fatalf("Failed to encode directory: %v", err)
+		}
+		decodeOutputDir := filepath.Join(tempDir, "decoded")
+		err = DecodeDirectory(ctx, DecodeConfig{
+			InputDir:    encodeOutputDir,
+			OutputDir:   decodeOutputDir,
+			Compression: CompressionGzip,
+			Strict:      true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to decode directory: %v", err)
+		}
+		restored, err := os.ReadFile(filepath.Join(decodeOutputDir, "data.txt"))
+		if err != nil || !bytes.Equal(restored, testContent) {
+			t.Errorf("Decoded data does not match the original: %v", err)
+		}
+	})
+}
@@ -0,0 +1,506 @@
+package padlock
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+)
+
+// TUIConfig holds configuration for the terminal interface
+type TUIConfig struct {
+	Roots       []string           // Directories searched when recovering from attached drives (default: DefaultRecoverRoots)
+	Compression Compression        // Compression mode used to encode, and assumed when decoding data that doesn't record it
+	RNG         pad.RNG            // Random number generator for the one-time pads of an encode
+	Serialize   SerializeOptions   // File attributes to preserve when encoding
+	Deserialize DeserializeOptions // File attributes to restore when decoding
+	Input       io.Reader          // Where the user's answers are read
+	Output      io.Writer          // Where the screens are drawn
+	ANSI        bool               // Whether Output is a terminal that understands ANSI escapes, used to clear screens and redraw progress in place
+}
+
+// tuiMaxEstimateRows limits the thresholds listed when estimating sizes
+const tuiMaxEstimateRows = 8
+
+// errEndOfInput ends the interface when the user's input is closed
+var errEndOfInput = errors.New("end of input")
+
+// tui is the state of the terminal interface
+type tui struct {
+	ctx context.Context
+	cfg TUIConfig
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// RunTUI runs a menu-driven terminal interface for users who would rather not
+// learn the commands, such as a custodian who has just received a collection.
+// It encodes a directory, asking for K and N with estimates of the space that
+// each choice takes; restores from collections in a directory; or searches
+// attached drives for collections as RecoverInteractive does. Progress is
+// shown as the data is written. Operations that fail are reported and the
+// menu shown again, until the user quits or the input ends.
+func RunTUI(ctx context.Context, cfg TUIConfig) error {
+	t := &tui{ctx: ctx, cfg: cfg, in: bufio.NewReader(cfg.Input), out: cfg.Output}
+	for {
+		t.screen("Padlock")
+		fmt.Fprintf(t.out, "Padlock splits a directory into collections, any K of which restore it,\n")
+		fmt.Fprintf(t.out, "while fewer than K reveal nothing about it.\n\n")
+		fmt.Fprintf(t.out, "  1  Encode a directory into collections\n")
+		fmt.Fprintf(t.out, "  2  Restore from collections in a directory\n")
+		fmt.Fprintf(t.out, "  3  Find collections on attached drives and restore them\n")
+		fmt.Fprintf(t.out, "  q  Quit\n\n")
+		choice, err := t.ask("Choose: ")
+		if err != nil {
+			return t.end(err)
+		}
+
+		switch strings.ToLower(choice) {
+		case "1":
+			err = t.encode()
+		case "2":
+			err = t.decode()
+		case "3":
+			err = t.recover()
+		case "q", "quit", "exit":
+			return nil
+		default:
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, errEndOfInput) {
+				return nil
+			}
+			fmt.Fprintf(t.out, "\n%s\n", t.highlight("Failed: "+err.Error()))
+		}
+		if _, err := t.ask("\nPress Enter to return to the menu "); err != nil {
+			return t.end(err)
+		}
+	}
+}
+
+// end returns the error that ends the interface, which is none when the
+// input simply ends
+func (t *tui) end(err error) error {
+	if errors.Is(err, errEndOfInput) {
+		return nil
+	}
+	return err
+}
+
+// screen starts a screen, clearing the terminal if it can
+func (t *tui) screen(title string) {
+	if t.cfg.ANSI {
+		fmt.Fprint(t.out, "\x1b[H\x1b[2J")
+	} else {
+		fmt.Fprintln(t.out)
+	}
+	fmt.Fprintf(t.out, "%s\n%s\n\n", t.highlight(title), strings.Repeat("=", len(title)))
+}
+
+// highlight returns text in bold, on a terminal that can show it
+func (t *tui) highlight(text string) string {
+	if t.cfg.ANSI {
+		return "\x1b[1m" + text + "\x1b[0m"
+	}
+	return text
+}
+
+// ask writes a prompt and returns the trimmed answer
+func (t *tui) ask(prompt string) (string, error) {
+	fmt.Fprint(t.out, prompt)
+	line, err := t.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		fmt.Fprintln(t.out)
+		if err == io.EOF {
+			return "", errEndOfInput
+		}
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// askDefault asks a question whose answer defaults to def
+func (t *tui) askDefault(prompt string, def string) (string, error) {
+	answer, err := t.ask(fmt.Sprintf("%s [%s]: ", prompt, def))
+	if answer == "" {
+		answer = def
+	}
+	return answer, err
+}
+
+// confirm asks a yes or no question
+func (t *tui) confirm(prompt string, def bool) (bool, error) {
+	options := "y/N"
+	if def {
+		options = "Y/n"
+	}
+	answer, err := t.ask(fmt.Sprintf("%s [%s] ", prompt, options))
+	if err != nil || answer == "" {
+		return def, err
+	}
+	return strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes"), nil
+}
+
+// askNumber asks for a number between min and max, asking again until given one
+func (t *tui) askNumber(prompt string, def, min, max int) (int, error) {
+	for {
+		answer, err := t.askDefault(prompt, strconv.Itoa(def))
+		if err != nil {
+			return 0, err
+		}
+		n, convErr := strconv.Atoi(answer)
+		if convErr == nil && n >= min && n <= max {
+			return n, nil
+		}
+		fmt.Fprintf(t.out, "Enter a number from %d to %d.\n", min, max)
+	}
+}
+
+// askDirectory asks for an existing directory, asking again until given one
+func (t *tui) askDirectory(prompt string) (string, error) {
+	for {
+		dir, err := t.ask(prompt)
+		if err != nil {
+			return "", err
+		}
+		if dir == "" {
+			continue
+		}
+		if info, statErr := os.Stat(dir); statErr != nil || !info.IsDir() {
+			fmt.Fprintf(t.out, "%s is not a directory.\n", dir)
+			continue
+		}
+		return dir, nil
+	}
+}
+
+// askOutputDirectory asks for the directory to write to, which may not exist
+// yet, and returns whether the user agreed to clear it if it isn't empty
+func (t *tui) askOutputDirectory(prompt string) (string, bool, error) {
+	for {
+		dir, err := t.ask(prompt)
+		if err != nil {
+			return "", false, err
+		}
+		if dir == "" {
+			continue
+		}
+		info, statErr := os.Stat(dir)
+		if statErr != nil {
+			return dir, false, nil
+		}
+		if !info.IsDir() {
+			fmt.Fprintf(t.out, "%s is not a directory.\n", dir)
+			continue
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) == 0 {
+			return dir, false, nil
+		}
+		clear, err := t.confirm(fmt.Sprintf("%s is not empty. Delete everything in it first?", dir), false)
+		if err != nil {
+			return "", false, err
+		}
+		if clear {
+			return dir, true, nil
+		}
+	}
+}
+
+// encode guides the user through encoding a directory
+func (t *tui) encode() error {
+	t.screen("Encode a directory")
+	inputDir, err := t.askDirectory("Directory to encode: ")
+	if err != nil {
+		return err
+	}
+	size, entries, err := directorySize(inputDir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(t.out, "  %d files and directories, %s\n\n", entries, formatBytes(size))
+
+	outputDir, clear, err := t.askOutputDirectory("Directory to write the collections to: ")
+	if err != nil {
+		return err
+	}
+
+	// The number of collections and the threshold, with the space each takes
+	fmt.Fprintf(t.out, "\nEach collection is given to a different person or kept in a different place.\n")
+	n, err := t.askNumber("How many collections (N)", 3, 2, 26)
+	if err != nil {
+		return err
+	}
+	t.writeEstimates(size, n)
+	k, err := t.askNumber("How many are needed to restore (K)", 2, 2, n)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(t.out, "  Any %d of the %d restore the data, so %d can be lost. Each collection holds about %s.\n\n",
+		k, n, n-k, formatBytes(estimateCollectionSize(size, n, k)))
+
+	format, err := t.askDefault("Format: png survives photo and cloud services, bin is smaller", "png")
+	if err != nil {
+		return err
+	}
+	for format != "png" && format != "bin" {
+		if format, err = t.askDefault("Enter png or bin", "png"); err != nil {
+			return err
+		}
+	}
+	zip, err := t.confirm("Zip each collection into a single file?", true)
+	if err != nil {
+		return err
+	}
+
+	archive := "directories"
+	if zip {
+		archive = "zips"
+	}
+	fmt.Fprintf(t.out, "\nEncode %s into %d %s %s in %s, any %d of which restore it.\n", inputDir, n, format, archive, outputDir, k)
+	if clear {
+		fmt.Fprintf(t.out, "Everything already in %s will be deleted.\n", outputDir)
+	}
+	if ok, err := t.confirm("Go ahead?", true); err != nil || !ok {
+		return err
+	}
+
+	cfg := EncodeConfig{
+		InputDir:        inputDir,
+		OutputDir:       outputDir,
+		N:               n,
+		K:               k,
+		Format:          FormatPNG,
+		ChunkSize:       2 * 1024 * 1024,
+		RNG:             t.cfg.RNG,
+		ClearIfNotEmpty: clear,
+		Compression:     t.cfg.Compression,
+		ZipCollections:  zip,
+		Serialize:       t.cfg.Serialize,
+	}
+	if format == "bin" {
+		cfg.Format = FormatBin
+	}
+	update, finish := t.progress("Encoding", estimateArchiveSize(size, entries))
+	cfg.Progress = update
+	err = EncodeDirectory(t.ctx, cfg)
+	finish()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(t.out, "\nDone. The collections are in %s:\n", outputDir)
+	if names, err := os.ReadDir(outputDir); err == nil {
+		for _, name := range names {
+			fmt.Fprintf(t.out, "  %s\n", name.Name())
+		}
+	}
+	fmt.Fprintf(t.out, "Give each to a different person or keep each in a different place. Any %d restore the data.\n", k)
+	return nil
+}
+
+// writeEstimates lists the size of each collection, and of all of them, for
+// the thresholds that N collections could have
+func (t *tui) writeEstimates(size int64, n int) {
+	fmt.Fprintf(t.out, "\n  %-10s %-18s %-18s %s\n", "K of N", "Each collection", "All collections", "Can be lost")
+	for k := 2; k <= n && k-2 < tuiMaxEstimateRows; k++ {
+		each := estimateCollectionSize(size, n, k)
+		fmt.Fprintf(t.out, "  %-10s %-18s %-18s %d\n", fmt.Sprintf("%d of %d", k, n), formatBytes(each), formatBytes(each*int64(n)), n-k)
+	}
+	if n-1 > tuiMaxEstimateRows {
+		fmt.Fprintf(t.out, "  ...\n")
+	}
+	fmt.Fprintf(t.out, "  Sizes are before compression, which shrinks most documents but not photos or video.\n\n")
+}
+
+// decode guides the user through restoring from collections in a directory
+func (t *tui) decode() error {
+	t.screen("Restore from collections")
+	fmt.Fprintf(t.out, "Put the collections you have, as directories or zips, in one directory.\n\n")
+	inputDir, err := t.askDirectory("Directory holding the collections: ")
+	if err != nil {
+		return err
+	}
+	d, err := diagnoseDirectory(t.ctx, inputDir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(t.out)
+	d.WriteReport(t.out)
+	if !d.Decodable() {
+		return d.Err()
+	}
+
+	fmt.Fprintln(t.out)
+	outputDir, clear, err := t.askOutputDirectory("Directory to restore the data to: ")
+	if err != nil {
+		return err
+	}
+	if ok, err := t.confirm(fmt.Sprintf("Restore to %s?", outputDir), true); err != nil || !ok {
+		return err
+	}
+
+	update, finish := t.progress("Restoring", 0)
+	err = DecodeDirectory(t.ctx, DecodeConfig{
+		InputDir:        inputDir,
+		OutputDir:       outputDir,
+		Compression:     t.cfg.Compression,
+		ClearIfNotEmpty: clear,
+		Deserialize:     t.cfg.Deserialize,
+		Progress:        update,
+	})
+	finish()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(t.out, "\nDone. The data is restored to %s\n", outputDir)
+	return nil
+}
+
+// recover searches attached drives for collections and restores them
+func (t *tui) recover() error {
+	t.screen("Find collections and restore them")
+	update, finish := t.progress("Restoring", 0)
+	defer finish()
+	return RecoverInteractive(t.ctx, RecoverConfig{
+		Roots:       t.cfg.Roots,
+		Compression: t.cfg.Compression,
+		Deserialize: t.cfg.Deserialize,
+		Input:       t.in,
+		Output:      t.out,
+		Progress:    update,
+	})
+}
+
+// progress returns a function drawing the progress of an operation toward
+// total bytes, or just the bytes so far if the total is unknown, and one that
+// ends the drawing. On a terminal the line is redrawn in place; otherwise a
+// line is written at each quarter of the total.
+func (t *tui) progress(label string, total int64) (func(n int64), func()) {
+	const width = 30
+	last, started := int64(-1), false
+	draw := func(n int64) {
+		var line string
+		if total > 0 {
+			percent := min(n*100/total, 99)
+			if percent == last || !t.cfg.ANSI && percent/25 == last/25 {
+				return
+			}
+			last = percent
+			filled := int(percent) * width / 100
+			line = fmt.Sprintf("%s [%s%s] %3d%%", label, strings.Repeat("#", filled), strings.Repeat("-", width-filled), percent)
+		} else {
+			// Without a total, the count is redrawn at each MiB
+			step := n >> 20
+			if step == last || !t.cfg.ANSI && started && step/64 == last/64 {
+				return
+			}
+			last = step
+			line = fmt.Sprintf("%s... %s", label, formatBytes(n))
+		}
+		started = true
+		if t.cfg.ANSI {
+			fmt.Fprintf(t.out, "\r\x1b[K%s", line)
+		} else {
+			fmt.Fprintln(t.out, line)
+		}
+	}
+	finish := func() {
+		if started && t.cfg.ANSI {
+			fmt.Fprintln(t.out)
+		}
+		started = false
+	}
+	return draw, finish
+}
+
+// directorySize returns the total size of the regular files under dir and the
+// number of entries under it, which determine the size of its archive
+func directorySize(dir string) (int64, int, error) {
+	var size int64
+	var entries int
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		entries++
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to measure %s: %w", dir, err)
+	}
+	return size, entries, nil
+}
+
+// inputsSize returns the total size of the files of the input of an encode,
+// and its number of entries, as for directorySize
+func inputsSize(cfg EncodeConfig) (int64, int, error) {
+	if len(cfg.Inputs) == 0 {
+		return directorySize(cfg.InputDir)
+	}
+	var size int64
+	var entries int
+	for _, input := range cfg.Inputs {
+		s, n, err := directorySize(input)
+		if err != nil {
+			return 0, 0, err
+		}
+		size, entries = size+s, entries+n
+	}
+	return size, entries, nil
+}
+
+// estimateArchiveSize estimates the size of the tar stream of a directory from
+// the size of its files and the number of entries, each of which has a header
+// and is padded to a whole block
+func estimateArchiveSize(size int64, entries int) int64 {
+	return size + int64(entries)*1024 + 1024
+}
+
+// estimateCollectionSize estimates the size of each of n collections with a
+// threshold of k encoding size bytes: every collection holds a piece of each
+// chunk for each of the combinations of k collections that it belongs to
+func estimateCollectionSize(size int64, n, k int) int64 {
+	return size * combinations(n-1, k-1)
+}
+
+// combinations returns the number of ways of choosing k of n
+func combinations(n, k int) int64 {
+	if k < 0 || k > n {
+		return 0
+	}
+	c := int64(1)
+	for i := 1; i <= k; i++ {
+		c = c * int64(n-k+i) / int64(i)
+	}
+	return c
+}
+
+// formatBytes formats a size for people, e.g. "3.4 MB"
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	value, exp := float64(n), 0
+	for value >= unit && exp < 5 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "kMGTP"[exp-1])
+}
@@ -0,0 +1,194 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestRunTUI(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "padlock-tui-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a context for this test
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	inputDir := filepath.Join(tempDir, "input")
+	encodedDir := filepath.Join(tempDir, "encoded")
+	restoredDir := filepath.Join(tempDir, "restored")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	testContent := strings.Repeat("handed to a custodian\n", 500)
+	if err := os.WriteFile(filepath.Join(inputDir, "data.txt"), []byte(testContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Encode 2 of 3 as zips, then restore from them, answering every question
+	answers := strings.Join([]string{
+		"1",                // Encode
+		"/nonexistent/dir", // Not a directory, so asked again
+		inputDir,
+		encodedDir,
+		"30",  // Too many, so asked again
+		"3",   // N
+		"",    // K defaults to 2
+		"bin", // Format
+		"",    // Zip
+		"y",   // Go ahead
+		"",    // Back to the menu
+		"2",   // Restore
+		encodedDir,
+		restoredDir,
+		"", // Restore
+		"", // Back to the menu
+		"q",
+	}, "\n") + "\n"
+
+	var out bytes.Buffer
+	err = RunTUI(ctx, TUIConfig{
+		Compression: CompressionGzip,
+		RNG:         pad.NewDefaultRand(ctx),
+		Serialize:   SerializeOptions{PreserveSymlinks: true},
+		Deserialize: DeserializeOptions{RestoreSymlinks: true, RestorePermissions: true},
+		Input:       strings.NewReader(answers),
+		Output:      &out,
+	})
+	if err != nil {
+		t.Fatalf("RunTUI failed: %v\n%s", err, out.String())
+	}
+
+	screens := out.String()
+	for _, want := range []string{
+		"/nonexistent/dir is not a directory.",
+		"Enter a number from 2 to 26.",
+		"2 of 3",
+		"Any 2 of the 3 restore the data, so 1 can be lost.",
+		"Done. The collections are in " + encodedDir,
+		"2A3.zip",
+		"No problems found",
+		"Done. The data is restored to " + restoredDir,
+	} {
+		if !strings.Contains(screens, want) {
+			t.Errorf("Output lacks %q:\n%s", want, screens)
+		}
+	}
+	if strings.Contains(screens, "\x1b[") {
+		t.Errorf("Output holds ANSI escapes although the terminal doesn't understand them")
+	}
+
+	restored, err := os.ReadFile(filepath.Join(restoredDir, "data.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read restored file: %v", err)
+	}
+	if string(restored) != testContent {
+		t.Errorf("Restored content does not match the original")
+	}
+
+	t.Run("Failure returns to the menu", func(t *testing.T) {
+		emptyDir := filepath.Join(tempDir, "empty")
+		if err := os.MkdirAll(emptyDir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		var out bytes.Buffer
+		err := RunTUI(ctx, TUIConfig{
+			Compression: CompressionGzip,
+			Input:       strings.NewReader("2\n" + emptyDir + "\n\nq\n"),
+			Output:      &out,
+		})
+		if err != nil {
+			t.Fatalf("RunTUI failed: %v", err)
+		}
+		if !strings.Contains(out.String(), "Failed: ") || strings.Count(out.String(), "Choose: ") != 2 {
+			t.Errorf("Failure was not reported before the menu was shown again:\n%s", out.String())
+		}
+	})
+
+	t.Run("End of input", func(t *testing.T) {
+		var out bytes.Buffer
+		err := RunTUI(ctx, TUIConfig{Input: strings.NewReader("1\n"), Output: &out})
+		if err != nil {
+			t.Errorf("RunTUI failed at the end of input: %v", err)
+		}
+	})
+}
+
+func TestTUIProgress(t *testing.T) {
+	t.Run("Terminal", func(t *testing.T) {
+		var out bytes.Buffer
+		ui := &tui{cfg: TUIConfig{ANSI: true}, out: &out}
+		update, finish := ui.progress("Encoding", 1000)
+		for n := int64(0); n <= 1000; n += 100 {
+			update(n)
+		}
+		finish()
+		if got := strings.Count(out.String(), "\r\x1b[K"); got != 11 {
+			t.Errorf("Progress was drawn %d times, want 11", got)
+		}
+		if !strings.Contains(out.String(), " 50%") || strings.Contains(out.String(), "100%") {
+			t.Errorf("Unexpected progress: %q", out.String())
+		}
+	})
+
+	t.Run("Plain", func(t *testing.T) {
+		var out bytes.Buffer
+		ui := &tui{out: &out}
+		update, finish := ui.progress("Encoding", 1000)
+		for n := int64(0); n <= 1000; n += 10 {
+			update(n)
+		}
+		finish()
+		if got := strings.Count(out.String(), "\n"); got != 3 {
+			t.Errorf("Progress was written on %d lines, want one at each quarter:\n%s", got, out.String())
+		}
+	})
+}
+
+func TestEstimateCollectionSize(t *testing.T) {
+	tests := []struct {
+		n, k int
+		want int64
+	}{
+		{2, 2, 1000},
+		{3, 2, 2000},
+		{4, 2, 3000},
+		{5, 3, 6000},
+		{26, 13, 1000 * 5200300},
+	}
+
+	for _, tt := range tests {
+		if got := estimateCollectionSize(1000, tt.n, tt.k); got != tt.want {
+			t.Errorf("estimateCollectionSize(1000, %d, %d) = %d, want %d", tt.n, tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 bytes"},
+		{999, "999 bytes"},
+		{1000, "1.0 kB"},
+		{3400000, "3.4 MB"},
+		{2500000000, "2.5 GB"},
+	}
+
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
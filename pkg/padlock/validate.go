@@ -0,0 +1,246 @@
+package padlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// Validate checks the configuration of an encode before any work begins,
+// returning every problem found, joined into one error, rather than only the
+// first. Each problem wraps ErrInvalidConfig, or ErrInvalidParameters for the N
+// and K of a set or its decoy collections. Only the options are checked, not
+// the directories they name, and a chunk size below MinChunkSize is not a
+// problem, as EncodeDirectory raises it. EncodeDirectory calls Validate itself,
+// so callers need only call it to report problems before doing any work.
+func (cfg EncodeConfig) Validate(ctx context.Context) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	var problems []error
+	invalid := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf("%w: "+format, append([]any{ErrInvalidConfig}, args...)...))
+	}
+
+	if cfg.InputDir == "" && len(cfg.Inputs) == 0 {
+		invalid("no input directory")
+	}
+	if cfg.InputDir != "" && len(cfg.Inputs) > 0 {
+		invalid("an input directory cannot be combined with inputs")
+	}
+	if cfg.OutputDir == "" && len(cfg.Targets) == 0 && len(cfg.Destinations) == 0 {
+		invalid("no output directory, targets or destinations")
+	}
+	if cfg.RNG == nil && cfg.PadsDir == "" {
+		invalid("no random number generator")
+	}
+	if cfg.Format != "" && cfg.Format != FormatBin && cfg.Format != FormatPNG && cfg.Format != FormatPacked {
+		invalid("format must be %s, %s or %s, got %q", FormatBin, FormatPNG, FormatPacked, cfg.Format)
+	} else if cfg.Format == FormatPacked && cfg.ChunkNaming != (file.ChunkNaming{}) {
+		invalid("chunks packed into one file cannot be named")
+	} else if err := cfg.ChunkNaming.CheckFormat(cfg.Format); err != nil {
+		invalid("%w", err)
+	}
+
+	// Check the N and K of each set, once the groups or custodians defining
+	// them are known to be valid, and the decoy collections of a single set
+	valid := true
+	switch {
+	case len(cfg.Groups) > 0 && len(cfg.Custodians) > 0:
+		invalid("groups cannot be combined with custodians")
+		valid = false
+	case len(cfg.Groups) > 0:
+		if err := validateGroupPolicies(cfg.Groups); err != nil {
+			invalid("invalid group policy: %w", err)
+			valid = false
+		}
+	case len(cfg.Custodians) > 0:
+		if err := validateCustodians(cfg.Custodians); err != nil {
+			invalid("invalid custodians: %w", err)
+			valid = false
+		}
+	case len(cfg.Targets) > 0 && len(cfg.Targets) != cfg.N:
+		invalid("%d targets given for %d collections", len(cfg.Targets), cfg.N)
+	}
+	if valid {
+		for _, set := range cfg.sets() {
+			if err := pad.CheckParameters(set.N, set.K); err != nil {
+				problems = append(problems, err)
+			} else if cfg.DecoyDir != "" && cfg.DecoyLetters != "" && len(cfg.Groups) == 0 && len(cfg.Custodians) == 0 {
+				if err := pad.CheckDecoy(set.N, set.K, cfg.DecoyLetters); err != nil {
+					problems = append(problems, err)
+				}
+			}
+		}
+	}
+
+	// Options that cannot be combined
+	if cfg.ZipCollections && cfg.TarCollections {
+		invalid("collections cannot be archived as both zip and tar")
+	}
+	if cfg.ObfuscateNames && (cfg.VolumeSize > 0 || len(cfg.Targets) > 0 || len(cfg.Groups) > 0 || len(cfg.Custodians) > 0 || len(cfg.Decoders) > 0) {
+		invalid("obfuscated names cannot be combined with volumes, targets, groups, custodians or decoders")
+	}
+	if cfg.StoreDir != "" && (cfg.ZipCollections || cfg.TarCollections || cfg.VolumeSize > 0 || len(cfg.Targets) > 0 || len(cfg.Custodians) > 0 || cfg.ObfuscateNames) {
+		invalid("a chunk store cannot be combined with archives, volumes, targets, custodians or obfuscated names")
+	}
+	if cfg.Format == FormatPacked && (cfg.ZipCollections || cfg.TarCollections || cfg.VolumeSize > 0 || len(cfg.Targets) > 0 || len(cfg.Custodians) > 0 || cfg.StoreDir != "" || cfg.ObfuscateNames || cfg.Snapshot != "") {
+		invalid("packed collections cannot be combined with archives, volumes, targets, custodians, a chunk store, obfuscated names or a snapshot")
+	}
+	if len(cfg.Targets) > 0 && len(cfg.Groups) > 0 {
+		invalid("targets cannot be combined with groups")
+	}
+	if (len(cfg.Targets) > 0 || cfg.VolumeSize > 0) && len(cfg.Custodians) > 0 {
+		invalid("targets and volumes cannot be combined with custodians")
+	}
+	if len(cfg.Targets) > 0 && cfg.VolumeSize > 0 {
+		invalid("targets cannot be combined with multi-volume collections")
+	}
+	if len(cfg.Destinations) > 0 {
+		if len(cfg.Targets) > 0 || len(cfg.Groups) > 0 || len(cfg.Custodians) > 0 || cfg.StoreDir != "" || cfg.Snapshot != "" || cfg.Hybrid != HybridNone {
+			invalid("destinations cannot be combined with targets, groups, custodians, a chunk store, a snapshot or hybrid encryption")
+		} else if err := validateDestinations(cfg.Destinations, cfg.N, cfg.OutputDir); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if cfg.ShredInput && (cfg.InputDir == "" || cfg.DecoyDir != "" || cfg.Serializer != nil || cfg.Serialize.FollowSymlinks) {
+		invalid("shredding the input requires a single input directory, and cannot be combined with a decoy, a serializer or following symlinks")
+	}
+	if cfg.DecoyDir != "" && (len(cfg.Groups) > 0 || len(cfg.Custodians) > 0) {
+		invalid("a decoy cannot be combined with groups or custodians")
+	}
+	if (cfg.DecoyDir == "") != (cfg.DecoyLetters == "") {
+		invalid("a decoy requires both its directory and the letters of the decoy collections")
+	}
+	if cfg.VolumeSize < 0 || (cfg.VolumeSize > 0 && cfg.VolumeSize <= int64(cfg.ChunkSize)) {
+		invalid("volume size %d must be larger than the chunk size %d", cfg.VolumeSize, cfg.ChunkSize)
+	}
+	if cfg.ParityPercent < 0 || cfg.ParityPercent > 100 {
+		invalid("parity must be between 0 and 100 percent, got %d", cfg.ParityPercent)
+	}
+	if cfg.ParityPercent > 0 && cfg.VolumeSize > 0 {
+		invalid("parity cannot be combined with multi-volume collections")
+	}
+	if len(cfg.Decoders) > 0 && cfg.VolumeSize > 0 {
+		invalid("decoders cannot be embedded in multi-volume collections")
+	}
+	platforms := make(map[string]bool)
+	for _, d := range cfg.Decoders {
+		if platforms[d.Platform()] {
+			invalid("more than one decoder for %s", d.Platform())
+		}
+		platforms[d.Platform()] = true
+	}
+	if cfg.PadChunks < 0 || (cfg.PadChunks > 0 && !cfg.PadLength) {
+		invalid("extra padding chunks must be zero or more and require length padding, got %d", cfg.PadChunks)
+	}
+	if cfg.Snapshot != "" && (cfg.ClearIfNotEmpty || cfg.ZipCollections || cfg.TarCollections || cfg.ObfuscateNames || cfg.StoreDir != "" || cfg.VolumeSize > 0 || len(cfg.Targets) > 0 || len(cfg.Groups) > 0 || len(cfg.Custodians) > 0 || cfg.ParityPercent > 0 || cfg.DecoyDir != "" || len(cfg.Decoders) > 0) {
+		invalid("a snapshot is appended to plain collection directories, and cannot be combined with clearing the output, archives, obfuscated names, a chunk store, volumes, targets, groups, custodians, parity, a decoy or decoders")
+	}
+	if cfg.Serializer != nil && len(cfg.Inputs) > 0 {
+		invalid("a serializer serializes a single input directory, not several inputs")
+	}
+	switch cfg.Hybrid {
+	case HybridNone, HybridShared, HybridReplicated:
+	default:
+		invalid("hybrid must be %s or %s, got %q", HybridShared, HybridReplicated, cfg.Hybrid)
+	}
+	if cfg.Hybrid != HybridNone && (cfg.ObfuscateNames || cfg.StoreDir != "" || cfg.VolumeSize > 0 || len(cfg.Targets) > 0 || len(cfg.Groups) > 0 || len(cfg.Custodians) > 0 || cfg.DecoyDir != "" || cfg.Snapshot != "") {
+		invalid("hybrid encryption keeps its data in a file of its own, and cannot be combined with obfuscated names, a chunk store, volumes, targets, groups, custodians, a decoy or a snapshot")
+	}
+	if len(cfg.Labels) > 0 {
+		if len(cfg.Groups) > 0 || cfg.Snapshot != "" {
+			invalid("labels name the collections of a single new set, and cannot be combined with groups or a snapshot")
+		} else if valid {
+			if err := validateLabels(cfg.Labels, cfg.sets()[0].N); err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+	if err := validateMetadata(cfg.Metadata); err != nil {
+		problems = append(problems, err)
+	}
+	if err := validatePolicyNotes(cfg.PolicyNotes); err != nil {
+		problems = append(problems, err)
+	}
+	if cfg.PadsDir != "" && (len(cfg.Groups) > 0 || len(cfg.Custodians) > 0 || cfg.Snapshot != "" || cfg.Hybrid != HybridNone || cfg.DecoyDir != "" || cfg.PadLength) {
+		invalid("pads encode a single set, and cannot be combined with groups, custodians, a snapshot, hybrid encryption, a decoy or length padding")
+	}
+	if cfg.Randomness != "" {
+		if _, err := pad.ParseRandomnessPolicy(string(cfg.Randomness)); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if cfg.PadsDir != "" && cfg.EntropyReport != "" {
+		invalid("pads draw no random numbers, so an encode with them has no entropy report")
+	}
+	if err := pad.CheckOffline(ctx, cfg.RNG); err != nil {
+		problems = append(problems, err)
+	}
+
+	if err := errors.Join(problems...); err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// sets returns the K-of-N sets an encode writes: one for each group of a
+// hierarchical encode, or else one, whose N is the sum of the weights of the
+// custodians if there are any
+func (cfg EncodeConfig) sets() []GroupPolicy {
+	switch {
+	case len(cfg.Groups) > 0:
+		return cfg.Groups
+	case len(cfg.Custodians) > 0:
+		return []GroupPolicy{{N: custodianCopies(cfg.Custodians), K: cfg.K}}
+	}
+	return []GroupPolicy{{N: cfg.N, K: cfg.K}}
+}
+
+// Validate checks the configuration of a decode before any work begins,
+// returning every problem found, joined into one error, each wrapping
+// ErrInvalidConfig. As with EncodeConfig, only the options are checked, and
+// DecodeDirectory calls Validate itself.
+func (cfg DecodeConfig) Validate(ctx context.Context) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+
+	var problems []error
+	invalid := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf("%w: "+format, append([]any{ErrInvalidConfig}, args...)...))
+	}
+
+	if cfg.InputDir == "" && len(cfg.Collections) == 0 {
+		invalid("no input directory or collections")
+	}
+	if cfg.OutputDir == "" && cfg.OutputWriter == nil {
+		invalid("no output directory or writer")
+	}
+	if err := file.ValidatePatterns(cfg.Deserialize.Files); err != nil {
+		invalid("invalid file pattern: %w", err)
+	}
+	if cfg.OutputWriter != nil && cfg.Deserialize.OnConflict != file.ConflictNone {
+		invalid("a conflict policy cannot be combined with an output writer, which restores no files")
+	}
+	if cfg.OutputWriter != nil && cfg.Serializer != nil {
+		invalid("an output writer extracts files from a tar stream, and cannot be combined with a serializer")
+	}
+	if cfg.TempDir != "" {
+		if info, err := os.Stat(cfg.TempDir); err != nil || !info.IsDir() {
+			invalid("temporary directory %s is not an existing directory", cfg.TempDir)
+		}
+	}
+	if err := validateMetadata(cfg.RequireMetadata); err != nil {
+		problems = append(problems, err)
+	}
+
+	if err := errors.Join(problems...); err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestEncodeConfigValidate(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	valid := func() EncodeConfig {
+		return EncodeConfig{
+			InputDir:  "input",
+			OutputDir: "output",
+			N:         5,
+			K:         3,
+			Format:    FormatBin,
+			ChunkSize: 1024,
+			RNG:       pad.NewDefaultRand(ctx),
+		}
+	}
+	if err := valid().Validate(ctx); err != nil {
+		t.Fatalf("Expected a valid configuration, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(cfg *EncodeConfig)
+		target error
+		want   string
+	}{
+		{"No input", func(cfg *EncodeConfig) { cfg.InputDir = "" }, ErrInvalidConfig, "no input directory"},
+		{"No RNG", func(cfg *EncodeConfig) { cfg.RNG = nil }, ErrInvalidConfig, "no random number generator"},
+		{"Unknown format", func(cfg *EncodeConfig) { cfg.Format = "gif" }, ErrInvalidConfig, "format must be"},
+		{"Too few copies", func(cfg *EncodeConfig) { cfg.N = 1 }, ErrInvalidParameters, "totalCopies"},
+		{"Required above copies", func(cfg *EncodeConfig) { cfg.K = 6 }, ErrInvalidParameters, "requiredCopies"},
+		{"Invalid group", func(cfg *EncodeConfig) {
+			cfg.Groups = []GroupPolicy{{Name: "a", N: 2, K: 2}, {Name: "b", N: 6, K: 7}}
+		}, ErrInvalidConfig, "group b"},
+		{"Bad decoy", func(cfg *EncodeConfig) { cfg.DecoyDir = "decoy"; cfg.DecoyLetters = "CDE" }, ErrInvalidParameters, "fewer than the 3 required"},
+		{"Decoy without letters", func(cfg *EncodeConfig) { cfg.DecoyDir = "decoy" }, ErrInvalidConfig, "decoy requires"},
+		{"Targets for too few", func(cfg *EncodeConfig) { cfg.Targets = []string{"a", "b"} }, ErrInvalidConfig, "2 targets given for 5"},
+		{"Zip and tar", func(cfg *EncodeConfig) { cfg.ZipCollections, cfg.TarCollections = true, true }, ErrInvalidConfig, "both zip and tar"},
+		{"Packed zip", func(cfg *EncodeConfig) { cfg.Format, cfg.ZipCollections = FormatPacked, true }, ErrInvalidConfig, "packed collections cannot be combined"},
+		{"Volume within a chunk", func(cfg *EncodeConfig) { cfg.VolumeSize = 512 }, ErrInvalidConfig, "volume size 512"},
+		{"Parity out of range", func(cfg *EncodeConfig) { cfg.ParityPercent = 101 }, ErrInvalidConfig, "parity must be"},
+		{"Extra chunks unpadded", func(cfg *EncodeConfig) { cfg.PadChunks = 3 }, ErrInvalidConfig, "extra padding chunks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(&cfg)
+			err := cfg.Validate(ctx)
+			if !errors.Is(err, tt.target) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected %v mentioning %q, got %v", tt.target, tt.want, err)
+			}
+		})
+	}
+
+	t.Run("Every problem", func(t *testing.T) {
+		cfg := valid()
+		cfg.K = 1
+		cfg.ParityPercent = -1
+		cfg.VolumeSize = 4096
+		cfg.Decoders = []file.Decoder{{OS: "linux", Arch: "amd64"}, {OS: "linux", Arch: "amd64"}}
+		err := cfg.Validate(ctx)
+		if !errors.Is(err, ErrInvalidConfig) || !errors.Is(err, ErrInvalidParameters) {
+			t.Fatalf("Expected both ErrInvalidConfig and ErrInvalidParameters, got %v", err)
+		}
+		for _, want := range []string{"requiredCopies", "parity must be", "decoders cannot be embedded", "more than one decoder"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Expected the error to mention %q, got %v", want, err)
+			}
+		}
+	})
+
+	t.Run("Checked before encoding", func(t *testing.T) {
+		// The problems are reported even though the input doesn't exist
+		cfg := valid()
+		cfg.Format = "gif"
+		if err := EncodeDirectory(ctx, cfg); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig, got %v", err)
+		}
+	})
+}
+
+func TestDecodeConfigValidate(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	if err := (DecodeConfig{InputDir: "input", OutputDir: "output"}).Validate(ctx); err != nil {
+		t.Fatalf("Expected a valid configuration, got %v", err)
+	}
+	if err := (DecodeConfig{InputDir: "input", OutputWriter: &bytes.Buffer{}}).Validate(ctx); err != nil {
+		t.Fatalf("Expected a valid configuration streaming to a writer, got %v", err)
+	}
+
+	cfg := DecodeConfig{
+		OutputWriter: &bytes.Buffer{},
+		Deserialize:  DeserializeOptions{Files: []string{"["}, OnConflict: file.ConflictSkip},
+	}
+	err := cfg.Validate(ctx)
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected ErrInvalidConfig, got %v", err)
+	}
+	for _, want := range []string{"no input directory", "invalid file pattern", "conflict policy"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %q, got %v", want, err)
+		}
+	}
+	if err := (DecodeConfig{InputDir: "input", OutputDir: "output", TempDir: "no-such-dir"}).Validate(ctx); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected a missing temporary directory to be invalid, got %v", err)
+	}
+	if err := DecodeDirectory(ctx, DecodeConfig{InputDir: "input"}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected DecodeDirectory to reject a decode without output, got %v", err)
+	}
+}
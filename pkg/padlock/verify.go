@@ -0,0 +1,95 @@
+package padlock
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// verifyEncode reads back the collections an encode has just written: K of
+// them are decoded, and the SHA-256 of the archive they reconstruct is
+// compared with that of the archive that was encoded, want. This proves the
+// collections decode before they are handed out, beyond the check of each
+// chunk as it is written.
+func verifyEncode(ctx context.Context, cfg EncodeConfig, want []byte) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	start := time.Now()
+	log.Infof("Verifying that the collections decode to the input")
+
+	h := sha256.New()
+	var n int64
+	err := decodeWritten(ctx, cfg, func(readCtx context.Context, r io.Reader) error {
+		var err error
+		n, err = io.Copy(h, r)
+		return err
+	})
+	if err != nil {
+		log.Error(fmt.Errorf("%w: %w", ErrVerifyFailed, err))
+		return fmt.Errorf("%w: %w", ErrVerifyFailed, err)
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want) {
+		log.Error(fmt.Errorf("%w: the decoded archive has SHA-256 %s, but the input's was %s", ErrVerifyFailed, hex.EncodeToString(got), hex.EncodeToString(want)))
+		return fmt.Errorf("%w: the decoded archive has SHA-256 %s, but the input's was %s", ErrVerifyFailed, hex.EncodeToString(got), hex.EncodeToString(want))
+	}
+	log.Infof("Verified: the collections decode to the input archive, %d bytes with SHA-256 %s (%s)", n, hex.EncodeToString(want), time.Since(start))
+	return nil
+}
+
+// decodeWritten decodes the collections an encode has just written, wherever
+// it wrote them, handing the reconstructed stream to consume as for
+// decodeCollections. Only K collections are read, the first of those that are
+// not decoys, so that the decode shows that K of them suffice; a hierarchical
+// set is decoded from all of its groups. The decode is strict, so that
+// anything unexpected fails it.
+func decodeWritten(ctx context.Context, cfg EncodeConfig, consume func(ctx context.Context, r io.Reader) error) error {
+	log := trace.FromContext(ctx).WithPrefix("PADLOCK")
+	if len(cfg.Groups) > 0 {
+		return decodeCollections(ctx, cfg.OutputDir, cfg.Compression, true, consume)
+	}
+
+	roots := []string{cfg.OutputDir}
+	if len(cfg.Targets) > 0 {
+		roots = cfg.Targets
+	} else if len(cfg.Destinations) > 0 {
+		roots = destinationRoots(cfg)
+	}
+	var collections []file.Collection
+	defer func() { file.CloseCollections(collections) }()
+	for _, root := range roots {
+		found, tempDir, err := file.FindCollections(ctx, root)
+		if err != nil {
+			return err
+		}
+		defer file.RemoveTemp(ctx, tempDir)
+		collections = append(collections, found...)
+	}
+	if len(collections) == 0 {
+		return fmt.Errorf("%w in %s", ErrNoCollections, strings.Join(roots, ", "))
+	}
+
+	// Choose K collections in order of their letters, passing over decoys
+	sort.Slice(collections, func(i, j int) bool { return collections[i].Name < collections[j].Name })
+	var chosen []file.Collection
+	var names []string
+	for _, coll := range collections {
+		if _, _, letter, err := pad.ParseCollectionName(coll.Name); err == nil && strings.Contains(cfg.DecoyLetters, letter) {
+			continue
+		}
+		if len(chosen) < cfg.K {
+			chosen = append(chosen, coll)
+			names = append(names, coll.Name)
+		}
+	}
+	log.Infof("Decoding collections %s", strings.Join(names, ", "))
+	return decodeFound(ctx, chosen, cfg.Compression, true, consume)
+}
@@ -0,0 +1,117 @@
+package padlock
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/file"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestVerifyEncode(t *testing.T) {
+	ctx := trace.WithContext(context.Background(), trace.NewTracer("TEST", trace.LogLevelError))
+	tempDir := t.TempDir()
+	inputDir := filepath.Join(tempDir, "input")
+	decoyDir := filepath.Join(tempDir, "decoy")
+	for _, dir := range []string{inputDir, decoyDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create input dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "seed.txt"), []byte(strings.Repeat(filepath.Base(dir)+" seed ", 500)), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	custodians, err := ParseCustodians("ceo:2,cfo,cto")
+	if err != nil {
+		t.Fatalf("ParseCustodians failed: %v", err)
+	}
+	groups, err := ParseGroupPolicies("board:2of3,engineers:2of2")
+	if err != nil {
+		t.Fatalf("ParseGroupPolicies failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(cfg *EncodeConfig, root string)
+	}{
+		{"Plain", func(cfg *EncodeConfig, root string) {}},
+		{"Gzip zips", func(cfg *EncodeConfig, root string) {
+			cfg.Compression = CompressionGzip
+			cfg.ZipCollections = true
+		}},
+		{"Volumes", func(cfg *EncodeConfig, root string) { cfg.VolumeSize = 8192 }},
+		{"Destinations", func(cfg *EncodeConfig, root string) {
+			cfg.Destinations = map[string]string{"A": filepath.Join(root, "usb1")}
+		}},
+		{"Custodians", func(cfg *EncodeConfig, root string) { cfg.Custodians = custodians }},
+		{"Groups", func(cfg *EncodeConfig, root string) { cfg.Groups = groups }},
+		{"Hybrid", func(cfg *EncodeConfig, root string) { cfg.Hybrid = HybridShared }},
+		{"Decoy", func(cfg *EncodeConfig, root string) {
+			cfg.DecoyDir = decoyDir
+			cfg.DecoyLetters = "A"
+		}},
+		{"Obfuscated", func(cfg *EncodeConfig, root string) { cfg.ObfuscateNames = true }},
+		{"Packed", func(cfg *EncodeConfig, root string) {
+			cfg.Format = FormatPacked
+			cfg.Metadata = map[string]string{"owner": "treasury"}
+		}},
+		{"Packed parity", func(cfg *EncodeConfig, root string) {
+			cfg.Format = FormatPacked
+			cfg.ParityPercent = 20
+		}},
+		{"Packed groups", func(cfg *EncodeConfig, root string) {
+			cfg.Format = FormatPacked
+			cfg.Groups = groups
+		}},
+		{"Packed hybrid", func(cfg *EncodeConfig, root string) {
+			cfg.Format = FormatPacked
+			cfg.Hybrid = HybridReplicated
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := filepath.Join(tempDir, strings.ReplaceAll(tt.name, " ", "-"))
+			cfg := EncodeConfig{
+				InputDir:    inputDir,
+				OutputDir:   filepath.Join(root, "output"),
+				N:           3,
+				K:           2,
+				Format:      FormatBin,
+				ChunkSize:   1024,
+				RNG:         pad.NewDefaultRand(ctx),
+				Compression: CompressionNone,
+				Verify:      true,
+			}
+			tt.modify(&cfg, root)
+			if err := EncodeDirectory(ctx, cfg); err != nil {
+				t.Fatalf("EncodeDirectory failed: %v", err)
+			}
+			if cfg.Format == FormatPacked {
+				packed, _ := filepath.Glob(filepath.Join(cfg.OutputDir, "*"+file.PackedSuffix))
+				grouped, _ := filepath.Glob(filepath.Join(cfg.OutputDir, "*", "*"+file.PackedSuffix))
+				if len(packed)+len(grouped) != cfg.N && len(grouped) != 5 {
+					t.Errorf("Expected a packed file per collection, got %v %v", packed, grouped)
+				}
+			}
+		})
+	}
+
+	t.Run("Mismatch", func(t *testing.T) {
+		cfg := EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   filepath.Join(tempDir, "Plain", "output"),
+			K:           2,
+			Compression: CompressionNone,
+		}
+		want := sha256.Sum256([]byte("something else"))
+		if err := verifyEncode(ctx, cfg, want[:]); !errors.Is(err, ErrVerifyFailed) {
+			t.Errorf("Expected ErrVerifyFailed, got %v", err)
+		}
+	})
+}
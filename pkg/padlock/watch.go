@@ -0,0 +1,185 @@
+package padlock
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// DefaultWatchDelay is how long the input directory must be quiet after a
+// change before a new collection set is encoded
+const DefaultWatchDelay = 5 * time.Second
+
+// watchSetTimeFormat names each collection set produced in watch mode after the
+// time it was encoded, so that the sets sort chronologically
+const watchSetTimeFormat = "20060102-150405"
+
+// WatchConfig holds configuration parameters for watch mode.
+// This structure is created by the command-line interface and passed to WatchDirectory.
+type WatchConfig struct {
+	Encode EncodeConfig  // Encoding parameters; each set is written to a dated subdirectory of Encode.OutputDir
+	Delay  time.Duration // Quiet period after a change before re-encoding (default: DefaultWatchDelay)
+}
+
+// WatchDirectory encodes the input directory into a new, dated collection set
+// under the output directory, then watches the input tree and encodes another
+// set each time it changes, until the context is cancelled.
+//
+// Changes are debounced: a set is encoded once no further change has been seen
+// for the configured delay, so a burst of edits produces a single set. Each set
+// is a complete encoding of the tree and can be decoded on its own.
+func WatchDirectory(ctx context.Context, cfg WatchConfig) error {
+	log := trace.FromContext(ctx).WithPrefix("WATCH")
+
+	if err := cfg.Encode.Validate(ctx); err != nil {
+		return err
+	}
+	if len(cfg.Encode.Targets) > 0 {
+		log.Error(fmt.Errorf("%w: watch mode cannot write to targets", ErrInvalidConfig))
+		return fmt.Errorf("%w: watch mode cannot write to targets", ErrInvalidConfig)
+	}
+	if len(cfg.Encode.Inputs) > 0 {
+		log.Error(fmt.Errorf("%w: watch mode encodes a single input directory", ErrInvalidConfig))
+		return fmt.Errorf("%w: watch mode encodes a single input directory", ErrInvalidConfig)
+	}
+	if cfg.Delay <= 0 {
+		cfg.Delay = DefaultWatchDelay
+	}
+	outputRoot, err := filepath.Abs(cfg.Encode.OutputDir)
+	if err != nil {
+		log.Error(fmt.Errorf("invalid output directory %s: %w", cfg.Encode.OutputDir, err))
+		return fmt.Errorf("invalid output directory %s: %w", cfg.Encode.OutputDir, err)
+	}
+	if err := os.MkdirAll(outputRoot, 0755); err != nil {
+		log.Error(fmt.Errorf("failed to create output directory %s: %w", outputRoot, err))
+		return fmt.Errorf("failed to create output directory %s: %w", outputRoot, err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error(fmt.Errorf("failed to create file watcher: %w", err))
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	// Watches are per directory, so every directory in the tree is added, skipping
+	// the output directory in case it lies within the input
+	addTree := func(root string) error {
+		return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				log.Infof("Warning: cannot watch %s: %v", path, err)
+				return nil
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if abs, err := filepath.Abs(path); err == nil && abs == outputRoot {
+				return filepath.SkipDir
+			}
+			if err := watcher.Add(path); err != nil {
+				return fmt.Errorf("failed to watch %s: %w", path, err)
+			}
+			return nil
+		})
+	}
+	if err := addTree(cfg.Encode.InputDir); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	// encodeSet encodes the tree into a new dated subdirectory of the output
+	// directory. The set is encoded into a hidden sibling and renamed into place
+	// once complete, so that a set appears only when it can be decoded and an
+	// encode that fails leaves nothing behind.
+	encodeSet := func() error {
+		setDir := watchSetDir(outputRoot, time.Now())
+		partialDir := filepath.Join(outputRoot, "."+filepath.Base(setDir)+".partial")
+		if err := os.RemoveAll(partialDir); err != nil {
+			log.Error(fmt.Errorf("failed to remove %s: %w", partialDir, err))
+			return fmt.Errorf("failed to remove %s: %w", partialDir, err)
+		}
+		setCfg := cfg.Encode
+		setCfg.OutputDir = partialDir
+		setCfg.ClearIfNotEmpty = false
+		if err := EncodeDirectory(ctx, setCfg); err != nil {
+			os.RemoveAll(partialDir)
+			return err
+		}
+		if err := os.Rename(partialDir, setDir); err != nil {
+			os.RemoveAll(partialDir)
+			log.Error(fmt.Errorf("failed to move collection set into place at %s: %w", setDir, err))
+			return fmt.Errorf("failed to move collection set into place at %s: %w", setDir, err)
+		}
+		log.Infof("Encoded collection set %s", setDir)
+		return nil
+	}
+	if err := encodeSet(); err != nil {
+		return err
+	}
+
+	log.Infof("Watching %s for changes", cfg.Encode.InputDir)
+	timer := time.NewTimer(cfg.Delay)
+	timer.Stop()
+	pending := false
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("Stopped watching %s", cfg.Encode.InputDir)
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if abs, err := filepath.Abs(event.Name); err == nil && (abs == outputRoot || strings.HasPrefix(abs, outputRoot+string(filepath.Separator))) {
+				continue
+			}
+			log.Debugf("Change: %s", event)
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := addTree(event.Name); err != nil {
+						log.Infof("Warning: %v", err)
+					}
+				}
+			}
+			timer.Reset(cfg.Delay)
+			pending = true
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Infof("Warning: file watcher error: %v", err)
+
+		case <-timer.C:
+			if !pending {
+				continue
+			}
+			pending = false
+			if err := encodeSet(); err != nil {
+				// Keep watching: the next change may well succeed
+				log.Error(fmt.Errorf("failed to encode collection set: %w", err))
+			}
+		}
+	}
+}
+
+// watchSetDir returns an unused directory name for a collection set encoded at the given time
+func watchSetDir(outputRoot string, t time.Time) string {
+	base := filepath.Join(outputRoot, t.Format(watchSetTimeFormat))
+	dir := base
+	for i := 2; ; i++ {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return dir
+		}
+		dir = fmt.Sprintf("%s-%d", base, i)
+	}
+}
@@ -0,0 +1,122 @@
+package padlock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestWatchDirectory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	inputDir, err := os.MkdirTemp("", "padlock-watch-input-*")
+	if err != nil {
+		t.Fatalf("Failed to create input temp dir: %v", err)
+	}
+	defer os.RemoveAll(inputDir)
+
+	outputDir, err := os.MkdirTemp("", "padlock-watch-output-*")
+	if err != nil {
+		t.Fatalf("Failed to create output temp dir: %v", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	if err := os.WriteFile(filepath.Join(inputDir, "a.txt"), []byte("first"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	cfg := WatchConfig{
+		Encode: EncodeConfig{
+			InputDir:    inputDir,
+			OutputDir:   outputDir,
+			N:           2,
+			K:           2,
+			Format:      FormatBin,
+			ChunkSize:   1024,
+			RNG:         pad.NewDefaultRand(ctx),
+			Compression: CompressionGzip,
+		},
+		Delay: 50 * time.Millisecond,
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- WatchDirectory(ctx, cfg)
+	}()
+
+	// waitForSets waits until the output directory holds the given number of
+	// sets, passing over the hidden directory of a set still being encoded
+	waitForSets := func(want int) []os.DirEntry {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			entries, _ := os.ReadDir(outputDir)
+			var sets []os.DirEntry
+			for _, entry := range entries {
+				if !strings.HasPrefix(entry.Name(), ".") {
+					sets = append(sets, entry)
+				}
+			}
+			if len(sets) >= want || time.Now().After(deadline) {
+				return sets
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// The initial set is encoded at startup
+	if sets := waitForSets(1); len(sets) != 1 {
+		t.Fatalf("Expected 1 initial collection set, got %d", len(sets))
+	}
+
+	// A burst of changes, including a new directory, produces one further set
+	if err := os.Mkdir(filepath.Join(inputDir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "sub", "b.txt"), []byte("second"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	sets := waitForSets(2)
+	if len(sets) != 2 {
+		t.Fatalf("Expected 2 collection sets after a change, got %d", len(sets))
+	}
+
+	// The latest set decodes to the changed tree
+	decodeDir, err := os.MkdirTemp("", "padlock-watch-decode-*")
+	if err != nil {
+		t.Fatalf("Failed to create decode temp dir: %v", err)
+	}
+	defer os.RemoveAll(decodeDir)
+	decodeCfg := DecodeConfig{
+		InputDir:    filepath.Join(outputDir, sets[len(sets)-1].Name()),
+		OutputDir:   decodeDir,
+		Compression: CompressionGzip,
+	}
+	if err := DecodeDirectory(ctx, decodeCfg); err != nil {
+		t.Fatalf("Failed to decode latest set: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(decodeDir, "sub", "b.txt"))
+	if err != nil || string(data) != "second" {
+		t.Errorf("Expected latest set to contain sub/b.txt, got %q (%v)", string(data), err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("WatchDirectory returned error: %v", err)
+	}
+
+	// Nothing of an encode in progress is left behind
+	entries, _ := os.ReadDir(outputDir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			t.Errorf("Unexpected %s left in the output directory", entry.Name())
+		}
+	}
+}
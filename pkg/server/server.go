@@ -0,0 +1,553 @@
+// Package server exposes the padlock threshold scheme as a local HTTP service,
+// so that programs written in other languages can encode, decode and verify
+// data without shelling out to the command line tool.
+//
+// Endpoints:
+//
+//	GET  /v1/health                       Service status
+//	POST /v1/encode?copies=N&required=K   Body: raw data, as application/octet-stream.
+//	                                      Response: multipart/mixed with one part per
+//	                                      chunk of each collection stream
+//	POST /v1/decode                       Body: multipart/form-data with one file part per
+//	                                      collection stream (K or more). Response: raw data
+//	POST /v1/verify                       Body: as for decode. Response: JSON summary
+//	GET  /metrics                         Encode and decode counters, in the Prometheus text format
+//	GET  /debug/vars                      The same counters as the expvar variable "padlock"
+//
+// The collection streams are those of pad.EncodeToWriters: the concatenated chunks
+// of one collection, identical to what a collection directory holds. Encode sends
+// each chunk of each collection as a part named after the collection as soon as
+// the chunk is complete, and the parts of a collection, concatenated in order, are
+// its stream. Decode sends the data as it is reconstructed, but reads the posted
+// collection streams in full first, since the decoder reads them all in step. A
+// failure once a response has started aborts it, leaving it truncated. Nothing is
+// written to disk by the service. Request bodies are limited by Config.MaxBytes.
+// With Config.Audit, every request is recorded in an audit log along with the
+// hashes of the data and collections it carried.
+//
+// The service has no authentication. So that a web page cannot drive it through
+// the browser of someone visiting it, POST requests a browser makes on behalf of a
+// page of another origin are refused, and encode accepts only application/octet-stream
+// bodies, which a page cannot post across origins without the service's consent.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/rayozzie/padlock/pkg/audit"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+// DefaultAddr is the address the service listens on by default. It is bound to
+// the loopback interface because requests carry plaintext.
+const DefaultAddr = "127.0.0.1:8420"
+
+// DefaultMaxBytes is the default limit on the size of a request body
+const DefaultMaxBytes = 64 * 1024 * 1024
+
+// Config holds the parameters of the service
+type Config struct {
+	Addr      string     // Address to listen on (default: DefaultAddr)
+	MaxBytes  int64      // Maximum request body size in bytes (default: DefaultMaxBytes)
+	ChunkSize int        // Maximum chunk size of the collection streams encoded (default: pad.DefaultChunkSize)
+	RNG       pad.RNG    // Random number generator for the pads of encodes (default: pad.NewDefaultRand)
+	Audit     *audit.Log // If set, encode, decode and verify requests are recorded here
+}
+
+// Server handles padlock service requests
+type Server struct {
+	ctx       context.Context
+	maxBytes  int64
+	chunkSize int
+	rng       pad.RNG
+	audit     *audit.Log
+	mux       *http.ServeMux
+}
+
+// New creates a Server, logging through the tracer in ctx
+func New(ctx context.Context, cfg Config) *Server {
+	s := &Server{
+		ctx:       ctx,
+		maxBytes:  cfg.MaxBytes,
+		chunkSize: cfg.ChunkSize,
+		rng:       cfg.RNG,
+		audit:     cfg.Audit,
+		mux:       http.NewServeMux(),
+	}
+	if s.maxBytes <= 0 {
+		s.maxBytes = DefaultMaxBytes
+	}
+	if s.chunkSize <= 0 {
+		s.chunkSize = pad.DefaultChunkSize
+	}
+	if s.rng == nil {
+		s.rng = pad.NewDefaultRand(ctx)
+	}
+
+	// Requests are served concurrently, but the RNG need not be safe for that
+	s.rng = &lockedRNG{rng: s.rng}
+
+	s.mux.HandleFunc("GET /v1/health", s.handleHealth)
+	s.mux.HandleFunc("POST /v1/encode", s.handleEncode)
+	s.mux.HandleFunc("POST /v1/decode", s.handleDecode)
+	s.mux.HandleFunc("POST /v1/verify", s.handleVerify)
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
+	publishMetrics.Do(func() {
+		expvar.Publish("padlock", expvar.Func(func() any { return pad.ReadMetrics() }))
+	})
+	s.mux.Handle("GET /debug/vars", expvar.Handler())
+	return s
+}
+
+// publishMetrics publishes the counters as an expvar variable, which can only
+// be done once however many servers are created
+var publishMetrics sync.Once
+
+// ServeHTTP implements http.Handler. Each request is an operation of its
+// own, logged and timed through the server's tracer.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, op := trace.StartOperation(trace.WithContext(r.Context(), trace.FromContext(s.ctx)), r.Method+" "+r.URL.Path)
+	defer op.End()
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && crossOrigin(r) {
+		s.fail(w, http.StatusForbidden, fmt.Errorf("refusing %s %s from another origin", r.Method, r.URL.Path))
+		return
+	}
+	s.mux.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// ListenAndServe runs the service until it fails
+func ListenAndServe(ctx context.Context, cfg Config) error {
+	log := trace.FromContext(ctx).WithPrefix("SERVE")
+
+	if cfg.Addr == "" {
+		cfg.Addr = DefaultAddr
+	}
+	log.Infof("Listening on http://%s", cfg.Addr)
+	if err := http.ListenAndServe(cfg.Addr, New(ctx, cfg)); err != nil {
+		log.Error(fmt.Errorf("server failed: %w", err))
+		return fmt.Errorf("server failed: %w", err)
+	}
+	return nil
+}
+
+// handleHealth reports that the service is running
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleEncode splits the request body into collection streams, sending them
+// as they are produced
+func (s *Server) handleEncode(w http.ResponseWriter, r *http.Request) {
+	log := trace.FromContext(s.ctx).WithPrefix("SERVE")
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/octet-stream" {
+		s.fail(w, http.StatusUnsupportedMediaType, fmt.Errorf("expected the data to encode as application/octet-stream, got %q", r.Header.Get("Content-Type")))
+		return
+	}
+	copies, err := queryInt(r, "copies", 2)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	required, err := queryInt(r, "required", 2)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	params := map[string]string{"copies": strconv.Itoa(copies), "required": strconv.Itoa(required)}
+	if copies < 2 || copies > 26 || required < 2 || required > copies {
+		err := fmt.Errorf("invalid parameters copies=%d required=%d: need 2 <= required <= copies <= 26", copies, required)
+		s.fail(w, http.StatusBadRequest, err)
+		s.record(audit.Record{Operation: audit.OpEncode, Params: params}, err)
+		return
+	}
+	p, err := pad.NewPadForEncode(r.Context(), copies, required)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, fmt.Errorf("encode failed: %w", err))
+		s.record(audit.Record{Operation: audit.OpEncode, Params: params}, err)
+		return
+	}
+
+	// The response is written while the body is still being read, which
+	// HTTP/1.x servers only allow when asked to
+	http.NewResponseController(w).EnableFullDuplex()
+
+	input := newHashingWriter()
+	body := io.TeeReader(http.MaxBytesReader(w, r.Body, s.maxBytes), input)
+	resp := newEncodeResponse(w, p.Collections)
+	err = p.Encode(r.Context(), s.chunkSize, body, s.rng, resp.newChunk, "bin")
+	if err == nil {
+		err = resp.finish()
+	}
+	rec := audit.Record{
+		Operation: audit.OpEncode,
+		Params:    params,
+		Inputs:    []audit.FileHash{input.fileHash("data")},
+	}
+	if err != nil {
+		s.record(rec, err)
+		s.abortOrFail(w, resp.started, statusForBodyError(err), fmt.Errorf("encode failed: %w", err))
+		return
+	}
+	for _, name := range p.Collections {
+		rec.Outputs = append(rec.Outputs, resp.hashes[name].fileHash(name+".bin"))
+	}
+	s.record(rec, nil)
+	log.Debugf("Encoded %d-of-%d collections", required, copies)
+}
+
+// handleDecode reconstructs the original data from the posted collection
+// streams, sending it as it is reconstructed
+func (s *Server) handleDecode(w http.ResponseWriter, r *http.Request) {
+	output := &responseStream{w: w, contentType: "application/octet-stream"}
+	data := newHashingWriter()
+	inputs, status, err := s.decode(r, w, io.MultiWriter(output, data))
+	if err != nil {
+		s.record(audit.Record{Operation: audit.OpDecode, Inputs: inputs}, err)
+		s.abortOrFail(w, output.started, status, err)
+		return
+	}
+	s.record(audit.Record{Operation: audit.OpDecode, Inputs: inputs, Outputs: []audit.FileHash{data.fileHash("data")}}, nil)
+	output.start()
+}
+
+// handleVerify decodes the posted collection streams without returning the data,
+// reporting the size and hash of what they reconstruct
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	data := newHashingWriter()
+	inputs, status, err := s.decode(r, w, data)
+	if err != nil {
+		s.record(audit.Record{Operation: audit.OpVerify, Inputs: inputs}, err)
+		s.fail(w, status, err)
+		return
+	}
+	result := data.fileHash("data")
+	s.record(audit.Record{Operation: audit.OpVerify, Inputs: inputs, Outputs: []audit.FileHash{result}}, nil)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ok":          true,
+		"collections": len(inputs),
+		"bytes":       result.Size,
+		"sha256":      result.SHA256,
+	})
+}
+
+// handleMetrics reports the encode and decode counters of the process, in the
+// Prometheus text exposition format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m := pad.ReadMetrics()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, metric := range []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"padlock_encoded_bytes_total", "counter", "Bytes of data encoded, including any padding.", m.BytesEncoded},
+		{"padlock_encoded_chunks_total", "counter", "Chunks encoded.", m.ChunksEncoded},
+		{"padlock_decoded_bytes_total", "counter", "Bytes of data reconstructed, including any padding.", m.BytesDecoded},
+		{"padlock_decoded_chunks_total", "counter", "Chunks reconstructed.", m.ChunksDecoded},
+		{"padlock_active_collections", "gauge", "Collections being read by the decodes under way.", m.ActiveCollections},
+		{"padlock_random_bytes_total", "counter", "Random bytes drawn.", m.RNGBytes},
+		{"padlock_errors_total", "counter", "Encodes and decodes that failed.", m.Errors},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+	}
+}
+
+// decode reads the collection streams from a multipart request and decodes them
+// to output, returning the hashes of the collections read. On failure it also
+// returns the status to report it with.
+func (s *Server) decode(r *http.Request, w http.ResponseWriter, output io.Writer) ([]audit.FileHash, int, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBytes)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("expected a multipart request with one part per collection: %w", err)
+	}
+
+	// The decoder reads every collection in step, so each part is buffered
+	var readers []io.Reader
+	var inputs []audit.FileHash
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return inputs, statusForBodyError(err), fmt.Errorf("failed to read collection: %w", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return inputs, statusForBodyError(err), fmt.Errorf("failed to read collection %s: %w", part.FormName(), err)
+		}
+		readers = append(readers, bytes.NewReader(data))
+		inputs = append(inputs, audit.HashBytes(part.FormName(), data))
+	}
+	if len(readers) < 2 {
+		return inputs, http.StatusBadRequest, fmt.Errorf("at least 2 collections are required, got %d", len(readers))
+	}
+
+	if err := pad.DecodeFromReaders(r.Context(), readers, output); err != nil {
+		return inputs, http.StatusUnprocessableEntity, fmt.Errorf("decode failed: %w", err)
+	}
+	return inputs, 0, nil
+}
+
+// record appends a request to the audit log, if there is one, with the outcome
+// given by err. The request has already been carried out, so a failure to
+// record it can only be logged.
+func (s *Server) record(rec audit.Record, err error) {
+	if s.audit == nil {
+		return
+	}
+	log := trace.FromContext(s.ctx).WithPrefix("SERVE")
+	rec.Session = audit.NewSession()
+	rec.Result = "ok"
+	if err != nil {
+		rec.Result, rec.Error = "error", err.Error()
+	}
+	if err := s.audit.Append(rec); err != nil {
+		log.Error(fmt.Errorf("failed to record %s in audit log: %w", rec.Operation, err))
+	}
+}
+
+// fail logs an error and writes it as a JSON response
+func (s *Server) fail(w http.ResponseWriter, status int, err error) {
+	log := trace.FromContext(s.ctx).WithPrefix("SERVE")
+	log.Error(err)
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// abortOrFail reports an error as fail does, unless the response has already
+// started, in which case it can only be logged and the response cut short so
+// that the client does not take it for a complete one
+func (s *Server) abortOrFail(w http.ResponseWriter, started bool, status int, err error) {
+	if !started {
+		s.fail(w, status, err)
+		return
+	}
+	log := trace.FromContext(s.ctx).WithPrefix("SERVE")
+	log.Error(fmt.Errorf("aborting response: %w", err))
+	panic(http.ErrAbortHandler)
+}
+
+// writeJSON writes a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return n, nil
+}
+
+// statusForBodyError maps an error reading the request body to a response status
+func statusForBodyError(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// crossOrigin reports whether a browser sent a request on behalf of a page of
+// another origin, as told by Sec-Fetch-Site or, in older browsers, by an Origin
+// whose host is not the one the request was sent to. Requests with neither
+// header come from programs other than browsers.
+func crossOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "":
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+// encodeResponse writes the collection streams of an encode as a multipart/mixed
+// response, one part per chunk of each collection. The pad writes the pieces of
+// a chunk to every collection in turn, and closes them all when it fails too, so
+// the chunks are buffered, and only sent once the encode has gone on to the next
+// chunk or finished.
+type encodeResponse struct {
+	w       http.ResponseWriter
+	mw      *multipart.Writer
+	size    int                       // Number of collections
+	pending []encodePart              // Chunk of each collection being encoded
+	hashes  map[string]*hashingWriter // Hashes of the collection streams sent
+	started bool                      // Whether any of the response has been written
+}
+
+// encodePart is the chunk of one collection
+type encodePart struct {
+	collName string
+	data     *bytes.Buffer
+}
+
+// newEncodeResponse creates the response of an encode into the given collections
+func newEncodeResponse(w http.ResponseWriter, collections []string) *encodeResponse {
+	e := &encodeResponse{
+		w:      w,
+		mw:     multipart.NewWriter(w),
+		size:   len(collections),
+		hashes: make(map[string]*hashingWriter, len(collections)),
+	}
+	for _, name := range collections {
+		e.hashes[name] = newHashingWriter()
+	}
+	return e
+}
+
+// newChunk implements pad.NewChunkFunc. The pad opens the chunks of every
+// collection before writing any, so a chunk is complete once as many chunks
+// again are opened.
+func (e *encodeResponse) newChunk(collName string, chunkNumber int, chunkFormat string) (io.WriteCloser, error) {
+	if len(e.pending) == e.size {
+		if err := e.flush(); err != nil {
+			return nil, err
+		}
+	}
+	part := encodePart{collName: collName, data: &bytes.Buffer{}}
+	e.pending = append(e.pending, part)
+	return nopWriteCloser{part.data}, nil
+}
+
+// flush sends the chunks pending, one part each
+func (e *encodeResponse) flush() error {
+	if !e.started {
+		e.w.Header().Set("Content-Type", "multipart/mixed; boundary="+e.mw.Boundary())
+		e.started = true
+	}
+	for _, part := range e.pending {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/octet-stream")
+		header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.bin"`, part.collName))
+		pw, err := e.mw.CreatePart(header)
+		if err != nil {
+			return fmt.Errorf("failed to write collection %s: %w", part.collName, err)
+		}
+		if _, err := part.data.WriteTo(io.MultiWriter(pw, e.hashes[part.collName])); err != nil {
+			return fmt.Errorf("failed to write collection %s: %w", part.collName, err)
+		}
+	}
+	e.pending = e.pending[:0]
+	http.NewResponseController(e.w).Flush()
+	return nil
+}
+
+// finish sends the last chunks and ends the response
+func (e *encodeResponse) finish() error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+	if err := e.mw.Close(); err != nil {
+		return fmt.Errorf("failed to finish encode response: %w", err)
+	}
+	return nil
+}
+
+// responseStream writes the data of a response as it is produced, setting its
+// headers on the first write so that a failure before then can still be
+// reported with an error status
+type responseStream struct {
+	w           http.ResponseWriter
+	contentType string
+	started     bool
+}
+
+// Write implements io.Writer
+func (s *responseStream) Write(p []byte) (int, error) {
+	s.start()
+	return s.w.Write(p)
+}
+
+// start writes the headers of the response, if they have not been written
+func (s *responseStream) start() {
+	if s.started {
+		return
+	}
+	s.w.Header().Set("Content-Type", s.contentType)
+	s.w.WriteHeader(http.StatusOK)
+	s.started = true
+}
+
+// hashingWriter hashes and counts the bytes written through it
+type hashingWriter struct {
+	hash hash.Hash
+	n    int64
+}
+
+// newHashingWriter creates a hashingWriter using SHA-256
+func newHashingWriter() *hashingWriter {
+	return &hashingWriter{hash: sha256.New()}
+}
+
+// Write implements io.Writer
+func (h *hashingWriter) Write(p []byte) (int, error) {
+	h.hash.Write(p)
+	h.n += int64(len(p))
+	return len(p), nil
+}
+
+// fileHash returns the size and hash of what was written, under the given path
+func (h *hashingWriter) fileHash(path string) audit.FileHash {
+	return audit.FileHash{Path: path, Size: h.n, SHA256: hex.EncodeToString(h.hash.Sum(nil))}
+}
+
+// nopWriteCloser adds a Close that does nothing to a writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close implements io.Closer
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// lockedRNG serializes access to an RNG shared between requests
+type lockedRNG struct {
+	mu  sync.Mutex
+	rng pad.RNG
+}
+
+// Name returns the name of the underlying RNG
+func (r *lockedRNG) Name() string {
+	return r.rng.Name()
+}
+
+// Read fills p from the underlying RNG
+func (r *lockedRNG) Read(ctx context.Context, p []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Read(ctx, p)
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayozzie/padlock/pkg/audit"
+	"github.com/rayozzie/padlock/pkg/pad"
+	"github.com/rayozzie/padlock/pkg/trace"
+)
+
+func TestServeEncodeDecodeVerify(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	dir, err := os.MkdirTemp("", "server-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	ts := httptest.NewServer(New(ctx, Config{MaxBytes: 1024 * 1024, Audit: auditLog}))
+	defer ts.Close()
+
+	input := []byte("the launch codes are in the second drawer")
+
+	// Encode into three collections, any two of which can decode
+	resp, err := http.Post(ts.URL+"/v1/encode?copies=3&required=2", "application/octet-stream", bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("Encode request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Encode returned status %d", resp.StatusCode)
+	}
+	collections, _ := readCollections(t, resp)
+	if len(collections) != 3 || collections["2B3"] == nil {
+		t.Fatalf("Expected collections 2A3, 2B3 and 2C3, got %d", len(collections))
+	}
+
+	// post sends collections as a multipart form to an endpoint
+	post := func(endpoint string, names ...string) *http.Response {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for _, name := range names {
+			fw, err := mw.CreateFormFile(name, name+".bin")
+			if err != nil {
+				t.Fatalf("Failed to create form file: %v", err)
+			}
+			fw.Write(collections[name])
+		}
+		mw.Close()
+		resp, err := http.Post(ts.URL+endpoint, mw.FormDataContentType(), &body)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", endpoint, err)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name       string
+		endpoint   string
+		names      []string
+		wantStatus int
+	}{
+		{"Decode 2 of 3", "/v1/decode", []string{"2C3", "2A3"}, http.StatusOK},
+		{"Verify 3 of 3", "/v1/verify", []string{"2A3", "2B3", "2C3"}, http.StatusOK},
+		{"Decode 1 of 3", "/v1/decode", []string{"2B3"}, http.StatusBadRequest},
+	}
+
+	sum := sha256.Sum256(input)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := post(tt.endpoint, tt.names...)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, resp.StatusCode, body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			switch tt.endpoint {
+			case "/v1/decode":
+				if !bytes.Equal(body, input) {
+					t.Errorf("Decoded %q, want %q", body, input)
+				}
+			case "/v1/verify":
+				var result struct {
+					OK     bool   `json:"ok"`
+					Bytes  int64  `json:"bytes"`
+					SHA256 string `json:"sha256"`
+				}
+				if err := json.Unmarshal(body, &result); err != nil {
+					t.Fatalf("Invalid verify response: %v", err)
+				}
+				if !result.OK || result.Bytes != int64(len(input)) || result.SHA256 != hex.EncodeToString(sum[:]) {
+					t.Errorf("Unexpected verify result: %s", body)
+				}
+			}
+		})
+	}
+
+	// Invalid parameters are rejected
+	resp, err = http.Post(ts.URL+"/v1/encode?copies=3&required=4", "application/octet-stream", bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("Encode request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d for required > copies, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// Every request was audited, identifying the collections by their hashes
+	records, err := audit.VerifyFile(auditLog.Path())
+	if err != nil {
+		t.Fatalf("Audit log does not verify: %v", err)
+	}
+	want := []struct {
+		op     string
+		result string
+	}{
+		{audit.OpEncode, "ok"},
+		{audit.OpDecode, "ok"},
+		{audit.OpVerify, "ok"},
+		{audit.OpDecode, "error"},
+		{audit.OpEncode, "error"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("Expected %d audit records, got %d", len(want), len(records))
+	}
+	for i, w := range want {
+		if records[i].Operation != w.op || records[i].Result != w.result {
+			t.Errorf("Record %d: expected %s %s, got %s %s", i+1, w.op, w.result, records[i].Operation, records[i].Result)
+		}
+	}
+	if got := records[0].Outputs[1]; got != audit.HashBytes("2B3.bin", collections["2B3"]) {
+		t.Errorf("Unexpected hash recorded for collection 2B3: %+v", got)
+	}
+	if got := records[2].Outputs[0].SHA256; got != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected verify to record the hash of the data, got %s", got)
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// More than one server may be created in a process
+	New(ctx, Config{})
+	ts := httptest.NewServer(New(ctx, Config{}))
+	defer ts.Close()
+
+	before := pad.ReadMetrics()
+	resp, err := http.Post(ts.URL+"/v1/encode?copies=3&required=2", "application/octet-stream", strings.NewReader("metrics"))
+	if err != nil {
+		t.Fatalf("Encode request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Metrics request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{
+		"# TYPE padlock_encoded_chunks_total counter\n",
+		fmt.Sprintf("\npadlock_encoded_chunks_total %d\n", before.ChunksEncoded+1),
+		fmt.Sprintf("\npadlock_encoded_bytes_total %d\n", before.BytesEncoded+int64(len("metrics"))),
+		"# TYPE padlock_active_collections gauge\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected the metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+
+	resp, err = http.Get(ts.URL + "/debug/vars")
+	if err != nil {
+		t.Fatalf("Vars request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var vars struct {
+		Padlock pad.Metrics `json:"padlock"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		t.Fatalf("Invalid vars response: %v", err)
+	}
+	if vars.Padlock.ChunksEncoded != before.ChunksEncoded+1 {
+		t.Errorf("Expected %d chunks encoded in the vars, got %d", before.ChunksEncoded+1, vars.Padlock.ChunksEncoded)
+	}
+}
+
+func TestServeEncodeStreamsChunks(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	// The chunk size and RNG of the service are used, each chunk of each
+	// collection being sent as soon as it is complete
+	ts := httptest.NewServer(New(ctx, Config{ChunkSize: 1024, RNG: pad.NewTestRNG(7)}))
+	defer ts.Close()
+
+	input := bytes.Repeat([]byte("streamed through the service "), 200)
+	resp, err := http.Post(ts.URL+"/v1/encode?copies=3&required=2", "application/octet-stream", bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("Encode request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Encode returned status %d", resp.StatusCode)
+	}
+	collections, parts := readCollections(t, resp)
+
+	buffers := make([]bytes.Buffer, 3)
+	writers := make([]io.Writer, 3)
+	for i := range buffers {
+		writers[i] = &buffers[i]
+	}
+	if err := pad.EncodeToWritersWithRNG(ctx, bytes.NewReader(input), writers, 2, 1024, pad.NewTestRNG(7)); err != nil {
+		t.Fatalf("EncodeToWritersWithRNG failed: %v", err)
+	}
+	chunks := len(input)/1024 + 1
+	if parts < 3*chunks {
+		t.Errorf("Expected at least %d parts, one per chunk of each collection, got %d", 3*chunks, parts)
+	}
+	for i := range buffers {
+		name := pad.CollectionName(2, 3, i)
+		if !bytes.Equal(collections[name], buffers[i].Bytes()) {
+			t.Errorf("Collection %s differs from the same encode through EncodeToWritersWithRNG", name)
+		}
+	}
+
+	// The data is reconstructed from the concatenated parts
+	var output bytes.Buffer
+	if err := pad.DecodeFromReaders(ctx, []io.Reader{bytes.NewReader(collections["2A3"]), bytes.NewReader(collections["2C3"])}, &output); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !bytes.Equal(output.Bytes(), input) {
+		t.Errorf("Decoded %d bytes, want the %d bytes encoded", output.Len(), len(input))
+	}
+}
+
+func TestServeRefusesCrossOrigin(t *testing.T) {
+	ctx := context.Background()
+	tracer := trace.NewTracer("TEST", trace.LogLevelVerbose)
+	ctx = trace.WithContext(ctx, tracer)
+
+	ts := httptest.NewServer(New(ctx, Config{}))
+	defer ts.Close()
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.CreateFormFile("2A3", "2A3.bin")
+	mw.Close()
+
+	tests := []struct {
+		name        string
+		endpoint    string
+		contentType string
+		headers     map[string]string
+		wantStatus  int
+	}{
+		{"Program", "/v1/encode", "application/octet-stream", nil, http.StatusOK},
+		{"Same origin", "/v1/encode", "application/octet-stream", map[string]string{"Origin": ts.URL, "Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"Origin of the service", "/v1/encode", "application/octet-stream", map[string]string{"Origin": ts.URL}, http.StatusOK},
+		{"Cross site", "/v1/encode", "application/octet-stream", map[string]string{"Origin": "https://example.com", "Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"Same site", "/v1/encode", "application/octet-stream", map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"Other origin", "/v1/encode", "application/octet-stream", map[string]string{"Origin": "https://example.com"}, http.StatusForbidden},
+		{"Opaque origin", "/v1/encode", "application/octet-stream", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"Form post", "/v1/decode", mw.FormDataContentType(), map[string]string{"Origin": "https://example.com"}, http.StatusForbidden},
+		{"Text body", "/v1/encode", "text/plain", nil, http.StatusUnsupportedMediaType},
+		{"Form-encoded body", "/v1/encode", "application/x-www-form-urlencoded", nil, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte("data")
+			if tt.endpoint == "/v1/decode" {
+				body = form.Bytes()
+			}
+			req, err := http.NewRequest(http.MethodPost, ts.URL+tt.endpoint, bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				body, _ := io.ReadAll(resp.Body)
+				t.Errorf("Expected status %d, got %d: %s", tt.wantStatus, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+// readCollections reads the collection streams of an encode response, joining
+// the parts of each, and returns them with the number of parts read
+func readCollections(t *testing.T, resp *http.Response) (map[string][]byte, int) {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Invalid encode content type: %v", err)
+	}
+	collections := make(map[string][]byte)
+	parts := 0
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read encode response: %v", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("Failed to read collection: %v", err)
+		}
+		name := strings.TrimSuffix(part.FileName(), ".bin")
+		collections[name] = append(collections[name], data...)
+		parts++
+	}
+	return collections, parts
+}
@@ -0,0 +1,89 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// otlpExporter writes spans in the OTLP/JSON encoding of OpenTelemetry
+type otlpExporter struct {
+	enc     *json.Encoder
+	service string
+}
+
+// OTLP/JSON encoding of an ExportTraceServiceRequest holding one span
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+)
+
+// otlpSpanKindInternal is the kind of spans of work within the process
+const otlpSpanKindInternal = 1
+
+// OTLPJSONExporter returns a span exporter writing each span to w as a line of
+// OTLP/JSON, the JSON encoding of the OpenTelemetry protocol, attributed to
+// the named service. Such files are read by the OpenTelemetry Collector's
+// otlpjsonfile receiver, from which spans can be sent to any tracing backend.
+// OpenTelemetry trace IDs are 16 bytes, so the 8-byte operation ID is padded
+// with leading zeros, as for 64-bit trace IDs from other systems.
+func OTLPJSONExporter(w io.Writer, service string) SpanExporter {
+	return &otlpExporter{enc: json.NewEncoder(w), service: service}
+}
+
+// ExportSpan implements SpanExporter
+func (e *otlpExporter) ExportSpan(span *Span) error {
+	traceID := span.Operation
+	if len(traceID) < 32 {
+		traceID = strings.Repeat("0", 32-len(traceID)) + traceID
+	}
+	return e.enc.Encode(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: e.service}}}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/rayozzie/padlock/pkg/trace"},
+			Spans: []otlpSpan{{
+				TraceID:           traceID,
+				SpanID:            span.ID,
+				ParentSpanID:      span.Parent,
+				Name:              span.Name,
+				Kind:              otlpSpanKindInternal,
+				StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+				EndTimeUnixNano:   strconv.FormatInt(span.Finish.UnixNano(), 10),
+				Attributes:        []otlpAttribute{{Key: "padlock.path", Value: otlpValue{StringValue: span.Path}}},
+			}},
+		}},
+	}}})
+}
@@ -0,0 +1,44 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOTLPJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer("TEST", LogLevelNormal)
+	tracer.SetSpanExporters(OTLPJSONExporter(&buf, "padlock"))
+	ctx := WithContext(context.Background(), tracer)
+
+	ctx, op := StartOperation(ctx, "encode")
+	_, chunk := StartSpan(ctx, "chunk 1")
+	chunk.End()
+	op.End()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a line per span, got %q", buf.String())
+	}
+	var req otlpRequest
+	if err := json.Unmarshal([]byte(lines[0]), &req); err != nil {
+		t.Fatalf("Line is not OTLP/JSON: %v", err)
+	}
+	rs := req.ResourceSpans[0]
+	if rs.Resource.Attributes[0] != (otlpAttribute{Key: "service.name", Value: otlpValue{StringValue: "padlock"}}) {
+		t.Errorf("Unexpected resource %+v", rs.Resource)
+	}
+	span := rs.ScopeSpans[0].Spans[0]
+	if span.TraceID != "0000000000000000"+op.Operation || span.SpanID != chunk.ID || span.ParentSpanID != op.ID || span.Name != "chunk 1" {
+		t.Errorf("Unexpected span %+v", span)
+	}
+	if span.Kind != otlpSpanKindInternal || span.StartTimeUnixNano == "" || span.EndTimeUnixNano < span.StartTimeUnixNano {
+		t.Errorf("Unexpected span times %+v", span)
+	}
+	if !strings.Contains(lines[1], `"name":"encode"`) || strings.Contains(lines[1], "parentSpanId") {
+		t.Errorf("Expected the root span without a parent, got %s", lines[1])
+	}
+}
@@ -0,0 +1,72 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "trace-rotate-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "padlock.log")
+	r, err := OpenRotatingFile(path, 20, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+	// Each line is 10 bytes, so every file holds two lines
+	for i := 0; i < 7; i++ {
+		if _, err := fmt.Fprintf(r, "line %04d\n", i); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"padlock.log", "line 0006\n"},
+		{"padlock.log.1", "line 0004\nline 0005\n"},
+		{"padlock.log.2", "line 0002\nline 0003\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(dir, tt.name))
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", tt.name, err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, string(data))
+			}
+		})
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 older files to be kept, found %s.3", path)
+	}
+
+	// Reopening appends to the existing file and accounts for its size
+	r, err = OpenRotatingFile(path, 20, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+	fmt.Fprintf(r, "line %04d\n", 7)
+	fmt.Fprintf(r, "line %04d\n", 8)
+	r.Close()
+	data, _ := os.ReadFile(path)
+	if string(data) != "line 0008\n" {
+		t.Errorf("Expected rotation after reopening, got %q", string(data))
+	}
+	data, _ = os.ReadFile(path + ".1")
+	if !strings.HasPrefix(string(data), "line 0006\nline 0007\n") {
+		t.Errorf("Expected reopened lines in %s.1, got %q", path, string(data))
+	}
+}
@@ -0,0 +1,139 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Severity is the kind of a log entry
+type Severity int
+
+const (
+	SeverityTrace Severity = iota // Detailed tracing, logged when verbose
+	SeverityDebug                 // Debug information, logged when verbose
+	SeverityInfo                  // Regular user-facing messages
+	SeverityError                 // Errors
+	SeverityFatal                 // Errors that end the program
+)
+
+// String returns the name of the severity, as written in JSON output
+func (s Severity) String() string {
+	switch s {
+	case SeverityTrace:
+		return "trace"
+	case SeverityDebug:
+		return "debug"
+	case SeverityInfo:
+		return "info"
+	case SeverityError:
+		return "error"
+	case SeverityFatal:
+		return "fatal"
+	}
+	return "unknown"
+}
+
+// Entry is one message logged by a tracer
+type Entry struct {
+	Time      time.Time
+	Severity  Severity
+	Prefix    string
+	Operation string // ID of the operation logged for, if any (see StartOperation)
+	Message   string
+}
+
+// Text formats the entry as a line of text, without a timestamp, e.g.
+// "PADLOCK: Collections: 3" or "PADLOCK ERROR: no collections found"
+func (e Entry) Text() string {
+	label := ""
+	switch e.Severity {
+	case SeverityTrace:
+		label = "TRACE"
+	case SeverityError:
+		label = "ERROR"
+	case SeverityFatal:
+		label = "FATAL"
+	}
+	head := strings.TrimSpace(e.Prefix + " " + label)
+	if head == "" {
+		return e.Message
+	}
+	return head + ": " + e.Message
+}
+
+// Sink receives the entries logged by a tracer. Calls are serialized by the
+// tracer, so sinks need not be safe for concurrent use.
+type Sink interface {
+	Write(entry Entry) error
+}
+
+// stdLogSink writes entries as text through the standard log package
+type stdLogSink struct{}
+
+// StdLogSink returns a sink that writes entries as text through the standard
+// log package, which is where tracers write by default
+func StdLogSink() Sink {
+	return stdLogSink{}
+}
+
+// Write implements Sink
+func (stdLogSink) Write(entry Entry) error {
+	log.Print(entry.Text())
+	return nil
+}
+
+// textSink writes entries as timestamped lines of text
+type textSink struct {
+	w io.Writer
+}
+
+// TextSink returns a sink that writes entries to w as lines of text,
+// timestamped like the standard log package, and followed by the ID of the
+// operation they were logged for, if any, e.g. "[op 5d41402abc4b2a76]"
+func TextSink(w io.Writer) Sink {
+	return &textSink{w: w}
+}
+
+// Write implements Sink
+func (s *textSink) Write(entry Entry) error {
+	line := entry.Time.Format("2006/01/02 15:04:05 ") + entry.Text()
+	if entry.Operation != "" {
+		line += " [op " + entry.Operation + "]"
+	}
+	_, err := io.WriteString(s.w, line+"\n")
+	return err
+}
+
+// jsonSink writes entries as JSON lines
+type jsonSink struct {
+	enc *json.Encoder
+}
+
+// jsonEntry is the JSON form of an entry
+type jsonEntry struct {
+	Time    string `json:"time"`             // RFC 3339 with nanoseconds
+	Level   string `json:"level"`            // trace, debug, info, error or fatal
+	Prefix  string `json:"prefix,omitempty"` // Component that logged the entry, e.g. PADLOCK
+	Op      string `json:"op,omitempty"`     // ID of the operation logged for
+	Message string `json:"msg"`
+}
+
+// JSONSink returns a sink that writes entries to w as JSON lines, one object
+// per entry, for log collectors and later auditing
+func JSONSink(w io.Writer) Sink {
+	return &jsonSink{enc: json.NewEncoder(w)}
+}
+
+// Write implements Sink
+func (s *jsonSink) Write(entry Entry) error {
+	return s.enc.Encode(jsonEntry{
+		Time:    entry.Time.Format(time.RFC3339Nano),
+		Level:   entry.Severity.String(),
+		Prefix:  entry.Prefix,
+		Op:      entry.Operation,
+		Message: entry.Message,
+	})
+}
@@ -0,0 +1,80 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEntryText(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry Entry
+		want  string
+	}{
+		{"info", Entry{Severity: SeverityInfo, Prefix: "PADLOCK", Message: "Collections: 3"}, "PADLOCK: Collections: 3"},
+		{"debug", Entry{Severity: SeverityDebug, Prefix: "FILE", Message: "reading"}, "FILE: reading"},
+		{"trace", Entry{Severity: SeverityTrace, Prefix: "PAD", Message: "chunk 1"}, "PAD TRACE: chunk 1"},
+		{"error", Entry{Severity: SeverityError, Prefix: "PADLOCK", Message: "no collections"}, "PADLOCK ERROR: no collections"},
+		{"fatal without prefix", Entry{Severity: SeverityFatal, Message: "failed"}, "FATAL: failed"},
+		{"info without prefix", Entry{Severity: SeverityInfo, Message: "Plain message"}, "Plain message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.Text(); got != tt.want {
+				t.Errorf("Text() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextSink(t *testing.T) {
+	var buf bytes.Buffer
+	when := time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+	if err := TextSink(&buf).Write(Entry{Time: when, Severity: SeverityError, Prefix: "FILE", Message: "disk full"}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if want := "2026/10/17 09:30:00 FILE ERROR: disk full\n"; buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+
+	buf.Reset()
+	TextSink(&buf).Write(Entry{Time: when, Severity: SeverityInfo, Prefix: "FILE", Operation: "5d41402abc4b2a76", Message: "done"})
+	if want := "2026/10/17 09:30:00 FILE: done [op 5d41402abc4b2a76]\n"; buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := JSONSink(&buf)
+	when := time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+	sink.Write(Entry{Time: when, Severity: SeverityInfo, Prefix: "PADLOCK", Operation: "5d41402abc4b2a76", Message: "Collections: 3"})
+	sink.Write(Entry{Time: when, Severity: SeverityError, Message: "say \"hi\"\nbye"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d: %q", len(lines), buf.String())
+	}
+	var first, second map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("First line is not JSON: %v", err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("Second line is not JSON: %v", err)
+	}
+	if first["time"] != "2026-10-17T09:30:00Z" || first["level"] != "info" || first["prefix"] != "PADLOCK" || first["msg"] != "Collections: 3" || first["op"] != "5d41402abc4b2a76" {
+		t.Errorf("Unexpected first entry: %v", first)
+	}
+	if _, ok := second["prefix"]; ok {
+		t.Errorf("Expected no prefix field for an entry without a prefix, got %v", second)
+	}
+	if _, ok := second["op"]; ok {
+		t.Errorf("Expected no op field for an entry outside an operation, got %v", second)
+	}
+	if second["level"] != "error" || second["msg"] != "say \"hi\"\nbye" {
+		t.Errorf("Unexpected second entry: %v", second)
+	}
+}
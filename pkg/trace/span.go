@@ -0,0 +1,140 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Span is a timed step of an operation, such as the encoding of one chunk or
+// its writing to one collection. Spans nest: the spans started under the
+// context of a span are its children, so that the time of an encode can be
+// broken down into its chunks, and the time of a chunk into its collections.
+// When a span ends, its duration is logged at the trace level and the span is
+// passed to the tracer's span exporters.
+type Span struct {
+	Name      string    // Name of the step, e.g. "chunk 42"
+	Path      string    // Names of the span and its ancestors, e.g. "encode/chunk 42/collection 3B5"
+	Operation string    // ID of the operation the span is part of
+	ID        string    // ID of the span, unique within its operation
+	Parent    string    // ID of the enclosing span, empty for the root span of the operation
+	Start     time.Time // When the span started
+	Finish    time.Time // When the span ended, zero until then
+
+	tracer *Tracer
+	once   sync.Once
+}
+
+// SpanExporter receives the spans of a tracer as they end. Calls are
+// serialized by the tracer, so exporters need not be safe for concurrent use.
+type SpanExporter interface {
+	ExportSpan(span *Span) error
+}
+
+// spanKey is the context key of the current span
+type spanKey struct{}
+
+// newID returns a random ID of n bytes in hex
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// StartOperation starts an operation, such as an encode or a decode, with a
+// new operation ID and a root span of the given name. Everything logged
+// through the returned context carries the operation ID, so that the entries
+// of one operation can be picked out of a log shared with others, and spans
+// started under it are its descendants. Within an operation, such as a decode
+// that is part of a reshare, the span is instead started as a step of the
+// operation under way. The span must be ended.
+func StartOperation(ctx context.Context, name string) (context.Context, *Span) {
+	if _, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return StartSpan(ctx, name)
+	}
+	tracer := FromContext(ctx).withOperation(newID(8))
+	ctx = WithContext(ctx, tracer)
+	ctx = context.WithValue(ctx, spanKey{}, (*Span)(nil))
+	return StartSpan(ctx, name)
+}
+
+// StartSpan starts a span of the given name within the span of ctx, or, if
+// there is none, as the root span of a new operation. The span must be ended.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok {
+		return StartOperation(ctx, name)
+	}
+	tracer := FromContext(ctx)
+	span := &Span{
+		Name:      name,
+		Path:      name,
+		Operation: tracer.op,
+		ID:        newID(8),
+		Start:     time.Now(),
+		tracer:    tracer,
+	}
+	if parent != nil {
+		span.Parent = parent.ID
+		span.Path = parent.Path + "/" + name
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SpanFromContext returns the span of ctx, or nil if there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// End ends the span, logging its duration and exporting it. Ending a span
+// again, or a nil span, does nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.Finish = time.Now()
+		s.tracer.Tracef("Span %s took %v", s.Path, s.Duration())
+		s.tracer.export(s)
+	})
+}
+
+// Duration returns how long the span took, or has taken so far if it has not ended
+func (s *Span) Duration() time.Duration {
+	if s.Finish.IsZero() {
+		return time.Since(s.Start)
+	}
+	return s.Finish.Sub(s.Start)
+}
+
+// SetSpanExporters replaces the exporters that the spans of this tracer, and
+// of every tracer derived from it, are passed to as they end
+func (t *Tracer) SetSpanExporters(exporters ...SpanExporter) {
+	t.out.mu.Lock()
+	defer t.out.mu.Unlock()
+	t.out.exporters = exporters
+}
+
+// export passes an ended span to every exporter
+func (t *Tracer) export(span *Span) {
+	t.out.mu.Lock()
+	defer t.out.mu.Unlock()
+	for _, exporter := range t.out.exporters {
+		exporter.ExportSpan(span)
+	}
+}
+
+// withOperation returns a tracer like this one whose entries carry an operation ID
+func (t *Tracer) withOperation(op string) *Tracer {
+	derived := t.WithPrefix(t.prefix)
+	derived.op = op
+	return derived
+}
+
+// Operation returns the ID of the operation the tracer logs for, if any
+func (t *Tracer) Operation() string {
+	return t.op
+}
@@ -0,0 +1,105 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// recordingExporter keeps the spans exported to it
+type recordingExporter struct {
+	spans []*Span
+}
+
+func (r *recordingExporter) ExportSpan(span *Span) error {
+	r.spans = append(r.spans, span)
+	return nil
+}
+
+func TestSpans(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer("TEST", LogLevelVerbose)
+	tracer.SetSinks(TextSink(&buf))
+	exporter := &recordingExporter{}
+	tracer.SetSpanExporters(exporter)
+	ctx := WithContext(context.Background(), tracer)
+
+	t.Run("Hierarchy", func(t *testing.T) {
+		exporter.spans = nil
+		opCtx, op := StartOperation(ctx, "encode")
+		chunkCtx, chunk := StartSpan(opCtx, "chunk 42")
+		_, coll := StartSpan(chunkCtx, "collection 3B5")
+		if SpanFromContext(chunkCtx) != chunk {
+			t.Errorf("Expected the chunk span in its context")
+		}
+		coll.End()
+		chunk.End()
+		chunk.End()
+		op.End()
+
+		if len(exporter.spans) != 3 || exporter.spans[0] != coll || exporter.spans[1] != chunk || exporter.spans[2] != op {
+			t.Fatalf("Expected each span exported once as it ends, got %d", len(exporter.spans))
+		}
+		if coll.Path != "encode/chunk 42/collection 3B5" || coll.Name != "collection 3B5" {
+			t.Errorf("Unexpected span path %q", coll.Path)
+		}
+		if op.Parent != "" || chunk.Parent != op.ID || coll.Parent != chunk.ID {
+			t.Errorf("Spans are not nested: %q <- %q <- %q", op.Parent, chunk.Parent, coll.Parent)
+		}
+		if len(op.Operation) != 16 || chunk.Operation != op.Operation || coll.Operation != op.Operation {
+			t.Errorf("Expected the spans to share an operation ID, got %q, %q and %q", op.Operation, chunk.Operation, coll.Operation)
+		}
+		if op.Duration() < chunk.Duration() || coll.Finish.Before(coll.Start) {
+			t.Errorf("Span times are inconsistent: %v, %v", op.Duration(), chunk.Duration())
+		}
+		if !strings.Contains(buf.String(), "TEST TRACE: Span encode/chunk 42/collection 3B5 took ") {
+			t.Errorf("Expected the span's duration to be logged, got:\n%s", buf.String())
+		}
+	})
+
+	t.Run("Correlation", func(t *testing.T) {
+		buf.Reset()
+		first, firstSpan := StartOperation(ctx, "decode")
+		second, secondSpan := StartOperation(ctx, "decode")
+		defer firstSpan.End()
+		defer secondSpan.End()
+		if firstSpan.Operation == secondSpan.Operation || secondSpan.Parent != "" {
+			t.Errorf("Expected a new operation to have its own ID and no parent")
+		}
+
+		// An operation started within another is a step of it
+		_, nested := StartOperation(first, "encode")
+		nested.End()
+		if nested.Operation != firstSpan.Operation || nested.Parent != firstSpan.ID || nested.Path != "decode/encode" {
+			t.Errorf("Expected an operation within another to be a span of it, got %+v", nested)
+		}
+
+		FromContext(first).WithPrefix("PAD").Infof("first")
+		FromContext(second).Infof("second")
+		FromContext(ctx).Infof("outside")
+		output := buf.String()
+		for _, want := range []string{"PAD: first [op " + firstSpan.Operation + "]", "TEST: second [op " + secondSpan.Operation + "]", "TEST: outside\n"} {
+			if !strings.Contains(output, want) {
+				t.Errorf("Expected output containing %q, got:\n%s", want, output)
+			}
+		}
+		if FromContext(first).Operation() != firstSpan.Operation || tracer.Operation() != "" {
+			t.Errorf("Tracers report the wrong operations")
+		}
+	})
+
+	t.Run("Without an operation", func(t *testing.T) {
+		// A span started outside any operation starts one
+		_, span := StartSpan(ctx, "decode")
+		span.End()
+		if span.Operation == "" || span.Parent != "" || span.Path != "decode" {
+			t.Errorf("Expected a root span of a new operation, got %+v", span)
+		}
+		var none *Span
+		none.End()
+		if SpanFromContext(ctx) != nil {
+			t.Errorf("Expected no span outside an operation")
+		}
+	})
+}
@@ -3,20 +3,37 @@ package trace
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 // LogLevel represents tracing verbosity level
 type LogLevel int
 
 const (
+	// LogLevelError for errors only
+	LogLevelError LogLevel = iota - 1
 	// LogLevelNormal for regular user-facing messages
-	LogLevelNormal LogLevel = iota
+	LogLevelNormal
 	// LogLevelVerbose for detailed debug/trace info (includes all trace information)
 	LogLevelVerbose
 )
 
+// ParseLogLevel converts a level name (error, normal or verbose) into a LogLevel
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "error":
+		return LogLevelError, nil
+	case "normal":
+		return LogLevelNormal, nil
+	case "verbose":
+		return LogLevelVerbose, nil
+	}
+	return LogLevelNormal, fmt.Errorf("unknown log level '%s' (expected error, normal or verbose)", name)
+}
+
 // TracerKey is the key type used for storing tracers in context
 type TracerKey struct{}
 
@@ -30,24 +47,81 @@ type Tracer struct {
 	prefix  string
 	level   LogLevel
 	verbose bool
+	op      string  // ID of the operation being logged for, if any (see StartOperation)
+	out     *output // Shared with every tracer derived from this one
+}
+
+// output is where a tracer, and the tracers derived from it with WithPrefix,
+// send their entries
+type output struct {
+	mu        sync.Mutex
+	sinks     []Sink
+	exporters []SpanExporter
+	levels    map[string]LogLevel // Levels overriding that of the tracers with these prefixes
 }
 
-// NewTracer creates a new tracer instance
+// NewTracer creates a new tracer instance, writing through the standard log package
 func NewTracer(prefix string, level LogLevel) *Tracer {
 	return &Tracer{
 		prefix:  prefix,
 		level:   level,
 		verbose: level >= LogLevelVerbose,
+		out:     &output{sinks: []Sink{StdLogSink()}, levels: make(map[string]LogLevel)},
 	}
 }
 
-// Tracef logs a message at the TRACE level (included in verbose output)
-func (t *Tracer) Tracef(format string, args ...interface{}) {
-	if !t.verbose {
+// SetSinks replaces the sinks that this tracer, and every tracer derived from
+// it, write to
+func (t *Tracer) SetSinks(sinks ...Sink) {
+	t.out.mu.Lock()
+	defer t.out.mu.Unlock()
+	t.out.sinks = sinks
+}
+
+// SetPrefixLevel sets the level of the tracers with the given prefix, among
+// this tracer and those derived from it, overriding their own
+func (t *Tracer) SetPrefixLevel(prefix string, level LogLevel) {
+	t.out.mu.Lock()
+	defer t.out.mu.Unlock()
+	t.out.levels[prefix] = level
+}
+
+// enabled reports whether entries of the given severity are logged
+func (t *Tracer) enabled(severity Severity) bool {
+	t.out.mu.Lock()
+	level, ok := t.out.levels[t.prefix]
+	t.out.mu.Unlock()
+	if !ok {
+		level = t.level
+		if t.verbose {
+			level = LogLevelVerbose
+		}
+	}
+	switch severity {
+	case SeverityTrace, SeverityDebug:
+		return level >= LogLevelVerbose
+	case SeverityInfo:
+		return level >= LogLevelNormal
+	}
+	return true
+}
+
+// emit sends an entry to every sink
+func (t *Tracer) emit(severity Severity, msg string) {
+	if !t.enabled(severity) {
 		return
 	}
-	msg := fmt.Sprintf(format, args...)
-	log.Printf("%s TRACE: %s", t.prefix, msg)
+	entry := Entry{Time: time.Now(), Severity: severity, Prefix: t.prefix, Operation: t.op, Message: msg}
+	t.out.mu.Lock()
+	defer t.out.mu.Unlock()
+	for _, sink := range t.out.sinks {
+		sink.Write(entry)
+	}
+}
+
+// Tracef logs a message at the TRACE level (included in verbose output)
+func (t *Tracer) Tracef(format string, args ...interface{}) {
+	t.emit(SeverityTrace, fmt.Sprintf(format, args...))
 }
 
 // WithContext adds the tracer to the given context
@@ -88,48 +162,39 @@ func (t *Tracer) IsVerbose() bool {
 
 // Infof logs a formatted message at normal level
 func (t *Tracer) Infof(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	if t.prefix != "" {
-		log.Printf("%s: %s", t.prefix, msg)
-	} else {
-		log.Print(msg)
-	}
+	t.emit(SeverityInfo, fmt.Sprintf(format, args...))
 }
 
 // Debugf logs a formatted message only if verbose is enabled
 func (t *Tracer) Debugf(format string, args ...interface{}) {
-	if !t.verbose {
-		return
-	}
-	msg := fmt.Sprintf(format, args...)
-	log.Printf("%s: %s", t.prefix, msg)
+	t.emit(SeverityDebug, fmt.Sprintf(format, args...))
 }
 
 // Error logs an error message
 func (t *Tracer) Error(err error) {
-	if t.prefix != "" {
-		log.Printf("%s ERROR: %v", t.prefix, err)
-	} else {
-		log.Printf("ERROR: %v", err)
-	}
+	t.emit(SeverityError, fmt.Sprint(err))
 }
 
 // Fatal logs a fatal error and exits
 func (t *Tracer) Fatal(err error) {
-	if t.prefix != "" {
-		log.Fatalf("%s FATAL: %v", t.prefix, err)
-	} else {
-		log.Fatalf("FATAL: %v", err)
-	}
-	os.Exit(1)
+	t.FatalCode(err, 1)
+}
+
+// FatalCode logs a fatal error and exits with the given status code
+func (t *Tracer) FatalCode(err error, code int) {
+	t.emit(SeverityFatal, fmt.Sprint(err))
+	os.Exit(code)
 }
 
-// WithPrefix creates a new tracer with the given prefix
+// WithPrefix creates a new tracer with the given prefix, sharing this
+// tracer's sinks, prefix levels and operation
 func (t *Tracer) WithPrefix(prefix string) *Tracer {
 	return &Tracer{
 		prefix:  prefix,
 		level:   t.level,
 		verbose: t.verbose,
+		op:      t.op,
+		out:     t.out,
 	}
 }
 
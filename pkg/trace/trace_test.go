@@ -218,3 +218,63 @@ func TestWithPrefix(t *testing.T) {
 		t.Errorf("Expected original prefix to remain 'ORIG', got '%s'", original.prefix)
 	}
 }
+
+func TestSetPrefixLevel(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer("MAIN", LogLevelNormal)
+	tracer.SetSinks(TextSink(&buf))
+	tracer.SetPrefixLevel("FILE", LogLevelVerbose)
+	tracer.SetPrefixLevel("PAD", LogLevelError)
+
+	// Children share the sinks and the per-prefix levels
+	file := tracer.WithPrefix("FILE")
+	pad := tracer.WithPrefix("PAD")
+	other := tracer.WithPrefix("OTHER")
+
+	file.Debugf("file debug")
+	pad.Infof("pad info")
+	pad.Error(errors.New("pad error"))
+	other.Debugf("other debug")
+	other.Infof("other info")
+
+	output := buf.String()
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"FILE: file debug", true},
+		{"PAD: pad info", false},
+		{"PAD ERROR: pad error", true},
+		{"OTHER: other debug", false},
+		{"OTHER: other info", true},
+	}
+	for _, tt := range tests {
+		if got := strings.Contains(output, tt.text); got != tt.want {
+			t.Errorf("Expected output containing %q to be %v, got:\n%s", tt.text, tt.want, output)
+		}
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    LogLevel
+		wantErr bool
+	}{
+		{"error", LogLevelError, false},
+		{"normal", LogLevelNormal, false},
+		{"VERBOSE", LogLevelVerbose, false},
+		{"loud", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLogLevel(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLogLevel(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseLogLevel(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}